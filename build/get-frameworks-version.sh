@@ -0,0 +1,5 @@
+#!/usr/bin/env bash
+
+# Print the constraint framework revision pinned in Gopkg.lock
+awk '/name = "github.com\/open-policy-agent\/frameworks"/ { found = 1 }
+     found && /revision = / { gsub(/"/, "", $3); print $3; exit }' Gopkg.lock
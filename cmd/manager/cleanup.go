@@ -0,0 +1,98 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+var (
+	cleanupDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gatekeeper_finalizer_cleanup_duration_seconds",
+		Help: "How long the removal of finalizers at shutdown took, by cleanup",
+	}, []string{"cleanup"})
+	cleanupObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gatekeeper_finalizer_cleanup_objects",
+		Help: "Number of objects updated by the removal of finalizers at shutdown, by cleanup",
+	}, []string{"cleanup"})
+)
+
+func init() {
+	util.AddMetrics("cleanup", cleanupDuration, cleanupObjects)
+}
+
+// cleanupFinalizers runs cleanup, one of the functions removing the finalizers of Gatekeeper at
+// shutdown, and records how long it took and how many objects it updated
+func cleanupFinalizers(name string, c client.Client, cleanup func(client.Client, chan struct{})) {
+	cc := &countingClient{Client: c, updated: make(map[string]bool)}
+	finished := make(chan struct{})
+	start := time.Now()
+	cleanup(cc, finished)
+	<-finished
+	duration := time.Since(start)
+	objects := cc.count()
+	cleanupDuration.WithLabelValues(name).Set(duration.Seconds())
+	cleanupObjects.WithLabelValues(name).Set(float64(objects))
+	logf.Log.WithName("entrypoint").Info("finalizer cleanup finished", "cleanup", name, "duration", duration.String(), "objects", objects)
+}
+
+var _ client.Client = &countingClient{}
+
+// countingClient counts the distinct objects updated through it, so that an object updated
+// again after a failed attempt is counted once
+type countingClient struct {
+	client.Client
+	mux     sync.Mutex
+	updated map[string]bool
+}
+
+func (c *countingClient) Update(ctx context.Context, obj runtime.Object) error {
+	err := c.Client.Update(ctx, obj)
+	if err == nil {
+		c.record(obj)
+	}
+	return err
+}
+
+func (c *countingClient) record(obj runtime.Object) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+	kind := obj.GetObjectKind().GroupVersionKind().GroupKind().String()
+	if kind == "" {
+		kind = fmt.Sprintf("%T", obj)
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.updated[kind+" "+accessor.GetNamespace()+"/"+accessor.GetName()] = true
+}
+
+func (c *countingClient) count() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return len(c.updated)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
+	"github.com/open-policy-agent/gatekeeper/pkg/controller/constrainttemplate"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ client.Client = &finalizedClient{}
+
+// finalizedClient stores constraint templates and constraints for the finalizer cleanup
+type finalizedClient struct {
+	client.Client
+	templates   map[string]*v1beta1.ConstraintTemplate
+	constraints map[string]*unstructured.Unstructured
+}
+
+func (c *finalizedClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	templ, ok := c.templates[key.Name]
+	if !ok {
+		return fmt.Errorf("unexpected get of %s", key)
+	}
+	templ.DeepCopyInto(obj.(*v1beta1.ConstraintTemplate))
+	return nil
+}
+
+func (c *finalizedClient) List(ctx context.Context, opts *client.ListOptions, list runtime.Object) error {
+	switch l := list.(type) {
+	case *v1beta1.ConstraintTemplateList:
+		for _, templ := range c.templates {
+			l.Items = append(l.Items, *templ.DeepCopy())
+		}
+		return nil
+	case *unstructured.UnstructuredList:
+		gvk := l.GroupVersionKind()
+		gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+		if gvk.Group != "constraints.gatekeeper.sh" {
+			return &meta.NoKindMatchError{GroupKind: gvk.GroupKind()}
+		}
+		for _, cstr := range c.constraints {
+			if cstr.GroupVersionKind() == gvk {
+				l.Items = append(l.Items, *cstr.DeepCopy())
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unexpected list of %T", list)
+}
+
+func (c *finalizedClient) Update(ctx context.Context, obj runtime.Object) error {
+	switch o := obj.(type) {
+	case *v1beta1.ConstraintTemplate:
+		c.templates[o.GetName()] = o.DeepCopy()
+		return nil
+	case *unstructured.Unstructured:
+		c.constraints[o.GetName()] = o.DeepCopy()
+		return nil
+	}
+	return fmt.Errorf("unexpected update of %T", obj)
+}
+
+func TestCleanupFinalizers(t *testing.T) {
+	c := &finalizedClient{
+		templates:   make(map[string]*v1beta1.ConstraintTemplate),
+		constraints: make(map[string]*unstructured.Unstructured),
+	}
+	templ := &v1beta1.ConstraintTemplate{ObjectMeta: metav1.ObjectMeta{
+		Name:       "k8sdenyall",
+		Finalizers: []string{"constrainttemplate.finalizers.gatekeeper.sh"},
+	}}
+	templ.Spec.CRD.Spec.Names.Kind = "K8sDenyAll"
+	c.templates[templ.GetName()] = templ
+	for i := 0; i < 500; i++ {
+		cstr := &unstructured.Unstructured{}
+		cstr.SetAPIVersion("constraints.gatekeeper.sh/v1beta1")
+		cstr.SetKind("K8sDenyAll")
+		cstr.SetName(fmt.Sprintf("deny-%d", i))
+		cstr.SetFinalizers([]string{"finalizers.gatekeeper.sh/constraint"})
+		c.constraints[cstr.GetName()] = cstr
+	}
+
+	cleanupFinalizers("constraints", c, constrainttemplate.RemoveAllFinalizers)
+
+	if f := c.templates["k8sdenyall"].GetFinalizers(); len(f) != 0 {
+		t.Errorf("template finalizers = %v; want none", f)
+	}
+	for name, cstr := range c.constraints {
+		if f := cstr.GetFinalizers(); len(f) != 0 {
+			t.Fatalf("constraint %s finalizers = %v; want none", name, f)
+		}
+	}
+	m := &dto.Metric{}
+	if err := cleanupObjects.WithLabelValues("constraints").Write(m); err != nil {
+		t.Fatalf("unable to read gatekeeper_finalizer_cleanup_objects: %s", err)
+	}
+	if got := m.GetGauge().GetValue(); got != 501 {
+		t.Errorf("gatekeeper_finalizer_cleanup_objects = %v; want 501", got)
+	}
+	m = &dto.Metric{}
+	if err := cleanupDuration.WithLabelValues("constraints").Write(m); err != nil {
+		t.Fatalf("unable to read gatekeeper_finalizer_cleanup_duration_seconds: %s", err)
+	}
+	if got := m.GetGauge().GetValue(); got <= 0 {
+		t.Errorf("gatekeeper_finalizer_cleanup_duration_seconds = %v; want a positive duration", got)
+	}
+}
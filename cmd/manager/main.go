@@ -18,38 +18,62 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"os"
 	"time"
 
+	"github.com/go-logr/logr"
 	"github.com/go-logr/zapr"
+	templatesv1beta1 "github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
 	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
 	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers/local"
 	"github.com/open-policy-agent/gatekeeper/pkg/apis"
+	configv1alpha1 "github.com/open-policy-agent/gatekeeper/pkg/apis/config/v1alpha1"
 	"github.com/open-policy-agent/gatekeeper/pkg/audit"
+	"github.com/open-policy-agent/gatekeeper/pkg/builtin"
+	"github.com/open-policy-agent/gatekeeper/pkg/bundle"
 	"github.com/open-policy-agent/gatekeeper/pkg/controller"
 	configController "github.com/open-policy-agent/gatekeeper/pkg/controller/config"
 	"github.com/open-policy-agent/gatekeeper/pkg/controller/constrainttemplate"
+	"github.com/open-policy-agent/gatekeeper/pkg/health"
+	"github.com/open-policy-agent/gatekeeper/pkg/policydir"
 	"github.com/open-policy-agent/gatekeeper/pkg/target"
 	"github.com/open-policy-agent/gatekeeper/pkg/upgrade"
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
 	"github.com/open-policy-agent/gatekeeper/pkg/watch"
 	"github.com/open-policy-agent/gatekeeper/pkg/webhook"
+	"github.com/open-policy-agent/gatekeeper/version"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	k8sCli "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 	"sigs.k8s.io/controller-runtime/pkg/runtime/signals"
 )
 
 var (
-	logLevel = flag.String("log-level", "INFO", "Minimum log level. For example, DEBUG, INFO, WARNING, ERROR. Defaulted to INFO if unspecified.")
+	logLevel            = flag.String("log-level", "INFO", "Minimum log level. For example, DEBUG, INFO, WARNING, ERROR. Defaulted to INFO if unspecified.")
+	healthAddr          = flag.String("health-addr", ":9090", "The address the health endpoints (/healthz for liveness, /readyz for readiness) bind to. Set to empty to disable. Defaulted to :9090 if unspecified.")
+	auditOnce           = flag.Bool("audit-once", false, "Run a single audit after --auditInterval seconds, once the replicated kinds are synced, write its results to constraint status and to stdout in the --audit-output format, then exit. The webhook is not served. Exits with 2 if deny violations were found, and with 1 if the replicated kinds did not sync within another --auditInterval seconds.")
+	logSampling         = flag.Bool("log-sampling", true, "Sample the logs written at the WARNING and ERROR levels, so repeated messages cannot flood the log. Set to false to write every message. Defaulted to true if unspecified.")
+	logSampleFirst      = flag.Int("log-sample-first", 100, "Number of identical messages written each second before sampling starts. Defaulted to 100 if unspecified.")
+	logSampleThereafter = flag.Int("log-sample-thereafter", 100, "Once sampling starts, write one in this many identical messages for the rest of the second. Defaulted to 100 if unspecified.")
 )
 
 func main() {
 
 	flag.Parse()
+	if err := validateLogSampling(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	switch *logLevel {
 	case "DEBUG":
 		logf.SetLogger(logf.ZapLogger(true))
@@ -61,7 +85,31 @@ func main() {
 		logf.SetLogger(logf.ZapLogger(false))
 	}
 
+	switch flag.Arg(0) {
+	case "test":
+		os.Exit(runTest(flag.Args()[1:]))
+	case "review":
+		os.Exit(runReview(flag.Args()[1:]))
+	case "snapshot":
+		os.Exit(runSnapshot(flag.Args()[1:]))
+	}
+
 	log := logf.Log.WithName("entrypoint")
+	log.Info("gatekeeper build info", "version", version.Version, "vcs", version.Vcs, "timestamp", version.Timestamp, "hostname", version.Hostname, "frameworksVersion", version.FrameworksVersion)
+	version.RecordBuildInfo()
+
+	if err := util.ValidateDefaultEnforcementAction(); err != nil {
+		log.Error(err, "invalid flags")
+		os.Exit(1)
+	}
+	if err := util.ValidateFinalizerPrefix(); err != nil {
+		log.Error(err, "invalid flags")
+		os.Exit(1)
+	}
+	if err := util.RegisterMetrics(metrics.Registry); err != nil {
+		log.Error(err, "invalid flags")
+		os.Exit(1)
+	}
 
 	// Get a config to talk to the apiserver
 	log.Info("setting up client for manager")
@@ -71,9 +119,20 @@ func main() {
 		os.Exit(1)
 	}
 
+	// The manager maps kinds using the APIs served when it is created, so the CRDs must be
+	// installed before then
+	err = util.WaitForCRDs(func() (meta.RESTMapper, error) { return apiutil.NewDiscoveryRESTMapper(cfg) }, []schema.GroupVersionKind{
+		templatesv1beta1.SchemeGroupVersion.WithKind("ConstraintTemplate"),
+		configv1alpha1.SchemeGroupVersion.WithKind("Config"),
+	})
+	if err != nil {
+		log.Error(err, "required CRDs are not installed")
+		os.Exit(1)
+	}
+
 	// Create a new Cmd to provide shared dependencies and start components
 	log.Info("setting up manager")
-	mgr, err := manager.New(cfg, manager.Options{})
+	mgr, err := manager.New(cfg, manager.Options{NewClient: util.NewManagerClient})
 	if err != nil {
 		log.Error(err, "unable to set up overall controller manager")
 		os.Exit(1)
@@ -95,43 +154,126 @@ func main() {
 		log.Error(err, "unable to set up OPA backend")
 		os.Exit(1)
 	}
-	client, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+	client, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}, &target.GenericTarget{}))
 	if err != nil {
 		log.Error(err, "unable to set up OPA client")
 	}
+	if err := builtin.Load(context.Background(), client); err != nil {
+		log.Error(err, "unable to load built-in policies")
+		os.Exit(1)
+	}
 
 	wmCtx, wmCancel := context.WithCancel(context.Background())
 	wm := watch.New(wmCtx, mgr.GetConfig())
+	// the constraints loaded into OPA by the kinds they select, for the webhook to prefilter
+	index := target.NewConstraintIndex()
 
 	// Setup all Controllers
 	log.Info("Setting up controller")
-	if err := controller.AddToManager(mgr, client, wm); err != nil {
+	if err := controller.AddToManager(mgr, client, wm, index); err != nil {
 		log.Error(err, "unable to register controllers to the manager")
 		os.Exit(1)
 	}
 
-	log.Info("setting up webhooks")
-	if err := webhook.AddToManager(mgr, client); err != nil {
-		log.Error(err, "unable to register webhooks to the manager")
-		os.Exit(1)
+	if policydir.Dir() != "" {
+		log.Info("setting up policy directory", "directory", policydir.Dir())
+		if err := mgr.Add(policydir.New(policydir.Dir(), client, mgr.GetScheme(), index)); err != nil {
+			log.Error(err, "unable to register the policy directory to the manager")
+			os.Exit(1)
+		}
 	}
 
-	log.Info("setting up audit")
-	if err := audit.AddToManager(mgr, client); err != nil {
-		log.Error(err, "unable to register audit to the manager")
-		os.Exit(1)
+	var reports <-chan *audit.Report
+	if *auditOnce {
+		log.Info("setting up single audit")
+		reports, err = audit.AddOnceToManager(mgr, client, driver)
+		if err != nil {
+			log.Error(err, "unable to register audit to the manager")
+			os.Exit(1)
+		}
+	} else {
+		log.Info("setting up webhooks")
+		if err := webhook.AddToManager(mgr, client, driver, index); err != nil {
+			log.Error(err, "unable to register webhooks to the manager")
+			os.Exit(1)
+		}
+
+		log.Info("setting up audit")
+		if err := audit.AddToManager(mgr, client, driver); err != nil {
+			log.Error(err, "unable to register audit to the manager")
+			os.Exit(1)
+		}
 	}
 
-	log.Info("setting up upgrade")
-	if err := upgrade.AddToManager(mgr); err != nil {
-		log.Error(err, "unable to register upgrade to the manager")
-		os.Exit(1)
+	if util.ReadOnly() {
+		log.Info("read-only, not upgrading stored resources")
+	} else {
+		log.Info("setting up upgrade")
+		if err := upgrade.AddToManager(mgr); err != nil {
+			log.Error(err, "unable to register upgrade to the manager")
+			os.Exit(1)
+		}
+	}
+
+	stopCh := signals.SetupSignalHandler()
+	var report *audit.Report
+	if *auditOnce {
+		// stop the manager once the audit is done
+		auditStopCh := make(chan struct{})
+		go func() {
+			defer close(auditStopCh)
+			select {
+			case <-stopCh:
+			case report = <-reports:
+			}
+		}()
+		stopCh = auditStopCh
+	}
+
+	if *healthAddr != "" {
+		log.Info("setting up health endpoints")
+		healthServer := health.New(*healthAddr)
+		if err := healthServer.LoadAuth(); err != nil {
+			log.Error(err, "invalid flags")
+			os.Exit(1)
+		}
+		cacheSynced := &health.CacheSyncCheck{}
+		if err := mgr.Add(cacheSynced); err != nil {
+			log.Error(err, "unable to register cache sync check to the manager")
+			os.Exit(1)
+		}
+		healthServer.AddReadinessCheck("cache-sync", cacheSynced.Check)
+		healthServer.AddReadinessCheck("opa", health.OpaCheck(driver))
+		if !*auditOnce {
+			healthServer.AddHandler("/debug/coverage", webhook.CoverageHandler(mgr.GetClient(), mgr.GetRESTMapper(), driver))
+			if webhook.DebugEndpointsEnabled() {
+				healthServer.AddHandler("/debug/decisions", webhook.DecisionsHandler())
+				healthServer.AddHandler("/debug/audit-progress", audit.ProgressHandler())
+			}
+			if audit.HistoryEnabled() {
+				healthServer.AddHandler("/debug/audit-history", audit.HistoryHandler())
+			}
+		}
+		if webhook.DebugEndpointsEnabled() {
+			healthServer.AddHandler("/debug/bundle", bundle.Handler(driver, webhook.RedactDump))
+			healthServer.AddHandler("/debug/match", webhook.MatchHandler(driver, mgr.GetRESTMapper()))
+		}
+		if healthServer.AuthEnabled() {
+			// so that metrics can be scraped with the same authentication as the debug endpoints
+			healthServer.AddHandler("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{ErrorHandling: promhttp.HTTPErrorOnError}))
+		}
+		go func() {
+			if err := healthServer.Start(stopCh); err != nil {
+				log.Error(err, "unable to serve health endpoints")
+				os.Exit(1)
+			}
+		}()
 	}
 
 	// Start the Cmd
 	log.Info("Starting the Cmd.")
 	hadError := false
-	if err := mgr.Start(signals.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(stopCh); err != nil {
 		log.Error(err, "unable to run the manager")
 		hadError = true
 	}
@@ -143,44 +285,81 @@ func main() {
 	// time.Sleep(5 * time.Second)
 	time.Sleep(5 * time.Second)
 
-	// Create a fresh client to be sure RESTmapper is up-to-date
-	log.Info("removing finalizers...")
-	cli, err := k8sCli.New(mgr.GetConfig(), k8sCli.Options{Scheme: nil, Mapper: nil})
-	if err != nil {
-		log.Error(err, "unable to create cleanup client")
-		os.Exit(1)
-	}
+	if util.ReadOnly() {
+		// finalizers were never added, and any present belong to another instance
+		log.Info("read-only, leaving finalizers in place")
+	} else {
+		// Create a fresh client to be sure RESTmapper is up-to-date
+		log.Info("removing finalizers...")
+		cli, err := k8sCli.New(mgr.GetConfig(), k8sCli.Options{Scheme: nil, Mapper: nil})
+		if err != nil {
+			log.Error(err, "unable to create cleanup client")
+			os.Exit(1)
+		}
 
-	// Clean up sync finalizers
-	// This logic should be disabled if OPA is run as a sidecar
-	syncCleaned := make(chan struct{})
-	go configController.RemoveAllConfigFinalizers(cli, syncCleaned)
+		// Clean up sync finalizers
+		// This logic should be disabled if OPA is run as a sidecar
+		syncCleaned := make(chan struct{})
+		go func() {
+			defer close(syncCleaned)
+			cleanupFinalizers("config", cli, configController.RemoveAllConfigFinalizers)
+		}()
 
-	// Clean up constraint finalizers
-	templatesCleaned := make(chan struct{})
-	go constrainttemplate.RemoveAllFinalizers(cli, templatesCleaned)
+		// Clean up constraint finalizers
+		templatesCleaned := make(chan struct{})
+		go func() {
+			defer close(templatesCleaned)
+			cleanupFinalizers("constraints", cli, constrainttemplate.RemoveAllFinalizers)
+		}()
 
-	<-syncCleaned
-	<-templatesCleaned
-	log.Info("finalizers removed")
+		<-syncCleaned
+		<-templatesCleaned
+		log.Info("finalizers removed")
+	}
 	if hadError {
 		os.Exit(1)
 	}
+	if *auditOnce {
+		if report != nil {
+			if err := audit.WriteReport(os.Stdout, report); err != nil {
+				log.Error(err, "unable to write audit report")
+				os.Exit(1)
+			}
+		}
+		os.Exit(audit.ExitCode(report))
+	}
 }
 
 func setLoggerForProduction() {
-	sink := zapcore.AddSync(os.Stderr)
+	logf.SetLogger(newProductionLogger(zapcore.AddSync(os.Stderr)))
+}
+
+// newProductionLogger returns a logger writing warnings and errors to sink as JSON, sampled
+// as set by --log-sampling, --log-sample-first and --log-sample-thereafter
+func newProductionLogger(sink zapcore.WriteSyncer) logr.Logger {
 	var opts []zap.Option
 	encCfg := zap.NewProductionEncoderConfig()
 	enc := zapcore.NewJSONEncoder(encCfg)
 	lvl := zap.NewAtomicLevelAt(zap.WarnLevel)
-	opts = append(opts, zap.AddStacktrace(zap.ErrorLevel),
-		zap.WrapCore(func(core zapcore.Core) zapcore.Core {
-			return zapcore.NewSampler(core, time.Second, 100, 100)
+	opts = append(opts, zap.AddStacktrace(zap.ErrorLevel))
+	if *logSampling {
+		opts = append(opts, zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return zapcore.NewSampler(core, time.Second, *logSampleFirst, *logSampleThereafter)
 		}))
+	}
 	opts = append(opts, zap.AddCallerSkip(1), zap.ErrorOutput(sink))
 	zlog := zap.New(zapcore.NewCore(&logf.KubeAwareEncoder{Encoder: enc, Verbose: false}, sink, lvl))
 	zlog = zlog.WithOptions(opts...)
-	newlogger := zapr.NewLogger(zlog)
-	logf.SetLogger(newlogger)
+	return zapr.NewLogger(zlog)
+}
+
+// validateLogSampling returns an error if the sampling flags cannot configure a sampler
+func validateLogSampling() error {
+	if *logSampleFirst < 0 {
+		return fmt.Errorf("invalid --log-sample-first %d, must not be negative", *logSampleFirst)
+	}
+	if *logSampleThereafter < 1 {
+		return fmt.Errorf("invalid --log-sample-thereafter %d, must be at least 1", *logSampleThereafter)
+	}
+	return nil
 }
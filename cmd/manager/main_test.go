@@ -0,0 +1,76 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestProductionLoggerSampling(t *testing.T) {
+	defer flag.Set("log-sampling", "true")
+	defer flag.Set("log-sample-first", "100")
+	defer flag.Set("log-sample-thereafter", "100")
+	tc := []struct {
+		Name       string
+		Sampling   string
+		First      string
+		Thereafter string
+		Written    int
+	}{
+		{Name: "defaults", Sampling: "true", First: "100", Thereafter: "100", Written: 10},
+		// the 1st and 2nd messages, then every 3rd: the 5th and 8th
+		{Name: "sampled", Sampling: "true", First: "2", Thereafter: "3", Written: 4},
+		{Name: "disabled", Sampling: "false", First: "2", Thereafter: "3", Written: 10},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			flag.Set("log-sampling", tt.Sampling)
+			flag.Set("log-sample-first", tt.First)
+			flag.Set("log-sample-thereafter", tt.Thereafter)
+			if err := validateLogSampling(); err != nil {
+				t.Fatalf("validateLogSampling() err = %s", err)
+			}
+			out := &bytes.Buffer{}
+			log := newProductionLogger(zapcore.AddSync(out))
+			for i := 0; i < 10; i++ {
+				log.Error(errors.New("boom"), "the same message")
+			}
+			if written := strings.Count(out.String(), "the same message"); written != tt.Written {
+				t.Errorf("%d messages written; want %d", written, tt.Written)
+			}
+		})
+	}
+}
+
+func TestValidateLogSampling(t *testing.T) {
+	defer flag.Set("log-sample-first", "100")
+	defer flag.Set("log-sample-thereafter", "100")
+	flag.Set("log-sample-thereafter", "0")
+	if err := validateLogSampling(); err == nil {
+		t.Error("validateLogSampling() err = nil with --log-sample-thereafter=0; want an error")
+	}
+	flag.Set("log-sample-thereafter", "100")
+	flag.Set("log-sample-first", "-1")
+	if err := validateLogSampling(); err == nil {
+		t.Error("validateLogSampling() err = nil with --log-sample-first=-1; want an error")
+	}
+}
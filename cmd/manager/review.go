@@ -0,0 +1,92 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/open-policy-agent/gatekeeper/pkg/simulate"
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// runReview reviews a JSON or YAML document that is not a Kubernetes object, such as a
+// Terraform plan, against the constraints of the generic target. It returns 0 if the document
+// passes, 2 if it is denied, or 1 if it could not be reviewed.
+func runReview(args []string) int {
+	fs := flag.NewFlagSet("review", flag.ExitOnError)
+	filename := fs.String("f", "", "the document to review, or - to read it from stdin")
+	kind := fs.String("kind", "", "the kind of the document, matched by the spec.match.kinds of constraints")
+	name := fs.String("name", "", "the name the document is reported by, defaulted to the name of its file if unspecified")
+	// the flags of the manager, such as --kubeconfig, also apply
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s review -f <document> [-kind <kind>]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *filename == "" {
+		fs.Usage()
+		return 1
+	}
+	if err := util.ValidateDefaultEnforcementAction(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *name == "" {
+		*name = *filename
+	}
+
+	var in io.Reader = os.Stdin
+	if *filename != "-" {
+		f, err := os.Open(*filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+	var doc interface{}
+	if err := yaml.NewYAMLOrJSONDecoder(in, 4096).Decode(&doc); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to decode %s: %s\n", *filename, err)
+		return 1
+	}
+
+	ctx := context.Background()
+	client, err := loadPolicies(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	r, err := simulate.ReviewDocument(ctx, client, *kind, *name, doc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to review %s: %s\n", *name, err)
+		return 1
+	}
+	results := []*simulate.Result{r}
+	if err := simulate.WriteResults(os.Stdout, results); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return simulate.ExitCode(results)
+}
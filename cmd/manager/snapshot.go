@@ -0,0 +1,69 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/open-policy-agent/gatekeeper/pkg/snapshot"
+	k8sCli "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+// runSnapshot writes the Config, templates and constraints of the cluster to stdout as a single
+// manifest, from which they can be applied to another cluster. It returns 0 if the snapshot was
+// written, or 1 otherwise.
+func runSnapshot(args []string) int {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	output := fs.String("o", "yaml", "the format of the snapshot, yaml or json")
+	// the flags of the manager, such as --kubeconfig, also apply
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s snapshot [-o yaml|json]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *output != "yaml" && *output != "json" {
+		fs.Usage()
+		return 1
+	}
+
+	cfg, err := config.GetConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to set up client config: %s\n", err)
+		return 1
+	}
+	c, err := k8sCli.New(cfg, k8sCli.Options{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to set up client: %s\n", err)
+		return 1
+	}
+	objs, err := snapshot.Export(context.Background(), c)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := snapshot.Write(os.Stdout, objs, *output); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,147 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers/local"
+	"github.com/open-policy-agent/gatekeeper/pkg/apis"
+	"github.com/open-policy-agent/gatekeeper/pkg/builtin"
+	"github.com/open-policy-agent/gatekeeper/pkg/policydir"
+	"github.com/open-policy-agent/gatekeeper/pkg/simulate"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sCli "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+// runTest reviews the objects of a manifest against the constraints of the cluster, as the
+// webhook would review their creation, without submitting them. It returns 0 if they would
+// all be admitted, 2 if some would be denied, or 1 if they could not be reviewed.
+func runTest(args []string) int {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	filename := fs.String("f", "", "the manifest of the objects to review, or - to read it from stdin")
+	// the flags of the manager, such as --kubeconfig, also apply
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s test -f <manifest>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *filename == "" {
+		fs.Usage()
+		return 1
+	}
+	if err := util.ValidateDefaultEnforcementAction(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var in io.Reader = os.Stdin
+	if *filename != "-" {
+		f, err := os.Open(*filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+	objs, err := simulate.ReadObjects(in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx := context.Background()
+	client, err := loadPolicies(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var results []*simulate.Result
+	for _, obj := range objs {
+		r, err := simulate.Review(ctx, client, obj)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to review %s %s: %s\n", obj.GetKind(), obj.GetName(), err)
+			return 1
+		}
+		results = append(results, r)
+	}
+	if err := simulate.WriteResults(os.Stdout, results); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return simulate.ExitCode(results)
+}
+
+// loadPolicies returns an OPA client holding the built-in policies and the templates and
+// constraints of the cluster, or of --policy-dir if set. The templates and constraints that
+// could not be loaded are listed on stderr.
+func loadPolicies(ctx context.Context) (*opa.Client, error) {
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		return nil, fmt.Errorf("unable to set up scheme: %s", err)
+	}
+	backend, err := opa.NewBackend(opa.Driver(local.New(local.Tracing(false))))
+	if err != nil {
+		return nil, fmt.Errorf("unable to set up OPA backend: %s", err)
+	}
+	client, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}, &target.GenericTarget{}))
+	if err != nil {
+		return nil, fmt.Errorf("unable to set up OPA client: %s", err)
+	}
+	if err := builtin.Load(ctx, client); err != nil {
+		return nil, err
+	}
+	// with --policy-dir, the policies of the directory are reviewed against in place of those
+	// of the cluster, which is then not needed
+	var skipped []string
+	if policydir.Dir() != "" {
+		skipped, err = policydir.New(policydir.Dir(), client, scheme, nil).Load(ctx)
+	} else {
+		skipped, err = loadCluster(ctx, scheme, client)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range skipped {
+		fmt.Fprintf(os.Stderr, "skipped %s\n", s)
+	}
+	return client, nil
+}
+
+// loadCluster loads the templates and constraints of the cluster into client
+func loadCluster(ctx context.Context, scheme *runtime.Scheme, client *opa.Client) ([]string, error) {
+	cfg, err := config.GetConfig()
+	if err != nil {
+		return nil, fmt.Errorf("unable to set up client config: %s", err)
+	}
+	c, err := k8sCli.New(cfg, k8sCli.Options{Scheme: scheme})
+	if err != nil {
+		return nil, fmt.Errorf("unable to set up client: %s", err)
+	}
+	return simulate.Load(ctx, c, scheme, client)
+}
@@ -16,14 +16,68 @@ import (
 	"context"
 
 	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers"
+	configv1alpha1 "github.com/open-policy-agent/gatekeeper/pkg/apis/config/v1alpha1"
+	syncc "github.com/open-policy-agent/gatekeeper/pkg/controller/sync"
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
-// AddToManager adds audit manager to the Manager
-func AddToManager(m manager.Manager, opa *opa.Client) error {
-	am, err := New(context.Background(), m.GetConfig(), opa)
+// AddToManager adds audit manager to the Manager. With --audit-incremental-clear, the
+// violations of synced resources are also cleared from constraint status as they are deleted.
+// Annotating the Config with AuditNowAnnotation runs an audit immediately. Neither is
+// available with --read-only, as both write to the API server. With --audit-history-size,
+// the violation counts of recent audits are served by HistoryHandler, and with
+// --audit-export-url their violations are sent to a central service.
+func AddToManager(m manager.Manager, opa *opa.Client, driver drivers.Driver) error {
+	am, err := New(context.Background(), m.GetConfig(), opa, driver)
 	if err != nil {
 		return err
 	}
+	if HistoryEnabled() {
+		am.history = newAuditHistory(*auditHistorySize)
+		history = am.history
+	}
+	exporter, err := newViolationExporter(*auditExportURL, *clusterName)
+	if err != nil {
+		return err
+	}
+	if exporter != nil {
+		am.exporter = exporter
+		if err := m.Add(exporter); err != nil {
+			return err
+		}
+	}
+	if util.ReadOnly() {
+		return m.Add(am)
+	}
+	if *auditIncrementalClear {
+		syncc.OnDataRemoved(am.clearViolations)
+	}
+	informer, err := m.GetCache().GetInformer(&configv1alpha1.Config{})
+	if err != nil {
+		return err
+	}
+	informer.AddEventHandler(am.configEventHandler(m.GetClient()))
 	return m.Add(am)
 }
+
+// AddOnceToManager adds an audit manager that audits only once to the Manager. The report
+// of the audit is sent on the returned channel once the results have been written to
+// constraint status, after which the channel is closed. The channel is closed without a
+// report if the audit fails, or if the kinds replicated into OPA are not synced in time.
+func AddOnceToManager(m manager.Manager, opa *opa.Client, driver drivers.Driver) (<-chan *Report, error) {
+	am, err := New(context.Background(), m.GetConfig(), opa, driver)
+	if err != nil {
+		return nil, err
+	}
+	am.reports = make(chan *Report, 1)
+	am.unsynced = syncc.Unsynced
+	// the single audit covers every kind after --auditInterval
+	am.schedule = nil
+	am.kindResults = nil
+	if err := m.Add(am); err != nil {
+		return nil, err
+	}
+	return am.reports, nil
+}
@@ -0,0 +1,35 @@
+package audit
+
+import (
+	constraintTypes "github.com/open-policy-agent/frameworks/constraint/pkg/types"
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// logDebugViolations logs each of results whose constraint sets spec.debug in full, without the
+// msgSize and violation limits of constraint status. Traces are not collected, as audit evaluates
+// every constraint at once.
+func logDebugViolations(results []*constraintTypes.Result) {
+	for _, r := range results {
+		if r.Constraint == nil || !util.Debug(r.Constraint) {
+			continue
+		}
+		kv := []interface{}{
+			"constraint_kind", r.Constraint.GetKind(),
+			"constraint_name", r.Constraint.GetName(),
+			"message", r.Msg,
+		}
+		if resource, ok := r.Resource.(*unstructured.Unstructured); ok {
+			kv = append(kv,
+				"resource_api_version", resource.GetAPIVersion(),
+				"resource_kind", resource.GetKind(),
+				"resource_namespace", resource.GetNamespace(),
+				"resource_name", resource.GetName(),
+			)
+		}
+		if details, ok := r.Metadata["details"]; ok {
+			kv = append(kv, "details", details)
+		}
+		log.Info("debug constraint violation", kv...)
+	}
+}
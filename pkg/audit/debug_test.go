@@ -0,0 +1,63 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// recordingLogger records the key/value pairs of every Info call
+type recordingLogger struct {
+	entries *[]map[string]interface{}
+}
+
+func (l recordingLogger) Info(msg string, keysAndValues ...interface{}) {
+	entry := map[string]interface{}{"msg": msg}
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		entry[fmt.Sprint(keysAndValues[i])] = keysAndValues[i+1]
+	}
+	*l.entries = append(*l.entries, entry)
+}
+
+func (l recordingLogger) Enabled() bool                                             { return true }
+func (l recordingLogger) Error(err error, msg string, keysAndValues ...interface{}) {}
+func (l recordingLogger) V(level int) logr.InfoLogger                               { return l }
+func (l recordingLogger) WithValues(keysAndValues ...interface{}) logr.Logger       { return l }
+func (l recordingLogger) WithName(name string) logr.Logger                          { return l }
+
+func TestAuditDebugConstraint(t *testing.T) {
+	origLog := log
+	defer func() { log = origLog }()
+	var entries []map[string]interface{}
+	log = recordingLogger{entries: &entries}
+
+	c, driver := makeOpaClient(t)
+	addTemplate(t, c, always_violate_template)
+	addConstraint(t, c, pods_in_foo)
+	addConstraint(t, c, strings.Replace(services_anywhere, "spec:\n", "spec:\n  debug: true\n", 1))
+	addObject(t, c, "Pod", "foo", "a")
+	addObject(t, c, "Service", "foo", "s")
+	addObject(t, c, "Service", "bar", "t")
+	am := &AuditManager{opa: c, driver: driver, selector: labels.Everything()}
+	if _, _, _, _, err := am.evaluate(context.Background(), fullAudit, "2020-01-01T00:00:00Z"); err != nil {
+		t.Fatalf("evaluate() err = %s", err)
+	}
+
+	var logged []string
+	for _, e := range entries {
+		if e["msg"] != "debug constraint violation" {
+			continue
+		}
+		if e["constraint_name"] != "services-anywhere" || e["message"] == "" {
+			t.Errorf("debug entry = %v; want one of services-anywhere with its message", e)
+		}
+		logged = append(logged, fmt.Sprintf("%s %s/%s", e["resource_kind"], e["resource_namespace"], e["resource_name"]))
+	}
+	if len(logged) != 2 {
+		t.Errorf("debug violations logged = %v; want the two services only", logged)
+	}
+}
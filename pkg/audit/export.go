@@ -0,0 +1,176 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+var (
+	auditExportURL = flag.String("audit-export-url", "", "http or https URL of a central service that the violations found by every audit are POSTed to, as JSON arrays tagged with --cluster-name, in the background. violations are not exported if unspecified")
+	clusterName    = flag.String("cluster-name", "", "name of the cluster attached to the violations exported with --audit-export-url, so that those of a fleet of clusters can be told apart. required with --audit-export-url")
+)
+
+const (
+	// exportBufferSize is the number of violations waiting to be exported, past which the
+	// violations of new audits are dropped rather than slowing down audit
+	exportBufferSize = 10000
+	// exportBatchSize is the maximum number of violations sent in a single request
+	exportBatchSize = 500
+	// exportAttempts is the number of times a batch is sent before it is dropped
+	exportAttempts = 3
+)
+
+// exportBackoff is the time to wait before sending a batch again, doubled after each attempt
+var exportBackoff = time.Second
+
+// ExportedViolation is a violation found by audit as sent to a central service
+type ExportedViolation struct {
+	// Cluster is the --cluster-name of the cluster audited
+	Cluster string `json:"cluster"`
+	// Timestamp is the time the audit started
+	Timestamp           string `json:"timestamp"`
+	ConstraintKind      string `json:"constraintKind"`
+	ConstraintName      string `json:"constraintName"`
+	ConstraintNamespace string `json:"constraintNamespace,omitempty"`
+	// the violating resource is always identified by its apiVersion, kind, namespace and
+	// name, whatever the --audit-id-format
+	APIVersion        string `json:"apiVersion"`
+	Kind              string `json:"kind"`
+	Name              string `json:"name"`
+	Namespace         string `json:"namespace,omitempty"`
+	Message           string `json:"message"`
+	Code              string `json:"code,omitempty"`
+	EnforcementAction string `json:"enforcementAction"`
+}
+
+var _ manager.Runnable = &violationExporter{}
+
+// violationExporter posts the violations of each audit to a central service in the
+// background, in batches of the violations waiting to be sent
+type violationExporter struct {
+	url        string
+	cluster    string
+	client     *http.Client
+	violations chan *ExportedViolation
+}
+
+// newViolationExporter returns the exporter set by --audit-export-url, or nil if violations
+// are not exported
+func newViolationExporter(url, cluster string) (*violationExporter, error) {
+	if url == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("invalid --audit-export-url %q, must be an http or https URL", url)
+	}
+	if cluster == "" {
+		return nil, fmt.Errorf("--cluster-name is required with --audit-export-url")
+	}
+	return &violationExporter{
+		url:        url,
+		cluster:    cluster,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		violations: make(chan *ExportedViolation, exportBufferSize),
+	}, nil
+}
+
+// export queues the violations of report, dropping those that do not fit in the buffer. A nil
+// violationExporter exports nothing.
+func (e *violationExporter) export(report *Report) {
+	if e == nil || report == nil {
+		return
+	}
+	dropped := 0
+	for _, cr := range report.Constraints {
+		for _, v := range cr.Violations {
+			select {
+			case e.violations <- &ExportedViolation{
+				Cluster:             e.cluster,
+				Timestamp:           report.Timestamp,
+				ConstraintKind:      cr.Kind,
+				ConstraintName:      cr.Name,
+				ConstraintNamespace: cr.Namespace,
+				APIVersion:          v.APIVersion,
+				Kind:                v.Kind,
+				Name:                v.Name,
+				Namespace:           v.Namespace,
+				Message:             v.Message,
+				Code:                v.Code,
+				EnforcementAction:   v.EnforcementAction,
+			}:
+			default:
+				dropped++
+			}
+		}
+	}
+	if dropped > 0 {
+		exportDroppedTotal.Add(float64(dropped))
+		log.Error(fmt.Errorf("%d violations waiting to be exported", exportBufferSize), "export buffer full, dropping violations", "dropped", dropped)
+	}
+}
+
+// Start posts the violations until stop is closed
+func (e *violationExporter) Start(stop <-chan struct{}) error {
+	for {
+		select {
+		case <-stop:
+			return nil
+		case v := <-e.violations:
+			batch := []*ExportedViolation{v}
+		drain:
+			for len(batch) < exportBatchSize {
+				select {
+				case v := <-e.violations:
+					batch = append(batch, v)
+				default:
+					break drain
+				}
+			}
+			if err := e.send(batch, stop); err != nil {
+				exportDroppedTotal.Add(float64(len(batch)))
+				log.Error(err, "unable to export violations", "url", e.url, "violations", len(batch))
+			}
+		}
+	}
+}
+
+// send posts batch up to exportAttempts times, backing off between attempts. It gives up
+// early if stop is closed.
+func (e *violationExporter) send(batch []*ExportedViolation, stop <-chan struct{}) error {
+	backoff := exportBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = e.post(batch); err == nil || attempt == exportAttempts {
+			return err
+		}
+		select {
+		case <-stop:
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (e *violationExporter) post(batch []*ExportedViolation) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("violation export service answered %s", resp.Status)
+	}
+	return nil
+}
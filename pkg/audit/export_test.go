@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// exportReport returns the report of an audit finding n violations of a single constraint
+func exportReport(n int) *Report {
+	cr := ConstraintReport{Kind: "K8sRequiredLabels", Name: "must-have-owner", TotalViolations: int64(n)}
+	for i := 0; i < n; i++ {
+		cr.Violations = append(cr.Violations, StatusViolation{Kind: "Namespace", Name: fmt.Sprintf("ns-%d", i), Message: "missing label owner", EnforcementAction: "deny"})
+	}
+	return &Report{Timestamp: "2020-01-01T00:00:00Z", TotalViolations: int64(n), Constraints: []ConstraintReport{cr}}
+}
+
+func TestViolationExporter(t *testing.T) {
+	defer func(b time.Duration) { exportBackoff = b }(exportBackoff)
+	exportBackoff = time.Millisecond
+
+	var mux sync.Mutex
+	var batches [][]ExportedViolation
+	failures := 1
+	received := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		// the service is briefly unavailable
+		if failures > 0 {
+			failures--
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var batch []ExportedViolation
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("invalid batch: %s", err)
+		}
+		batches = append(batches, batch)
+		received <- struct{}{}
+	}))
+	defer server.Close()
+
+	exporter, err := newViolationExporter(server.URL, "prod-east")
+	if err != nil {
+		t.Fatalf("newViolationExporter() err = %s", err)
+	}
+	// queued before the exporter starts, so that they are sent in full batches
+	exporter.export(exportReport(2*exportBatchSize + 10))
+	stop := make(chan struct{})
+	defer close(stop)
+	go exporter.Start(stop)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-received:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("received %d batches; want 3", i)
+		}
+	}
+	mux.Lock()
+	defer mux.Unlock()
+	sizes := []int{}
+	seen := make(map[string]bool)
+	for _, batch := range batches {
+		sizes = append(sizes, len(batch))
+		for _, v := range batch {
+			if v.Cluster != "prod-east" {
+				t.Errorf("violation of %s exported with cluster %q; want prod-east", v.Name, v.Cluster)
+			}
+			if v.ConstraintKind != "K8sRequiredLabels" || v.ConstraintName != "must-have-owner" || v.Timestamp != "2020-01-01T00:00:00Z" {
+				t.Errorf("violation %+v does not name its constraint and audit", v)
+			}
+			seen[v.Name] = true
+		}
+	}
+	if fmt.Sprint(sizes) != fmt.Sprint([]int{exportBatchSize, exportBatchSize, 10}) {
+		t.Errorf("batch sizes = %v; want %d, %d, 10", sizes, exportBatchSize, exportBatchSize)
+	}
+	if len(seen) != 2*exportBatchSize+10 {
+		t.Errorf("%d distinct violations exported; want %d", len(seen), 2*exportBatchSize+10)
+	}
+}
+
+func TestViolationExporterDoesNotBlock(t *testing.T) {
+	exporter := &violationExporter{cluster: "prod-east", violations: make(chan *ExportedViolation, 5)}
+	done := make(chan struct{})
+	go func() {
+		exporter.export(exportReport(20))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("export() blocked on a full buffer")
+	}
+	if len(exporter.violations) != 5 {
+		t.Errorf("%d violations queued; want the buffer of 5 full", len(exporter.violations))
+	}
+	var nilExporter *violationExporter
+	nilExporter.export(exportReport(1))
+}
+
+func TestNewViolationExporter(t *testing.T) {
+	if e, err := newViolationExporter("", ""); e != nil || err != nil {
+		t.Errorf("newViolationExporter() = %v, %v without a URL; want nil", e, err)
+	}
+	if _, err := newViolationExporter("kafka://broker:9092", "prod-east"); err == nil {
+		t.Error("newViolationExporter() succeeded with a kafka URL; want error")
+	}
+	if _, err := newViolationExporter("https://violations.example.com", ""); err == nil {
+		t.Error("newViolationExporter() succeeded without --cluster-name; want error")
+	}
+}
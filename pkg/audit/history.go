@@ -0,0 +1,136 @@
+package audit
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+var auditHistorySize = flag.Int("audit-history-size", 0, "number of recent audits whose violation counts are kept in memory and served as JSON on /debug/audit-history of --health-addr, so that violations can be seen trending up or down. audit history is not kept if unspecified or 0")
+
+// history holds the summaries of the recent audits, served by HistoryHandler. It is nil unless
+// --audit-history-size is set.
+var history *auditHistory
+
+// HistoryEnabled returns true if the summaries of recent audits are kept
+func HistoryEnabled() bool {
+	return *auditHistorySize > 0
+}
+
+// AuditSummary counts the violations found by an audit
+type AuditSummary struct {
+	Timestamp       string `json:"timestamp"`
+	TotalViolations int64  `json:"totalViolations"`
+	DenyViolations  int64  `json:"denyViolations"`
+	// Constraints counts the violations of each constraint. Constraints without violations are
+	// left out.
+	Constraints []ConstraintSummary `json:"constraints"`
+}
+
+// ConstraintSummary counts the violations of one constraint found by an audit
+type ConstraintSummary struct {
+	Kind            string `json:"kind"`
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace,omitempty"`
+	TotalViolations int64  `json:"totalViolations"`
+}
+
+// auditHistory is a bounded, thread-safe ring buffer of the summaries of the last audits
+type auditHistory struct {
+	mux       sync.Mutex
+	summaries []AuditSummary
+	// next is the index of the summary overwritten next once the buffer is full
+	next int
+}
+
+func newAuditHistory(size int) *auditHistory {
+	return &auditHistory{summaries: make([]AuditSummary, 0, size)}
+}
+
+// add records the summary of report, replacing the oldest one if the buffer is full. A nil
+// auditHistory records nothing.
+func (h *auditHistory) add(report *Report) {
+	if h == nil || report == nil {
+		return
+	}
+	summary := summarize(report)
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if len(h.summaries) < cap(h.summaries) {
+		h.summaries = append(h.summaries, summary)
+		return
+	}
+	h.summaries[h.next] = summary
+	h.next = (h.next + 1) % len(h.summaries)
+}
+
+// summarize counts the violations of each constraint in report
+func summarize(report *Report) AuditSummary {
+	summary := AuditSummary{
+		Timestamp:       report.Timestamp,
+		TotalViolations: report.TotalViolations,
+		DenyViolations:  report.DenyViolations,
+		Constraints:     []ConstraintSummary{},
+	}
+	for _, cr := range report.Constraints {
+		summary.Constraints = append(summary.Constraints, ConstraintSummary{
+			Kind:            cr.Kind,
+			Name:            cr.Name,
+			Namespace:       cr.Namespace,
+			TotalViolations: cr.TotalViolations,
+		})
+	}
+	return summary
+}
+
+// list returns the recorded summaries, oldest first
+func (h *auditHistory) list() []AuditSummary {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	out := make([]AuditSummary, 0, len(h.summaries))
+	out = append(out, h.summaries[h.next:]...)
+	return append(out, h.summaries[:h.next]...)
+}
+
+// HistoryHandler serves the summaries of the audits recorded with --audit-history-size as JSON,
+// oldest first. The limit query parameter restricts them to the most recent ones, and the kind
+// and name query parameters restrict their constraints to the matching ones, e.g. to follow
+// the violations of a single constraint.
+func HistoryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		summaries := []AuditSummary{}
+		if history != nil {
+			summaries = history.list()
+		}
+		query := r.URL.Query()
+		if l := query.Get("limit"); l != "" {
+			limit, err := strconv.Atoi(l)
+			if err != nil || limit < 0 {
+				http.Error(w, fmt.Sprintf("invalid limit %q", l), http.StatusBadRequest)
+				return
+			}
+			if limit < len(summaries) {
+				summaries = summaries[len(summaries)-limit:]
+			}
+		}
+		kind, name := query.Get("kind"), query.Get("name")
+		if kind != "" || name != "" {
+			for i := range summaries {
+				constraints := []ConstraintSummary{}
+				for _, c := range summaries[i].Constraints {
+					if (kind == "" || c.Kind == kind) && (name == "" || c.Name == name) {
+						constraints = append(constraints, c)
+					}
+				}
+				summaries[i].Constraints = constraints
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(summaries); err != nil {
+			log.Error(err, "unable to write audit history")
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAuditHistory(t *testing.T) {
+	defer func() { history = nil }()
+	history = newAuditHistory(3)
+	// five audits of two constraints, the violations of one trending down
+	for i := 0; i < 5; i++ {
+		history.add(&Report{
+			Timestamp:       fmt.Sprintf("2020-01-01T00:0%d:00Z", i),
+			TotalViolations: int64(10 - i + 1),
+			DenyViolations:  int64(10 - i),
+			Constraints: []ConstraintReport{
+				{Kind: "K8sRequiredLabels", Name: "must-have-owner", TotalViolations: int64(10 - i)},
+				{Kind: "K8sAllowedRepos", Name: "trusted-repos", TotalViolations: 1},
+			},
+		})
+	}
+
+	query := func(url string) []AuditSummary {
+		rec := httptest.NewRecorder()
+		HistoryHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d: %s", url, rec.Code, rec.Body.String())
+		}
+		var summaries []AuditSummary
+		if err := json.Unmarshal(rec.Body.Bytes(), &summaries); err != nil {
+			t.Fatalf("GET %s: invalid JSON: %s", url, err)
+		}
+		return summaries
+	}
+	violations := func(summaries []AuditSummary) []int64 {
+		var counts []int64
+		for _, s := range summaries {
+			for _, c := range s.Constraints {
+				counts = append(counts, c.TotalViolations)
+			}
+		}
+		return counts
+	}
+
+	all := query("/debug/audit-history")
+	var timestamps []string
+	for _, s := range all {
+		timestamps = append(timestamps, s.Timestamp)
+	}
+	if diff := cmp.Diff([]string{"2020-01-01T00:02:00Z", "2020-01-01T00:03:00Z", "2020-01-01T00:04:00Z"}, timestamps); diff != "" {
+		t.Errorf("history is not the last 3 audits, oldest first: %s", diff)
+	}
+	if all[2].DenyViolations != 6 || all[2].TotalViolations != 7 {
+		t.Errorf("last audit = %+v; want 7 violations, 6 of them deny", all[2])
+	}
+
+	trend := violations(query("/debug/audit-history?kind=K8sRequiredLabels&name=must-have-owner"))
+	if diff := cmp.Diff([]int64{8, 7, 6}, trend); diff != "" {
+		t.Errorf("violations of must-have-owner: %s", diff)
+	}
+	if got := violations(query("/debug/audit-history?limit=1&name=trusted-repos")); !cmp.Equal(got, []int64{1}) {
+		t.Errorf("last violations of trusted-repos = %v; want [1]", got)
+	}
+	// filtering the response leaves the history untouched
+	if got := len(query("/debug/audit-history")[0].Constraints); got != 2 {
+		t.Errorf("first audit has %d constraints after filtering; want 2", got)
+	}
+
+	rec := httptest.NewRecorder()
+	HistoryHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/audit-history?limit=-1", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("negative limit: status %d; want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,113 @@
+package audit
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var auditIDFormat = flag.String("audit-id-format", "fields", "how the violating resource of a violation is identified in constraint status and the --audit-once report, one of fields, apiversion, path or object. fields writes kind, namespace and name, apiversion adds apiVersion, path writes group/version/kind/namespace/name as id and object writes them as the fields of resource. defaulted to fields if unspecified ")
+
+// validateIDFormat returns an error if format is not a supported --audit-id-format
+func validateIDFormat(format string) error {
+	switch format {
+	case "fields", "apiversion", "path", "object":
+		return nil
+	}
+	return fmt.Errorf("unknown format %q, must be fields, apiversion, path or object", format)
+}
+
+// ResourceID identifies the violating resource of a violation for --audit-id-format=object
+type ResourceID struct {
+	Group     string `json:"group"`
+	Version   string `json:"version"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// resourceID returns the identifier of the violating resource of v
+func (v StatusViolation) resourceID() ResourceID {
+	gv, _ := schema.ParseGroupVersion(v.APIVersion)
+	return ResourceID{Group: gv.Group, Version: gv.Version, Kind: v.Kind, Namespace: v.Namespace, Name: v.Name}
+}
+
+// resourcePath returns the identifier of the violating resource of v as
+// group/version/kind/namespace/name, with core for the core group and an empty namespace for
+// cluster-scoped resources, so that the path always has five segments
+func (v StatusViolation) resourcePath() string {
+	id := v.resourceID()
+	group := id.Group
+	if group == "" {
+		group = "core"
+	}
+	return strings.Join([]string{group, id.Version, id.Kind, id.Namespace, id.Name}, "/")
+}
+
+// violationResourceID decodes the identifier of the violating resource of v, a violation read
+// from constraint status, as MarshalJSON writes it with format. Group and Version are only set
+// by the formats that record them.
+func violationResourceID(v map[string]interface{}, format string) ResourceID {
+	str := func(m map[string]interface{}, key string) string {
+		s, _ := m[key].(string)
+		return s
+	}
+	switch format {
+	case "path":
+		segments := strings.Split(str(v, "id"), "/")
+		if len(segments) != 5 {
+			return ResourceID{}
+		}
+		group := segments[0]
+		if group == "core" {
+			group = ""
+		}
+		return ResourceID{Group: group, Version: segments[1], Kind: segments[2], Namespace: segments[3], Name: segments[4]}
+	case "object":
+		resource, _ := v["resource"].(map[string]interface{})
+		return ResourceID{Group: str(resource, "group"), Version: str(resource, "version"), Kind: str(resource, "kind"), Namespace: str(resource, "namespace"), Name: str(resource, "name")}
+	case "apiversion":
+		gv, _ := schema.ParseGroupVersion(str(v, "apiVersion"))
+		return ResourceID{Group: gv.Group, Version: gv.Version, Kind: str(v, "kind"), Namespace: str(v, "namespace"), Name: str(v, "name")}
+	}
+	return ResourceID{Kind: str(v, "kind"), Namespace: str(v, "namespace"), Name: str(v, "name")}
+}
+
+// MarshalJSON writes v with its violating resource identified as set by --audit-id-format
+func (v StatusViolation) MarshalJSON() ([]byte, error) {
+	type details struct {
+		Message           string `json:"message"`
+		Code              string `json:"code,omitempty"`
+		EnforcementAction string `json:"enforcementAction"`
+	}
+	d := details{Message: v.Message, Code: v.Code, EnforcementAction: v.EnforcementAction}
+	switch *auditIDFormat {
+	case "apiversion":
+		return json.Marshal(struct {
+			APIVersion string `json:"apiVersion"`
+			Kind       string `json:"kind"`
+			Name       string `json:"name"`
+			Namespace  string `json:"namespace,omitempty"`
+			details
+		}{APIVersion: v.APIVersion, Kind: v.Kind, Name: v.Name, Namespace: v.Namespace, details: d})
+	case "path":
+		return json.Marshal(struct {
+			ID string `json:"id"`
+			details
+		}{ID: v.resourcePath(), details: d})
+	case "object":
+		return json.Marshal(struct {
+			Resource ResourceID `json:"resource"`
+			details
+		}{Resource: v.resourceID(), details: d})
+	}
+	return json.Marshal(struct {
+		Kind      string `json:"kind"`
+		Name      string `json:"name"`
+		Namespace string `json:"namespace,omitempty"`
+		details
+	}{Kind: v.Kind, Name: v.Name, Namespace: v.Namespace, details: d})
+}
@@ -0,0 +1,85 @@
+package audit
+
+import (
+	"encoding/json"
+	"flag"
+	"testing"
+)
+
+func TestAuditIDFormat(t *testing.T) {
+	defer flag.Set("audit-id-format", "fields")
+	namespaced := StatusViolation{
+		APIVersion:        "apps/v1",
+		Kind:              "Deployment",
+		Name:              "nginx",
+		Namespace:         "web",
+		Message:           "you must provide labels",
+		EnforcementAction: "deny",
+	}
+	clusterScoped := StatusViolation{
+		APIVersion:        "v1",
+		Kind:              "Namespace",
+		Name:              "web",
+		Message:           "you must provide labels",
+		Code:              "missing_labels",
+		EnforcementAction: "dryrun",
+	}
+	tc := []struct {
+		Format        string
+		Namespaced    string
+		ClusterScoped string
+	}{
+		{
+			Format:        "fields",
+			Namespaced:    `{"kind":"Deployment","name":"nginx","namespace":"web","message":"you must provide labels","enforcementAction":"deny"}`,
+			ClusterScoped: `{"kind":"Namespace","name":"web","message":"you must provide labels","code":"missing_labels","enforcementAction":"dryrun"}`,
+		},
+		{
+			Format:        "apiversion",
+			Namespaced:    `{"apiVersion":"apps/v1","kind":"Deployment","name":"nginx","namespace":"web","message":"you must provide labels","enforcementAction":"deny"}`,
+			ClusterScoped: `{"apiVersion":"v1","kind":"Namespace","name":"web","message":"you must provide labels","code":"missing_labels","enforcementAction":"dryrun"}`,
+		},
+		{
+			Format:        "path",
+			Namespaced:    `{"id":"apps/v1/Deployment/web/nginx","message":"you must provide labels","enforcementAction":"deny"}`,
+			ClusterScoped: `{"id":"core/v1/Namespace//web","message":"you must provide labels","code":"missing_labels","enforcementAction":"dryrun"}`,
+		},
+		{
+			Format:        "object",
+			Namespaced:    `{"resource":{"group":"apps","version":"v1","kind":"Deployment","namespace":"web","name":"nginx"},"message":"you must provide labels","enforcementAction":"deny"}`,
+			ClusterScoped: `{"resource":{"group":"","version":"v1","kind":"Namespace","name":"web"},"message":"you must provide labels","code":"missing_labels","enforcementAction":"dryrun"}`,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Format, func(t *testing.T) {
+			flag.Set("audit-id-format", tt.Format)
+			if err := validateIDFormat(tt.Format); err != nil {
+				t.Fatalf("validateIDFormat() err = %s", err)
+			}
+			for _, c := range []struct {
+				v    StatusViolation
+				want string
+			}{{namespaced, tt.Namespaced}, {clusterScoped, tt.ClusterScoped}} {
+				out, err := json.Marshal(c.v)
+				if err != nil {
+					t.Fatalf("json.Marshal() err = %s", err)
+				}
+				if string(out) != c.want {
+					t.Errorf("json.Marshal() = %s; want %s", out, c.want)
+				}
+				var decoded map[string]interface{}
+				if err := json.Unmarshal(out, &decoded); err != nil {
+					t.Fatalf("json.Unmarshal() err = %s", err)
+				}
+				id := violationResourceID(decoded, tt.Format)
+				if id.Kind != c.v.Kind || id.Namespace != c.v.Namespace || id.Name != c.v.Name {
+					t.Errorf("violationResourceID(%s) = %+v; want the kind, namespace and name of %+v", out, id, c.v)
+				}
+			}
+		})
+	}
+
+	if err := validateIDFormat("gvk"); err == nil {
+		t.Error("validateIDFormat() err = nil for an unknown format; want an error")
+	}
+}
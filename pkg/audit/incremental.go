@@ -0,0 +1,173 @@
+package audit
+
+import (
+	"context"
+	"flag"
+	"sync"
+
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var auditIncrementalClear = flag.Bool("audit-incremental-clear", false, "between audits, remove the violations of a synced resource from constraint status as soon as the resource is deleted, rather than at the next audit")
+
+// auditedConstraint is a constraint whose status lists violations written by the last audit
+type auditedConstraint struct {
+	gvk        schema.GroupVersionKind
+	name       string
+	namespace  string
+	violations []auditResult
+}
+
+// auditedConstraints indexes the violations written by the last audit, so a deleted
+// resource can be matched to the constraints that list it without listing every constraint
+type auditedConstraints struct {
+	mux         sync.Mutex
+	client      client.Client
+	constraints map[string]*auditedConstraint
+	// ucloop is writing the violations of the audit to constraint status, if it is not nil
+	ucloop *updateConstraintLoop
+}
+
+func newAuditedConstraints() *auditedConstraints {
+	return &auditedConstraints{constraints: make(map[string]*auditedConstraint)}
+}
+
+// record replaces the index with the violations of an audit, which ucloop writes unless it is
+// nil. updateConstraints are the constraints the audit writes, keyed by selfLink like
+// updateLists. It must be called before ucloop starts, so that no violation it writes is missed
+// by clear.
+func (a *auditedConstraints) record(c client.Client, ucloop *updateConstraintLoop, updateConstraints map[string]unstructured.Unstructured, updateLists map[string][]auditResult) {
+	constraints := make(map[string]*auditedConstraint)
+	for selfLink, item := range updateConstraints {
+		results := updateLists[selfLink]
+		if len(results) == 0 {
+			continue
+		}
+		constraints[selfLink] = &auditedConstraint{
+			gvk:        item.GroupVersionKind(),
+			name:       item.GetName(),
+			namespace:  item.GetNamespace(),
+			violations: append([]auditResult(nil), results...),
+		}
+	}
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.client = c
+	a.constraints = constraints
+	a.ucloop = ucloop
+}
+
+// isResource reports whether a violation is of obj
+func (ar auditResult) isResource(obj *unstructured.Unstructured) bool {
+	return ar.rkind == obj.GetKind() && ar.rname == obj.GetName() && ar.rnamespace == obj.GetNamespace()
+}
+
+// clear removes the violations of obj from the status of every constraint that lists them,
+// and from the status the update loop of the audit has yet to write. The status of the
+// constraints is updated without holding the index, and the first error is returned once
+// every constraint has been tried, so that clearing is retried.
+func (a *auditedConstraints) clear(ctx context.Context, obj *unstructured.Unstructured) error {
+	a.mux.Lock()
+	c, ucloop := a.client, a.ucloop
+	listing := make(map[string]*auditedConstraint)
+	for selfLink, ac := range a.constraints {
+		for _, ar := range ac.violations {
+			if ar.isResource(obj) {
+				listing[selfLink] = ac
+				break
+			}
+		}
+	}
+	a.mux.Unlock()
+
+	// once forgotten by the loop, the violations cannot be written back after they are cleared
+	ucloop.forget(obj)
+	var firstErr error
+	for selfLink, ac := range listing {
+		if err := clearConstraintViolations(ctx, c, ac, obj); err != nil {
+			log.Error(err, "could not clear violations of deleted resource", "constraintName", ac.name, "kind", obj.GetKind(), "name", obj.GetName(), "namespace", obj.GetNamespace())
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		a.forget(selfLink, ac, obj)
+	}
+	return firstErr
+}
+
+// forget removes the violations of obj from ac, indexed at selfLink, unless the index was
+// replaced by another audit since
+func (a *auditedConstraints) forget(selfLink string, ac *auditedConstraint, obj *unstructured.Unstructured) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if a.constraints[selfLink] != ac {
+		return
+	}
+	var remaining []auditResult
+	for _, ar := range ac.violations {
+		if !ar.isResource(obj) {
+			remaining = append(remaining, ar)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(a.constraints, selfLink)
+	} else {
+		ac.violations = remaining
+	}
+}
+
+// clearConstraintViolations removes the violations of obj from the status of the latest
+// version of a constraint and lowers its totalViolations to match
+func clearConstraintViolations(ctx context.Context, c client.Client, ac *auditedConstraint, obj *unstructured.Unstructured) error {
+	instance := &unstructured.Unstructured{}
+	instance.SetGroupVersionKind(ac.gvk)
+	if err := c.Get(ctx, types.NamespacedName{Name: ac.name, Namespace: ac.namespace}, instance); err != nil {
+		return err
+	}
+	violations, _, err := unstructured.NestedSlice(instance.Object, "status", "violations")
+	if err != nil {
+		return err
+	}
+	var remaining []interface{}
+	for _, v := range violations {
+		if sv, ok := v.(map[string]interface{}); ok {
+			id := violationResourceID(sv, *auditIDFormat)
+			if id.Kind == obj.GetKind() && id.Name == obj.GetName() && id.Namespace == obj.GetNamespace() {
+				continue
+			}
+		}
+		remaining = append(remaining, v)
+	}
+	removed := int64(len(violations) - len(remaining))
+	if removed == 0 {
+		return nil
+	}
+	if len(remaining) == 0 {
+		unstructured.RemoveNestedField(instance.Object, "status", "violations")
+	} else {
+		unstructured.SetNestedSlice(instance.Object, remaining, "status", "violations")
+	}
+	total, found, err := unstructured.NestedInt64(instance.Object, "status", "totalViolations")
+	if err == nil && found {
+		total -= removed
+		if total < 0 {
+			total = 0
+		}
+		unstructured.SetNestedField(instance.Object, total, "status", "totalViolations")
+	}
+	if err := util.UpdateStatus(ctx, c, instance); err != nil {
+		return err
+	}
+	log.Info("cleared violations of deleted resource", "constraintName", ac.name, "count", removed, "kind", obj.GetKind(), "name", obj.GetName(), "namespace", obj.GetNamespace())
+	return nil
+}
+
+// clearViolations is called with each synced resource whose data is removed from OPA
+func (am *AuditManager) clearViolations(obj *unstructured.Unstructured) error {
+	return am.audited.clear(context.Background(), obj)
+}
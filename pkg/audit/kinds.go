@@ -0,0 +1,189 @@
+package audit
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	constraintTypes "github.com/open-policy-agent/frameworks/constraint/pkg/types"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var auditKindIntervals = flag.String("audit-kind-intervals", "", "comma-separated audit intervals in seconds of resource kinds audited on their own schedule, e.g. Pod=10,rbac.authorization.k8s.io/ClusterRole=3600. kinds of the core group are given without a group. all other kinds are audited every --auditInterval seconds ")
+
+// parseKindIntervals parses the value of --audit-kind-intervals
+func parseKindIntervals(s string) (map[schema.GroupKind]time.Duration, error) {
+	intervals := make(map[schema.GroupKind]time.Duration)
+	if s == "" {
+		return intervals, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid kind interval %q, want [group/]Kind=seconds", entry)
+		}
+		gk := schema.GroupKind{Kind: parts[0]}
+		if i := strings.LastIndex(parts[0], "/"); i >= 0 {
+			gk = schema.GroupKind{Group: parts[0][:i], Kind: parts[0][i+1:]}
+		}
+		if gk.Kind == "" {
+			return nil, fmt.Errorf("invalid kind interval %q, kind is empty", entry)
+		}
+		seconds, err := strconv.Atoi(parts[1])
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("invalid kind interval %q, interval must be a positive number of seconds", entry)
+		}
+		intervals[gk] = time.Duration(seconds) * time.Second
+	}
+	return intervals, nil
+}
+
+// auditScope selects the kinds of the resources an audit evaluates
+type auditScope struct {
+	// kinds are the only kinds audited, or the kinds not audited if exclude is set
+	kinds   []schema.GroupKind
+	exclude bool
+}
+
+// fullAudit is the scope of an audit of every kind
+var fullAudit = auditScope{exclude: true}
+
+// full returns true if the scope covers every kind
+func (s auditScope) full() bool {
+	return s.exclude && len(s.kinds) == 0
+}
+
+// includes returns true if resources of kind gk are audited
+func (s auditScope) includes(gk schema.GroupKind) bool {
+	for _, k := range s.kinds {
+		if k == gk {
+			return !s.exclude
+		}
+	}
+	return s.exclude
+}
+
+// kindSchedule tracks when each kind with its own audit interval is next due, and when the
+// remaining kinds are
+type kindSchedule struct {
+	intervals       map[schema.GroupKind]time.Duration
+	defaultInterval time.Duration
+	next            map[schema.GroupKind]time.Time
+	nextDefault     time.Time
+}
+
+// newKindSchedule schedules the first audit of every kind one interval after now
+func newKindSchedule(intervals map[schema.GroupKind]time.Duration, defaultInterval time.Duration, now time.Time) *kindSchedule {
+	s := &kindSchedule{
+		intervals:       intervals,
+		defaultInterval: defaultInterval,
+		next:            make(map[schema.GroupKind]time.Time),
+	}
+	s.reset(now)
+	return s
+}
+
+// reset schedules the next audit of every kind as if all were audited at now
+func (s *kindSchedule) reset(now time.Time) {
+	for gk, interval := range s.intervals {
+		s.next[gk] = now.Add(interval)
+	}
+	s.nextDefault = now.Add(s.defaultInterval)
+}
+
+// nextAudit returns when the next kind is due
+func (s *kindSchedule) nextAudit() time.Time {
+	next := s.nextDefault
+	for _, t := range s.next {
+		if t.Before(next) {
+			next = t
+		}
+	}
+	return next
+}
+
+// due returns the scope of the audit of the kinds due at now, and schedules their next audit
+func (s *kindSchedule) due(now time.Time) auditScope {
+	var due, notDue []schema.GroupKind
+	for gk, next := range s.next {
+		if now.Before(next) {
+			notDue = append(notDue, gk)
+			continue
+		}
+		due = append(due, gk)
+		s.next[gk] = now.Add(s.intervals[gk])
+	}
+	sortGroupKinds(due)
+	sortGroupKinds(notDue)
+	if now.Before(s.nextDefault) {
+		return auditScope{kinds: due}
+	}
+	s.nextDefault = now.Add(s.defaultInterval)
+	return auditScope{kinds: notDue, exclude: true}
+}
+
+func sortGroupKinds(gks []schema.GroupKind) {
+	sort.Slice(gks, func(i, j int) bool { return gks[i].String() < gks[j].String() })
+}
+
+// auditKinds evaluates the cached resources of the kinds in scope against every constraint
+func (am *AuditManager) auditKinds(ctx context.Context, scope auditScope) (*constraintTypes.Responses, error) {
+	t := &target.K8sValidationTarget{}
+	var kinds []interface{}
+	for _, gk := range scope.kinds {
+		kinds = append(kinds, map[string]interface{}{"group": gk.Group, "kind": gk.Kind})
+	}
+	input := map[string]interface{}{"kinds": kinds, "exclude": scope.exclude}
+	resp, err := am.driver.Query(ctx, fmt.Sprintf(`hooks["%s"].library.kind_audit`, t.GetName()), input)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range resp.Results {
+		if err := t.HandleViolation(r); err != nil {
+			return nil, err
+		}
+	}
+	resp.Target = t.GetName()
+	responses := constraintTypes.NewResponses()
+	responses.ByTarget[t.GetName()] = resp
+	return responses, nil
+}
+
+// mergeKindResults replaces the stored violations of the kinds in scope with those in resp,
+// and returns the stored violations of every kind. This keeps the violations of the kinds
+// that were not due in the constraint status written after the audit.
+func (am *AuditManager) mergeKindResults(scope auditScope, resp *constraintTypes.Responses) (*constraintTypes.Responses, error) {
+	for gk := range am.kindResults {
+		if scope.includes(gk) {
+			delete(am.kindResults, gk)
+		}
+	}
+	for _, r := range resp.Results() {
+		resource, ok := r.Resource.(*unstructured.Unstructured)
+		if !ok {
+			return nil, errors.Errorf("could not cast resource as reviewResource: %v", r.Resource)
+		}
+		gk := resource.GroupVersionKind().GroupKind()
+		am.kindResults[gk] = append(am.kindResults[gk], r)
+	}
+	var gks []schema.GroupKind
+	for gk := range am.kindResults {
+		gks = append(gks, gk)
+	}
+	sortGroupKinds(gks)
+	t := &target.K8sValidationTarget{}
+	merged := &constraintTypes.Response{Target: t.GetName()}
+	for _, gk := range gks {
+		merged.Results = append(merged.Results, am.kindResults[gk]...)
+	}
+	responses := constraintTypes.NewResponses()
+	responses.ByTarget[t.GetName()] = merged
+	return responses, nil
+}
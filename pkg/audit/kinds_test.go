@@ -0,0 +1,135 @@
+package audit
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	constraintTypes "github.com/open-policy-agent/frameworks/constraint/pkg/types"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	podKind         = schema.GroupKind{Kind: "Pod"}
+	clusterRoleKind = schema.GroupKind{Group: "rbac.authorization.k8s.io", Kind: "ClusterRole"}
+	serviceKind     = schema.GroupKind{Kind: "Service"}
+)
+
+func TestParseKindIntervals(t *testing.T) {
+	tc := []struct {
+		Name        string
+		Value       string
+		Expected    map[schema.GroupKind]time.Duration
+		ExpectError bool
+	}{
+		{
+			Name:     "Empty",
+			Value:    "",
+			Expected: map[schema.GroupKind]time.Duration{},
+		},
+		{
+			Name:  "Core and grouped kinds",
+			Value: "Pod=10, rbac.authorization.k8s.io/ClusterRole=3600",
+			Expected: map[schema.GroupKind]time.Duration{
+				podKind:         10 * time.Second,
+				clusterRoleKind: time.Hour,
+			},
+		},
+		{
+			Name:        "Missing interval",
+			Value:       "Pod",
+			ExpectError: true,
+		},
+		{
+			Name:        "Missing kind",
+			Value:       "apps/=10",
+			ExpectError: true,
+		},
+		{
+			Name:        "Zero interval",
+			Value:       "Pod=0",
+			ExpectError: true,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			got, err := parseKindIntervals(tt.Value)
+			if (err != nil) != tt.ExpectError {
+				t.Fatalf("parseKindIntervals() err = %v; want error %t", err, tt.ExpectError)
+			}
+			if !tt.ExpectError && !reflect.DeepEqual(got, tt.Expected) {
+				t.Errorf("parseKindIntervals() = %v; want %v", got, tt.Expected)
+			}
+		})
+	}
+}
+
+func TestKindSchedule(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := newKindSchedule(map[schema.GroupKind]time.Duration{podKind: 10 * time.Second}, 30*time.Second, now)
+
+	audits := make(map[schema.GroupKind]int)
+	for end := now.Add(time.Minute); ; {
+		now = s.nextAudit()
+		if now.After(end) {
+			break
+		}
+		scope := s.due(now)
+		for _, gk := range []schema.GroupKind{podKind, serviceKind} {
+			if scope.includes(gk) {
+				audits[gk]++
+			}
+		}
+	}
+	if audits[podKind] != 6 {
+		t.Errorf("Pods audited %d times in a minute; want 6", audits[podKind])
+	}
+	if audits[serviceKind] != 2 {
+		t.Errorf("Services audited %d times in a minute; want 2", audits[serviceKind])
+	}
+}
+
+func TestAuditKinds(t *testing.T) {
+	c, driver := makeOpaClient(t)
+	addTemplate(t, c, always_violate_template)
+	podsInFoo := addConstraint(t, c, pods_in_foo)
+	servicesAnywhere := addConstraint(t, c, services_anywhere)
+	addObject(t, c, "Pod", "foo", "a")
+	service := addObject(t, c, "Service", "foo", "s")
+	am := &AuditManager{driver: driver, kindResults: make(map[schema.GroupKind][]*constraintTypes.Result)}
+
+	violations := func(stage string, scope auditScope) map[string]int {
+		resp, err := am.auditKinds(context.Background(), scope)
+		if err != nil {
+			t.Fatalf("%s: auditKinds() err = %s", stage, err)
+		}
+		merged, err := am.mergeKindResults(scope, resp)
+		if err != nil {
+			t.Fatalf("%s: mergeKindResults() err = %s", stage, err)
+		}
+		counts := make(map[string]int)
+		for _, r := range merged.Results() {
+			counts[r.Constraint.GetName()]++
+		}
+		return counts
+	}
+	check := func(stage string, scope auditScope, cstr *unstructured.Unstructured, want int) {
+		if got := violations(stage, scope)[cstr.GetName()]; got != want {
+			t.Errorf("%s: %s has %d violations; want %d", stage, cstr.GetName(), got, want)
+		}
+	}
+
+	check("full audit", fullAudit, podsInFoo, 1)
+	addObject(t, c, "Pod", "foo", "b")
+	if _, err := c.RemoveData(context.Background(), service); err != nil {
+		t.Fatalf("could not remove data: %s", err)
+	}
+	podScope := auditScope{kinds: []schema.GroupKind{podKind}}
+	check("pod audit", podScope, podsInFoo, 2)
+	check("pod audit", podScope, servicesAnywhere, 1)
+	otherScope := auditScope{kinds: []schema.GroupKind{podKind}, exclude: true}
+	check("audit of other kinds", otherScope, servicesAnywhere, 0)
+	check("audit of other kinds", otherScope, podsInFoo, 2)
+}
@@ -4,16 +4,26 @@ import (
 	"context"
 	"encoding/json"
 	"flag"
+	"fmt"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers"
 	constraintTypes "github.com/open-policy-agent/frameworks/constraint/pkg/types"
+	"github.com/open-policy-agent/gatekeeper/pkg/controller/constraint"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
 	"github.com/pkg/errors"
 	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/discovery"
@@ -25,14 +35,17 @@ import (
 var log = logf.Log.WithName("controller").WithValues("metaKind", "audit")
 
 const (
-	crdName       = "constrainttemplates.templates.gatekeeper.sh"
-	constraintsGV = "constraints.gatekeeper.sh/v1beta1"
-	msgSize       = 256
+	crdName            = "constrainttemplates.templates.gatekeeper.sh"
+	constraintsVersion = "v1beta1"
+	msgSize            = 256
 )
 
 var (
 	auditInterval             = flag.Int("auditInterval", 60, "interval to run audit in seconds. defaulted to 60 secs if unspecified ")
-	constraintViolationsLimit = flag.Int("constraintViolationsLimit", 20, "limit of number of violations per constraint. defaulted to 20 violations if unspecified ")
+	constraintViolationsLimit = flag.Int("constraintViolationsLimit", 20, "limit of number of violations per constraint, for constraints that do not set spec.auditViolationsLimit. defaulted to 20 violations if unspecified ")
+	auditConstraintSelector   = flag.String("audit-constraint-selector", "", "label selector restricting audit to the constraints it matches, e.g. tier=critical. all constraints are audited if unspecified ")
+	statusWriteAttempts       = flag.Int("audit-status-write-attempts", 5, "number of times audit tries to write the status of each constraint before giving up on it until the next audit, e.g. when writes conflict or are throttled. defaulted to 5 if unspecified ")
+	statusWriteBackoff        = flag.Duration("audit-status-write-backoff", time.Second, "time to wait before retrying the failed constraint status writes of an audit, doubled after each attempt. defaulted to 1s if unspecified ")
 	emptyAuditResults         []auditResult
 )
 
@@ -40,11 +53,38 @@ var (
 type AuditManager struct {
 	client  client.Client
 	opa     *opa.Client
+	driver  drivers.Driver
 	stopper chan struct{}
 	stopped chan struct{}
 	cfg     *rest.Config
 	ctx     context.Context
 	ucloop  *updateConstraintLoop
+	// selector restricts audit to the constraints it matches
+	selector labels.Selector
+	// trigger receives requests to audit before the audit interval has passed
+	trigger chan struct{}
+	// audited indexes the violations written by the last audit for --audit-incremental-clear
+	audited *auditedConstraints
+	// schedule tracks when each kind is next due for --audit-kind-intervals, and kindResults
+	// holds the violations found by the last audit of each kind. Both are nil if every kind is
+	// audited every --auditInterval seconds.
+	schedule    *kindSchedule
+	kindResults map[schema.GroupKind][]*constraintTypes.Result
+	// reports receives the report of the first audit and is closed after it when the
+	// manager audits only once
+	reports chan *Report
+	// unsynced returns the kinds replicated into OPA that are not synced yet. The single audit
+	// waits for them, and it is nil unless the manager audits only once.
+	unsynced func() []schema.GroupVersionKind
+	// history keeps the violation counts of recent audits. It is nil unless
+	// --audit-history-size is set.
+	history *auditHistory
+	// exporter sends the violations of each audit to a central service. It is nil unless
+	// --audit-export-url is set.
+	exporter *violationExporter
+	// system selects the resources whose violations are skipped. It is nil unless
+	// --audit-skip-system-resources is set.
+	system systemResources
 }
 
 type auditResult struct {
@@ -52,71 +92,248 @@ type auditResult struct {
 	cnamespace        string
 	cgvk              schema.GroupVersionKind
 	capiversion       string
+	rapiversion       string
 	rkind             string
 	rname             string
 	rnamespace        string
 	message           string
+	code              string
 	enforcementAction string
 }
 
-// StatusViolation represents each violation under status
+// StatusViolation represents each violation under status. Its violating resource is written
+// as set by --audit-id-format.
 type StatusViolation struct {
+	APIVersion        string `json:"apiVersion,omitempty"`
 	Kind              string `json:"kind"`
 	Name              string `json:"name"`
 	Namespace         string `json:"namespace,omitempty"`
 	Message           string `json:"message"`
+	Code              string `json:"code,omitempty"`
 	EnforcementAction string `json:"enforcementAction"`
 }
 
+// Report summarizes the violations found by an audit
+type Report struct {
+	Timestamp       string `json:"timestamp"`
+	TotalViolations int64  `json:"totalViolations"`
+	// DenyViolations counts the violations of constraints whose enforcementAction is deny
+	DenyViolations int64              `json:"denyViolations"`
+	Constraints    []ConstraintReport `json:"constraints,omitempty"`
+}
+
+// ConstraintReport lists the violations of one constraint, up to its auditViolationsLimit or
+// --constraintViolationsLimit
+type ConstraintReport struct {
+	Kind            string            `json:"kind"`
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace,omitempty"`
+	TotalViolations int64             `json:"totalViolations"`
+	TotalMatches    int64             `json:"totalMatches"`
+	Violations      []StatusViolation `json:"violations"`
+}
+
 // New creates a new manager for audit
-func New(ctx context.Context, cfg *rest.Config, opa *opa.Client) (*AuditManager, error) {
+func New(ctx context.Context, cfg *rest.Config, opa *opa.Client, driver drivers.Driver) (*AuditManager, error) {
+	selector, err := constraintSelector(*auditConstraintSelector)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid --audit-constraint-selector")
+	}
+	if err := validateOutput(*auditOutput); err != nil {
+		return nil, errors.Wrap(err, "invalid --audit-output")
+	}
+	if err := validatePriority(*auditOpaPriority); err != nil {
+		return nil, errors.Wrap(err, "invalid --audit-opa-priority")
+	}
+	if err := validateIDFormat(*auditIDFormat); err != nil {
+		return nil, errors.Wrap(err, "invalid --audit-id-format")
+	}
 	am := &AuditManager{
-		opa:     opa,
-		stopper: make(chan struct{}),
-		stopped: make(chan struct{}),
-		cfg:     cfg,
-		ctx:     ctx,
+		opa:      opa,
+		driver:   driver,
+		stopper:  make(chan struct{}),
+		stopped:  make(chan struct{}),
+		cfg:      cfg,
+		ctx:      ctx,
+		selector: selector,
+		trigger:  make(chan struct{}, 1),
+		audited:  newAuditedConstraints(),
+	}
+	intervals, err := parseKindIntervals(*auditKindIntervals)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid --audit-kind-intervals")
+	}
+	if len(intervals) > 0 {
+		am.schedule = newKindSchedule(intervals, time.Duration(*auditInterval)*time.Second, time.Now())
+		am.kindResults = make(map[schema.GroupKind][]*constraintTypes.Result)
+	}
+	if *auditSkipSystemResources {
+		if am.system, err = parseSystemResources(*auditSystemResources); err != nil {
+			return nil, errors.Wrap(err, "invalid --audit-system-resources")
+		}
 	}
 	return am, nil
 }
 
-// audit performs an audit then updates the status of all constraint resources with the results
-func (am *AuditManager) audit(ctx context.Context) error {
+// audit performs an audit of the resources of the kinds in scope then updates the status of all
+// constraint resources with the results
+func (am *AuditManager) audit(ctx context.Context, scope auditScope) (*Report, error) {
 	timestamp := time.Now().UTC().Format(time.RFC3339)
 	// new client to get updated restmapper
 	c, err := client.New(am.cfg, client.Options{Scheme: nil, Mapper: nil})
 	if err != nil {
-		return err
+		return nil, err
 	}
 	am.client = c
 	// don't audit anything until the constraintTemplate crd is in the cluster
 	if err := am.ensureCRDExists(ctx); err != nil {
 		log.Info("Audit exits, required crd has not been deployed ", "CRD", crdName)
-		return nil
+		return &Report{Timestamp: timestamp}, nil
 	}
-	resp, err := am.opa.Audit(ctx)
+	report, updateLists, totalViolationsPerConstraint, totalMatchesPerConstraint, err := am.evaluate(ctx, scope, timestamp)
 	if err != nil {
-		return err
+		progress.failed(err)
+		return nil, err
+	}
+	if util.ReadOnly() {
+		logReport(report)
+		return report, nil
+	}
+	// get all constraint kinds
+	rs, err := am.getAllConstraintKinds()
+	if err != nil {
+		// if no constraint is found with the constraint apiversion, then return
+		log.Info("no constraint is found with apiversion", "constraint apiversion", constraint.Group+"/"+constraintsVersion)
+		return report, nil
+	}
+	// update constraints for each kind
+	if err := am.writeAuditResults(ctx, rs, am.selector, updateLists, timestamp, totalViolationsPerConstraint, totalMatchesPerConstraint); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// evaluate audits the cached resources of the kinds in scope against every constraint, and
+// returns the report of the audit along with the violations to write to the status of each
+// constraint and the counts of its violations and matches. Its progress is published to the
+// clients of ProgressHandler.
+func (am *AuditManager) evaluate(ctx context.Context, scope auditScope, timestamp string) (*Report, map[string][]auditResult, map[string]int64, map[string]int64, error) {
+	progress.started(timestamp)
+	var resp *constraintTypes.Responses
+	var err error
+	if *auditOpaPriority == "low" {
+		resp, err = am.auditEachKind(ctx, scope)
+	} else if scope.full() {
+		resp, err = am.opa.Audit(ctx)
+	} else {
+		log.Info("auditing kinds", "kinds", scope.kinds, "exclude", scope.exclude)
+		resp, err = am.auditKinds(ctx, scope)
+	}
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	resp = skipTerminating(am.system.skip(resp))
+	if *auditOpaPriority != "low" {
+		// violations of kinds audited one at a time are published as each kind is audited
+		progress.violations(resp.Results())
+	}
+	if am.schedule != nil {
+		if resp, err = am.mergeKindResults(scope, resp); err != nil {
+			return nil, nil, nil, nil, err
+		}
 	}
 	log.Info("Audit opa.Audit() audit results", "violations", len(resp.Results()))
+	logDebugViolations(resp.Results())
+	if scope.full() || am.schedule != nil {
+		// admission counts the violations of objects already in the cluster towards the
+		// violationThreshold of constraints
+		util.RecordAuditedViolations(resp.Results())
+	}
 	// get updatedLists
 	updateLists := make(map[string][]auditResult)
 	totalViolationsPerConstraint := make(map[string]int64)
 	if len(resp.Results()) > 0 {
-		updateLists, totalViolationsPerConstraint, err = getUpdateListsFromAuditResponses(resp)
+		updateLists, totalViolationsPerConstraint, err = getUpdateListsFromAuditResponses(resp, am.selector)
 		if err != nil {
-			return err
+			return nil, nil, nil, nil, err
 		}
 	}
-	// get all constraint kinds
-	rs, err := am.getAllConstraintKinds()
+	if *auditOpaPriority == "low" {
+		if err := util.WaitForAdmissions(ctx, maxAdmissionWait); err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
+	totalMatchesPerConstraint, err := getMatchCounts(ctx, am.driver, am.selector)
 	if err != nil {
-		// if no constraint is found with the constraint apiversion, then return
-		log.Info("no constraint is found with apiversion", "constraint apiversion", constraintsGV)
-		return nil
+		return nil, nil, nil, nil, err
 	}
-	// update constraints for each kind
-	return am.writeAuditResults(ctx, rs, updateLists, timestamp, totalViolationsPerConstraint)
+	report := newReport(timestamp, resp, am.selector, updateLists, totalViolationsPerConstraint, totalMatchesPerConstraint)
+	progress.completed(report)
+	return report, updateLists, totalViolationsPerConstraint, totalMatchesPerConstraint, nil
+}
+
+// logReport logs the violations of each constraint in report, in place of writing them to
+// constraint status
+func logReport(report *Report) {
+	for _, cr := range report.Constraints {
+		log.Info("audit results", "kind", cr.Kind, "name", cr.Name, "namespace", cr.Namespace, "totalViolations", cr.TotalViolations, "totalMatches", cr.TotalMatches, "violations", cr.Violations)
+	}
+}
+
+// newReport summarizes the results of an audit
+func newReport(timestamp string, resp *constraintTypes.Responses, selector labels.Selector, updateLists map[string][]auditResult, totalViolations map[string]int64, totalMatches map[string]int64) *Report {
+	report := &Report{Timestamp: timestamp}
+	results := resp.Results()
+	util.ResolveEnforcementActions(results)
+	for _, r := range results {
+		if !selected(selector, r.Constraint) {
+			continue
+		}
+		report.TotalViolations++
+		if r.EnforcementAction == "deny" {
+			report.DenyViolations++
+		}
+	}
+	for selfLink, results := range updateLists {
+		cr := ConstraintReport{
+			Kind:            results[0].cgvk.Kind,
+			Name:            results[0].cname,
+			Namespace:       results[0].cnamespace,
+			TotalViolations: totalViolations[selfLink],
+			TotalMatches:    totalMatches[selfLink],
+		}
+		for _, ar := range results {
+			cr.Violations = append(cr.Violations, StatusViolation{
+				APIVersion:        ar.rapiversion,
+				Kind:              ar.rkind,
+				Name:              ar.rname,
+				Namespace:         ar.rnamespace,
+				Message:           ar.message,
+				Code:              ar.code,
+				EnforcementAction: ar.enforcementAction,
+			})
+		}
+		report.Constraints = append(report.Constraints, cr)
+	}
+	sort.Slice(report.Constraints, func(i, j int) bool {
+		if report.Constraints[i].Kind != report.Constraints[j].Kind {
+			return report.Constraints[i].Kind < report.Constraints[j].Kind
+		}
+		return report.Constraints[i].Name < report.Constraints[j].Name
+	})
+	return report
+}
+
+// ExitCode returns the exit code of a process that audited once and produced report: 0 if
+// no deny violations were found, 2 if some were, or 1 if the audit failed and report is nil
+func ExitCode(report *Report) int {
+	if report == nil {
+		return 1
+	}
+	if report.DenyViolations > 0 {
+		return 2
+	}
+	return 0
 }
 
 func (am *AuditManager) auditManagerLoop(ctx context.Context) {
@@ -127,9 +344,34 @@ func (am *AuditManager) auditManagerLoop(ctx context.Context) {
 			close(am.stopper)
 			return
 		default:
-			time.Sleep(time.Duration(*auditInterval) * time.Second)
-			if err := am.audit(ctx); err != nil {
+			scope := am.waitForNextAudit(ctx)
+			if ctx.Err() != nil {
+				continue
+			}
+			if am.reports != nil {
+				if err := am.waitForSync(ctx); err != nil {
+					log.Error(err, "not running the single audit")
+					close(am.reports)
+					return
+				}
+			}
+			report, err := am.audit(ctx, scope)
+			if err != nil {
 				log.Error(err, "audit manager audit() failed")
+			} else {
+				am.history.add(report)
+				am.exporter.export(report)
+			}
+			if am.reports != nil {
+				// wait for the results to be written to constraint status before reporting
+				if am.ucloop != nil {
+					<-am.ucloop.stopped
+				}
+				if err == nil {
+					am.reports <- report
+				}
+				close(am.reports)
+				return
 			}
 		}
 	}
@@ -151,23 +393,65 @@ func (am *AuditManager) ensureCRDExists(ctx context.Context) error {
 	return am.client.Get(ctx, types.NamespacedName{Name: crdName}, crd)
 }
 
-func (am *AuditManager) getAllConstraintKinds() (*metav1.APIResourceList, error) {
+// getAllConstraintKinds returns the constraint kinds of every group constraints are served in
+func (am *AuditManager) getAllConstraintKinds() ([]*metav1.APIResourceList, error) {
 	discoveryClient, err := discovery.NewDiscoveryClientForConfig(am.cfg)
 	if err != nil {
 		return nil, err
 	}
-	return discoveryClient.ServerResourcesForGroupVersion(constraintsGV)
+	var rs []*metav1.APIResourceList
+	for _, group := range constraint.Groups() {
+		gv := group + "/" + constraintsVersion
+		r, err := discoveryClient.ServerResourcesForGroupVersion(gv)
+		if err != nil {
+			if group == constraint.Group {
+				return nil, err
+			}
+			// namespaced constraints are only served once a template has been created
+			log.Info("no constraint is found with apiversion", "constraint apiversion", gv)
+			continue
+		}
+		rs = append(rs, r)
+	}
+	return rs, nil
 }
 
-func getUpdateListsFromAuditResponses(resp *constraintTypes.Responses) (map[string][]auditResult, map[string]int64, error) {
+// constraintSelector returns the selector of the audited constraints: those matched by the
+// label selector s that are not disabled with util.DisabledLabel
+func constraintSelector(s string) (labels.Selector, error) {
+	selector, err := labels.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	enabled, err := labels.NewRequirement(util.DisabledLabel, selection.NotEquals, []string{"true"})
+	if err != nil {
+		return nil, err
+	}
+	return selector.Add(*enabled), nil
+}
+
+// selected reports whether a constraint is matched by selector
+func selected(selector labels.Selector, obj *unstructured.Unstructured) bool {
+	return selector.Matches(labels.Set(obj.GetLabels()))
+}
+
+func getUpdateListsFromAuditResponses(resp *constraintTypes.Responses, selector labels.Selector) (map[string][]auditResult, map[string]int64, error) {
 	updateLists := make(map[string][]auditResult)
 	totalViolationsPerConstraint := make(map[string]int64)
 
-	for _, r := range resp.Results() {
+	results := resp.Results()
+	util.ResolveEnforcementActions(results)
+	// the order of results decides which violations are kept within the limit
+	util.SortResults(results)
+	for _, r := range results {
+		// skip constraints excluded by the audit constraint selector
+		if !selected(selector, r.Constraint) {
+			continue
+		}
 		selfLink := r.Constraint.GetSelfLink()
 		totalViolationsPerConstraint[selfLink] = totalViolationsPerConstraint[selfLink] + 1
-		// skip if this constraint has reached the constraintViolationsLimit
-		if len(updateLists[selfLink]) < *constraintViolationsLimit {
+		// skip if this constraint has reached its auditViolationsLimit or the constraintViolationsLimit
+		if len(updateLists[selfLink]) < util.AuditViolationsLimit(r.Constraint, *constraintViolationsLimit) {
 			name := r.Constraint.GetName()
 			namespace := r.Constraint.GetNamespace()
 			apiVersion := r.Constraint.GetAPIVersion()
@@ -183,16 +467,19 @@ func getUpdateListsFromAuditResponses(resp *constraintTypes.Responses) (map[stri
 			}
 			rname := resource.GetName()
 			rkind := resource.GetKind()
+			rapiversion := resource.GetAPIVersion()
 			rnamespace := resource.GetNamespace()
 			updateLists[selfLink] = append(updateLists[selfLink], auditResult{
 				cgvk:              gvk,
 				capiversion:       apiVersion,
 				cname:             name,
 				cnamespace:        namespace,
+				rapiversion:       rapiversion,
 				rkind:             rkind,
 				rname:             rname,
 				rnamespace:        rnamespace,
 				message:           message,
+				code:              util.ViolationCode(r),
 				enforcementAction: enforcementAction,
 			})
 		}
@@ -200,67 +487,105 @@ func getUpdateListsFromAuditResponses(resp *constraintTypes.Responses) (map[stri
 	return updateLists, totalViolationsPerConstraint, nil
 }
 
-func (am *AuditManager) writeAuditResults(ctx context.Context, resourceList *metav1.APIResourceList, updateLists map[string][]auditResult, timestamp string, totalViolations map[string]int64) error {
-	resourceGV := strings.Split(resourceList.GroupVersion, "/")
-	group := resourceGV[0]
-	version := resourceGV[1]
-
-	// get constraints for each Kind
-	for _, r := range resourceList.APIResources {
-		log.Info("constraint", "resource kind", r.Kind)
-		constraintGvk := schema.GroupVersionKind{
-			Group:   group,
-			Version: version,
-			Kind:    r.Kind + "List",
-		}
-		instanceList := &unstructured.UnstructuredList{}
-		instanceList.SetGroupVersionKind(constraintGvk)
-		err := am.client.List(ctx, &client.ListOptions{}, instanceList)
-		if err != nil {
-			return err
+// getMatchCounts returns the number of cached resources matched by each constraint selected
+// by selector, keyed by the constraint's selfLink. It also records the counts as metrics.
+func getMatchCounts(ctx context.Context, driver drivers.Driver, selector labels.Selector) (map[string]int64, error) {
+	resp, err := driver.Query(ctx, fmt.Sprintf(`hooks["%s"].library.matched_count`, (&target.K8sValidationTarget{}).GetName()), nil)
+	if err != nil {
+		return nil, err
+	}
+	matchedTotal.Reset()
+	totalMatches := make(map[string]int64)
+	for _, r := range resp.Results {
+		if r.Constraint == nil || !selected(selector, r.Constraint) {
+			continue
 		}
-		log.Info("constraint", "count of constraints", len(instanceList.Items))
-		updateConstraints := make(map[string]unstructured.Unstructured, len(instanceList.Items))
-		// get each constraint
-		for _, item := range instanceList.Items {
-			updateConstraints[item.GetSelfLink()] = item
+		matched, ok := r.Metadata["matched"].(float64)
+		if !ok {
+			return nil, errors.Errorf("could not read match count for constraint %s: %v", r.Constraint.GetName(), r.Metadata)
 		}
+		totalMatches[r.Constraint.GetSelfLink()] = int64(matched)
+		matchedTotal.WithLabelValues(r.Constraint.GetKind(), r.Constraint.GetName()).Set(matched)
+	}
+	return totalMatches, nil
+}
 
-		if len(updateConstraints) > 0 {
-			if am.ucloop != nil {
-				close(am.ucloop.stop)
-				select {
-				case <-am.ucloop.stopped:
-				case <-time.After(time.Duration(*auditInterval) * time.Second):
-				}
+func (am *AuditManager) writeAuditResults(ctx context.Context, resourceLists []*metav1.APIResourceList, selector labels.Selector, updateLists map[string][]auditResult, timestamp string, totalViolations map[string]int64, totalMatches map[string]int64) error {
+	updateConstraints := make(map[string]unstructured.Unstructured)
+	for _, resourceList := range resourceLists {
+		resourceGV := strings.Split(resourceList.GroupVersion, "/")
+		group := resourceGV[0]
+		version := resourceGV[1]
+
+		// get constraints for each Kind
+		for _, r := range resourceList.APIResources {
+			log.Info("constraint", "resource kind", r.Kind, "group", group)
+			constraintGvk := schema.GroupVersionKind{
+				Group:   group,
+				Version: version,
+				Kind:    r.Kind + "List",
+			}
+			instanceList := &unstructured.UnstructuredList{}
+			instanceList.SetGroupVersionKind(constraintGvk)
+			// only update the status of audited constraints
+			err := am.client.List(ctx, &client.ListOptions{LabelSelector: selector}, instanceList)
+			if err != nil {
+				return err
 			}
-			am.ucloop = &updateConstraintLoop{
-				uc:      updateConstraints,
-				client:  am.client,
-				stop:    make(chan struct{}),
-				stopped: make(chan struct{}),
-				ul:      updateLists,
-				ts:      timestamp,
-				tv:      totalViolations,
+			log.Info("constraint", "count of constraints", len(instanceList.Items))
+			// get each constraint
+			for _, item := range instanceList.Items {
+				updateConstraints[item.GetSelfLink()] = item
 			}
-			log.Info("starting update constraints loop", "updateConstraints", updateConstraints)
-			go am.ucloop.update()
 		}
 	}
+
+	// update the constraints of all kinds in a single loop, so that constraints of one
+	// kind are not left unwritten when the loop is restarted for the next
+	var ucloop *updateConstraintLoop
+	if len(updateConstraints) > 0 {
+		if am.ucloop != nil {
+			close(am.ucloop.stop)
+			select {
+			case <-am.ucloop.stopped:
+			case <-time.After(time.Duration(*auditInterval) * time.Second):
+			}
+		}
+		am.ucloop = &updateConstraintLoop{
+			uc:      updateConstraints,
+			client:  am.client,
+			stop:    make(chan struct{}),
+			stopped: make(chan struct{}),
+			ul:      updateLists,
+			ts:      timestamp,
+			tv:      totalViolations,
+			tm:      totalMatches,
+		}
+		ucloop = am.ucloop
+	}
+	if *auditIncrementalClear {
+		am.audited.record(am.client, ucloop, updateConstraints, updateLists)
+	}
+	if ucloop != nil {
+		log.Info("starting update constraints loop", "updateConstraints", updateConstraints)
+		go ucloop.update()
+	}
 	return nil
 }
 
-func (ucloop *updateConstraintLoop) updateConstraintStatus(ctx context.Context, instance *unstructured.Unstructured, auditResults []auditResult, timestamp string, totalViolations int64) error {
+func (ucloop *updateConstraintLoop) updateConstraintStatus(ctx context.Context, instance *unstructured.Unstructured, auditResults []auditResult, timestamp string, totalViolations int64, totalMatches int64) error {
 	constraintName := instance.GetName()
 	log.Info("updating constraint", "constraintName", constraintName)
 	// create constraint status violations
 	var statusViolations []interface{}
 	for _, ar := range auditResults {
 		statusViolations = append(statusViolations, StatusViolation{
+			APIVersion:        ar.rapiversion,
 			Kind:              ar.rkind,
 			Name:              ar.rname,
 			Namespace:         ar.rnamespace,
 			Message:           ar.message,
+			Code:              ar.code,
 			EnforcementAction: ar.enforcementAction,
 		})
 	}
@@ -274,10 +599,19 @@ func (ucloop *updateConstraintLoop) updateConstraintStatus(ctx context.Context,
 	if err != nil {
 		return err
 	}
+	// skip the write if the violations and totals are those already stored, which leaves
+	// auditTimestamp at the last audit that changed them
+	if auditStatusUnchanged(instance, auditStatus{Violations: violations, TotalViolations: totalViolations, TotalMatches: totalMatches}) {
+		statusWritesSkippedTotal.Inc()
+		log.V(1).Info("constraint status unchanged, not updating it", "constraintName", constraintName)
+		return nil
+	}
 	// update constraint status auditTimestamp
 	unstructured.SetNestedField(instance.Object, timestamp, "status", "auditTimestamp")
 	// update constraint status totalViolations
 	unstructured.SetNestedField(instance.Object, totalViolations, "status", "totalViolations")
+	// update constraint status totalMatches
+	unstructured.SetNestedField(instance.Object, totalMatches, "status", "totalMatches")
 	// update constraint status violations
 	if len(violations) == 0 {
 		_, found, err := unstructured.NestedSlice(instance.Object, "status", "violations")
@@ -288,14 +622,14 @@ func (ucloop *updateConstraintLoop) updateConstraintStatus(ctx context.Context,
 			unstructured.RemoveNestedField(instance.Object, "status", "violations")
 			log.Info("removed status violations", "constraintName", constraintName)
 		}
-		err = ucloop.client.Update(ctx, instance)
+		err = util.UpdateStatus(ctx, ucloop.client, instance)
 		if err != nil {
 			return err
 		}
 	} else {
 		unstructured.SetNestedSlice(instance.Object, violations, "status", "violations")
 		log.Info("update constraint", "object", instance)
-		err = ucloop.client.Update(ctx, instance)
+		err = util.UpdateStatus(ctx, ucloop.client, instance)
 		if err != nil {
 			return err
 		}
@@ -304,6 +638,18 @@ func (ucloop *updateConstraintLoop) updateConstraintStatus(ctx context.Context,
 	return nil
 }
 
+// statusWriteFailureReason returns the reason label of the failure to write a constraint
+// status with err
+func statusWriteFailureReason(err error) string {
+	switch {
+	case apierrors.IsConflict(err):
+		return "conflict"
+	case apierrors.IsTooManyRequests(err), apierrors.IsServerTimeout(err):
+		return "throttled"
+	}
+	return "error"
+}
+
 func truncateString(str string, size int) string {
 	shortenStr := str
 	if len(str) > size {
@@ -316,6 +662,8 @@ func truncateString(str string, size int) string {
 }
 
 type updateConstraintLoop struct {
+	// mux is held while a constraint is written, and guards ul and tv
+	mux     sync.Mutex
 	uc      map[string]unstructured.Unstructured
 	client  client.Client
 	stop    chan struct{}
@@ -323,6 +671,34 @@ type updateConstraintLoop struct {
 	ul      map[string][]auditResult
 	ts      string
 	tv      map[string]int64
+	tm      map[string]int64
+}
+
+// forget removes the violations of obj from the status the loop has yet to write, once obj is
+// deleted. A nil loop has nothing to forget.
+func (ucloop *updateConstraintLoop) forget(obj *unstructured.Unstructured) {
+	if ucloop == nil {
+		return
+	}
+	ucloop.mux.Lock()
+	defer ucloop.mux.Unlock()
+	for selfLink, results := range ucloop.ul {
+		var remaining []auditResult
+		for _, ar := range results {
+			if !ar.isResource(obj) {
+				remaining = append(remaining, ar)
+			}
+		}
+		removed := int64(len(results) - len(remaining))
+		if removed == 0 {
+			continue
+		}
+		ucloop.ul[selfLink] = remaining
+		ucloop.tv[selfLink] -= removed
+		if ucloop.tv[selfLink] < 0 {
+			ucloop.tv[selfLink] = 0
+		}
+	}
 }
 
 func (ucloop *updateConstraintLoop) update() {
@@ -333,6 +709,7 @@ func (ucloop *updateConstraintLoop) update() {
 			case <-ucloop.stop:
 				return true, nil
 			default:
+				ucloop.mux.Lock()
 				failure := false
 				ctx := context.Background()
 				var latestItem unstructured.Unstructured
@@ -350,23 +727,27 @@ func (ucloop *updateConstraintLoop) update() {
 					log.Error(err, "could not get latest constraint during update", "name", name, "namespace", namespace)
 				}
 				if constraintAuditResults, ok := ucloop.ul[latestItem.GetSelfLink()]; !ok {
-					err := ucloop.updateConstraintStatus(ctx, &latestItem, emptyAuditResults, ucloop.ts, 0)
+					err := ucloop.updateConstraintStatus(ctx, &latestItem, emptyAuditResults, ucloop.ts, 0, ucloop.tm[latestItem.GetSelfLink()])
 					if err != nil {
 						failure = true
+						statusWriteFailuresTotal.WithLabelValues(statusWriteFailureReason(err)).Inc()
 						log.Error(err, "could not update constraint status", "name", name, "namespace", namespace)
 					}
 				} else {
 					totalViolations := ucloop.tv[latestItem.GetSelfLink()]
 					// update the constraint
-					err := ucloop.updateConstraintStatus(ctx, &latestItem, constraintAuditResults, ucloop.ts, totalViolations)
+					totalMatches := ucloop.tm[latestItem.GetSelfLink()]
+					err := ucloop.updateConstraintStatus(ctx, &latestItem, constraintAuditResults, ucloop.ts, totalViolations, totalMatches)
 					if err != nil {
 						failure = true
+						statusWriteFailuresTotal.WithLabelValues(statusWriteFailureReason(err)).Inc()
 						log.Error(err, "could not update constraint status", "name", name, "namespace", namespace)
 					}
 				}
 				if !failure {
 					delete(ucloop.uc, latestItem.GetSelfLink())
 				}
+				ucloop.mux.Unlock()
 			}
 		}
 		if len(ucloop.uc) == 0 {
@@ -375,11 +756,13 @@ func (ucloop *updateConstraintLoop) update() {
 		return false, nil
 	}
 
+	// each attempt only writes the constraints whose status is not written yet, so every
+	// constraint is tried at most --audit-status-write-attempts times
 	if err := wait.ExponentialBackoff(wait.Backoff{
-		Duration: 1 * time.Second,
+		Duration: *statusWriteBackoff,
 		Factor:   2,
 		Jitter:   1,
-		Steps:    5,
+		Steps:    *statusWriteAttempts,
 	}, updateLoop); err != nil {
 		log.Error(err, "could not update constraint reached max retries", "remaining update constraints", ucloop.uc)
 	}
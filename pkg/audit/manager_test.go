@@ -0,0 +1,1026 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+	templv1beta1 "github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
+	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers/local"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/core/templates"
+	"github.com/open-policy-agent/gatekeeper/pkg/apis"
+	configv1alpha1 "github.com/open-policy-agent/gatekeeper/pkg/apis/config/v1alpha1"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	dto "github.com/prometheus/client_model/go"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	always_violate_template = `
+apiVersion: templates.gatekeeper.sh/v1beta1
+kind: ConstraintTemplate
+metadata:
+  name: k8salwaysviolate
+spec:
+  crd:
+    spec:
+      names:
+        kind: K8sAlwaysViolate
+        listKind: K8sAlwaysViolateList
+        plural: k8salwaysviolate
+        singular: k8salwaysviolate
+  targets:
+    - target: admission.k8s.gatekeeper.sh
+      rego: |
+        package alwaysviolate
+
+        violation[{"msg": msg}] {
+          msg := "always"
+        }
+`
+
+	coded_violation_template = `
+apiVersion: templates.gatekeeper.sh/v1beta1
+kind: ConstraintTemplate
+metadata:
+  name: k8scodedviolation
+spec:
+  crd:
+    spec:
+      names:
+        kind: K8sCodedViolation
+        listKind: K8sCodedViolationList
+        plural: k8scodedviolation
+        singular: k8scodedviolation
+  targets:
+    - target: admission.k8s.gatekeeper.sh
+      rego: |
+        package codedviolation
+
+        violation[{"msg": msg, "details": {"code": "missing_owner"}}] {
+          msg := "no owner"
+        }
+`
+
+	coded_pods_anywhere = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sCodedViolation
+metadata:
+  name: coded-pods-anywhere
+  selfLink: /apis/constraints.gatekeeper.sh/v1beta1/k8scodedviolation/coded-pods-anywhere
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Pod"]
+`
+
+	pods_in_foo = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sAlwaysViolate
+metadata:
+  name: pods-in-foo
+  selfLink: /apis/constraints.gatekeeper.sh/v1beta1/k8salwaysviolate/pods-in-foo
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Pod"]
+    namespaces: ["foo"]
+`
+
+	critical_pods_anywhere = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sAlwaysViolate
+metadata:
+  name: critical-pods-anywhere
+  selfLink: /apis/constraints.gatekeeper.sh/v1beta1/k8salwaysviolate/critical-pods-anywhere
+  labels:
+    tier: critical
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Pod"]
+`
+
+	services_anywhere = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sAlwaysViolate
+metadata:
+  name: services-anywhere
+  selfLink: /apis/constraints.gatekeeper.sh/v1beta1/k8salwaysviolate/services-anywhere
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Service"]
+`
+)
+
+func makeOpaClient(t testing.TB) (*opa.Client, drivers.Driver) {
+	driver := local.New(local.Tracing(false))
+	backend, err := opa.NewBackend(opa.Driver(driver))
+	if err != nil {
+		t.Fatalf("Could not create backend: %s", err)
+	}
+	c, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+	return c, driver
+}
+
+func addTemplate(t testing.TB, c *opa.Client, src string) {
+	scheme := k8sruntime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Fatalf("Could not build scheme: %s", err)
+	}
+	templ := &templv1beta1.ConstraintTemplate{}
+	if err := yaml.Unmarshal([]byte(src), templ); err != nil {
+		t.Fatalf("Could not parse template: %s", err)
+	}
+	unversioned := &templates.ConstraintTemplate{}
+	if err := scheme.Convert(templ, unversioned, nil); err != nil {
+		t.Fatalf("Could not convert template: %s", err)
+	}
+	if _, err := c.AddTemplate(context.Background(), unversioned); err != nil {
+		t.Fatalf("Could not add template: %s", err)
+	}
+}
+
+func addConstraint(t testing.TB, c *opa.Client, src string) *unstructured.Unstructured {
+	cstr := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(src), &cstr.Object); err != nil {
+		t.Fatalf("Could not parse constraint: %s", err)
+	}
+	if _, err := c.AddConstraint(context.Background(), cstr); err != nil {
+		t.Fatalf("Could not add constraint: %s", err)
+	}
+	return cstr
+}
+
+func addObject(t testing.TB, c *opa.Client, kind, namespace, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	if _, err := c.AddData(context.Background(), obj); err != nil {
+		t.Fatalf("Could not add data: %s", err)
+	}
+	return obj
+}
+
+func TestGetMatchCounts(t *testing.T) {
+	c, driver := makeOpaClient(t)
+	addTemplate(t, c, always_violate_template)
+	podsInFoo := addConstraint(t, c, pods_in_foo)
+	servicesAnywhere := addConstraint(t, c, services_anywhere)
+	addObject(t, c, "Pod", "foo", "a")
+	addObject(t, c, "Pod", "foo", "b")
+	addObject(t, c, "Pod", "bar", "c")
+
+	counts, err := getMatchCounts(context.Background(), driver, labels.Everything())
+	if err != nil {
+		t.Fatalf("getMatchCounts() err = %s", err)
+	}
+	if got := counts[podsInFoo.GetSelfLink()]; got != 2 {
+		t.Errorf("pods-in-foo matched %d resources; want 2", got)
+	}
+	if got, ok := counts[servicesAnywhere.GetSelfLink()]; !ok || got != 0 {
+		t.Errorf("services-anywhere matched %d resources (reported: %v); want 0", got, ok)
+	}
+}
+
+func TestAuditDefaultEnforcementAction(t *testing.T) {
+	defer flag.Set("default-enforcement-action", "deny")
+	flag.Set("default-enforcement-action", "dryrun")
+
+	c, _ := makeOpaClient(t)
+	addTemplate(t, c, always_violate_template)
+	podsInFoo := addConstraint(t, c, pods_in_foo)
+	addObject(t, c, "Pod", "foo", "a")
+
+	resp, err := c.Audit(context.Background())
+	if err != nil {
+		t.Fatalf("Audit() err = %s", err)
+	}
+	updateLists, _, err := getUpdateListsFromAuditResponses(resp, labels.Everything())
+	if err != nil {
+		t.Fatalf("getUpdateListsFromAuditResponses() err = %s", err)
+	}
+	results := updateLists[podsInFoo.GetSelfLink()]
+	if len(results) != 1 {
+		t.Fatalf("got %d violations; want 1", len(results))
+	}
+	if got := results[0].enforcementAction; got != "dryrun" {
+		t.Errorf("enforcementAction = %s; want dryrun", got)
+	}
+}
+
+func TestAuditViolationCode(t *testing.T) {
+	c, _ := makeOpaClient(t)
+	addTemplate(t, c, always_violate_template)
+	addTemplate(t, c, coded_violation_template)
+	podsInFoo := addConstraint(t, c, pods_in_foo)
+	codedPods := addConstraint(t, c, coded_pods_anywhere)
+	addObject(t, c, "Pod", "foo", "a")
+
+	resp, err := c.Audit(context.Background())
+	if err != nil {
+		t.Fatalf("Audit() err = %s", err)
+	}
+	updateLists, totalViolations, err := getUpdateListsFromAuditResponses(resp, labels.Everything())
+	if err != nil {
+		t.Fatalf("getUpdateListsFromAuditResponses() err = %s", err)
+	}
+	report := newReport("", resp, labels.Everything(), updateLists, totalViolations, nil)
+	codes := make(map[string]string)
+	for _, cr := range report.Constraints {
+		for _, v := range cr.Violations {
+			codes[cr.Name] = v.Code
+		}
+	}
+	if got := codes[codedPods.GetName()]; got != "missing_owner" {
+		t.Errorf("%s violation code = %q; want missing_owner", codedPods.GetName(), got)
+	}
+	if got, ok := codes[podsInFoo.GetName()]; !ok || got != "" {
+		t.Errorf("%s violation code = %q (reported: %v); want none", podsInFoo.GetName(), got, ok)
+	}
+}
+
+func TestAuditDeterministicEval(t *testing.T) {
+	defer flag.Set("deterministic-eval", "false")
+	defer flag.Set("constraintViolationsLimit", "20")
+	flag.Set("deterministic-eval", "true")
+	flag.Set("constraintViolationsLimit", "2")
+
+	c, _ := makeOpaClient(t)
+	addTemplate(t, c, always_violate_template)
+	podsInFoo := addConstraint(t, c, pods_in_foo)
+	for _, name := range []string{"e", "c", "a", "d", "b"} {
+		addObject(t, c, "Pod", "foo", name)
+	}
+	resp, err := c.Audit(context.Background())
+	if err != nil {
+		t.Fatalf("Audit() err = %s", err)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		// the order in which the framework returns results varies between runs
+		for _, tr := range resp.ByTarget {
+			r.Shuffle(len(tr.Results), func(j, k int) { tr.Results[j], tr.Results[k] = tr.Results[k], tr.Results[j] })
+		}
+		updateLists, _, err := getUpdateListsFromAuditResponses(resp, labels.Everything())
+		if err != nil {
+			t.Fatalf("getUpdateListsFromAuditResponses() err = %s", err)
+		}
+		var names []string
+		for _, ar := range updateLists[podsInFoo.GetSelfLink()] {
+			names = append(names, ar.rname)
+		}
+		if want := []string{"a", "b"}; !reflect.DeepEqual(names, want) {
+			t.Fatalf("run %d: violations kept = %v; want %v", i, names, want)
+		}
+	}
+}
+
+func TestAuditViolationsLimit(t *testing.T) {
+	defer flag.Set("constraintViolationsLimit", "20")
+	flag.Set("constraintViolationsLimit", "2")
+
+	c, _ := makeOpaClient(t)
+	addTemplate(t, c, always_violate_template)
+	limits := map[string]int{"default": 2, "critical": 4, "noisy": 0}
+	var constraints []*unstructured.Unstructured
+	for _, name := range []string{"default", "critical", "noisy"} {
+		cstr := addConstraint(t, c, `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sAlwaysViolate
+metadata:
+  name: `+name+`
+  selfLink: /apis/constraints.gatekeeper.sh/v1beta1/k8salwaysviolate/`+name+`
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Pod"]
+`)
+		if name != "default" {
+			unstructured.SetNestedField(cstr.Object, int64(limits[name]), "spec", "auditViolationsLimit")
+			if _, err := c.AddConstraint(context.Background(), cstr); err != nil {
+				t.Fatalf("Could not add constraint: %s", err)
+			}
+		}
+		constraints = append(constraints, cstr)
+	}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		addObject(t, c, "Pod", "foo", name)
+	}
+
+	resp, err := c.Audit(context.Background())
+	if err != nil {
+		t.Fatalf("Audit() err = %s", err)
+	}
+	updateLists, totalViolations, err := getUpdateListsFromAuditResponses(resp, labels.Everything())
+	if err != nil {
+		t.Fatalf("getUpdateListsFromAuditResponses() err = %s", err)
+	}
+	for _, cstr := range constraints {
+		if got, want := len(updateLists[cstr.GetSelfLink()]), limits[cstr.GetName()]; got != want {
+			t.Errorf("%s reported %d violations; want %d", cstr.GetName(), got, want)
+		}
+		if got := totalViolations[cstr.GetSelfLink()]; got != 5 {
+			t.Errorf("%s has %d violations in total; want 5", cstr.GetName(), got)
+		}
+	}
+}
+
+func TestAuditConstraintSelector(t *testing.T) {
+	defer flag.Set("audit-constraint-selector", "")
+	flag.Set("audit-constraint-selector", "tier=critical")
+
+	c, driver := makeOpaClient(t)
+	am, err := New(context.Background(), nil, c, driver)
+	if err != nil {
+		t.Fatalf("New() err = %s", err)
+	}
+	addTemplate(t, c, always_violate_template)
+	podsInFoo := addConstraint(t, c, pods_in_foo)
+	criticalPods := addConstraint(t, c, critical_pods_anywhere)
+	addObject(t, c, "Pod", "foo", "a")
+	addObject(t, c, "Pod", "bar", "b")
+
+	resp, err := c.Audit(context.Background())
+	if err != nil {
+		t.Fatalf("Audit() err = %s", err)
+	}
+	updateLists, totalViolations, err := getUpdateListsFromAuditResponses(resp, am.selector)
+	if err != nil {
+		t.Fatalf("getUpdateListsFromAuditResponses() err = %s", err)
+	}
+	if got := totalViolations[criticalPods.GetSelfLink()]; got != 2 {
+		t.Errorf("critical-pods-anywhere has %d violations; want 2", got)
+	}
+	if _, ok := updateLists[podsInFoo.GetSelfLink()]; ok {
+		t.Errorf("pods-in-foo was audited but is not selected")
+	}
+
+	counts, err := getMatchCounts(context.Background(), driver, am.selector)
+	if err != nil {
+		t.Fatalf("getMatchCounts() err = %s", err)
+	}
+	if got := counts[criticalPods.GetSelfLink()]; got != 2 {
+		t.Errorf("critical-pods-anywhere matched %d resources; want 2", got)
+	}
+	if _, ok := counts[podsInFoo.GetSelfLink()]; ok {
+		t.Errorf("pods-in-foo match count was reported but it is not selected")
+	}
+}
+
+const disabled_pods_anywhere = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sAlwaysViolate
+metadata:
+  name: disabled-pods-anywhere
+  selfLink: /apis/constraints.gatekeeper.sh/v1beta1/k8salwaysviolate/disabled-pods-anywhere
+  labels:
+    tier: critical
+    gatekeeper.sh/disabled: "true"
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Pod"]
+`
+
+func TestAuditSkipsDisabledConstraints(t *testing.T) {
+	for _, s := range []string{"", "tier=critical"} {
+		t.Run(s, func(t *testing.T) {
+			defer flag.Set("audit-constraint-selector", "")
+			flag.Set("audit-constraint-selector", s)
+
+			c, driver := makeOpaClient(t)
+			am, err := New(context.Background(), nil, c, driver)
+			if err != nil {
+				t.Fatalf("New() err = %s", err)
+			}
+			addTemplate(t, c, always_violate_template)
+			// disabled constraints are not loaded by the constraint controller, but status
+			// must not be written for them either
+			disabled := addConstraint(t, c, disabled_pods_anywhere)
+			criticalPods := addConstraint(t, c, critical_pods_anywhere)
+			addObject(t, c, "Pod", "foo", "a")
+
+			resp, err := c.Audit(context.Background())
+			if err != nil {
+				t.Fatalf("Audit() err = %s", err)
+			}
+			updateLists, totalViolations, err := getUpdateListsFromAuditResponses(resp, am.selector)
+			if err != nil {
+				t.Fatalf("getUpdateListsFromAuditResponses() err = %s", err)
+			}
+			if got := totalViolations[criticalPods.GetSelfLink()]; got != 1 {
+				t.Errorf("critical-pods-anywhere has %d violations; want 1", got)
+			}
+			if _, ok := updateLists[disabled.GetSelfLink()]; ok {
+				t.Errorf("disabled-pods-anywhere was audited but is disabled")
+			}
+			if selected(am.selector, disabled) {
+				t.Errorf("selector %s selects disabled-pods-anywhere, whose status would be written", am.selector)
+			}
+		})
+	}
+}
+
+func TestInvalidAuditConstraintSelector(t *testing.T) {
+	defer flag.Set("audit-constraint-selector", "")
+	flag.Set("audit-constraint-selector", "tier in (critical")
+
+	c, driver := makeOpaClient(t)
+	if _, err := New(context.Background(), nil, c, driver); err == nil {
+		t.Errorf("New() err = nil; want an error for an invalid selector")
+	}
+}
+
+func TestAuditOnceExitCode(t *testing.T) {
+	tc := []struct {
+		Name          string
+		Action        string
+		Namespace     string
+		Deny          int64
+		ExitCode      int
+		NumViolations int
+	}{
+		{
+			Name:      "Clean",
+			Action:    "deny",
+			Namespace: "bar",
+			ExitCode:  0,
+		},
+		{
+			Name:          "Deny violations",
+			Action:        "deny",
+			Namespace:     "foo",
+			Deny:          1,
+			ExitCode:      2,
+			NumViolations: 1,
+		},
+		{
+			Name:          "Dryrun violations",
+			Action:        "dryrun",
+			Namespace:     "foo",
+			ExitCode:      0,
+			NumViolations: 1,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			defer flag.Set("default-enforcement-action", "deny")
+			flag.Set("default-enforcement-action", tt.Action)
+
+			c, _ := makeOpaClient(t)
+			addTemplate(t, c, always_violate_template)
+			podsInFoo := addConstraint(t, c, pods_in_foo)
+			addObject(t, c, "Pod", tt.Namespace, "a")
+
+			resp, err := c.Audit(context.Background())
+			if err != nil {
+				t.Fatalf("Audit() err = %s", err)
+			}
+			updateLists, totalViolations, err := getUpdateListsFromAuditResponses(resp, labels.Everything())
+			if err != nil {
+				t.Fatalf("getUpdateListsFromAuditResponses() err = %s", err)
+			}
+			totalMatches := map[string]int64{podsInFoo.GetSelfLink(): int64(tt.NumViolations)}
+			report := newReport("now", resp, labels.Everything(), updateLists, totalViolations, totalMatches)
+
+			if report.TotalViolations != int64(tt.NumViolations) {
+				t.Errorf("TotalViolations = %d; want %d", report.TotalViolations, tt.NumViolations)
+			}
+			if report.DenyViolations != tt.Deny {
+				t.Errorf("DenyViolations = %d; want %d", report.DenyViolations, tt.Deny)
+			}
+			if tt.NumViolations > 0 {
+				if len(report.Constraints) != 1 || report.Constraints[0].Name != "pods-in-foo" || len(report.Constraints[0].Violations) != tt.NumViolations {
+					t.Errorf("Constraints = %+v; want %d violations of pods-in-foo", report.Constraints, tt.NumViolations)
+				}
+			}
+			if got := ExitCode(report); got != tt.ExitCode {
+				t.Errorf("ExitCode() = %d; want %d", got, tt.ExitCode)
+			}
+		})
+	}
+
+	if got := ExitCode(nil); got != 1 {
+		t.Errorf("ExitCode(nil) = %d; want 1", got)
+	}
+}
+
+var _ client.Client = &constraintClient{}
+
+// constraintClient serves a single constraint and stores its updates
+type constraintClient struct {
+	obj *unstructured.Unstructured
+}
+
+func (c *constraintClient) Get(ctx context.Context, key client.ObjectKey, obj k8sruntime.Object) error {
+	obj.(*unstructured.Unstructured).Object = c.obj.DeepCopy().Object
+	return nil
+}
+
+func (c *constraintClient) List(ctx context.Context, opts *client.ListOptions, list k8sruntime.Object) error {
+	return nil
+}
+
+func (c *constraintClient) Create(ctx context.Context, obj k8sruntime.Object) error {
+	return nil
+}
+
+func (c *constraintClient) Delete(ctx context.Context, obj k8sruntime.Object, opts ...client.DeleteOptionFunc) error {
+	return nil
+}
+
+func (c *constraintClient) Update(ctx context.Context, obj k8sruntime.Object) error {
+	c.obj = obj.(*unstructured.Unstructured).DeepCopy()
+	return nil
+}
+
+func (c *constraintClient) Status() client.StatusWriter {
+	return c
+}
+
+func TestAuditIncrementalClear(t *testing.T) {
+	defer flag.Set("audit-incremental-clear", "false")
+	flag.Set("audit-incremental-clear", "true")
+	defer flag.Set("audit-id-format", "fields")
+
+	for _, format := range []string{"fields", "apiversion", "path", "object"} {
+		t.Run(format, func(t *testing.T) {
+			flag.Set("audit-id-format", format)
+			c, driver := makeOpaClient(t)
+			am, err := New(context.Background(), nil, c, driver)
+			if err != nil {
+				t.Fatalf("New() err = %s", err)
+			}
+			addTemplate(t, c, always_violate_template)
+			podsInFoo := addConstraint(t, c, pods_in_foo)
+			podA := addObject(t, c, "Pod", "foo", "a")
+			addObject(t, c, "Pod", "foo", "b")
+
+			resp, err := c.Audit(context.Background())
+			if err != nil {
+				t.Fatalf("Audit() err = %s", err)
+			}
+			updateLists, totalViolations, err := getUpdateListsFromAuditResponses(resp, labels.Everything())
+			if err != nil {
+				t.Fatalf("getUpdateListsFromAuditResponses() err = %s", err)
+			}
+			cc := &constraintClient{obj: podsInFoo.DeepCopy()}
+			ucloop := &updateConstraintLoop{client: cc}
+			selfLink := podsInFoo.GetSelfLink()
+			if err := ucloop.updateConstraintStatus(context.Background(), cc.obj, updateLists[selfLink], "now", totalViolations[selfLink], 2); err != nil {
+				t.Fatalf("updateConstraintStatus() err = %s", err)
+			}
+			am.audited.record(cc, nil, map[string]unstructured.Unstructured{selfLink: *podsInFoo}, updateLists)
+
+			// pod a is deleted before the next audit
+			if _, err := c.RemoveData(context.Background(), podA); err != nil {
+				t.Fatalf("Could not remove data: %s", err)
+			}
+			if err := am.clearViolations(podA); err != nil {
+				t.Fatalf("clearViolations() err = %s", err)
+			}
+
+			violations, _, err := unstructured.NestedSlice(cc.obj.Object, "status", "violations")
+			if err != nil {
+				t.Fatalf("could not read violations: %s", err)
+			}
+			if len(violations) != 1 || violationResourceID(violations[0].(map[string]interface{}), format).Name != "b" {
+				t.Errorf("violations = %v; want only the violation of pod b", violations)
+			}
+			if got, _, _ := unstructured.NestedInt64(cc.obj.Object, "status", "totalViolations"); got != 1 {
+				t.Errorf("totalViolations = %d; want 1", got)
+			}
+
+			// deleting the last violating resource removes the violations from status
+			am.clearViolations(addObject(t, c, "Pod", "foo", "b"))
+			if _, found, _ := unstructured.NestedSlice(cc.obj.Object, "status", "violations"); found {
+				t.Errorf("violations still listed after every violating resource was deleted")
+			}
+		})
+	}
+}
+
+func TestAuditIncrementalClearPendingUpdate(t *testing.T) {
+	defer flag.Set("audit-incremental-clear", "false")
+	flag.Set("audit-incremental-clear", "true")
+	defer flag.Set("audit-status-write-backoff", "1s")
+	flag.Set("audit-status-write-backoff", "1ms")
+	defer flag.Set("use-status-subresource", "auto")
+	flag.Set("use-status-subresource", "true")
+
+	c, driver := makeOpaClient(t)
+	am, err := New(context.Background(), nil, c, driver)
+	if err != nil {
+		t.Fatalf("New() err = %s", err)
+	}
+	addTemplate(t, c, always_violate_template)
+	podsInFoo := addConstraint(t, c, pods_in_foo)
+	podA := addObject(t, c, "Pod", "foo", "a")
+	addObject(t, c, "Pod", "foo", "b")
+	resp, err := c.Audit(context.Background())
+	if err != nil {
+		t.Fatalf("Audit() err = %s", err)
+	}
+	updateLists, totalViolations, err := getUpdateListsFromAuditResponses(resp, labels.Everything())
+	if err != nil {
+		t.Fatalf("getUpdateListsFromAuditResponses() err = %s", err)
+	}
+	selfLink := podsInFoo.GetSelfLink()
+
+	// pod a is deleted before the loop of the audit writes the status of the constraint
+	cc := &conflictingClient{constraintClient: &constraintClient{obj: podsInFoo.DeepCopy()}, failures: 1}
+	updateConstraints := map[string]unstructured.Unstructured{selfLink: *podsInFoo}
+	ucloop := &updateConstraintLoop{
+		uc:      map[string]unstructured.Unstructured{selfLink: *podsInFoo},
+		client:  cc,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		ul:      updateLists,
+		ts:      "now",
+		tv:      totalViolations,
+		tm:      map[string]int64{selfLink: 2},
+	}
+	am.audited.record(cc, ucloop, updateConstraints, updateLists)
+	if err := am.clearViolations(podA); err != nil {
+		t.Fatalf("clearViolations() err = %s", err)
+	}
+	ucloop.update()
+
+	violations, _, err := unstructured.NestedSlice(cc.obj.Object, "status", "violations")
+	if err != nil {
+		t.Fatalf("could not read violations: %s", err)
+	}
+	if len(violations) != 1 || violations[0].(map[string]interface{})["name"] != "b" {
+		t.Errorf("violations = %v; want the loop not to write back the violation of pod a", violations)
+	}
+	if got, _, _ := unstructured.NestedInt64(cc.obj.Object, "status", "totalViolations"); got != 1 {
+		t.Errorf("totalViolations = %d; want 1", got)
+	}
+
+	// a failed update is returned, and clearing again succeeds
+	podB := addObject(t, c, "Pod", "foo", "b")
+	cc.writes, cc.failures = 0, 1
+	if err := am.clearViolations(podB); err == nil {
+		t.Fatal("clearViolations() err = nil for a failed update; want the error")
+	}
+	if err := am.clearViolations(podB); err != nil {
+		t.Fatalf("clearViolations() err = %s when retried", err)
+	}
+	if _, found, _ := unstructured.NestedSlice(cc.obj.Object, "status", "violations"); found {
+		t.Errorf("violations still listed after the removal was retried")
+	}
+}
+
+// conflictingClient fails to write the status of its constraint a number of times before
+// storing it
+type conflictingClient struct {
+	*constraintClient
+	failures int
+	writes   int
+}
+
+func (c *conflictingClient) Update(ctx context.Context, obj k8sruntime.Object) error {
+	c.writes++
+	if c.writes <= c.failures {
+		return apierrors.NewConflict(schema.GroupResource{Group: "constraints.gatekeeper.sh", Resource: "k8salwaysviolate"}, "pods-in-foo", errors.New("the object has been modified"))
+	}
+	return c.constraintClient.Update(ctx, obj)
+}
+
+func (c *conflictingClient) Status() client.StatusWriter {
+	return c
+}
+
+func TestUpdateConstraintStatusRetries(t *testing.T) {
+	defer flag.Set("audit-status-write-backoff", "1s")
+	flag.Set("audit-status-write-backoff", "1ms")
+	defer flag.Set("use-status-subresource", "auto")
+	flag.Set("use-status-subresource", "true")
+
+	failures := func() float64 {
+		m := &dto.Metric{}
+		if err := statusWriteFailuresTotal.WithLabelValues("conflict").Write(m); err != nil {
+			t.Fatalf("Could not read metric: %s", err)
+		}
+		return m.GetCounter().GetValue()
+	}
+
+	tc := []struct {
+		Name     string
+		Attempts string
+		Failures int
+		Written  bool
+	}{
+		{Name: "succeeds after retries", Attempts: "5", Failures: 2, Written: true},
+		{Name: "gives up after attempts", Attempts: "2", Failures: 2, Written: false},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			defer flag.Set("audit-status-write-attempts", "5")
+			flag.Set("audit-status-write-attempts", tt.Attempts)
+			c, _ := makeOpaClient(t)
+			addTemplate(t, c, always_violate_template)
+			podsInFoo := addConstraint(t, c, pods_in_foo)
+			addObject(t, c, "Pod", "foo", "a")
+			resp, err := c.Audit(context.Background())
+			if err != nil {
+				t.Fatalf("Audit() err = %s", err)
+			}
+			updateLists, totalViolations, err := getUpdateListsFromAuditResponses(resp, labels.Everything())
+			if err != nil {
+				t.Fatalf("getUpdateListsFromAuditResponses() err = %s", err)
+			}
+			selfLink := podsInFoo.GetSelfLink()
+
+			cc := &conflictingClient{constraintClient: &constraintClient{obj: podsInFoo.DeepCopy()}, failures: tt.Failures}
+			ucloop := &updateConstraintLoop{
+				uc:      map[string]unstructured.Unstructured{selfLink: *podsInFoo},
+				client:  cc,
+				stop:    make(chan struct{}),
+				stopped: make(chan struct{}),
+				ul:      updateLists,
+				ts:      "now",
+				tv:      totalViolations,
+				tm:      map[string]int64{selfLink: 1},
+			}
+			before := failures()
+			ucloop.update()
+
+			if got := failures() - before; got != float64(tt.Failures) {
+				t.Errorf("recorded %v status write failures; want %d", got, tt.Failures)
+			}
+			if got := len(ucloop.uc) == 0; got != tt.Written {
+				t.Errorf("status written = %v; want %v", got, tt.Written)
+			}
+			ts, _, _ := unstructured.NestedString(cc.obj.Object, "status", "auditTimestamp")
+			if !tt.Written {
+				if ts != "" {
+					t.Errorf("auditTimestamp = %q; want the status left unwritten", ts)
+				}
+				return
+			}
+			if ts != "now" {
+				t.Errorf("auditTimestamp = %q; want now", ts)
+			}
+			if got, _, _ := unstructured.NestedInt64(cc.obj.Object, "status", "totalViolations"); got != 1 {
+				t.Errorf("totalViolations = %d; want 1", got)
+			}
+			violations, _, _ := unstructured.NestedSlice(cc.obj.Object, "status", "violations")
+			if len(violations) != 1 || violations[0].(map[string]interface{})["name"] != "a" {
+				t.Errorf("violations = %v; want the violation of pod a", violations)
+			}
+		})
+	}
+}
+
+func TestUnchangedStatusNotWritten(t *testing.T) {
+	defer flag.Set("use-status-subresource", "auto")
+	flag.Set("use-status-subresource", "true")
+
+	skipped := func() float64 {
+		m := &dto.Metric{}
+		if err := statusWritesSkippedTotal.Write(m); err != nil {
+			t.Fatalf("Could not read metric: %s", err)
+		}
+		return m.GetCounter().GetValue()
+	}
+
+	c, _ := makeOpaClient(t)
+	addTemplate(t, c, always_violate_template)
+	podsInFoo := addConstraint(t, c, pods_in_foo)
+	selfLink := podsInFoo.GetSelfLink()
+	cc := &conflictingClient{constraintClient: &constraintClient{obj: podsInFoo.DeepCopy()}}
+	audit := func(timestamp string) {
+		resp, err := c.Audit(context.Background())
+		if err != nil {
+			t.Fatalf("Audit() err = %s", err)
+		}
+		updateLists, totalViolations, err := getUpdateListsFromAuditResponses(resp, labels.Everything())
+		if err != nil {
+			t.Fatalf("getUpdateListsFromAuditResponses() err = %s", err)
+		}
+		// store the status as the API server would, with the violations decoded from JSON
+		raw, err := cc.obj.MarshalJSON()
+		if err != nil {
+			t.Fatalf("Could not encode constraint: %s", err)
+		}
+		if err := cc.obj.UnmarshalJSON(raw); err != nil {
+			t.Fatalf("Could not decode constraint: %s", err)
+		}
+		ucloop := &updateConstraintLoop{
+			uc:      map[string]unstructured.Unstructured{selfLink: *podsInFoo},
+			client:  cc,
+			stop:    make(chan struct{}),
+			stopped: make(chan struct{}),
+			ul:      updateLists,
+			ts:      timestamp,
+			tv:      totalViolations,
+			tm:      map[string]int64{selfLink: totalViolations[selfLink]},
+		}
+		ucloop.update()
+	}
+	timestamp := func() string {
+		ts, _, _ := unstructured.NestedString(cc.obj.Object, "status", "auditTimestamp")
+		return ts
+	}
+
+	addObject(t, c, "Pod", "foo", "a")
+	audit("first")
+	if cc.writes != 1 || timestamp() != "first" {
+		t.Fatalf("first audit: %d writes, auditTimestamp %q; want 1 write at first", cc.writes, timestamp())
+	}
+
+	before := skipped()
+	audit("second")
+	if cc.writes != 1 {
+		t.Errorf("unchanged audit: %d writes; want no new write", cc.writes)
+	}
+	if timestamp() != "first" {
+		t.Errorf("unchanged audit: auditTimestamp = %q; want first", timestamp())
+	}
+	if got := skipped() - before; got != 1 {
+		t.Errorf("recorded %v skipped writes; want 1", got)
+	}
+
+	addObject(t, c, "Pod", "foo", "b")
+	audit("third")
+	if cc.writes != 2 || timestamp() != "third" {
+		t.Errorf("changed audit: %d writes, auditTimestamp %q; want 2 writes at third", cc.writes, timestamp())
+	}
+	if got, _, _ := unstructured.NestedInt64(cc.obj.Object, "status", "totalViolations"); got != 2 {
+		t.Errorf("totalViolations = %d; want 2", got)
+	}
+}
+
+var _ client.Client = &configClient{}
+
+// configClient serves a single Config and stores its updates
+type configClient struct {
+	mux sync.Mutex
+	cfg *configv1alpha1.Config
+}
+
+func (c *configClient) Get(ctx context.Context, key client.ObjectKey, obj k8sruntime.Object) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.cfg.DeepCopyInto(obj.(*configv1alpha1.Config))
+	return nil
+}
+
+func (c *configClient) List(ctx context.Context, opts *client.ListOptions, list k8sruntime.Object) error {
+	return nil
+}
+
+func (c *configClient) Create(ctx context.Context, obj k8sruntime.Object) error {
+	return nil
+}
+
+func (c *configClient) Delete(ctx context.Context, obj k8sruntime.Object, opts ...client.DeleteOptionFunc) error {
+	return nil
+}
+
+func (c *configClient) Update(ctx context.Context, obj k8sruntime.Object) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.cfg = obj.(*configv1alpha1.Config).DeepCopy()
+	return nil
+}
+
+func (c *configClient) Status() client.StatusWriter {
+	return c
+}
+
+func (c *configClient) annotated() bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	_, ok := c.cfg.GetAnnotations()[AuditNowAnnotation]
+	return ok
+}
+
+func TestAuditOnceWaitsForSync(t *testing.T) {
+	defer flag.Set("auditInterval", "60")
+	flag.Set("auditInterval", "1")
+	defer func(interval time.Duration) { syncPollInterval = interval }(syncPollInterval)
+	syncPollInterval = 10 * time.Millisecond
+
+	c, driver := makeOpaClient(t)
+	am, err := New(context.Background(), nil, c, driver)
+	if err != nil {
+		t.Fatalf("New() err = %s", err)
+	}
+	podGvk := schema.GroupVersionKind{Version: "v1", Kind: "Pod"}
+
+	// the kind is synced after a few checks
+	checks := 0
+	am.unsynced = func() []schema.GroupVersionKind {
+		checks++
+		if checks < 3 {
+			return []schema.GroupVersionKind{podGvk}
+		}
+		return nil
+	}
+	if err := am.waitForSync(context.Background()); err != nil {
+		t.Errorf("waitForSync() err = %s; want nil once the kind is synced", err)
+	}
+	if checks != 3 {
+		t.Errorf("synced kinds checked %d times; want 3", checks)
+	}
+
+	// the kind is never synced, so the single audit does not run and the process exits with 1
+	am.unsynced = func() []schema.GroupVersionKind { return []schema.GroupVersionKind{podGvk} }
+	if err := am.waitForSync(context.Background()); err == nil {
+		t.Error("waitForSync() err = nil; want an error for the unsynced kind")
+	}
+	am.reports = make(chan *Report, 1)
+	am.schedule = nil
+	flag.Set("auditInterval", "0")
+	done := make(chan struct{})
+	go func() {
+		am.auditManagerLoop(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("single audit still waiting for the unsynced kind")
+	}
+	if report := <-am.reports; ExitCode(report) != 1 {
+		t.Errorf("ExitCode() = %d; want 1 when kinds are not synced", ExitCode(report))
+	}
+}
+
+func TestAuditNowAnnotation(t *testing.T) {
+	defer flag.Set("auditInterval", "60")
+	flag.Set("auditInterval", "3600")
+
+	c, driver := makeOpaClient(t)
+	am, err := New(context.Background(), nil, c, driver)
+	if err != nil {
+		t.Fatalf("New() err = %s", err)
+	}
+	cfg := &configv1alpha1.Config{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: util.GetNamespace()}}
+	cc := &configClient{cfg: cfg.DeepCopy()}
+	handler := am.configEventHandler(cc)
+
+	// neither an unannotated Config nor another object triggers an audit
+	handler.OnUpdate(cfg, cfg)
+	other := cfg.DeepCopy()
+	other.SetName("other")
+	other.SetAnnotations(map[string]string{AuditNowAnnotation: "true"})
+	handler.OnUpdate(cfg, other)
+	if len(am.trigger) != 0 {
+		t.Fatal("audit requested without the annotation on the Config")
+	}
+
+	annotated := cfg.DeepCopy()
+	annotated.SetAnnotations(map[string]string{AuditNowAnnotation: "true"})
+	cc.Update(context.Background(), annotated)
+	handler.OnUpdate(cfg, annotated)
+
+	waited := make(chan struct{})
+	go func() {
+		am.waitForNextAudit(context.Background())
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("annotating the Config did not start an audit before the audit interval passed")
+	}
+
+	for i := 0; cc.annotated(); i++ {
+		if i == 50 {
+			t.Fatal("audit request annotation not removed from the Config")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
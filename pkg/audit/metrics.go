@@ -0,0 +1,38 @@
+package audit
+
+import (
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	matchedTotal = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gatekeeper_audit_matched_total",
+		Help: "Number of cached resources matched by a constraint during the last audit cycle",
+	}, []string{"constraint_kind", "constraint_name"})
+
+	statusWriteFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gatekeeper_audit_status_write_failures_total",
+		Help: "Number of failed attempts to write the audit results to the status of a constraint, by reason: conflict, throttled or error",
+	}, []string{"reason"})
+
+	statusWritesSkippedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gatekeeper_audit_status_writes_skipped_total",
+		Help: "Number of constraint status writes skipped by audit as the violations and totals were unchanged",
+	})
+
+	exportDroppedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gatekeeper_audit_export_dropped_total",
+		Help: "Number of audit violations that could not be exported with --audit-export-url, as the export buffer was full or the service could not be reached",
+	})
+)
+
+func init() {
+	util.AddMetrics(
+		"audit",
+		matchedTotal,
+		statusWriteFailuresTotal,
+		statusWritesSkippedTotal,
+		exportDroppedTotal,
+	)
+}
@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	constraintTypes "github.com/open-policy-agent/frameworks/constraint/pkg/types"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var auditOpaPriority = flag.String("audit-opa-priority", "normal", "priority of audit queries to OPA relative to admission reviews, either normal or low. low audits one kind at a time and waits for the admission reviews in flight before each kind, keeping admission latency low during large audits at the cost of longer audits ")
+
+// maxAdmissionWait bounds how long a low priority audit waits for admission reviews before
+// each query, so steady admission traffic slows audit down without stalling it
+const maxAdmissionWait = time.Second
+
+// validatePriority returns an error if priority is not a supported --audit-opa-priority
+func validatePriority(priority string) error {
+	switch priority {
+	case "normal", "low":
+		return nil
+	}
+	return fmt.Errorf("unknown priority %q, must be normal or low", priority)
+}
+
+// cachedKinds returns the kinds of the cached resources, in order
+func (am *AuditManager) cachedKinds(ctx context.Context) ([]schema.GroupKind, error) {
+	resp, err := am.driver.Query(ctx, fmt.Sprintf(`hooks["%s"].library.cached_kinds`, (&target.K8sValidationTarget{}).GetName()), nil)
+	if err != nil {
+		return nil, err
+	}
+	var gks []schema.GroupKind
+	seen := make(map[schema.GroupKind]bool)
+	for _, r := range resp.Results {
+		group, _ := r.Metadata["group"].(string)
+		kind, _ := r.Metadata["kind"].(string)
+		gk := schema.GroupKind{Group: group, Kind: kind}
+		if !seen[gk] {
+			seen[gk] = true
+			gks = append(gks, gk)
+		}
+	}
+	sortGroupKinds(gks)
+	return gks, nil
+}
+
+// auditEachKind audits the cached resources of the kinds in scope one kind at a time, giving
+// way to admission reviews. The audit of a kind waits for the admission reviews in flight and
+// is interrupted and retried when one starts, until it has given way for maxAdmissionWait.
+func (am *AuditManager) auditEachKind(ctx context.Context, scope auditScope) (*constraintTypes.Responses, error) {
+	gks, err := am.cachedKinds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	t := &target.K8sValidationTarget{}
+	merged := &constraintTypes.Response{Target: t.GetName()}
+	for _, gk := range gks {
+		if !scope.includes(gk) {
+			continue
+		}
+		resp, err := am.auditKindGivingWay(ctx, gk)
+		if err != nil {
+			return nil, err
+		}
+		resp = skipTerminating(am.system.skip(resp))
+		progress.kindAudited(gk, resp.Results())
+		merged.Results = append(merged.Results, resp.Results()...)
+	}
+	responses := constraintTypes.NewResponses()
+	responses.ByTarget[t.GetName()] = merged
+	return responses, nil
+}
+
+// auditKindGivingWay audits the cached resources of kind gk, giving way to admission reviews
+// for at most maxAdmissionWait
+func (am *AuditManager) auditKindGivingWay(ctx context.Context, gk schema.GroupKind) (*constraintTypes.Responses, error) {
+	scope := auditScope{kinds: []schema.GroupKind{gk}}
+	deadline := time.Now().Add(maxAdmissionWait)
+	for {
+		if err := util.WaitForAdmissions(ctx, time.Until(deadline)); err != nil {
+			return nil, err
+		}
+		if !time.Now().Before(deadline) {
+			return am.auditKinds(ctx, scope)
+		}
+		preemptible, cancel := util.PreemptedByAdmission(ctx)
+		resp, err := am.auditKinds(preemptible, scope)
+		preempted := preemptible.Err() != nil && ctx.Err() == nil
+		cancel()
+		if !preempted {
+			return resp, err
+		}
+	}
+}
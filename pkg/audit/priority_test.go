@@ -0,0 +1,148 @@
+package audit
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestAuditEachKind(t *testing.T) {
+	c, driver := makeOpaClient(t)
+	addTemplate(t, c, always_violate_template)
+	addConstraint(t, c, pods_in_foo)
+	addConstraint(t, c, services_anywhere)
+	addObject(t, c, "Pod", "foo", "a")
+	addObject(t, c, "Pod", "bar", "b")
+	addObject(t, c, "Service", "foo", "s")
+	addObject(t, c, "ConfigMap", "foo", "cm")
+	am := &AuditManager{opa: c, driver: driver}
+
+	gks, err := am.cachedKinds(context.Background())
+	if err != nil {
+		t.Fatalf("cachedKinds() err = %s", err)
+	}
+	if want := []schema.GroupKind{{Kind: "ConfigMap"}, podKind, serviceKind}; fmt.Sprint(gks) != fmt.Sprint(want) {
+		t.Errorf("cachedKinds() = %v; want %v", gks, want)
+	}
+
+	all, err := am.opa.Audit(context.Background())
+	if err != nil {
+		t.Fatalf("Audit() err = %s", err)
+	}
+	resp, err := am.auditEachKind(context.Background(), fullAudit)
+	if err != nil {
+		t.Fatalf("auditEachKind() err = %s", err)
+	}
+	if got, want := len(resp.Results()), len(all.Results()); got != want {
+		t.Errorf("auditEachKind() found %d violations; want %d as found by Audit()", got, want)
+	}
+	resp, err = am.auditEachKind(context.Background(), auditScope{kinds: []schema.GroupKind{serviceKind}, exclude: true})
+	if err != nil {
+		t.Fatalf("auditEachKind() err = %s", err)
+	}
+	if got := len(resp.Results()); got != 1 {
+		t.Errorf("audit of kinds other than Service found %d violations; want 1", got)
+	}
+}
+
+func TestAuditEachKindWaitsForAdmission(t *testing.T) {
+	c, driver := makeOpaClient(t)
+	addTemplate(t, c, always_violate_template)
+	addConstraint(t, c, pods_in_foo)
+	addObject(t, c, "Pod", "foo", "a")
+	am := &AuditManager{opa: c, driver: driver}
+
+	admissionDone := util.AdmissionStarted()
+	audited := make(chan error, 1)
+	go func() {
+		_, err := am.auditEachKind(context.Background(), fullAudit)
+		audited <- err
+	}()
+	select {
+	case <-audited:
+		t.Fatal("audit ran while an admission review was in flight")
+	case <-time.After(100 * time.Millisecond):
+	}
+	admissionDone()
+	select {
+	case err := <-audited:
+		if err != nil {
+			t.Errorf("auditEachKind() err = %s", err)
+		}
+	case <-time.After(maxAdmissionWait / 2):
+		t.Error("audit did not resume once the admission review was done")
+	}
+}
+
+func TestInvalidAuditOpaPriority(t *testing.T) {
+	defer flag.Set("audit-opa-priority", "normal")
+	flag.Set("audit-opa-priority", "high")
+
+	c, driver := makeOpaClient(t)
+	if _, err := New(context.Background(), nil, c, driver); err == nil {
+		t.Errorf("New() err = nil; want an error for an unknown priority")
+	}
+}
+
+// BenchmarkAdmissionDuringAudit measures the latency of admission reviews while audits run
+// continuously against the same OPA client, with and without --audit-opa-priority=low. Run
+// it with -benchtime=200x, as the time between requests does not count towards ns/op.
+func BenchmarkAdmissionDuringAudit(b *testing.B) {
+	for _, priority := range []string{"normal", "low"} {
+		b.Run(priority, func(b *testing.B) {
+			c, driver := makeOpaClient(b)
+			addTemplate(b, c, always_violate_template)
+			addConstraint(b, c, pods_in_foo)
+			addConstraint(b, c, services_anywhere)
+			for i := 0; i < 200; i++ {
+				for _, kind := range []string{"Pod", "Service", "ConfigMap", "Secret"} {
+					addObject(b, c, kind, "foo", fmt.Sprintf("obj-%d", i))
+				}
+			}
+			am := &AuditManager{opa: c, driver: driver}
+			review := &admissionv1beta1.AdmissionRequest{
+				Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Name:      "pod",
+				Namespace: "foo",
+				Operation: admissionv1beta1.Create,
+				Object:    k8sruntime.RawExtension{Raw: []byte(`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "pod", "namespace": "foo"}}`)},
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			audits := make(chan struct{})
+			go func() {
+				defer close(audits)
+				for ctx.Err() == nil {
+					if priority == "low" {
+						am.auditEachKind(ctx, fullAudit)
+					} else {
+						am.opa.Audit(ctx)
+					}
+				}
+			}()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// admission requests arrive apart, leaving the audit time to run
+				b.StopTimer()
+				time.Sleep(10 * time.Millisecond)
+				b.StartTimer()
+				done := util.AdmissionStarted()
+				if _, err := c.Review(context.Background(), review); err != nil {
+					b.Fatalf("Review() err = %s", err)
+				}
+				done()
+			}
+			b.StopTimer()
+			cancel()
+			<-audits
+		})
+	}
+}
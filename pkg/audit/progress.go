@@ -0,0 +1,195 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	constraintTypes "github.com/open-policy-agent/frameworks/constraint/pkg/types"
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// progressBufferSize is the number of events buffered for each client of the progress
+	// stream. A client falling further behind is disconnected, so that audit never waits for it.
+	progressBufferSize = 1000
+	// progressKeepAlive is how often a comment is sent to idle clients, so that proxies do not
+	// close the connection between audits
+	progressKeepAlive = 30 * time.Second
+)
+
+// ProgressViolation is a violation published while an audit is in progress
+type ProgressViolation struct {
+	ConstraintKind      string          `json:"constraintKind"`
+	ConstraintName      string          `json:"constraintName"`
+	ConstraintNamespace string          `json:"constraintNamespace,omitempty"`
+	Violation           StatusViolation `json:"violation"`
+}
+
+// KindProgress is published once the resources of a kind have been audited, when kinds are
+// audited one at a time
+type KindProgress struct {
+	Group      string `json:"group"`
+	Kind       string `json:"kind"`
+	Violations int    `json:"violations"`
+}
+
+type progressEvent struct {
+	name string
+	data interface{}
+}
+
+// progressStream publishes the progress of audits to the clients of ProgressHandler
+type progressStream struct {
+	mux         sync.Mutex
+	subscribers map[chan progressEvent]bool
+}
+
+var progress = &progressStream{subscribers: make(map[chan progressEvent]bool)}
+
+func (p *progressStream) subscribe() chan progressEvent {
+	ch := make(chan progressEvent, progressBufferSize)
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.subscribers[ch] = true
+	return ch
+}
+
+// unsubscribe stops publishing to ch, unless it was already dropped for falling behind
+func (p *progressStream) unsubscribe(ch chan progressEvent) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.subscribers[ch] {
+		delete(p.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (p *progressStream) subscribed() int {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return len(p.subscribers)
+}
+
+// publish sends an event to every subscriber without waiting. Subscribers whose buffer is full
+// are dropped, which closes their channel.
+func (p *progressStream) publish(name string, data interface{}) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	for ch := range p.subscribers {
+		select {
+		case ch <- progressEvent{name: name, data: data}:
+		default:
+			delete(p.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (p *progressStream) started(timestamp string) {
+	p.publish("started", map[string]string{"timestamp": timestamp})
+}
+
+func (p *progressStream) kindAudited(gk schema.GroupKind, results []*constraintTypes.Result) {
+	p.violations(results)
+	p.publish("kind", KindProgress{Group: gk.Group, Kind: gk.Kind, Violations: len(results)})
+}
+
+// violations publishes results. Their enforcementAction is computed from results alone, so for
+// constraints with a violationThreshold it can differ from the one written to their status.
+func (p *progressStream) violations(results []*constraintTypes.Result) {
+	if p.subscribed() == 0 {
+		return
+	}
+	// the results are not changed, as their enforcementAction is set again once every kind
+	// has been audited
+	copies := make([]*constraintTypes.Result, 0, len(results))
+	for _, r := range results {
+		c := *r
+		copies = append(copies, &c)
+	}
+	util.ResolveEnforcementActions(copies)
+	for _, r := range copies {
+		resource, ok := r.Resource.(*unstructured.Unstructured)
+		if !ok || r.Constraint == nil {
+			continue
+		}
+		message := r.Msg
+		if len(message) > msgSize {
+			message = truncateString(message, msgSize)
+		}
+		p.publish("violation", ProgressViolation{
+			ConstraintKind:      r.Constraint.GetKind(),
+			ConstraintName:      r.Constraint.GetName(),
+			ConstraintNamespace: r.Constraint.GetNamespace(),
+			Violation: StatusViolation{
+				APIVersion:        resource.GetAPIVersion(),
+				Kind:              resource.GetKind(),
+				Name:              resource.GetName(),
+				Namespace:         resource.GetNamespace(),
+				Message:           message,
+				Code:              util.ViolationCode(r),
+				EnforcementAction: r.EnforcementAction,
+			},
+		})
+	}
+}
+
+func (p *progressStream) completed(report *Report) {
+	p.publish("completed", summarize(report))
+}
+
+func (p *progressStream) failed(err error) {
+	p.publish("failed", map[string]string{"error": err.Error()})
+}
+
+// ProgressHandler streams the progress of audits as server-sent events, from the next audit on:
+// a started event with the timestamp of each audit, a violation event for each violation as it
+// is found, a kind event as each kind is audited with --audit-opa-priority=low, and a completed
+// event with the violation counts of the audit, or a failed event. The stream ends when the
+// client disconnects, or if it falls more than progressBufferSize events behind.
+func ProgressHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+		ch := progress.subscribe()
+		defer progress.unsubscribe(ch)
+		keepAlive := time.NewTicker(progressKeepAlive)
+		defer keepAlive.Stop()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+			case e, ok := <-ch:
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(e.data)
+				if err != nil {
+					log.Error(err, "unable to encode audit progress")
+					continue
+				}
+				if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.name, data); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	})
+}
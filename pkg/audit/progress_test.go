@@ -0,0 +1,145 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+type sseEvent struct {
+	name string
+	data string
+}
+
+// readEvents sends the events read from the stream of resp to events until the stream ends
+func readEvents(resp *http.Response, events chan<- sseEvent) {
+	defer close(events)
+	scanner := bufio.NewScanner(resp.Body)
+	var e sseEvent
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			e.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			e.data = strings.TrimPrefix(line, "data: ")
+		case line == "" && e.name != "":
+			events <- e
+			e = sseEvent{}
+		}
+	}
+}
+
+// waitForSubscribers waits until the progress stream has n subscribers
+func waitForSubscribers(t *testing.T, n int) {
+	for i := 0; progress.subscribed() != n; i++ {
+		if i == 100 {
+			t.Fatalf("progress stream has %d subscribers; want %d", progress.subscribed(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAuditProgressStream(t *testing.T) {
+	defer flag.Set("audit-opa-priority", "normal")
+	c, driver := makeOpaClient(t)
+	addTemplate(t, c, always_violate_template)
+	addConstraint(t, c, pods_in_foo)
+	addConstraint(t, c, services_anywhere)
+	addObject(t, c, "Pod", "foo", "a")
+	addObject(t, c, "Pod", "bar", "b")
+	addObject(t, c, "Service", "foo", "s")
+	am := &AuditManager{opa: c, driver: driver, selector: labels.Everything()}
+	srv := httptest.NewServer(ProgressHandler())
+	defer srv.Close()
+
+	tc := []struct {
+		Priority string
+		Events   []string
+	}{
+		{Priority: "normal", Events: []string{"started", "violation", "violation", "completed"}},
+		{Priority: "low", Events: []string{"started", "violation", "kind", "violation", "kind", "completed"}},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Priority, func(t *testing.T) {
+			flag.Set("audit-opa-priority", tt.Priority)
+			resp, err := http.Get(srv.URL)
+			if err != nil {
+				t.Fatalf("GET err = %s", err)
+			}
+			if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+				t.Errorf("Content-Type = %q; want text/event-stream", ct)
+			}
+			events := make(chan sseEvent, 100)
+			go readEvents(resp, events)
+			waitForSubscribers(t, 1)
+
+			report, _, _, _, err := am.evaluate(context.Background(), fullAudit, "2020-01-01T00:00:00Z")
+			if err != nil {
+				t.Fatalf("evaluate() err = %s", err)
+			}
+			var names []string
+			var violated []string
+			var summary AuditSummary
+			for e := range events {
+				names = append(names, e.name)
+				switch e.name {
+				case "violation":
+					var v ProgressViolation
+					if err := json.Unmarshal([]byte(e.data), &v); err != nil {
+						t.Fatalf("invalid violation %s: %s", e.data, err)
+					}
+					violated = append(violated, v.ConstraintName+" "+v.Violation.Kind+" "+v.Violation.Namespace+"/"+v.Violation.Name)
+				case "completed":
+					if err := json.Unmarshal([]byte(e.data), &summary); err != nil {
+						t.Fatalf("invalid summary %s: %s", e.data, err)
+					}
+				}
+				if e.name == "completed" {
+					break
+				}
+			}
+			if strings.Join(names, ",") != strings.Join(tt.Events, ",") {
+				t.Errorf("events = %v; want %v", names, tt.Events)
+			}
+			sort.Strings(violated)
+			if want := []string{"pods-in-foo Pod foo/a", "services-anywhere Service foo/s"}; strings.Join(violated, ",") != strings.Join(want, ",") {
+				t.Errorf("violations = %v; want %v", violated, want)
+			}
+			if summary.Timestamp != report.Timestamp || summary.TotalViolations != report.TotalViolations {
+				t.Errorf("completed = %+v; want the summary of %+v", summary, report)
+			}
+
+			// disconnecting ends the stream and unsubscribes
+			resp.Body.Close()
+			waitForSubscribers(t, 0)
+		})
+	}
+}
+
+func TestAuditProgressSlowClient(t *testing.T) {
+	ch := progress.subscribe()
+	defer progress.unsubscribe(ch)
+	for i := 0; i <= progressBufferSize; i++ {
+		progress.started("2020-01-01T00:00:00Z")
+	}
+	if progress.subscribed() != 0 {
+		t.Errorf("slow client still subscribed")
+	}
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != progressBufferSize {
+		t.Errorf("slow client received %d events before its stream ended; want %d", n, progressBufferSize)
+	}
+}
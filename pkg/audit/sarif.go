@@ -0,0 +1,161 @@
+package audit
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+)
+
+var auditOutput = flag.String("audit-output", "json", "format of the report written to stdout by --audit-once, either json or sarif. sarif writes a SARIF 2.1.0 log with a rule per constraint kind and a result per violation ")
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://raw.githubusercontent.com/oasis-tcs/sarif-spec/master/Schemata/sarif-schema-2.1.0.json"
+)
+
+// validateOutput returns an error if format is not a supported --audit-output
+func validateOutput(format string) error {
+	switch format {
+	case "json", "sarif":
+		return nil
+	}
+	return fmt.Errorf("unknown format %q, must be json or sarif", format)
+}
+
+// WriteReport writes report to w in the format selected by --audit-output
+func WriteReport(w io.Writer, report *Report) error {
+	var out interface{} = report
+	switch *auditOutput {
+	case "json":
+	case "sarif":
+		out = newSarifLog(report)
+	default:
+		return validateOutput(*auditOutput)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID     string                 `json:"ruleId"`
+	RuleIndex  int                    `json:"ruleIndex"`
+	Level      string                 `json:"level"`
+	Message    sarifMessage           `json:"message"`
+	Locations  []sarifLocation        `json:"locations"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type sarifLocation struct {
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifLogicalLocation struct {
+	Name               string `json:"name"`
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+// newSarifLog converts report to a SARIF log. Each constraint kind is a rule and each
+// violation a result located at the violating resource, identified as
+// [<namespace>/]<kind>/<name>. Violations of deny constraints are errors, all others warnings.
+func newSarifLog(report *Report) *sarifLog {
+	ruleIndex := make(map[string]int)
+	var kinds []string
+	for _, cr := range report.Constraints {
+		if _, ok := ruleIndex[cr.Kind]; !ok {
+			ruleIndex[cr.Kind] = 0
+			kinds = append(kinds, cr.Kind)
+		}
+	}
+	sort.Strings(kinds)
+	rules := []sarifRule{}
+	for i, kind := range kinds {
+		ruleIndex[kind] = i
+		rules = append(rules, sarifRule{
+			ID:               kind,
+			ShortDescription: sarifMessage{Text: fmt.Sprintf("violations of %s constraints", kind)},
+		})
+	}
+
+	results := []sarifResult{}
+	for _, cr := range report.Constraints {
+		for _, v := range cr.Violations {
+			level := "warning"
+			if v.EnforcementAction == "deny" {
+				level = "error"
+			}
+			resource := v.Kind + "/" + v.Name
+			if v.Namespace != "" {
+				resource = v.Namespace + "/" + resource
+			}
+			properties := map[string]interface{}{
+				"constraint":        cr.Name,
+				"enforcementAction": v.EnforcementAction,
+			}
+			if cr.Namespace != "" {
+				properties["constraintNamespace"] = cr.Namespace
+			}
+			if v.Code != "" {
+				properties["code"] = v.Code
+			}
+			results = append(results, sarifResult{
+				RuleID:    cr.Kind,
+				RuleIndex: ruleIndex[cr.Kind],
+				Level:     level,
+				Message:   sarifMessage{Text: v.Message},
+				Locations: []sarifLocation{{LogicalLocations: []sarifLogicalLocation{{
+					Name:               v.Name,
+					FullyQualifiedName: resource,
+					Kind:               "resource",
+				}}}},
+				Properties: properties,
+			})
+		}
+	}
+
+	return &sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "gatekeeper",
+				InformationURI: "https://github.com/open-policy-agent/gatekeeper",
+				Rules:          rules,
+			}},
+			Results: results,
+		}},
+	}
+}
@@ -0,0 +1,172 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"testing"
+)
+
+const expectedSarif = `{
+  "$schema": "https://raw.githubusercontent.com/oasis-tcs/sarif-spec/master/Schemata/sarif-schema-2.1.0.json",
+  "version": "2.1.0",
+  "runs": [
+    {
+      "tool": {
+        "driver": {
+          "name": "gatekeeper",
+          "informationUri": "https://github.com/open-policy-agent/gatekeeper",
+          "rules": [
+            {
+              "id": "K8sAllowedRepos",
+              "shortDescription": {
+                "text": "violations of K8sAllowedRepos constraints"
+              }
+            },
+            {
+              "id": "K8sRequiredLabels",
+              "shortDescription": {
+                "text": "violations of K8sRequiredLabels constraints"
+              }
+            }
+          ]
+        }
+      },
+      "results": [
+        {
+          "ruleId": "K8sAllowedRepos",
+          "ruleIndex": 0,
+          "level": "error",
+          "message": {
+            "text": "image evil.io/app is not allowed"
+          },
+          "locations": [
+            {
+              "logicalLocations": [
+                {
+                  "name": "app",
+                  "fullyQualifiedName": "default/Pod/app",
+                  "kind": "resource"
+                }
+              ]
+            }
+          ],
+          "properties": {
+            "code": "untrusted_image",
+            "constraint": "repo-is-trusted",
+            "enforcementAction": "deny"
+          }
+        },
+        {
+          "ruleId": "K8sRequiredLabels",
+          "ruleIndex": 1,
+          "level": "warning",
+          "message": {
+            "text": "missing label owner"
+          },
+          "locations": [
+            {
+              "logicalLocations": [
+                {
+                  "name": "kube-system",
+                  "fullyQualifiedName": "Namespace/kube-system",
+                  "kind": "resource"
+                }
+              ]
+            }
+          ],
+          "properties": {
+            "constraint": "ns-must-have-owner",
+            "enforcementAction": "dryrun"
+          }
+        }
+      ]
+    }
+  ]
+}
+`
+
+const expectedEmptySarif = `{
+  "$schema": "https://raw.githubusercontent.com/oasis-tcs/sarif-spec/master/Schemata/sarif-schema-2.1.0.json",
+  "version": "2.1.0",
+  "runs": [
+    {
+      "tool": {
+        "driver": {
+          "name": "gatekeeper",
+          "informationUri": "https://github.com/open-policy-agent/gatekeeper",
+          "rules": []
+        }
+      },
+      "results": []
+    }
+  ]
+}
+`
+
+func TestWriteReportSarif(t *testing.T) {
+	defer flag.Set("audit-output", "json")
+	flag.Set("audit-output", "sarif")
+
+	tc := []struct {
+		Name     string
+		Report   *Report
+		Expected string
+	}{
+		{
+			Name: "Violations",
+			Report: &Report{
+				Timestamp:       "2019-01-01T00:00:00Z",
+				TotalViolations: 2,
+				DenyViolations:  1,
+				Constraints: []ConstraintReport{
+					{
+						Kind:            "K8sAllowedRepos",
+						Name:            "repo-is-trusted",
+						TotalViolations: 1,
+						TotalMatches:    3,
+						Violations: []StatusViolation{
+							{Kind: "Pod", Name: "app", Namespace: "default", Message: "image evil.io/app is not allowed", Code: "untrusted_image", EnforcementAction: "deny"},
+						},
+					},
+					{
+						Kind:            "K8sRequiredLabels",
+						Name:            "ns-must-have-owner",
+						TotalViolations: 1,
+						TotalMatches:    1,
+						Violations: []StatusViolation{
+							{Kind: "Namespace", Name: "kube-system", Message: "missing label owner", EnforcementAction: "dryrun"},
+						},
+					},
+				},
+			},
+			Expected: expectedSarif,
+		},
+		{
+			Name:     "No violations",
+			Report:   &Report{Timestamp: "2019-01-01T00:00:00Z"},
+			Expected: expectedEmptySarif,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			if err := WriteReport(buf, tt.Report); err != nil {
+				t.Fatalf("WriteReport() err = %s", err)
+			}
+			if buf.String() != tt.Expected {
+				t.Errorf("WriteReport() = %s; want %s", buf.String(), tt.Expected)
+			}
+		})
+	}
+}
+
+func TestInvalidAuditOutput(t *testing.T) {
+	defer flag.Set("audit-output", "json")
+	flag.Set("audit-output", "xml")
+
+	c, driver := makeOpaClient(t)
+	if _, err := New(context.Background(), nil, c, driver); err == nil {
+		t.Errorf("New() err = nil; want an error for an unknown output format")
+	}
+}
@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// auditStatus is the part of a constraint's status written by audit, other than the
+// timestamp of the audit
+type auditStatus struct {
+	Violations      []interface{} `json:"violations"`
+	TotalViolations int64         `json:"totalViolations"`
+	TotalMatches    int64         `json:"totalMatches"`
+}
+
+// hash returns a stable hash of s. encoding/json sorts the keys of maps, so violations
+// decoded from the stored status hash the same as those about to be written.
+func (s auditStatus) hash() (string, error) {
+	if s.Violations == nil {
+		s.Violations = []interface{}{}
+	}
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// storedAuditStatus returns the audit status stored in the status of instance, or false if
+// audit has not written it
+func storedAuditStatus(instance *unstructured.Unstructured) (auditStatus, bool) {
+	var s auditStatus
+	totalViolations, found, err := unstructured.NestedInt64(instance.Object, "status", "totalViolations")
+	if err != nil || !found {
+		return s, false
+	}
+	totalMatches, found, err := unstructured.NestedInt64(instance.Object, "status", "totalMatches")
+	if err != nil || !found {
+		return s, false
+	}
+	violations, _, err := unstructured.NestedSlice(instance.Object, "status", "violations")
+	if err != nil {
+		return s, false
+	}
+	return auditStatus{Violations: violations, TotalViolations: totalViolations, TotalMatches: totalMatches}, true
+}
+
+// auditStatusUnchanged returns true if the status of instance already holds status, so that
+// writing it again would be a no-op apart from the timestamp of the audit
+func auditStatusUnchanged(instance *unstructured.Unstructured, status auditStatus) bool {
+	stored, ok := storedAuditStatus(instance)
+	if !ok {
+		return false
+	}
+	storedHash, err := stored.hash()
+	if err != nil {
+		return false
+	}
+	newHash, err := status.hash()
+	if err != nil {
+		return false
+	}
+	return storedHash == newHash
+}
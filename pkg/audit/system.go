@@ -0,0 +1,121 @@
+package audit
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	constraintTypes "github.com/open-policy-agent/frameworks/constraint/pkg/types"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// defaultSystemResources are the namespaces and objects created by Kubernetes itself, whose
+// violations teams usually cannot fix
+const defaultSystemResources = "kube-system,kube-public,kube-node-lease,ServiceAccount/default,ConfigMap/kube-root-ca.crt,rbac.authorization.k8s.io/ClusterRole/system:*,rbac.authorization.k8s.io/ClusterRoleBinding/system:*"
+
+var (
+	auditSkipSystemResources = flag.Bool("audit-skip-system-resources", false, "leave the violations of the resources listed by --audit-system-resources out of audit, so that constraint status only reports the violations of user resources. admission is not affected")
+	auditSystemResources     = flag.String("audit-system-resources", defaultSystemResources, "comma-separated system resources skipped by --audit-skip-system-resources, each a namespace, whose resources and the namespace itself are skipped, or [group/]Kind/name, skipped in every namespace. a trailing * matches any suffix of the namespace or name. defaulted to "+defaultSystemResources+" if unspecified ")
+)
+
+// systemResource is an entry of --audit-system-resources
+type systemResource struct {
+	// namespace is set for a namespace entry, and kind and name for an object entry
+	namespace string
+	kind      schema.GroupKind
+	name      string
+}
+
+// systemResources selects the resources skipped by --audit-skip-system-resources
+type systemResources []systemResource
+
+// parseSystemResources parses the value of --audit-system-resources
+func parseSystemResources(s string) (systemResources, error) {
+	var resources systemResources
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, "/")
+		var r systemResource
+		switch len(parts) {
+		case 1:
+			r.namespace = parts[0]
+		case 2:
+			r.kind, r.name = schema.GroupKind{Kind: parts[0]}, parts[1]
+		case 3:
+			r.kind, r.name = schema.GroupKind{Group: parts[0], Kind: parts[1]}, parts[2]
+		default:
+			return nil, fmt.Errorf("invalid system resource %q, want a namespace or [group/]Kind/name", entry)
+		}
+		if len(parts) > 1 && (r.kind.Kind == "" || r.name == "") {
+			return nil, fmt.Errorf("invalid system resource %q, kind and name must not be empty", entry)
+		}
+		resources = append(resources, r)
+	}
+	return resources, nil
+}
+
+// matchesPattern returns true if value is pattern, or starts with pattern up to its trailing *
+func matchesPattern(pattern, value string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(value, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == value
+}
+
+// includes returns true if obj is a system resource
+func (s systemResources) includes(obj *unstructured.Unstructured) bool {
+	gk := obj.GroupVersionKind().GroupKind()
+	for _, r := range s {
+		if r.namespace != "" {
+			if matchesPattern(r.namespace, obj.GetNamespace()) {
+				return true
+			}
+			if gk == (schema.GroupKind{Kind: "Namespace"}) && matchesPattern(r.namespace, obj.GetName()) {
+				return true
+			}
+			continue
+		}
+		if r.kind == gk && matchesPattern(r.name, obj.GetName()) {
+			return true
+		}
+	}
+	return false
+}
+
+// skip returns the responses of resp without the violations of system resources. A nil
+// systemResources skips nothing.
+func (s systemResources) skip(resp *constraintTypes.Responses) *constraintTypes.Responses {
+	if s == nil {
+		return resp
+	}
+	filtered, skipped := withoutViolationsOf(resp, s.includes)
+	if skipped > 0 {
+		log.Info("skipped the violations of system resources", "violations", skipped)
+	}
+	return filtered
+}
+
+// withoutViolationsOf returns the responses of resp without the violations of the resources for
+// which skip returns true, and the number of violations left out
+func withoutViolationsOf(resp *constraintTypes.Responses, skip func(*unstructured.Unstructured) bool) (*constraintTypes.Responses, int) {
+	skipped := 0
+	filtered := constraintTypes.NewResponses()
+	filtered.Handled = resp.Handled
+	for name, r := range resp.ByTarget {
+		kept := *r
+		kept.Results = nil
+		for _, result := range r.Results {
+			if resource, ok := result.Resource.(*unstructured.Unstructured); ok && skip(resource) {
+				skipped++
+				continue
+			}
+			kept.Results = append(kept.Results, result)
+		}
+		filtered.ByTarget[name] = &kept
+	}
+	return filtered, skipped
+}
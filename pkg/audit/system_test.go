@@ -0,0 +1,173 @@
+package audit
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestParseSystemResources(t *testing.T) {
+	tc := []struct {
+		Name        string
+		Value       string
+		Expected    systemResources
+		ExpectError bool
+	}{
+		{
+			Name:  "Empty",
+			Value: "",
+		},
+		{
+			Name:  "Namespaces and objects",
+			Value: "kube-system, openshift-*,ServiceAccount/default,rbac.authorization.k8s.io/ClusterRole/system:*",
+			Expected: systemResources{
+				{namespace: "kube-system"},
+				{namespace: "openshift-*"},
+				{kind: schema.GroupKind{Kind: "ServiceAccount"}, name: "default"},
+				{kind: clusterRoleKind, name: "system:*"},
+			},
+		},
+		{
+			Name:        "Missing name",
+			Value:       "ServiceAccount/",
+			ExpectError: true,
+		},
+		{
+			Name:        "Too many segments",
+			Value:       "apps/v1/Deployment/coredns",
+			ExpectError: true,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			got, err := parseSystemResources(tt.Value)
+			if (err != nil) != tt.ExpectError {
+				t.Fatalf("parseSystemResources() err = %v; want error %t", err, tt.ExpectError)
+			}
+			if !tt.ExpectError && !reflect.DeepEqual(got, tt.Expected) {
+				t.Errorf("parseSystemResources() = %v; want %v", got, tt.Expected)
+			}
+		})
+	}
+}
+
+func TestSystemResourcesIncludes(t *testing.T) {
+	system, err := parseSystemResources(defaultSystemResources)
+	if err != nil {
+		t.Fatalf("parseSystemResources() err = %s", err)
+	}
+	object := func(apiVersion, kind, namespace, name string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(apiVersion)
+		obj.SetKind(kind)
+		obj.SetNamespace(namespace)
+		obj.SetName(name)
+		return obj
+	}
+	tc := []struct {
+		Name     string
+		Object   *unstructured.Unstructured
+		Expected bool
+	}{
+		{
+			Name:     "Bootstrap token in kube-system",
+			Object:   object("v1", "Secret", "kube-system", "bootstrap-token-abcdef"),
+			Expected: true,
+		},
+		{
+			Name:     "System namespace",
+			Object:   object("v1", "Namespace", "", "kube-public"),
+			Expected: true,
+		},
+		{
+			Name:     "Default service account",
+			Object:   object("v1", "ServiceAccount", "team-a", "default"),
+			Expected: true,
+		},
+		{
+			Name:     "System cluster role",
+			Object:   object("rbac.authorization.k8s.io/v1", "ClusterRole", "", "system:node"),
+			Expected: true,
+		},
+		{
+			Name:   "User service account",
+			Object: object("v1", "ServiceAccount", "team-a", "builder"),
+		},
+		{
+			Name:   "User cluster role",
+			Object: object("rbac.authorization.k8s.io/v1", "ClusterRole", "", "team-a-admin"),
+		},
+		{
+			Name:   "User namespace",
+			Object: object("v1", "Namespace", "", "team-a"),
+		},
+		{
+			Name:   "Role named like a system cluster role",
+			Object: object("rbac.authorization.k8s.io/v1", "Role", "team-a", "system:node"),
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			if got := system.includes(tt.Object); got != tt.Expected {
+				t.Errorf("includes() = %t; want %t", got, tt.Expected)
+			}
+		})
+	}
+}
+
+func TestAuditSkipSystemResources(t *testing.T) {
+	c, _ := makeOpaClient(t)
+	addTemplate(t, c, always_violate_template)
+	criticalPods := addConstraint(t, c, critical_pods_anywhere)
+	addObject(t, c, "Pod", "kube-system", "coredns")
+	addObject(t, c, "Pod", "kube-public", "probe")
+	addObject(t, c, "Pod", "team-a", "app")
+
+	system, err := parseSystemResources(defaultSystemResources)
+	if err != nil {
+		t.Fatalf("parseSystemResources() err = %s", err)
+	}
+	tc := []struct {
+		Name     string
+		System   systemResources
+		Expected []string
+	}{
+		{
+			Name:     "System resources audited",
+			Expected: []string{"kube-public/probe", "kube-system/coredns", "team-a/app"},
+		},
+		{
+			Name:     "System resources skipped",
+			System:   system,
+			Expected: []string{"team-a/app"},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			resp, err := c.Audit(context.Background())
+			if err != nil {
+				t.Fatalf("Audit() err = %s", err)
+			}
+			updateLists, totals, err := getUpdateListsFromAuditResponses(tt.System.skip(resp), labels.Everything())
+			if err != nil {
+				t.Fatalf("getUpdateListsFromAuditResponses() err = %s", err)
+			}
+			var got []string
+			for _, r := range updateLists[criticalPods.GetSelfLink()] {
+				got = append(got, r.rnamespace+"/"+r.rname)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.Expected) {
+				t.Errorf("violations = %v; want %v", got, tt.Expected)
+			}
+			if totals[criticalPods.GetSelfLink()] != int64(len(tt.Expected)) {
+				t.Errorf("totalViolations = %d; want %d", totals[criticalPods.GetSelfLink()], len(tt.Expected))
+			}
+		})
+	}
+}
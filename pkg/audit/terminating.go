@@ -0,0 +1,28 @@
+package audit
+
+import (
+	"flag"
+
+	constraintTypes "github.com/open-policy-agent/frameworks/constraint/pkg/types"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var auditSkipTerminating = flag.Bool("audit-skip-terminating", false, "leave the violations of terminating resources, which have a deletionTimestamp but are kept by their finalizers, out of audit. admission is not affected")
+
+// terminating returns true if obj is being deleted
+func terminating(obj *unstructured.Unstructured) bool {
+	return obj.GetDeletionTimestamp() != nil
+}
+
+// skipTerminating returns the responses of resp without the violations of terminating resources
+// if --audit-skip-terminating is set, and resp otherwise
+func skipTerminating(resp *constraintTypes.Responses) *constraintTypes.Responses {
+	if !*auditSkipTerminating {
+		return resp
+	}
+	filtered, skipped := withoutViolationsOf(resp, terminating)
+	if skipped > 0 {
+		log.Info("skipped the violations of terminating resources", "violations", skipped)
+	}
+	return filtered
+}
@@ -0,0 +1,59 @@
+package audit
+
+import (
+	"context"
+	"flag"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestAuditSkipTerminating(t *testing.T) {
+	defer flag.Set("audit-skip-terminating", "false")
+	c, _ := makeOpaClient(t)
+	addTemplate(t, c, always_violate_template)
+	addConstraint(t, c, pods_in_foo)
+	addObject(t, c, "Pod", "foo", "live")
+	// a pod kept by a finalizer after its deletion
+	stuck := &unstructured.Unstructured{}
+	stuck.SetAPIVersion("v1")
+	stuck.SetKind("Pod")
+	stuck.SetNamespace("foo")
+	stuck.SetName("terminating")
+	deleted := metav1.NewTime(time.Now())
+	stuck.SetDeletionTimestamp(&deleted)
+	stuck.SetFinalizers([]string{"example.com/cleanup"})
+	if _, err := c.AddData(context.Background(), stuck); err != nil {
+		t.Fatalf("Could not add data: %s", err)
+	}
+
+	tc := []struct {
+		Name     string
+		Skip     string
+		Expected []string
+	}{
+		{Name: "Terminating resources audited", Skip: "false", Expected: []string{"live", "terminating"}},
+		{Name: "Terminating resources skipped", Skip: "true", Expected: []string{"live"}},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			flag.Set("audit-skip-terminating", tt.Skip)
+			resp, err := c.Audit(context.Background())
+			if err != nil {
+				t.Fatalf("Audit() err = %s", err)
+			}
+			var got []string
+			for _, r := range skipTerminating(resp).Results() {
+				got = append(got, r.Resource.(*unstructured.Unstructured).GetName())
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.Expected) {
+				t.Errorf("violations = %v; want %v", got, tt.Expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	configv1alpha1 "github.com/open-policy-agent/gatekeeper/pkg/apis/config/v1alpha1"
+	"github.com/open-policy-agent/gatekeeper/pkg/controller/config"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AuditNowAnnotation, when set on the Config, requests an audit without waiting for the
+// audit interval to pass. The annotation is removed once the request is received.
+const AuditNowAnnotation = "gatekeeper.sh/audit-now"
+
+// requestAudit makes the audit manager run its next audit immediately. Requests made while
+// one is already pending are merged with it.
+func (am *AuditManager) requestAudit() {
+	select {
+	case am.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// waitForNextAudit returns the scope of the next audit once the audit interval of a kind has
+// passed, an audit has been requested or ctx is done. Requested audits cover every kind.
+func (am *AuditManager) waitForNextAudit(ctx context.Context) auditScope {
+	wait := time.Duration(*auditInterval) * time.Second
+	if am.schedule != nil {
+		wait = time.Until(am.schedule.nextAudit())
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+		if am.schedule != nil {
+			return am.schedule.due(time.Now())
+		}
+	case <-am.trigger:
+		log.Info("running requested audit", "annotation", AuditNowAnnotation)
+		if am.schedule != nil {
+			am.schedule.reset(time.Now())
+		}
+	}
+	return fullAudit
+}
+
+// syncPollInterval is how often waitForSync checks for kinds that are not synced
+var syncPollInterval = time.Second
+
+// waitForSync waits up to --auditInterval seconds for every kind replicated into OPA to be
+// synced, so that a single audit does not evaluate referential constraints against a partial
+// data.inventory. It returns an error naming the kinds still not synced.
+func (am *AuditManager) waitForSync(ctx context.Context) error {
+	if am.unsynced == nil {
+		return nil
+	}
+	timeout := time.NewTimer(time.Duration(*auditInterval) * time.Second)
+	defer timeout.Stop()
+	ticker := time.NewTicker(syncPollInterval)
+	defer ticker.Stop()
+	for {
+		unsynced := am.unsynced()
+		if len(unsynced) == 0 {
+			return nil
+		}
+		log.Info("waiting for synced kinds before auditing", "unsynced", unsynced)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout.C:
+			return fmt.Errorf("kinds were not synced within --auditInterval %d seconds: %v", *auditInterval, unsynced)
+		case <-ticker.C:
+		}
+	}
+}
+
+// configEventHandler requests an audit when the Config watched by an informer is annotated
+// with AuditNowAnnotation, then removes the annotation using c so it does not trigger again
+func (am *AuditManager) configEventHandler(c client.Client) toolscache.ResourceEventHandler {
+	handle := func(obj interface{}) {
+		cfg, ok := obj.(*configv1alpha1.Config)
+		if !ok || (types.NamespacedName{Namespace: cfg.GetNamespace(), Name: cfg.GetName()}) != config.CfgKey {
+			return
+		}
+		if _, ok := cfg.GetAnnotations()[AuditNowAnnotation]; !ok {
+			return
+		}
+		am.requestAudit()
+		go clearAuditNow(c)
+	}
+	return toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    handle,
+		UpdateFunc: func(_, obj interface{}) { handle(obj) },
+	}
+}
+
+// clearAuditNow removes AuditNowAnnotation from the Config
+func clearAuditNow(c client.Client) {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cfg := &configv1alpha1.Config{}
+		if err := c.Get(context.Background(), config.CfgKey, cfg); err != nil {
+			return err
+		}
+		annotations := cfg.GetAnnotations()
+		if _, ok := annotations[AuditNowAnnotation]; !ok {
+			return nil
+		}
+		delete(annotations, AuditNowAnnotation)
+		cfg.SetAnnotations(annotations)
+		return c.Update(context.Background(), cfg)
+	})
+	if err != nil {
+		log.Error(err, "unable to remove audit request from config", "annotation", AuditNowAnnotation)
+	}
+}
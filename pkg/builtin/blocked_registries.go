@@ -0,0 +1,167 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package builtin loads the policies Gatekeeper enforces out of the box, configured by
+// flags, alongside the templates and constraints of the cluster.
+package builtin
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/core/templates"
+	"github.com/open-policy-agent/gatekeeper/pkg/controller/constraint"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var blockedRegistries = flag.String("blocked-registries", "", "comma-separated container image registries, optionally followed by a path such as quay.io/example, that pods may not use images from. images without a registry are from docker.io. enforced by the built-in GatekeeperBlockedRegistries constraint, which is loaded alongside the cluster's constraints. no registry is blocked if unspecified ")
+
+const (
+	// BlockedRegistriesKind is the kind of the built-in constraint of --blocked-registries
+	BlockedRegistriesKind = "GatekeeperBlockedRegistries"
+	// BlockedRegistriesName is the name of the built-in constraint of --blocked-registries
+	BlockedRegistriesName = "blocked-registries"
+)
+
+const blockedRegistriesRego = `
+package gatekeeperblockedregistries
+
+violation[{"msg": msg}] {
+  container := input_containers[_]
+  image := normalized_image(container.image)
+  prefix := input.parameters.registries[_]
+  startswith(image, concat("", [prefix, "/"]))
+  msg := sprintf("container <%v> uses image <%v> from blocked registry <%v>", [container.name, container.image, prefix])
+}
+
+input_containers[c] {
+  c := input.review.object.spec.containers[_]
+}
+
+input_containers[c] {
+  c := input.review.object.spec.initContainers[_]
+}
+
+# images without a registry are pulled from Docker Hub, and those without a repository
+# from its library
+normalized_image(image) = image {
+  parts := split(image, "/")
+  count(parts) > 1
+  is_registry(parts[0])
+}
+
+normalized_image(image) = concat("/", ["docker.io", image]) {
+  parts := split(image, "/")
+  count(parts) > 1
+  not is_registry(parts[0])
+}
+
+normalized_image(image) = concat("/", ["docker.io", "library", image]) {
+  count(split(image, "/")) == 1
+}
+
+is_registry(host) {
+  contains(host, ".")
+}
+
+is_registry(host) {
+  contains(host, ":")
+}
+
+is_registry(host) {
+  host == "localhost"
+}
+`
+
+// registries returns the entries of --blocked-registries, without trailing slashes
+func registries() ([]string, error) {
+	var out []string
+	for _, r := range strings.Split(*blockedRegistries, ",") {
+		r = strings.TrimSuffix(strings.TrimSpace(r), "/")
+		if r == "" {
+			continue
+		}
+		if strings.ContainsAny(r, " @") {
+			return nil, fmt.Errorf("invalid --blocked-registries entry %q, must be a registry optionally followed by a path", r)
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// Load adds the built-in templates and constraints enabled by flags to opa
+func Load(ctx context.Context, opa *opa.Client) error {
+	regs, err := registries()
+	if err != nil {
+		return err
+	}
+	if len(regs) == 0 {
+		return nil
+	}
+	if _, err := opa.AddTemplate(ctx, blockedRegistriesTemplate()); err != nil {
+		return fmt.Errorf("unable to load the %s template: %s", BlockedRegistriesKind, err)
+	}
+	if _, err := opa.AddConstraint(ctx, blockedRegistriesConstraint(regs)); err != nil {
+		return fmt.Errorf("unable to load the %s constraint: %s", BlockedRegistriesKind, err)
+	}
+	return nil
+}
+
+func blockedRegistriesTemplate() *templates.ConstraintTemplate {
+	return &templates.ConstraintTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: strings.ToLower(BlockedRegistriesKind)},
+		Spec: templates.ConstraintTemplateSpec{
+			CRD: templates.CRD{Spec: templates.CRDSpec{
+				Names: templates.Names{Kind: BlockedRegistriesKind},
+				Validation: &templates.Validation{OpenAPIV3Schema: &apiextensions.JSONSchemaProps{
+					Properties: map[string]apiextensions.JSONSchemaProps{
+						"registries": {
+							Type:  "array",
+							Items: &apiextensions.JSONSchemaPropsOrArray{Schema: &apiextensions.JSONSchemaProps{Type: "string"}},
+						},
+					},
+				}},
+			}},
+			Targets: []templates.Target{{Target: (&target.K8sValidationTarget{}).GetName(), Rego: blockedRegistriesRego}},
+		},
+	}
+}
+
+func blockedRegistriesConstraint(regs []string) *unstructured.Unstructured {
+	registries := make([]interface{}, len(regs))
+	for i, r := range regs {
+		registries[i] = r
+	}
+	cstr := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"match": map[string]interface{}{
+				"kinds": []interface{}{
+					map[string]interface{}{"apiGroups": []interface{}{""}, "kinds": []interface{}{"Pod"}},
+				},
+			},
+			"parameters": map[string]interface{}{"registries": registries},
+		},
+	}}
+	cstr.SetAPIVersion(constraint.Group + "/v1beta1")
+	cstr.SetKind(BlockedRegistriesKind)
+	cstr.SetName(BlockedRegistriesName)
+	return cstr
+}
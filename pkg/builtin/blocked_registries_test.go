@@ -0,0 +1,150 @@
+package builtin
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"testing"
+
+	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers/local"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/core/templates"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func newClient(t *testing.T) *opa.Client {
+	backend, err := opa.NewBackend(opa.Driver(local.New(local.Tracing(false))))
+	if err != nil {
+		t.Fatalf("unable to set up OPA backend: %s", err)
+	}
+	c, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+	if err != nil {
+		t.Fatalf("unable to set up OPA client: %s", err)
+	}
+	return c
+}
+
+func podRequest(t *testing.T, images ...string) *admissionv1beta1.AdmissionRequest {
+	var containers []interface{}
+	for _, image := range images {
+		containers = append(containers, map[string]interface{}{"name": "c", "image": image})
+	}
+	raw, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   map[string]interface{}{"name": "pod", "namespace": "default"},
+		"spec":       map[string]interface{}{"initContainers": containers[:1], "containers": containers[1:]},
+	})
+	if err != nil {
+		t.Fatalf("unable to marshal pod: %s", err)
+	}
+	req := &admissionv1beta1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Name:      "pod",
+		Namespace: "default",
+		Operation: admissionv1beta1.Create,
+	}
+	req.Object.Raw = raw
+	return req
+}
+
+func TestBlockedRegistries(t *testing.T) {
+	defer flag.Set("blocked-registries", "")
+	flag.Set("blocked-registries", "docker.io, quay.io/untrusted/,localhost:5000")
+	c := newClient(t)
+	if err := Load(context.Background(), c); err != nil {
+		t.Fatalf("Load() err = %s", err)
+	}
+
+	tc := []struct {
+		Name   string
+		Images []string
+		Denied int
+	}{
+		{Name: "allowed registry", Images: []string{"gcr.io/distroless/static", "quay.io/trusted/app:v1"}},
+		{Name: "blocked registry", Images: []string{"gcr.io/distroless/static", "docker.io/library/nginx"}, Denied: 1},
+		{Name: "docker hub repository", Images: []string{"gcr.io/distroless/static", "example/app"}, Denied: 1},
+		{Name: "docker hub library image", Images: []string{"gcr.io/distroless/static", "nginx:1.17"}, Denied: 1},
+		{Name: "blocked path", Images: []string{"quay.io/untrusted/app", "gcr.io/distroless/static"}, Denied: 1},
+		{Name: "registry with a port", Images: []string{"localhost:5000/app", "localhost:5000/other"}, Denied: 2},
+		{Name: "registry sharing a prefix", Images: []string{"docker.io.example.com/app", "quay.io/untrusted-not/app"}},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			resp, err := c.Review(context.Background(), podRequest(t, tt.Images...))
+			if err != nil {
+				t.Fatalf("Review() err = %s", err)
+			}
+			if got := len(resp.Results()); got != tt.Denied {
+				t.Errorf("got %d violations; want %d: %v", got, tt.Denied, resp.Results())
+			}
+		})
+	}
+}
+
+func TestBlockedRegistriesWithUserConstraints(t *testing.T) {
+	defer flag.Set("blocked-registries", "")
+	flag.Set("blocked-registries", "docker.io")
+	c := newClient(t)
+	if err := Load(context.Background(), c); err != nil {
+		t.Fatalf("Load() err = %s", err)
+	}
+	templ := &templates.ConstraintTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "k8sdenyall"},
+		Spec: templates.ConstraintTemplateSpec{
+			CRD: templates.CRD{Spec: templates.CRDSpec{Names: templates.Names{Kind: "K8sDenyAll"}}},
+			Targets: []templates.Target{{Target: (&target.K8sValidationTarget{}).GetName(), Rego: `
+package denyall
+
+violation[{"msg": "denied"}] {
+  true
+}
+`}},
+		},
+	}
+	if _, err := c.AddTemplate(context.Background(), templ); err != nil {
+		t.Fatalf("AddTemplate() err = %s", err)
+	}
+	cstr := &unstructured.Unstructured{}
+	cstr.SetAPIVersion("constraints.gatekeeper.sh/v1beta1")
+	cstr.SetKind("K8sDenyAll")
+	cstr.SetName("deny-all")
+	if _, err := c.AddConstraint(context.Background(), cstr); err != nil {
+		t.Fatalf("AddConstraint() err = %s", err)
+	}
+
+	resp, err := c.Review(context.Background(), podRequest(t, "gcr.io/distroless/static", "nginx"))
+	if err != nil {
+		t.Fatalf("Review() err = %s", err)
+	}
+	kinds := make(map[string]bool)
+	for _, r := range resp.Results() {
+		kinds[r.Constraint.GetKind()] = true
+	}
+	if !kinds[BlockedRegistriesKind] || !kinds["K8sDenyAll"] {
+		t.Errorf("violated constraint kinds = %v; want %s and K8sDenyAll", kinds, BlockedRegistriesKind)
+	}
+}
+
+func TestNoBlockedRegistries(t *testing.T) {
+	c := newClient(t)
+	if err := Load(context.Background(), c); err != nil {
+		t.Fatalf("Load() err = %s", err)
+	}
+	resp, err := c.Review(context.Background(), podRequest(t, "nginx", "nginx"))
+	if err != nil {
+		t.Fatalf("Review() err = %s", err)
+	}
+	if len(resp.Results()) != 0 {
+		t.Errorf("got %d violations without --blocked-registries; want 0", len(resp.Results()))
+	}
+
+	defer flag.Set("blocked-registries", "")
+	flag.Set("blocked-registries", "docker.io/library/nginx@sha256")
+	if err := Load(context.Background(), newClient(t)); err == nil {
+		t.Error("Load() err = nil with an invalid --blocked-registries entry; want an error")
+	}
+}
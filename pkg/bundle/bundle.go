@@ -0,0 +1,114 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bundle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers"
+	"github.com/open-policy-agent/gatekeeper/pkg/health"
+	"github.com/open-policy-agent/opa/ast"
+	opabundle "github.com/open-policy-agent/opa/bundle"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+var log = logf.Log.WithName("bundle")
+
+// dump is the state of a driver, as returned by its Dump method
+type dump struct {
+	Modules map[string]string      `json:"modules"`
+	Data    map[string]interface{} `json:"data"`
+}
+
+// Export returns an OPA bundle of the modules and data loaded into driver, which includes
+// every template, the target library, the constraints and the replicated and external data.
+// Loading the bundle into another OPA serves the same policy decisions. If redact is not nil,
+// it is applied to the dump of driver first, to remove the values of sensitive fields.
+func Export(ctx context.Context, driver drivers.Driver, redact func(string) (string, error)) (*opabundle.Bundle, error) {
+	s, err := driver.Dump(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if redact != nil {
+		if s, err = redact(s); err != nil {
+			return nil, fmt.Errorf("unable to redact OPA state: %s", err)
+		}
+	}
+	d := &dump{}
+	if err := json.Unmarshal([]byte(s), d); err != nil {
+		return nil, fmt.Errorf("unable to parse OPA state: %s", err)
+	}
+
+	b := &opabundle.Bundle{Data: make(map[string]interface{})}
+	var names []string
+	for name := range d.Modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// the dumped data also holds the documents generated by the modules, which the bundle
+	// must not redefine as data
+	generated := make(map[string]bool)
+	for _, name := range names {
+		module, err := ast.ParseModule(name, d.Modules[name])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse module %s: %s", name, err)
+		}
+		if len(module.Package.Path) > 1 {
+			if root, ok := module.Package.Path[1].Value.(ast.String); ok {
+				generated[string(root)] = true
+			}
+		}
+		b.Modules = append(b.Modules, opabundle.ModuleFile{
+			Path:   "/" + name + ".rego",
+			Raw:    []byte(d.Modules[name]),
+			Parsed: module,
+		})
+	}
+	for root, doc := range d.Data {
+		if !generated[root] {
+			b.Data[root] = doc
+		}
+	}
+	b.Manifest.Init()
+	return b, nil
+}
+
+// Handler serves the bundle exported from driver, redacted with redact, as a gzipped tarball.
+// Clients that do not connect from localhost are denied unless they authenticated with
+// --debug-auth-token-file or --debug-client-ca-file, as the bundle holds all replicated data.
+func Handler(driver drivers.Driver, redact func(string) (string, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !health.FromLocalhost(r) && !health.Authenticated(r) {
+			http.Error(w, "the bundle is only served to localhost", http.StatusForbidden)
+			return
+		}
+		b, err := Export(r.Context(), driver, redact)
+		if err != nil {
+			log.Error(err, "unable to export bundle")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", `attachment; filename="bundle.tar.gz"`)
+		if err := opabundle.Write(w, *b); err != nil {
+			log.Error(err, "unable to write bundle")
+		}
+	})
+}
@@ -0,0 +1,172 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bundle
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers/local"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/core/templates"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	"github.com/open-policy-agent/gatekeeper/pkg/webhook"
+	opabundle "github.com/open-policy-agent/opa/bundle"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const denyAllRego = `
+package denyall
+
+violation[{"msg": "denied"}] {
+  true
+}
+`
+
+func TestHandler(t *testing.T) {
+	driver := local.New(local.Tracing(false))
+	backend, err := opa.NewBackend(opa.Driver(driver))
+	if err != nil {
+		t.Fatalf("Could not create backend: %s", err)
+	}
+	c, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+	templ := &templates.ConstraintTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "k8sdenyall"},
+		Spec: templates.ConstraintTemplateSpec{
+			CRD: templates.CRD{Spec: templates.CRDSpec{Names: templates.Names{Kind: "K8sDenyAll"}}},
+			Targets: []templates.Target{
+				{Target: (&target.K8sValidationTarget{}).GetName(), Rego: denyAllRego},
+			},
+		},
+	}
+	if _, err := c.AddTemplate(context.Background(), templ); err != nil {
+		t.Fatalf("Could not add template: %s", err)
+	}
+	cstr := &unstructured.Unstructured{}
+	cstr.SetGroupVersionKind(schema.GroupVersionKind{Group: "constraints.gatekeeper.sh", Version: "v1beta1", Kind: "K8sDenyAll"})
+	cstr.SetName("deny-everything")
+	if _, err := c.AddConstraint(context.Background(), cstr); err != nil {
+		t.Fatalf("Could not add constraint: %s", err)
+	}
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("Namespace")
+	obj.SetName("prod")
+	if _, err := c.AddData(context.Background(), obj); err != nil {
+		t.Fatalf("Could not add data: %s", err)
+	}
+	secret := &unstructured.Unstructured{}
+	secret.SetAPIVersion("v1")
+	secret.SetKind("Secret")
+	secret.SetNamespace("prod")
+	secret.SetName("db")
+	if err := unstructured.SetNestedField(secret.Object, "czNjcmV0", "data", "password"); err != nil {
+		t.Fatalf("Could not set secret data: %s", err)
+	}
+	if _, err := c.AddData(context.Background(), secret); err != nil {
+		t.Fatalf("Could not add data: %s", err)
+	}
+
+	// the bundle holds all replicated data, which is not served to remote clients
+	rec := httptest.NewRecorder()
+	Handler(driver, webhook.RedactDump).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/bundle", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d for a remote client; want %d", rec.Code, http.StatusForbidden)
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/debug/bundle", nil)
+	req.RemoteAddr = "127.0.0.1:34567"
+	Handler(driver, webhook.RedactDump).ServeHTTP(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("status = %d; want 200: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/gzip" {
+		t.Errorf("Content-Type = %s; want application/gzip", got)
+	}
+	b, err := opabundle.NewReader(rec.Body).Read()
+	if err != nil {
+		t.Fatalf("could not read bundle: %s", err)
+	}
+
+	packages := make(map[string]bool)
+	for _, m := range b.Modules {
+		packages[m.Parsed.Package.Path.String()] = true
+	}
+	for _, want := range []string{
+		`data.hooks["admission.k8s.gatekeeper.sh"].library`,
+		`data.templates["admission.k8s.gatekeeper.sh"].K8sDenyAll`,
+	} {
+		if !packages[want] {
+			t.Errorf("bundle packages = %v; want %s", packages, want)
+		}
+	}
+
+	if _, ok := b.Data["templates"]; ok {
+		t.Error("bundle data includes the documents generated by templates")
+	}
+	if _, ok := b.Data["hooks"]; ok {
+		t.Error("bundle data includes the documents generated by the target library")
+	}
+	for root, want := range map[string]string{"constraints": "deny-everything", "external": "prod"} {
+		doc, err := json.Marshal(b.Data[root])
+		if err != nil {
+			t.Fatalf("could not encode %s data: %s", root, err)
+		}
+		if !strings.Contains(string(doc), want) {
+			t.Errorf("bundle %s data = %s; want it to include %s", root, doc, want)
+		}
+	}
+
+	external, err := json.Marshal(b.Data["external"])
+	if err != nil {
+		t.Fatalf("could not encode external data: %s", err)
+	}
+	if strings.Contains(string(external), "czNjcmV0") {
+		t.Errorf("bundle external data = %s; want the data of the secret redacted", external)
+	}
+
+	// an offline OPA loaded with the bundle reaches the same decisions
+	opts := []func(*rego.Rego){
+		rego.Store(inmem.NewFromObject(b.Data)),
+		rego.Query(`data.hooks["admission.k8s.gatekeeper.sh"].audit`),
+	}
+	for _, m := range b.Modules {
+		opts = append(opts, rego.ParsedModule(m.Parsed))
+	}
+	rs, err := rego.New(opts...).Eval(context.Background())
+	if err != nil {
+		t.Fatalf("could not evaluate bundle: %s", err)
+	}
+	audit, err := json.Marshal(rs)
+	if err != nil {
+		t.Fatalf("could not encode audit results: %s", err)
+	}
+	if !strings.Contains(string(audit), "deny-everything") || !strings.Contains(string(audit), "prod") {
+		t.Errorf("offline audit = %s; want deny-everything to deny the prod namespace", audit)
+	}
+}
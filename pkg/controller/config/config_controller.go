@@ -17,12 +17,14 @@ package config
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"reflect"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
 	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
 	configv1alpha1 "github.com/open-policy-agent/gatekeeper/pkg/apis/config/v1alpha1"
 	syncc "github.com/open-policy-agent/gatekeeper/pkg/controller/sync"
@@ -49,10 +51,21 @@ import (
 // allFinalizers agrees with reality and launches a cleaner if the config is missing.
 
 const (
-	ctrlName      = "config-controller"
-	finalizerName = "finalizers.gatekeeper.sh/config"
+	ctrlName          = "config-controller"
+	baseFinalizerName = "finalizers.gatekeeper.sh/config"
 )
 
+func init() {
+	util.RegisterFinalizerName(baseFinalizerName)
+}
+
+// finalizerName returns the finalizer managed by this instance
+func finalizerName() string {
+	return util.FinalizerName(baseFinalizerName)
+}
+
+var purgeRemovedKinds = flag.Bool("purge-removed-sync-kinds", true, "remove the objects of a synced kind from OPA's cache once the API server no longer serves the kind, e.g. because its CRD was deleted, so that referential policies do not evaluate against objects that no longer exist. the kind is watched and synced again once it is served. defaulted to true if unspecified ")
+
 var CfgKey = types.NamespacedName{Namespace: util.GetNamespace(), Name: "config"}
 var log = logf.Log.WithName("controller").WithValues("kind", "Config")
 
@@ -79,28 +92,35 @@ func (a *Adder) InjectWatchManager(wm *watch.WatchManager) {
 	a.WatchManager = wm
 }
 
+func (a *Adder) InjectConstraintIndex(idx *target.ConstraintIndex) {}
+
 // newReconciler returns a new reconcile.Reconciler
 func newReconciler(mgr manager.Manager, opa *opa.Client, wm *watch.WatchManager) (reconcile.Reconciler, error) {
-	syncAdder := syncc.Adder{Opa: opa}
+	active := syncc.NewActiveKinds()
+	syncAdder := syncc.Adder{Opa: opa, Active: active}
 	w, err := wm.NewRegistrar(
 		ctrlName,
 		[]func(manager.Manager, schema.GroupVersionKind) error{syncAdder.Add})
 	if err != nil {
 		return nil, err
 	}
+	w.OnKindRemoved(func(gvk schema.GroupVersionKind) {
+		purgeRemovedKind(opa, active, gvk)
+	})
 	return &ReconcileConfig{
 		Client:  mgr.GetClient(),
 		scheme:  mgr.GetScheme(),
 		opa:     opa,
 		watcher: w,
 		watched: newSet(),
+		active:  active,
 	}, nil
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
 func add(mgr manager.Manager, r reconcile.Reconciler) error {
 	// Create a new controller
-	c, err := controller.New(ctrlName, mgr, controller.Options{Reconciler: r})
+	c, err := controller.New(ctrlName, mgr, util.ControllerOptions(r))
 	if err != nil {
 		return err
 	}
@@ -111,6 +131,12 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Watch for changes to the sync dependencies of templates
+	err = c.Watch(&source.Kind{Type: &v1beta1.ConstraintTemplate{}}, enqueueConfig, dependenciesChanged)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -123,6 +149,7 @@ type ReconcileConfig struct {
 	opa     *opa.Client
 	watcher *watch.Registrar
 	watched *watchSet
+	active  *syncc.ActiveKinds
 	fc      *finalizerCleanup
 }
 
@@ -155,7 +182,7 @@ func (r *ReconcileConfig) Reconcile(request reconcile.Request) (reconcile.Result
 	toClean := newSet()
 	if instance.GetDeletionTimestamp().IsZero() {
 		if !hasFinalizer(instance) {
-			instance.SetFinalizers(append(instance.GetFinalizers(), finalizerName))
+			instance.SetFinalizers(append(instance.GetFinalizers(), finalizerName()))
 			if err := r.Update(context.Background(), instance); err != nil {
 				return reconcile.Result{}, err
 			}
@@ -164,6 +191,9 @@ func (r *ReconcileConfig) Reconcile(request reconcile.Request) (reconcile.Result
 			gvk := schema.GroupVersionKind{Group: entry.Group, Version: entry.Version, Kind: entry.Kind}
 			newSyncOnly.Add(gvk)
 		}
+		if err := templateDependencies(r, newSyncOnly); err != nil {
+			return reconcile.Result{}, err
+		}
 		// Handle deletion
 	} else {
 		if hasFinalizer(instance) {
@@ -183,7 +213,18 @@ func (r *ReconcileConfig) Reconcile(request reconcile.Request) (reconcile.Result
 		if err != nil {
 			return reconcile.Result{}, err
 		}
-		if _, err := r.opa.RemoveData(context.Background(), target.WipeData{}); err != nil {
+		// Sync controllers for removed kinds may still be running until the watch manager
+		// restarts, so stop them from adding data before the wipe. External data is not
+		// synced from the cluster and is kept.
+		if err := r.active.Replace(newSyncOnly.Items(), func() error {
+			for _, scope := range []string{target.ClusterScope, target.NamespaceScope} {
+				if _, err := r.opa.RemoveData(context.Background(), target.WipeData{Scope: scope}); err != nil {
+					return err
+				}
+			}
+			syncc.DataWiped()
+			return nil
+		}); err != nil {
 			return reconcile.Result{}, err
 		}
 	}
@@ -216,13 +257,30 @@ func (r *ReconcileConfig) Reconcile(request reconcile.Request) (reconcile.Result
 
 	util.SetCfgHAStatus(instance, status)
 	log.Info("updating config resource", "obj", instance, "allFinalizers", allFinalizers)
-	if err := r.Update(context.Background(), instance); err != nil {
+	if err := util.UpdateWithStatus(context.Background(), r, instance); err != nil {
 		return reconcile.Result{}, err
 	}
 	r.watched.Replace(newSyncOnly)
 	return reconcile.Result{}, nil
 }
 
+// purgeRemovedKind removes the objects of gvk from OPA's cache, unless
+// --purge-removed-sync-kinds is false, once the watch manager has stopped watching gvk because
+// it is no longer served
+func purgeRemovedKind(opa *opa.Client, active *syncc.ActiveKinds, gvk schema.GroupVersionKind) {
+	removedErr := fmt.Errorf("synced kind %s is no longer served", gvk)
+	if !*purgeRemovedKinds {
+		log.Error(removedErr, "keeping the objects of the kind in OPA's cache")
+		return
+	}
+	n, err := syncc.PurgeKind(opa, active, gvk)
+	if err != nil {
+		log.Error(err, "unable to remove the objects of a kind that is no longer served from OPA's cache", "gvk", gvk.String())
+		return
+	}
+	log.Error(removedErr, "removed the objects of the kind from OPA's cache", "objects", n)
+}
+
 func containsString(s string, items []string) bool {
 	for _, item := range items {
 		if item == s {
@@ -291,11 +349,11 @@ func RemoveAllConfigFinalizers(c client.Client, finished chan struct{}) {
 }
 
 func hasFinalizer(instance *configv1alpha1.Config) bool {
-	return containsString(finalizerName, instance.GetFinalizers())
+	return containsString(finalizerName(), instance.GetFinalizers())
 }
 
 func removeFinalizer(instance *configv1alpha1.Config) {
-	instance.SetFinalizers(removeString(finalizerName, instance.GetFinalizers()))
+	instance.SetFinalizers(removeString(finalizerName(), instance.GetFinalizers()))
 }
 
 type finalizerCleanup struct {
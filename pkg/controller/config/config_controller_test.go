@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"github.com/onsi/gomega"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
 	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
 	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers/local"
 	configv1alpha1 "github.com/open-policy-agent/gatekeeper/pkg/apis/config/v1alpha1"
@@ -172,3 +173,82 @@ func TestReconcile(t *testing.T) {
 		return nil
 	}, timeout).Should(gomega.BeNil())
 }
+
+func TestReconcileTemplateDependencies(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	instance := &configv1alpha1.Config{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "config",
+			Namespace: "gatekeeper-system",
+		},
+		Spec: configv1alpha1.ConfigSpec{
+			Sync: configv1alpha1.Sync{
+				SyncOnly: []configv1alpha1.SyncOnlyEntry{
+					{Group: "", Version: "v1", Kind: "Namespace"},
+				},
+			},
+		},
+	}
+	templ := &v1beta1.ConstraintTemplate{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "uniqueservice",
+			Annotations: map[string]string{SyncDependenciesAnnotation: `[{"group": "", "version": "v1", "kind": "Service"}]`},
+		},
+		Spec: v1beta1.ConstraintTemplateSpec{
+			CRD: v1beta1.CRD{Spec: v1beta1.CRDSpec{Names: v1beta1.Names{Kind: "UniqueService"}}},
+			Targets: []v1beta1.Target{
+				{Target: "admission.k8s.gatekeeper.sh", Rego: "package foo\n\nviolation[{\"msg\": \"denied\"}] { false }"},
+			},
+		},
+	}
+
+	mgr, err := manager.New(cfg, manager.Options{})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	c = mgr.GetClient()
+
+	driver := local.New(local.Tracing(false))
+	backend, err := opa.NewBackend(opa.Driver(driver))
+	if err != nil {
+		t.Fatalf("unable to set up OPA backend: %s", err)
+	}
+	opa, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+	if err != nil {
+		t.Fatalf("unable to set up OPA client: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher := watch.New(ctx, mgr.GetConfig())
+	rec, _ := newReconciler(mgr, opa, watcher)
+	recFn, _ := SetupTestReconcile(rec)
+	g.Expect(add(mgr, recFn)).NotTo(gomega.HaveOccurred())
+
+	stopMgr, mgrStopped := StartTestManager(mgr, g)
+	defer func() {
+		close(stopMgr)
+		mgrStopped.Wait()
+	}()
+
+	managedKinds := func() []string {
+		var kinds []string
+		for _, gvkMap := range watcher.GetManaged() {
+			for gvk := range gvkMap {
+				kinds = append(kinds, gvk.Kind)
+			}
+		}
+		sort.Strings(kinds)
+		return kinds
+	}
+
+	g.Expect(c.Create(context.TODO(), instance)).NotTo(gomega.HaveOccurred())
+	defer c.Delete(context.TODO(), instance)
+	g.Eventually(managedKinds, timeout).Should(gomega.Equal([]string{"Namespace"}))
+
+	// declaring a dependency starts syncing the kind
+	g.Expect(c.Create(context.TODO(), templ)).NotTo(gomega.HaveOccurred())
+	g.Eventually(managedKinds, timeout).Should(gomega.Equal([]string{"Namespace", "Service"}))
+
+	// deleting the template stops it
+	g.Expect(c.Delete(context.TODO(), templ)).NotTo(gomega.HaveOccurred())
+	g.Eventually(managedKinds, timeout).Should(gomega.Equal([]string{"Namespace"}))
+}
@@ -0,0 +1,91 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
+	configv1alpha1 "github.com/open-policy-agent/gatekeeper/pkg/apis/config/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// SyncDependenciesAnnotation lists, on a ConstraintTemplate, the kinds its Rego reads from
+// data.inventory. They are synced into OPA as if listed in the Config's syncOnly. The value
+// is a JSON list of syncOnly entries, for example [{"group": "", "version": "v1", "kind": "Namespace"}].
+const SyncDependenciesAnnotation = "gatekeeper.sh/sync-dependencies"
+
+// SyncDependencies returns the kinds templ declares it depends on
+func SyncDependencies(templ *v1beta1.ConstraintTemplate) ([]schema.GroupVersionKind, error) {
+	value, ok := templ.GetAnnotations()[SyncDependenciesAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	var entries []configv1alpha1.SyncOnlyEntry
+	if err := json.Unmarshal([]byte(value), &entries); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %s", SyncDependenciesAnnotation, err)
+	}
+	var gvks []schema.GroupVersionKind
+	for _, entry := range entries {
+		if entry.Version == "" || entry.Kind == "" {
+			return nil, fmt.Errorf("invalid %s annotation: entries need a version and kind: %+v", SyncDependenciesAnnotation, entry)
+		}
+		gvks = append(gvks, schema.GroupVersionKind{Group: entry.Group, Version: entry.Version, Kind: entry.Kind})
+	}
+	return gvks, nil
+}
+
+// templateDependencies adds the kinds every template depends on to set. Templates with an
+// invalid annotation are skipped.
+func templateDependencies(c client.Client, set *watchSet) error {
+	templates := &v1beta1.ConstraintTemplateList{}
+	if err := c.List(context.Background(), &client.ListOptions{}, templates); err != nil {
+		return err
+	}
+	for i := range templates.Items {
+		templ := &templates.Items[i]
+		gvks, err := SyncDependencies(templ)
+		if err != nil {
+			log.Error(err, "ignoring sync dependencies of template", "template", templ.GetName())
+			continue
+		}
+		for _, gvk := range gvks {
+			set.Add(gvk)
+		}
+	}
+	return nil
+}
+
+// enqueueConfig maps every template event to the Config
+var enqueueConfig = &handler.EnqueueRequestsFromMapFunc{
+	ToRequests: handler.ToRequestsFunc(func(handler.MapObject) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: CfgKey}}
+	}),
+}
+
+// dependenciesChanged filters out template updates that leave the sync dependencies unchanged
+var dependenciesChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return e.MetaOld.GetAnnotations()[SyncDependenciesAnnotation] != e.MetaNew.GetAnnotations()[SyncDependenciesAnnotation]
+	},
+}
@@ -0,0 +1,137 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package constraint
+
+import (
+	"time"
+
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Conditions reported in status.conditions of constraints
+const (
+	// ReadyCondition is True once the constraint is loaded into OPA
+	ReadyCondition = "Ready"
+	// EnforcedCondition is True while the constraint is loaded and denies admission requests,
+	// and False if it is not loaded or its enforcementAction is not deny
+	EnforcedCondition = "Enforced"
+	// ErrorCondition is True with the error as its message if the constraint could not be loaded
+	ErrorCondition = "Error"
+	// UnknownFieldsCondition is True with their paths as its message if the spec of the
+	// constraint has fields Gatekeeper does not read. It is only reported with
+	// --strict-spec-validation.
+	UnknownFieldsCondition = "UnknownFields"
+	// PendingCondition is True while the constraint only matches kinds that the API server does
+	// not serve, such as kinds whose CRDs are not installed yet
+	PendingCondition = "Pending"
+)
+
+// setCondition sets the condition of condType in the status of obj. Its lastTransitionTime is
+// only updated when its status changes.
+func setCondition(obj *unstructured.Unstructured, condType string, status bool, reason string, message string, now time.Time) error {
+	statusStr := "False"
+	if status {
+		statusStr = "True"
+	}
+	conditions, _, err := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	if err != nil {
+		return err
+	}
+	condition := map[string]interface{}{
+		"type":               condType,
+		"status":             statusStr,
+		"reason":             reason,
+		"message":            message,
+		"lastTransitionTime": now.UTC().Format(time.RFC3339),
+	}
+	for i, c := range conditions {
+		current, ok := c.(map[string]interface{})
+		if !ok || current["type"] != condType {
+			continue
+		}
+		if current["status"] == statusStr && current["lastTransitionTime"] != nil {
+			condition["lastTransitionTime"] = current["lastTransitionTime"]
+		}
+		conditions[i] = condition
+		return unstructured.SetNestedSlice(obj.Object, conditions, "status", "conditions")
+	}
+	return unstructured.SetNestedSlice(obj.Object, append(conditions, condition), "status", "conditions")
+}
+
+// setLoaded reports in the conditions of instance that it is loaded into OPA
+func setLoaded(instance *unstructured.Unstructured, now time.Time) error {
+	if err := setCondition(instance, ReadyCondition, true, "Loaded", "constraint is loaded", now); err != nil {
+		return err
+	}
+	if err := setCondition(instance, ErrorCondition, false, "Loaded", "", now); err != nil {
+		return err
+	}
+	action := util.EnforcementAction(instance)
+	if remaining := gracePeriodRemaining(instance, now); remaining > 0 {
+		return setCondition(instance, EnforcedCondition, false, "GracePeriod", "enforcementAction is deny once the grace period ends at "+now.Add(remaining).UTC().Format(time.RFC3339), now)
+	}
+	return setCondition(instance, EnforcedCondition, action == "deny", "EnforcementAction", "enforcementAction is "+action, now)
+}
+
+// gracePeriodRemaining returns how long instance still does not deny admission as it was
+// created less than --constraint-grace-period ago, or 0 if it is enforced as set by its
+// enforcementAction
+func gracePeriodRemaining(instance *unstructured.Unstructured, now time.Time) time.Duration {
+	if util.EnforcementAction(instance) != "deny" {
+		return 0
+	}
+	return util.GracePeriodRemaining(instance, now)
+}
+
+// setLoadFailed reports in the conditions of instance that it could not be loaded into OPA
+func setLoadFailed(instance *unstructured.Unstructured, cause error, now time.Time) error {
+	if err := setCondition(instance, ReadyCondition, false, "LoadFailed", "constraint could not be loaded", now); err != nil {
+		return err
+	}
+	if err := setCondition(instance, ErrorCondition, true, "LoadFailed", cause.Error(), now); err != nil {
+		return err
+	}
+	return setCondition(instance, EnforcedCondition, false, "LoadFailed", "constraint is not loaded", now)
+}
+
+// setInvalidParameters reports in the conditions of instance that it is not loaded into OPA as
+// its parameters do not match the schema of its template
+func setInvalidParameters(instance *unstructured.Unstructured, cause error, now time.Time) error {
+	if err := setCondition(instance, ReadyCondition, false, InvalidParametersReason, "constraint parameters do not match the template's schema", now); err != nil {
+		return err
+	}
+	if err := setCondition(instance, ErrorCondition, true, InvalidParametersReason, cause.Error(), now); err != nil {
+		return err
+	}
+	return setCondition(instance, EnforcedCondition, false, InvalidParametersReason, "constraint is not loaded", now)
+}
+
+// DisabledReason is the reason of the conditions of a constraint labeled with util.DisabledLabel
+const DisabledReason = "Disabled"
+
+// setDisabled reports in the conditions of instance that it is not loaded into OPA as it is
+// labeled with util.DisabledLabel
+func setDisabled(instance *unstructured.Unstructured, now time.Time) error {
+	message := "constraint is disabled by the " + util.DisabledLabel + " label"
+	if err := setCondition(instance, ReadyCondition, false, DisabledReason, message, now); err != nil {
+		return err
+	}
+	if err := setCondition(instance, ErrorCondition, false, DisabledReason, "", now); err != nil {
+		return err
+	}
+	return setCondition(instance, EnforcedCondition, false, DisabledReason, message, now)
+}
@@ -18,15 +18,20 @@ package constraint
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
 	"github.com/open-policy-agent/gatekeeper/pkg/util"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
@@ -38,36 +43,51 @@ import (
 var log = logf.Log.WithName("controller").WithValues("metaKind", "Constraint")
 
 const (
-	finalizerName = "finalizers.gatekeeper.sh/constraint"
-	project       = "gatekeeper.sh"
+	baseFinalizerName = "finalizers.gatekeeper.sh/constraint"
+	project           = "gatekeeper.sh"
 )
 
+func init() {
+	util.RegisterFinalizerName(baseFinalizerName)
+}
+
+// finalizerName returns the finalizer managed by this instance
+func finalizerName() string {
+	return util.FinalizerName(baseFinalizerName)
+}
+
 type Adder struct {
 	Opa *opa.Client
+	// Index lists the loaded constraints by the kinds they select, unless it is nil
+	Index *target.ConstraintIndex
 }
 
 // Add creates a new Constraint Controller and adds it to the Manager with default RBAC. The Manager will set fields on the Controller
 // and Start it when the Manager is Started.
 func (a *Adder) Add(mgr manager.Manager, gvk schema.GroupVersionKind) error {
-	r := newReconciler(mgr, gvk, a.Opa)
+	r := newReconciler(mgr, gvk, a.Opa, a.Index)
 	return add(mgr, r, gvk)
 }
 
 // newReconciler returns a new reconcile.Reconciler
-func newReconciler(mgr manager.Manager, gvk schema.GroupVersionKind, opa *opa.Client) reconcile.Reconciler {
+func newReconciler(mgr manager.Manager, gvk schema.GroupVersionKind, opa *opa.Client, index *target.ConstraintIndex) reconcile.Reconciler {
 	return &ReconcileConstraint{
 		Client: mgr.GetClient(),
 		scheme: mgr.GetScheme(),
 		opa:    opa,
+		index:  index,
 		log:    log.WithValues("kind", gvk.Kind, "apiVersion", gvk.GroupVersion().String()),
 		gvk:    gvk,
+		kinds: newServedKinds(mgr.GetRESTMapper(), func() (meta.RESTMapper, error) {
+			return apiutil.NewDiscoveryRESTMapper(mgr.GetConfig())
+		}),
 	}
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
 func add(mgr manager.Manager, r reconcile.Reconciler, gvk schema.GroupVersionKind) error {
 	// Create a new controller
-	c, err := controller.New(fmt.Sprintf("%s-constraint-controller", gvk.String()), mgr, controller.Options{Reconciler: r})
+	c, err := controller.New(fmt.Sprintf("%s-constraint-controller", gvk.String()), mgr, util.ControllerOptions(r))
 	if err != nil {
 		return err
 	}
@@ -90,19 +110,29 @@ type ReconcileConstraint struct {
 	client.Client
 	scheme *runtime.Scheme
 	opa    *opa.Client
-	gvk    schema.GroupVersionKind
-	log    logr.Logger
+	// index lists the constraints loaded into opa by the kinds they select, unless it is nil
+	index *target.ConstraintIndex
+	gvk   schema.GroupVersionKind
+	log   logr.Logger
+	// kinds reports constraints whose kinds are not served as pending, unless it is nil
+	kinds *servedKinds
 }
 
 // Reconcile reads that state of the cluster for a constraint object and makes changes based on the state read
 // and what is in the constraint.Spec
 // +kubebuilder:rbac:groups=constraints.gatekeeper.sh,resources=*,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=namespaced.constraints.gatekeeper.sh,resources=*,verbs=get;list;watch;create;update;patch;delete
 func (r *ReconcileConstraint) Reconcile(request reconcile.Request) (reconcile.Result, error) {
 	instance := &unstructured.Unstructured{}
 	instance.SetGroupVersionKind(r.gvk)
 	err := r.Get(context.TODO(), request.NamespacedName, instance)
 	if err != nil {
 		if errors.IsNotFound(err) {
+			// no finalizer holds the deletion of constraints in read-only mode, so they are
+			// removed from OPA once they are gone
+			if util.ReadOnly() {
+				return reconcile.Result{}, r.removeDeleted(request.NamespacedName.Namespace, request.NamespacedName.Name)
+			}
 			// Object not found, return.  Created objects are automatically garbage collected.
 			// For additional cleanup logic use finalizers.
 			return reconcile.Result{}, nil
@@ -113,7 +143,7 @@ func (r *ReconcileConstraint) Reconcile(request reconcile.Request) (reconcile.Re
 
 	if instance.GetDeletionTimestamp().IsZero() {
 		if !HasFinalizer(instance) {
-			instance.SetFinalizers(append(instance.GetFinalizers(), finalizerName))
+			instance.SetFinalizers(append(instance.GetFinalizers(), finalizerName()))
 			if err := r.Update(context.Background(), instance); err != nil {
 				return reconcile.Result{Requeue: true}, nil
 			}
@@ -126,25 +156,73 @@ func (r *ReconcileConstraint) Reconcile(request reconcile.Request) (reconcile.Re
 		delete(status, "errors")
 		util.SetHAStatus(instance, status)
 
-		if _, err := r.opa.AddConstraint(context.Background(), instance); err != nil {
+		enforced, err := ToClusterConstraint(instance)
+		if err != nil {
+			return r.loadFailed(instance, err)
+		}
+		if util.Disabled(instance) {
+			return r.disable(instance, enforced)
+		}
+		schema, err := r.parametersSchema()
+		if err != nil {
 			return reconcile.Result{}, err
 		}
+		if err := validateParameters(schema, enforced); err != nil {
+			return r.rejectParameters(instance, enforced, err)
+		}
+		// listed before it is loaded so that no review it can match misses it
+		r.index.Add(enforced)
+		if _, err := r.opa.AddConstraint(context.Background(), enforced); err != nil {
+			return r.loadFailed(instance, err)
+		}
+		r.index.Trim(enforced)
+		util.RecordDebugConstraint(enforced)
 		status, err = util.GetHAStatus(instance)
 		if err != nil {
 			return reconcile.Result{}, err
 		}
 		status["enforced"] = true
 		util.SetHAStatus(instance, status)
-		if err := r.Update(context.Background(), instance); err != nil {
+		now := time.Now()
+		if err := setLoaded(instance, now); err != nil {
+			return reconcile.Result{}, err
+		}
+		var pending bool
+		if r.kinds != nil {
+			missing, err := r.kinds.unserved(instance)
+			if err != nil {
+				r.log.Error(err, "unable to discover the kinds served by the API server", "name", instance.GetName())
+			} else if err := setPending(instance, missing, now); err != nil {
+				return reconcile.Result{}, err
+			}
+			pending = len(missing) != 0
+		}
+		if util.StrictSpecValidation() {
+			unknown := unknownFields(instance, schema)
+			if len(unknown) != 0 {
+				r.log.Error(fmt.Errorf("unknown fields %s", strings.Join(unknown, ", ")), "constraint has fields that are ignored", "name", instance.GetName())
+			}
+			if err := setUnknownFields(instance, unknown, now); err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+		if err := util.UpdateWithStatus(context.Background(), r, instance); err != nil {
 			return reconcile.Result{Requeue: true}, nil
 		}
+		// the Enforced condition changes once the grace period ends, or once a kind of a pending
+		// constraint is served
+		remaining := gracePeriodRemaining(instance, now)
+		if pending && (remaining <= 0 || pendingRecheckInterval < remaining) {
+			remaining = pendingRecheckInterval
+		}
+		if remaining > 0 {
+			return reconcile.Result{RequeueAfter: remaining}, nil
+		}
 	} else {
 		// Handle deletion
-		if HasFinalizer(instance) {
-			if _, err := r.opa.RemoveConstraint(context.Background(), instance); err != nil {
-				if _, ok := err.(*opa.UnrecognizedConstraintError); !ok {
-					return reconcile.Result{}, err
-				}
+		if HasFinalizer(instance) || util.ReadOnly() {
+			if err := r.remove(instance); err != nil {
+				return reconcile.Result{}, err
 			}
 			RemoveFinalizer(instance)
 			if err := r.Update(context.Background(), instance); err != nil {
@@ -156,12 +234,104 @@ func (r *ReconcileConstraint) Reconcile(request reconcile.Request) (reconcile.Re
 	return reconcile.Result{}, nil
 }
 
+// remove removes the cluster constraint of instance from OPA
+func (r *ReconcileConstraint) remove(instance *unstructured.Unstructured) error {
+	enforced, err := ToClusterConstraint(instance)
+	if err != nil {
+		return err
+	}
+	if _, err := r.opa.RemoveConstraint(context.Background(), enforced); err != nil {
+		if _, ok := err.(*opa.UnrecognizedConstraintError); !ok {
+			return err
+		}
+	}
+	util.ForgetDebugConstraint(enforced)
+	r.index.Remove(enforced)
+	return nil
+}
+
+// removeDeleted removes the deleted constraint of the kind of r with namespace and name from OPA
+func (r *ReconcileConstraint) removeDeleted(namespace, name string) error {
+	instance := &unstructured.Unstructured{}
+	instance.SetGroupVersionKind(r.gvk)
+	instance.SetNamespace(namespace)
+	instance.SetName(name)
+	r.log.Info("removing deleted constraint", "name", name, "namespace", namespace)
+	return r.remove(instance)
+}
+
+// loadFailed reports in the conditions of instance that it could not be loaded because of
+// cause, which is returned so loading is retried
+func (r *ReconcileConstraint) loadFailed(instance *unstructured.Unstructured, cause error) (reconcile.Result, error) {
+	if err := setLoadFailed(instance, cause, time.Now()); err != nil {
+		return reconcile.Result{}, err
+	}
+	if err := util.UpdateWithStatus(context.Background(), r, instance); err != nil {
+		log.Error(err, "unable to report load failure in constraint status", "name", instance.GetName())
+	}
+	return reconcile.Result{}, cause
+}
+
+// rejectParameters removes enforced, the cluster constraint of instance, from OPA and reports in
+// the conditions of instance that its parameters are invalid because of cause. Retrying cannot
+// fix the constraint, but a change to its template can, so it is checked again after
+// parametersRecheckInterval.
+func (r *ReconcileConstraint) rejectParameters(instance, enforced *unstructured.Unstructured, cause error) (reconcile.Result, error) {
+	r.log.Info("rejecting constraint with invalid parameters", "name", instance.GetName(), "error", cause.Error())
+	if _, err := r.opa.RemoveConstraint(context.Background(), enforced); err != nil {
+		if _, ok := err.(*opa.UnrecognizedConstraintError); !ok {
+			return reconcile.Result{}, err
+		}
+	}
+	util.ForgetDebugConstraint(enforced)
+	r.index.Remove(enforced)
+	status, err := util.GetHAStatus(instance)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	status["enforced"] = false
+	util.SetHAStatus(instance, status)
+	if err := setInvalidParameters(instance, cause, time.Now()); err != nil {
+		return reconcile.Result{}, err
+	}
+	if err := util.UpdateWithStatus(context.Background(), r, instance); err != nil {
+		return reconcile.Result{Requeue: true}, nil
+	}
+	return reconcile.Result{RequeueAfter: parametersRecheckInterval}, nil
+}
+
+// disable removes enforced, the cluster constraint of instance, from OPA and reports in the
+// conditions of instance that it is disabled, until util.DisabledLabel is removed from it
+func (r *ReconcileConstraint) disable(instance, enforced *unstructured.Unstructured) (reconcile.Result, error) {
+	r.log.Info("disabling constraint", "name", instance.GetName())
+	if _, err := r.opa.RemoveConstraint(context.Background(), enforced); err != nil {
+		if _, ok := err.(*opa.UnrecognizedConstraintError); !ok {
+			return reconcile.Result{}, err
+		}
+	}
+	util.ForgetDebugConstraint(enforced)
+	r.index.Remove(enforced)
+	status, err := util.GetHAStatus(instance)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	status["enforced"] = false
+	util.SetHAStatus(instance, status)
+	if err := setDisabled(instance, time.Now()); err != nil {
+		return reconcile.Result{}, err
+	}
+	if err := util.UpdateWithStatus(context.Background(), r, instance); err != nil {
+		return reconcile.Result{Requeue: true}, nil
+	}
+	return reconcile.Result{}, nil
+}
+
 func RemoveFinalizer(instance *unstructured.Unstructured) {
-	instance.SetFinalizers(removeString(finalizerName, instance.GetFinalizers()))
+	instance.SetFinalizers(removeString(finalizerName(), instance.GetFinalizers()))
 }
 
 func HasFinalizer(instance *unstructured.Unstructured) bool {
-	return containsString(finalizerName, instance.GetFinalizers())
+	return containsString(finalizerName(), instance.GetFinalizers())
 }
 
 func containsString(s string, items []string) bool {
@@ -0,0 +1,757 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package constraint
+
+import (
+	"context"
+	"flag"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+	templv1beta1 "github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
+	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers/local"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/core/templates"
+	"github.com/open-policy-agent/gatekeeper/pkg/apis"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ client.Client = &fakeClient{}
+
+// fakeClient serves a single constraint, or none if it is nil, and records its updates. It also serves templ, or
+// no template if it is nil.
+type fakeClient struct {
+	obj   *unstructured.Unstructured
+	templ *templv1beta1.ConstraintTemplate
+}
+
+func (c *fakeClient) Get(ctx context.Context, key client.ObjectKey, obj k8sruntime.Object) error {
+	if templ, ok := obj.(*templv1beta1.ConstraintTemplate); ok {
+		if c.templ == nil {
+			return errors.NewNotFound(schema.GroupResource{Resource: "constrainttemplates"}, key.Name)
+		}
+		c.templ.DeepCopyInto(templ)
+		return nil
+	}
+	if c.obj == nil {
+		return errors.NewNotFound(schema.GroupResource{Resource: "constraints"}, key.Name)
+	}
+	c.obj.DeepCopyInto(obj.(*unstructured.Unstructured))
+	return nil
+}
+
+func (c *fakeClient) List(ctx context.Context, opts *client.ListOptions, list k8sruntime.Object) error {
+	return nil
+}
+
+func (c *fakeClient) Create(ctx context.Context, obj k8sruntime.Object) error {
+	return nil
+}
+
+func (c *fakeClient) Delete(ctx context.Context, obj k8sruntime.Object, opts ...client.DeleteOptionFunc) error {
+	return nil
+}
+
+func (c *fakeClient) Update(ctx context.Context, obj k8sruntime.Object) error {
+	c.obj = obj.(*unstructured.Unstructured).DeepCopy()
+	return nil
+}
+
+func (c *fakeClient) Status() client.StatusWriter {
+	return c
+}
+
+const cluster_constraint = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sDenyAll
+metadata:
+  name: no-pods
+spec:
+  enforcementAction: dryrun
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Pod"]
+`
+
+const required_labels_template = `
+apiVersion: templates.gatekeeper.sh/v1beta1
+kind: ConstraintTemplate
+metadata:
+  name: k8srequiredlabels
+spec:
+  crd:
+    spec:
+      names:
+        kind: K8sRequiredLabels
+      validation:
+        openAPIV3Schema:
+          properties:
+            labels:
+              type: array
+              items:
+                type: string
+            exempt:
+              type: boolean
+          required: ["labels"]
+  targets:
+    - target: admission.k8s.gatekeeper.sh
+      rego: |
+        package k8srequiredlabels
+
+        violation[{"msg": msg}] {
+          label := input.parameters.labels[_]
+          not input.review.object.metadata.labels[label]
+          msg := sprintf("missing label %v", [label])
+        }
+`
+
+// requiredLabelsConstraint returns a K8sRequiredLabels constraint with parameters
+func requiredLabelsConstraint(t *testing.T, parameters string) *unstructured.Unstructured {
+	return parseConstraint(t, `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sRequiredLabels
+metadata:
+  name: must-have-owner
+spec:
+  parameters: `+parameters)
+}
+
+// condition returns the status, message and lastTransitionTime of the condition of condType of obj
+func condition(t *testing.T, obj *unstructured.Unstructured, condType string) (string, string, string) {
+	conditions, _, err := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	if err != nil {
+		t.Fatalf("could not read conditions: %s", err)
+	}
+	for _, c := range conditions {
+		cond := c.(map[string]interface{})
+		if cond["type"] == condType {
+			return cond["status"].(string), cond["message"].(string), cond["lastTransitionTime"].(string)
+		}
+	}
+	t.Fatalf("no %s condition in %v", condType, conditions)
+	return "", "", ""
+}
+
+func TestReconcileConditions(t *testing.T) {
+	driver := local.New(local.Tracing(false))
+	backend, err := opa.NewBackend(opa.Driver(driver))
+	if err != nil {
+		t.Fatalf("Could not create backend: %s", err)
+	}
+	c, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+	cstr := parseConstraint(t, cluster_constraint)
+	fc := &fakeClient{obj: cstr}
+	r := &ReconcileConstraint{Client: fc, opa: c, gvk: cstr.GroupVersionKind(), log: log}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: cstr.GetName()}}
+
+	// the template is not loaded yet
+	if _, err := r.Reconcile(req); err == nil {
+		t.Fatal("Reconcile() err = nil without a template; want an error")
+	}
+	if status, _, _ := condition(t, fc.obj, ReadyCondition); status != "False" {
+		t.Errorf("Ready = %s without a template; want False", status)
+	}
+	status, message, failedAt := condition(t, fc.obj, ErrorCondition)
+	if status != "True" || message == "" {
+		t.Errorf("Error = %s with message %q without a template; want True with the error", status, message)
+	}
+
+	scheme := k8sruntime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Fatalf("Could not build scheme: %s", err)
+	}
+	templ := &templv1beta1.ConstraintTemplate{}
+	if err := yaml.Unmarshal([]byte(deny_all_template), templ); err != nil {
+		t.Fatalf("Could not parse template: %s", err)
+	}
+	unversioned := &templates.ConstraintTemplate{}
+	if err := scheme.Convert(templ, unversioned, nil); err != nil {
+		t.Fatalf("Could not convert template: %s", err)
+	}
+	if _, err := c.AddTemplate(context.Background(), unversioned); err != nil {
+		t.Fatalf("Could not add template: %s", err)
+	}
+
+	// lastTransitionTime has a resolution of a second
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := r.Reconcile(req); err != nil {
+		t.Fatalf("Reconcile() err = %s", err)
+	}
+	status, _, readyAt := condition(t, fc.obj, ReadyCondition)
+	if status != "True" {
+		t.Errorf("Ready = %s once the template is loaded; want True", status)
+	}
+	status, _, clearedAt := condition(t, fc.obj, ErrorCondition)
+	if status != "False" {
+		t.Errorf("Error = %s once the template is loaded; want False", status)
+	}
+	if clearedAt == failedAt {
+		t.Errorf("Error lastTransitionTime not updated when it became False")
+	}
+	if status, message, _ := condition(t, fc.obj, EnforcedCondition); status != "False" || message != "enforcementAction is dryrun" {
+		t.Errorf("Enforced = %s with message %q for a dryrun constraint; want False", status, message)
+	}
+
+	// reconciling again keeps the transition times
+	if _, err := r.Reconcile(req); err != nil {
+		t.Fatalf("Reconcile() err = %s", err)
+	}
+	if _, _, got := condition(t, fc.obj, ReadyCondition); got != readyAt {
+		t.Errorf("Ready lastTransitionTime = %s; want %s", got, readyAt)
+	}
+}
+
+const widget_constraint = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sDenyAll
+metadata:
+  name: no-widgets
+spec:
+  match:
+    kinds:
+      - apiGroups: ["example.com"]
+        kinds: ["Widget", "Gadget"]
+`
+
+func TestReconcilePendingKinds(t *testing.T) {
+	defer func(interval time.Duration) { mapperRefreshInterval = interval }(mapperRefreshInterval)
+	mapperRefreshInterval = 0
+	driver := local.New(local.Tracing(false))
+	backend, err := opa.NewBackend(opa.Driver(driver))
+	if err != nil {
+		t.Fatalf("Could not create backend: %s", err)
+	}
+	c, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+	scheme := k8sruntime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Fatalf("Could not build scheme: %s", err)
+	}
+	templ := &templv1beta1.ConstraintTemplate{}
+	if err := yaml.Unmarshal([]byte(deny_all_template), templ); err != nil {
+		t.Fatalf("Could not parse template: %s", err)
+	}
+	unversioned := &templates.ConstraintTemplate{}
+	if err := scheme.Convert(templ, unversioned, nil); err != nil {
+		t.Fatalf("Could not convert template: %s", err)
+	}
+	if _, err := c.AddTemplate(context.Background(), unversioned); err != nil {
+		t.Fatalf("Could not add template: %s", err)
+	}
+
+	// the API server serves the kinds of the mapper, and Widgets once their CRD is installed
+	installed := false
+	newMapper := func() (meta.RESTMapper, error) {
+		mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{{Version: "v1"}, {Group: "example.com", Version: "v1"}})
+		mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Pod"}, meta.RESTScopeNamespace)
+		if installed {
+			mapper.Add(schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}, meta.RESTScopeNamespace)
+		}
+		return mapper, nil
+	}
+	mapper, _ := newMapper()
+	cstr := parseConstraint(t, widget_constraint)
+	fc := &fakeClient{obj: cstr}
+	r := &ReconcileConstraint{Client: fc, opa: c, gvk: cstr.GroupVersionKind(), log: log, kinds: newServedKinds(mapper, newMapper)}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: cstr.GetName()}}
+
+	result, err := r.Reconcile(req)
+	if err != nil {
+		t.Fatalf("Reconcile() err = %s", err)
+	}
+	if status, message, _ := condition(t, fc.obj, PendingCondition); status != "True" || message != "none of the kinds the constraint matches is served: Gadget.example.com, Widget.example.com" {
+		t.Errorf("Pending = %s with message %q before the CRD is installed; want True with the missing kinds", status, message)
+	}
+	if status, _, _ := condition(t, fc.obj, EnforcedCondition); status != "False" {
+		t.Errorf("Enforced = %s before the CRD is installed; want False", status)
+	}
+	if status, _, _ := condition(t, fc.obj, ReadyCondition); status != "True" {
+		t.Errorf("Ready = %s before the CRD is installed; want True, as the constraint is loaded", status)
+	}
+	if result.RequeueAfter != pendingRecheckInterval {
+		t.Errorf("RequeueAfter = %s; want %s to check the kinds again", result.RequeueAfter, pendingRecheckInterval)
+	}
+
+	// installing the CRD activates the constraint when it is checked again
+	installed = true
+	result, err = r.Reconcile(req)
+	if err != nil {
+		t.Fatalf("Reconcile() err = %s", err)
+	}
+	if status, _, _ := condition(t, fc.obj, PendingCondition); status != "False" {
+		t.Errorf("Pending = %s once the CRD is installed; want False", status)
+	}
+	if status, message, _ := condition(t, fc.obj, EnforcedCondition); status != "True" || message != "enforcementAction is deny" {
+		t.Errorf("Enforced = %s with message %q once the CRD is installed; want True", status, message)
+	}
+	if result.RequeueAfter != 0 {
+		t.Errorf("RequeueAfter = %s once the CRD is installed; want 0", result.RequeueAfter)
+	}
+}
+
+func TestValidateParameters(t *testing.T) {
+	templ := &templv1beta1.ConstraintTemplate{}
+	if err := yaml.Unmarshal([]byte(required_labels_template), templ); err != nil {
+		t.Fatalf("Could not parse template: %s", err)
+	}
+	schema := templ.Spec.CRD.Spec.Validation.OpenAPIV3Schema
+	tc := []struct {
+		Name       string
+		Parameters string
+		Invalid    []string
+	}{
+		{Name: "valid", Parameters: `{"labels": ["owner"], "exempt": false}`},
+		{Name: "wrong type", Parameters: `{"labels": "owner"}`, Invalid: []string{"labels must be of type array"}},
+		{Name: "wrong item type", Parameters: `{"labels": [1]}`, Invalid: []string{"labels must be of type string"}},
+		{Name: "missing", Parameters: `{"lables": ["owner"]}`, Invalid: []string{"labels is required"}},
+		{Name: "several errors", Parameters: `{"labels": "owner", "exempt": "yes"}`, Invalid: []string{"labels must be of type array", "exempt must be of type boolean"}},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := validateParameters(schema, requiredLabelsConstraint(t, tt.Parameters))
+			if len(tt.Invalid) == 0 {
+				if err != nil {
+					t.Errorf("validateParameters() err = %s; want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("validateParameters() err = nil; want an error")
+			}
+			for _, msg := range tt.Invalid {
+				if !strings.Contains(err.Error(), msg) {
+					t.Errorf("validateParameters() err = %q; want it to contain %q", err, msg)
+				}
+			}
+		})
+	}
+	if err := validateParameters(nil, requiredLabelsConstraint(t, `{"labels": "owner"}`)); err != nil {
+		t.Errorf("validateParameters() err = %s without a schema; want nil", err)
+	}
+}
+
+func TestReconcileInvalidParameters(t *testing.T) {
+	driver := local.New(local.Tracing(false))
+	backend, err := opa.NewBackend(opa.Driver(driver))
+	if err != nil {
+		t.Fatalf("Could not create backend: %s", err)
+	}
+	c, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+	scheme := k8sruntime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Fatalf("Could not build scheme: %s", err)
+	}
+	templ := &templv1beta1.ConstraintTemplate{}
+	if err := yaml.Unmarshal([]byte(required_labels_template), templ); err != nil {
+		t.Fatalf("Could not parse template: %s", err)
+	}
+	unversioned := &templates.ConstraintTemplate{}
+	if err := scheme.Convert(templ, unversioned, nil); err != nil {
+		t.Fatalf("Could not convert template: %s", err)
+	}
+	if _, err := c.AddTemplate(context.Background(), unversioned); err != nil {
+		t.Fatalf("Could not add template: %s", err)
+	}
+	cstr := requiredLabelsConstraint(t, `{"labels": ["owner"]}`)
+	fc := &fakeClient{obj: cstr, templ: templ}
+	r := &ReconcileConstraint{Client: fc, opa: c, gvk: cstr.GroupVersionKind(), log: log}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: cstr.GetName()}}
+	loaded := func() bool {
+		resp, err := c.Dump(context.Background())
+		if err != nil {
+			t.Fatalf("Dump() err = %s", err)
+		}
+		return strings.Contains(resp, "must-have-owner")
+	}
+
+	if _, err := r.Reconcile(req); err != nil {
+		t.Fatalf("Reconcile() err = %s", err)
+	}
+	if status, _, _ := condition(t, fc.obj, ReadyCondition); status != "True" {
+		t.Errorf("Ready = %s with valid parameters; want True", status)
+	}
+	if !loaded() {
+		t.Error("constraint with valid parameters not loaded")
+	}
+
+	if err := unstructured.SetNestedField(fc.obj.Object, "owner", "spec", "parameters", "labels"); err != nil {
+		t.Fatalf("Could not set parameters: %s", err)
+	}
+	result, err := r.Reconcile(req)
+	if err != nil {
+		t.Fatalf("Reconcile() err = %s with invalid parameters; want nil as retrying cannot fix them", err)
+	}
+	if result.RequeueAfter != parametersRecheckInterval {
+		t.Errorf("Reconcile() RequeueAfter = %s with invalid parameters; want %s", result.RequeueAfter, parametersRecheckInterval)
+	}
+	if status, _, _ := condition(t, fc.obj, ReadyCondition); status != "False" {
+		t.Errorf("Ready = %s with invalid parameters; want False", status)
+	}
+	if status, message, _ := condition(t, fc.obj, ErrorCondition); status != "True" || !strings.Contains(message, "labels must be of type array") {
+		t.Errorf("Error = %s with message %q with invalid parameters; want True naming the invalid field", status, message)
+	}
+	if loaded() {
+		t.Error("constraint with invalid parameters still loaded")
+	}
+}
+
+func TestReconcileGracePeriod(t *testing.T) {
+	defer flag.Set("constraint-grace-period", "0")
+	flag.Set("constraint-grace-period", "1h")
+
+	driver := local.New(local.Tracing(false))
+	backend, err := opa.NewBackend(opa.Driver(driver))
+	if err != nil {
+		t.Fatalf("Could not create backend: %s", err)
+	}
+	c, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+	scheme := k8sruntime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Fatalf("Could not build scheme: %s", err)
+	}
+	templ := &templv1beta1.ConstraintTemplate{}
+	if err := yaml.Unmarshal([]byte(deny_all_template), templ); err != nil {
+		t.Fatalf("Could not parse template: %s", err)
+	}
+	unversioned := &templates.ConstraintTemplate{}
+	if err := scheme.Convert(templ, unversioned, nil); err != nil {
+		t.Fatalf("Could not convert template: %s", err)
+	}
+	if _, err := c.AddTemplate(context.Background(), unversioned); err != nil {
+		t.Fatalf("Could not add template: %s", err)
+	}
+
+	tc := []struct {
+		Name     string
+		Age      time.Duration
+		Enforced string
+		Requeue  bool
+	}{
+		{Name: "within grace period", Age: 10 * time.Minute, Enforced: "False", Requeue: true},
+		{Name: "past grace period", Age: 2 * time.Hour, Enforced: "True"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			cstr := parseConstraint(t, cluster_constraint)
+			unstructured.SetNestedField(cstr.Object, "deny", "spec", "enforcementAction")
+			cstr.SetCreationTimestamp(metav1.NewTime(time.Now().Add(-tt.Age)))
+			fc := &fakeClient{obj: cstr}
+			r := &ReconcileConstraint{Client: fc, opa: c, gvk: cstr.GroupVersionKind(), log: log}
+			req := reconcile.Request{NamespacedName: types.NamespacedName{Name: cstr.GetName()}}
+
+			result, err := r.Reconcile(req)
+			if err != nil {
+				t.Fatalf("Reconcile() err = %s", err)
+			}
+			if status, message, _ := condition(t, fc.obj, EnforcedCondition); status != tt.Enforced {
+				t.Errorf("Enforced = %s with message %q; want %s", status, message, tt.Enforced)
+			}
+			if requeued := result.RequeueAfter > 0; requeued != tt.Requeue {
+				t.Errorf("Reconcile() RequeueAfter = %s; want requeued = %v", result.RequeueAfter, tt.Requeue)
+			}
+			if tt.Requeue && result.RequeueAfter > 50*time.Minute {
+				t.Errorf("Reconcile() RequeueAfter = %s; want at most the 50m left of the grace period", result.RequeueAfter)
+			}
+		})
+	}
+}
+
+func TestReconcileUnknownFields(t *testing.T) {
+	defer flag.Set("strict-spec-validation", "false")
+	flag.Set("strict-spec-validation", "true")
+
+	driver := local.New(local.Tracing(false))
+	backend, err := opa.NewBackend(opa.Driver(driver))
+	if err != nil {
+		t.Fatalf("Could not create backend: %s", err)
+	}
+	c, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+	scheme := k8sruntime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Fatalf("Could not build scheme: %s", err)
+	}
+	templ := &templv1beta1.ConstraintTemplate{}
+	if err := yaml.Unmarshal([]byte(required_labels_template), templ); err != nil {
+		t.Fatalf("Could not parse template: %s", err)
+	}
+	unversioned := &templates.ConstraintTemplate{}
+	if err := scheme.Convert(templ, unversioned, nil); err != nil {
+		t.Fatalf("Could not convert template: %s", err)
+	}
+	if _, err := c.AddTemplate(context.Background(), unversioned); err != nil {
+		t.Fatalf("Could not add template: %s", err)
+	}
+
+	cstr := parseConstraint(t, `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sRequiredLabels
+metadata:
+  name: must-have-owner
+spec:
+  enforcmentAction: deny
+  match:
+    kinds:
+      - apiGroups: [""]
+        kind: ["Namespace"]
+    namespace: ["default"]
+    labelSelector:
+      matchLabels:
+        team: a
+  parameters:
+    labels: ["owner"]
+    exempted: true
+`)
+	fc := &fakeClient{obj: cstr, templ: templ}
+	r := &ReconcileConstraint{Client: fc, opa: c, gvk: cstr.GroupVersionKind(), log: log}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: cstr.GetName()}}
+	if _, err := r.Reconcile(req); err != nil {
+		t.Fatalf("Reconcile() err = %s", err)
+	}
+	if status, _, _ := condition(t, fc.obj, ReadyCondition); status != "True" {
+		t.Errorf("Ready = %s with unknown fields; want True as they are ignored", status)
+	}
+	expected := "ignoring unknown fields spec.enforcmentAction, spec.match.kinds[0].kind, spec.match.namespace, spec.parameters.exempted"
+	if status, message, _ := condition(t, fc.obj, UnknownFieldsCondition); status != "True" || message != expected {
+		t.Errorf("UnknownFields = %s with message %q; want True with message %q", status, message, expected)
+	}
+
+	// fixing the typos clears the condition
+	spec := fc.obj.Object["spec"].(map[string]interface{})
+	delete(spec, "enforcmentAction")
+	match := spec["match"].(map[string]interface{})
+	delete(match, "namespace")
+	match["kinds"] = []interface{}{map[string]interface{}{"apiGroups": []interface{}{""}, "kinds": []interface{}{"Namespace"}}}
+	delete(spec["parameters"].(map[string]interface{}), "exempted")
+	if _, err := r.Reconcile(req); err != nil {
+		t.Fatalf("Reconcile() err = %s", err)
+	}
+	if status, message, _ := condition(t, fc.obj, UnknownFieldsCondition); status != "False" {
+		t.Errorf("UnknownFields = %s with message %q once the typos are fixed; want False", status, message)
+	}
+}
+
+func TestReconcileDisabled(t *testing.T) {
+	driver := local.New(local.Tracing(false))
+	backend, err := opa.NewBackend(opa.Driver(driver))
+	if err != nil {
+		t.Fatalf("Could not create backend: %s", err)
+	}
+	c, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+	scheme := k8sruntime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Fatalf("Could not build scheme: %s", err)
+	}
+	templ := &templv1beta1.ConstraintTemplate{}
+	if err := yaml.Unmarshal([]byte(deny_all_template), templ); err != nil {
+		t.Fatalf("Could not parse template: %s", err)
+	}
+	unversioned := &templates.ConstraintTemplate{}
+	if err := scheme.Convert(templ, unversioned, nil); err != nil {
+		t.Fatalf("Could not convert template: %s", err)
+	}
+	if _, err := c.AddTemplate(context.Background(), unversioned); err != nil {
+		t.Fatalf("Could not add template: %s", err)
+	}
+
+	// payments is disabled during an incident, while no-pods keeps being enforced
+	enabled := parseConstraint(t, cluster_constraint)
+	disabled := parseConstraint(t, cluster_constraint)
+	disabled.SetName("payments-no-pods")
+	disabled.SetLabels(map[string]string{"team": "payments", util.DisabledLabel: "true"})
+	reconcilers := make(map[string]*ReconcileConstraint)
+	for _, cstr := range []*unstructured.Unstructured{enabled, disabled} {
+		r := &ReconcileConstraint{Client: &fakeClient{obj: cstr}, opa: c, gvk: cstr.GroupVersionKind(), log: log}
+		reconcilers[cstr.GetName()] = r
+		if _, err := r.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: cstr.GetName()}}); err != nil {
+			t.Fatalf("Reconcile() of %s err = %s", cstr.GetName(), err)
+		}
+	}
+	reviewed := func() []string {
+		req := &admissionv1beta1.AdmissionRequest{
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Name:      "a",
+			Namespace: "default",
+			Operation: admissionv1beta1.Create,
+			Object:    k8sruntime.RawExtension{Raw: []byte(`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "a", "namespace": "default"}}`)},
+		}
+		resp, err := c.Review(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Review() err = %s", err)
+		}
+		var names []string
+		for _, r := range resp.Results() {
+			names = append(names, r.Constraint.GetName())
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	if got := reviewed(); !reflect.DeepEqual(got, []string{"no-pods"}) {
+		t.Errorf("violated constraints = %v; want [no-pods] while payments-no-pods is disabled", got)
+	}
+	fc := reconcilers["payments-no-pods"].Client.(*fakeClient)
+	if status, message, _ := condition(t, fc.obj, EnforcedCondition); status != "False" || !strings.Contains(message, util.DisabledLabel) {
+		t.Errorf("Enforced = %s with message %q for a disabled constraint; want False naming the label", status, message)
+	}
+	if status, _, _ := condition(t, fc.obj, ReadyCondition); status != "False" {
+		t.Errorf("Ready = %s for a disabled constraint; want False", status)
+	}
+
+	// re-enabling is removing the label
+	fc.obj.SetLabels(map[string]string{"team": "payments"})
+	if _, err := reconcilers["payments-no-pods"].Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "payments-no-pods"}}); err != nil {
+		t.Fatalf("Reconcile() err = %s", err)
+	}
+	if got := reviewed(); !reflect.DeepEqual(got, []string{"no-pods", "payments-no-pods"}) {
+		t.Errorf("violated constraints = %v; want both once payments-no-pods is enabled again", got)
+	}
+	if status, _, _ := condition(t, fc.obj, ReadyCondition); status != "True" {
+		t.Errorf("Ready = %s once the label is removed; want True", status)
+	}
+}
+
+func TestReconcileReadOnlyDeletion(t *testing.T) {
+	for _, mode := range []string{"read-only", "dry-run"} {
+		t.Run(mode, func(t *testing.T) {
+			defer flag.Set(mode, "false")
+			flag.Set(mode, "true")
+			driver := local.New(local.Tracing(false))
+			backend, err := opa.NewBackend(opa.Driver(driver))
+			if err != nil {
+				t.Fatalf("Could not create backend: %s", err)
+			}
+			c, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+			if err != nil {
+				t.Fatalf("Could not create client: %s", err)
+			}
+			scheme := k8sruntime.NewScheme()
+			if err := apis.AddToScheme(scheme); err != nil {
+				t.Fatalf("Could not build scheme: %s", err)
+			}
+			templ := &templv1beta1.ConstraintTemplate{}
+			if err := yaml.Unmarshal([]byte(deny_all_template), templ); err != nil {
+				t.Fatalf("Could not parse template: %s", err)
+			}
+			unversioned := &templates.ConstraintTemplate{}
+			if err := scheme.Convert(templ, unversioned, nil); err != nil {
+				t.Fatalf("Could not convert template: %s", err)
+			}
+			if _, err := c.AddTemplate(context.Background(), unversioned); err != nil {
+				t.Fatalf("Could not add template: %s", err)
+			}
+			violated := func() bool {
+				req := &admissionv1beta1.AdmissionRequest{
+					Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+					Name:      "a",
+					Namespace: "default",
+					Operation: admissionv1beta1.Create,
+					Object:    k8sruntime.RawExtension{Raw: []byte(`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "a", "namespace": "default"}}`)},
+				}
+				resp, err := c.Review(context.Background(), req)
+				if err != nil {
+					t.Fatalf("Review() err = %s", err)
+				}
+				return len(resp.Results()) != 0
+			}
+			index := target.NewConstraintIndex()
+			indexed := func(name string) bool {
+				for _, key := range index.Candidates("", "Pod") {
+					if key.Name == name {
+						return true
+					}
+				}
+				return false
+			}
+			loaded := func(name string) (*fakeClient, *ReconcileConstraint) {
+				cstr := parseConstraint(t, cluster_constraint)
+				cstr.SetName(name)
+				fc := &fakeClient{obj: cstr}
+				r := &ReconcileConstraint{Client: fc, opa: c, index: index, gvk: cstr.GroupVersionKind(), log: log}
+				if _, err := r.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: name}}); err != nil {
+					t.Fatalf("Reconcile() err = %s", err)
+				}
+				if !violated() {
+					t.Fatalf("constraint %s not loaded", name)
+				}
+				if !indexed(name) {
+					t.Fatalf("constraint %s not indexed", name)
+				}
+				return fc, r
+			}
+
+			// no finalizer is written, so the constraint is gone by the time it is reconciled
+			fc, r := loaded("gone")
+			fc.obj = nil
+			if _, err := r.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "gone"}}); err != nil {
+				t.Fatalf("Reconcile() err = %s", err)
+			}
+			if violated() {
+				t.Errorf("deleted constraint still enforced with --%s", mode)
+			}
+			if indexed("gone") {
+				t.Errorf("deleted constraint still indexed with --%s", mode)
+			}
+
+			// the finalizer of another client holds the deletion
+			fc, r = loaded("terminating")
+			fc.obj.SetFinalizers([]string{"example.com/other"})
+			now := metav1.Now()
+			fc.obj.SetDeletionTimestamp(&now)
+			if _, err := r.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "terminating"}}); err != nil {
+				t.Fatalf("Reconcile() err = %s", err)
+			}
+			if violated() {
+				t.Errorf("constraint being deleted still enforced with --%s", mode)
+			}
+			if indexed("terminating") {
+				t.Errorf("constraint being deleted still indexed with --%s", mode)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package constraint
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var enableNamespacedConstraints = flag.Bool("enable-namespaced-constraints", false, "serve a namespaced variant of every constraint kind in the "+NamespacedGroup+" group. A namespaced constraint only applies to resources in its own namespace")
+
+const (
+	// Group is the API group of cluster-scoped constraints
+	Group = "constraints.gatekeeper.sh"
+	// NamespacedGroup is the API group of namespaced constraints
+	NamespacedGroup = "namespaced.constraints.gatekeeper.sh"
+
+	// namespacedPrefix starts the name OPA knows a namespaced constraint by
+	namespacedPrefix = "namespaced."
+)
+
+// NamespacedConstraintsEnabled returns true if namespaced constraints are served
+func NamespacedConstraintsEnabled() bool {
+	return *enableNamespacedConstraints
+}
+
+// Groups returns the API groups constraints are served in
+func Groups() []string {
+	if NamespacedConstraintsEnabled() {
+		return []string{Group, NamespacedGroup}
+	}
+	return []string{Group}
+}
+
+// IsNamespaced returns true if obj is a namespaced constraint
+func IsNamespaced(obj *unstructured.Unstructured) bool {
+	return obj.GroupVersionKind().Group == NamespacedGroup
+}
+
+// ValidateName returns an error if a cluster-scoped constraint uses a name reserved for
+// namespaced constraints
+func ValidateName(obj *unstructured.Unstructured) error {
+	if NamespacedConstraintsEnabled() && !IsNamespaced(obj) && strings.HasPrefix(obj.GetName(), namespacedPrefix) {
+		return fmt.Errorf("constraint names starting with %q are reserved for namespaced constraints", namespacedPrefix)
+	}
+	return nil
+}
+
+// ValidateNamespacedMatch returns an error if a namespaced constraint tries to match
+// namespaces other than its own
+func ValidateNamespacedMatch(obj *unstructured.Unstructured) error {
+	if !IsNamespaced(obj) {
+		return nil
+	}
+	namespaces, _, err := unstructured.NestedStringSlice(obj.Object, "spec", "match", "namespaces")
+	if err != nil {
+		return err
+	}
+	for _, ns := range namespaces {
+		if ns != obj.GetNamespace() {
+			return fmt.Errorf("namespaced constraint %s in namespace %s cannot match namespace %s", obj.GetName(), obj.GetNamespace(), ns)
+		}
+	}
+	return nil
+}
+
+// ToClusterConstraint returns the cluster-scoped constraint OPA enforces for obj. Namespaced
+// constraints share storage with cluster-scoped constraints of the same kind, so they are
+// renamed to namespaced.<namespace>.<name>, which namespace names cannot make ambiguous, and
+// their match is scoped to their own namespace. The selfLink is kept so that results can
+// be traced back to obj. Cluster-scoped constraints are returned as is.
+func ToClusterConstraint(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	if !IsNamespaced(obj) {
+		return obj, nil
+	}
+	out := obj.DeepCopy()
+	gvk := obj.GroupVersionKind()
+	gvk.Group = Group
+	out.SetGroupVersionKind(gvk)
+	out.SetName(namespacedPrefix + obj.GetNamespace() + "." + obj.GetName())
+	if err := unstructured.SetNestedStringSlice(out.Object, []string{obj.GetNamespace()}, "spec", "match", "namespaces"); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
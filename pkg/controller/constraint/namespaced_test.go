@@ -0,0 +1,210 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package constraint
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	templv1beta1 "github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
+	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers/local"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/core/templates"
+	"github.com/open-policy-agent/gatekeeper/pkg/apis"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	deny_all_template = `
+apiVersion: templates.gatekeeper.sh/v1beta1
+kind: ConstraintTemplate
+metadata:
+  name: k8sdenyall
+spec:
+  crd:
+    spec:
+      names:
+        kind: K8sDenyAll
+        listKind: K8sDenyAllList
+        plural: k8sdenyall
+        singular: k8sdenyall
+  targets:
+    - target: admission.k8s.gatekeeper.sh
+      rego: |
+        package denyall
+
+        violation[{"msg": "denied"}] {
+          true
+        }
+`
+
+	tenant_constraint = `
+apiVersion: namespaced.constraints.gatekeeper.sh/v1beta1
+kind: K8sDenyAll
+metadata:
+  name: no-pods
+  namespace: tenant-a
+  selfLink: /apis/namespaced.constraints.gatekeeper.sh/v1beta1/namespaces/tenant-a/k8sdenyall/no-pods
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Pod", "Namespace"]
+`
+)
+
+func parseConstraint(t *testing.T, src string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(src), &obj.Object); err != nil {
+		t.Fatalf("Could not parse constraint: %s", err)
+	}
+	return obj
+}
+
+func TestToClusterConstraint(t *testing.T) {
+	tenant := parseConstraint(t, tenant_constraint)
+	enforced, err := ToClusterConstraint(tenant)
+	if err != nil {
+		t.Fatalf("ToClusterConstraint() err = %s", err)
+	}
+	if got := enforced.GroupVersionKind().Group; got != Group {
+		t.Errorf("group = %s; want %s", got, Group)
+	}
+	if got := enforced.GetName(); got != "namespaced.tenant-a.no-pods" {
+		t.Errorf("name = %s; want namespaced.tenant-a.no-pods", got)
+	}
+	if got := enforced.GetSelfLink(); got != tenant.GetSelfLink() {
+		t.Errorf("selfLink = %s; want %s", got, tenant.GetSelfLink())
+	}
+	namespaces, _, _ := unstructured.NestedStringSlice(enforced.Object, "spec", "match", "namespaces")
+	if !reflect.DeepEqual(namespaces, []string{"tenant-a"}) {
+		t.Errorf("match.namespaces = %v; want [tenant-a]", namespaces)
+	}
+	if IsNamespaced(enforced) || !IsNamespaced(tenant) {
+		t.Errorf("IsNamespaced() does not tell the constraints apart")
+	}
+	if tenant.GetName() != "no-pods" || tenant.GroupVersionKind().Group != NamespacedGroup {
+		t.Errorf("ToClusterConstraint() modified its input: %v", tenant)
+	}
+
+	cluster := tenant.DeepCopy()
+	cluster.SetAPIVersion(Group + "/v1beta1")
+	if got, _ := ToClusterConstraint(cluster); got != cluster {
+		t.Errorf("ToClusterConstraint() changed a cluster-scoped constraint")
+	}
+}
+
+func TestValidateNamespaced(t *testing.T) {
+	defer flag.Set("enable-namespaced-constraints", "false")
+	flag.Set("enable-namespaced-constraints", "true")
+
+	tenant := parseConstraint(t, tenant_constraint)
+	if err := ValidateNamespacedMatch(tenant); err != nil {
+		t.Errorf("ValidateNamespacedMatch() err = %s; want nil", err)
+	}
+	unstructured.SetNestedStringSlice(tenant.Object, []string{"tenant-a"}, "spec", "match", "namespaces")
+	if err := ValidateNamespacedMatch(tenant); err != nil {
+		t.Errorf("ValidateNamespacedMatch() err = %s for its own namespace; want nil", err)
+	}
+	unstructured.SetNestedStringSlice(tenant.Object, []string{"tenant-a", "tenant-b"}, "spec", "match", "namespaces")
+	if err := ValidateNamespacedMatch(tenant); err == nil {
+		t.Errorf("ValidateNamespacedMatch() err = nil for another namespace; want an error")
+	}
+
+	cluster := parseConstraint(t, tenant_constraint)
+	cluster.SetAPIVersion(Group + "/v1beta1")
+	cluster.SetName("namespaced.tenant-a.no-pods")
+	if err := ValidateName(cluster); err == nil {
+		t.Errorf("ValidateName() err = nil for a reserved name; want an error")
+	}
+}
+
+func TestNamespacedConstraintScope(t *testing.T) {
+	driver := local.New(local.Tracing(false))
+	backend, err := opa.NewBackend(opa.Driver(driver))
+	if err != nil {
+		t.Fatalf("Could not create backend: %s", err)
+	}
+	c, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+	if err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+	scheme := k8sruntime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Fatalf("Could not build scheme: %s", err)
+	}
+	templ := &templv1beta1.ConstraintTemplate{}
+	if err := yaml.Unmarshal([]byte(deny_all_template), templ); err != nil {
+		t.Fatalf("Could not parse template: %s", err)
+	}
+	unversioned := &templates.ConstraintTemplate{}
+	if err := scheme.Convert(templ, unversioned, nil); err != nil {
+		t.Fatalf("Could not convert template: %s", err)
+	}
+	if _, err := c.AddTemplate(context.Background(), unversioned); err != nil {
+		t.Fatalf("Could not add template: %s", err)
+	}
+
+	// a tenant trying to widen its constraint is still scoped to its namespace
+	tenant := parseConstraint(t, tenant_constraint)
+	unstructured.SetNestedStringSlice(tenant.Object, []string{"tenant-b"}, "spec", "match", "namespaces")
+	enforced, err := ToClusterConstraint(tenant)
+	if err != nil {
+		t.Fatalf("ToClusterConstraint() err = %s", err)
+	}
+	if _, err := c.AddConstraint(context.Background(), enforced); err != nil {
+		t.Fatalf("Could not add constraint: %s", err)
+	}
+
+	tc := []struct {
+		Name      string
+		Kind      string
+		Namespace string
+		Denied    bool
+	}{
+		{Name: "Pod in own namespace", Kind: "Pod", Namespace: "tenant-a", Denied: true},
+		{Name: "Pod in other namespace", Kind: "Pod", Namespace: "tenant-b"},
+		{Name: "Cluster-scoped resource", Kind: "Namespace"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			req := &admissionv1beta1.AdmissionRequest{
+				Kind:      metav1.GroupVersionKind{Version: "v1", Kind: tt.Kind},
+				Name:      "a",
+				Namespace: tt.Namespace,
+				Operation: admissionv1beta1.Create,
+				Object: k8sruntime.RawExtension{
+					Raw: []byte(fmt.Sprintf(`{"apiVersion": "v1", "kind": "%s", "metadata": {"name": "a", "namespace": "%s"}}`, tt.Kind, tt.Namespace)),
+				},
+			}
+			resp, err := c.Review(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Review() err = %s", err)
+			}
+			if got := len(resp.Results()) != 0; got != tt.Denied {
+				t.Errorf("denied = %v; want %v", got, tt.Denied)
+			}
+		})
+	}
+}
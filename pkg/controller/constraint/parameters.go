@@ -0,0 +1,90 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package constraint
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	"k8s.io/apiextensions-apiserver/pkg/apiserver/validation"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// InvalidParametersReason is the reason of the conditions of a constraint whose parameters do
+// not match the schema declared by its template
+const InvalidParametersReason = "InvalidParameters"
+
+// parametersRecheckInterval is how often constraints rejected for their parameters are
+// validated again, in case the schema of their template changed
+const parametersRecheckInterval = time.Minute
+
+// parametersSchema returns the schema the template of r's kind declares for the parameters of
+// its constraints, or nil if the template is not found or declares none. Templates are named
+// after the lowercase of their kind.
+func (r *ReconcileConstraint) parametersSchema() (*apiextensionsv1beta1.JSONSchemaProps, error) {
+	templ := &v1beta1.ConstraintTemplate{}
+	err := r.Get(context.TODO(), types.NamespacedName{Name: strings.ToLower(r.gvk.Kind)}, templ)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if templ.Spec.CRD.Spec.Validation == nil {
+		return nil, nil
+	}
+	return templ.Spec.CRD.Spec.Validation.OpenAPIV3Schema, nil
+}
+
+// validateParameters returns an error listing the ways the parameters of instance do not
+// match schema. Constraints without parameters are validated as if their parameters were empty.
+func validateParameters(schema *apiextensionsv1beta1.JSONSchemaProps, instance *unstructured.Unstructured) error {
+	if schema == nil {
+		return nil
+	}
+	internal := &apiextensions.JSONSchemaProps{}
+	if err := apiextensionsv1beta1.Convert_v1beta1_JSONSchemaProps_To_apiextensions_JSONSchemaProps(schema, internal, nil); err != nil {
+		return err
+	}
+	validator, _, err := validation.NewSchemaValidator(&apiextensions.CustomResourceValidation{OpenAPIV3Schema: internal})
+	if err != nil {
+		return err
+	}
+	parameters, _, err := unstructured.NestedFieldNoCopy(instance.Object, "spec", "parameters")
+	if err != nil {
+		return err
+	}
+	if parameters == nil {
+		parameters = map[string]interface{}{}
+	}
+	result := validator.Validate(parameters)
+	if result.IsValid() {
+		return nil
+	}
+	var msgs []string
+	for _, e := range result.Errors {
+		// the validator names the validated document body
+		msgs = append(msgs, strings.Replace(e.Error(), " in body", "", 1))
+	}
+	return fmt.Errorf("invalid parameters: %s", strings.Join(msgs, "; "))
+}
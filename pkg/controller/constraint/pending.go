@@ -0,0 +1,137 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package constraint
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// KindsNotServedReason is the reason of the Pending condition of constraints none of whose
+	// kinds is served by the API server
+	KindsNotServedReason = "KindsNotServed"
+)
+
+var (
+	// pendingRecheckInterval is how often constraints pending on kinds that are not served are
+	// checked again
+	pendingRecheckInterval = 30 * time.Second
+	// mapperRefreshInterval bounds how often the kinds served by the API server are discovered
+	// again, when a constraint selects a kind that was not served the last time
+	mapperRefreshInterval = 10 * time.Second
+)
+
+// servedKinds tells which kinds the API server serves, so that constraints selecting only kinds
+// whose CRDs are not installed yet are reported as pending
+type servedKinds struct {
+	// newMapper discovers the kinds served now
+	newMapper func() (meta.RESTMapper, error)
+	mux       sync.Mutex
+	mapper    meta.RESTMapper
+	refreshed time.Time
+}
+
+// newServedKinds returns servedKinds that starts with mapper, and uses newMapper to discover the
+// kinds installed afterwards
+func newServedKinds(mapper meta.RESTMapper, newMapper func() (meta.RESTMapper, error)) *servedKinds {
+	return &servedKinds{newMapper: newMapper, mapper: mapper, refreshed: time.Now()}
+}
+
+// served returns whether gk is served. Unknown kinds are looked up again in a freshly discovered
+// mapper, at most every mapperRefreshInterval.
+func (s *servedKinds) served(gk schema.GroupKind) (bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if _, err := s.mapper.RESTMapping(gk); err == nil {
+		return true, nil
+	}
+	if time.Since(s.refreshed) < mapperRefreshInterval {
+		return false, nil
+	}
+	mapper, err := s.newMapper()
+	if err != nil {
+		return false, err
+	}
+	s.mapper = mapper
+	s.refreshed = time.Now()
+	_, err = s.mapper.RESTMapping(gk)
+	return err == nil, nil
+}
+
+// unserved returns the kinds selected by spec.match.kinds of instance, as Kind.group, if none of
+// them is served. It returns nil if a kind is served, or if instance selects every group or every
+// kind, as then it may match kinds installed later without any of them being named.
+func (s *servedKinds) unserved(instance *unstructured.Unstructured) ([]string, error) {
+	selectors, _, err := unstructured.NestedSlice(instance.Object, "spec", "match", "kinds")
+	if err != nil || len(selectors) == 0 {
+		return nil, err
+	}
+	var kinds []schema.GroupKind
+	for _, sel := range selectors {
+		m, ok := sel.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		groups, _, _ := unstructured.NestedStringSlice(m, "apiGroups")
+		names, _, _ := unstructured.NestedStringSlice(m, "kinds")
+		for _, group := range groups {
+			for _, kind := range names {
+				if group == "*" || kind == "*" {
+					return nil, nil
+				}
+				kinds = append(kinds, schema.GroupKind{Group: group, Kind: kind})
+			}
+		}
+	}
+	var missing []string
+	for _, gk := range kinds {
+		ok, err := s.served(gk)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return nil, nil
+		}
+		if gk.Group == "" {
+			missing = append(missing, gk.Kind)
+		} else {
+			missing = append(missing, gk.String())
+		}
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// setPending reports in the conditions of instance whether it is pending on its kinds, missing,
+// being served. A pending constraint is loaded, so that it applies as soon as one of them is
+// served, but it is not reported as enforced until then, as it cannot match anything.
+func setPending(instance *unstructured.Unstructured, missing []string, now time.Time) error {
+	if len(missing) == 0 {
+		return setCondition(instance, PendingCondition, false, "KindsServed", "", now)
+	}
+	message := "none of the kinds the constraint matches is served: " + strings.Join(missing, ", ")
+	if err := setCondition(instance, PendingCondition, true, KindsNotServedReason, message, now); err != nil {
+		return err
+	}
+	return setCondition(instance, EnforcedCondition, false, KindsNotServedReason, message, now)
+}
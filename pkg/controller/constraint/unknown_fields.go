@@ -0,0 +1,137 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package constraint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// specFields are the fields of the spec of constraints that Gatekeeper reads
+var specFields = map[string]bool{
+	"match":                true,
+	"parameters":           true,
+	"enforcementAction":    true,
+	"auditViolationsLimit": true,
+	"bypassable":           true,
+	"debug":                true,
+	"denyAfter":            true,
+	"violationThreshold":   true,
+}
+
+// selectorFields are the fields of the label, annotation and namespace selectors of match
+var selectorFields = map[string]bool{"matchLabels": true, "matchExpressions": true}
+
+// unknownFields returns the sorted paths of the fields of the spec of instance that Gatekeeper
+// does not read, such as misspelled ones. Parameters are checked against schema, the schema
+// of the template for them, where it lists the properties of an object.
+func unknownFields(instance *unstructured.Unstructured, schema *apiextensionsv1beta1.JSONSchemaProps) []string {
+	spec, _, err := unstructured.NestedMap(instance.Object, "spec")
+	if err != nil {
+		return nil
+	}
+	var unknown []string
+	for k := range spec {
+		if !specFields[k] {
+			unknown = append(unknown, "spec."+k)
+		}
+	}
+	matchFields := (&target.K8sValidationTarget{}).MatchSchema().Properties
+	match, _ := spec["match"].(map[string]interface{})
+	for k, v := range match {
+		matchSchema, ok := matchFields[k]
+		if !ok {
+			unknown = append(unknown, "spec.match."+k)
+			continue
+		}
+		switch {
+		case k == "kinds" || k == "ownerKinds":
+			selectors, _ := v.([]interface{})
+			for i, s := range selectors {
+				selector, _ := s.(map[string]interface{})
+				for f := range selector {
+					if _, ok := matchSchema.Items.Schema.Properties[f]; !ok {
+						unknown = append(unknown, fmt.Sprintf("spec.match.%s[%d].%s", k, i, f))
+					}
+				}
+			}
+		case strings.HasSuffix(k, "Selector"):
+			selector, _ := v.(map[string]interface{})
+			for f := range selector {
+				if !selectorFields[f] {
+					unknown = append(unknown, fmt.Sprintf("spec.match.%s.%s", k, f))
+				}
+			}
+		}
+	}
+	unknown = append(unknown, unknownParameters("spec.parameters", spec["parameters"], schema)...)
+	sort.Strings(unknown)
+	return unknown
+}
+
+// unknownParameters returns the paths of the fields of value that schema does not list among
+// the properties of their object. Objects whose schema lists no properties, or allows
+// additional ones, may hold any field.
+func unknownParameters(path string, value interface{}, schema *apiextensionsv1beta1.JSONSchemaProps) []string {
+	if schema == nil {
+		return nil
+	}
+	var unknown []string
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if schema.AdditionalProperties != nil {
+			if schema.AdditionalProperties.Schema != nil {
+				for k, child := range v {
+					unknown = append(unknown, unknownParameters(path+"."+k, child, schema.AdditionalProperties.Schema)...)
+				}
+			}
+			return unknown
+		}
+		if len(schema.Properties) == 0 {
+			return nil
+		}
+		for k, child := range v {
+			prop, ok := schema.Properties[k]
+			if !ok {
+				unknown = append(unknown, path+"."+k)
+				continue
+			}
+			unknown = append(unknown, unknownParameters(path+"."+k, child, &prop)...)
+		}
+	case []interface{}:
+		if schema.Items == nil || schema.Items.Schema == nil {
+			return nil
+		}
+		for i, child := range v {
+			unknown = append(unknown, unknownParameters(fmt.Sprintf("%s[%d]", path, i), child, schema.Items.Schema)...)
+		}
+	}
+	return unknown
+}
+
+// setUnknownFields reports the unknown fields of instance in its UnknownFields condition
+func setUnknownFields(instance *unstructured.Unstructured, unknown []string, now time.Time) error {
+	if len(unknown) == 0 {
+		return setCondition(instance, UnknownFieldsCondition, false, "NoUnknownFields", "", now)
+	}
+	return setCondition(instance, UnknownFieldsCondition, true, "UnknownFields", "ignoring unknown fields "+strings.Join(unknown, ", "), now)
+}
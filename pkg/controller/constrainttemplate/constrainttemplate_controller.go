@@ -19,16 +19,19 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"sync"
 	"time"
 
 	"github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
 	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
 	"github.com/open-policy-agent/frameworks/constraint/pkg/core/templates"
 	"github.com/open-policy-agent/gatekeeper/pkg/controller/constraint"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
 	"github.com/open-policy-agent/gatekeeper/pkg/util"
 	"github.com/open-policy-agent/gatekeeper/pkg/watch"
 	"github.com/open-policy-agent/opa/ast"
 	errorpkg "github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
 	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -48,21 +51,36 @@ import (
 )
 
 const (
-	finalizerName = "constrainttemplate.finalizers.gatekeeper.sh"
-	ctrlName      = "constrainttemplate-controller"
+	baseFinalizerName = "constrainttemplate.finalizers.gatekeeper.sh"
+	ctrlName          = "constrainttemplate-controller"
 )
 
+// EvaluationDisabledCode is the code of the status error reported while a template is
+// excluded from admission review after repeated evaluation errors. The webhook sets and
+// clears it, so it is kept when the controller resets the template's errors.
+const EvaluationDisabledCode = "evaluation_disabled"
+
+func init() {
+	util.RegisterFinalizerName(baseFinalizerName)
+}
+
+// finalizerName returns the finalizer managed by this instance
+func finalizerName() string {
+	return util.FinalizerName(baseFinalizerName)
+}
+
 var log = logf.Log.WithName("controller").WithValues("kind", "ConstraintTemplate")
 
 type Adder struct {
 	Opa          *opa.Client
 	WatchManager *watch.WatchManager
+	Index        *target.ConstraintIndex
 }
 
 // Add creates a new ConstraintTemplate Controller and adds it to the Manager with default RBAC. The Manager will set fields on the Controller
 // and Start it when the Manager is Started.
 func (a *Adder) Add(mgr manager.Manager) error {
-	r, err := newReconciler(mgr, a.Opa, a.WatchManager)
+	r, err := newReconciler(mgr, a.Opa, a.WatchManager, a.Index)
 	if err != nil {
 		return err
 	}
@@ -77,9 +95,13 @@ func (a *Adder) InjectWatchManager(wm *watch.WatchManager) {
 	a.WatchManager = wm
 }
 
+func (a *Adder) InjectConstraintIndex(idx *target.ConstraintIndex) {
+	a.Index = idx
+}
+
 // newReconciler returns a new reconcile.Reconciler
-func newReconciler(mgr manager.Manager, opa *opa.Client, wm *watch.WatchManager) (reconcile.Reconciler, error) {
-	constraintAdder := constraint.Adder{Opa: opa}
+func newReconciler(mgr manager.Manager, opa *opa.Client, wm *watch.WatchManager, index *target.ConstraintIndex) (reconcile.Reconciler, error) {
+	constraintAdder := constraint.Adder{Opa: opa, Index: index}
 	w, err := wm.NewRegistrar(
 		ctrlName,
 		[]func(manager.Manager, schema.GroupVersionKind) error{constraintAdder.Add})
@@ -91,13 +113,14 @@ func newReconciler(mgr manager.Manager, opa *opa.Client, wm *watch.WatchManager)
 		scheme:  mgr.GetScheme(),
 		opa:     opa,
 		watcher: w,
+		loaded:  newLoadedTemplates(),
 	}, nil
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
 func add(mgr manager.Manager, r reconcile.Reconciler) error {
 	// Create a new controller
-	c, err := controller.New(ctrlName, mgr, controller.Options{Reconciler: r})
+	c, err := controller.New(ctrlName, mgr, util.ControllerOptions(r))
 	if err != nil {
 		return err
 	}
@@ -108,6 +131,12 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Watch for changes to shared libs so the templates that import them are reloaded
+	err = c.Watch(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: templatesForLib(mgr.GetClient())})
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -119,6 +148,34 @@ type ReconcileConstraintTemplate struct {
 	scheme  *runtime.Scheme
 	watcher *watch.Registrar
 	opa     *opa.Client
+	// loaded holds the templates loaded into OPA, so that they can be removed from OPA once they
+	// are deleted in read-only mode, where no finalizer holds their deletion
+	loaded *loadedTemplates
+}
+
+// loadedTemplates holds the last version of each template loaded into OPA, by template name
+type loadedTemplates struct {
+	mux       sync.Mutex
+	templates map[string]*templates.ConstraintTemplate
+}
+
+func newLoadedTemplates() *loadedTemplates {
+	return &loadedTemplates{templates: make(map[string]*templates.ConstraintTemplate)}
+}
+
+func (l *loadedTemplates) add(name string, templ *templates.ConstraintTemplate) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.templates[name] = templ
+}
+
+// remove returns the template loaded with name and forgets it, or nil if none is
+func (l *loadedTemplates) remove(name string) *templates.ConstraintTemplate {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	templ := l.templates[name]
+	delete(l.templates, name)
+	return templ
 }
 
 // Reconcile reads that state of the cluster for a ConstraintTemplate object and makes changes based on the state read
@@ -133,6 +190,11 @@ func (r *ReconcileConstraintTemplate) Reconcile(request reconcile.Request) (reco
 	err := r.Get(context.TODO(), request.NamespacedName, instance)
 	if err != nil {
 		if errors.IsNotFound(err) {
+			// no finalizer holds the deletion of templates in read-only mode, so they are
+			// removed from OPA once they are gone
+			if util.ReadOnly() {
+				return reconcile.Result{}, r.removeDeleted(request.Name)
+			}
 			// Object not found, return.  Created objects are automatically garbage collected.
 			// For additional cleanup logic use finalizers.
 			return reconcile.Result{}, nil
@@ -142,16 +204,37 @@ func (r *ReconcileConstraintTemplate) Reconcile(request reconcile.Request) (reco
 	}
 
 	status := util.GetCTHAStatus(instance)
-	status.Errors = nil
+	var evaluationErrs []*v1beta1.CreateCRDError
+	for _, e := range status.Errors {
+		if e.Code == EvaluationDisabledCode {
+			evaluationErrs = append(evaluationErrs, e)
+		}
+	}
+	status.Errors = evaluationErrs
 	versionless := &templates.ConstraintTemplate{}
 	if err := r.scheme.Convert(instance, versionless, nil); err != nil {
 		log.Error(err, "conversion error")
 		return reconcile.Result{}, err
 	}
-	crd, err := r.opa.CreateCRD(context.Background(), versionless)
+	if warning := unsupportedTargetsError(RemoveUnsupportedTargets(versionless)); warning != nil {
+		status.Errors = append(status.Errors, warning)
+	}
+	if util.StrictSpecValidation() {
+		warning, err := r.unknownFieldsError(request.NamespacedName)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if warning != nil {
+			status.Errors = append(status.Errors, warning)
+		}
+	}
+	libs, crd, err := r.createCRD(versionless)
 	if err != nil {
 		var createErr *v1beta1.CreateCRDError
-		if parseErrs, ok := err.(ast.Errors); ok {
+		if libErr, ok := err.(*SharedLibError); ok {
+			createErr = &v1beta1.CreateCRDError{Code: "lib_error", Message: libErr.Error()}
+			status.Errors = append(status.Errors, createErr)
+		} else if parseErrs, ok := err.(ast.Errors); ok {
 			for i := 0; i < len(parseErrs); i++ {
 				createErr = &v1beta1.CreateCRDError{Code: parseErrs[i].Code, Message: parseErrs[i].Message, Location: parseErrs[i].Location.String()}
 				status.Errors = append(status.Errors, createErr)
@@ -177,7 +260,7 @@ func (r *ReconcileConstraintTemplate) Reconcile(request reconcile.Request) (reco
 		found := &apiextensionsv1beta1.CustomResourceDefinition{}
 		err = r.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, found)
 		if err != nil && errors.IsNotFound(err) {
-			return r.handleCreate(instance, crd)
+			return r.handleCreate(instance, versionless, libs, crd)
 
 		} else if err != nil {
 			return reconcile.Result{}, err
@@ -188,33 +271,59 @@ func (r *ReconcileConstraintTemplate) Reconcile(request reconcile.Request) (reco
 				log.Error(err, "conversion error")
 				return reconcile.Result{}, err
 			}
-			return r.handleUpdate(instance, crd, unversionedCRD)
+			return r.handleUpdate(instance, versionless, libs, crd, unversionedCRD)
 		}
 
 	}
+	// the CRDs of the template are left to the instance writing to the API server
+	if util.ReadOnly() {
+		return reconcile.Result{}, r.removeDeleted(instance.GetName())
+	}
 	return r.handleDelete(instance, crd)
 }
 
+// removeDeleted removes the deleted template with name from OPA, if it was loaded
+func (r *ReconcileConstraintTemplate) removeDeleted(name string) error {
+	templ := r.loaded.remove(name)
+	if templ == nil {
+		return nil
+	}
+	log.Info("removing deleted template", "name", name)
+	if _, err := r.opa.RemoveTemplate(context.Background(), templ); err != nil {
+		r.loaded.add(name, templ)
+		return err
+	}
+	return nil
+}
+
+// createCRD resolves the template's shared libs and creates the CRD for its constraints
+func (r *ReconcileConstraintTemplate) createCRD(versionless *templates.ConstraintTemplate) (LibSources, *apiextensions.CustomResourceDefinition, error) {
+	libs, err := AddSharedLibs(context.Background(), r, versionless)
+	if err != nil {
+		return nil, nil, err
+	}
+	crd, err := r.opa.CreateCRD(context.Background(), versionless)
+	return libs, crd, err
+}
+
 func (r *ReconcileConstraintTemplate) handleCreate(
 	instance *v1beta1.ConstraintTemplate,
+	versionless *templates.ConstraintTemplate,
+	libs LibSources,
 	crd *apiextensions.CustomResourceDefinition) (reconcile.Result, error) {
 	name := crd.GetName()
 	log := log.WithValues("name", name)
 	log.Info("creating constraint")
-	if !containsString(finalizerName, instance.GetFinalizers()) {
-		instance.SetFinalizers(append(instance.GetFinalizers(), finalizerName))
+	if !containsString(finalizerName(), instance.GetFinalizers()) {
+		instance.SetFinalizers(append(instance.GetFinalizers(), finalizerName()))
 		if err := r.Update(context.Background(), instance); err != nil {
 			log.Error(err, "update error")
 			return reconcile.Result{Requeue: true}, nil
 		}
 	}
 	log.Info("loading code into OPA")
-	versionless := &templates.ConstraintTemplate{}
-	if err := r.scheme.Convert(instance, versionless, nil); err != nil {
-		log.Error(err, "conversion error")
-		return reconcile.Result{}, err
-	}
 	if _, err := r.opa.AddTemplate(context.Background(), versionless); err != nil {
+		err = libs.Explain(err)
 		updateErr := &v1beta1.CreateCRDError{Code: "update_error", Message: fmt.Sprintf("Could not update CRD: %s", err)}
 		status := util.GetCTHAStatus(instance)
 		status.Errors = append(status.Errors, updateErr)
@@ -224,6 +333,7 @@ func (r *ReconcileConstraintTemplate) handleCreate(
 		}
 		return reconcile.Result{}, err
 	}
+	r.loaded.add(instance.GetName(), versionless)
 	log.Info("adding to watcher registry")
 	if err := r.watcher.AddWatch(makeGvk(instance.Spec.CRD.Spec.Names.Kind)); err != nil {
 		return reconcile.Result{}, err
@@ -235,7 +345,11 @@ func (r *ReconcileConstraintTemplate) handleCreate(
 		log.Error(err, "conversion error")
 		return reconcile.Result{}, err
 	}
-	if err := r.Create(context.TODO(), crdv1beta1); err != nil {
+	err := r.Create(context.TODO(), crdv1beta1)
+	if err == nil {
+		err = r.ensureNamespacedCRD(instance, crd)
+	}
+	if err != nil {
 		status := util.GetCTHAStatus(instance)
 		status.Errors = []*v1beta1.CreateCRDError{}
 		createErr := &v1beta1.CreateCRDError{Code: "create_error", Message: fmt.Sprintf("Could not create CRD: %s", err)}
@@ -255,6 +369,8 @@ func (r *ReconcileConstraintTemplate) handleCreate(
 
 func (r *ReconcileConstraintTemplate) handleUpdate(
 	instance *v1beta1.ConstraintTemplate,
+	versionless *templates.ConstraintTemplate,
+	libs LibSources,
 	crd, found *apiextensions.CustomResourceDefinition) (reconcile.Result, error) {
 	// TODO: We may want to only check in code if it has changed. This is harder to do than it sounds
 	// because even if the hash hasn't changed, OPA may have been restarted and needs code re-loaded
@@ -262,20 +378,16 @@ func (r *ReconcileConstraintTemplate) handleUpdate(
 	// this may be too expensive to do in large clusters
 	name := crd.GetName()
 	log := log.WithValues("name", instance.GetName(), "crdName", name)
-	if !containsString(finalizerName, instance.GetFinalizers()) {
-		instance.SetFinalizers(append(instance.GetFinalizers(), finalizerName))
+	if !containsString(finalizerName(), instance.GetFinalizers()) {
+		instance.SetFinalizers(append(instance.GetFinalizers(), finalizerName()))
 		if err := r.Update(context.Background(), instance); err != nil {
 			log.Error(err, "update error")
 			return reconcile.Result{Requeue: true}, nil
 		}
 	}
 	log.Info("loading constraint code into OPA")
-	versionless := &templates.ConstraintTemplate{}
-	if err := r.scheme.Convert(instance, versionless, nil); err != nil {
-		log.Error(err, "conversion error")
-		return reconcile.Result{}, err
-	}
 	if _, err := r.opa.AddTemplate(context.Background(), versionless); err != nil {
+		err = libs.Explain(err)
 		updateErr := &v1beta1.CreateCRDError{Code: "update_error", Message: fmt.Sprintf("Could not update CRD: %s", err)}
 		status := util.GetCTHAStatus(instance)
 		status.Errors = append(status.Errors, updateErr)
@@ -285,6 +397,7 @@ func (r *ReconcileConstraintTemplate) handleUpdate(
 		}
 		return reconcile.Result{}, err
 	}
+	r.loaded.add(instance.GetName(), versionless)
 	log.Info("making sure constraint is in watcher registry")
 	if err := r.watcher.AddWatch(makeGvk(instance.Spec.CRD.Spec.Names.Kind)); err != nil {
 		log.Error(err, "error adding template to watch registry")
@@ -302,6 +415,9 @@ func (r *ReconcileConstraintTemplate) handleUpdate(
 			return reconcile.Result{}, err
 		}
 	}
+	if err := r.ensureNamespacedCRD(instance, crd); err != nil {
+		return reconcile.Result{}, err
+	}
 	if err := r.Update(context.Background(), instance); err != nil {
 		log.Error(err, "update error")
 		return reconcile.Result{Requeue: true}, nil
@@ -309,13 +425,65 @@ func (r *ReconcileConstraintTemplate) handleUpdate(
 	return reconcile.Result{}, nil
 }
 
+// ensureNamespacedCRD creates or updates the CRD for the namespaced variant of the
+// template's constraints, and watches them, if namespaced constraints are enabled
+func (r *ReconcileConstraintTemplate) ensureNamespacedCRD(
+	instance *v1beta1.ConstraintTemplate,
+	crd *apiextensions.CustomResourceDefinition) error {
+	if !constraint.NamespacedConstraintsEnabled() {
+		return nil
+	}
+	nsCRD := namespacedCRD(crd)
+	found := &apiextensionsv1beta1.CustomResourceDefinition{}
+	err := r.Get(context.TODO(), types.NamespacedName{Name: nsCRD.GetName()}, found)
+	switch {
+	case err != nil && errors.IsNotFound(err):
+		log.Info("creating namespaced constraint CRD", "crdName", nsCRD.GetName())
+		crdv1beta1 := &apiextensionsv1beta1.CustomResourceDefinition{}
+		if err := r.scheme.Convert(nsCRD, crdv1beta1, nil); err != nil {
+			return err
+		}
+		if err := r.Create(context.TODO(), crdv1beta1); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		unversionedCRD := &apiextensions.CustomResourceDefinition{}
+		if err := r.scheme.Convert(found, unversionedCRD, nil); err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(nsCRD.Spec, unversionedCRD.Spec) {
+			log.Info("difference in namespaced constraint CRD spec found, updating", "crdName", nsCRD.GetName())
+			unversionedCRD.Spec = nsCRD.Spec
+			crdv1beta1 := &apiextensionsv1beta1.CustomResourceDefinition{}
+			if err := r.scheme.Convert(unversionedCRD, crdv1beta1, nil); err != nil {
+				return err
+			}
+			if err := r.Update(context.Background(), crdv1beta1); err != nil {
+				return err
+			}
+		}
+	}
+	return r.watcher.AddWatch(makeNamespacedGvk(instance.Spec.CRD.Spec.Names.Kind))
+}
+
+// namespacedCRD returns the CRD for the namespaced variant of the constraints defined by crd
+func namespacedCRD(crd *apiextensions.CustomResourceDefinition) *apiextensions.CustomResourceDefinition {
+	nsCRD := crd.DeepCopy()
+	nsCRD.Spec.Group = constraint.NamespacedGroup
+	nsCRD.Spec.Scope = apiextensions.NamespaceScoped
+	nsCRD.SetName(fmt.Sprintf("%s.%s", nsCRD.Spec.Names.Plural, constraint.NamespacedGroup))
+	return nsCRD
+}
+
 func (r *ReconcileConstraintTemplate) handleDelete(
 	instance *v1beta1.ConstraintTemplate,
 	crd *apiextensions.CustomResourceDefinition) (reconcile.Result, error) {
 	name := crd.GetName()
 	namespace := crd.GetNamespace()
 	log := log.WithValues("name", instance.GetName(), "crdName", name)
-	if containsString(finalizerName, instance.GetFinalizers()) {
+	if containsString(finalizerName(), instance.GetFinalizers()) {
 		crdv1beta1 := &apiextensionsv1beta1.CustomResourceDefinition{}
 		if err := r.scheme.Convert(crd, crdv1beta1, nil); err != nil {
 			log.Error(err, "conversion error")
@@ -324,6 +492,28 @@ func (r *ReconcileConstraintTemplate) handleDelete(
 		if err := r.Delete(context.Background(), crdv1beta1); err != nil && !errors.IsNotFound(err) {
 			return reconcile.Result{}, err
 		}
+		// The namespaced CRD is removed even if namespaced constraints have since been disabled
+		nsCRDv1beta1 := &apiextensionsv1beta1.CustomResourceDefinition{}
+		if err := r.scheme.Convert(namespacedCRD(crd), nsCRDv1beta1, nil); err != nil {
+			log.Error(err, "conversion error")
+			return reconcile.Result{}, err
+		}
+		if err := r.Delete(context.Background(), nsCRDv1beta1); err != nil && !errors.IsNotFound(err) {
+			return reconcile.Result{}, err
+		}
+		nsFound := &apiextensionsv1beta1.CustomResourceDefinition{}
+		if err := r.Get(context.Background(), types.NamespacedName{Name: nsCRDv1beta1.GetName()}, nsFound); err == nil {
+			log.Info("child namespaced constraint CRD has not yet been deleted, waiting")
+			if err := r.watcher.AddWatch(makeNamespacedGvk(instance.Spec.CRD.Spec.Names.Kind)); err != nil {
+				return reconcile.Result{}, err
+			}
+			return reconcile.Result{Requeue: true}, nil
+		} else if err != nil && !errors.IsNotFound(err) {
+			return reconcile.Result{}, err
+		}
+		if err := r.watcher.RemoveWatch(makeNamespacedGvk(instance.Spec.CRD.Spec.Names.Kind)); err != nil {
+			return reconcile.Result{}, err
+		}
 		found := &apiextensionsv1beta1.CustomResourceDefinition{}
 		if err := r.Get(context.Background(), types.NamespacedName{Name: name, Namespace: namespace}, found); err == nil {
 			log.Info("child constraint CRD has not yet been deleted, waiting")
@@ -345,9 +535,11 @@ func (r *ReconcileConstraintTemplate) handleDelete(
 			log.Error(err, "conversion error")
 			return reconcile.Result{}, err
 		}
+		RemoveUnsupportedTargets(versionless)
 		if _, err := r.opa.RemoveTemplate(context.Background(), versionless); err != nil {
 			return reconcile.Result{}, err
 		}
+		r.loaded.remove(instance.GetName())
 		RemoveFinalizer(instance)
 
 		if err := r.Update(context.Background(), instance); err != nil {
@@ -358,17 +550,23 @@ func (r *ReconcileConstraintTemplate) handleDelete(
 }
 
 func RemoveFinalizer(instance *v1beta1.ConstraintTemplate) {
-	instance.SetFinalizers(removeString(finalizerName, instance.GetFinalizers()))
+	instance.SetFinalizers(removeString(finalizerName(), instance.GetFinalizers()))
 }
 
 func makeGvk(kind string) schema.GroupVersionKind {
 	return schema.GroupVersionKind{
-		Group:   "constraints.gatekeeper.sh",
+		Group:   constraint.Group,
 		Version: "v1beta1",
 		Kind:    kind,
 	}
 }
 
+func makeNamespacedGvk(kind string) schema.GroupVersionKind {
+	gvk := makeGvk(kind)
+	gvk.Group = constraint.NamespacedGroup
+	return gvk
+}
+
 func containsString(s string, items []string) bool {
 	for _, item := range items {
 		if item == s {
@@ -414,27 +612,29 @@ func RemoveAllFinalizers(c client.Client, finished chan struct{}) {
 		log.Info("removing finalizers from constraint templates", "templates", toList(names))
 		for nn, kind := range names {
 			listKind := kind + "List"
-			// TODO these should be constants somewhere
-			gvk := schema.GroupVersionKind{Group: "constraints.gatekeeper.sh", Version: "v1beta1", Kind: listKind}
-			objs := &unstructured.UnstructuredList{}
-			objs.SetGroupVersionKind(gvk)
-			if err := c.List(context.Background(), nil, objs); err != nil {
-				// If the kind is not recognized, there is nothing to clean
-				if !meta.IsNoMatchError(err) {
-					log.Error(err, "while listing constraints for cleanup", "kind", listKind)
-					continue
-				}
-			}
 			success := true
-			for _, obj := range objs.Items {
-				if !constraint.HasFinalizer(&obj) {
-					continue
+			for _, group := range constraint.Groups() {
+				gvk := schema.GroupVersionKind{Group: group, Version: "v1beta1", Kind: listKind}
+				objs := &unstructured.UnstructuredList{}
+				objs.SetGroupVersionKind(gvk)
+				if err := c.List(context.Background(), nil, objs); err != nil {
+					// If the kind is not recognized, there is nothing to clean
+					if !meta.IsNoMatchError(err) {
+						log.Error(err, "while listing constraints for cleanup", "kind", listKind, "group", group)
+						success = false
+						continue
+					}
 				}
-				log.Info("scrubing constraint finalizer", "name", obj.GetName())
-				constraint.RemoveFinalizer(&obj)
-				if err := c.Update(context.Background(), &obj); err != nil {
-					success = false
-					log.Error(err, "could not scrub constraint finalizer", "name", obj.GetName())
+				for _, obj := range objs.Items {
+					if !constraint.HasFinalizer(&obj) {
+						continue
+					}
+					log.Info("scrubing constraint finalizer", "name", obj.GetName(), "namespace", obj.GetNamespace())
+					constraint.RemoveFinalizer(&obj)
+					if err := c.Update(context.Background(), &obj); err != nil {
+						success = false
+						log.Error(err, "could not scrub constraint finalizer", "name", obj.GetName(), "namespace", obj.GetNamespace())
+					}
 				}
 			}
 			if success == true {
@@ -18,6 +18,7 @@ package constrainttemplate
 import (
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"testing"
 	"time"
@@ -26,6 +27,7 @@ import (
 	"github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
 	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
 	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers/local"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/core/templates"
 	"github.com/open-policy-agent/gatekeeper/pkg/controller/constraint"
 	"github.com/open-policy-agent/gatekeeper/pkg/target"
 	"github.com/open-policy-agent/gatekeeper/pkg/util"
@@ -34,6 +36,7 @@ import (
 	admissionv1beta1 "k8s.io/api/admission/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -100,7 +103,7 @@ violation[{"msg": "denied!"}] {
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	rec, _ := newReconciler(mgr, opa, watch.New(ctx, mgr.GetConfig()))
+	rec, _ := newReconciler(mgr, opa, watch.New(ctx, mgr.GetConfig()), nil)
 	recFn, requests := SetupTestReconcile(rec)
 	g.Expect(add(mgr, recFn)).NotTo(gomega.HaveOccurred())
 
@@ -255,7 +258,7 @@ anyrule[}}}//invalid//rego
 	// Test finalizer removal
 	orig := &v1beta1.ConstraintTemplate{}
 	g.Expect(c.Get(context.TODO(), types.NamespacedName{Name: "denyall"}, orig)).NotTo(gomega.HaveOccurred())
-	g.Expect(containsString(finalizerName, orig.GetFinalizers())).Should(gomega.BeTrue())
+	g.Expect(containsString(finalizerName(), orig.GetFinalizers())).Should(gomega.BeTrue())
 
 	origCstr, err := cstrClient.Get("denyall", metav1.GetOptions{TypeMeta: metav1.TypeMeta{Kind: "DenyAll", APIVersion: "constraints.gatekeeper.sh/v1beta1"}})
 	g.Expect(err).NotTo(gomega.HaveOccurred())
@@ -274,7 +277,7 @@ anyrule[}}}//invalid//rego
 		if err := c.Get(context.TODO(), types.NamespacedName{Name: "denyall"}, obj); err != nil {
 			return err
 		}
-		if containsString(finalizerName, obj.GetFinalizers()) {
+		if containsString(finalizerName(), obj.GetFinalizers()) {
 			return errors.New("denyall constraint template still has finalizer")
 		}
 		return nil
@@ -291,3 +294,77 @@ anyrule[}}}//invalid//rego
 		return nil
 	}, timeout).Should(gomega.BeNil())
 }
+
+// goneClient serves no object, as if every object had been deleted
+type goneClient struct {
+	client.Client
+}
+
+func (c *goneClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	return apierrors.NewNotFound(schema.GroupResource{Resource: "constrainttemplates"}, key.Name)
+}
+
+func TestReconcileReadOnlyDeletion(t *testing.T) {
+	defer flag.Set("read-only", "false")
+	flag.Set("read-only", "true")
+	driver := local.New(local.Tracing(false))
+	backend, err := opa.NewBackend(opa.Driver(driver))
+	if err != nil {
+		t.Fatalf("unable to set up OPA backend: %s", err)
+	}
+	opa, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+	if err != nil {
+		t.Fatalf("unable to set up OPA client: %s", err)
+	}
+	templ := &templates.ConstraintTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "denyall"},
+		Spec: templates.ConstraintTemplateSpec{
+			CRD: templates.CRD{Spec: templates.CRDSpec{Names: templates.Names{Kind: "DenyAll"}}},
+			Targets: []templates.Target{{
+				Target: "admission.k8s.gatekeeper.sh",
+				Rego: `
+package foo
+
+violation[{"msg": "denied!"}] {
+	1 == 1
+}
+`}},
+		},
+	}
+	if _, err := opa.AddTemplate(context.Background(), templ); err != nil {
+		t.Fatalf("AddTemplate() err = %s", err)
+	}
+	cstr := &unstructured.Unstructured{}
+	cstr.SetGroupVersionKind(makeGvk("DenyAll"))
+	cstr.SetName("denyall")
+	if _, err := opa.AddConstraint(context.Background(), cstr); err != nil {
+		t.Fatalf("AddConstraint() err = %s", err)
+	}
+	r := &ReconcileConstraintTemplate{Client: &goneClient{}, opa: opa, loaded: newLoadedTemplates()}
+	r.loaded.add("denyall", templ)
+	reviewed := func() int {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "testns"}}
+		req := admissionv1beta1.AdmissionRequest{
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Namespace"},
+			Operation: "Create",
+			Name:      "testns",
+			Object:    runtime.RawExtension{Object: ns},
+		}
+		resp, err := opa.Review(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Review() err = %s", err)
+		}
+		return len(resp.Results())
+	}
+	if got := reviewed(); got != 1 {
+		t.Fatalf("%d violations before the template is deleted; want 1", got)
+	}
+
+	// no finalizer is written, so the template is gone by the time it is reconciled
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "denyall"}}); err != nil {
+		t.Fatalf("Reconcile() err = %s", err)
+	}
+	if got := reviewed(); got != 0 {
+		t.Errorf("%d violations once the template is deleted in read-only mode; want 0", got)
+	}
+}
@@ -0,0 +1,155 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package constrainttemplate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/core/templates"
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	"github.com/open-policy-agent/opa/ast"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// LibsAnnotation lists the shared libs, by comma-separated ConfigMap name, that a template
+// imports. Each ConfigMap lives in the Gatekeeper namespace and every key in its data is a
+// Rego module whose package is under data.lib.
+const LibsAnnotation = "templates.gatekeeper.sh/libs"
+
+// SharedLibError is returned when a shared lib cannot be loaded
+type SharedLibError struct {
+	Lib string
+	Err error
+}
+
+func (e *SharedLibError) Error() string {
+	return fmt.Sprintf("shared lib %s: %s", e.Lib, e.Err)
+}
+
+// SharedLibNames returns the names of the shared libs referenced by obj, in order and
+// without duplicates
+func SharedLibNames(obj metav1.Object) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(obj.GetAnnotations()[LibsAnnotation], ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// LibSources names each lib of a template by its position in the template's libs
+type LibSources []string
+
+// Explain adds the names of the libs referenced by a compile error to its message. The
+// constraint framework names modules by their position after the entry point, which
+// is module 0.
+func (l LibSources) Explain(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	var notes []string
+	for i, name := range l {
+		if strings.Contains(msg, fmt.Sprintf("_idx_%d:", i+1)) {
+			notes = append(notes, fmt.Sprintf("_idx_%d is %s", i+1, name))
+		}
+	}
+	if len(notes) == 0 {
+		return err
+	}
+	return fmt.Errorf("%s\n(%s)", msg, strings.Join(notes, ", "))
+}
+
+// AddSharedLibs appends the modules of the shared libs referenced by templ to the libs of
+// each of its targets, after any libs the template defines itself. Modules are added in
+// the order the libs are listed and, within a lib, in order of their key, so the resulting
+// module names are stable across reconciles. The returned LibSources describe the libs
+// of the template's first target.
+func AddSharedLibs(ctx context.Context, c client.Reader, templ *templates.ConstraintTemplate) (LibSources, error) {
+	var sources LibSources
+	if len(templ.Spec.Targets) != 0 {
+		for i := range templ.Spec.Targets[0].Libs {
+			sources = append(sources, fmt.Sprintf("spec.targets[0].libs[%d]", i))
+		}
+	}
+	names := SharedLibNames(templ)
+	if len(names) == 0 {
+		return sources, nil
+	}
+	var libs []string
+	for _, name := range names {
+		cm := &corev1.ConfigMap{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: util.GetNamespace(), Name: name}, cm); err != nil {
+			return nil, &SharedLibError{Lib: name, Err: err}
+		}
+		var keys []string
+		for k := range cm.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			// Parse each module here so a syntax error names the lib it came from
+			lib := fmt.Sprintf("%s/%s", name, k)
+			if _, err := ast.ParseModule(lib, cm.Data[k]); err != nil {
+				return nil, &SharedLibError{Lib: lib, Err: err}
+			}
+			libs = append(libs, cm.Data[k])
+			sources = append(sources, "shared lib "+lib)
+		}
+	}
+	for i := range templ.Spec.Targets {
+		templ.Spec.Targets[i].Libs = append(templ.Spec.Targets[i].Libs, libs...)
+	}
+	return sources, nil
+}
+
+// templatesForLib maps a ConfigMap to the templates that import it as a shared lib
+func templatesForLib(c client.Client) handler.ToRequestsFunc {
+	return func(obj handler.MapObject) []reconcile.Request {
+		if obj.Meta.GetNamespace() != util.GetNamespace() {
+			return nil
+		}
+		templs := &v1beta1.ConstraintTemplateList{}
+		if err := c.List(context.Background(), nil, templs); err != nil {
+			log.Error(err, "could not list templates importing shared lib", "lib", obj.Meta.GetName())
+			return nil
+		}
+		var requests []reconcile.Request
+		for _, templ := range templs.Items {
+			for _, name := range SharedLibNames(&templ) {
+				if name == obj.Meta.GetName() {
+					requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: templ.GetName()}})
+					break
+				}
+			}
+		}
+		return requests
+	}
+}
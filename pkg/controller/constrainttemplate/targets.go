@@ -0,0 +1,64 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package constrainttemplate
+
+import (
+	"fmt"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/core/templates"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+)
+
+// UnsupportedTargetCode is the code of the status error listing the targets of a template
+// that Gatekeeper does not enforce. It is a warning: the template is loaded with the targets
+// that are supported.
+const UnsupportedTargetCode = "unsupported_target"
+
+// RemoveUnsupportedTargets removes the targets other than the Kubernetes admission target from
+// templ, or, for templates without it, the targets other than the generic target, and returns
+// the names of the removed targets. Templates with neither target are left as they are, so
+// that loading them fails.
+func RemoveUnsupportedTargets(templ *templates.ConstraintTemplate) []string {
+	for _, supported := range []string{(&target.K8sValidationTarget{}).GetName(), (&target.GenericTarget{}).GetName()} {
+		var kept []templates.Target
+		var removed []string
+		for _, t := range templ.Spec.Targets {
+			if t.Target == supported {
+				kept = append(kept, t)
+			} else {
+				removed = append(removed, t.Target)
+			}
+		}
+		if len(kept) != 0 {
+			templ.Spec.Targets = kept
+			return removed
+		}
+	}
+	return nil
+}
+
+// unsupportedTargetsError returns the status error warning of the removed targets of a
+// template, or nil if none were removed
+func unsupportedTargetsError(removed []string) *v1beta1.CreateCRDError {
+	if len(removed) == 0 {
+		return nil
+	}
+	return &v1beta1.CreateCRDError{
+		Code:    UnsupportedTargetCode,
+		Message: fmt.Sprintf("ignoring unsupported targets %v, only one of %s or %s is enforced", removed, (&target.K8sValidationTarget{}).GetName(), (&target.GenericTarget{}).GetName()),
+	}
+}
@@ -0,0 +1,125 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package constrainttemplate
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers/local"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/core/templates"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestRemoveUnsupportedTargets(t *testing.T) {
+	backend, err := opa.NewBackend(opa.Driver(local.New(local.Tracing(false))))
+	if err != nil {
+		t.Fatalf("unable to set up OPA backend: %s", err)
+	}
+	c, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+	if err != nil {
+		t.Fatalf("unable to set up OPA client: %s", err)
+	}
+	templ := &templates.ConstraintTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "k8sdenyall"},
+		Spec: templates.ConstraintTemplateSpec{
+			CRD: templates.CRD{Spec: templates.CRDSpec{Names: templates.Names{Kind: "K8sDenyAll"}}},
+			Targets: []templates.Target{
+				{Target: "unknown.example.com", Rego: "package denyall\n\ndeny[msg] {\n  msg := \"unknown\"\n}\n"},
+				{Target: "admission.k8s.gatekeeper.sh", Rego: "package denyall\n\nviolation[{\"msg\": \"denied\"}] {\n  true\n}\n"},
+			},
+		},
+	}
+
+	removed := RemoveUnsupportedTargets(templ)
+	if want := []string{"unknown.example.com"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("RemoveUnsupportedTargets() = %v; want %v", removed, want)
+	}
+	if len(templ.Spec.Targets) != 1 || templ.Spec.Targets[0].Target != "admission.k8s.gatekeeper.sh" {
+		t.Fatalf("targets = %v; want the admission target alone", templ.Spec.Targets)
+	}
+	if warning := unsupportedTargetsError(removed); warning == nil || warning.Code != UnsupportedTargetCode {
+		t.Errorf("unsupportedTargetsError() = %v; want a warning with code %s", warning, UnsupportedTargetCode)
+	}
+	if _, err := c.AddTemplate(context.Background(), templ); err != nil {
+		t.Fatalf("AddTemplate() err = %s", err)
+	}
+	cstr := &unstructured.Unstructured{}
+	cstr.SetAPIVersion("constraints.gatekeeper.sh/v1beta1")
+	cstr.SetKind("K8sDenyAll")
+	cstr.SetName("deny-all")
+	if _, err := c.AddConstraint(context.Background(), cstr); err != nil {
+		t.Fatalf("AddConstraint() err = %s", err)
+	}
+	req := &admissionv1beta1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Name:      "pod",
+		Namespace: "default",
+		Operation: admissionv1beta1.Create,
+	}
+	req.Object.Raw = []byte(`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "pod", "namespace": "default"}}`)
+	resp, err := c.Review(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Review() err = %s", err)
+	}
+	if results := resp.Results(); len(results) != 1 || results[0].Msg != "denied" {
+		t.Errorf("Review() results = %v; want the denial of the admission target", results)
+	}
+
+	if removed := RemoveUnsupportedTargets(templ); len(removed) != 0 {
+		t.Errorf("RemoveUnsupportedTargets() = %v for a template with the admission target alone; want none", removed)
+	}
+	generic := &templates.ConstraintTemplate{Spec: templates.ConstraintTemplateSpec{Targets: []templates.Target{{Target: "unknown.example.com"}, {Target: "generic.gatekeeper.sh"}}}}
+	if removed := RemoveUnsupportedTargets(generic); !reflect.DeepEqual(removed, []string{"unknown.example.com"}) || len(generic.Spec.Targets) != 1 {
+		t.Errorf("RemoveUnsupportedTargets() = %v, targets %v for a template with the generic target; want the generic target alone", removed, generic.Spec.Targets)
+	}
+	unknown := &templates.ConstraintTemplate{Spec: templates.ConstraintTemplateSpec{Targets: []templates.Target{{Target: "unknown.example.com"}}}}
+	if removed := RemoveUnsupportedTargets(unknown); len(removed) != 0 || len(unknown.Spec.Targets) != 1 {
+		t.Errorf("RemoveUnsupportedTargets() = %v, targets %v for a template without the admission target; want it left as is", removed, unknown.Spec.Targets)
+	}
+}
+
+func TestUnknownTemplateFields(t *testing.T) {
+	raw := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "templates.gatekeeper.sh/v1beta1",
+		"kind":       "ConstraintTemplate",
+		"metadata":   map[string]interface{}{"name": "k8sdenyall"},
+		"spec": map[string]interface{}{
+			"crd": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"names": map[string]interface{}{"kind": "K8sDenyAll", "shortName": "denyall"},
+				},
+			},
+			"targets": []interface{}{
+				map[string]interface{}{"target": "admission.k8s.gatekeeper.sh", "rego": "package foo", "libs": []interface{}{}, "lib": "package lib"},
+			},
+			"target": "admission.k8s.gatekeeper.sh",
+		},
+	}}
+	unknown, err := unknownTemplateFields(raw)
+	if err != nil {
+		t.Fatalf("unknownTemplateFields() err = %s", err)
+	}
+	expected := []string{"spec.crd.spec.names.shortName", "spec.target", "spec.targets[0].lib"}
+	if !reflect.DeepEqual(unknown, expected) {
+		t.Errorf("unknownTemplateFields() = %v; want %v", unknown, expected)
+	}
+}
@@ -0,0 +1,64 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package constrainttemplate
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// UnknownFieldsCode is the code of the status error listing the fields of the spec of a
+// template that Gatekeeper does not know, reported with --strict-spec-validation. It is a
+// warning: the fields are ignored.
+const UnknownFieldsCode = "unknown_fields"
+
+// unknownTemplateFields returns the sorted paths of the fields of the spec of raw that are
+// lost when it is decoded as a ConstraintTemplate. The typed client drops them silently.
+func unknownTemplateFields(raw *unstructured.Unstructured) ([]string, error) {
+	templ := &v1beta1.ConstraintTemplate{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw.Object, templ); err != nil {
+		return nil, err
+	}
+	known, err := runtime.DefaultUnstructuredConverter.ToUnstructured(templ)
+	if err != nil {
+		return nil, err
+	}
+	return util.UnknownFields("spec", raw.Object["spec"], known["spec"]), nil
+}
+
+// unknownFieldsError returns the status error warning of the unknown fields of the template
+// named name, or nil if it has none
+func (r *ReconcileConstraintTemplate) unknownFieldsError(name types.NamespacedName) (*v1beta1.CreateCRDError, error) {
+	raw := &unstructured.Unstructured{}
+	raw.SetGroupVersionKind(v1beta1.SchemeGroupVersion.WithKind("ConstraintTemplate"))
+	if err := r.Get(context.TODO(), name, raw); err != nil {
+		return nil, err
+	}
+	unknown, err := unknownTemplateFields(raw)
+	if err != nil || len(unknown) == 0 {
+		return nil, err
+	}
+	msg := "ignoring unknown fields " + strings.Join(unknown, ", ")
+	log.Error(errors.New(msg), "template has fields that are ignored", "name", name.Name)
+	return &v1beta1.CreateCRDError{Code: UnknownFieldsCode, Message: msg}, nil
+}
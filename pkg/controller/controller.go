@@ -17,6 +17,7 @@ package controller
 
 import (
 	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
 	"github.com/open-policy-agent/gatekeeper/pkg/watch"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
@@ -24,6 +25,7 @@ import (
 type Injector interface {
 	InjectOpa(*opa.Client)
 	InjectWatchManager(*watch.WatchManager)
+	InjectConstraintIndex(*target.ConstraintIndex)
 	Add(mgr manager.Manager) error
 }
 
@@ -35,10 +37,11 @@ var Injectors []Injector
 var AddToManagerFuncs []func(manager.Manager) error
 
 // AddToManager adds all Controllers to the Manager
-func AddToManager(m manager.Manager, client *opa.Client, wm *watch.WatchManager) error {
+func AddToManager(m manager.Manager, client *opa.Client, wm *watch.WatchManager, index *target.ConstraintIndex) error {
 	for _, a := range Injectors {
 		a.InjectOpa(client)
 		a.InjectWatchManager(wm)
+		a.InjectConstraintIndex(index)
 		if err := a.Add(m); err != nil {
 			return err
 		}
@@ -0,0 +1,34 @@
+package externaldata
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ExemptionsLabel marks a ConfigMap in the Gatekeeper namespace as exemption data when set to
+// "true". Each key in its data is a JSON exemption, which templates read as
+// data.inventory.exemptions[<ConfigMap name>][<key>].
+const ExemptionsLabel = "gatekeeper.sh/exemptions"
+
+// parseExemptions decodes every key of an exemption ConfigMap as an exemption. Unknown fields
+// are rejected, so that a misspelled list cannot exempt more than intended.
+func parseExemptions(cm *corev1.ConfigMap) (map[string]target.Exemption, error) {
+	exemptions := make(map[string]target.Exemption, len(cm.Data))
+	for k, v := range cm.Data {
+		var e target.Exemption
+		decoder := json.NewDecoder(bytes.NewReader([]byte(v)))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&e); err != nil {
+			return nil, fmt.Errorf("exemption %s is invalid: %s", k, err)
+		}
+		if len(e.Kinds) == 0 && len(e.Namespaces) == 0 && len(e.Names) == 0 {
+			return nil, fmt.Errorf("exemption %s is invalid: it must list kinds, namespaces or names", k)
+		}
+		exemptions[k] = e
+	}
+	return exemptions, nil
+}
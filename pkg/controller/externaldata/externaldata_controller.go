@@ -0,0 +1,166 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package externaldata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	"github.com/open-policy-agent/gatekeeper/pkg/watch"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const ctrlName = "externaldata-controller"
+
+// Label marks a ConfigMap in the Gatekeeper namespace as external data when set to "true".
+// Each key in its data is a JSON document, which templates read as
+// data.inventory.external[<ConfigMap name>][<key>].
+const Label = "gatekeeper.sh/external-data"
+
+var log = logf.Log.WithName("controller").WithValues("kind", "ExternalData")
+
+type Adder struct {
+	Opa *opa.Client
+}
+
+// Add creates a new ExternalData Controller and adds it to the Manager with default RBAC. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func (a *Adder) Add(mgr manager.Manager) error {
+	r := newReconciler(mgr, a.Opa)
+	return add(mgr, r)
+}
+
+func (a *Adder) InjectOpa(o *opa.Client) {
+	a.Opa = o
+}
+
+func (a *Adder) InjectWatchManager(wm *watch.WatchManager) {}
+
+func (a *Adder) InjectConstraintIndex(idx *target.ConstraintIndex) {}
+
+// newReconciler returns a new reconcile.Reconciler
+func newReconciler(mgr manager.Manager, opa *opa.Client) reconcile.Reconciler {
+	return &ReconcileExternalData{Client: mgr.GetClient(), opa: opa}
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler
+func add(mgr manager.Manager, r reconcile.Reconciler) error {
+	// Create a new controller
+	c, err := controller.New(ctrlName, mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to ConfigMaps, which may hold external data
+	err = c.Watch(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var _ reconcile.Reconciler = &ReconcileExternalData{}
+
+// ReconcileExternalData loads the external data and exemption data held by ConfigMaps into OPA
+type ReconcileExternalData struct {
+	client.Client
+	opa *opa.Client
+}
+
+// Reconcile loads the documents of an external data ConfigMap, or the exemptions of an
+// exemption ConfigMap, into OPA, replacing any previously loaded from it, and removes them
+// once the ConfigMap is deleted or unlabeled
+// +kubebuilder:rbac:groups=,resources=configmaps,verbs=get;list;watch
+func (r *ReconcileExternalData) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	if request.Namespace != util.GetNamespace() {
+		return reconcile.Result{}, nil
+	}
+	cm := &corev1.ConfigMap{}
+	err := r.Get(context.TODO(), request.NamespacedName, cm)
+	if err != nil && !errors.IsNotFound(err) {
+		return reconcile.Result{}, err
+	}
+	removed := errors.IsNotFound(err) || !cm.GetDeletionTimestamp().IsZero()
+	if err := r.reconcileExternalData(request.Name, cm, removed || cm.GetLabels()[Label] != "true"); err != nil {
+		return reconcile.Result{}, err
+	}
+	if err := r.reconcileExemptions(request.Name, cm, removed || cm.GetLabels()[ExemptionsLabel] != "true"); err != nil {
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{}, nil
+}
+
+func (r *ReconcileExternalData) reconcileExternalData(name string, cm *corev1.ConfigMap, removed bool) error {
+	if removed {
+		_, err := r.opa.RemoveData(context.Background(), &target.ExternalData{Name: name})
+		return err
+	}
+	documents, err := parseDocuments(cm)
+	if err != nil {
+		// retrying cannot fix the document, so keep serving the last valid version until the
+		// ConfigMap is updated
+		log.Error(err, "invalid external data, keeping the previously loaded documents", "name", cm.GetName())
+		return nil
+	}
+	if _, err := r.opa.AddData(context.Background(), &target.ExternalData{Name: cm.GetName(), Documents: documents}); err != nil {
+		return err
+	}
+	log.Info("loaded external data", "name", cm.GetName(), "documents", len(documents))
+	return nil
+}
+
+func (r *ReconcileExternalData) reconcileExemptions(name string, cm *corev1.ConfigMap, removed bool) error {
+	if removed {
+		_, err := r.opa.RemoveData(context.Background(), &target.ExemptionData{Name: name})
+		return err
+	}
+	exemptions, err := parseExemptions(cm)
+	if err != nil {
+		log.Error(err, "invalid exemption data, keeping the previously loaded exemptions", "name", cm.GetName())
+		return nil
+	}
+	if _, err := r.opa.AddData(context.Background(), &target.ExemptionData{Name: cm.GetName(), Exemptions: exemptions}); err != nil {
+		return err
+	}
+	log.Info("loaded exemption data", "name", cm.GetName(), "exemptions", len(exemptions))
+	return nil
+}
+
+// parseDocuments decodes every key of an external data ConfigMap as a JSON document
+func parseDocuments(cm *corev1.ConfigMap) (map[string]interface{}, error) {
+	documents := make(map[string]interface{}, len(cm.Data))
+	for k, v := range cm.Data {
+		var doc interface{}
+		if err := json.Unmarshal([]byte(v), &doc); err != nil {
+			return nil, fmt.Errorf("document %s is not valid JSON: %s", k, err)
+		}
+		documents[k] = doc
+	}
+	return documents, nil
+}
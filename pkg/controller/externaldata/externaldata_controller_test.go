@@ -0,0 +1,307 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package externaldata
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers/local"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/core/templates"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ client.Client = &fakeClient{}
+
+// fakeClient serves a single ConfigMap, or none if it is nil
+type fakeClient struct {
+	cm *corev1.ConfigMap
+}
+
+func (c *fakeClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	if c.cm == nil {
+		return errors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, key.Name)
+	}
+	c.cm.DeepCopyInto(obj.(*corev1.ConfigMap))
+	return nil
+}
+
+func (c *fakeClient) List(ctx context.Context, opts *client.ListOptions, list runtime.Object) error {
+	return nil
+}
+
+func (c *fakeClient) Create(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+
+func (c *fakeClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOptionFunc) error {
+	return nil
+}
+
+func (c *fakeClient) Update(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+
+func (c *fakeClient) Status() client.StatusWriter {
+	return c
+}
+
+const allowedRegistriesRego = `
+package allowedregistries
+
+violation[{"msg": msg}] {
+  container := input.review.object.spec.containers[_]
+  not allowed(container.image)
+  msg := sprintf("image %v is not from an allowed registry", [container.image])
+}
+
+allowed(image) {
+  registry := data.inventory.external["allowed-registries"]["registries"][_]
+  startswith(image, registry)
+}
+`
+
+func podRequest(image string) *admissionv1beta1.AdmissionRequest {
+	return &admissionv1beta1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"},
+		Name:      "pod",
+		Namespace: "default",
+		Operation: admissionv1beta1.Create,
+		Object: runtime.RawExtension{
+			Raw: []byte(fmt.Sprintf(`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "pod"}, "spec": {"containers": [{"name": "c", "image": "%s"}]}}`, image)),
+		},
+	}
+}
+
+func TestExternalData(t *testing.T) {
+	driver := local.New(local.Tracing(false))
+	backend, err := opa.NewBackend(opa.Driver(driver))
+	if err != nil {
+		t.Fatalf("unable to set up OPA backend: %s", err)
+	}
+	c, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+	if err != nil {
+		t.Fatalf("unable to set up OPA client: %s", err)
+	}
+	templ := &templates.ConstraintTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "k8sallowedregistries"},
+		Spec: templates.ConstraintTemplateSpec{
+			CRD: templates.CRD{Spec: templates.CRDSpec{Names: templates.Names{Kind: "K8sAllowedRegistries"}}},
+			Targets: []templates.Target{
+				{Target: (&target.K8sValidationTarget{}).GetName(), Rego: allowedRegistriesRego},
+			},
+		},
+	}
+	if _, err := c.AddTemplate(context.Background(), templ); err != nil {
+		t.Fatalf("could not add template: %s", err)
+	}
+	cstr := &unstructured.Unstructured{}
+	cstr.SetGroupVersionKind(schema.GroupVersionKind{Group: "constraints.gatekeeper.sh", Version: "v1beta1", Kind: "K8sAllowedRegistries"})
+	cstr.SetName("allowed-registries")
+	if _, err := c.AddConstraint(context.Background(), cstr); err != nil {
+		t.Fatalf("could not add constraint: %s", err)
+	}
+
+	fc := &fakeClient{cm: &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "allowed-registries",
+			Namespace: util.GetNamespace(),
+			Labels:    map[string]string{Label: "true"},
+		},
+		Data: map[string]string{"registries": `["gcr.io/", "quay.io/"]`},
+	}}
+	r := &ReconcileExternalData{Client: fc, opa: c}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: util.GetNamespace(), Name: "allowed-registries"}}
+	denied := func(image string) bool {
+		resp, err := c.Review(context.Background(), podRequest(image))
+		if err != nil {
+			t.Fatalf("Review() err = %s", err)
+		}
+		return len(resp.Results()) > 0
+	}
+	reconcileAndCheck := func(stage string, want map[string]bool) {
+		if _, err := r.Reconcile(req); err != nil {
+			t.Fatalf("%s: Reconcile() err = %s", stage, err)
+		}
+		for image, wantDenied := range want {
+			if got := denied(image); got != wantDenied {
+				t.Errorf("%s: %s denied = %t; want %t", stage, image, got, wantDenied)
+			}
+		}
+	}
+
+	reconcileAndCheck("loaded", map[string]bool{"gcr.io/app": false, "evil.io/app": true})
+
+	// wiping synced resources keeps external data
+	for _, scope := range []string{target.ClusterScope, target.NamespaceScope} {
+		if _, err := c.RemoveData(context.Background(), target.WipeData{Scope: scope}); err != nil {
+			t.Fatalf("could not wipe %s data: %s", scope, err)
+		}
+	}
+	if denied("gcr.io/app") {
+		t.Error("external data removed by wiping synced resources")
+	}
+
+	fc.cm.Data["registries"] = `["evil.io/"]`
+	reconcileAndCheck("updated", map[string]bool{"gcr.io/app": true, "evil.io/app": false})
+
+	fc.cm.Data["registries"] = `["gcr.io/"`
+	reconcileAndCheck("invalid update", map[string]bool{"gcr.io/app": true, "evil.io/app": false})
+
+	fc.cm = nil
+	reconcileAndCheck("deleted", map[string]bool{"gcr.io/app": true, "evil.io/app": true})
+}
+
+const denyPodsRego = `
+package denypods
+
+violation[{"msg": "pods are denied"}] {
+  not exempted
+}
+
+exempted {
+  e := data.inventory.exemptions[_][_]
+  applies(e.constraints, "K8sDenyPods")
+  applies(e.kinds, kind)
+  applies(e.namespaces, namespace)
+  applies(e.names, input.review.name)
+}
+
+# audit reviews cluster-scoped resources without a namespace
+namespace = ns {
+  ns := input.review.namespace
+}
+
+namespace = "" {
+  not input.review.namespace
+}
+
+kind = k {
+  input.review.kind.group == ""
+  k := input.review.kind.kind
+}
+
+kind = k {
+  input.review.kind.group != ""
+  k := concat(".", [input.review.kind.kind, input.review.kind.group])
+}
+
+applies(list, value) {
+  count(list) == 0
+}
+
+applies(list, value) {
+  list[_] == value
+}
+`
+
+func TestExemptionData(t *testing.T) {
+	driver := local.New(local.Tracing(false))
+	backend, err := opa.NewBackend(opa.Driver(driver))
+	if err != nil {
+		t.Fatalf("unable to set up OPA backend: %s", err)
+	}
+	c, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+	if err != nil {
+		t.Fatalf("unable to set up OPA client: %s", err)
+	}
+	templ := &templates.ConstraintTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "k8sdenypods"},
+		Spec: templates.ConstraintTemplateSpec{
+			CRD: templates.CRD{Spec: templates.CRDSpec{Names: templates.Names{Kind: "K8sDenyPods"}}},
+			Targets: []templates.Target{
+				{Target: (&target.K8sValidationTarget{}).GetName(), Rego: denyPodsRego},
+			},
+		},
+	}
+	if _, err := c.AddTemplate(context.Background(), templ); err != nil {
+		t.Fatalf("could not add template: %s", err)
+	}
+	cstr := &unstructured.Unstructured{}
+	cstr.SetGroupVersionKind(schema.GroupVersionKind{Group: "constraints.gatekeeper.sh", Version: "v1beta1", Kind: "K8sDenyPods"})
+	cstr.SetName("deny-pods")
+	if _, err := c.AddConstraint(context.Background(), cstr); err != nil {
+		t.Fatalf("could not add constraint: %s", err)
+	}
+
+	fc := &fakeClient{cm: &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "exemptions",
+			Namespace: util.GetNamespace(),
+			Labels:    map[string]string{ExemptionsLabel: "true"},
+		},
+		Data: map[string]string{"legacy": `{"kinds": ["Pod"], "namespaces": ["legacy"], "reason": "migrating"}`},
+	}}
+	r := &ReconcileExternalData{Client: fc, opa: c}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: util.GetNamespace(), Name: "exemptions"}}
+	denied := func(namespace string) bool {
+		review := podRequest("gcr.io/app")
+		review.Namespace = namespace
+		resp, err := c.Review(context.Background(), review)
+		if err != nil {
+			t.Fatalf("Review() err = %s", err)
+		}
+		return len(resp.Results()) > 0
+	}
+	reconcileAndCheck := func(stage string, want map[string]bool) {
+		if _, err := r.Reconcile(req); err != nil {
+			t.Fatalf("%s: Reconcile() err = %s", stage, err)
+		}
+		for namespace, wantDenied := range want {
+			if got := denied(namespace); got != wantDenied {
+				t.Errorf("%s: pod in %s denied = %t; want %t", stage, namespace, got, wantDenied)
+			}
+		}
+	}
+
+	reconcileAndCheck("loaded", map[string]bool{"legacy": false, "default": true})
+
+	fc.cm.Data["legacy"] = `{"constraints": ["K8sOtherConstraint"], "namespaces": ["legacy"]}`
+	reconcileAndCheck("other constraint", map[string]bool{"legacy": true, "default": true})
+
+	fc.cm.Data["legacy"] = `{"namespaces": ["legacy", "default"]}`
+	reconcileAndCheck("updated", map[string]bool{"legacy": false, "default": false})
+
+	fc.cm.Data["legacy"] = `{"namespace": ["legacy"]}`
+	reconcileAndCheck("unknown field", map[string]bool{"legacy": false, "default": false})
+
+	fc.cm.Data["legacy"] = `{"reason": "everything"}`
+	reconcileAndCheck("no selector", map[string]bool{"legacy": false, "default": false})
+
+	// exemptions are not external data
+	fc.cm.Labels = map[string]string{Label: "true"}
+	reconcileAndCheck("relabeled", map[string]bool{"legacy": true, "default": true})
+
+	fc.cm.Labels = map[string]string{ExemptionsLabel: "true"}
+	fc.cm.Data["legacy"] = `{"namespaces": ["legacy"]}`
+	reconcileAndCheck("labeled again", map[string]bool{"legacy": false, "default": true})
+
+	fc.cm = nil
+	reconcileAndCheck("deleted", map[string]bool{"legacy": true, "default": true})
+}
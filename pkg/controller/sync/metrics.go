@@ -0,0 +1,99 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sync
+
+import (
+	"sync"
+
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var cacheObjectsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "gatekeeper_opa_cache_objects",
+	Help: "Approximate number of objects synced into OPA's cache",
+})
+
+func init() {
+	util.AddMetrics("sync", cacheObjectsGauge)
+}
+
+type objectKey struct {
+	gvk       schema.GroupVersionKind
+	namespace string
+	name      string
+}
+
+// cachedObjects tracks the synced objects whose data was added to OPA, so that an object is
+// counted once however often it is reconciled
+type cachedObjects struct {
+	mux  sync.Mutex
+	keys map[objectKey]bool
+}
+
+var cached = &cachedObjects{keys: make(map[objectKey]bool)}
+
+func keyOf(obj *unstructured.Unstructured) objectKey {
+	return objectKey{gvk: obj.GroupVersionKind(), namespace: obj.GetNamespace(), name: obj.GetName()}
+}
+
+func (c *cachedObjects) add(obj *unstructured.Unstructured) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.keys[keyOf(obj)] = true
+	cacheObjectsGauge.Set(float64(len(c.keys)))
+}
+
+func (c *cachedObjects) remove(obj *unstructured.Unstructured) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	delete(c.keys, keyOf(obj))
+	cacheObjectsGauge.Set(float64(len(c.keys)))
+}
+
+func (c *cachedObjects) wipe() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.keys = make(map[objectKey]bool)
+	cacheObjectsGauge.Set(0)
+}
+
+func (c *cachedObjects) has(key objectKey) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.keys[key]
+}
+
+// ofKind returns the keys of the tracked objects of gvk
+func (c *cachedObjects) ofKind(gvk schema.GroupVersionKind) []objectKey {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	var keys []objectKey
+	for k := range c.keys {
+		if k.gvk == gvk {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// DataWiped records that the data of every synced object was removed from OPA
+func DataWiped() {
+	cached.wipe()
+	synced.reset()
+}
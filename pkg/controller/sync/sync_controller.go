@@ -19,9 +19,11 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"sync"
 
 	"github.com/go-logr/logr"
 	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -38,26 +40,50 @@ import (
 var log = logf.Log.WithName("controller").WithValues("metaKind", "Sync")
 
 const (
-	finalizerName = "finalizers.gatekeeper.sh/sync"
+	baseFinalizerName = "finalizers.gatekeeper.sh/sync"
 )
 
+func init() {
+	util.RegisterFinalizerName(baseFinalizerName)
+}
+
+// finalizerName returns the finalizer managed by this instance
+func finalizerName() string {
+	return util.FinalizerName(baseFinalizerName)
+}
+
 type Adder struct {
 	Opa *opa.Client
+	// Active, if set, restricts syncing to the kinds it contains
+	Active *ActiveKinds
 }
 
 // Add creates a new Sync Controller and adds it to the Manager with default RBAC. The Manager will set fields on the Controller
 // and Start it when the Manager is Started.
 func (a *Adder) Add(mgr manager.Manager, gvk schema.GroupVersionKind) error {
-	r := newReconciler(mgr, gvk, a.Opa)
-	return add(mgr, r, gvk)
+	r := newReconciler(mgr, gvk, a.Opa, a.Active)
+	if err := add(mgr, r, gvk); err != nil {
+		return err
+	}
+	// the informer is the one the controller watches with, so that the kind is synced once
+	// the objects it lists are added to OPA
+	instance := &unstructured.Unstructured{}
+	instance.SetGroupVersionKind(gvk)
+	informer, err := mgr.GetCache().GetInformer(instance)
+	if err != nil {
+		return err
+	}
+	synced.watch(gvk, informer)
+	return nil
 }
 
 // newReconciler returns a new reconcile.Reconciler
-func newReconciler(mgr manager.Manager, gvk schema.GroupVersionKind, opa *opa.Client) reconcile.Reconciler {
+func newReconciler(mgr manager.Manager, gvk schema.GroupVersionKind, opa *opa.Client, active *ActiveKinds) reconcile.Reconciler {
 	return &ReconcileSync{
 		Client: mgr.GetClient(),
 		scheme: mgr.GetScheme(),
 		opa:    opa,
+		active: active,
 		log:    log.WithValues("kind", gvk.Kind, "apiVersion", gvk.GroupVersion().String()),
 		gvk:    gvk,
 	}
@@ -89,6 +115,7 @@ type ReconcileSync struct {
 	client.Client
 	scheme *runtime.Scheme
 	opa    *opa.Client
+	active *ActiveKinds
 	gvk    schema.GroupVersionKind
 	log    logr.Logger
 }
@@ -102,6 +129,11 @@ func (r *ReconcileSync) Reconcile(request reconcile.Request) (reconcile.Result,
 	err := r.Get(context.TODO(), request.NamespacedName, instance)
 	if err != nil {
 		if errors.IsNotFound(err) {
+			// no finalizer holds the deletion of objects in read-only mode, so their data is
+			// removed once they are gone
+			if util.ReadOnly() {
+				return reconcile.Result{}, r.removeDeleted(request.NamespacedName.Namespace, request.NamespacedName.Name)
+			}
 			// Object not found, return.  Created objects are automatically garbage collected.
 			// For additional cleanup logic use finalizers.
 			return reconcile.Result{}, nil
@@ -116,8 +148,8 @@ func (r *ReconcileSync) Reconcile(request reconcile.Request) (reconcile.Result,
 	}
 
 	if instance.GetDeletionTimestamp().IsZero() {
-		if !containsString(finalizerName, instance.GetFinalizers()) {
-			instance.SetFinalizers(append(instance.GetFinalizers(), finalizerName))
+		if !containsString(finalizerName(), instance.GetFinalizers()) {
+			instance.SetFinalizers(append(instance.GetFinalizers(), finalizerName()))
 			// For some reason the instance sometimes gets changed by update when there is a race
 			// condition that leads to a validating webhook deny of the update
 			cpy := instance.DeepCopy()
@@ -128,14 +160,25 @@ func (r *ReconcileSync) Reconcile(request reconcile.Request) (reconcile.Result,
 				log.Info("instance and cpy differ")
 			}
 		}
-		log.Info("data will be added", "data", instance)
-		if _, err := r.opa.AddData(context.Background(), instance); err != nil {
+		added, err := r.active.Do(r.gvk, func() error {
+			log.Info("data will be added", "data", instance)
+			if _, err := r.opa.AddData(context.Background(), instance); err != nil {
+				return err
+			}
+			cached.add(instance)
+			return nil
+		})
+		if err != nil {
 			return reconcile.Result{}, err
 		}
+		if !added {
+			r.log.Info("kind is no longer synced, not adding data", "name", instance.GetName(), "namespace", instance.GetNamespace())
+		}
 	} else {
 		// Handle deletion
-		if HasFinalizer(instance) {
-			if _, err := r.opa.RemoveData(context.Background(), instance); err != nil {
+		if HasFinalizer(instance) || util.ReadOnly() {
+			// the finalizer is kept until the removal is handled, so that it is retried
+			if err := r.removeData(instance); err != nil {
 				return reconcile.Result{}, err
 			}
 			if err := RemoveFinalizer(r, instance); err != nil {
@@ -147,12 +190,91 @@ func (r *ReconcileSync) Reconcile(request reconcile.Request) (reconcile.Result,
 	return reconcile.Result{}, nil
 }
 
+// removeData removes the data of instance from OPA and notifies the functions registered with
+// OnDataRemoved
+func (r *ReconcileSync) removeData(instance *unstructured.Unstructured) error {
+	if _, err := r.opa.RemoveData(context.Background(), instance); err != nil {
+		return err
+	}
+	cached.remove(instance)
+	return notifyDataRemoved(instance)
+}
+
+// removeDeleted removes the data of the deleted object of the kind of r with namespace and
+// name, if it was added to OPA
+func (r *ReconcileSync) removeDeleted(namespace, name string) error {
+	if !cached.has(objectKey{gvk: r.gvk, namespace: namespace, name: name}) {
+		return nil
+	}
+	instance := &unstructured.Unstructured{}
+	instance.SetGroupVersionKind(r.gvk)
+	instance.SetNamespace(namespace)
+	instance.SetName(name)
+	r.log.Info("removing the data of deleted object", "name", name, "namespace", namespace)
+	return r.removeData(instance)
+}
+
+var (
+	removedMux       sync.RWMutex
+	dataRemovedFuncs []func(*unstructured.Unstructured) error
+)
+
+// OnDataRemoved registers fn to be called with each synced object whose data is removed
+// from OPA because the object is being deleted or its kind is purged. The deletion of an
+// object is reconciled again while fn returns an error.
+func OnDataRemoved(fn func(*unstructured.Unstructured) error) {
+	removedMux.Lock()
+	defer removedMux.Unlock()
+	dataRemovedFuncs = append(dataRemovedFuncs, fn)
+}
+
+// notifyDataRemoved calls every registered function with obj, and returns the first error
+func notifyDataRemoved(obj *unstructured.Unstructured) error {
+	removedMux.RLock()
+	defer removedMux.RUnlock()
+	var firstErr error
+	for _, fn := range dataRemovedFuncs {
+		if err := fn(obj); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// PurgeKind removes the data of every synced object of gvk from OPA, e.g. once the kind is no
+// longer served and its objects can no longer be deleted through the API. It returns the
+// number of objects removed. Sync controllers do not add data while it runs.
+func PurgeKind(opa *opa.Client, active *ActiveKinds, gvk schema.GroupVersionKind) (int, error) {
+	if active != nil {
+		active.mux.Lock()
+		defer active.mux.Unlock()
+	}
+	removed := 0
+	for _, key := range cached.ofKind(gvk) {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(key.gvk)
+		obj.SetNamespace(key.namespace)
+		obj.SetName(key.name)
+		if _, err := opa.RemoveData(context.Background(), obj); err != nil {
+			return removed, err
+		}
+		cached.remove(obj)
+		// the objects of a purged kind are not deleted again, so removals cannot be retried
+		if err := notifyDataRemoved(obj); err != nil {
+			log.Error(err, "unable to handle the removal of a purged object", "gvk", gvk.String(), "namespace", obj.GetNamespace(), "name", obj.GetName())
+		}
+		removed++
+	}
+	synced.forget(gvk)
+	return removed, nil
+}
+
 func HasFinalizer(obj *unstructured.Unstructured) bool {
-	return containsString(finalizerName, obj.GetFinalizers())
+	return containsString(finalizerName(), obj.GetFinalizers())
 }
 
 func RemoveFinalizer(c client.Client, obj *unstructured.Unstructured) error {
-	obj.SetFinalizers(removeString(finalizerName, obj.GetFinalizers()))
+	obj.SetFinalizers(removeString(finalizerName(), obj.GetFinalizers()))
 	return c.Update(context.Background(), obj)
 }
 
@@ -174,3 +296,42 @@ func removeString(s string, items []string) []string {
 	}
 	return rval
 }
+
+// ActiveKinds tracks which kinds are currently synced. A sync controller whose kind has been
+// removed may still be processing requests after its watch is torn down; ActiveKinds keeps it
+// from re-adding data to OPA after that data has been purged.
+type ActiveKinds struct {
+	mux   sync.RWMutex
+	kinds map[schema.GroupVersionKind]bool
+}
+
+func NewActiveKinds() *ActiveKinds {
+	return &ActiveKinds{kinds: make(map[schema.GroupVersionKind]bool)}
+}
+
+// Replace sets the active kinds and calls purge before any sync controller is able to
+// observe the new set. purge should remove cached data for kinds that are no longer active.
+func (a *ActiveKinds) Replace(gvks []schema.GroupVersionKind, purge func() error) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	kinds := make(map[schema.GroupVersionKind]bool, len(gvks))
+	for _, gvk := range gvks {
+		kinds[gvk] = true
+	}
+	a.kinds = kinds
+	return purge()
+}
+
+// Do calls fn if gvk is active, returning whether fn was called. A nil ActiveKinds treats
+// every kind as active.
+func (a *ActiveKinds) Do(gvk schema.GroupVersionKind, fn func() error) (bool, error) {
+	if a == nil {
+		return true, fn()
+	}
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+	if !a.kinds[gvk] {
+		return false, nil
+	}
+	return true, fn()
+}
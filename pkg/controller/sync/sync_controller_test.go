@@ -0,0 +1,418 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sync
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"testing"
+
+	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers/local"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ client.Client = &fakeClient{}
+
+// fakeClient serves a single object, or none if it is nil, and accepts all writes
+type fakeClient struct {
+	obj *unstructured.Unstructured
+}
+
+func (c *fakeClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	if c.obj == nil {
+		return errors.NewNotFound(schema.GroupResource{Resource: "objects"}, key.Name)
+	}
+	u := obj.(*unstructured.Unstructured)
+	u.Object = c.obj.DeepCopy().Object
+	return nil
+}
+
+func (c *fakeClient) List(ctx context.Context, opts *client.ListOptions, list runtime.Object) error {
+	return nil
+}
+
+func (c *fakeClient) Create(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+
+func (c *fakeClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOptionFunc) error {
+	return nil
+}
+
+func (c *fakeClient) Update(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+
+func (c *fakeClient) Status() client.StatusWriter {
+	return c
+}
+
+func TestReconcileRemovedKind(t *testing.T) {
+	driver := local.New(local.Tracing(false))
+	backend, err := opa.NewBackend(opa.Driver(driver))
+	if err != nil {
+		t.Fatalf("unable to set up OPA backend: %s", err)
+	}
+	opa, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+	if err != nil {
+		t.Fatalf("unable to set up OPA client: %s", err)
+	}
+
+	nsGvk := schema.GroupVersionKind{Group: "", Version: "v1", Kind: "Namespace"}
+	ns := &unstructured.Unstructured{}
+	ns.SetGroupVersionKind(nsGvk)
+	ns.SetName("testns")
+
+	active := NewActiveKinds()
+	wipe := func() error {
+		_, err := opa.RemoveData(context.Background(), target.WipeData{})
+		return err
+	}
+	r := &ReconcileSync{
+		Client: &fakeClient{obj: ns},
+		opa:    opa,
+		active: active,
+		gvk:    nsGvk,
+		log:    log,
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "testns"}}
+	cached := func() bool {
+		dump, err := opa.Dump(context.Background())
+		if err != nil {
+			t.Fatalf("could not dump OPA cache: %s", err)
+		}
+		return strings.Contains(dump, "testns")
+	}
+
+	if err := active.Replace([]schema.GroupVersionKind{nsGvk}, wipe); err != nil {
+		t.Fatalf("could not add kind: %s", err)
+	}
+	if _, err := r.Reconcile(req); err != nil {
+		t.Fatalf("Reconcile() err = %s", err)
+	}
+	if !cached() {
+		t.Fatal("namespace not cached after adding its kind")
+	}
+
+	if err := active.Replace(nil, wipe); err != nil {
+		t.Fatalf("could not remove kind: %s", err)
+	}
+	if cached() {
+		t.Error("namespace still cached after removing its kind")
+	}
+	// a sync controller that has not yet been torn down must not repopulate the cache
+	if _, err := r.Reconcile(req); err != nil {
+		t.Fatalf("Reconcile() err = %s", err)
+	}
+	if cached() {
+		t.Error("namespace re-cached by a sync controller for a removed kind")
+	}
+}
+
+func TestFinalizerPrefixIsolation(t *testing.T) {
+	defer flag.Set("finalizer-prefix", "")
+
+	obj := &unstructured.Unstructured{}
+	obj.SetName("shared")
+	for _, prefix := range []string{"tenant-a", "tenant-b"} {
+		flag.Set("finalizer-prefix", prefix)
+		obj.SetFinalizers(append(obj.GetFinalizers(), finalizerName()))
+	}
+
+	flag.Set("finalizer-prefix", "tenant-a")
+	if !HasFinalizer(obj) {
+		t.Fatal("tenant-a finalizer not found")
+	}
+	if err := RemoveFinalizer(&fakeClient{}, obj); err != nil {
+		t.Fatalf("RemoveFinalizer() err = %s", err)
+	}
+	if HasFinalizer(obj) {
+		t.Error("tenant-a finalizer not removed")
+	}
+
+	flag.Set("finalizer-prefix", "tenant-b")
+	if !HasFinalizer(obj) {
+		t.Errorf("tenant-b finalizer removed by tenant-a; finalizers = %v", obj.GetFinalizers())
+	}
+}
+
+func TestCacheObjectsGauge(t *testing.T) {
+	driver := local.New(local.Tracing(false))
+	backend, err := opa.NewBackend(opa.Driver(driver))
+	if err != nil {
+		t.Fatalf("unable to set up OPA backend: %s", err)
+	}
+	opa, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+	if err != nil {
+		t.Fatalf("unable to set up OPA client: %s", err)
+	}
+	cached.wipe()
+	gauge := func() float64 {
+		m := &dto.Metric{}
+		if err := cacheObjectsGauge.Write(m); err != nil {
+			t.Fatalf("could not read gauge: %s", err)
+		}
+		return m.GetGauge().GetValue()
+	}
+
+	nsGvk := schema.GroupVersionKind{Group: "", Version: "v1", Kind: "Namespace"}
+	c := &fakeClient{}
+	r := &ReconcileSync{Client: c, opa: opa, gvk: nsGvk, log: log}
+	reconcileNs := func(name string, deleted bool) {
+		ns := &unstructured.Unstructured{}
+		ns.SetGroupVersionKind(nsGvk)
+		ns.SetName(name)
+		ns.SetFinalizers([]string{finalizerName()})
+		if deleted {
+			now := metav1.Now()
+			ns.SetDeletionTimestamp(&now)
+		}
+		c.obj = ns
+		if _, err := r.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: name}}); err != nil {
+			t.Fatalf("Reconcile() err = %s", err)
+		}
+	}
+
+	reconcileNs("first", false)
+	reconcileNs("first", false)
+	if got := gauge(); got != 1 {
+		t.Errorf("gauge = %v after adding an object twice; want 1", got)
+	}
+	reconcileNs("second", false)
+	if got := gauge(); got != 2 {
+		t.Errorf("gauge = %v after adding a second object; want 2", got)
+	}
+	reconcileNs("first", true)
+	if got := gauge(); got != 1 {
+		t.Errorf("gauge = %v after removing an object; want 1", got)
+	}
+	DataWiped()
+	if got := gauge(); got != 0 {
+		t.Errorf("gauge = %v after wiping the cache; want 0", got)
+	}
+}
+
+func TestPurgeKind(t *testing.T) {
+	driver := local.New(local.Tracing(false))
+	backend, err := opa.NewBackend(opa.Driver(driver))
+	if err != nil {
+		t.Fatalf("unable to set up OPA backend: %s", err)
+	}
+	opa, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+	if err != nil {
+		t.Fatalf("unable to set up OPA client: %s", err)
+	}
+	cached.wipe()
+	defer cached.wipe()
+
+	nsGvk := schema.GroupVersionKind{Group: "", Version: "v1", Kind: "Namespace"}
+	widgetGvk := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Widget"}
+	active := NewActiveKinds()
+	if err := active.Replace([]schema.GroupVersionKind{nsGvk, widgetGvk}, func() error { return nil }); err != nil {
+		t.Fatalf("could not add kinds: %s", err)
+	}
+	var notified []string
+	OnDataRemoved(func(obj *unstructured.Unstructured) error {
+		notified = append(notified, obj.GetName())
+		return nil
+	})
+	defer func() {
+		removedMux.Lock()
+		dataRemovedFuncs = dataRemovedFuncs[:len(dataRemovedFuncs)-1]
+		removedMux.Unlock()
+	}()
+
+	syncObj := func(gvk schema.GroupVersionKind, namespace, name string) {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(gvk)
+		obj.SetNamespace(namespace)
+		obj.SetName(name)
+		r := &ReconcileSync{Client: &fakeClient{obj: obj}, opa: opa, active: active, gvk: gvk, log: log}
+		if _, err := r.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}); err != nil {
+			t.Fatalf("Reconcile() err = %s", err)
+		}
+	}
+	syncObj(nsGvk, "", "testns")
+	syncObj(widgetGvk, "testns", "ghost-widget")
+	syncObj(widgetGvk, "", "cluster-widget")
+
+	// the CRD of Widget is deleted, taking its objects with it
+	n, err := PurgeKind(opa, active, widgetGvk)
+	if err != nil {
+		t.Fatalf("PurgeKind() err = %s", err)
+	}
+	if n != 2 {
+		t.Errorf("PurgeKind() = %d, want 2", n)
+	}
+	dump, err := opa.Dump(context.Background())
+	if err != nil {
+		t.Fatalf("could not dump OPA cache: %s", err)
+	}
+	for _, name := range []string{"ghost-widget", "cluster-widget"} {
+		if strings.Contains(dump, name) {
+			t.Errorf("%s still cached after purging its kind", name)
+		}
+	}
+	if !strings.Contains(dump, "testns") {
+		t.Error("namespace of another kind purged")
+	}
+	if len(notified) != 2 {
+		t.Errorf("notified of removed data for %v, want both widgets", notified)
+	}
+	m := &dto.Metric{}
+	if err := cacheObjectsGauge.Write(m); err != nil {
+		t.Fatalf("could not read gauge: %s", err)
+	}
+	if got := m.GetGauge().GetValue(); got != 1 {
+		t.Errorf("gauge = %v after purging a kind; want 1", got)
+	}
+}
+
+// finalizerClient serves a single object and records whether its finalizer was removed
+type finalizerClient struct {
+	fakeClient
+	removed bool
+}
+
+func (c *finalizerClient) Update(ctx context.Context, obj runtime.Object) error {
+	c.removed = !containsString(finalizerName(), obj.(*unstructured.Unstructured).GetFinalizers())
+	return nil
+}
+
+func TestReconcileDeletionRetriesRemovalHandlers(t *testing.T) {
+	driver := local.New(local.Tracing(false))
+	backend, err := opa.NewBackend(opa.Driver(driver))
+	if err != nil {
+		t.Fatalf("unable to set up OPA backend: %s", err)
+	}
+	opa, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+	if err != nil {
+		t.Fatalf("unable to set up OPA client: %s", err)
+	}
+	cached.wipe()
+	defer cached.wipe()
+
+	failures := 1
+	OnDataRemoved(func(obj *unstructured.Unstructured) error {
+		if failures > 0 {
+			failures--
+			return fmt.Errorf("unable to clear the violations of %s", obj.GetName())
+		}
+		return nil
+	})
+	defer func() {
+		removedMux.Lock()
+		dataRemovedFuncs = dataRemovedFuncs[:len(dataRemovedFuncs)-1]
+		removedMux.Unlock()
+	}()
+
+	nsGvk := schema.GroupVersionKind{Group: "", Version: "v1", Kind: "Namespace"}
+	ns := &unstructured.Unstructured{}
+	ns.SetGroupVersionKind(nsGvk)
+	ns.SetName("testns")
+	ns.SetFinalizers([]string{finalizerName()})
+	now := metav1.Now()
+	ns.SetDeletionTimestamp(&now)
+	fc := &finalizerClient{fakeClient: fakeClient{obj: ns}}
+	r := &ReconcileSync{Client: fc, opa: opa, gvk: nsGvk, log: log}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "testns"}}
+
+	if _, err := r.Reconcile(req); err == nil {
+		t.Error("Reconcile() err = nil when the removal is not handled; want an error so it is requeued")
+	}
+	if fc.removed {
+		t.Error("finalizer removed before the removal was handled")
+	}
+	if _, err := r.Reconcile(req); err != nil {
+		t.Fatalf("Reconcile() err = %s once the removal is handled", err)
+	}
+	if !fc.removed {
+		t.Error("finalizer not removed once the removal was handled")
+	}
+}
+
+func TestReconcileReadOnlyDeletion(t *testing.T) {
+	defer flag.Set("read-only", "false")
+	flag.Set("read-only", "true")
+	driver := local.New(local.Tracing(false))
+	backend, err := opa.NewBackend(opa.Driver(driver))
+	if err != nil {
+		t.Fatalf("unable to set up OPA backend: %s", err)
+	}
+	opa, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+	if err != nil {
+		t.Fatalf("unable to set up OPA client: %s", err)
+	}
+	cached.wipe()
+	defer cached.wipe()
+
+	nsGvk := schema.GroupVersionKind{Group: "", Version: "v1", Kind: "Namespace"}
+	synced := func(name string) (*fakeClient, *ReconcileSync) {
+		ns := &unstructured.Unstructured{}
+		ns.SetGroupVersionKind(nsGvk)
+		ns.SetName(name)
+		fc := &fakeClient{obj: ns}
+		r := &ReconcileSync{Client: fc, opa: opa, gvk: nsGvk, log: log}
+		if _, err := r.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: name}}); err != nil {
+			t.Fatalf("Reconcile() err = %s", err)
+		}
+		return fc, r
+	}
+	dumped := func() string {
+		dump, err := opa.Dump(context.Background())
+		if err != nil {
+			t.Fatalf("could not dump OPA cache: %s", err)
+		}
+		return dump
+	}
+
+	// no finalizer is written, so the object is gone by the time it is reconciled
+	fc, r := synced("gone")
+	fc.obj = nil
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "gone"}}); err != nil {
+		t.Fatalf("Reconcile() err = %s", err)
+	}
+	if strings.Contains(dumped(), "gone") {
+		t.Error("deleted object still cached in read-only mode")
+	}
+	if cached.has(objectKey{gvk: nsGvk, name: "gone"}) {
+		t.Error("deleted object still counted as cached")
+	}
+
+	// the finalizer of another client holds the deletion
+	fc, r = synced("terminating")
+	fc.obj.SetFinalizers([]string{"kubernetes"})
+	now := metav1.Now()
+	fc.obj.SetDeletionTimestamp(&now)
+	if _, err := r.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: "terminating"}}); err != nil {
+		t.Fatalf("Reconcile() err = %s", err)
+	}
+	if strings.Contains(dumped(), "terminating") {
+		t.Error("object being deleted still cached in read-only mode")
+	}
+}
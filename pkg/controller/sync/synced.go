@@ -0,0 +1,167 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sync
+
+import (
+	"sort"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+// syncSource is the informer a sync controller watches its kind with
+type syncSource interface {
+	HasSynced() bool
+	GetStore() toolscache.Store
+}
+
+// syncedKinds tracks which kinds have been synced, so that referential constraints are not
+// evaluated against a partial data.inventory. A kind is synced once its informer has listed
+// its objects and every object it listed has been added to OPA, been deleted or started being
+// deleted. It then stays synced, across restarts of the watch manager, until its data is
+// removed from OPA.
+type syncedKinds struct {
+	mux     sync.Mutex
+	sources map[schema.GroupVersionKind]syncSource
+	synced  map[schema.GroupVersionKind]bool
+	// pending holds the keys of the objects listed by the informer of each kind that are not
+	// in OPA yet, once the informer has synced, so that each check only looks at those
+	pending map[schema.GroupVersionKind]map[string]bool
+}
+
+var synced = newSyncedKinds()
+
+func newSyncedKinds() *syncedKinds {
+	return &syncedKinds{
+		sources: make(map[schema.GroupVersionKind]syncSource),
+		synced:  make(map[schema.GroupVersionKind]bool),
+		pending: make(map[schema.GroupVersionKind]map[string]bool),
+	}
+}
+
+// watch records that the objects of gvk are synced from source
+func (s *syncedKinds) watch(gvk schema.GroupVersionKind, source syncSource) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.sources[gvk] = source
+	delete(s.pending, gvk)
+}
+
+// forget records that the data of gvk was removed from OPA
+func (s *syncedKinds) forget(gvk schema.GroupVersionKind) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.sources, gvk)
+	delete(s.synced, gvk)
+	delete(s.pending, gvk)
+}
+
+// reset records that the data of every kind was removed from OPA. The kinds still watched are
+// synced again once their objects are added back.
+func (s *syncedKinds) reset() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.synced = make(map[schema.GroupVersionKind]bool)
+	s.pending = make(map[schema.GroupVersionKind]map[string]bool)
+}
+
+func (s *syncedKinds) isSynced(gvk schema.GroupVersionKind, c *cachedObjects) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.synced[gvk] {
+		return true
+	}
+	source, ok := s.sources[gvk]
+	if !ok || !source.HasSynced() {
+		return false
+	}
+	pending, ok := s.pending[gvk]
+	if !ok {
+		pending = make(map[string]bool)
+		for _, obj := range source.GetStore().List() {
+			if terminating(obj) {
+				continue
+			}
+			if key, err := toolscache.MetaNamespaceKeyFunc(obj); err == nil {
+				pending[key] = true
+			}
+		}
+		s.pending[gvk] = pending
+	}
+	for key := range pending {
+		if added(gvk, key, source, c) {
+			delete(pending, key)
+		}
+	}
+	if len(pending) != 0 {
+		return false
+	}
+	delete(s.pending, gvk)
+	s.synced[gvk] = true
+	return true
+}
+
+// added returns true if the object of gvk with key is in c, or will not be added to OPA as it
+// has since been deleted from source or is being deleted
+func added(gvk schema.GroupVersionKind, key string, source syncSource, c *cachedObjects) bool {
+	namespace, name, err := toolscache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return true
+	}
+	if c.has(objectKey{gvk: gvk, namespace: namespace, name: name}) {
+		return true
+	}
+	obj, exists, err := source.GetStore().GetByKey(key)
+	return err == nil && (!exists || terminating(obj))
+}
+
+// terminating returns true if obj is being deleted, as the sync controller does not add such
+// objects to OPA
+func terminating(obj interface{}) bool {
+	accessor, err := meta.Accessor(obj)
+	return err == nil && accessor.GetDeletionTimestamp() != nil
+}
+
+// Synced returns true once every object of gvk listed from the API server, other than those
+// being deleted, has been added to OPA, and false if gvk is not watched
+func Synced(gvk schema.GroupVersionKind) bool {
+	return synced.isSynced(gvk, cached)
+}
+
+// unsynced returns the watched kinds that are not synced yet, sorted
+func (s *syncedKinds) unsynced(c *cachedObjects) []schema.GroupVersionKind {
+	s.mux.Lock()
+	var gvks []schema.GroupVersionKind
+	for gvk := range s.sources {
+		gvks = append(gvks, gvk)
+	}
+	s.mux.Unlock()
+	var unsynced []schema.GroupVersionKind
+	for _, gvk := range gvks {
+		if !s.isSynced(gvk, c) {
+			unsynced = append(unsynced, gvk)
+		}
+	}
+	sort.Slice(unsynced, func(i, j int) bool { return unsynced[i].String() < unsynced[j].String() })
+	return unsynced
+}
+
+// Unsynced returns the watched kinds whose objects have not all been added to OPA yet
+func Unsynced() []schema.GroupVersionKind {
+	return synced.unsynced(cached)
+}
@@ -0,0 +1,170 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sync
+
+import (
+	"testing"
+
+	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers/local"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// fakeSource is an informer that has listed the objects of its store once synced is set
+type fakeSource struct {
+	store  toolscache.Store
+	synced bool
+}
+
+func (s *fakeSource) HasSynced() bool {
+	return s.synced
+}
+
+func (s *fakeSource) GetStore() toolscache.Store {
+	return s.store
+}
+
+func TestSynced(t *testing.T) {
+	driver := local.New(local.Tracing(false))
+	backend, err := opa.NewBackend(opa.Driver(driver))
+	if err != nil {
+		t.Fatalf("unable to set up OPA backend: %s", err)
+	}
+	opa, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+	if err != nil {
+		t.Fatalf("unable to set up OPA client: %s", err)
+	}
+	DataWiped()
+	defer DataWiped()
+
+	nsGvk := schema.GroupVersionKind{Group: "", Version: "v1", Kind: "Namespace"}
+	podGvk := schema.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"}
+	defer synced.forget(nsGvk)
+	defer synced.forget(podGvk)
+	newObj := func(gvk schema.GroupVersionKind, namespace, name string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(gvk)
+		obj.SetNamespace(namespace)
+		obj.SetName(name)
+		return obj
+	}
+	newSource := func(objs ...*unstructured.Unstructured) *fakeSource {
+		s := &fakeSource{store: toolscache.NewStore(toolscache.MetaNamespaceKeyFunc)}
+		for _, obj := range objs {
+			if err := s.store.Add(obj); err != nil {
+				t.Fatalf("could not add to store: %s", err)
+			}
+		}
+		return s
+	}
+	syncObj := func(obj *unstructured.Unstructured) {
+		r := &ReconcileSync{Client: &fakeClient{obj: obj}, opa: opa, gvk: obj.GroupVersionKind(), log: log}
+		if _, err := r.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}}); err != nil {
+			t.Fatalf("Reconcile() err = %s", err)
+		}
+	}
+
+	if Synced(nsGvk) {
+		t.Error("kind synced before it is watched")
+	}
+	a, b := newObj(nsGvk, "", "a"), newObj(nsGvk, "", "b")
+	source := newSource(a, b)
+	synced.watch(nsGvk, source)
+	if Synced(nsGvk) {
+		t.Error("kind synced before its informer has listed it")
+	}
+	if got := Unsynced(); len(got) != 1 || got[0] != nsGvk {
+		t.Errorf("Unsynced() = %v; want [%v]", got, nsGvk)
+	}
+	source.synced = true
+	syncObj(a)
+	if Synced(nsGvk) {
+		t.Error("kind synced before every listed object is in OPA")
+	}
+	syncObj(b)
+	if !Synced(nsGvk) {
+		t.Error("kind not synced once every listed object is in OPA")
+	}
+	if got := Unsynced(); len(got) != 0 {
+		t.Errorf("Unsynced() = %v; want none", got)
+	}
+
+	// the data stays in OPA while the watch manager restarts
+	synced.watch(nsGvk, newSource(a, b))
+	if !Synced(nsGvk) {
+		t.Error("kind no longer synced after its watch restarted")
+	}
+
+	// objects being deleted are never added to OPA, so they do not hold the kind back
+	stuck := newObj(nsGvk, "", "stuck")
+	now := metav1.Now()
+	stuck.SetDeletionTimestamp(&now)
+	c := newObj(nsGvk, "", "c")
+	synced.forget(nsGvk)
+	source = newSource(a, b, c, stuck)
+	source.synced = true
+	synced.watch(nsGvk, source)
+	if Synced(nsGvk) {
+		t.Error("kind synced before every listed object is in OPA")
+	}
+	syncObj(c)
+	if !Synced(nsGvk) {
+		t.Error("kind not synced while an object being deleted is listed")
+	}
+
+	// objects deleted once listed are not waited for either
+	synced.forget(nsGvk)
+	d := newObj(nsGvk, "", "d")
+	source = newSource(a, b, c, d)
+	source.synced = true
+	synced.watch(nsGvk, source)
+	if Synced(nsGvk) {
+		t.Error("kind synced before every listed object is in OPA")
+	}
+	if err := source.store.Delete(d); err != nil {
+		t.Fatalf("could not delete from store: %s", err)
+	}
+	if !Synced(nsGvk) {
+		t.Error("kind not synced once the missing object was deleted")
+	}
+
+	// kinds whose objects are namespaced
+	pod := newObj(podGvk, "a", "pod")
+	pods := newSource(pod)
+	pods.synced = true
+	synced.watch(podGvk, pods)
+	syncObj(pod)
+	if !Synced(podGvk) {
+		t.Error("kind of namespaced objects not synced")
+	}
+
+	if _, err := PurgeKind(opa, nil, podGvk); err != nil {
+		t.Fatalf("PurgeKind() err = %s", err)
+	}
+	if Synced(podGvk) {
+		t.Error("kind synced after its data was purged")
+	}
+	DataWiped()
+	if Synced(nsGvk) {
+		t.Error("kind synced after the data was wiped")
+	}
+}
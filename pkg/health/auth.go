@@ -0,0 +1,159 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var (
+	debugAuthTokenFile = flag.String("debug-auth-token-file", "", "file holding a bearer token that clients of the /debug endpoints of --health-addr must send as an Authorization: Bearer header, so that they can be exposed beyond localhost. the token is read at startup. the endpoints are only served to localhost if unspecified")
+	debugTLSCertFile   = flag.String("debug-tls-cert-file", "", "PEM certificate that --health-addr is served over TLS with, along with --debug-tls-key-file. served over plain HTTP if unspecified")
+	debugTLSKeyFile    = flag.String("debug-tls-key-file", "", "PEM private key of --debug-tls-cert-file")
+	debugClientCAFile  = flag.String("debug-client-ca-file", "", "PEM file of the certificate authorities whose client certificates authenticate clients of the /debug endpoints of --health-addr, in addition to --debug-auth-token-file. requires --debug-tls-cert-file. client certificates are not accepted if unspecified")
+)
+
+type authenticatedKey struct{}
+
+// auth authenticates the clients of the debugging endpoints with a bearer token or a client
+// certificate. A nil auth lets every client through.
+type auth struct {
+	// token is the bearer token accepted, or nil if tokens are not accepted
+	token []byte
+	// clientCerts is set if verified client certificates are accepted
+	clientCerts bool
+}
+
+// LoadAuth reads the authentication of the debugging endpoints and the TLS configuration of
+// the server from --debug-auth-token-file, --debug-tls-cert-file, --debug-tls-key-file and
+// --debug-client-ca-file. It must be called before Start.
+func (s *Server) LoadAuth() error {
+	a, tlsConfig, err := loadAuth(*debugAuthTokenFile, *debugTLSCertFile, *debugTLSKeyFile, *debugClientCAFile)
+	if err != nil {
+		return err
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.auth, s.tlsConfig = a, tlsConfig
+	return nil
+}
+
+// AuthEnabled returns true if clients of the debugging endpoints must authenticate
+func (s *Server) AuthEnabled() bool {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.auth != nil
+}
+
+func loadAuth(tokenFile, certFile, keyFile, caFile string) (*auth, *tls.Config, error) {
+	var a *auth
+	if tokenFile != "" {
+		token, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid --debug-auth-token-file: %s", err)
+		}
+		token = bytes.TrimSpace(token)
+		if len(token) == 0 {
+			return nil, nil, fmt.Errorf("invalid --debug-auth-token-file: %s is empty", tokenFile)
+		}
+		a = &auth{token: token}
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, nil, errors.New("--debug-tls-cert-file and --debug-tls-key-file must be set together")
+	}
+	if certFile == "" {
+		if caFile != "" {
+			return nil, nil, errors.New("--debug-client-ca-file requires --debug-tls-cert-file")
+		}
+		return a, nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid --debug-tls-cert-file or --debug-tls-key-file: %s", err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid --debug-client-ca-file: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("invalid --debug-client-ca-file: no certificate in %s", caFile)
+		}
+		// probes and clients authenticating with a token connect without a certificate
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if a == nil {
+			a = &auth{}
+		}
+		a.clientCerts = true
+	}
+	return a, tlsConfig, nil
+}
+
+// authenticated returns true if r has a verified client certificate or the bearer token
+func (a *auth) authenticated(r *http.Request) bool {
+	if a.clientCerts && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	if a.token == nil {
+		return false
+	}
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), a.token) == 1
+}
+
+// wrap returns h answering 401 to the clients that are not authenticated. Without
+// authentication, the debugging endpoints under /debug/ are only served to clients connecting
+// from localhost, and other clients are answered 403.
+func (a *auth) wrap(path string, h http.Handler) http.Handler {
+	debug := strings.HasPrefix(path, "/debug/")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a == nil {
+			if debug && !FromLocalhost(r) {
+				http.Error(w, "debug endpoints are only served to localhost", http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+		if !a.authenticated(r) {
+			if a.token != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="gatekeeper"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authenticatedKey{}, true)))
+	})
+}
+
+// FromLocalhost returns true if the client of r connects from a loopback address
+func FromLocalhost(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Authenticated returns true if the client of r was authenticated, for debugging endpoints
+// that otherwise only serve clients connecting from localhost
+func Authenticated(r *http.Request) bool {
+	authenticated, _ := r.Context().Value(authenticatedKey{}).(bool)
+	return authenticated
+}
@@ -0,0 +1,246 @@
+package health
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// authenticatedHandler answers 200 if the request was marked authenticated
+var authenticatedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if !Authenticated(r) {
+		w.WriteHeader(http.StatusTeapot)
+	}
+})
+
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Could not write %s: %s", name, err)
+	}
+	return path
+}
+
+func TestDebugTokenAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "debug-auth")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	a, tlsConfig, err := loadAuth(writeFile(t, dir, "token", []byte("s3cret\n")), "", "", "")
+	if err != nil {
+		t.Fatalf("loadAuth() err = %s", err)
+	}
+	if tlsConfig != nil {
+		t.Errorf("TLS configured without a certificate")
+	}
+	s := New(":0")
+	s.auth = a
+	s.AddHandler("/debug/decisions", authenticatedHandler)
+
+	tc := []struct {
+		Name          string
+		Path          string
+		Authorization string
+		Expected      int
+	}{
+		{Name: "No token", Path: "/debug/decisions", Expected: http.StatusUnauthorized},
+		{Name: "Wrong token", Path: "/debug/decisions", Authorization: "Bearer guess", Expected: http.StatusUnauthorized},
+		{Name: "Not a bearer token", Path: "/debug/decisions", Authorization: "Basic s3cret", Expected: http.StatusUnauthorized},
+		{Name: "Token", Path: "/debug/decisions", Authorization: "Bearer s3cret", Expected: http.StatusOK},
+		{Name: "Liveness without a token", Path: "/healthz", Expected: http.StatusOK},
+		{Name: "Readiness without a token", Path: "/readyz", Expected: http.StatusOK},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.Path, nil)
+			if tt.Authorization != "" {
+				req.Header.Set("Authorization", tt.Authorization)
+			}
+			s.Handler().ServeHTTP(rec, req)
+			if rec.Code != tt.Expected {
+				t.Errorf("%s = %d; want %d", tt.Path, rec.Code, tt.Expected)
+			}
+		})
+	}
+}
+
+func TestDebugEndpointsWithoutAuth(t *testing.T) {
+	a, tlsConfig, err := loadAuth("", "", "", "")
+	if err != nil {
+		t.Fatalf("loadAuth() err = %s", err)
+	}
+	if a != nil || tlsConfig != nil {
+		t.Fatalf("loadAuth() = %v, %v; want no authentication", a, tlsConfig)
+	}
+	s := New(":0")
+	paths := []string{"/debug/coverage", "/debug/bundle", "/debug/match", "/debug/decisions", "/debug/audit-history", "/debug/audit-progress"}
+	for _, path := range paths {
+		s.AddHandler(path, authenticatedHandler)
+	}
+
+	tc := []struct {
+		Name       string
+		RemoteAddr string
+		Expected   int
+	}{
+		{Name: "IPv4 loopback", RemoteAddr: "127.0.0.1:34567", Expected: http.StatusTeapot},
+		{Name: "IPv6 loopback", RemoteAddr: "[::1]:34567", Expected: http.StatusTeapot},
+		{Name: "Pod network", RemoteAddr: "10.0.0.7:34567", Expected: http.StatusForbidden},
+	}
+	for _, tt := range tc {
+		for _, path := range paths {
+			t.Run(tt.Name+path, func(t *testing.T) {
+				rec := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				req.RemoteAddr = tt.RemoteAddr
+				s.Handler().ServeHTTP(rec, req)
+				if rec.Code != tt.Expected {
+					t.Errorf("%s from %s = %d; want %d", path, tt.RemoteAddr, rec.Code, tt.Expected)
+				}
+			})
+		}
+	}
+	if code := get(t, s, "/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz = %d from a remote client; want %d", code, http.StatusOK)
+	}
+}
+
+// newCert returns a certificate and key signed by parent, or self-signed if parent is nil
+func newCert(t *testing.T, name string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Could not generate key: %s", err)
+	}
+	templ := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		parent, parentKey = templ, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, templ, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Could not create certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Could not parse certificate: %s", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Could not marshal key: %s", err)
+	}
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestDebugClientCertAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "debug-auth")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	ca, caKey, caPEM, _ := newCert(t, "ca", true, nil, nil)
+	_, _, serverPEM, serverKeyPEM := newCert(t, "gatekeeper", false, ca, caKey)
+	_, _, clientPEM, clientKeyPEM := newCert(t, "scraper", false, ca, caKey)
+	_, _, untrustedPEM, untrustedKeyPEM := newCert(t, "intruder", false, nil, nil)
+
+	a, tlsConfig, err := loadAuth("", writeFile(t, dir, "tls.crt", serverPEM), writeFile(t, dir, "tls.key", serverKeyPEM), writeFile(t, dir, "ca.crt", caPEM))
+	if err != nil {
+		t.Fatalf("loadAuth() err = %s", err)
+	}
+	s := New(":0")
+	s.auth = a
+	s.AddHandler("/debug/decisions", authenticatedHandler)
+	srv := httptest.NewUnstartedServer(s.Handler())
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caPEM)
+	client := func(certPEM, keyPEM []byte) *http.Client {
+		config := &tls.Config{RootCAs: pool}
+		if certPEM != nil {
+			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				t.Fatalf("Could not load client certificate: %s", err)
+			}
+			config.Certificates = []tls.Certificate{cert}
+		}
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	}
+
+	tc := []struct {
+		Name     string
+		Client   *http.Client
+		Path     string
+		Expected int
+	}{
+		{Name: "Trusted client certificate", Client: client(clientPEM, clientKeyPEM), Path: "/debug/decisions", Expected: http.StatusOK},
+		{Name: "No client certificate", Client: client(nil, nil), Path: "/debug/decisions", Expected: http.StatusUnauthorized},
+		{Name: "Untrusted client certificate", Client: client(untrustedPEM, untrustedKeyPEM), Path: "/debug/decisions", Expected: http.StatusUnauthorized},
+		{Name: "Liveness without a client certificate", Client: client(nil, nil), Path: "/healthz", Expected: http.StatusOK},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			resp, err := tt.Client.Get(srv.URL + tt.Path)
+			if err != nil {
+				t.Fatalf("%s err = %s", tt.Path, err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.Expected {
+				t.Errorf("%s = %d; want %d", tt.Path, resp.StatusCode, tt.Expected)
+			}
+		})
+	}
+}
+
+func TestLoadAuthErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "debug-auth")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	_, _, certPEM, keyPEM := newCert(t, "gatekeeper", false, nil, nil)
+	cert := writeFile(t, dir, "tls.crt", certPEM)
+	key := writeFile(t, dir, "tls.key", keyPEM)
+	tc := []struct {
+		Name                        string
+		Token, Cert, Key, ClientCAs string
+	}{
+		{Name: "Missing token file", Token: filepath.Join(dir, "missing")},
+		{Name: "Empty token file", Token: writeFile(t, dir, "empty", []byte("\n"))},
+		{Name: "Certificate without a key", Cert: cert},
+		{Name: "Client CAs without a certificate", ClientCAs: cert},
+		{Name: "Client CAs without a certificate in them", Cert: cert, Key: key, ClientCAs: key},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			if _, _, err := loadAuth(tt.Token, tt.Cert, tt.Key, tt.ClientCAs); err == nil {
+				t.Errorf("loadAuth() err = nil; want error")
+			}
+		})
+	}
+}
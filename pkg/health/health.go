@@ -0,0 +1,195 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+var log = logf.Log.WithName("health")
+
+// Checker returns an error if the component it is responsible for is not ready
+type Checker func(*http.Request) error
+
+// Server serves liveness on /healthz and readiness on /readyz.
+//
+// Liveness only reports whether the process is able to answer HTTP requests,
+// so a slow or stuck component (like a long audit) never causes a restart.
+// Readiness runs every registered check and fails if any of them fail.
+type Server struct {
+	addr     string
+	mux      sync.RWMutex
+	checks   map[string]Checker
+	handlers map[string]http.Handler
+	// auth authenticates the clients of the handlers added with AddHandler. It is nil unless
+	// LoadAuth found authentication configured.
+	auth *auth
+	// tlsConfig is set if the endpoints are served over TLS
+	tlsConfig *tls.Config
+}
+
+// New creates a health server listening on addr
+func New(addr string) *Server {
+	return &Server{
+		addr:     addr,
+		checks:   make(map[string]Checker),
+		handlers: make(map[string]http.Handler),
+	}
+}
+
+// AddReadinessCheck registers a check that must pass for the process to be ready
+func (s *Server) AddReadinessCheck(name string, check Checker) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.checks[name] = check
+}
+
+// AddHandler serves h on path alongside the health endpoints, for debugging endpoints that
+// should not be exposed by the webhook server. Unlike the health endpoints, h is only served
+// to authenticated clients once LoadAuth has configured authentication, and otherwise only to
+// clients connecting from localhost if path is under /debug/. It must be called before Start.
+func (s *Server) AddHandler(path string, h http.Handler) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.handlers[path] = h
+}
+
+// Handler returns the http.Handler serving the health endpoints
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.liveness)
+	mux.HandleFunc("/readyz", s.readiness)
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	for path, h := range s.handlers {
+		mux.Handle(path, s.auth.wrap(path, h))
+	}
+	return mux
+}
+
+func (s *Server) liveness(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "ok")
+}
+
+func (s *Server) readiness(w http.ResponseWriter, r *http.Request) {
+	s.mux.RLock()
+	names := make([]string, 0, len(s.checks))
+	for name := range s.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var failures []string
+	for _, name := range names {
+		if err := s.checks[name](r); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+	s.mux.RUnlock()
+
+	if len(failures) > 0 {
+		log.Info("readiness check failed", "failures", failures)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, strings.Join(failures, "\n"))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "ok")
+}
+
+// Start serves the health endpoints until stop is closed
+func (s *Server) Start(stop <-chan struct{}) error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: s.Handler()}
+	s.mux.RLock()
+	if s.tlsConfig != nil {
+		ln = tls.NewListener(ln, s.tlsConfig)
+	}
+	s.mux.RUnlock()
+	go func() {
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Error(err, "error shutting down health server")
+		}
+	}()
+	log.Info("serving health endpoints", "addr", s.addr)
+	if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+var _ manager.Runnable = &CacheSyncCheck{}
+
+// CacheSyncCheck reports ready once the manager's caches have synced. The manager
+// only starts its runnables after the caches sync, so adding the check to the
+// manager is enough to detect when that has happened.
+type CacheSyncCheck struct {
+	synced int32
+}
+
+// Start implements manager.Runnable
+func (c *CacheSyncCheck) Start(stop <-chan struct{}) error {
+	atomic.StoreInt32(&c.synced, 1)
+	<-stop
+	return nil
+}
+
+// Check implements Checker
+func (c *CacheSyncCheck) Check(_ *http.Request) error {
+	if atomic.LoadInt32(&c.synced) == 0 {
+		return errors.New("caches have not synced")
+	}
+	return nil
+}
+
+// OpaCheck returns a Checker that fails if driver cannot evaluate a query of the target
+// library. The query returns a constant, so probes do not cost a review or grow with the
+// number of constraints.
+func OpaCheck(driver drivers.Driver) Checker {
+	return func(r *http.Request) error {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+		resp, err := driver.Query(ctx, fmt.Sprintf(`hooks["%s"].library.ping`, (&target.K8sValidationTarget{}).GetName()), nil)
+		if err != nil {
+			return err
+		}
+		if len(resp.Results) == 0 {
+			return errors.New("target library is not loaded")
+		}
+		return nil
+	}
+}
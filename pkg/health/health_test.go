@@ -0,0 +1,77 @@
+package health
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers/local"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+)
+
+func get(t *testing.T, s *Server, path string) int {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	s.Handler().ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestLivenessIgnoresReadiness(t *testing.T) {
+	s := New(":0")
+	s.AddReadinessCheck("stuck", func(*http.Request) error { return errors.New("stuck") })
+	if code := get(t, s, "/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz = %d; want %d", code, http.StatusOK)
+	}
+	if code := get(t, s, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz = %d; want %d", code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	s := New(":0")
+	if code := get(t, s, "/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz with no checks = %d; want %d", code, http.StatusOK)
+	}
+
+	synced := &CacheSyncCheck{}
+	s.AddReadinessCheck("cache-sync", synced.Check)
+	if code := get(t, s, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz before sync = %d; want %d", code, http.StatusServiceUnavailable)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go synced.Start(stop)
+	deadline := time.Now().Add(5 * time.Second)
+	for synced.Check(nil) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("cache sync check never became ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code := get(t, s, "/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz after sync = %d; want %d", code, http.StatusOK)
+	}
+}
+
+func TestOpaCheck(t *testing.T) {
+	driver := local.New(local.Tracing(false))
+	check := OpaCheck(driver)
+	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	if err := check(req); err == nil {
+		t.Error("OpaCheck() = nil before the target library is loaded; want an error")
+	}
+	backend, err := opa.NewBackend(opa.Driver(driver))
+	if err != nil {
+		t.Fatalf("Could not create backend: %s", err)
+	}
+	if _, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{})); err != nil {
+		t.Fatalf("Could not create client: %s", err)
+	}
+	if err := check(req); err != nil {
+		t.Errorf("OpaCheck() = %s; want nil", err)
+	}
+}
@@ -0,0 +1,325 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package policydir loads the templates and constraints of the manifests of a local directory,
+// such as one populated by a git-sync sidecar, into OPA, and reloads them whenever the
+// directory changes, without reading them from the Kubernetes API.
+package policydir
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
+	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/core/templates"
+	"github.com/open-policy-agent/gatekeeper/pkg/controller/constraint"
+	"github.com/open-policy-agent/gatekeeper/pkg/controller/constrainttemplate"
+	"github.com/open-policy-agent/gatekeeper/pkg/simulate"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	"gopkg.in/fsnotify.v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+var log = logf.Log.WithName("policy-dir")
+
+var dir = flag.String("policy-dir", "", "directory of YAML or JSON manifests of constraint templates and constraints to load into OPA, reloaded whenever its files change. Meant for a directory populated by a git-sync sidecar. Not used if unspecified")
+
+// reloadDelay is how long to wait for further changes to the directory before reloading it, so
+// that a commit touching many files is loaded once
+var reloadDelay = time.Second
+
+// Dir returns the directory set by --policy-dir, or an empty string if policies are not loaded
+// from a directory
+func Dir() string {
+	return *dir
+}
+
+// Loader keeps the templates and constraints loaded into OPA in line with the manifests of a
+// directory
+type Loader struct {
+	dir    string
+	opa    *opa.Client
+	scheme *runtime.Scheme
+	// index lists the loaded constraints by the kinds they select, unless it is nil
+	index *target.ConstraintIndex
+
+	mux sync.Mutex
+	// templates are the loaded templates, by name
+	templates map[string]*templates.ConstraintTemplate
+	// constraints are the loaded constraints, in the form OPA knows them by, by kind and name
+	constraints map[string]*unstructured.Unstructured
+}
+
+// New returns a loader of the manifests of dir into opa, which lists the constraints it loads in
+// index unless it is nil. scheme must know the template APIs.
+func New(dir string, opa *opa.Client, scheme *runtime.Scheme, index *target.ConstraintIndex) *Loader {
+	return &Loader{
+		dir:         dir,
+		opa:         opa,
+		scheme:      scheme,
+		index:       index,
+		templates:   make(map[string]*templates.ConstraintTemplate),
+		constraints: make(map[string]*unstructured.Unstructured),
+	}
+}
+
+// Load reads the manifests of the directory and updates OPA to hold exactly their templates and
+// constraints: new ones are added, changed ones are replaced and the ones whose manifests were
+// removed are removed. Templates and constraints that cannot be loaded are skipped, and the
+// reasons are returned. If a manifest cannot be read, nothing is changed and an error is
+// returned, so a partially written directory does not remove the policies it holds.
+func (l *Loader) Load(ctx context.Context) ([]string, error) {
+	objs, _, err := readDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	var skipped []string
+	var templs []*templates.ConstraintTemplate
+	var cstrs []*unstructured.Unstructured
+	for _, obj := range objs {
+		switch obj.GroupVersionKind().Group {
+		case "templates.gatekeeper.sh":
+			if obj.GetKind() != "ConstraintTemplate" {
+				skipped = append(skipped, fmt.Sprintf("%s %s: not a constraint template", obj.GetKind(), obj.GetName()))
+				continue
+			}
+			templ := &v1beta1.ConstraintTemplate{}
+			versionless := &templates.ConstraintTemplate{}
+			err := l.scheme.Convert(obj, templ, nil)
+			if err == nil {
+				err = l.scheme.Convert(templ, versionless, nil)
+			}
+			if err != nil {
+				skipped = append(skipped, fmt.Sprintf("template %s: %s", obj.GetName(), err))
+				continue
+			}
+			constrainttemplate.RemoveUnsupportedTargets(versionless)
+			templs = append(templs, versionless)
+		case constraint.Group, constraint.NamespacedGroup:
+			enforced, err := constraint.ToClusterConstraint(obj)
+			if err != nil {
+				skipped = append(skipped, fmt.Sprintf("constraint %s %s: %s", obj.GetKind(), obj.GetName(), err))
+				continue
+			}
+			// disabled constraints are not loaded, as for the constraint controller
+			if util.Disabled(obj) {
+				continue
+			}
+			cstrs = append(cstrs, enforced)
+		default:
+			skipped = append(skipped, fmt.Sprintf("%s %s: neither a constraint template nor a constraint", obj.GetKind(), obj.GetName()))
+		}
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	wantTemplates := make(map[string]bool)
+	for _, templ := range templs {
+		wantTemplates[templ.GetName()] = true
+	}
+	wantConstraints := make(map[string]bool)
+	for _, cstr := range cstrs {
+		wantConstraints[constraintKey(cstr)] = true
+	}
+
+	// constraints are removed first, as OPA only knows how to remove the constraints of the
+	// templates it holds
+	for key, cstr := range l.constraints {
+		if wantConstraints[key] {
+			continue
+		}
+		if _, err := l.opa.RemoveConstraint(ctx, cstr); err != nil {
+			log.Error(err, "unable to remove constraint", "kind", cstr.GetKind(), "name", cstr.GetName())
+		}
+		util.ForgetDebugConstraint(cstr)
+		l.index.Remove(cstr)
+		delete(l.constraints, key)
+	}
+	for name, templ := range l.templates {
+		if wantTemplates[name] {
+			continue
+		}
+		if _, err := l.opa.RemoveTemplate(ctx, templ); err != nil {
+			log.Error(err, "unable to remove template", "name", name)
+		}
+		delete(l.templates, name)
+	}
+
+	for _, templ := range templs {
+		if _, err := l.opa.AddTemplate(ctx, templ); err != nil {
+			skipped = append(skipped, fmt.Sprintf("template %s: %s", templ.GetName(), err))
+			continue
+		}
+		l.templates[templ.GetName()] = templ
+	}
+	for _, cstr := range cstrs {
+		key := constraintKey(cstr)
+		l.index.Add(cstr)
+		if _, err := l.opa.AddConstraint(ctx, cstr); err != nil {
+			skipped = append(skipped, fmt.Sprintf("constraint %s %s: %s", cstr.GetKind(), cstr.GetName(), err))
+			// a constraint that no longer loads must not keep its previous version
+			if old, ok := l.constraints[key]; ok {
+				l.opa.RemoveConstraint(ctx, old)
+				util.ForgetDebugConstraint(old)
+				delete(l.constraints, key)
+			}
+			l.index.Remove(cstr)
+			continue
+		}
+		l.index.Trim(cstr)
+		util.RecordDebugConstraint(cstr)
+		l.constraints[key] = cstr
+	}
+	sort.Strings(skipped)
+	return skipped, nil
+}
+
+// Start loads the directory, then reloads it every time its files change, until stop is closed.
+// It implements manager.Runnable.
+func (l *Loader) Start(stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("unable to watch %s: %s", l.dir, err)
+	}
+	defer watcher.Close()
+	watched := make(map[string]bool)
+	reload := func() {
+		// the directories are watched before they are read, so that no change goes unnoticed
+		_, dirs, _ := readDir(l.dir)
+		for _, d := range dirs {
+			if watched[d] {
+				continue
+			}
+			if err := watcher.Add(d); err != nil {
+				log.Error(err, "unable to watch directory", "directory", d)
+				continue
+			}
+			watched[d] = true
+		}
+		skipped, err := l.Load(context.Background())
+		if err != nil {
+			log.Error(err, "unable to load policies", "directory", l.dir)
+			return
+		}
+		for _, s := range skipped {
+			log.Info("skipped policy", "reason", s)
+		}
+		log.Info("policies loaded", "directory", l.dir, "skipped", len(skipped))
+	}
+	reload()
+
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-watcher.Events:
+			if timer == nil {
+				timer = time.After(reloadDelay)
+			}
+		case err := <-watcher.Errors:
+			log.Error(err, "error watching directory", "directory", l.dir)
+		case <-timer:
+			timer = nil
+			// directories that were removed are no longer watched
+			for d := range watched {
+				if _, err := os.Stat(d); err != nil {
+					delete(watched, d)
+				}
+			}
+			reload()
+		}
+	}
+}
+
+func constraintKey(cstr *unstructured.Unstructured) string {
+	return cstr.GetKind() + "/" + cstr.GetName()
+}
+
+// readDir returns the objects of the manifests under dir, in the order of their paths, and the
+// directories to watch for changes to them, which are returned even if a manifest cannot be
+// read. Symbolic links to directories, as swapped in by git-sync, are followed, and files and
+// directories starting with a dot, such as .git, are ignored.
+func readDir(dir string) ([]*unstructured.Unstructured, []string, error) {
+	var objs []*unstructured.Unstructured
+	var dirs []string
+	var walk func(string) error
+	walk = func(d string) error {
+		// the watches are kept on the directories themselves, not their links
+		real, err := filepath.EvalSymlinks(d)
+		if err != nil {
+			return err
+		}
+		dirs = append(dirs, real)
+		infos, err := ioutil.ReadDir(d)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			if strings.HasPrefix(info.Name(), ".") {
+				continue
+			}
+			path := filepath.Join(d, info.Name())
+			if info.Mode()&os.ModeSymlink != 0 {
+				if info, err = os.Stat(path); err != nil {
+					// dangling links are left by git-sync while it swaps worktrees
+					continue
+				}
+			}
+			if info.IsDir() {
+				if err := walk(path); err != nil {
+					return err
+				}
+				continue
+			}
+			switch filepath.Ext(path) {
+			case ".yaml", ".yml", ".json":
+			default:
+				continue
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			fobjs, err := simulate.ReadObjects(f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %s", path, err)
+			}
+			objs = append(objs, fobjs...)
+		}
+		return nil
+	}
+	// git-sync swaps the worktree by replacing the link, in the directory holding it
+	if info, err := os.Lstat(dir); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		dirs = append(dirs, filepath.Dir(dir))
+	}
+	if err := walk(dir); err != nil {
+		return nil, dirs, err
+	}
+	return objs, dirs, nil
+}
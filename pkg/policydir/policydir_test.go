@@ -0,0 +1,270 @@
+package policydir
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers/local"
+	"github.com/open-policy-agent/gatekeeper/pkg/apis"
+	"github.com/open-policy-agent/gatekeeper/pkg/simulate"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const template = `
+apiVersion: templates.gatekeeper.sh/v1beta1
+kind: ConstraintTemplate
+metadata:
+  name: k8sdenyall
+spec:
+  crd:
+    spec:
+      names:
+        kind: K8sDenyAll
+  targets:
+    - target: admission.k8s.gatekeeper.sh
+      rego: |
+        package k8sdenyall
+
+        violation[{"msg": msg}] {
+          msg := sprintf("%s is denied", [input.review.object.metadata.name])
+        }
+`
+
+func constraintFor(name, kind string) string {
+	return `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sDenyAll
+metadata:
+  name: ` + name + `
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["` + kind + `"]
+`
+}
+
+func newClient(t *testing.T) (*opa.Client, *runtime.Scheme) {
+	backend, err := opa.NewBackend(opa.Driver(local.New(local.Tracing(false))))
+	if err != nil {
+		t.Fatalf("unable to set up OPA backend: %s", err)
+	}
+	c, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}))
+	if err != nil {
+		t.Fatalf("unable to set up OPA client: %s", err)
+	}
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Fatalf("unable to set up scheme: %s", err)
+	}
+	return c, scheme
+}
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("unable to write %s: %s", path, err)
+	}
+}
+
+// denials returns the messages denying the creation of a namespace
+func denials(t *testing.T, c *opa.Client) []string {
+	ns := &unstructured.Unstructured{}
+	ns.SetAPIVersion("v1")
+	ns.SetKind("Namespace")
+	ns.SetName("web")
+	r, err := simulate.Review(context.Background(), c, ns)
+	if err != nil {
+		t.Fatalf("unable to review namespace: %s", err)
+	}
+	return r.Denials
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policydir")
+	if err != nil {
+		t.Fatalf("unable to create directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	c, scheme := newClient(t)
+	index := target.NewConstraintIndex()
+	l := New(dir, c, scheme, index)
+	// candidates returns the names of the constraints the index lists for kind
+	candidates := func(kind string) []string {
+		var names []string
+		for _, key := range index.Candidates("", kind) {
+			names = append(names, key.Name)
+		}
+		return names
+	}
+	load := func(t *testing.T) {
+		skipped, err := l.Load(context.Background())
+		if err != nil {
+			t.Fatalf("Load() err = %s", err)
+		}
+		if len(skipped) != 0 {
+			t.Fatalf("Load() skipped %v; want every policy loaded", skipped)
+		}
+	}
+
+	t.Run("add", func(t *testing.T) {
+		writeFile(t, filepath.Join(dir, "template.yaml"), template)
+		if err := os.Mkdir(filepath.Join(dir, "constraints"), 0755); err != nil {
+			t.Fatalf("unable to create directory: %s", err)
+		}
+		writeFile(t, filepath.Join(dir, "constraints", "deny.yaml"), constraintFor("deny-namespaces", "Namespace"))
+		load(t)
+		if got, want := denials(t, c), []string{"[denied by deny-namespaces] web is denied"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("denials = %v; want %v", got, want)
+		}
+		if got, want := candidates("Namespace"), []string{"deny-namespaces"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("candidates = %v; want %v", got, want)
+		}
+	})
+
+	t.Run("modify", func(t *testing.T) {
+		writeFile(t, filepath.Join(dir, "constraints", "deny.yaml"), constraintFor("deny-pods", "Pod"))
+		load(t)
+		if got := denials(t, c); len(got) != 0 {
+			t.Errorf("denials = %v; want none once the constraint only matches pods", got)
+		}
+		if got := candidates("Namespace"); len(got) != 0 {
+			t.Errorf("candidates = %v; want none once the constraint only matches pods", got)
+		}
+		if got, want := candidates("Pod"), []string{"deny-pods"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("candidates = %v; want %v", got, want)
+		}
+	})
+
+	t.Run("unreadable", func(t *testing.T) {
+		writeFile(t, filepath.Join(dir, "constraints", "deny.yaml"), constraintFor("deny-namespaces", "Namespace"))
+		writeFile(t, filepath.Join(dir, "broken.yaml"), "kind: [")
+		if _, err := l.Load(context.Background()); err == nil {
+			t.Fatal("Load() err = nil with an unreadable manifest; want an error")
+		}
+		if got := denials(t, c); len(got) != 0 {
+			t.Errorf("denials = %v; want the policies left as they were", got)
+		}
+		os.Remove(filepath.Join(dir, "broken.yaml"))
+		load(t)
+		if got := denials(t, c); len(got) != 1 {
+			t.Errorf("denials = %v; want one", got)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if err := os.Remove(filepath.Join(dir, "constraints", "deny.yaml")); err != nil {
+			t.Fatalf("unable to remove constraint: %s", err)
+		}
+		load(t)
+		if got := denials(t, c); len(got) != 0 {
+			t.Errorf("denials = %v; want none once the constraint is deleted", got)
+		}
+		if got := candidates("Namespace"); len(got) != 0 {
+			t.Errorf("candidates = %v; want none once the constraint is deleted", got)
+		}
+		if err := os.Remove(filepath.Join(dir, "template.yaml")); err != nil {
+			t.Fatalf("unable to remove template: %s", err)
+		}
+		load(t)
+		if len(l.templates) != 0 || len(l.constraints) != 0 {
+			t.Errorf("loaded %d templates and %d constraints; want none", len(l.templates), len(l.constraints))
+		}
+		dump, err := c.Dump(context.Background())
+		if err != nil {
+			t.Fatalf("unable to dump OPA: %s", err)
+		}
+		if strings.Contains(dump, "k8sdenyall") {
+			t.Error("OPA still holds the deleted template")
+		}
+	})
+
+	t.Run("skipped", func(t *testing.T) {
+		writeFile(t, filepath.Join(dir, "orphan.yaml"), constraintFor("orphan", "Namespace"))
+		skipped, err := l.Load(context.Background())
+		if err != nil {
+			t.Fatalf("Load() err = %s", err)
+		}
+		if len(skipped) != 1 || !strings.HasPrefix(skipped[0], "constraint K8sDenyAll orphan:") {
+			t.Errorf("Load() skipped %v; want the constraint without a template skipped", skipped)
+		}
+	})
+}
+
+func TestStart(t *testing.T) {
+	defer func(d time.Duration) { reloadDelay = d }(reloadDelay)
+	reloadDelay = 10 * time.Millisecond
+	root, err := ioutil.TempDir("", "policydir")
+	if err != nil {
+		t.Fatalf("unable to create directory: %s", err)
+	}
+	defer os.RemoveAll(root)
+	// as laid out by git-sync, the synced worktree is swapped in with a symbolic link
+	for _, rev := range []string{"rev1", "rev2"} {
+		if err := os.Mkdir(filepath.Join(root, rev), 0755); err != nil {
+			t.Fatalf("unable to create directory: %s", err)
+		}
+		writeFile(t, filepath.Join(root, rev, "template.yaml"), template)
+	}
+	writeFile(t, filepath.Join(root, "rev2", "constraint.yaml"), constraintFor("deny-namespaces", "Namespace"))
+	if err := os.Symlink(filepath.Join(root, "rev1"), filepath.Join(root, "current")); err != nil {
+		t.Fatalf("unable to link worktree: %s", err)
+	}
+	dir := filepath.Join(root, "current")
+
+	c, scheme := newClient(t)
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- New(dir, c, scheme, nil).Start(stop) }()
+	defer func() {
+		close(stop)
+		if err := <-done; err != nil {
+			t.Errorf("Start() err = %s", err)
+		}
+	}()
+
+	waitFor := func(t *testing.T, want int) {
+		var got []string
+		for i := 0; i < 200; i++ {
+			if got = denials(t, c); len(got) == want {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("denials = %v; want %d", got, want)
+	}
+	waitFor(t, 0)
+
+	t.Run("added", func(t *testing.T) {
+		writeFile(t, filepath.Join(root, "rev1", "constraint.yaml"), constraintFor("deny-namespaces", "Namespace"))
+		waitFor(t, 1)
+	})
+	t.Run("modified", func(t *testing.T) {
+		writeFile(t, filepath.Join(root, "rev1", "constraint.yaml"), constraintFor("deny-namespaces", "Pod"))
+		waitFor(t, 0)
+	})
+	t.Run("swapped", func(t *testing.T) {
+		link := filepath.Join(root, "current.tmp")
+		if err := os.Symlink(filepath.Join(root, "rev2"), link); err != nil {
+			t.Fatalf("unable to link worktree: %s", err)
+		}
+		if err := os.Rename(link, filepath.Join(root, "current")); err != nil {
+			t.Fatalf("unable to swap worktree: %s", err)
+		}
+		waitFor(t, 1)
+	})
+	t.Run("deleted", func(t *testing.T) {
+		if err := os.Remove(filepath.Join(root, "rev2", "constraint.yaml")); err != nil {
+			t.Fatalf("unable to remove constraint: %s", err)
+		}
+		waitFor(t, 0)
+	})
+}
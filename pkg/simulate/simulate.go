@@ -0,0 +1,238 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package simulate reviews manifests against the constraints of a cluster without
+// submitting them, as the admission webhook would review their creation.
+package simulate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
+	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/core/templates"
+	"github.com/open-policy-agent/gatekeeper/pkg/controller/constraint"
+	"github.com/open-policy-agent/gatekeeper/pkg/controller/constrainttemplate"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Load adds the templates and constraints read with c to opa. Templates and constraints that
+// cannot be loaded are skipped, and the reasons are returned, as the controllers would leave
+// them out of the live policy.
+func Load(ctx context.Context, c client.Reader, scheme *runtime.Scheme, opa *opa.Client) ([]string, error) {
+	templs := &v1beta1.ConstraintTemplateList{}
+	if err := c.List(ctx, nil, templs); err != nil {
+		return nil, fmt.Errorf("unable to list constraint templates: %s", err)
+	}
+	var skipped []string
+	for i := range templs.Items {
+		templ := &templs.Items[i]
+		versionless := &templates.ConstraintTemplate{}
+		if err := scheme.Convert(templ, versionless, nil); err != nil {
+			skipped = append(skipped, fmt.Sprintf("template %s: %s", templ.GetName(), err))
+			continue
+		}
+		constrainttemplate.RemoveUnsupportedTargets(versionless)
+		if _, err := opa.AddTemplate(ctx, versionless); err != nil {
+			skipped = append(skipped, fmt.Sprintf("template %s: %s", templ.GetName(), err))
+			continue
+		}
+		kind := templ.Spec.CRD.Spec.Names.Kind
+		for _, group := range []string{constraint.Group, constraint.NamespacedGroup} {
+			cstrs := &unstructured.UnstructuredList{}
+			cstrs.SetGroupVersionKind(schema.GroupVersionKind{Group: group, Version: "v1beta1", Kind: kind + "List"})
+			if err := c.List(ctx, nil, cstrs); err != nil {
+				// namespaced constraints are only served if enabled
+				if meta.IsNoMatchError(err) {
+					continue
+				}
+				return nil, fmt.Errorf("unable to list %s constraints: %s", kind, err)
+			}
+			for j := range cstrs.Items {
+				cstr := &cstrs.Items[j]
+				// the cluster does not enforce disabled constraints either
+				if util.Disabled(cstr) {
+					continue
+				}
+				enforced, err := constraint.ToClusterConstraint(cstr)
+				if err == nil {
+					_, err = opa.AddConstraint(ctx, enforced)
+				}
+				if err != nil {
+					skipped = append(skipped, fmt.Sprintf("constraint %s %s: %s", kind, name(cstr), err))
+				}
+			}
+		}
+	}
+	return skipped, nil
+}
+
+// ReadObjects decodes the objects of a YAML or JSON manifest, which can hold several documents
+// and lists of objects
+func ReadObjects(r io.Reader) ([]*unstructured.Unstructured, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(r, 4096)
+	var objs []*unstructured.Unstructured
+	for {
+		obj := &unstructured.Unstructured{}
+		err := decoder.Decode(&obj.Object)
+		if err == io.EOF {
+			return objs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode manifest: %s", err)
+		}
+		// empty documents
+		if len(obj.Object) == 0 {
+			continue
+		}
+		if obj.GetKind() == "" {
+			return nil, fmt.Errorf("object %d of the manifest has no kind", len(objs)+1)
+		}
+		if obj.IsList() {
+			list, err := obj.ToList()
+			if err != nil {
+				return nil, fmt.Errorf("unable to decode %s: %s", obj.GetKind(), err)
+			}
+			for i := range list.Items {
+				objs = append(objs, &list.Items[i])
+			}
+			continue
+		}
+		objs = append(objs, obj)
+	}
+}
+
+// Result is the outcome of the simulated admission of an object, or of the review of a
+// document by the generic target
+type Result struct {
+	Object *unstructured.Unstructured
+	// Name identifies a reviewed document that is not a Kubernetes object, in place of Object
+	Name string
+	// Denials are the messages of the deny violations, which keep the object from being admitted
+	Denials []string
+	// Warnings are the messages of the violations of constraints that do not deny admission
+	Warnings []string
+}
+
+// Allowed returns true if the object would be admitted
+func (r *Result) Allowed() bool {
+	return len(r.Denials) == 0
+}
+
+// Review reviews the creation of obj against the constraints loaded into opa
+func Review(ctx context.Context, opa *opa.Client, obj *unstructured.Unstructured) (*Result, error) {
+	raw, err := json.Marshal(obj.Object)
+	if err != nil {
+		return nil, err
+	}
+	gvk := obj.GroupVersionKind()
+	req := &admissionv1beta1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind},
+		Name:      obj.GetName(),
+		Namespace: obj.GetNamespace(),
+		Operation: admissionv1beta1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+	result := &Result{Object: obj}
+	if err := review(ctx, opa, req, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ReviewDocument reviews doc, a document of kind that is not a Kubernetes object, against the
+// constraints of the generic target loaded into opa
+func ReviewDocument(ctx context.Context, opa *opa.Client, kind, name string, doc interface{}) (*Result, error) {
+	result := &Result{Name: name}
+	if err := review(ctx, opa, &target.GenericReview{Kind: kind, Name: name, Object: doc}, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// review reviews input and adds the messages of the violations to result
+func review(ctx context.Context, opa *opa.Client, input interface{}, result *Result) error {
+	resp, err := opa.Review(ctx, input)
+	if err != nil {
+		return err
+	}
+	res := resp.Results()
+	util.ResolveEnforcementActions(res)
+	for _, r := range res {
+		if r.EnforcementAction == "deny" {
+			result.Denials = append(result.Denials, fmt.Sprintf("[denied by %s] %s", r.Constraint.GetName(), r.Msg))
+		} else {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("[%s by %s] %s", r.EnforcementAction, r.Constraint.GetName(), r.Msg))
+		}
+	}
+	sort.Strings(result.Denials)
+	sort.Strings(result.Warnings)
+	return nil
+}
+
+// WriteResults writes whether each object would be admitted, with the messages of the
+// constraints it violates
+func WriteResults(w io.Writer, results []*Result) error {
+	for _, r := range results {
+		outcome := "allowed"
+		if !r.Allowed() {
+			outcome = "denied"
+		}
+		subject := r.Name
+		if r.Object != nil {
+			subject = r.Object.GetKind() + " " + name(r.Object)
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s\n", subject, outcome); err != nil {
+			return err
+		}
+		for _, msg := range append(r.Denials, r.Warnings...) {
+			if _, err := fmt.Fprintf(w, "  %s\n", msg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ExitCode returns the exit code of a process that simulated the admission of the objects of
+// results: 0 if they would all be admitted, or 2 if some would be denied
+func ExitCode(results []*Result) int {
+	for _, r := range results {
+		if !r.Allowed() {
+			return 2
+		}
+	}
+	return 0
+}
+
+func name(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() == "" {
+		return obj.GetName()
+	}
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
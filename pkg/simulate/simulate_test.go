@@ -0,0 +1,382 @@
+package simulate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
+	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers/local"
+	"github.com/open-policy-agent/gatekeeper/pkg/apis"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const fixtures = `
+apiVersion: templates.gatekeeper.sh/v1beta1
+kind: ConstraintTemplate
+metadata:
+  name: k8srequiredlabels
+spec:
+  crd:
+    spec:
+      names:
+        kind: K8sRequiredLabels
+  targets:
+    - target: admission.k8s.gatekeeper.sh
+      rego: |
+        package k8srequiredlabels
+
+        violation[{"msg": msg}] {
+          provided := {label | input.review.object.metadata.labels[label]}
+          required := {label | label := input.parameters.labels[_]}
+          missing := required - provided
+          count(missing) > 0
+          msg := sprintf("you must provide labels: %v", [missing])
+        }
+---
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sRequiredLabels
+metadata:
+  name: deployments-must-have-owner
+spec:
+  match:
+    kinds:
+      - apiGroups: ["apps"]
+        kinds: ["Deployment"]
+  parameters:
+    labels: ["owner"]
+---
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sRequiredLabels
+metadata:
+  name: deployments-should-have-team
+spec:
+  enforcementAction: dryrun
+  match:
+    kinds:
+      - apiGroups: ["apps"]
+        kinds: ["Deployment"]
+  parameters:
+    labels: ["team"]
+`
+
+var _ client.Reader = &fakeReader{}
+
+// fakeReader lists the templates and constraints of a manifest
+type fakeReader struct {
+	scheme *runtime.Scheme
+	objs   []*unstructured.Unstructured
+}
+
+func (r *fakeReader) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	return fmt.Errorf("unexpected get of %s", key)
+}
+
+func (r *fakeReader) List(ctx context.Context, opts *client.ListOptions, list runtime.Object) error {
+	switch l := list.(type) {
+	case *v1beta1.ConstraintTemplateList:
+		for _, obj := range r.objs {
+			if obj.GetKind() != "ConstraintTemplate" {
+				continue
+			}
+			templ := v1beta1.ConstraintTemplate{}
+			if err := r.scheme.Convert(obj, &templ, nil); err != nil {
+				return err
+			}
+			l.Items = append(l.Items, templ)
+		}
+		return nil
+	case *unstructured.UnstructuredList:
+		gvk := l.GroupVersionKind()
+		gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+		found := false
+		for _, obj := range r.objs {
+			if obj.GroupVersionKind() == gvk {
+				l.Items = append(l.Items, *obj)
+				found = true
+			}
+		}
+		if !found {
+			return &meta.NoKindMatchError{GroupKind: gvk.GroupKind()}
+		}
+		return nil
+	}
+	return fmt.Errorf("unexpected list of %T", list)
+}
+
+func newClient(t *testing.T) (*opa.Client, *runtime.Scheme) {
+	backend, err := opa.NewBackend(opa.Driver(local.New(local.Tracing(false))))
+	if err != nil {
+		t.Fatalf("unable to set up OPA backend: %s", err)
+	}
+	c, err := backend.NewClient(opa.Targets(&target.K8sValidationTarget{}, &target.GenericTarget{}))
+	if err != nil {
+		t.Fatalf("unable to set up OPA client: %s", err)
+	}
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Fatalf("unable to set up scheme: %s", err)
+	}
+	return c, scheme
+}
+
+func TestReadObjects(t *testing.T) {
+	manifest := `
+apiVersion: v1
+kind: List
+items:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: first
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: second
+---
+---
+{"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "third"}}
+`
+	objs, err := ReadObjects(strings.NewReader(manifest))
+	if err != nil {
+		t.Fatalf("ReadObjects() err = %s", err)
+	}
+	var names []string
+	for _, obj := range objs {
+		names = append(names, obj.GetKind()+"/"+obj.GetName())
+	}
+	if want := []string{"ConfigMap/first", "ConfigMap/second", "Namespace/third"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ReadObjects() = %v; want %v", names, want)
+	}
+
+	if _, err := ReadObjects(strings.NewReader("metadata:\n  name: kindless\n")); err == nil {
+		t.Error("ReadObjects() err = nil for an object without a kind; want an error")
+	}
+}
+
+func TestReview(t *testing.T) {
+	c, scheme := newClient(t)
+	objs, err := ReadObjects(strings.NewReader(fixtures))
+	if err != nil {
+		t.Fatalf("unable to read fixtures: %s", err)
+	}
+	skipped, err := Load(context.Background(), &fakeReader{scheme: scheme, objs: objs}, scheme, c)
+	if err != nil {
+		t.Fatalf("Load() err = %s", err)
+	}
+	if len(skipped) != 0 {
+		t.Fatalf("Load() skipped %v; want every fixture loaded", skipped)
+	}
+
+	tc := []struct {
+		Name     string
+		Manifest string
+		Output   string
+		ExitCode int
+	}{
+		{
+			Name: "denied",
+			Manifest: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  namespace: default
+`,
+			Output: `Deployment default/nginx: denied
+  [denied by deployments-must-have-owner] you must provide labels: {"owner"}
+  [dryrun by deployments-should-have-team] you must provide labels: {"team"}
+`,
+			ExitCode: 2,
+		},
+		{
+			Name: "allowed",
+			Manifest: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+  namespace: default
+  labels:
+    owner: me
+    team: us
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: web
+`,
+			Output: `Deployment default/nginx: allowed
+Namespace web: allowed
+`,
+			ExitCode: 0,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			objs, err := ReadObjects(strings.NewReader(tt.Manifest))
+			if err != nil {
+				t.Fatalf("ReadObjects() err = %s", err)
+			}
+			var results []*Result
+			for _, obj := range objs {
+				r, err := Review(context.Background(), c, obj)
+				if err != nil {
+					t.Fatalf("Review() err = %s", err)
+				}
+				results = append(results, r)
+			}
+			out := &bytes.Buffer{}
+			if err := WriteResults(out, results); err != nil {
+				t.Fatalf("WriteResults() err = %s", err)
+			}
+			if out.String() != tt.Output {
+				t.Errorf("WriteResults() = %q; want %q", out.String(), tt.Output)
+			}
+			if code := ExitCode(results); code != tt.ExitCode {
+				t.Errorf("ExitCode() = %d; want %d", code, tt.ExitCode)
+			}
+		})
+	}
+}
+
+func TestLoadSkipsInvalidConstraints(t *testing.T) {
+	c, scheme := newClient(t)
+	objs, err := ReadObjects(strings.NewReader(fixtures + `
+---
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sRequiredLabels
+metadata:
+  name: bad-match
+spec:
+  match:
+    kinds: "Deployment"
+`))
+	if err != nil {
+		t.Fatalf("unable to read fixtures: %s", err)
+	}
+	skipped, err := Load(context.Background(), &fakeReader{scheme: scheme, objs: objs}, scheme, c)
+	if err != nil {
+		t.Fatalf("Load() err = %s", err)
+	}
+	if len(skipped) != 1 || !strings.HasPrefix(skipped[0], "constraint K8sRequiredLabels bad-match:") {
+		t.Errorf("Load() skipped %v; want bad-match skipped", skipped)
+	}
+}
+
+const genericFixtures = `
+apiVersion: templates.gatekeeper.sh/v1beta1
+kind: ConstraintTemplate
+metadata:
+  name: publicbuckets
+spec:
+  crd:
+    spec:
+      names:
+        kind: PublicBuckets
+  targets:
+    - target: generic.gatekeeper.sh
+      rego: |
+        package publicbuckets
+
+        violation[{"msg": msg}] {
+          r := input.review.object.resource_changes[_]
+          r.type == "aws_s3_bucket"
+          r.change.after.acl == "public-read"
+          msg := sprintf("bucket %v must not be public", [r.name])
+        }
+---
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: PublicBuckets
+metadata:
+  name: no-public-buckets
+spec:
+  match:
+    kinds: ["terraform-plan"]
+`
+
+func TestReviewDocument(t *testing.T) {
+	c, scheme := newClient(t)
+	objs, err := ReadObjects(strings.NewReader(fixtures + "\n---\n" + genericFixtures))
+	if err != nil {
+		t.Fatalf("unable to read fixtures: %s", err)
+	}
+	skipped, err := Load(context.Background(), &fakeReader{scheme: scheme, objs: objs}, scheme, c)
+	if err != nil {
+		t.Fatalf("Load() err = %s", err)
+	}
+	if len(skipped) != 0 {
+		t.Fatalf("Load() skipped %v; want every fixture loaded", skipped)
+	}
+
+	plan := map[string]interface{}{
+		"resource_changes": []interface{}{
+			map[string]interface{}{"type": "aws_s3_bucket", "name": "logs", "change": map[string]interface{}{"after": map[string]interface{}{"acl": "private"}}},
+			map[string]interface{}{"type": "aws_s3_bucket", "name": "site", "change": map[string]interface{}{"after": map[string]interface{}{"acl": "public-read"}}},
+		},
+	}
+	tc := []struct {
+		Name   string
+		Kind   string
+		Output string
+	}{
+		{
+			Name:   "matched kind",
+			Kind:   "terraform-plan",
+			Output: "plan.json: denied\n  [denied by no-public-buckets] bucket site must not be public\n",
+		},
+		{
+			Name:   "other kind",
+			Kind:   "ci-artifact",
+			Output: "plan.json: allowed\n",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			r, err := ReviewDocument(context.Background(), c, tt.Kind, "plan.json", plan)
+			if err != nil {
+				t.Fatalf("ReviewDocument() err = %s", err)
+			}
+			out := &bytes.Buffer{}
+			if err := WriteResults(out, []*Result{r}); err != nil {
+				t.Fatalf("WriteResults() err = %s", err)
+			}
+			if out.String() != tt.Output {
+				t.Errorf("WriteResults() = %q; want %q", out.String(), tt.Output)
+			}
+		})
+	}
+
+	// Kubernetes objects are only reviewed against the constraints of the admission target
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("apps/v1")
+	obj.SetKind("Deployment")
+	obj.SetName("nginx")
+	obj.SetNamespace("default")
+	r, err := Review(context.Background(), c, obj)
+	if err != nil {
+		t.Fatalf("Review() err = %s", err)
+	}
+	for _, msg := range append(r.Denials, r.Warnings...) {
+		if strings.Contains(msg, "no-public-buckets") {
+			t.Errorf("Review() reported %q for a Kubernetes object", msg)
+		}
+	}
+	resp, err := c.Audit(context.Background())
+	if err != nil {
+		t.Fatalf("Audit() err = %s", err)
+	}
+	for _, res := range resp.Results() {
+		if res.Constraint.GetName() == "no-public-buckets" {
+			t.Errorf("Audit() reported a violation of %s; want none for the generic target", res.Constraint.GetName())
+		}
+	}
+}
@@ -0,0 +1,188 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package snapshot exports the policy state of a cluster, its Config, constraint templates and
+// constraints, as a single manifest from which it can be rebuilt on another cluster.
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/ghodss/yaml"
+	"github.com/open-policy-agent/gatekeeper/pkg/controller/constraint"
+	"github.com/open-policy-agent/gatekeeper/pkg/controller/constrainttemplate"
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	configGVK   = schema.GroupVersionKind{Group: "config.gatekeeper.sh", Version: "v1alpha1", Kind: "Config"}
+	templateGVK = schema.GroupVersionKind{Group: "templates.gatekeeper.sh", Version: "v1beta1", Kind: "ConstraintTemplate"}
+	libGVK      = schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+)
+
+// serverFields are the fields of metadata set by the API server, which must not be applied to
+// another cluster
+var serverFields = []string{"uid", "resourceVersion", "generation", "creationTimestamp", "deletionTimestamp", "deletionGracePeriodSeconds", "selfLink", "managedFields", "finalizers", "ownerReferences"}
+
+// lastAppliedAnnotation is kept by kubectl apply, and is set again when the snapshot is applied
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// Export reads the policy state of a cluster with c: its Config, the shared libs referenced by
+// its templates, its templates and its constraints, in the order they must be applied to rebuild
+// it, as each depends on the ones before. The objects are returned as they were applied, without
+// their status or the metadata set by the API server.
+func Export(ctx context.Context, c client.Reader) ([]*unstructured.Unstructured, error) {
+	configs, err := list(ctx, c, configGVK)
+	if err != nil {
+		return nil, err
+	}
+	templs, err := list(ctx, c, templateGVK)
+	if err != nil {
+		return nil, err
+	}
+
+	var libs []*unstructured.Unstructured
+	seen := make(map[string]bool)
+	for _, templ := range templs {
+		for _, name := range constrainttemplate.SharedLibNames(templ) {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			lib := &unstructured.Unstructured{}
+			lib.SetGroupVersionKind(libGVK)
+			err := c.Get(ctx, client.ObjectKey{Namespace: util.GetNamespace(), Name: name}, lib)
+			// the template cannot be loaded without it either, as its status reports
+			if errors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("unable to get shared lib %s: %s", name, err)
+			}
+			libs = append(libs, lib)
+		}
+	}
+	sortByName(libs)
+
+	var cstrs []*unstructured.Unstructured
+	for _, templ := range templs {
+		kind, _, err := unstructured.NestedString(templ.Object, "spec", "crd", "spec", "names", "kind")
+		if err != nil || kind == "" {
+			continue
+		}
+		for _, group := range []string{constraint.Group, constraint.NamespacedGroup} {
+			items, err := list(ctx, c, schema.GroupVersionKind{Group: group, Version: "v1beta1", Kind: kind})
+			// namespaced constraints are only served if enabled, and the CRD of a template
+			// that failed to load is not created
+			if meta.IsNoMatchError(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			cstrs = append(cstrs, items...)
+		}
+	}
+
+	var objs []*unstructured.Unstructured
+	for _, group := range [][]*unstructured.Unstructured{configs, libs, templs, cstrs} {
+		for _, obj := range group {
+			objs = append(objs, clean(obj))
+		}
+	}
+	return objs, nil
+}
+
+// list returns the objects of gvk read with c, sorted by namespace and name
+func list(ctx context.Context, c client.Reader, gvk schema.GroupVersionKind) ([]*unstructured.Unstructured, error) {
+	l := &unstructured.UnstructuredList{}
+	l.SetGroupVersionKind(schema.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind + "List"})
+	if err := c.List(ctx, nil, l); err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("unable to list %s: %s", gvk.Kind, err)
+	}
+	objs := make([]*unstructured.Unstructured, 0, len(l.Items))
+	for i := range l.Items {
+		obj := &l.Items[i]
+		// lists of unstructured objects do not always set the kind of their items
+		obj.SetGroupVersionKind(gvk)
+		objs = append(objs, obj)
+	}
+	sortByName(objs)
+	return objs, nil
+}
+
+func sortByName(objs []*unstructured.Unstructured) {
+	sort.SliceStable(objs, func(i, j int) bool {
+		if objs[i].GetNamespace() != objs[j].GetNamespace() {
+			return objs[i].GetNamespace() < objs[j].GetNamespace()
+		}
+		return objs[i].GetName() < objs[j].GetName()
+	})
+}
+
+// clean returns a copy of obj without its status and the metadata set by the API server
+func clean(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	obj = obj.DeepCopy()
+	unstructured.RemoveNestedField(obj.Object, "status")
+	for _, field := range serverFields {
+		unstructured.RemoveNestedField(obj.Object, "metadata", field)
+	}
+	if annotations := obj.GetAnnotations(); annotations != nil {
+		delete(annotations, lastAppliedAnnotation)
+		if len(annotations) == 0 {
+			annotations = nil
+		}
+		obj.SetAnnotations(annotations)
+	}
+	return obj
+}
+
+// Write writes objs to w as a single v1 List, formatted as yaml or json. The List keeps the
+// order of objs, in which kubectl applies them.
+func Write(w io.Writer, objs []*unstructured.Unstructured, format string) error {
+	items := make([]interface{}, 0, len(objs))
+	for _, obj := range objs {
+		items = append(items, obj.Object)
+	}
+	list := map[string]interface{}{"apiVersion": "v1", "kind": "List", "items": items}
+	var out []byte
+	var err error
+	switch format {
+	case "yaml":
+		out, err = yaml.Marshal(list)
+	case "json":
+		out, err = json.MarshalIndent(list, "", "  ")
+		out = append(out, '\n')
+	default:
+		return fmt.Errorf("unknown output format %q, must be yaml or json", format)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to encode snapshot: %s", err)
+	}
+	_, err = w.Write(out)
+	return err
+}
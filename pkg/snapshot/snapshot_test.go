@@ -0,0 +1,283 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/gatekeeper/pkg/simulate"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// cluster is the policy state of a cluster, as read from the API server
+const cluster = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sRequiredLabels
+metadata:
+  name: ns-must-have-owner
+  uid: 3c1e1b8e-0000-0000-0000-000000000002
+  resourceVersion: "1201"
+  generation: 1
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: '{}'
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Namespace"]
+  parameters:
+    labels: ["owner"]
+status:
+  byPod:
+    - id: gatekeeper-controller-manager-0
+      enforced: true
+---
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sRequiredLabels
+metadata:
+  name: deployments-must-have-owner
+  resourceVersion: "1202"
+spec:
+  enforcementAction: dryrun
+  match:
+    kinds:
+      - apiGroups: ["apps"]
+        kinds: ["Deployment"]
+  parameters:
+    labels: ["owner"]
+---
+apiVersion: templates.gatekeeper.sh/v1beta1
+kind: ConstraintTemplate
+metadata:
+  name: k8srequiredlabels
+  uid: 3c1e1b8e-0000-0000-0000-000000000001
+  resourceVersion: "1100"
+  creationTimestamp: "2020-01-01T00:00:00Z"
+  finalizers: ["constrainttemplate.finalizers.gatekeeper.sh"]
+  annotations:
+    templates.gatekeeper.sh/libs: naming
+spec:
+  crd:
+    spec:
+      names:
+        kind: K8sRequiredLabels
+  targets:
+    - target: admission.k8s.gatekeeper.sh
+      rego: |
+        package k8srequiredlabels
+
+        violation[{"msg": msg}] {
+          provided := {label | input.review.object.metadata.labels[label]}
+          required := {label | label := input.parameters.labels[_]}
+          missing := required - provided
+          count(missing) > 0
+          msg := sprintf("you must provide labels: %v", [missing])
+        }
+status:
+  created: true
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: naming
+  namespace: gatekeeper-system
+  resourceVersion: "900"
+data:
+  naming.rego: |
+    package lib.naming
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: unrelated
+  namespace: gatekeeper-system
+data:
+  key: value
+---
+apiVersion: config.gatekeeper.sh/v1alpha1
+kind: Config
+metadata:
+  name: config
+  namespace: gatekeeper-system
+  resourceVersion: "800"
+  selfLink: /apis/config.gatekeeper.sh/v1alpha1/namespaces/gatekeeper-system/configs/config
+spec:
+  sync:
+    syncOnly:
+      - group: ""
+        version: v1
+        kind: Namespace
+status:
+  byPod:
+    - id: gatekeeper-controller-manager-0
+`
+
+// want is the snapshot of cluster
+const want = `
+apiVersion: config.gatekeeper.sh/v1alpha1
+kind: Config
+metadata:
+  name: config
+  namespace: gatekeeper-system
+spec:
+  sync:
+    syncOnly:
+      - group: ""
+        version: v1
+        kind: Namespace
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: naming
+  namespace: gatekeeper-system
+data:
+  naming.rego: |
+    package lib.naming
+---
+apiVersion: templates.gatekeeper.sh/v1beta1
+kind: ConstraintTemplate
+metadata:
+  name: k8srequiredlabels
+  annotations:
+    templates.gatekeeper.sh/libs: naming
+spec:
+  crd:
+    spec:
+      names:
+        kind: K8sRequiredLabels
+  targets:
+    - target: admission.k8s.gatekeeper.sh
+      rego: |
+        package k8srequiredlabels
+
+        violation[{"msg": msg}] {
+          provided := {label | input.review.object.metadata.labels[label]}
+          required := {label | label := input.parameters.labels[_]}
+          missing := required - provided
+          count(missing) > 0
+          msg := sprintf("you must provide labels: %v", [missing])
+        }
+---
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sRequiredLabels
+metadata:
+  name: deployments-must-have-owner
+spec:
+  enforcementAction: dryrun
+  match:
+    kinds:
+      - apiGroups: ["apps"]
+        kinds: ["Deployment"]
+  parameters:
+    labels: ["owner"]
+---
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sRequiredLabels
+metadata:
+  name: ns-must-have-owner
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Namespace"]
+  parameters:
+    labels: ["owner"]
+`
+
+var _ client.Reader = &fakeReader{}
+
+// fakeReader reads the objects of a manifest, and serves no namespaced constraints
+type fakeReader struct {
+	objs []*unstructured.Unstructured
+}
+
+func (r *fakeReader) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("unexpected get of %T", obj)
+	}
+	for _, o := range r.objs {
+		if o.GroupVersionKind() == u.GroupVersionKind() && o.GetNamespace() == key.Namespace && o.GetName() == key.Name {
+			o.DeepCopyInto(u)
+			return nil
+		}
+	}
+	return errors.NewNotFound(schema.GroupResource{Resource: u.GetKind()}, key.Name)
+}
+
+func (r *fakeReader) List(ctx context.Context, opts *client.ListOptions, list runtime.Object) error {
+	l, ok := list.(*unstructured.UnstructuredList)
+	if !ok {
+		return fmt.Errorf("unexpected list of %T", list)
+	}
+	gvk := l.GroupVersionKind()
+	gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+	if gvk.Group == "namespaced.constraints.gatekeeper.sh" {
+		return &meta.NoKindMatchError{GroupKind: gvk.GroupKind()}
+	}
+	for _, obj := range r.objs {
+		if obj.GroupVersionKind() == gvk {
+			l.Items = append(l.Items, *obj.DeepCopy())
+		}
+	}
+	return nil
+}
+
+func readManifest(t *testing.T, manifest string) []*unstructured.Unstructured {
+	objs, err := simulate.ReadObjects(strings.NewReader(manifest))
+	if err != nil {
+		t.Fatalf("unable to read manifest: %s", err)
+	}
+	return objs
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	wantObjs := readManifest(t, want)
+	for _, format := range []string{"yaml", "json"} {
+		t.Run(format, func(t *testing.T) {
+			objs, err := Export(context.Background(), &fakeReader{objs: readManifest(t, cluster)})
+			if err != nil {
+				t.Fatalf("Export() err = %s", err)
+			}
+			out := &bytes.Buffer{}
+			if err := Write(out, objs, format); err != nil {
+				t.Fatalf("Write() err = %s", err)
+			}
+			got, err := simulate.ReadObjects(bytes.NewReader(out.Bytes()))
+			if err != nil {
+				t.Fatalf("unable to read snapshot: %s\n%s", err, out)
+			}
+			if !reflect.DeepEqual(got, wantObjs) {
+				t.Errorf("snapshot = %s; want\n%s", out, want)
+			}
+
+			// the policy state rebuilt from the snapshot has the same snapshot
+			restored, err := Export(context.Background(), &fakeReader{objs: got})
+			if err != nil {
+				t.Fatalf("Export() of the restored state err = %s", err)
+			}
+			again := &bytes.Buffer{}
+			if err := Write(again, restored, format); err != nil {
+				t.Fatalf("Write() err = %s", err)
+			}
+			if again.String() != out.String() {
+				t.Errorf("snapshot of the restored state = %s; want %s", again, out)
+			}
+		})
+	}
+}
+
+func TestWriteUnknownFormat(t *testing.T) {
+	if err := Write(&bytes.Buffer{}, nil, "xml"); err == nil {
+		t.Errorf("Write() with format xml did not fail")
+	}
+}
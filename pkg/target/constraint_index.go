@@ -0,0 +1,200 @@
+package target
+
+import (
+	"sort"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ConstraintKey names a constraint by its kind and name
+type ConstraintKey struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// ConstraintIndex records the kinds that the kind selectors of the loaded constraints select,
+// so that the constraints a review can match are found without evaluating all of them. It errs
+// on the side of listing too many constraints: a constraint is added before it is loaded into
+// OPA, trimmed to the kinds it selects once it is loaded, and removed once it has been removed
+// from OPA. Evaluating a constraint that does not match costs a lookup. The methods of a nil
+// index do nothing.
+type ConstraintIndex struct {
+	mux sync.RWMutex
+	// byKind[group][kind] holds the constraints selecting group and kind, either of which may
+	// be "*"
+	byKind map[string]map[string]map[ConstraintKey]bool
+	// selected holds the group and kind pairs each constraint is listed under
+	selected map[ConstraintKey][][2]string
+}
+
+// NewConstraintIndex returns an empty index
+func NewConstraintIndex() *ConstraintIndex {
+	return &ConstraintIndex{
+		byKind:   make(map[string]map[string]map[ConstraintKey]bool),
+		selected: make(map[ConstraintKey][][2]string),
+	}
+}
+
+// Add lists u, a constraint in the form OPA knows it by, under the kinds it selects, in addition
+// to those a previous version of it selected, so that it stays listed under both while OPA is
+// updated
+func (idx *ConstraintIndex) Add(u *unstructured.Unstructured) {
+	if idx == nil {
+		return
+	}
+	key := ConstraintKey{Kind: u.GetKind(), Name: u.GetName()}
+	selected := selectedKinds(u)
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+	idx.list(key, selected)
+}
+
+// Trim stops listing u, a constraint in the form OPA knows it by, under the kinds only a
+// previous version of it selected, once u has been loaded into OPA
+func (idx *ConstraintIndex) Trim(u *unstructured.Unstructured) {
+	if idx == nil {
+		return
+	}
+	key := ConstraintKey{Kind: u.GetKind(), Name: u.GetName()}
+	selected := selectedKinds(u)
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+	idx.remove(key)
+	idx.list(key, selected)
+}
+
+// list lists the constraint with key under the group and kind pairs of selected. idx.mux must
+// be held.
+func (idx *ConstraintIndex) list(key ConstraintKey, selected [][2]string) {
+	for _, gk := range selected {
+		kinds, ok := idx.byKind[gk[0]]
+		if !ok {
+			kinds = make(map[string]map[ConstraintKey]bool)
+			idx.byKind[gk[0]] = kinds
+		}
+		keys, ok := kinds[gk[1]]
+		if !ok {
+			keys = make(map[ConstraintKey]bool)
+			kinds[gk[1]] = keys
+		}
+		if !keys[key] {
+			keys[key] = true
+			idx.selected[key] = append(idx.selected[key], gk)
+		}
+	}
+}
+
+// Remove stops listing u, a constraint in the form OPA knows it by
+func (idx *ConstraintIndex) Remove(u *unstructured.Unstructured) {
+	if idx == nil {
+		return
+	}
+	idx.mux.Lock()
+	defer idx.mux.Unlock()
+	idx.remove(ConstraintKey{Kind: u.GetKind(), Name: u.GetName()})
+}
+
+// remove stops listing the constraint with key. idx.mux must be held.
+func (idx *ConstraintIndex) remove(key ConstraintKey) {
+	for _, gk := range idx.selected[key] {
+		kinds := idx.byKind[gk[0]]
+		delete(kinds[gk[1]], key)
+		if len(kinds[gk[1]]) == 0 {
+			delete(kinds, gk[1])
+		}
+		if len(kinds) == 0 {
+			delete(idx.byKind, gk[0])
+		}
+	}
+	delete(idx.selected, key)
+}
+
+// selectedKinds returns the group and kind pairs selected by the kind selectors of u. A
+// constraint with a namespace selector is selected for every kind, as it rejects reviews in
+// namespaces that are not cached whatever their kind, and so is one whose selectors cannot be
+// read.
+func selectedKinds(u *unstructured.Unstructured) [][2]string {
+	every := [][2]string{{"*", "*"}}
+	if _, found, _ := unstructured.NestedFieldNoCopy(u.Object, "spec", "match", "namespaceSelector"); found {
+		return every
+	}
+	selectors, found, err := unstructured.NestedFieldNoCopy(u.Object, "spec", "match", "kinds")
+	if err != nil || !found {
+		return every
+	}
+	list, ok := selectors.([]interface{})
+	if !ok {
+		return every
+	}
+	var selected [][2]string
+	for _, s := range list {
+		selector, ok := s.(map[string]interface{})
+		if !ok {
+			return every
+		}
+		groups, ok := stringSlice(selector["apiGroups"])
+		if !ok {
+			return every
+		}
+		kinds, ok := stringSlice(selector["kinds"])
+		if !ok {
+			return every
+		}
+		for _, g := range groups {
+			for _, k := range kinds {
+				selected = append(selected, [2]string{g, k})
+			}
+		}
+	}
+	return selected
+}
+
+// stringSlice converts a list of strings decoded from JSON. A missing list selects nothing.
+func stringSlice(v interface{}) ([]string, bool) {
+	if v == nil {
+		return nil, true
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	out := make([]string, 0, len(list))
+	for _, s := range list {
+		str, ok := s.(string)
+		if !ok {
+			return nil, false
+		}
+		out = append(out, str)
+	}
+	return out, true
+}
+
+// Candidates returns the constraints that a review of an object of group and kind can match, in
+// order of kind and name. It may list constraints that do not match.
+func (idx *ConstraintIndex) Candidates(group, kind string) []ConstraintKey {
+	if idx == nil {
+		return nil
+	}
+	idx.mux.RLock()
+	seen := make(map[ConstraintKey]bool)
+	for _, g := range []string{group, "*"} {
+		for _, k := range []string{kind, "*"} {
+			for key := range idx.byKind[g][k] {
+				seen[key] = true
+			}
+		}
+	}
+	idx.mux.RUnlock()
+	out := make([]ConstraintKey, 0, len(seen))
+	for key := range seen {
+		out = append(out, key)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Kind != out[j].Kind {
+			return out[i].Kind < out[j].Kind
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
@@ -0,0 +1,63 @@
+package target
+
+import (
+	"net/url"
+	"path"
+
+	"github.com/pkg/errors"
+)
+
+// ExemptionsScope holds exemption data
+const ExemptionsScope = "exemptions"
+
+// Exemption selects the resources that templates consulting exemption data allow regardless
+// of their rules. A resource is selected when it matches every non-empty list. Templates read
+// it as data.inventory.exemptions[<ConfigMap name>][<key>], where every list is present,
+// empty if it selects any value.
+type Exemption struct {
+	// Constraints are the constraint kinds, such as K8sRequiredLabels, that the resources are
+	// exempted from
+	Constraints []string `json:"constraints"`
+	// Kinds are the kinds of the resources as Kind.group, or Kind for the core group, e.g.
+	// Deployment.apps or ConfigMap
+	Kinds []string `json:"kinds"`
+	// Namespaces are the namespaces of the resources. Cluster-scoped resources have none.
+	Namespaces []string `json:"namespaces"`
+	// Names are the names of the resources
+	Names []string `json:"names"`
+	// Reason documents why the resources are exempted
+	Reason string `json:"reason"`
+}
+
+// ExemptionData is the set of exemptions loaded from a ConfigMap, keyed by the ConfigMap's
+// keys
+type ExemptionData struct {
+	Name       string
+	Exemptions map[string]Exemption
+}
+
+func processExemptionData(data *ExemptionData) (bool, string, interface{}, error) {
+	if data.Name == "" {
+		return true, "", nil, errors.New("exemption data has no name")
+	}
+	exemptions := make(map[string]interface{}, len(data.Exemptions))
+	for key, e := range data.Exemptions {
+		exemptions[key] = map[string]interface{}{
+			"constraints": stringList(e.Constraints),
+			"kinds":       stringList(e.Kinds),
+			"namespaces":  stringList(e.Namespaces),
+			"names":       stringList(e.Names),
+			"reason":      e.Reason,
+		}
+	}
+	return true, path.Join(ExemptionsScope, url.PathEscape(data.Name)), exemptions, nil
+}
+
+// stringList returns list as a JSON array, empty rather than null if list is nil
+func stringList(list []string) []interface{} {
+	out := make([]interface{}, 0, len(list))
+	for _, s := range list {
+		out = append(out, s)
+	}
+	return out
+}
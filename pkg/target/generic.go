@@ -0,0 +1,140 @@
+package target
+
+import (
+	"text/template"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/types"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var _ client.TargetHandler = &GenericTarget{}
+
+// GenericTarget reviews arbitrary JSON documents, such as Terraform plans or CI artifacts, that
+// are not Kubernetes objects. Templates select it with the generic.gatekeeper.sh target, and
+// their constraints can match the kinds of documents they apply to. As there is no inventory
+// of documents, audit finds no violations of its constraints.
+type GenericTarget struct{}
+
+// GenericReview is a document reviewed by GenericTarget. Its rego sees it as
+// input.review.object, along with input.review.kind and input.review.name.
+type GenericReview struct {
+	// Kind is the kind of document, such as terraform-plan, that constraints can match with
+	// spec.match.kinds
+	Kind string
+	// Name identifies the document, such as by the name of its file
+	Name   string
+	Object interface{}
+}
+
+func (h *GenericTarget) GetName() string {
+	return "generic.gatekeeper.sh"
+}
+
+var genericLibTempl = template.Must(template.New("library").Parse(genericTemplSrc))
+
+func (h *GenericTarget) Library() *template.Template {
+	return genericLibTempl
+}
+
+// ProcessData handles no data, as generic documents are only reviewed one at a time
+func (h *GenericTarget) ProcessData(obj interface{}) (bool, string, interface{}, error) {
+	return false, "", nil, nil
+}
+
+func (h *GenericTarget) HandleReview(obj interface{}) (bool, interface{}, error) {
+	switch data := obj.(type) {
+	case GenericReview:
+		return true, genericReview(&data), nil
+	case *GenericReview:
+		return true, genericReview(data), nil
+	}
+	return false, nil, nil
+}
+
+func genericReview(r *GenericReview) map[string]interface{} {
+	return map[string]interface{}{
+		"kind":   r.Kind,
+		"name":   r.Name,
+		"object": r.Object,
+	}
+}
+
+// HandleViolation sets the reviewed document as the resource of result
+func (h *GenericTarget) HandleViolation(result *types.Result) error {
+	if review, ok := result.Review.(map[string]interface{}); ok {
+		result.Resource = review["object"]
+	}
+	return nil
+}
+
+func (h *GenericTarget) MatchSchema() apiextensions.JSONSchemaProps {
+	return apiextensions.JSONSchemaProps{
+		Properties: map[string]apiextensions.JSONSchemaProps{
+			// kinds restricts matching to documents of the listed kinds
+			"kinds": apiextensions.JSONSchemaProps{
+				Type: "array",
+				Items: &apiextensions.JSONSchemaPropsOrArray{
+					Schema: &apiextensions.JSONSchemaProps{Type: "string"}}},
+		},
+	}
+}
+
+func (h *GenericTarget) ValidateConstraint(u *unstructured.Unstructured) error {
+	return nil
+}
+
+const genericTemplSrc = `package target
+
+# the match of a constraint can always be evaluated
+autoreject_review[rejection] {
+  false
+  rejection := {}
+}
+
+matching_constraints[constraint] {
+  constraint := {{.ConstraintsRoot}}[_][_]
+  spec := get_default(constraint, "spec", {})
+  match := get_default(spec, "match", {})
+  matches_kinds(match)
+}
+
+# there is no inventory of documents to audit
+matching_reviews_and_constraints[[review, constraint]] {
+  false
+  review := {}
+  constraint := {}
+}
+
+matches_kinds(match) {
+  not has_field(match, "kinds")
+}
+
+matches_kinds(match) {
+  match.kinds[_] == input.review.kind
+}
+
+has_field(object, field) = true {
+  object[field]
+}
+
+has_field(object, field) = true {
+  object[field] == false
+}
+
+has_field(object, field) = false {
+  not object[field]
+  not object[field] == false
+}
+
+get_default(object, field, _default) = output {
+  has_field(object, field)
+  output = object[field]
+}
+
+get_default(object, field, _default) = output {
+  has_field(object, field) == false
+  output = _default
+}
+`
@@ -0,0 +1,60 @@
+package target
+
+import (
+	"encoding/json"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// DefaultNamespace is the namespace the API server assigns to namespaced objects created
+// without one
+const DefaultNamespace = "default"
+
+// EffectiveNamespace returns the namespace constraints match req against. Requests for
+// namespaced kinds may arrive without a namespace, which the API server defaults later, so
+// the namespace in the metadata of the object or old object is used instead, or
+// DefaultNamespace if neither has one and namespaced is set. Requests for cluster-scoped
+// kinds have no namespace.
+func EffectiveNamespace(req *admissionv1beta1.AdmissionRequest, namespaced bool) string {
+	if req.Namespace != "" {
+		return req.Namespace
+	}
+	for _, obj := range []runtime.RawExtension{req.Object, req.OldObject} {
+		if ns := metadataNamespace(obj); ns != "" {
+			return ns
+		}
+	}
+	if namespaced {
+		return DefaultNamespace
+	}
+	return ""
+}
+
+// metadataNamespace returns the namespace in the metadata of obj, if any
+func metadataNamespace(obj runtime.RawExtension) string {
+	if obj.Raw == nil {
+		return ""
+	}
+	var partial struct {
+		Metadata struct {
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(obj.Raw, &partial); err != nil {
+		return ""
+	}
+	return partial.Metadata.Namespace
+}
+
+// handleRequest returns req with the namespace set to the one found in the metadata of its
+// objects if it has none
+func handleRequest(req *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionRequest {
+	ns := EffectiveNamespace(req, false)
+	if ns == req.Namespace {
+		return req
+	}
+	handled := *req
+	handled.Namespace = ns
+	return &handled
+}
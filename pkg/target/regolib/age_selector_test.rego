@@ -0,0 +1,63 @@
+package target
+
+test_undefined_created_after_matches_old {
+	matches_created_after({}) with input as old_review
+}
+
+test_created_after_new {
+	matches_created_after({"createdAfter": "2020-01-01T00:00:00Z"}) with input as new_review
+}
+
+test_created_after_old_negative {
+	not matches_created_after({"createdAfter": "2020-01-01T00:00:00Z"}) with input as old_review
+}
+
+test_created_after_same_time_negative {
+	not matches_created_after({"createdAfter": "2000-01-01T00:00:00Z"}) with input as old_review
+}
+
+test_created_after_offset {
+	matches_created_after({"createdAfter": "2000-01-01T00:00:00+01:00"}) with input as old_review
+}
+
+test_created_after_uncreated {
+	matches_created_after({"createdAfter": "2020-01-01T00:00:00Z"}) with input as uncreated_review
+}
+
+test_undefined_max_age_matches_old {
+	matches_max_age({}) with input as old_review
+}
+
+test_max_age_new {
+	matches_max_age({"maxAge": "720h"}) with input as new_review
+}
+
+test_max_age_old_negative {
+	not matches_max_age({"maxAge": "720h"}) with input as old_review
+}
+
+test_max_age_uncreated {
+	matches_max_age({"maxAge": "1s"}) with input as uncreated_review
+}
+
+old_review = {
+  "review": {
+    "kind": {"group": "", "kind": "PersistentVolumeClaim"},
+    "object": {"metadata": {"name": "old", "creationTimestamp": "2000-01-01T00:00:00Z"}}
+  }
+}
+
+# created in the future, so younger than any maxAge whenever the tests run
+new_review = {
+  "review": {
+    "kind": {"group": "", "kind": "PersistentVolumeClaim"},
+    "object": {"metadata": {"name": "new", "creationTimestamp": "2100-01-01T00:00:00Z"}}
+  }
+}
+
+uncreated_review = {
+  "review": {
+    "kind": {"group": "", "kind": "PersistentVolumeClaim"},
+    "object": {"metadata": {"name": "uncreated", "creationTimestamp": null}}
+  }
+}
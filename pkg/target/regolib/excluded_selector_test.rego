@@ -0,0 +1,110 @@
+package target
+
+test_empty_excluded_selector_excludes_nothing {
+  not excluded_by_selector({}, {"a": "b"})
+}
+
+test_empty_excluded_selector_excludes_nothing_unlabeled {
+  not excluded_by_selector({"matchLabels": {}, "matchExpressions": []}, {})
+}
+
+test_excluded_selector_match_labels {
+  excluded_by_selector({"matchLabels": {"a": "b"}}, {"a": "b", "c": "d"})
+}
+
+test_excluded_selector_match_labels_negative {
+  not excluded_by_selector({"matchLabels": {"a": "b"}}, {"a": "c"})
+}
+
+test_excluded_selector_match_expressions {
+  excluded_by_selector({"matchExpressions": [{"key": "a", "operator": "Exists"}]}, {"a": "b"})
+}
+
+test_excluded_selector_match_expressions_negative {
+  not excluded_by_selector({"matchExpressions": [{"key": "a", "operator": "Exists"}]}, {"c": "d"})
+}
+
+test_excluded_label_selector_skips_matching_pods {
+  not matching_constraints[excluding_system_pods] with input as review_labeled({"tier": "system"}, {}) with data["{{.ConstraintsRoot}}"] as constraints_excluding
+}
+
+test_excluded_label_selector_keeps_other_pods {
+  matching_constraints[excluding_system_pods] with input as review_labeled({"tier": "web"}, {}) with data["{{.ConstraintsRoot}}"] as constraints_excluding
+}
+
+test_excluded_label_selector_keeps_unlabeled_pods {
+  matching_constraints[excluding_system_pods] with input as review_labeled({}, {}) with data["{{.ConstraintsRoot}}"] as constraints_excluding
+}
+
+test_label_and_excluded_label_selectors {
+  matching_constraints[team_excluding_system_pods] with input as review_labeled({"team": "a", "tier": "web"}, {}) with data["{{.ConstraintsRoot}}"] as constraints_team_excluding
+}
+
+test_label_and_excluded_label_selectors_excluded {
+  not matching_constraints[team_excluding_system_pods] with input as review_labeled({"team": "a", "tier": "system"}, {}) with data["{{.ConstraintsRoot}}"] as constraints_team_excluding
+}
+
+test_label_and_excluded_label_selectors_unselected {
+  not matching_constraints[team_excluding_system_pods] with input as review_labeled({"tier": "web"}, {}) with data["{{.ConstraintsRoot}}"] as constraints_team_excluding
+}
+
+test_annotation_selector_matches {
+  matching_constraints[annotated] with input as review_labeled({}, {"policy.example.com/scan": "true"}) with data["{{.ConstraintsRoot}}"] as constraints_annotated
+}
+
+test_annotation_selector_negative {
+  not matching_constraints[annotated] with input as review_labeled({}, {}) with data["{{.ConstraintsRoot}}"] as constraints_annotated
+}
+
+test_excluded_annotation_selector_skips_matching_pods {
+  not matching_constraints[excluding_annotated] with input as review_labeled({}, {"policy.example.com/exempt": "true"}) with data["{{.ConstraintsRoot}}"] as constraints_excluding_annotated
+}
+
+test_excluded_annotation_selector_keeps_other_pods {
+  matching_constraints[excluding_annotated] with input as review_labeled({}, {"policy.example.com/exempt": "false"}) with data["{{.ConstraintsRoot}}"] as constraints_excluding_annotated
+}
+
+review_labeled(labels, annotations) = output {
+  output = {
+    "review": {
+      "kind": {"group": "", "kind": "Pod"},
+      "namespace": "default",
+      "object": {"metadata": {"name": "pod", "labels": labels, "annotations": annotations}}
+    }
+  }
+}
+
+excluding_system_pods = {
+  "kind": "K8sDenyAll",
+  "metadata": {"name": "excluding-system-pods"},
+  "spec": {"match": {"excludedLabelSelector": {"matchLabels": {"tier": "system"}}}}
+}
+
+constraints_excluding = {"K8sDenyAll": {"excluding-system-pods": excluding_system_pods}}
+
+team_excluding_system_pods = {
+  "kind": "K8sDenyAll",
+  "metadata": {"name": "team-excluding-system-pods"},
+  "spec": {"match": {
+    "labelSelector": {"matchLabels": {"team": "a"}},
+    "excludedLabelSelector": {"matchLabels": {"tier": "system"}}
+  }}
+}
+
+constraints_team_excluding = {"K8sDenyAll": {"team-excluding-system-pods": team_excluding_system_pods}}
+
+annotated = {
+  "kind": "K8sDenyAll",
+  "metadata": {"name": "annotated"},
+  "spec": {"match": {"annotationSelector": {"matchExpressions": [{"key": "policy.example.com/scan", "operator": "In", "values": ["true"]}]}}}
+}
+
+constraints_annotated = {"K8sDenyAll": {"annotated": annotated}}
+
+excluding_annotated = {
+  "kind": "K8sDenyAll",
+  "metadata": {"name": "excluding-annotated"},
+  "spec": {"match": {"excludedAnnotationSelector": {"matchLabels": {"policy.example.com/exempt": "true"}}}}
+}
+
+constraints_excluding_annotated = {"K8sDenyAll": {"excluding-annotated": excluding_annotated}}
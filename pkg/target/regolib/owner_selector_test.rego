@@ -0,0 +1,107 @@
+package target
+
+test_undefined_owner_selector_matches_unowned {
+	matches_owner_kinds({}) with input as unowned_pod_review
+}
+
+test_undefined_owner_selector_matches_owned {
+	matches_owner_kinds({}) with input as job_pod_review
+}
+
+test_owner_selector_unowned_negative {
+	not matches_owner_kinds({
+    "ownerKinds": [
+      {"apiGroups": ["*"], "kinds": ["*"]},
+    ]
+	}) with input as unowned_pod_review
+}
+
+test_wildcard_owner_selector {
+	matches_owner_kinds({
+    "ownerKinds": [
+      {"apiGroups": ["*"], "kinds": ["*"]},
+    ]
+	}) with input as job_pod_review
+}
+
+test_constant_owner_selector {
+	matches_owner_kinds({
+    "ownerKinds": [
+      {"apiGroups": ["batch"], "kinds": ["Job"]},
+    ]
+	}) with input as job_pod_review
+}
+
+test_constant_owner_selector_negative {
+	not matches_owner_kinds({
+    "ownerKinds": [
+      {"apiGroups": ["batch"], "kinds": ["Job"]},
+    ]
+	}) with input as replicaset_pod_review
+}
+
+test_owner_selector_group_negative {
+	not matches_owner_kinds({
+    "ownerKinds": [
+      {"apiGroups": [""], "kinds": ["Job"]},
+    ]
+	}) with input as job_pod_review
+}
+
+test_owner_selector_core_group {
+	matches_owner_kinds({
+    "ownerKinds": [
+      {"apiGroups": [""], "kinds": ["Node"]},
+    ]
+	}) with input as node_pod_review
+}
+
+test_multiple_owner_selectors {
+	matches_owner_kinds({
+    "ownerKinds": [
+      {"apiGroups": ["batch"], "kinds": ["Job"]},
+      {"apiGroups": ["apps"], "kinds": ["ReplicaSet"]},
+    ]
+	}) with input as replicaset_pod_review
+}
+
+test_empty_owner_selectors_negative {
+	not matches_owner_kinds({"ownerKinds": []}) with input as job_pod_review
+}
+
+unowned_pod_review = {
+  "review": {
+    "kind": {"group": "", "kind": "Pod"},
+    "object": {"metadata": {"name": "standalone"}}
+  }
+}
+
+job_pod_review = {
+  "review": {
+    "kind": {"group": "", "kind": "Pod"},
+    "object": {"metadata": {
+      "name": "job-pod",
+      "ownerReferences": [{"apiVersion": "batch/v1", "kind": "Job", "name": "job"}]
+    }}
+  }
+}
+
+replicaset_pod_review = {
+  "review": {
+    "kind": {"group": "", "kind": "Pod"},
+    "object": {"metadata": {
+      "name": "replica-pod",
+      "ownerReferences": [{"apiVersion": "apps/v1", "kind": "ReplicaSet", "name": "rs"}]
+    }}
+  }
+}
+
+node_pod_review = {
+  "review": {
+    "kind": {"group": "", "kind": "Pod"},
+    "object": {"metadata": {
+      "name": "static-pod",
+      "ownerReferences": [{"apiVersion": "v1", "kind": "Node", "name": "node"}]
+    }}
+  }
+}
@@ -0,0 +1,134 @@
+package target
+
+test_undefined_required_paths_matches {
+	matches_required_paths({}) with input as plain_pod_review
+}
+
+test_required_path_present {
+	matches_required_paths({"requiredPaths": ["spec.initContainers"]}) with input as init_pod_review
+}
+
+test_required_path_missing_negative {
+	not matches_required_paths({"requiredPaths": ["spec.initContainers"]}) with input as plain_pod_review
+}
+
+test_required_path_empty_list_negative {
+	not matches_required_paths({"requiredPaths": ["spec.initContainers"]}) with input as empty_init_pod_review
+}
+
+test_required_path_null_negative {
+	not matches_required_paths({"requiredPaths": ["spec.initContainers"]}) with input as null_init_pod_review
+}
+
+test_required_path_into_list {
+	matches_required_paths({"requiredPaths": ["spec.containers.image"]}) with input as plain_pod_review
+}
+
+test_required_path_into_list_any_element {
+	matches_required_paths({"requiredPaths": ["spec.containers.ports"]}) with input as plain_pod_review
+}
+
+test_required_path_into_list_negative {
+	not matches_required_paths({"requiredPaths": ["spec.containers.securityContext"]}) with input as plain_pod_review
+}
+
+test_required_path_deep_into_list {
+	matches_required_paths({"requiredPaths": ["spec.template.spec.containers.securityContext.privileged"]}) with input as deployment_review
+}
+
+test_required_path_false_value {
+	path_present({"spec": {"hostNetwork": false}}, "spec.hostNetwork")
+}
+
+test_required_path_through_lists {
+	path_present({"spec": {"rules": [{"http": {"paths": [{"path": "/"}, {"backend": {}}]}}]}}, "spec.rules.http.paths.path")
+}
+
+test_required_path_through_lists_negative {
+	not path_present({"spec": {"rules": [{"http": {"paths": [{"path": "/"}, {"backend": {}}]}}]}}, "spec.rules.http.paths.backend")
+}
+
+test_required_path_too_long_negative {
+	not path_present({"a": {"b": {"c": {"d": {"e": {"f": {"g": {"h": {"i": {"j": {"k": 1}}}}}}}}}}}, "a.b.c.d.e.f.g.h.i.j.k")
+}
+
+test_required_paths_all_present {
+	matches_required_paths({"requiredPaths": ["spec.containers", "spec.initContainers.image"]}) with input as init_pod_review
+}
+
+test_required_paths_one_missing_negative {
+	not matches_required_paths({"requiredPaths": ["spec.containers", "spec.volumes"]}) with input as init_pod_review
+}
+
+test_required_path_prefix_negative {
+	not matches_required_paths({"requiredPaths": ["spec.init"]}) with input as init_pod_review
+}
+
+test_required_path_constraint {
+	c := {"kind": "K8sInitImages", "metadata": {"name": "init-images"}, "spec": {"match": {"requiredPaths": ["spec.initContainers"]}}}
+	matching_constraints[c] with input as init_pod_review with data["{{.ConstraintsRoot}}"] as {"K8sInitImages": {"init-images": c}}
+}
+
+test_required_path_constraint_negative {
+	c := {"kind": "K8sInitImages", "metadata": {"name": "init-images"}, "spec": {"match": {"requiredPaths": ["spec.initContainers"]}}}
+	not matching_constraints[c] with input as plain_pod_review with data["{{.ConstraintsRoot}}"] as {"K8sInitImages": {"init-images": c}}
+}
+
+plain_pod_review = {
+  "review": {
+    "kind": {"group": "", "kind": "Pod"},
+    "object": {
+      "metadata": {"name": "plain"},
+      "spec": {"containers": [
+        {"name": "app", "image": "nginx"},
+        {"name": "sidecar", "image": "envoy", "ports": [{"containerPort": 8080}]}
+      ]}
+    }
+  }
+}
+
+init_pod_review = {
+  "review": {
+    "kind": {"group": "", "kind": "Pod"},
+    "object": {
+      "metadata": {"name": "init"},
+      "spec": {
+        "initContainers": [{"name": "setup", "image": "busybox"}],
+        "containers": [{"name": "app", "image": "nginx"}]
+      }
+    }
+  }
+}
+
+empty_init_pod_review = {
+  "review": {
+    "kind": {"group": "", "kind": "Pod"},
+    "object": {
+      "metadata": {"name": "empty-init"},
+      "spec": {"initContainers": [], "containers": [{"name": "app", "image": "nginx"}]}
+    }
+  }
+}
+
+null_init_pod_review = {
+  "review": {
+    "kind": {"group": "", "kind": "Pod"},
+    "object": {
+      "metadata": {"name": "null-init"},
+      "spec": {"initContainers": null, "containers": [{"name": "app", "image": "nginx"}]}
+    }
+  }
+}
+
+deployment_review = {
+  "review": {
+    "kind": {"group": "apps", "kind": "Deployment"},
+    "object": {
+      "metadata": {"name": "privileged"},
+      "spec": {"template": {"spec": {"containers": [
+        {"name": "app", "image": "nginx"},
+        {"name": "agent", "image": "agent", "securityContext": {"privileged": true}}
+      ]}}}
+    }
+  }
+}
@@ -6,6 +6,13 @@ package target
 
 autoreject_review[rejection] {
   constraint := data["{{.ConstraintsRoot}}"][_][_]
+  rejection := autoreject(constraint)
+}
+
+# autoreject is the rejection of the review under input by constraint, if it rejects it
+# regardless of its rules. Like constraint_matches, it is given the constraint so that a single
+# constraint can be checked without evaluating every other one.
+autoreject(constraint) = rejection {
   spec := get_default(constraint, "spec", {})
   match := get_default(spec, "match", {})
   has_field(match, "namespaceSelector")
@@ -19,20 +26,39 @@ autoreject_review[rejection] {
 
 matching_constraints[constraint] {
   constraint := data["{{.ConstraintsRoot}}"][_][_]
+  constraint_matches(constraint)
+}
+
+# constraint_matches is true if constraint matches the review under input
+constraint_matches(constraint) {
   spec := get_default(constraint, "spec", {})
   match := get_default(spec, "match", {})
 
   any_kind_selector_matches(match)
 
+  matches_subresource(match)
+
   matches_namespaces(match)
 
   matches_nsselector(match)
 
-  label_selector := get_default(match, "labelSelector", {})
+  matches_owner_kinds(match)
+
+  matches_created_after(match)
+
+  matches_max_age(match)
+
+  matches_required_paths(match)
+
   obj := get_default(input.review, "object", {})
   metadata := get_default(obj, "metadata", {})
   labels := get_default(metadata, "labels", {})
-  matches_label_selector(label_selector, labels)
+  matches_label_selector(get_default(match, "labelSelector", {}), labels)
+  not excluded_by_selector(get_default(match, "excludedLabelSelector", {}), labels)
+
+  annotations := get_default(metadata, "annotations", {})
+  matches_label_selector(get_default(match, "annotationSelector", {}), annotations)
+  not excluded_by_selector(get_default(match, "excludedAnnotationSelector", {}), annotations)
 }
 
 # Namespace-scoped objects
@@ -50,6 +76,175 @@ matching_reviews_and_constraints[[review, constraint]] {
   matching_constraints[constraint] with input as {"review": review}
 }
 
+# Number of cached objects matched by each constraint, used for audit statistics
+matched_count[result] {
+  constraint := data["{{.ConstraintsRoot}}"][_][_]
+  matches := {review | matching_reviews_and_constraints[[review, constraint]]}
+  result := {
+    "constraint": constraint,
+    "metadata": {"matched": count(matches)},
+  }
+}
+
+# Violations in the cached state of the kinds selected by input, used to audit kinds on their
+# own schedule. input.kinds lists {"group": group, "kind": kind} objects, which are the only
+# kinds audited, or the kinds not audited if input.exclude is true.
+kind_audit[response] {
+  audited_reviews[review]
+  matching_constraints[constraint] with input as {"review": review}
+  spec := get_default(constraint, "spec", {})
+  inp := {
+    "review": review,
+    "parameters": get_default(spec, "parameters", {}),
+  }
+  inv := review_inventory
+  # the library is not given the root of the templates, which is keyed by target name
+  data.templates["admission.k8s.gatekeeper.sh"][constraint.kind].violation[r] with input as inp with data.inventory as inv
+  response := {
+    "msg": r.msg,
+    "metadata": {"details": get_default(r, "details", {})},
+    "constraint": constraint,
+    "review": review,
+    "enforcementAction": get_default(spec, "enforcementAction", "deny"),
+  }
+}
+
+# Namespace-scoped objects of the kinds selected by input
+audited_reviews[review] {
+  obj = data["{{.DataRoot}}"].namespace[namespace][api_version][kind][name]
+  r := make_review(obj, api_version, kind, name)
+  audited_kind(r.kind)
+  review := add_field(r, "namespace", namespace)
+}
+
+# Cluster-scoped objects of the kinds selected by input
+audited_reviews[review] {
+  obj = data["{{.DataRoot}}"].cluster[api_version][kind][name]
+  review := make_review(obj, api_version, kind, name)
+  audited_kind(review.kind)
+}
+
+audited_kind(gvk) {
+  not input.exclude
+  listed_kind(gvk)
+}
+
+audited_kind(gvk) {
+  input.exclude
+  not listed_kind(gvk)
+}
+
+listed_kind(gvk) {
+  input.kinds[_] == {"group": gvk.group, "kind": gvk.kind}
+}
+
+# Kinds of the cached objects, used to audit one kind at a time
+cached_kinds[result] {
+  data["{{.DataRoot}}"].namespace[_][api_version][kind]
+  [group, _] := make_group_version(api_version)
+  result := {"metadata": {"group": group, "kind": kind}}
+}
+
+cached_kinds[result] {
+  data["{{.DataRoot}}"].cluster[api_version][kind]
+  [group, _] := make_group_version(api_version)
+  result := {"metadata": {"group": group, "kind": kind}}
+}
+
+# A constant result, used by the readiness check to confirm the library is loaded and evaluates
+# without the cost of a review
+ping[result] {
+  result := {"msg": "pong"}
+}
+
+# Every loaded constraint, used to compare the kinds constraints match with the webhook rules
+loaded_constraints[result] {
+  constraint := data["{{.ConstraintsRoot}}"][_][_]
+  result := {"constraint": constraint}
+}
+
+# Constraints matching the review under input, used for coverage metrics
+matched_constraints[result] {
+  matching_constraints[constraint]
+  result := {"constraint": constraint}
+}
+
+# Constraints the review under input is evaluated against, used to review constraints one
+# at a time
+reviewed_constraints[result] {
+  matching_constraints[constraint]
+  result := {"constraint": constraint}
+}
+
+reviewed_constraints[result] {
+  autoreject_review[rejection]
+  result := {"constraint": rejection.constraint}
+}
+
+# Constraints the review under input is evaluated against, out of those listed by
+# input.candidates
+reviewed_candidates[result] {
+  candidate := input.candidates[_]
+  constraint := data["{{.ConstraintsRoot}}"][candidate.kind][candidate.name]
+  constraint_matches(constraint)
+  result := {"constraint": constraint}
+}
+
+reviewed_candidates[result] {
+  candidate := input.candidates[_]
+  constraint := data["{{.ConstraintsRoot}}"][candidate.kind][candidate.name]
+  autoreject(constraint)
+  result := {"constraint": constraint}
+}
+
+# Violations of the review under input of the constraint named by input.constraint
+constraint_violation[response] {
+  constraint := data["{{.ConstraintsRoot}}"][input.constraint.kind][input.constraint.name]
+  rejection := autoreject(constraint)
+  response := {
+    "msg": rejection.msg,
+    "metadata": {"details": rejection.details},
+    "constraint": constraint,
+    "review": input.review,
+    "enforcementAction": get_default(get_default(constraint, "spec", {}), "enforcementAction", "deny"),
+  }
+}
+
+constraint_violation[response] {
+  constraint := data["{{.ConstraintsRoot}}"][input.constraint.kind][input.constraint.name]
+  constraint_matches(constraint)
+  spec := get_default(constraint, "spec", {})
+  inp := {
+    "review": input.review,
+    "parameters": get_default(spec, "parameters", {}),
+  }
+  inv := review_inventory
+  # the library is not given the root of the templates, which is keyed by target name
+  data.templates["admission.k8s.gatekeeper.sh"][constraint.kind].violation[r] with input as inp with data.inventory as inv
+  response := {
+    "msg": r.msg,
+    "metadata": {"details": get_default(r, "details", {})},
+    "constraint": constraint,
+    "review": input.review,
+    "enforcementAction": get_default(spec, "enforcementAction", "deny"),
+  }
+}
+
+# Violations of the review under input of the constraints listed by input.candidates, each
+# named by kind and name. Constraints that are not listed are not evaluated.
+candidate_violation[response] {
+  candidate := input.candidates[_]
+  constraint_violation[response] with input.constraint as candidate
+}
+
+review_inventory = inv {
+  inv := data["{{.DataRoot}}"]
+}
+
+review_inventory = {} {
+  not data["{{.DataRoot}}"]
+}
+
 make_review(obj, api_version, kind, name) = review {
   [group, version] := make_group_version(api_version)
   review := {
@@ -140,6 +335,146 @@ kind_matches(ks) {
   ks.kinds[_] == input.review.kind.kind
 }
 
+########################
+# Owner Selector Logic #
+########################
+
+# ownerKinds lists kind selectors, like kinds, that match the kinds of the object's owners. If
+# defined, an object is only matched if one of its ownerReferences matches a selector.
+matches_owner_kinds(match) {
+  not has_field(match, "ownerKinds")
+}
+
+matches_owner_kinds(match) {
+  obj := get_default(input.review, "object", {})
+  metadata := get_default(obj, "metadata", {})
+  owners := get_default(metadata, "ownerReferences", [])
+  owner := owners[_]
+  [group, _] := make_group_version(owner.apiVersion)
+  ks := match.ownerKinds[_]
+  owner_kind_selector_matches(ks, group, owner.kind)
+}
+
+owner_kind_selector_matches(ks, group, kind) {
+  owner_group_matches(ks, group)
+  owner_kind_matches(ks, kind)
+}
+
+owner_group_matches(ks, group) {
+  ks.apiGroups[_] == "*"
+}
+
+owner_group_matches(ks, group) {
+  ks.apiGroups[_] == group
+}
+
+owner_kind_matches(ks, kind) {
+  ks.kinds[_] == "*"
+}
+
+owner_kind_matches(ks, kind) {
+  ks.kinds[_] == kind
+}
+
+################
+# Age Matching #
+################
+
+# createdAfter is an RFC3339 timestamp. If defined, an object is only matched if it was
+# created after it.
+matches_created_after(match) {
+  not has_field(match, "createdAfter")
+}
+
+# objects without a creationTimestamp have not been created yet
+matches_created_after(match) {
+  not creation_ns
+}
+
+matches_created_after(match) {
+  creation_ns > time.parse_rfc3339_ns(match.createdAfter)
+}
+
+# maxAge is a duration such as 720h. If defined, an object is only matched if it was created
+# at most that long ago.
+matches_max_age(match) {
+  not has_field(match, "maxAge")
+}
+
+matches_max_age(match) {
+  not creation_ns
+}
+
+matches_max_age(match) {
+  time.now_ns() - creation_ns <= time.parse_duration_ns(match.maxAge)
+}
+
+creation_ns = ns {
+  obj := get_default(input.review, "object", {})
+  metadata := get_default(obj, "metadata", {})
+  timestamp := get_default(metadata, "creationTimestamp", null)
+  is_string(timestamp)
+  ns := time.parse_rfc3339_ns(timestamp)
+}
+
+#######################
+# Field Path Matching #
+#######################
+
+# requiredPaths lists dot-separated field paths, such as spec.initContainers. If defined, an
+# object is only matched if every path is present in it: each field along the path exists and
+# the value of the last one is not null, an empty list or an empty object. Lists along a path
+# are looked into element by element, so spec.containers.ports is present if any container
+# has ports.
+matches_required_paths(match) {
+  not has_field(match, "requiredPaths")
+}
+
+matches_required_paths(match) {
+  obj := get_default(input.review, "object", {})
+  missing := {path | path := match.requiredPaths[_]; not path_present(obj, path)}
+  count(missing) == 0
+}
+
+path_present(obj, path) {
+  values := path_values(obj, split(path, "."))
+  value := values[_]
+  not value == null
+  not value == []
+  not value == {}
+}
+
+# path_values returns the values at fields under obj, looking into the elements of the lists
+# met on the way. Rules cannot be recursive, so the descent is unrolled and paths of more than
+# 10 fields select nothing.
+path_values(obj, fields) = v10 {
+  count(fields) <= 10
+  v1 := path_step({obj}, fields, 0)
+  v2 := path_step(v1, fields, 1)
+  v3 := path_step(v2, fields, 2)
+  v4 := path_step(v3, fields, 3)
+  v5 := path_step(v4, fields, 4)
+  v6 := path_step(v5, fields, 5)
+  v7 := path_step(v6, fields, 6)
+  v8 := path_step(v7, fields, 7)
+  v9 := path_step(v8, fields, 8)
+  v10 := path_step(v9, fields, 9)
+}
+
+# path_step descends from values into field i of fields, in the values that are objects or in
+# the elements of those that are lists. values are returned as they are past the last field.
+path_step(values, fields, i) = values {
+  i >= count(fields)
+}
+
+path_step(values, fields, i) = children {
+  i < count(fields)
+  field := fields[i]
+  in_objects := {c | v := values[_]; is_object(v); c := v[field]}
+  in_lists := {c | v := values[_]; is_array(v); e := v[_]; is_object(e); c := e[field]}
+  children := in_objects | in_lists
+}
+
 ########################
 # Label Selector Logic #
 ########################
@@ -192,6 +527,40 @@ matches_label_selector(selector, labels) {
   any(mismatches) == false
 }
 
+# Checks to see if an excluded selector, such as excludedLabelSelector, excludes an object with
+# a given set of labels. Objects are excluded if the selector matches them, but unlike an empty
+# selector, which matches everything, an empty excluded selector excludes nothing.
+excluded_by_selector(selector, labels) {
+  count(get_default(selector, "matchLabels", {})) + count(get_default(selector, "matchExpressions", [])) > 0
+  matches_label_selector(selector, labels)
+}
+
+#####################
+# Subresource Logic #
+#####################
+
+# Requests for a subresource, such as the scale of a Deployment, only match the constraints that
+# list the subresource in subresources, or "*". CONNECT requests are always for a subresource, such
+# as pods/exec, and are matched regardless.
+matches_subresource(match) {
+  not subresource_request
+}
+
+matches_subresource(match) {
+  subresource_request
+  match.subresources[_] == "*"
+}
+
+matches_subresource(match) {
+  subresource_request
+  match.subresources[_] == input.review.subResource
+}
+
+subresource_request {
+  get_default(input.review, "subResource", "") != ""
+  input.review.operation != "CONNECT"
+}
+
 ############################
 # Namespace Selector Logic #
 ############################
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"net/url"
 	"path"
+	"strings"
 	"text/template"
+	"time"
 
 	"github.com/open-policy-agent/frameworks/constraint/pkg/client"
 	"github.com/open-policy-agent/frameworks/constraint/pkg/types"
@@ -32,10 +34,41 @@ func (h *K8sValidationTarget) Library() *template.Template {
 	return libTempl
 }
 
-type WipeData struct{}
+// WipeData removes cached data: all of it, or only that under Scope if it is set
+type WipeData struct {
+	// Scope is one of ClusterScope or NamespaceScope
+	Scope string
+}
+
+const (
+	// ClusterScope holds cached cluster-scoped resources
+	ClusterScope = "cluster"
+	// NamespaceScope holds cached namespace-scoped resources
+	NamespaceScope = "namespace"
+	// ExternalScope holds external data documents
+	ExternalScope = "external"
+)
+
+func processWipeData(data *WipeData) (bool, string, interface{}, error) {
+	return true, data.Scope, nil, nil
+}
 
-func processWipeData() (bool, string, interface{}, error) {
-	return true, "", nil, nil
+// ExternalData is a set of JSON documents loaded from outside the cluster. Templates read
+// them as data.inventory.external[Name][key].
+type ExternalData struct {
+	Name      string
+	Documents map[string]interface{}
+}
+
+func processExternalData(data *ExternalData) (bool, string, interface{}, error) {
+	if data.Name == "" {
+		return true, "", nil, errors.New("external data has no name")
+	}
+	documents := data.Documents
+	if documents == nil {
+		documents = map[string]interface{}{}
+	}
+	return true, path.Join(ExternalScope, url.PathEscape(data.Name)), documents, nil
 }
 
 func processUnstructured(o *unstructured.Unstructured) (bool, string, interface{}, error) {
@@ -49,9 +82,9 @@ func processUnstructured(o *unstructured.Unstructured) (bool, string, interface{
 	}
 
 	if o.GetNamespace() == "" {
-		return true, path.Join("cluster", url.PathEscape(gvk.GroupVersion().String()), gvk.Kind, o.GetName()), o.Object, nil
+		return true, path.Join(ClusterScope, url.PathEscape(gvk.GroupVersion().String()), gvk.Kind, o.GetName()), o.Object, nil
 	}
-	return true, path.Join("namespace", o.GetNamespace(), url.PathEscape(gvk.GroupVersion().String()), gvk.Kind, o.GetName()), o.Object, nil
+	return true, path.Join(NamespaceScope, o.GetNamespace(), url.PathEscape(gvk.GroupVersion().String()), gvk.Kind, o.GetName()), o.Object, nil
 }
 
 func (h *K8sValidationTarget) ProcessData(obj interface{}) (bool, string, interface{}, error) {
@@ -60,8 +93,18 @@ func (h *K8sValidationTarget) ProcessData(obj interface{}) (bool, string, interf
 		return processUnstructured(&data)
 	case *unstructured.Unstructured:
 		return processUnstructured(data)
-	case WipeData, *WipeData:
-		return processWipeData()
+	case WipeData:
+		return processWipeData(&data)
+	case *WipeData:
+		return processWipeData(data)
+	case ExternalData:
+		return processExternalData(&data)
+	case *ExternalData:
+		return processExternalData(data)
+	case ExemptionData:
+		return processExemptionData(&data)
+	case *ExemptionData:
+		return processExemptionData(data)
 	default:
 		return false, "", nil, nil
 	}
@@ -70,9 +113,9 @@ func (h *K8sValidationTarget) ProcessData(obj interface{}) (bool, string, interf
 func (h *K8sValidationTarget) HandleReview(obj interface{}) (bool, interface{}, error) {
 	switch data := obj.(type) {
 	case admissionv1beta1.AdmissionRequest:
-		return true, data, nil
+		return true, handleRequest(&data), nil
 	case *admissionv1beta1.AdmissionRequest:
-		return true, data, nil
+		return true, handleRequest(data), nil
 	}
 	return false, nil, nil
 }
@@ -177,25 +220,50 @@ func (h *K8sValidationTarget) MatchSchema() apiextensions.JSONSchemaProps {
 			},
 		},
 	}
-	return apiextensions.JSONSchemaProps{
-		Properties: map[string]apiextensions.JSONSchemaProps{
-			"kinds": apiextensions.JSONSchemaProps{
-				Type: "array",
-				Items: &apiextensions.JSONSchemaPropsOrArray{
-					Schema: &apiextensions.JSONSchemaProps{
-						Properties: map[string]apiextensions.JSONSchemaProps{
-							"apiGroups": {Items: stringList},
-							"kinds":     {Items: stringList},
-						},
-					},
+	kindSelectorsSchema := apiextensions.JSONSchemaProps{
+		Type: "array",
+		Items: &apiextensions.JSONSchemaPropsOrArray{
+			Schema: &apiextensions.JSONSchemaProps{
+				Properties: map[string]apiextensions.JSONSchemaProps{
+					"apiGroups": {Items: stringList},
+					"kinds":     {Items: stringList},
 				},
 			},
+		},
+	}
+	return apiextensions.JSONSchemaProps{
+		Properties: map[string]apiextensions.JSONSchemaProps{
+			"kinds": kindSelectorsSchema,
+			// ownerKinds restricts matching to objects with an owner of a listed kind
+			"ownerKinds": kindSelectorsSchema,
 			"namespaces": apiextensions.JSONSchemaProps{
 				Type: "array",
 				Items: &apiextensions.JSONSchemaPropsOrArray{
 					Schema: &apiextensions.JSONSchemaProps{Type: "string"}}},
 			"labelSelector":     labelSelectorSchema,
 			"namespaceSelector": labelSelectorSchema,
+			// excludedLabelSelector excludes the objects whose labels it matches, and
+			// annotationSelector and excludedAnnotationSelector select objects by their
+			// annotations the same way
+			"excludedLabelSelector":      labelSelectorSchema,
+			"annotationSelector":         labelSelectorSchema,
+			"excludedAnnotationSelector": labelSelectorSchema,
+			// createdAfter and maxAge restrict matching to objects created after an RFC3339
+			// timestamp or at most a duration ago
+			"createdAfter": apiextensions.JSONSchemaProps{Type: "string"},
+			"maxAge":       apiextensions.JSONSchemaProps{Type: "string"},
+			// requiredPaths restricts matching to objects in which every listed dot-separated
+			// field path, such as spec.initContainers, is present
+			"requiredPaths": apiextensions.JSONSchemaProps{
+				Type: "array",
+				Items: &apiextensions.JSONSchemaPropsOrArray{
+					Schema: &apiextensions.JSONSchemaProps{Type: "string"}}},
+			// subresources lists the subresources, such as scale, whose requests are
+			// matched, or "*" for every subresource. They are not matched otherwise.
+			"subresources": apiextensions.JSONSchemaProps{
+				Type: "array",
+				Items: &apiextensions.JSONSchemaPropsOrArray{
+					Schema: &apiextensions.JSONSchemaProps{Type: "string"}}},
 		},
 	}
 }
@@ -233,6 +301,116 @@ func (h *K8sValidationTarget) ValidateConstraint(u *unstructured.Unstructured) e
 		}
 	}
 
+	if err := validateLabelSelector(u, "excludedLabelSelector"); err != nil {
+		return err
+	}
+	for _, field := range []string{"annotationSelector", "excludedAnnotationSelector"} {
+		if err := validateAnnotationSelector(u, field); err != nil {
+			return err
+		}
+	}
+
+	createdAfter, found, err := unstructured.NestedString(u.Object, "spec", "match", "createdAfter")
+	if err != nil {
+		return err
+	}
+	if found {
+		if _, err := time.Parse(time.RFC3339, createdAfter); err != nil {
+			return errors.Wrap(err, "invalid spec.match.createdAfter, must be an RFC3339 timestamp")
+		}
+	}
+
+	maxAge, found, err := unstructured.NestedString(u.Object, "spec", "match", "maxAge")
+	if err != nil {
+		return err
+	}
+	if found {
+		d, err := time.ParseDuration(maxAge)
+		if err != nil {
+			return errors.Wrap(err, "invalid spec.match.maxAge, must be a duration such as 720h")
+		}
+		if d <= 0 {
+			return errors.Errorf("invalid spec.match.maxAge %q, must be positive", maxAge)
+		}
+	}
+
+	paths, _, err := unstructured.NestedStringSlice(u.Object, "spec", "match", "requiredPaths")
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		for _, f := range strings.Split(path, ".") {
+			if f == "" {
+				return errors.Errorf("invalid spec.match.requiredPaths entry %q, must be field names separated by dots, such as spec.initContainers", path)
+			}
+		}
+	}
+
+	subresources, _, err := unstructured.NestedStringSlice(u.Object, "spec", "match", "subresources")
+	if err != nil {
+		return err
+	}
+	for _, sub := range subresources {
+		if sub == "" || strings.Contains(sub, "/") {
+			return errors.Errorf("invalid spec.match.subresources entry %q, must be the name of a subresource, such as scale, or *", sub)
+		}
+	}
+
+	return nil
+}
+
+// validateLabelSelector returns an error if the label selector in spec.match.<name> of u is
+// invalid
+func validateLabelSelector(u *unstructured.Unstructured, name string) error {
+	selector, found, err := unstructured.NestedMap(u.Object, "spec", "match", name)
+	if err != nil || !found || selector == nil {
+		return err
+	}
+	selectorObj, err := convertToLabelSelector(selector)
+	if err != nil {
+		return err
+	}
+	if errorList := validation.ValidateLabelSelector(selectorObj, field.NewPath("spec", "match", name)); len(errorList) > 0 {
+		return errorList.ToAggregate()
+	}
+	return nil
+}
+
+// validateAnnotationSelector returns an error if the selector of annotations in
+// spec.match.<name> of u is invalid. Annotation values are not restricted like label values.
+func validateAnnotationSelector(u *unstructured.Unstructured, name string) error {
+	selector, found, err := unstructured.NestedMap(u.Object, "spec", "match", name)
+	if err != nil || !found || selector == nil {
+		return err
+	}
+	selectorObj, err := convertToLabelSelector(selector)
+	if err != nil {
+		return err
+	}
+	path := field.NewPath("spec", "match", name)
+	var errorList field.ErrorList
+	for key := range selectorObj.MatchLabels {
+		errorList = append(errorList, validation.ValidateLabelName(key, path.Child("matchLabels"))...)
+	}
+	for i, expr := range selectorObj.MatchExpressions {
+		exprPath := path.Child("matchExpressions").Index(i)
+		errorList = append(errorList, validation.ValidateLabelName(expr.Key, exprPath.Child("key"))...)
+		switch expr.Operator {
+		case metav1.LabelSelectorOpIn, metav1.LabelSelectorOpNotIn:
+			if len(expr.Values) == 0 {
+				errorList = append(errorList, field.Required(exprPath.Child("values"), "must be specified when `operator` is 'In' or 'NotIn'"))
+			}
+		case metav1.LabelSelectorOpExists, metav1.LabelSelectorOpDoesNotExist:
+			if len(expr.Values) > 0 {
+				errorList = append(errorList, field.Forbidden(exprPath.Child("values"), "may not be specified when `operator` is 'Exists' or 'DoesNotExist'"))
+			}
+		default:
+			errorList = append(errorList, field.Invalid(exprPath.Child("operator"), expr.Operator, "not a valid selector operator"))
+		}
+	}
+	if len(errorList) > 0 {
+		return errorList.ToAggregate()
+	}
 	return nil
 }
 
@@ -1,15 +1,22 @@
 package target
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"reflect"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/google/go-cmp/cmp"
 	"github.com/open-policy-agent/frameworks/constraint/pkg/client"
 	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers/local"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/core/templates"
 	"github.com/open-policy-agent/frameworks/constraint/pkg/types"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
@@ -246,6 +253,71 @@ func TestValidateConstraint(t *testing.T) {
 `,
 			ErrorExpected: false,
 		},
+		{
+			Name:          "Valid age matching",
+			Constraint:    `{"apiVersion": "constraints.gatekeeper.sh/v1beta1", "kind": "K8sRequiredStorageClass", "metadata": {"name": "new-pvcs"}, "spec": {"match": {"createdAfter": "2020-01-01T00:00:00Z", "maxAge": "720h"}}}`,
+			ErrorExpected: false,
+		},
+		{
+			Name:          "Valid excluded selectors",
+			Constraint:    `{"apiVersion": "constraints.gatekeeper.sh/v1beta1", "kind": "K8sRequiredStorageClass", "metadata": {"name": "pvcs"}, "spec": {"match": {"excludedLabelSelector": {"matchLabels": {"tier": "system"}}, "annotationSelector": {"matchExpressions": [{"key": "example.com/scan", "operator": "Exists"}]}, "excludedAnnotationSelector": {"matchLabels": {"example.com/reason": "not a label value!"}}}}}`,
+			ErrorExpected: false,
+		},
+		{
+			Name:          "Invalid excludedLabelSelector",
+			Constraint:    `{"apiVersion": "constraints.gatekeeper.sh/v1beta1", "kind": "K8sRequiredStorageClass", "metadata": {"name": "pvcs"}, "spec": {"match": {"excludedLabelSelector": {"matchExpressions": [{"key": "tier", "operator": "In"}]}}}}`,
+			ErrorExpected: true,
+		},
+		{
+			Name:          "Invalid excludedAnnotationSelector",
+			Constraint:    `{"apiVersion": "constraints.gatekeeper.sh/v1beta1", "kind": "K8sRequiredStorageClass", "metadata": {"name": "pvcs"}, "spec": {"match": {"excludedAnnotationSelector": {"matchExpressions": [{"key": "example.com/exempt", "operator": "Exists", "values": ["true"]}]}}}}`,
+			ErrorExpected: true,
+		},
+		{
+			Name:          "Invalid annotationSelector key",
+			Constraint:    `{"apiVersion": "constraints.gatekeeper.sh/v1beta1", "kind": "K8sRequiredStorageClass", "metadata": {"name": "pvcs"}, "spec": {"match": {"annotationSelector": {"matchLabels": {"not a key": "true"}}}}}`,
+			ErrorExpected: true,
+		},
+		{
+			Name:          "Invalid createdAfter",
+			Constraint:    `{"apiVersion": "constraints.gatekeeper.sh/v1beta1", "kind": "K8sRequiredStorageClass", "metadata": {"name": "new-pvcs"}, "spec": {"match": {"createdAfter": "2020-01-01"}}}`,
+			ErrorExpected: true,
+		},
+		{
+			Name:          "Invalid maxAge",
+			Constraint:    `{"apiVersion": "constraints.gatekeeper.sh/v1beta1", "kind": "K8sRequiredStorageClass", "metadata": {"name": "new-pvcs"}, "spec": {"match": {"maxAge": "30d"}}}`,
+			ErrorExpected: true,
+		},
+		{
+			Name:          "Negative maxAge",
+			Constraint:    `{"apiVersion": "constraints.gatekeeper.sh/v1beta1", "kind": "K8sRequiredStorageClass", "metadata": {"name": "new-pvcs"}, "spec": {"match": {"maxAge": "-1h"}}}`,
+			ErrorExpected: true,
+		},
+		{
+			Name:          "Valid requiredPaths",
+			Constraint:    `{"apiVersion": "constraints.gatekeeper.sh/v1beta1", "kind": "K8sAllowedRepos", "metadata": {"name": "init-images"}, "spec": {"match": {"requiredPaths": ["spec.initContainers", "spec.containers.image"]}}}`,
+			ErrorExpected: false,
+		},
+		{
+			Name:          "Empty field in requiredPaths",
+			Constraint:    `{"apiVersion": "constraints.gatekeeper.sh/v1beta1", "kind": "K8sAllowedRepos", "metadata": {"name": "init-images"}, "spec": {"match": {"requiredPaths": ["spec..initContainers"]}}}`,
+			ErrorExpected: true,
+		},
+		{
+			Name:          "Empty requiredPaths entry",
+			Constraint:    `{"apiVersion": "constraints.gatekeeper.sh/v1beta1", "kind": "K8sAllowedRepos", "metadata": {"name": "init-images"}, "spec": {"match": {"requiredPaths": [""]}}}`,
+			ErrorExpected: true,
+		},
+		{
+			Name:          "Valid subresources",
+			Constraint:    `{"apiVersion": "constraints.gatekeeper.sh/v1beta1", "kind": "K8sMaxReplicas", "metadata": {"name": "max-replicas"}, "spec": {"match": {"subresources": ["scale", "*"]}}}`,
+			ErrorExpected: false,
+		},
+		{
+			Name:          "Subresource with its resource",
+			Constraint:    `{"apiVersion": "constraints.gatekeeper.sh/v1beta1", "kind": "K8sMaxReplicas", "metadata": {"name": "max-replicas"}, "spec": {"match": {"subresources": ["deployments/scale"]}}}`,
+			ErrorExpected: true,
+		},
 	}
 	for _, tt := range tc {
 		t.Run(tt.Name, func(t *testing.T) {
@@ -426,3 +498,865 @@ func TestProcessData(t *testing.T) {
 		})
 	}
 }
+
+func TestEffectiveNamespace(t *testing.T) {
+	tc := []struct {
+		Name       string
+		Namespace  string
+		Object     string
+		OldObject  string
+		Namespaced bool
+		Expected   string
+	}{
+		{
+			Name:      "Request namespace",
+			Namespace: "foo",
+			Object:    `{"metadata": {"name": "pod", "namespace": "bar"}}`,
+			Expected:  "foo",
+		},
+		{
+			Name:     "Object namespace",
+			Object:   `{"metadata": {"name": "pod", "namespace": "bar"}}`,
+			Expected: "bar",
+		},
+		{
+			Name:      "Old object namespace",
+			OldObject: `{"metadata": {"name": "pod", "namespace": "bar"}}`,
+			Expected:  "bar",
+		},
+		{
+			Name:       "Defaulted",
+			Object:     `{"metadata": {"name": "pod"}}`,
+			Namespaced: true,
+			Expected:   "default",
+		},
+		{
+			Name:     "Cluster-scoped",
+			Object:   `{"metadata": {"name": "node"}}`,
+			Expected: "",
+		},
+		{
+			Name:       "Invalid object",
+			Object:     `["not", "an", "object"]`,
+			Namespaced: true,
+			Expected:   "default",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			req := &admissionv1beta1.AdmissionRequest{Namespace: tt.Namespace}
+			if tt.Object != "" {
+				req.Object.Raw = []byte(tt.Object)
+			}
+			if tt.OldObject != "" {
+				req.OldObject.Raw = []byte(tt.OldObject)
+			}
+			if ns := EffectiveNamespace(req, tt.Namespaced); ns != tt.Expected {
+				t.Errorf("EffectiveNamespace() = %q; want %q", ns, tt.Expected)
+			}
+		})
+	}
+}
+
+func TestHandleReviewNamespace(t *testing.T) {
+	req := &admissionv1beta1.AdmissionRequest{}
+	req.Object.Raw = []byte(`{"metadata": {"name": "pod", "namespace": "bar"}}`)
+	h := &K8sValidationTarget{}
+	handled, review, err := h.HandleReview(req)
+	if !handled || err != nil {
+		t.Fatalf("HandleReview() = %t, %v; want true, nil", handled, err)
+	}
+	if ns := review.(*admissionv1beta1.AdmissionRequest).Namespace; ns != "bar" {
+		t.Errorf("review namespace = %q; want bar", ns)
+	}
+	if req.Namespace != "" {
+		t.Errorf("HandleReview() modified the request namespace to %q", req.Namespace)
+	}
+}
+
+func TestOwnerKindsMatch(t *testing.T) {
+	target := &K8sValidationTarget{}
+	backend, err := client.NewBackend(client.Driver(local.New(local.Tracing(false))))
+	if err != nil {
+		t.Fatalf("Could not initialize backend: %s", err)
+	}
+	c, err := backend.NewClient(client.Targets(target))
+	if err != nil {
+		t.Fatalf("unable to set up OPA client: %s", err)
+	}
+	templ := &templates.ConstraintTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "k8sdenyall"},
+		Spec: templates.ConstraintTemplateSpec{
+			CRD: templates.CRD{Spec: templates.CRDSpec{Names: templates.Names{Kind: "K8sDenyAll"}}},
+			Targets: []templates.Target{{Target: target.GetName(), Rego: `
+package denyall
+
+violation[{"msg": "denied"}] {
+  true
+}
+`}},
+		},
+	}
+	if _, err := c.AddTemplate(context.Background(), templ); err != nil {
+		t.Fatalf("could not add template: %s", err)
+	}
+	cstr := &unstructured.Unstructured{}
+	if err := json.Unmarshal([]byte(`{
+  "apiVersion": "constraints.gatekeeper.sh/v1beta1",
+  "kind": "K8sDenyAll",
+  "metadata": {"name": "job-pods-in-batch"},
+  "spec": {"match": {
+    "kinds": [{"apiGroups": [""], "kinds": ["Pod"]}],
+    "namespaces": ["batch"],
+    "ownerKinds": [{"apiGroups": ["batch"], "kinds": ["Job"]}]
+  }}
+}`), cstr); err != nil {
+		t.Fatalf("could not parse constraint: %s", err)
+	}
+	if _, err := c.AddConstraint(context.Background(), cstr); err != nil {
+		t.Fatalf("could not add constraint: %s", err)
+	}
+
+	tc := []struct {
+		Name      string
+		Namespace string
+		Owners    string
+		Denied    bool
+	}{
+		{Name: "owned by a Job", Namespace: "batch", Owners: `[{"apiVersion": "batch/v1", "kind": "Job", "name": "job"}]`, Denied: true},
+		{Name: "also owned by a Job", Namespace: "batch", Owners: `[{"apiVersion": "apps/v1", "kind": "ReplicaSet", "name": "rs"}, {"apiVersion": "batch/v1", "kind": "Job", "name": "job"}]`, Denied: true},
+		{Name: "unowned", Namespace: "batch", Owners: `[]`},
+		{Name: "owned by a ReplicaSet", Namespace: "batch", Owners: `[{"apiVersion": "apps/v1", "kind": "ReplicaSet", "name": "rs"}]`},
+		{Name: "owned by a Job in another namespace", Namespace: "default", Owners: `[{"apiVersion": "batch/v1", "kind": "Job", "name": "job"}]`},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			req := &admissionv1beta1.AdmissionRequest{
+				Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Name:      "pod",
+				Namespace: tt.Namespace,
+				Operation: admissionv1beta1.Create,
+			}
+			req.Object.Raw = []byte(fmt.Sprintf(`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "pod", "namespace": %q, "ownerReferences": %s}}`, tt.Namespace, tt.Owners))
+			resp, err := c.Review(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Review() err = %s", err)
+			}
+			if denied := len(resp.Results()) > 0; denied != tt.Denied {
+				t.Errorf("denied = %t; want %t", denied, tt.Denied)
+			}
+		})
+	}
+}
+
+func TestInventoryAggregate(t *testing.T) {
+	target := &K8sValidationTarget{}
+	backend, err := client.NewBackend(client.Driver(local.New(local.Tracing(false))))
+	if err != nil {
+		t.Fatalf("Could not initialize backend: %s", err)
+	}
+	c, err := backend.NewClient(client.Targets(target))
+	if err != nil {
+		t.Fatalf("unable to set up OPA client: %s", err)
+	}
+	templ := &templates.ConstraintTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "k8smaxloadbalancers"},
+		Spec: templates.ConstraintTemplateSpec{
+			CRD: templates.CRD{Spec: templates.CRDSpec{Names: templates.Names{Kind: "K8sMaxLoadBalancers"}}},
+			Targets: []templates.Target{{Target: target.GetName(), Rego: `
+package k8smaxloadbalancers
+
+violation[{"msg": msg}] {
+  svc := input.review.object
+  is_load_balancer(svc)
+  ns := svc.metadata.namespace
+  # the reviewed service is in the inventory if it already exists
+  others := {name | other := data.inventory.namespace[ns]["v1"]["Service"][name]; is_load_balancer(other); name != svc.metadata.name}
+  count(others) + 1 > input.parameters.max
+  msg := sprintf("namespace %v already has %v LoadBalancer services", [ns, count(others)])
+}
+
+is_load_balancer(svc) {
+  svc.spec.type == "LoadBalancer"
+}
+`}},
+		},
+	}
+	if _, err := c.AddTemplate(context.Background(), templ); err != nil {
+		t.Fatalf("could not add template: %s", err)
+	}
+	cstr := &unstructured.Unstructured{}
+	if err := json.Unmarshal([]byte(`{
+  "apiVersion": "constraints.gatekeeper.sh/v1beta1",
+  "kind": "K8sMaxLoadBalancers",
+  "metadata": {"name": "two-load-balancers"},
+  "spec": {
+    "match": {"kinds": [{"apiGroups": [""], "kinds": ["Service"]}]},
+    "parameters": {"max": 2}
+  }
+}`), cstr); err != nil {
+		t.Fatalf("could not parse constraint: %s", err)
+	}
+	if _, err := c.AddConstraint(context.Background(), cstr); err != nil {
+		t.Fatalf("could not add constraint: %s", err)
+	}
+
+	service := func(namespace, name, svcType string) *unstructured.Unstructured {
+		svc := &unstructured.Unstructured{}
+		if err := json.Unmarshal([]byte(fmt.Sprintf(`{"apiVersion": "v1", "kind": "Service", "metadata": {"name": %q, "namespace": %q}, "spec": {"type": %q}}`, name, namespace, svcType)), svc); err != nil {
+			t.Fatalf("could not parse service: %s", err)
+		}
+		return svc
+	}
+	for _, svc := range []*unstructured.Unstructured{
+		service("web", "first", "LoadBalancer"),
+		service("web", "second", "LoadBalancer"),
+		service("web", "internal", "ClusterIP"),
+	} {
+		if _, err := c.AddData(context.Background(), svc); err != nil {
+			t.Fatalf("could not add data: %s", err)
+		}
+	}
+
+	tc := []struct {
+		Name      string
+		Operation admissionv1beta1.Operation
+		Service   *unstructured.Unstructured
+		Denied    bool
+	}{
+		{Name: "third load balancer", Operation: admissionv1beta1.Create, Service: service("web", "third", "LoadBalancer"), Denied: true},
+		{Name: "cluster IP service", Operation: admissionv1beta1.Create, Service: service("web", "third", "ClusterIP")},
+		{Name: "existing load balancer", Operation: admissionv1beta1.Update, Service: service("web", "first", "LoadBalancer")},
+		{Name: "load balancer in another namespace", Operation: admissionv1beta1.Create, Service: service("api", "first", "LoadBalancer")},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			req := &admissionv1beta1.AdmissionRequest{
+				Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Service"},
+				Name:      tt.Service.GetName(),
+				Namespace: tt.Service.GetNamespace(),
+				Operation: tt.Operation,
+			}
+			raw, err := json.Marshal(tt.Service.Object)
+			if err != nil {
+				t.Fatalf("could not marshal service: %s", err)
+			}
+			req.Object.Raw = raw
+			resp, err := c.Review(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Review() err = %s", err)
+			}
+			if denied := len(resp.Results()) > 0; denied != tt.Denied {
+				t.Errorf("denied = %t; want %t", denied, tt.Denied)
+			}
+		})
+	}
+
+	// audit reviews the cached services, each of which counts itself once
+	resp, err := c.Audit(context.Background())
+	if err != nil {
+		t.Fatalf("Audit() err = %s", err)
+	}
+	if len(resp.Results()) != 0 {
+		t.Errorf("Audit() found %d violations with the limit reached but not exceeded; want 0", len(resp.Results()))
+	}
+}
+
+func TestAgeMatch(t *testing.T) {
+	target := &K8sValidationTarget{}
+	backend, err := client.NewBackend(client.Driver(local.New(local.Tracing(false))))
+	if err != nil {
+		t.Fatalf("Could not initialize backend: %s", err)
+	}
+	c, err := backend.NewClient(client.Targets(target))
+	if err != nil {
+		t.Fatalf("unable to set up OPA client: %s", err)
+	}
+	templ := &templates.ConstraintTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "k8sdenyall"},
+		Spec: templates.ConstraintTemplateSpec{
+			CRD: templates.CRD{Spec: templates.CRDSpec{Names: templates.Names{Kind: "K8sDenyAll"}}},
+			Targets: []templates.Target{{Target: target.GetName(), Rego: `
+package denyall
+
+violation[{"msg": "denied"}] {
+  true
+}
+`}},
+		},
+	}
+	if _, err := c.AddTemplate(context.Background(), templ); err != nil {
+		t.Fatalf("could not add template: %s", err)
+	}
+	now := time.Now()
+	cutoff := now.Add(-24 * time.Hour)
+	for name, match := range map[string]string{
+		"created-after": fmt.Sprintf(`{"kinds": [{"apiGroups": [""], "kinds": ["PersistentVolumeClaim"]}], "createdAfter": %q}`, cutoff.Format(time.RFC3339)),
+		"max-age":       `{"kinds": [{"apiGroups": [""], "kinds": ["PersistentVolumeClaim"]}], "maxAge": "24h"}`,
+	} {
+		cstr := &unstructured.Unstructured{}
+		if err := json.Unmarshal([]byte(fmt.Sprintf(`{"apiVersion": "constraints.gatekeeper.sh/v1beta1", "kind": "K8sDenyAll", "metadata": {"name": %q}, "spec": {"match": %s}}`, name, match)), cstr); err != nil {
+			t.Fatalf("could not parse constraint: %s", err)
+		}
+		if _, err := c.AddConstraint(context.Background(), cstr); err != nil {
+			t.Fatalf("could not add constraint: %s", err)
+		}
+	}
+
+	pvc := func(name string, created time.Time) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("PersistentVolumeClaim")
+		obj.SetNamespace("default")
+		obj.SetName(name)
+		obj.SetCreationTimestamp(metav1.NewTime(created))
+		return obj
+	}
+	tc := []struct {
+		Name   string
+		PVC    *unstructured.Unstructured
+		Denied []string
+	}{
+		{Name: "created before the cutoff", PVC: pvc("old", now.Add(-48*time.Hour))},
+		{Name: "created after the cutoff", PVC: pvc("new", now.Add(-time.Hour)), Denied: []string{"created-after", "max-age"}},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			req := &admissionv1beta1.AdmissionRequest{
+				Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "PersistentVolumeClaim"},
+				Name:      tt.PVC.GetName(),
+				Namespace: tt.PVC.GetNamespace(),
+				Operation: admissionv1beta1.Update,
+			}
+			raw, err := json.Marshal(tt.PVC.Object)
+			if err != nil {
+				t.Fatalf("could not marshal PVC: %s", err)
+			}
+			req.Object.Raw = raw
+			resp, err := c.Review(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Review() err = %s", err)
+			}
+			var denied []string
+			for _, r := range resp.Results() {
+				denied = append(denied, r.Constraint.GetName())
+			}
+			sort.Strings(denied)
+			if !reflect.DeepEqual(denied, tt.Denied) {
+				t.Errorf("denied by %v; want %v", denied, tt.Denied)
+			}
+		})
+		if _, err := c.AddData(context.Background(), tt.PVC); err != nil {
+			t.Fatalf("could not add data: %s", err)
+		}
+	}
+
+	resp, err := c.Audit(context.Background())
+	if err != nil {
+		t.Fatalf("Audit() err = %s", err)
+	}
+	var audited []string
+	for _, r := range resp.Results() {
+		audited = append(audited, r.Constraint.GetName()+" "+r.Resource.(*unstructured.Unstructured).GetName())
+	}
+	sort.Strings(audited)
+	if want := []string{"created-after new", "max-age new"}; !reflect.DeepEqual(audited, want) {
+		t.Errorf("Audit() found %v; want %v", audited, want)
+	}
+}
+
+func TestExcludedSelectorMatch(t *testing.T) {
+	target := &K8sValidationTarget{}
+	backend, err := client.NewBackend(client.Driver(local.New(local.Tracing(false))))
+	if err != nil {
+		t.Fatalf("Could not initialize backend: %s", err)
+	}
+	c, err := backend.NewClient(client.Targets(target))
+	if err != nil {
+		t.Fatalf("unable to set up OPA client: %s", err)
+	}
+	templ := &templates.ConstraintTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "k8sdenyall"},
+		Spec: templates.ConstraintTemplateSpec{
+			CRD: templates.CRD{Spec: templates.CRDSpec{Names: templates.Names{Kind: "K8sDenyAll"}}},
+			Targets: []templates.Target{{Target: target.GetName(), Rego: `
+package denyall
+
+violation[{"msg": "denied"}] {
+  true
+}
+`}},
+		},
+	}
+	if _, err := c.AddTemplate(context.Background(), templ); err != nil {
+		t.Fatalf("could not add template: %s", err)
+	}
+	for name, match := range map[string]string{
+		"not-system":     `{"kinds": [{"apiGroups": [""], "kinds": ["Pod"]}], "excludedLabelSelector": {"matchLabels": {"tier": "system"}}}`,
+		"web-not-canary": `{"kinds": [{"apiGroups": [""], "kinds": ["Pod"]}], "labelSelector": {"matchLabels": {"app": "web"}}, "excludedLabelSelector": {"matchExpressions": [{"key": "track", "operator": "In", "values": ["canary"]}]}}`,
+		"not-exempt":     `{"kinds": [{"apiGroups": [""], "kinds": ["Pod"]}], "excludedAnnotationSelector": {"matchExpressions": [{"key": "policy.example.com/exempt", "operator": "Exists"}]}}`,
+	} {
+		cstr := &unstructured.Unstructured{}
+		if err := json.Unmarshal([]byte(fmt.Sprintf(`{"apiVersion": "constraints.gatekeeper.sh/v1beta1", "kind": "K8sDenyAll", "metadata": {"name": %q}, "spec": {"match": %s}}`, name, match)), cstr); err != nil {
+			t.Fatalf("could not parse constraint: %s", err)
+		}
+		if err := target.ValidateConstraint(cstr); err != nil {
+			t.Fatalf("ValidateConstraint() err = %s", err)
+		}
+		if _, err := c.AddConstraint(context.Background(), cstr); err != nil {
+			t.Fatalf("could not add constraint: %s", err)
+		}
+	}
+
+	pod := func(name string, labels, annotations map[string]string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("Pod")
+		obj.SetNamespace("default")
+		obj.SetName(name)
+		obj.SetLabels(labels)
+		obj.SetAnnotations(annotations)
+		return obj
+	}
+	tc := []struct {
+		Name   string
+		Pod    *unstructured.Unstructured
+		Denied []string
+	}{
+		{Name: "unlabeled", Pod: pod("plain", nil, nil), Denied: []string{"not-exempt", "not-system"}},
+		{Name: "excluded by label", Pod: pod("system", map[string]string{"tier": "system"}, nil), Denied: []string{"not-exempt"}},
+		{Name: "selected and not excluded", Pod: pod("web", map[string]string{"app": "web", "track": "stable"}, nil), Denied: []string{"not-exempt", "not-system", "web-not-canary"}},
+		{Name: "selected and excluded", Pod: pod("canary", map[string]string{"app": "web", "track": "canary"}, nil), Denied: []string{"not-exempt", "not-system"}},
+		{Name: "excluded by annotation", Pod: pod("exempt", nil, map[string]string{"policy.example.com/exempt": "incident 42"}), Denied: []string{"not-system"}},
+	}
+	var wantAudited []string
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			req := &admissionv1beta1.AdmissionRequest{
+				Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Name:      tt.Pod.GetName(),
+				Namespace: tt.Pod.GetNamespace(),
+				Operation: admissionv1beta1.Create,
+			}
+			raw, err := json.Marshal(tt.Pod.Object)
+			if err != nil {
+				t.Fatalf("could not marshal pod: %s", err)
+			}
+			req.Object.Raw = raw
+			resp, err := c.Review(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Review() err = %s", err)
+			}
+			var denied []string
+			for _, r := range resp.Results() {
+				denied = append(denied, r.Constraint.GetName())
+			}
+			sort.Strings(denied)
+			if !reflect.DeepEqual(denied, tt.Denied) {
+				t.Errorf("denied by %v; want %v", denied, tt.Denied)
+			}
+		})
+		if _, err := c.AddData(context.Background(), tt.Pod); err != nil {
+			t.Fatalf("could not add data: %s", err)
+		}
+		for _, name := range tt.Denied {
+			wantAudited = append(wantAudited, name+" "+tt.Pod.GetName())
+		}
+	}
+
+	resp, err := c.Audit(context.Background())
+	if err != nil {
+		t.Fatalf("Audit() err = %s", err)
+	}
+	var audited []string
+	for _, r := range resp.Results() {
+		audited = append(audited, r.Constraint.GetName()+" "+r.Resource.(*unstructured.Unstructured).GetName())
+	}
+	sort.Strings(audited)
+	sort.Strings(wantAudited)
+	if !reflect.DeepEqual(audited, wantAudited) {
+		t.Errorf("Audit() found %v; want %v", audited, wantAudited)
+	}
+}
+
+func TestRequiredPathsMatch(t *testing.T) {
+	target := &K8sValidationTarget{}
+	backend, err := client.NewBackend(client.Driver(local.New(local.Tracing(false))))
+	if err != nil {
+		t.Fatalf("Could not initialize backend: %s", err)
+	}
+	c, err := backend.NewClient(client.Targets(target))
+	if err != nil {
+		t.Fatalf("unable to set up OPA client: %s", err)
+	}
+	templ := &templates.ConstraintTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "k8sdenyall"},
+		Spec: templates.ConstraintTemplateSpec{
+			CRD: templates.CRD{Spec: templates.CRDSpec{Names: templates.Names{Kind: "K8sDenyAll"}}},
+			Targets: []templates.Target{{Target: target.GetName(), Rego: `
+package denyall
+
+violation[{"msg": "denied"}] {
+  true
+}
+`}},
+		},
+	}
+	if _, err := c.AddTemplate(context.Background(), templ); err != nil {
+		t.Fatalf("could not add template: %s", err)
+	}
+	cstr := &unstructured.Unstructured{}
+	if err := json.Unmarshal([]byte(`{"apiVersion": "constraints.gatekeeper.sh/v1beta1", "kind": "K8sDenyAll", "metadata": {"name": "init-containers"}, "spec": {"match": {"kinds": [{"apiGroups": [""], "kinds": ["Pod"]}], "requiredPaths": ["spec.initContainers"]}}}`), cstr); err != nil {
+		t.Fatalf("could not parse constraint: %s", err)
+	}
+	if err := target.ValidateConstraint(cstr); err != nil {
+		t.Fatalf("ValidateConstraint() err = %s", err)
+	}
+	if _, err := c.AddConstraint(context.Background(), cstr); err != nil {
+		t.Fatalf("could not add constraint: %s", err)
+	}
+
+	pod := func(name string, spec string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		if err := json.Unmarshal([]byte(fmt.Sprintf(`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": %q, "namespace": "default"}, "spec": %s}`, name, spec)), obj); err != nil {
+			t.Fatalf("could not parse pod: %s", err)
+		}
+		return obj
+	}
+	tc := []struct {
+		Name    string
+		Pod     *unstructured.Unstructured
+		Matched bool
+	}{
+		{Name: "with initContainers", Pod: pod("init", `{"initContainers": [{"name": "setup", "image": "busybox"}], "containers": [{"name": "app", "image": "nginx"}]}`), Matched: true},
+		{Name: "without initContainers", Pod: pod("plain", `{"containers": [{"name": "app", "image": "nginx"}]}`)},
+		{Name: "with no initContainers", Pod: pod("empty", `{"initContainers": [], "containers": [{"name": "app", "image": "nginx"}]}`)},
+	}
+	var wantAudited []string
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			req := &admissionv1beta1.AdmissionRequest{
+				Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Name:      tt.Pod.GetName(),
+				Namespace: tt.Pod.GetNamespace(),
+				Operation: admissionv1beta1.Create,
+			}
+			raw, err := json.Marshal(tt.Pod.Object)
+			if err != nil {
+				t.Fatalf("could not marshal pod: %s", err)
+			}
+			req.Object.Raw = raw
+			resp, err := c.Review(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Review() err = %s", err)
+			}
+			if matched := len(resp.Results()) != 0; matched != tt.Matched {
+				t.Errorf("matched = %t; want %t", matched, tt.Matched)
+			}
+		})
+		if _, err := c.AddData(context.Background(), tt.Pod); err != nil {
+			t.Fatalf("could not add data: %s", err)
+		}
+		if tt.Matched {
+			wantAudited = append(wantAudited, tt.Pod.GetName())
+		}
+	}
+
+	resp, err := c.Audit(context.Background())
+	if err != nil {
+		t.Fatalf("Audit() err = %s", err)
+	}
+	var audited []string
+	for _, r := range resp.Results() {
+		audited = append(audited, r.Resource.(*unstructured.Unstructured).GetName())
+	}
+	if !reflect.DeepEqual(audited, wantAudited) {
+		t.Errorf("Audit() found %v; want %v", audited, wantAudited)
+	}
+}
+
+func TestSubresourcesMatch(t *testing.T) {
+	target := &K8sValidationTarget{}
+	driver := local.New(local.Tracing(false))
+	backend, err := client.NewBackend(client.Driver(driver))
+	if err != nil {
+		t.Fatalf("Could not initialize backend: %s", err)
+	}
+	c, err := backend.NewClient(client.Targets(target))
+	if err != nil {
+		t.Fatalf("unable to set up OPA client: %s", err)
+	}
+	templ := &templates.ConstraintTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "k8smaxreplicas"},
+		Spec: templates.ConstraintTemplateSpec{
+			CRD: templates.CRD{Spec: templates.CRDSpec{Names: templates.Names{Kind: "K8sMaxReplicas"}}},
+			Targets: []templates.Target{{Target: target.GetName(), Rego: `
+package k8smaxreplicas
+
+violation[{"msg": msg}] {
+  input.review.subResource == "scale"
+  input.review.object.spec.replicas > 3
+  msg := sprintf("%v cannot be scaled above 3 replicas", [input.review.name])
+}
+`}},
+		},
+	}
+	if _, err := c.AddTemplate(context.Background(), templ); err != nil {
+		t.Fatalf("could not add template: %s", err)
+	}
+	// only the first constraint opts into the scale subresource
+	for _, constraint := range []string{
+		`{"apiVersion": "constraints.gatekeeper.sh/v1beta1", "kind": "K8sMaxReplicas", "metadata": {"name": "scale"}, "spec": {"match": {"kinds": [{"apiGroups": ["autoscaling"], "kinds": ["Scale"]}], "subresources": ["scale"]}}}`,
+		`{"apiVersion": "constraints.gatekeeper.sh/v1beta1", "kind": "K8sMaxReplicas", "metadata": {"name": "resources"}, "spec": {"match": {"kinds": [{"apiGroups": ["*"], "kinds": ["*"]}]}}}`,
+	} {
+		cstr := &unstructured.Unstructured{}
+		if err := json.Unmarshal([]byte(constraint), cstr); err != nil {
+			t.Fatalf("could not parse constraint: %s", err)
+		}
+		if err := target.ValidateConstraint(cstr); err != nil {
+			t.Fatalf("ValidateConstraint() err = %s", err)
+		}
+		if _, err := c.AddConstraint(context.Background(), cstr); err != nil {
+			t.Fatalf("could not add constraint: %s", err)
+		}
+	}
+
+	scale := func(replicas int) *admissionv1beta1.AdmissionRequest {
+		req := &admissionv1beta1.AdmissionRequest{
+			Kind:        metav1.GroupVersionKind{Group: "autoscaling", Version: "v1", Kind: "Scale"},
+			Resource:    metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+			SubResource: "scale",
+			Name:        "web",
+			Namespace:   "default",
+			Operation:   admissionv1beta1.Update,
+		}
+		req.Object.Raw = []byte(fmt.Sprintf(`{"apiVersion": "autoscaling/v1", "kind": "Scale", "metadata": {"name": "web", "namespace": "default"}, "spec": {"replicas": %d}}`, replicas))
+		return req
+	}
+	exec := &admissionv1beta1.AdmissionRequest{
+		Kind:        metav1.GroupVersionKind{Version: "v1", Kind: "PodExecOptions"},
+		Resource:    metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		SubResource: "exec",
+		Name:        "web",
+		Namespace:   "default",
+		Operation:   admissionv1beta1.Connect,
+	}
+	exec.Object.Raw = []byte(`{"apiVersion": "v1", "kind": "PodExecOptions", "command": ["sh"]}`)
+	tc := []struct {
+		Name    string
+		Request *admissionv1beta1.AdmissionRequest
+		Matched []string
+		Denied  []string
+	}{
+		{Name: "scaling above the limit", Request: scale(5), Matched: []string{"scale"}, Denied: []string{"scale"}},
+		{Name: "scaling within the limit", Request: scale(2), Matched: []string{"scale"}},
+		{Name: "connect requests match regardless", Request: exec, Matched: []string{"resources"}},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			resp, err := c.Review(context.Background(), tt.Request)
+			if err != nil {
+				t.Fatalf("Review() err = %s", err)
+			}
+			var denied []string
+			for _, r := range resp.Results() {
+				denied = append(denied, r.Constraint.GetName())
+			}
+			if !reflect.DeepEqual(denied, tt.Denied) {
+				t.Errorf("denied by %v; want %v", denied, tt.Denied)
+			}
+
+			_, review, err := target.HandleReview(tt.Request)
+			if err != nil {
+				t.Fatalf("HandleReview() err = %s", err)
+			}
+			r, err := driver.Query(context.Background(), fmt.Sprintf(`hooks["%s"].library.matched_constraints`, target.GetName()), map[string]interface{}{"review": review})
+			if err != nil {
+				t.Fatalf("Query() err = %s", err)
+			}
+			var matched []string
+			for _, result := range r.Results {
+				matched = append(matched, result.Constraint.GetName())
+			}
+			sort.Strings(matched)
+			if !reflect.DeepEqual(matched, tt.Matched) {
+				t.Errorf("matched %v; want %v", matched, tt.Matched)
+			}
+		})
+	}
+}
+
+func TestKindMatchesEveryVersion(t *testing.T) {
+	target := &K8sValidationTarget{}
+	backend, err := client.NewBackend(client.Driver(local.New(local.Tracing(false))))
+	if err != nil {
+		t.Fatalf("Could not initialize backend: %s", err)
+	}
+	c, err := backend.NewClient(client.Targets(target))
+	if err != nil {
+		t.Fatalf("unable to set up OPA client: %s", err)
+	}
+	templ := &templates.ConstraintTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "k8sdenyall"},
+		Spec: templates.ConstraintTemplateSpec{
+			CRD: templates.CRD{Spec: templates.CRDSpec{Names: templates.Names{Kind: "K8sDenyAll"}}},
+			Targets: []templates.Target{{Target: target.GetName(), Rego: `
+package denyall
+
+violation[{"msg": "denied"}] {
+  true
+}
+`}},
+		},
+	}
+	if _, err := c.AddTemplate(context.Background(), templ); err != nil {
+		t.Fatalf("could not add template: %s", err)
+	}
+	cstr := &unstructured.Unstructured{}
+	if err := json.Unmarshal([]byte(`{"apiVersion": "constraints.gatekeeper.sh/v1beta1", "kind": "K8sDenyAll", "metadata": {"name": "ingresses"}, "spec": {"match": {"kinds": [{"apiGroups": ["networking.k8s.io"], "kinds": ["Ingress"]}]}}}`), cstr); err != nil {
+		t.Fatalf("could not parse constraint: %s", err)
+	}
+	if _, err := c.AddConstraint(context.Background(), cstr); err != nil {
+		t.Fatalf("could not add constraint: %s", err)
+	}
+
+	tc := []struct {
+		Name       string
+		APIVersion string
+		Matched    bool
+	}{
+		{Name: "v1beta1", APIVersion: "networking.k8s.io/v1beta1", Matched: true},
+		{Name: "v1", APIVersion: "networking.k8s.io/v1", Matched: true},
+		{Name: "other-group", APIVersion: "extensions/v1beta1"},
+	}
+	var wantAudited []string
+	for _, tt := range tc {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(tt.APIVersion)
+		obj.SetKind("Ingress")
+		obj.SetNamespace("default")
+		obj.SetName(tt.Name)
+		t.Run(tt.Name, func(t *testing.T) {
+			gvk := obj.GroupVersionKind()
+			req := &admissionv1beta1.AdmissionRequest{
+				Kind:      metav1.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind},
+				Name:      obj.GetName(),
+				Namespace: obj.GetNamespace(),
+				Operation: admissionv1beta1.Create,
+			}
+			raw, err := json.Marshal(obj.Object)
+			if err != nil {
+				t.Fatalf("could not marshal ingress: %s", err)
+			}
+			req.Object.Raw = raw
+			resp, err := c.Review(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Review() err = %s", err)
+			}
+			if matched := len(resp.Results()) != 0; matched != tt.Matched {
+				t.Errorf("matched = %t; want %t", matched, tt.Matched)
+			}
+		})
+		if _, err := c.AddData(context.Background(), obj); err != nil {
+			t.Fatalf("could not add data: %s", err)
+		}
+		if tt.Matched {
+			wantAudited = append(wantAudited, tt.APIVersion)
+		}
+	}
+
+	resp, err := c.Audit(context.Background())
+	if err != nil {
+		t.Fatalf("Audit() err = %s", err)
+	}
+	var audited []string
+	for _, r := range resp.Results() {
+		audited = append(audited, r.Resource.(*unstructured.Unstructured).GetAPIVersion())
+	}
+	sort.Strings(audited)
+	sort.Strings(wantAudited)
+	if !reflect.DeepEqual(audited, wantAudited) {
+		t.Errorf("Audit() found %v; want %v", audited, wantAudited)
+	}
+}
+
+func TestCandidateConstraints(t *testing.T) {
+	idx := NewConstraintIndex()
+	constraint := func(name, match string) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{Object: map[string]interface{}{}}
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(match), &m); err != nil {
+			t.Fatalf("Could not parse match of %s: %s", name, err)
+		}
+		u.Object["spec"] = map[string]interface{}{"match": m}
+		u.SetKind("K8sTest")
+		u.SetName(name)
+		return u
+	}
+	for _, c := range []struct{ name, match string }{
+		{"pods", `{"kinds": [{"apiGroups": [""], "kinds": ["Pod", "Service"]}]}`},
+		{"any-group", `{"kinds": [{"apiGroups": ["*"], "kinds": ["Deployment"]}]}`},
+		{"apps", `{"kinds": [{"apiGroups": ["apps"], "kinds": ["*"]}]}`},
+		{"everything", `{}`},
+		{"selected-namespaces", `{"kinds": [{"apiGroups": ["example.com"], "kinds": ["Widget"]}], "namespaceSelector": {}}`},
+		{"no-groups", `{"kinds": [{"kinds": ["Pod"]}]}`},
+		{"no-kinds", `{"kinds": []}`},
+		{"malformed", `{"kinds": "Pod"}`},
+	} {
+		idx.Add(constraint(c.name, c.match))
+	}
+	tc := []struct {
+		Group, Kind string
+		Expected    []string
+	}{
+		{Group: "", Kind: "Pod", Expected: []string{"everything", "malformed", "pods", "selected-namespaces"}},
+		{Group: "apps", Kind: "Deployment", Expected: []string{"any-group", "apps", "everything", "malformed", "selected-namespaces"}},
+		{Group: "example.com", Kind: "Deployment", Expected: []string{"any-group", "everything", "malformed", "selected-namespaces"}},
+		{Group: "example.com", Kind: "Gadget", Expected: []string{"everything", "malformed", "selected-namespaces"}},
+	}
+	for _, tt := range tc {
+		var got []string
+		for _, key := range idx.Candidates(tt.Group, tt.Kind) {
+			got = append(got, key.Name)
+		}
+		if !reflect.DeepEqual(got, tt.Expected) {
+			t.Errorf("Candidates(%q, %q) = %v; want %v", tt.Group, tt.Kind, got, tt.Expected)
+		}
+	}
+
+	// a changed constraint stays listed under the kinds it selected until it is trimmed
+	listed := func(name, kind string) bool {
+		for _, key := range idx.Candidates("", kind) {
+			if key.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+	changed := constraint("pods", `{"kinds": [{"apiGroups": [""], "kinds": ["Service", "ConfigMap"]}]}`)
+	idx.Add(changed)
+	if !listed("pods", "Pod") || !listed("pods", "ConfigMap") {
+		t.Error("changed constraint not listed under both its old and new kinds before it is trimmed")
+	}
+	idx.Trim(changed)
+	if listed("pods", "Pod") || !listed("pods", "ConfigMap") || !listed("pods", "Service") {
+		t.Error("trimmed constraint not listed under exactly the kinds it now selects")
+	}
+
+	// removed constraints are no longer listed, nor are the kinds only they selected
+	for _, name := range []string{"pods", "any-group", "apps", "everything", "selected-namespaces", "no-groups", "no-kinds", "malformed"} {
+		idx.Remove(constraint(name, `{}`))
+	}
+	if got := idx.Candidates("", "Service"); len(got) != 0 {
+		t.Errorf("Candidates() = %v once every constraint is removed; want none", got)
+	}
+	if len(idx.byKind) != 0 || len(idx.selected) != 0 {
+		t.Errorf("index holds %v and %v once every constraint is removed; want nothing", idx.byKind, idx.selected)
+	}
+
+	var none *ConstraintIndex
+	none.Add(constraint("pods", `{}`))
+	if got := none.Candidates("", "Pod"); got != nil {
+		t.Errorf("Candidates() of a nil index = %v; want nil", got)
+	}
+}
@@ -0,0 +1,97 @@
+package util
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// admissionGate tracks the admission reviews in flight, so work sharing the OPA client with
+// them can give way
+type admissionGate struct {
+	mux      sync.Mutex
+	inFlight int
+	// idle is closed while no admission review is in flight, and busy while one is
+	idle chan struct{}
+	busy chan struct{}
+}
+
+func newAdmissionGate() *admissionGate {
+	idle := make(chan struct{})
+	close(idle)
+	return &admissionGate{idle: idle, busy: make(chan struct{})}
+}
+
+var admissions = newAdmissionGate()
+
+// AdmissionStarted records that an admission review started. The returned function must be
+// called once the review is done.
+func AdmissionStarted() func() {
+	return admissions.started()
+}
+
+// WaitForAdmissions waits until no admission review is in flight, for at most max. It only
+// returns an error if ctx is done first.
+func WaitForAdmissions(ctx context.Context, max time.Duration) error {
+	return admissions.wait(ctx, max)
+}
+
+// PreemptedByAdmission returns a context derived from ctx that is canceled as soon as an
+// admission review starts, so work that can be retried stops competing with it
+func PreemptedByAdmission(ctx context.Context) (context.Context, context.CancelFunc) {
+	return admissions.preempted(ctx)
+}
+
+func (g *admissionGate) started() func() {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	if g.inFlight == 0 {
+		g.idle = make(chan struct{})
+		close(g.busy)
+	}
+	g.inFlight++
+	var once sync.Once
+	return func() {
+		once.Do(g.done)
+	}
+}
+
+func (g *admissionGate) done() {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	g.inFlight--
+	if g.inFlight == 0 {
+		close(g.idle)
+		g.busy = make(chan struct{})
+	}
+}
+
+func (g *admissionGate) wait(ctx context.Context, max time.Duration) error {
+	g.mux.Lock()
+	idle := g.idle
+	g.mux.Unlock()
+	timer := time.NewTimer(max)
+	defer timer.Stop()
+	select {
+	case <-idle:
+	case <-timer.C:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (g *admissionGate) preempted(ctx context.Context) (context.Context, context.CancelFunc) {
+	g.mux.Lock()
+	busy := g.busy
+	g.mux.Unlock()
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-busy:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,82 @@
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAdmissionGate(t *testing.T) {
+	g := newAdmissionGate()
+	waited := func(max time.Duration) time.Duration {
+		start := time.Now()
+		if err := g.wait(context.Background(), max); err != nil {
+			t.Fatalf("wait() err = %s", err)
+		}
+		return time.Since(start)
+	}
+
+	if d := waited(time.Second); d > 100*time.Millisecond {
+		t.Errorf("waited %s with no admission in flight; want no wait", d)
+	}
+
+	first := g.started()
+	second := g.started()
+	if d := waited(50 * time.Millisecond); d < 50*time.Millisecond {
+		t.Errorf("waited %s with admissions in flight; want the maximum wait", d)
+	}
+	first()
+	first()
+	if d := waited(50 * time.Millisecond); d < 50*time.Millisecond {
+		t.Errorf("waited %s with an admission in flight; want the maximum wait", d)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		second()
+	}()
+	if d := waited(time.Second); d > 500*time.Millisecond {
+		t.Errorf("waited %s for the last admission to finish; want it to end the wait", d)
+	}
+
+	g.started()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := g.wait(ctx, time.Second); err != context.Canceled {
+		t.Errorf("wait() err = %v with a canceled context; want %v", err, context.Canceled)
+	}
+}
+
+func TestPreemptedByAdmission(t *testing.T) {
+	g := newAdmissionGate()
+	ctx, cancel := g.preempted(context.Background())
+	defer cancel()
+	select {
+	case <-ctx.Done():
+		t.Fatal("context canceled with no admission started")
+	case <-time.After(20 * time.Millisecond):
+	}
+	done := g.started()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context not canceled once an admission started")
+	}
+
+	// an admission already in flight preempts work started after it
+	ctx, cancel = g.preempted(context.Background())
+	defer cancel()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context not canceled while an admission is in flight")
+	}
+
+	done()
+	ctx, cancel = g.preempted(context.Background())
+	defer cancel()
+	select {
+	case <-ctx.Done():
+		t.Fatal("context canceled once every admission was done")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
@@ -0,0 +1,33 @@
+package util
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// bypassable returns the spec.bypassable of constraint, which defaults to true
+func bypassable(constraint *unstructured.Unstructured) (bool, error) {
+	v, found, err := unstructured.NestedFieldNoCopy(constraint.Object, "spec", "bypassable")
+	if err != nil || !found || v == nil {
+		return true, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return true, fmt.Errorf("bypassable %v is not a boolean", v)
+	}
+	return b, nil
+}
+
+// Bypassable returns false if constraint sets spec.bypassable to false, in which case it is
+// enforced even on the admission requests that exemptions allow without review
+func Bypassable(constraint *unstructured.Unstructured) bool {
+	b, err := bypassable(constraint)
+	return err != nil || b
+}
+
+// ValidateBypassable returns an error if constraint sets a bypassable that is not a boolean
+func ValidateBypassable(constraint *unstructured.Unstructured) error {
+	_, err := bypassable(constraint)
+	return err
+}
@@ -0,0 +1,35 @@
+package util
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestBypassable(t *testing.T) {
+	tc := []struct {
+		Name       string
+		Bypassable interface{}
+		Want       bool
+		WantErr    bool
+	}{
+		{Name: "unset", Bypassable: nil, Want: true},
+		{Name: "true", Bypassable: true, Want: true},
+		{Name: "false", Bypassable: false, Want: false},
+		{Name: "string", Bypassable: "false", Want: true, WantErr: true},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			cstr := &unstructured.Unstructured{Object: map[string]interface{}{"spec": map[string]interface{}{}}}
+			if tt.Bypassable != nil {
+				cstr.Object["spec"].(map[string]interface{})["bypassable"] = tt.Bypassable
+			}
+			if got := Bypassable(cstr); got != tt.Want {
+				t.Errorf("Bypassable() = %t; want %t", got, tt.Want)
+			}
+			if err := ValidateBypassable(cstr); (err != nil) != tt.WantErr {
+				t.Errorf("ValidateBypassable() err = %v; want error %t", err, tt.WantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package util
+
+import (
+	"flag"
+
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var maxConcurrentReconciles = flag.Int("max-concurrent-reconciles", 1, "number of objects each constraint template, constraint and config controller reconciles at once. higher values load templates and constraints faster on large clusters at the cost of more contention on the OPA client. defaulted to 1 if unspecified ")
+
+// ControllerOptions returns the options of a controller reconciling with r, honoring
+// --max-concurrent-reconciles
+func ControllerOptions(r reconcile.Reconciler) controller.Options {
+	return controller.Options{Reconciler: r, MaxConcurrentReconciles: *maxConcurrentReconciles}
+}
@@ -0,0 +1,21 @@
+package util
+
+import (
+	"flag"
+	"testing"
+
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestControllerOptions(t *testing.T) {
+	r := reconcile.Func(func(reconcile.Request) (reconcile.Result, error) { return reconcile.Result{}, nil })
+	if opts := ControllerOptions(r); opts.MaxConcurrentReconciles != 1 || opts.Reconciler == nil {
+		t.Errorf("ControllerOptions() = %+v; want 1 concurrent reconcile with the reconciler", opts)
+	}
+
+	defer flag.Set("max-concurrent-reconciles", "1")
+	flag.Set("max-concurrent-reconciles", "4")
+	if opts := ControllerOptions(r); opts.MaxConcurrentReconciles != 4 {
+		t.Errorf("MaxConcurrentReconciles = %d; want 4", opts.MaxConcurrentReconciles)
+	}
+}
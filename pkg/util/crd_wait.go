@@ -0,0 +1,51 @@
+package util
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+var crdWaitTimeout = flag.Duration("crd-wait-timeout", 0, "how long to wait at startup for the Gatekeeper CRDs to be installed before giving up, for example 5m. the CRDs are not checked for at startup if unspecified or 0")
+
+// crdWaitInterval is how often WaitForCRDs checks for the CRDs
+var crdWaitInterval = 5 * time.Second
+
+var crdLog = logf.Log.WithName("crd-wait")
+
+// WaitForCRDs blocks until the kinds in gvks are served, as reported by a RESTMapper freshly
+// obtained from newMapper on every check, or --crd-wait-timeout has elapsed. It returns
+// immediately if --crd-wait-timeout is unset.
+func WaitForCRDs(newMapper func() (meta.RESTMapper, error), gvks []schema.GroupVersionKind) error {
+	if *crdWaitTimeout <= 0 {
+		return nil
+	}
+	var missing []string
+	check := func() (bool, error) {
+		mapper, err := newMapper()
+		if err != nil {
+			crdLog.Info("unable to discover served kinds, retrying", "error", err.Error())
+			return false, nil
+		}
+		missing = nil
+		for _, gvk := range gvks {
+			if _, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+				missing = append(missing, gvk.String())
+			}
+		}
+		if len(missing) != 0 {
+			crdLog.Info("waiting for CRDs to be installed", "missing", missing, "timeout", crdWaitTimeout.String())
+			return false, nil
+		}
+		return true, nil
+	}
+	if err := wait.PollImmediate(crdWaitInterval, *crdWaitTimeout, check); err != nil {
+		return fmt.Errorf("CRDs were not installed within --crd-wait-timeout %s, missing: %v", *crdWaitTimeout, missing)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package util
+
+import (
+	"flag"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var templateGVK = schema.GroupVersionKind{Group: "templates.gatekeeper.sh", Version: "v1beta1", Kind: "ConstraintTemplate"}
+
+// delayedMapper returns RESTMappers that serve templateGVK once installed is called
+type delayedMapper struct {
+	mux       sync.Mutex
+	installed bool
+	calls     int
+}
+
+func (d *delayedMapper) install() {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.installed = true
+}
+
+func (d *delayedMapper) newMapper() (meta.RESTMapper, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.calls++
+	mapper := meta.NewDefaultRESTMapper(nil)
+	if d.installed {
+		mapper.Add(templateGVK, meta.RESTScopeRoot)
+	}
+	return mapper, nil
+}
+
+func TestWaitForCRDs(t *testing.T) {
+	defer flag.Set("crd-wait-timeout", "0")
+	defer func(interval time.Duration) { crdWaitInterval = interval }(crdWaitInterval)
+	crdWaitInterval = 10 * time.Millisecond
+
+	tc := []struct {
+		Name        string
+		Timeout     string
+		InstallIn   time.Duration
+		ExpectError bool
+	}{
+		{
+			Name:      "Installed",
+			Timeout:   "1s",
+			InstallIn: 0,
+		},
+		{
+			Name:      "Installed after a delay",
+			Timeout:   "5s",
+			InstallIn: 100 * time.Millisecond,
+		},
+		{
+			Name:        "Never installed",
+			Timeout:     "100ms",
+			InstallIn:   time.Hour,
+			ExpectError: true,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			flag.Set("crd-wait-timeout", tt.Timeout)
+			d := &delayedMapper{}
+			timer := time.AfterFunc(tt.InstallIn, d.install)
+			defer timer.Stop()
+			if tt.InstallIn == 0 {
+				d.install()
+			}
+			err := WaitForCRDs(d.newMapper, []schema.GroupVersionKind{templateGVK})
+			if (err != nil) != tt.ExpectError {
+				t.Errorf("WaitForCRDs() err = %v; want error %t", err, tt.ExpectError)
+			}
+		})
+	}
+}
+
+func TestWaitForCRDsDisabled(t *testing.T) {
+	d := &delayedMapper{}
+	if err := WaitForCRDs(d.newMapper, []schema.GroupVersionKind{templateGVK}); err != nil {
+		t.Errorf("WaitForCRDs() err = %s; want nil", err)
+	}
+	if d.calls != 0 {
+		t.Errorf("WaitForCRDs() checked %d times with the wait disabled; want 0", d.calls)
+	}
+}
@@ -0,0 +1,68 @@
+package util
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// debug returns the spec.debug of constraint, which defaults to false
+func debug(constraint *unstructured.Unstructured) (bool, error) {
+	v, found, err := unstructured.NestedFieldNoCopy(constraint.Object, "spec", "debug")
+	if err != nil || !found || v == nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("debug %v is not a boolean", v)
+	}
+	return b, nil
+}
+
+// Debug returns true if constraint sets spec.debug to true, in which case its evaluations are
+// logged in detail
+func Debug(constraint *unstructured.Unstructured) bool {
+	b, err := debug(constraint)
+	return err == nil && b
+}
+
+// ValidateDebug returns an error if constraint sets a debug that is not a boolean
+func ValidateDebug(constraint *unstructured.Unstructured) error {
+	_, err := debug(constraint)
+	return err
+}
+
+// debugConstraints holds the loaded constraints that set spec.debug, so that admission only
+// evaluates constraints one at a time to log them while there are some
+type debugConstraints struct {
+	mux  sync.RWMutex
+	keys map[string]bool
+}
+
+var debugging = &debugConstraints{keys: make(map[string]bool)}
+
+// RecordDebugConstraint records whether constraint, which was loaded, sets spec.debug
+func RecordDebugConstraint(constraint *unstructured.Unstructured) {
+	debugging.mux.Lock()
+	defer debugging.mux.Unlock()
+	if Debug(constraint) {
+		debugging.keys[constraintKey(constraint)] = true
+	} else {
+		delete(debugging.keys, constraintKey(constraint))
+	}
+}
+
+// ForgetDebugConstraint records that constraint is no longer loaded
+func ForgetDebugConstraint(constraint *unstructured.Unstructured) {
+	debugging.mux.Lock()
+	defer debugging.mux.Unlock()
+	delete(debugging.keys, constraintKey(constraint))
+}
+
+// DebuggingConstraints returns true if a loaded constraint sets spec.debug
+func DebuggingConstraints() bool {
+	debugging.mux.RLock()
+	defer debugging.mux.RUnlock()
+	return len(debugging.keys) != 0
+}
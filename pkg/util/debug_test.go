@@ -0,0 +1,59 @@
+package util
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDebug(t *testing.T) {
+	tc := []struct {
+		Name    string
+		Debug   interface{}
+		Want    bool
+		WantErr bool
+	}{
+		{Name: "unset", Debug: nil, Want: false},
+		{Name: "true", Debug: true, Want: true},
+		{Name: "false", Debug: false, Want: false},
+		{Name: "string", Debug: "true", Want: false, WantErr: true},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			cstr := &unstructured.Unstructured{Object: map[string]interface{}{"spec": map[string]interface{}{}}}
+			if tt.Debug != nil {
+				cstr.Object["spec"].(map[string]interface{})["debug"] = tt.Debug
+			}
+			if got := Debug(cstr); got != tt.Want {
+				t.Errorf("Debug() = %t; want %t", got, tt.Want)
+			}
+			if err := ValidateDebug(cstr); (err != nil) != tt.WantErr {
+				t.Errorf("ValidateDebug() err = %v; want error %t", err, tt.WantErr)
+			}
+		})
+	}
+}
+
+func TestRecordDebugConstraint(t *testing.T) {
+	cstr := &unstructured.Unstructured{Object: map[string]interface{}{"spec": map[string]interface{}{"debug": true}}}
+	cstr.SetKind("K8sRequiredLabels")
+	cstr.SetName("owner")
+	defer ForgetDebugConstraint(cstr)
+
+	RecordDebugConstraint(cstr)
+	if !DebuggingConstraints() {
+		t.Errorf("DebuggingConstraints() = false with a loaded debug constraint")
+	}
+	// turning debug off is recorded when the constraint is loaded again
+	cstr.Object["spec"].(map[string]interface{})["debug"] = false
+	RecordDebugConstraint(cstr)
+	if DebuggingConstraints() {
+		t.Errorf("DebuggingConstraints() = true once debug is turned off")
+	}
+	cstr.Object["spec"].(map[string]interface{})["debug"] = true
+	RecordDebugConstraint(cstr)
+	ForgetDebugConstraint(cstr)
+	if DebuggingConstraints() {
+		t.Errorf("DebuggingConstraints() = true once the debug constraint is removed")
+	}
+}
@@ -0,0 +1,55 @@
+package util
+
+import (
+	"flag"
+	"sort"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/types"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var deterministicEval = flag.Bool("deterministic-eval", false, "order the results of admission reviews and audits by the kind and name of their constraint, then by the kind, namespace and name of their resource and by message, so denial messages, logged decisions and the violations kept in constraint status are the same from run to run")
+
+// DeterministicEval returns true if results are ordered as set by --deterministic-eval
+func DeterministicEval() bool {
+	return *deterministicEval
+}
+
+// SortResults orders results by the kind and name of their constraint, then by the kind,
+// namespace and name of their resource and by message, if --deterministic-eval is set. The
+// constraint framework returns results in no particular order.
+func SortResults(results []*types.Result) {
+	if !DeterministicEval() {
+		return
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return lessResult(results[i], results[j])
+	})
+}
+
+func lessResult(a, b *types.Result) bool {
+	ka, kb := resultKey(a), resultKey(b)
+	for i := range ka {
+		if ka[i] != kb[i] {
+			return ka[i] < kb[i]
+		}
+	}
+	return false
+}
+
+// resultKey lists the fields results are ordered by
+func resultKey(r *types.Result) [6]string {
+	var key [6]string
+	if r.Constraint != nil {
+		key[0] = r.Constraint.GetKind()
+		key[1] = r.Constraint.GetNamespace() + "/" + r.Constraint.GetName()
+	}
+	// the target fills in the resource of audit results
+	if resource, ok := r.Resource.(*unstructured.Unstructured); ok && resource != nil {
+		key[2] = resource.GetKind()
+		key[3] = resource.GetNamespace()
+		key[4] = resource.GetName()
+	}
+	key[5] = r.Msg
+	return key
+}
@@ -0,0 +1,63 @@
+package util
+
+import (
+	"flag"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/types"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestSortResults(t *testing.T) {
+	defer flag.Set("deterministic-eval", "false")
+	flag.Set("deterministic-eval", "true")
+
+	object := func(kind, namespace, name string) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{}
+		u.SetKind(kind)
+		u.SetNamespace(namespace)
+		u.SetName(name)
+		return u
+	}
+	var results []*types.Result
+	for _, cstr := range []*unstructured.Unstructured{
+		object("K8sAllowedRepos", "", "repos"),
+		object("K8sRequiredLabels", "", "owner"),
+		object("K8sRequiredLabels", "", "team"),
+	} {
+		for _, resource := range []*unstructured.Unstructured{
+			object("Pod", "default", "a"),
+			object("Pod", "default", "b"),
+			object("Pod", "kube-system", "a"),
+			object("Service", "default", "a"),
+		} {
+			for _, msg := range []string{"first", "second"} {
+				results = append(results, &types.Result{Constraint: cstr, Resource: resource, Msg: msg})
+			}
+		}
+	}
+	want := make([]*types.Result, len(results))
+	copy(want, results)
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		shuffled := make([]*types.Result, len(results))
+		for j, k := range r.Perm(len(results)) {
+			shuffled[j] = results[k]
+		}
+		SortResults(shuffled)
+		if !reflect.DeepEqual(shuffled, want) {
+			t.Fatalf("run %d: SortResults() of shuffled results differs from the expected order", i)
+		}
+	}
+
+	// results are left as they are by default
+	flag.Set("deterministic-eval", "false")
+	reversed := []*types.Result{results[1], results[0]}
+	SortResults(reversed)
+	if reversed[0] != results[1] {
+		t.Error("SortResults() reordered results without --deterministic-eval")
+	}
+}
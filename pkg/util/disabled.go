@@ -0,0 +1,14 @@
+package util
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// DisabledLabel is the label that disables the constraints it is set to "true" on, which are
+// neither enforced nor audited until it is removed
+const DisabledLabel = "gatekeeper.sh/disabled"
+
+// Disabled returns true if constraint is labeled with DisabledLabel set to "true"
+func Disabled(constraint *unstructured.Unstructured) bool {
+	return constraint.GetLabels()[DisabledLabel] == "true"
+}
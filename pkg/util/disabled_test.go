@@ -0,0 +1,29 @@
+package util
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDisabled(t *testing.T) {
+	tc := []struct {
+		Name   string
+		Labels map[string]string
+		Want   bool
+	}{
+		{Name: "unlabeled", Labels: nil, Want: false},
+		{Name: "true", Labels: map[string]string{DisabledLabel: "true"}, Want: true},
+		{Name: "false", Labels: map[string]string{DisabledLabel: "false"}, Want: false},
+		{Name: "other label", Labels: map[string]string{"team": "payments"}, Want: false},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			cstr := &unstructured.Unstructured{Object: map[string]interface{}{}}
+			cstr.SetLabels(tt.Labels)
+			if got := Disabled(cstr); got != tt.Want {
+				t.Errorf("Disabled() = %t; want %t", got, tt.Want)
+			}
+		})
+	}
+}
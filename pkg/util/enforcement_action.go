@@ -0,0 +1,87 @@
+package util
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/types"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var defaultEnforcementAction = flag.String("default-enforcement-action", "deny", "enforcementAction applied to constraints that do not specify one. For example, deny, dryrun. Defaulted to deny if unspecified.")
+
+// SupportedEnforcementActions lists the values accepted for a constraint's enforcementAction
+var SupportedEnforcementActions = []string{
+	"deny",
+	"dryrun",
+}
+
+// ValidateEnforcementAction returns an error if input is not a supported enforcementAction
+func ValidateEnforcementAction(input string) error {
+	for _, n := range SupportedEnforcementActions {
+		if input == n {
+			return nil
+		}
+	}
+	return fmt.Errorf("Could not find the provided enforcementAction value within the supported list %v", SupportedEnforcementActions)
+}
+
+// ValidateDefaultEnforcementAction returns an error if --default-enforcement-action is not supported
+func ValidateDefaultEnforcementAction() error {
+	if err := ValidateEnforcementAction(*defaultEnforcementAction); err != nil {
+		return fmt.Errorf("invalid --default-enforcement-action: %s", err)
+	}
+	return nil
+}
+
+// EnforcementAction returns the enforcementAction of constraint, or the value of
+// --default-enforcement-action if it does not specify one
+func EnforcementAction(constraint *unstructured.Unstructured) string {
+	action, found, err := unstructured.NestedString(constraint.Object, "spec", "enforcementAction")
+	if err != nil || !found || action == "" {
+		return *defaultEnforcementAction
+	}
+	return action
+}
+
+// ResolveEnforcementActions sets the enforcementAction each of results is acted on with, which
+// may differ from the one its constraint specifies. In order:
+//
+//   - results whose constraint does not specify one get the value of
+//     --default-enforcement-action. The constraint framework always reports them as "deny".
+//   - denials by constraints still within their grace period, set by
+//     --constraint-grace-period or their denyAfter, become "dryrun".
+//   - denials by constraints whose violationThreshold is not exceeded become "dryrun".
+func ResolveEnforcementActions(results []*types.Result) {
+	applyDefaultEnforcementAction(results)
+	applyGracePeriods(results, time.Now())
+	applyViolationThresholds(results)
+}
+
+// applyDefaultEnforcementAction replaces the enforcementAction of results whose constraint does
+// not specify one with the value of --default-enforcement-action
+func applyDefaultEnforcementAction(results []*types.Result) {
+	for _, r := range results {
+		if r.Constraint == nil {
+			continue
+		}
+		action, found, err := unstructured.NestedString(r.Constraint.Object, "spec", "enforcementAction")
+		if err != nil || !found || action == "" {
+			r.EnforcementAction = *defaultEnforcementAction
+		}
+	}
+}
+
+// applyGracePeriods reports the denials of constraints still within their grace period at now
+// as dryrun
+func applyGracePeriods(results []*types.Result, now time.Time) {
+	for _, r := range results {
+		if r.Constraint == nil {
+			continue
+		}
+		if r.EnforcementAction == "deny" && GracePeriodRemaining(r.Constraint, now) > 0 {
+			r.EnforcementAction = "dryrun"
+		}
+	}
+}
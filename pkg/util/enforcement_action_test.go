@@ -0,0 +1,137 @@
+package util
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestValidateDefaultEnforcementAction(t *testing.T) {
+	defer flag.Set("default-enforcement-action", "deny")
+
+	for _, action := range SupportedEnforcementActions {
+		flag.Set("default-enforcement-action", action)
+		if err := ValidateDefaultEnforcementAction(); err != nil {
+			t.Errorf("ValidateDefaultEnforcementAction() with %s err = %s; want nil", action, err)
+		}
+	}
+	flag.Set("default-enforcement-action", "warn")
+	if err := ValidateDefaultEnforcementAction(); err == nil {
+		t.Error("ValidateDefaultEnforcementAction() with warn err = nil; want error")
+	}
+}
+
+func TestResolveEnforcementActions(t *testing.T) {
+	defer flag.Set("default-enforcement-action", "deny")
+	flag.Set("default-enforcement-action", "dryrun")
+
+	omitted := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{},
+	}}
+	explicit := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"enforcementAction": "deny"},
+	}}
+	results := []*types.Result{
+		{Constraint: omitted, EnforcementAction: "deny"},
+		{Constraint: explicit, EnforcementAction: "deny"},
+	}
+	ResolveEnforcementActions(results)
+	if results[0].EnforcementAction != "dryrun" {
+		t.Errorf("omitted enforcementAction = %s; want dryrun", results[0].EnforcementAction)
+	}
+	if results[1].EnforcementAction != "deny" {
+		t.Errorf("explicit enforcementAction = %s; want deny", results[1].EnforcementAction)
+	}
+}
+
+func TestResolveEnforcementActionsGracePeriod(t *testing.T) {
+	defer flag.Set("constraint-grace-period", "0")
+	flag.Set("constraint-grace-period", "1h")
+
+	createdAt := func(ago time.Duration) *unstructured.Unstructured {
+		cstr := &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec": map[string]interface{}{},
+		}}
+		if ago >= 0 {
+			cstr.SetCreationTimestamp(metav1.NewTime(time.Now().Add(-ago)))
+		}
+		return cstr
+	}
+	tc := []struct {
+		Name       string
+		Constraint *unstructured.Unstructured
+		Action     string
+		Want       string
+	}{
+		{Name: "within grace period", Constraint: createdAt(10 * time.Minute), Action: "deny", Want: "dryrun"},
+		{Name: "past grace period", Constraint: createdAt(2 * time.Hour), Action: "deny", Want: "deny"},
+		{Name: "no creationTimestamp", Constraint: createdAt(-1), Action: "deny", Want: "deny"},
+		{Name: "dryrun within grace period", Constraint: createdAt(10 * time.Minute), Action: "dryrun", Want: "dryrun"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			unstructured.SetNestedField(tt.Constraint.Object, tt.Action, "spec", "enforcementAction")
+			results := []*types.Result{{Constraint: tt.Constraint, EnforcementAction: tt.Action}}
+			ResolveEnforcementActions(results)
+			if results[0].EnforcementAction != tt.Want {
+				t.Errorf("enforcementAction = %s; want %s", results[0].EnforcementAction, tt.Want)
+			}
+		})
+	}
+
+	if got := GracePeriodRemaining(createdAt(10*time.Minute), time.Now()); got <= 49*time.Minute || got > 50*time.Minute {
+		t.Errorf("GracePeriodRemaining() = %s 10 minutes after creation; want about 50m", got)
+	}
+	flag.Set("constraint-grace-period", "0")
+	if got := GracePeriodRemaining(createdAt(10*time.Minute), time.Now()); got != 0 {
+		t.Errorf("GracePeriodRemaining() = %s without a grace period; want 0", got)
+	}
+}
+
+func TestResolveEnforcementActionsDenyAfter(t *testing.T) {
+	now := time.Now()
+	withDenyAfter := func(denyAfter string, created bool) *unstructured.Unstructured {
+		cstr := &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec": map[string]interface{}{"enforcementAction": "deny", "denyAfter": denyAfter},
+		}}
+		if created {
+			cstr.SetCreationTimestamp(metav1.NewTime(now.Add(-time.Hour)))
+		}
+		return cstr
+	}
+	tc := []struct {
+		Name       string
+		Constraint *unstructured.Unstructured
+		Want       string
+		WantErr    bool
+	}{
+		{Name: "before timestamp", Constraint: withDenyAfter(now.Add(time.Hour).UTC().Format(time.RFC3339), true), Want: "dryrun"},
+		{Name: "after timestamp", Constraint: withDenyAfter(now.Add(-time.Minute).UTC().Format(time.RFC3339), true), Want: "deny"},
+		{Name: "timestamp without creationTimestamp", Constraint: withDenyAfter(now.Add(time.Hour).UTC().Format(time.RFC3339), false), Want: "dryrun"},
+		{Name: "within duration", Constraint: withDenyAfter("2h", true), Want: "dryrun"},
+		{Name: "past duration", Constraint: withDenyAfter("30m", true), Want: "deny"},
+		{Name: "duration without creationTimestamp", Constraint: withDenyAfter("2h", false), Want: "deny"},
+		{Name: "invalid", Constraint: withDenyAfter("next week", true), Want: "deny", WantErr: true},
+		{Name: "negative", Constraint: withDenyAfter("-1h", true), Want: "deny", WantErr: true},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			results := []*types.Result{{Constraint: tt.Constraint, EnforcementAction: "deny"}}
+			ResolveEnforcementActions(results)
+			if results[0].EnforcementAction != tt.Want {
+				t.Errorf("enforcementAction = %s; want %s", results[0].EnforcementAction, tt.Want)
+			}
+			if err := ValidateDenyAfter(tt.Constraint); (err != nil) != tt.WantErr {
+				t.Errorf("ValidateDenyAfter() err = %v; want error %t", err, tt.WantErr)
+			}
+		})
+	}
+
+	if got := GracePeriodRemaining(withDenyAfter("2h", true), now); got <= 59*time.Minute || got > time.Hour {
+		t.Errorf("GracePeriodRemaining() = %s an hour after creation with denyAfter 2h; want about 1h", got)
+	}
+}
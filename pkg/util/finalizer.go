@@ -0,0 +1,44 @@
+package util
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+var finalizerPrefix = flag.String("finalizer-prefix", "", "prefix added to the names of finalizers managed by this instance, allowing multiple Gatekeeper instances to share a cluster without removing each other's finalizers. Must be a DNS-1123 label short enough for the prefixed finalizer names to remain valid qualified names. Unprefixed finalizer names are used if unspecified")
+
+// finalizerNames holds the unprefixed names of the finalizers managed by this instance
+var finalizerNames []string
+
+// RegisterFinalizerName records name as the unprefixed name of a finalizer managed by this
+// instance, so that ValidateFinalizerPrefix checks the name it is prefixed into
+func RegisterFinalizerName(name string) {
+	finalizerNames = append(finalizerNames, name)
+}
+
+// FinalizerName returns the finalizer name this instance uses in place of name
+func FinalizerName(name string) string {
+	if *finalizerPrefix == "" {
+		return name
+	}
+	return *finalizerPrefix + "." + name
+}
+
+// ValidateFinalizerPrefix returns an error if --finalizer-prefix would produce invalid finalizer names
+func ValidateFinalizerPrefix() error {
+	if *finalizerPrefix == "" {
+		return nil
+	}
+	if errs := validation.IsDNS1123Label(*finalizerPrefix); len(errs) != 0 {
+		return fmt.Errorf("invalid --finalizer-prefix %q: %s", *finalizerPrefix, strings.Join(errs, ", "))
+	}
+	for _, name := range finalizerNames {
+		if errs := validation.IsQualifiedName(FinalizerName(name)); len(errs) != 0 {
+			return fmt.Errorf("invalid --finalizer-prefix %q, finalizer %q: %s", *finalizerPrefix, FinalizerName(name), strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
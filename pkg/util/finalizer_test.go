@@ -0,0 +1,68 @@
+package util
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestFinalizerName(t *testing.T) {
+	defer flag.Set("finalizer-prefix", "")
+
+	tc := []struct {
+		Name     string
+		Prefix   string
+		Expected string
+	}{
+		{
+			Name:     "No prefix",
+			Prefix:   "",
+			Expected: "finalizers.gatekeeper.sh/sync",
+		},
+		{
+			Name:     "Prefix",
+			Prefix:   "tenant-a",
+			Expected: "tenant-a.finalizers.gatekeeper.sh/sync",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			flag.Set("finalizer-prefix", tt.Prefix)
+			if got := FinalizerName("finalizers.gatekeeper.sh/sync"); got != tt.Expected {
+				t.Errorf("FinalizerName() = %s; want %s", got, tt.Expected)
+			}
+		})
+	}
+}
+
+func TestValidateFinalizerPrefix(t *testing.T) {
+	defer flag.Set("finalizer-prefix", "")
+
+	for _, prefix := range []string{"", "tenant-a"} {
+		flag.Set("finalizer-prefix", prefix)
+		if err := ValidateFinalizerPrefix(); err != nil {
+			t.Errorf("ValidateFinalizerPrefix() with %q err = %s; want nil", prefix, err)
+		}
+	}
+	for _, prefix := range []string{"Tenant", "tenant/a", "tenant.a"} {
+		flag.Set("finalizer-prefix", prefix)
+		if err := ValidateFinalizerPrefix(); err == nil {
+			t.Errorf("ValidateFinalizerPrefix() with %q err = nil; want error", prefix)
+		}
+	}
+
+	// a valid prefix can still make a finalizer name too long
+	defer func(names []string) { finalizerNames = names }(finalizerNames)
+	finalizerNames = nil
+	RegisterFinalizerName("finalizers.gatekeeper.sh/sync")
+	RegisterFinalizerName("constrainttemplate.finalizers.gatekeeper.sh")
+	flag.Set("finalizer-prefix", "tenant-a")
+	if err := ValidateFinalizerPrefix(); err != nil {
+		t.Errorf("ValidateFinalizerPrefix() with %q err = %s; want nil", "tenant-a", err)
+	}
+	long := strings.Repeat("a", 30)
+	flag.Set("finalizer-prefix", long)
+	if err := ValidateFinalizerPrefix(); err == nil {
+		t.Errorf("ValidateFinalizerPrefix() with %q err = nil; want error for finalizer %q", long, FinalizerName("constrainttemplate.finalizers.gatekeeper.sh"))
+	}
+}
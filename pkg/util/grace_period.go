@@ -0,0 +1,69 @@
+package util
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var constraintGracePeriod = flag.Duration("constraint-grace-period", 0, "time after the creation of a constraint during which its violations are audited but do not deny admission, e.g. 24h, so teams can react to a new constraint before it blocks their deployments. constraints are enforced as soon as they are created if unspecified or 0")
+
+// GracePeriodRemaining returns how long constraint is still within its grace period at now, or
+// 0 if it is past it. The grace period ends --constraint-grace-period after its
+// creationTimestamp, or at its spec.denyAfter if that is later. Constraints without a
+// creationTimestamp, such as those loaded from files, have no grace period unless denyAfter is
+// a timestamp.
+func GracePeriodRemaining(constraint *unstructured.Unstructured, now time.Time) time.Duration {
+	var end time.Time
+	created := constraint.GetCreationTimestamp()
+	if *constraintGracePeriod > 0 && !created.IsZero() {
+		end = created.Add(*constraintGracePeriod)
+	}
+	if after, found, err := denyAfter(constraint); err == nil && found && after.After(end) {
+		end = after
+	}
+	remaining := end.Sub(now)
+	if end.IsZero() || remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// denyAfter returns the time set by the spec.denyAfter of constraint, before which its denials
+// are treated as dryrun. denyAfter is either an RFC3339 timestamp or a duration counted from
+// the creationTimestamp of constraint. found is false if it does not set one, or if it is a
+// duration and constraint has no creationTimestamp.
+func denyAfter(constraint *unstructured.Unstructured) (after time.Time, found bool, err error) {
+	v, found, err := unstructured.NestedFieldNoCopy(constraint.Object, "spec", "denyAfter")
+	if err != nil || !found || v == nil {
+		return time.Time{}, false, err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, true, fmt.Errorf("denyAfter %v is not a string", v)
+	}
+	if after, err := time.Parse(time.RFC3339, s); err == nil {
+		return after, true, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, true, fmt.Errorf("denyAfter %q is neither an RFC3339 timestamp nor a duration", s)
+	}
+	if d < 0 {
+		return time.Time{}, true, fmt.Errorf("denyAfter %q must not be negative", s)
+	}
+	created := constraint.GetCreationTimestamp()
+	if created.IsZero() {
+		return time.Time{}, false, nil
+	}
+	return created.Add(d), true, nil
+}
+
+// ValidateDenyAfter returns an error if constraint sets a denyAfter that is neither an RFC3339
+// timestamp nor a non-negative duration
+func ValidateDenyAfter(constraint *unstructured.Unstructured) error {
+	_, _, err := denyAfter(constraint)
+	return err
+}
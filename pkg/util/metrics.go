@@ -0,0 +1,74 @@
+package util
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricFamilies are the names of the groups of metrics that --metrics-enable can expose
+var MetricFamilies = []string{"validation", "audit", "sync", "watch", "cleanup"}
+
+var metricsEnable = flag.String("metrics-enable", "all", "comma-separated list of the metric families exposed on the metrics endpoint, of "+strings.Join(MetricFamilies, ", ")+". the metrics of the families left out are not registered. gatekeeper_build_info and the controller-runtime metrics are always exposed. defaulted to all if unspecified ")
+
+// metricCollectors are the collectors of each family, registered by RegisterMetrics
+var metricCollectors = make(map[string][]prometheus.Collector)
+
+// AddMetrics adds collectors to family, to be registered by RegisterMetrics if the family is
+// enabled. It is meant to be called from init, and panics if family is unknown
+func AddMetrics(family string, collectors ...prometheus.Collector) {
+	if !knownMetricFamily(family) {
+		panic(fmt.Sprintf("unknown metric family %q", family))
+	}
+	metricCollectors[family] = append(metricCollectors[family], collectors...)
+}
+
+func knownMetricFamily(family string) bool {
+	for _, f := range MetricFamilies {
+		if f == family {
+			return true
+		}
+	}
+	return false
+}
+
+// enabledMetricFamilies returns the families listed by --metrics-enable
+func enabledMetricFamilies() ([]string, error) {
+	if strings.TrimSpace(*metricsEnable) == "all" {
+		return MetricFamilies, nil
+	}
+	var families []string
+	for _, f := range strings.Split(*metricsEnable, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if !knownMetricFamily(f) {
+			return nil, fmt.Errorf("invalid --metrics-enable: unknown metric family %q, must be all or some of %s", f, strings.Join(MetricFamilies, ", "))
+		}
+		families = append(families, f)
+	}
+	return families, nil
+}
+
+// RegisterMetrics registers with r the collectors of the families enabled by --metrics-enable,
+// or returns an error if it lists an unknown family
+func RegisterMetrics(r prometheus.Registerer) error {
+	families, err := enabledMetricFamilies()
+	if err != nil {
+		return err
+	}
+	for _, f := range families {
+		for _, c := range metricCollectors[f] {
+			if err := r.Register(c); err != nil {
+				if _, ok := err.(prometheus.AlreadyRegisteredError); ok {
+					continue
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
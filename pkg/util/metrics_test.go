@@ -0,0 +1,60 @@
+package util
+
+import (
+	"flag"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRegisterMetrics(t *testing.T) {
+	defer flag.Set("metrics-enable", "all")
+	defer func(c map[string][]prometheus.Collector) { metricCollectors = c }(metricCollectors)
+	metricCollectors = make(map[string][]prometheus.Collector)
+	for _, f := range MetricFamilies {
+		AddMetrics(f, prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_" + f}))
+	}
+
+	tc := []struct {
+		Name    string
+		Enable  string
+		Want    []string
+		WantErr bool
+	}{
+		{Name: "all", Enable: "all", Want: []string{"test_audit", "test_cleanup", "test_sync", "test_validation", "test_watch"}},
+		{Name: "some", Enable: "validation, audit", Want: []string{"test_audit", "test_validation"}},
+		{Name: "repeated", Enable: "sync,sync", Want: []string{"test_sync"}},
+		{Name: "none", Enable: ""},
+		{Name: "unknown", Enable: "validation,latency", WantErr: true},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			flag.Set("metrics-enable", tt.Enable)
+			r := prometheus.NewRegistry()
+			err := RegisterMetrics(r)
+			if tt.WantErr {
+				if err == nil {
+					t.Fatal("RegisterMetrics() err = nil; want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RegisterMetrics() err = %s", err)
+			}
+			families, err := r.Gather()
+			if err != nil {
+				t.Fatalf("Could not gather metrics: %s", err)
+			}
+			var names []string
+			for _, f := range families {
+				names = append(names, f.GetName())
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tt.Want) {
+				t.Errorf("exposed metrics = %v; want %v", names, tt.Want)
+			}
+		})
+	}
+}
@@ -1,8 +1,17 @@
 package util
 
-import "os"
+import (
+	"flag"
+	"os"
+)
 
+var gatekeeperNamespace = flag.String("gatekeeper-namespace", "", "namespace Gatekeeper is installed in. defaulted to the POD_NAMESPACE environment variable, or gatekeeper-system if it is not set")
+
+// GetNamespace returns the namespace Gatekeeper is installed in
 func GetNamespace() string {
+	if *gatekeeperNamespace != "" {
+		return *gatekeeperNamespace
+	}
 	ns, found := os.LookupEnv("POD_NAMESPACE")
 	if !found {
 		return "gatekeeper-system"
@@ -0,0 +1,91 @@
+package util
+
+import (
+	"context"
+	"flag"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+var readOnly = flag.Bool("read-only", false, "never write to the API server, so Gatekeeper can run with a read-only service account. finalizers, status, constraint CRDs, the webhook configuration and upgraded resources are not written, and audit results are only logged")
+
+var dryRun = flag.Bool("dry-run", false, "evaluate admission requests and audit as usual, but allow every admission request and never write to the API server, as with --read-only. requests that would have been denied are logged and counted")
+
+var readOnlyLog = logf.Log.WithName("read-only")
+
+// ReadOnly returns true if Gatekeeper must not write to the API server, as is the case with
+// --read-only and --dry-run. Controllers then remove deleted objects from OPA without the
+// finalizers they cannot write.
+func ReadOnly() bool {
+	return *readOnly || *dryRun
+}
+
+// DryRun returns true if Gatekeeper must allow every admission request and not write to the
+// API server
+func DryRun() bool {
+	return *dryRun
+}
+
+var _ client.Client = &readOnlyClient{}
+
+// readOnlyClient reads with the client it wraps and drops every write
+type readOnlyClient struct {
+	client.Client
+}
+
+// NewReadOnlyClient returns a client that reads with c and drops every write, including
+// status writes, without calling c
+func NewReadOnlyClient(c client.Client) client.Client {
+	return &readOnlyClient{Client: c}
+}
+
+func (c *readOnlyClient) Create(ctx context.Context, obj runtime.Object) error {
+	return dropWrite("create", obj)
+}
+
+func (c *readOnlyClient) Update(ctx context.Context, obj runtime.Object) error {
+	return dropWrite("update", obj)
+}
+
+func (c *readOnlyClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOptionFunc) error {
+	return dropWrite("delete", obj)
+}
+
+func (c *readOnlyClient) Status() client.StatusWriter {
+	return c
+}
+
+func dropWrite(verb string, obj runtime.Object) error {
+	readOnlyLog.V(1).Info("dropping write in read-only mode", "verb", verb, "kind", obj.GetObjectKind().GroupVersionKind().Kind)
+	return nil
+}
+
+// NewManagerClient creates the client of a manager, which reads from cache, and drops every
+// write with --read-only. It is a manager.NewClientFunc.
+func NewManagerClient(cache cache.Cache, config *rest.Config, options client.Options) (client.Client, error) {
+	c, err := client.New(config, options)
+	if err != nil {
+		return nil, err
+	}
+	return managerClient(&client.DelegatingClient{
+		Reader: &client.DelegatingReader{
+			CacheReader:  cache,
+			ClientReader: c,
+		},
+		Writer:       c,
+		StatusClient: c,
+	}), nil
+}
+
+// managerClient returns c, or a client dropping the writes of c if Gatekeeper must not write to
+// the API server
+func managerClient(c client.Client) client.Client {
+	if ReadOnly() {
+		return NewReadOnlyClient(c)
+	}
+	return c
+}
@@ -0,0 +1,119 @@
+package util
+
+import (
+	"context"
+	"flag"
+	"reflect"
+	"strconv"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ client.Client = &recordingClient{}
+
+// recordingClient records the calls made to it
+type recordingClient struct {
+	calls []string
+}
+
+func (c *recordingClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	c.calls = append(c.calls, "get")
+	return nil
+}
+
+func (c *recordingClient) List(ctx context.Context, opts *client.ListOptions, list runtime.Object) error {
+	c.calls = append(c.calls, "list")
+	return nil
+}
+
+func (c *recordingClient) Create(ctx context.Context, obj runtime.Object) error {
+	c.calls = append(c.calls, "create")
+	return nil
+}
+
+func (c *recordingClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOptionFunc) error {
+	c.calls = append(c.calls, "delete")
+	return nil
+}
+
+func (c *recordingClient) Update(ctx context.Context, obj runtime.Object) error {
+	c.calls = append(c.calls, "update")
+	return nil
+}
+
+func (c *recordingClient) Status() client.StatusWriter {
+	return &recordingStatusWriter{c: c}
+}
+
+type recordingStatusWriter struct {
+	c *recordingClient
+}
+
+func (w *recordingStatusWriter) Update(ctx context.Context, obj runtime.Object) error {
+	w.c.calls = append(w.c.calls, "status update")
+	return nil
+}
+
+func TestReadOnlyClient(t *testing.T) {
+	rc := &recordingClient{}
+	c := NewReadOnlyClient(rc)
+	ctx := context.Background()
+	cm := &corev1.ConfigMap{}
+
+	for name, write := range map[string]func() error{
+		"Create":          func() error { return c.Create(ctx, cm) },
+		"Update":          func() error { return c.Update(ctx, cm) },
+		"Delete":          func() error { return c.Delete(ctx, cm) },
+		"Status().Update": func() error { return c.Status().Update(ctx, cm) },
+	} {
+		if err := write(); err != nil {
+			t.Errorf("%s() err = %s; want nil", name, err)
+		}
+	}
+	if len(rc.calls) != 0 {
+		t.Errorf("writes reached the wrapped client: %v", rc.calls)
+	}
+
+	if err := c.Get(ctx, client.ObjectKey{Name: "cm"}, cm); err != nil {
+		t.Errorf("Get() err = %s", err)
+	}
+	if err := c.List(ctx, &client.ListOptions{}, &corev1.ConfigMapList{}); err != nil {
+		t.Errorf("List() err = %s", err)
+	}
+	if len(rc.calls) != 2 || rc.calls[0] != "get" || rc.calls[1] != "list" {
+		t.Errorf("wrapped client calls = %v; want [get list]", rc.calls)
+	}
+}
+
+func TestDryRunManagerClient(t *testing.T) {
+	defer flag.Set("dry-run", "false")
+	ctx := context.Background()
+	cm := &corev1.ConfigMap{}
+	for _, dryRun := range []bool{false, true} {
+		flag.Set("dry-run", strconv.FormatBool(dryRun))
+		if ReadOnly() != dryRun || DryRun() != dryRun {
+			t.Errorf("dry-run %t: ReadOnly() = %t, DryRun() = %t; want both %t", dryRun, ReadOnly(), DryRun(), dryRun)
+		}
+		rc := &recordingClient{}
+		c := managerClient(rc)
+		if err := c.Get(ctx, client.ObjectKey{Name: "cm"}, cm); err != nil {
+			t.Errorf("dry-run %t: Get() err = %s", dryRun, err)
+		}
+		if err := c.Create(ctx, cm); err != nil {
+			t.Errorf("dry-run %t: Create() err = %s", dryRun, err)
+		}
+		if err := c.Status().Update(ctx, cm); err != nil {
+			t.Errorf("dry-run %t: Status().Update() err = %s", dryRun, err)
+		}
+		want := []string{"get", "create", "status update"}
+		if dryRun {
+			want = []string{"get"}
+		}
+		if !reflect.DeepEqual(rc.calls, want) {
+			t.Errorf("dry-run %t: wrapped client calls = %v; want %v", dryRun, rc.calls, want)
+		}
+	}
+}
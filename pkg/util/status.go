@@ -0,0 +1,101 @@
+package util
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var useStatusSubresource = flag.String("use-status-subresource", "auto", "whether the status of constraints and the config is written through the status subresource: true, false, or auto to use it for the kinds whose CRD enables it. with false, status changes are lost if the CRD enables the subresource. defaulted to auto if unspecified ")
+
+// noStatusSubresource records the kinds found to have no status subresource, keyed by
+// statusKey
+var noStatusSubresource sync.Map
+
+// statusKey identifies the kind of obj. Typed objects read by the client may not carry their
+// kind, so their type is part of the key.
+func statusKey(obj runtime.Object) string {
+	return fmt.Sprintf("%T/%s", obj, obj.GetObjectKind().GroupVersionKind().GroupKind())
+}
+
+// statusMode returns the value of --use-status-subresource for obj, resolving auto to false
+// for kinds found to have no status subresource
+func statusMode(obj runtime.Object) (string, error) {
+	switch *useStatusSubresource {
+	case "true", "false":
+		return *useStatusSubresource, nil
+	case "auto":
+		if _, ok := noStatusSubresource.Load(statusKey(obj)); ok {
+			return "false", nil
+		}
+		return "auto", nil
+	}
+	return "", fmt.Errorf("invalid --use-status-subresource %q, must be true, false or auto", *useStatusSubresource)
+}
+
+// UpdateStatus writes the status of obj with c, through the status subresource as set by
+// --use-status-subresource. Without the subresource, the whole object is updated.
+func UpdateStatus(ctx context.Context, c client.Client, obj runtime.Object) error {
+	mode, err := statusMode(obj)
+	if err != nil {
+		return err
+	}
+	if mode == "false" {
+		return c.Update(ctx, obj)
+	}
+	err = c.Status().Update(ctx, obj)
+	if mode == "true" || !errors.IsNotFound(err) {
+		return err
+	}
+	// the API server answers not found for kinds without a status subresource
+	if err := c.Update(ctx, obj); err != nil {
+		return err
+	}
+	noStatusSubresource.Store(statusKey(obj), true)
+	return nil
+}
+
+// UpdateWithStatus writes obj with c, including its status. With the status subresource, as
+// set by --use-status-subresource, the object is updated first, then its status.
+func UpdateWithStatus(ctx context.Context, c client.Client, obj runtime.Object) error {
+	mode, err := statusMode(obj)
+	if err != nil {
+		return err
+	}
+	if mode == "false" {
+		return c.Update(ctx, obj)
+	}
+	// updating the object returns the status stored by the API server, which the
+	// subresource keeps from being changed
+	withStatus := obj.DeepCopyObject()
+	if err := c.Update(ctx, obj); err != nil {
+		return err
+	}
+	updated, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	accessor, err := meta.Accessor(withStatus)
+	if err != nil {
+		return err
+	}
+	accessor.SetResourceVersion(updated.GetResourceVersion())
+	err = c.Status().Update(ctx, withStatus)
+	if mode == "auto" && errors.IsNotFound(err) {
+		// the object was updated along with its status
+		noStatusSubresource.Store(statusKey(obj), true)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(withStatus).Elem())
+	return nil
+}
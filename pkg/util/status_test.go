@@ -0,0 +1,174 @@
+package util
+
+import (
+	"context"
+	"flag"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ client.Client = &subresourceClient{}
+
+// subresourceClient stores a single object, as the API server does for a CRD with or without
+// the status subresource
+type subresourceClient struct {
+	recordingClient
+	subresource bool
+	stored      *unstructured.Unstructured
+	version     int
+}
+
+func (c *subresourceClient) Update(ctx context.Context, obj runtime.Object) error {
+	c.calls = append(c.calls, "update")
+	u := obj.(*unstructured.Unstructured)
+	stored := u.DeepCopy()
+	if c.subresource {
+		status, found, _ := unstructured.NestedFieldCopy(c.stored.Object, "status")
+		unstructured.RemoveNestedField(stored.Object, "status")
+		if found {
+			unstructured.SetNestedField(stored.Object, status, "status")
+		}
+	}
+	return c.store(stored, u)
+}
+
+func (c *subresourceClient) Status() client.StatusWriter {
+	return &subresourceStatusWriter{c: c}
+}
+
+// store saves stored as the next version of the object and returns it in obj
+func (c *subresourceClient) store(stored, obj *unstructured.Unstructured) error {
+	if obj.GetResourceVersion() != strconv.Itoa(c.version) {
+		return errors.NewConflict(schema.GroupResource{Resource: "things"}, obj.GetName(), nil)
+	}
+	c.version++
+	stored.SetResourceVersion(strconv.Itoa(c.version))
+	c.stored = stored
+	stored.DeepCopyInto(obj)
+	return nil
+}
+
+type subresourceStatusWriter struct {
+	c *subresourceClient
+}
+
+func (w *subresourceStatusWriter) Update(ctx context.Context, obj runtime.Object) error {
+	w.c.calls = append(w.c.calls, "update status")
+	u := obj.(*unstructured.Unstructured)
+	if !w.c.subresource {
+		return errors.NewNotFound(schema.GroupResource{Resource: "things"}, u.GetName())
+	}
+	stored := w.c.stored.DeepCopy()
+	status, _, _ := unstructured.NestedFieldCopy(u.Object, "status")
+	unstructured.SetNestedField(stored.Object, status, "status")
+	stored.SetResourceVersion(u.GetResourceVersion())
+	return w.c.store(stored, u)
+}
+
+func resetStatusSubresources() {
+	noStatusSubresource.Range(func(k, _ interface{}) bool {
+		noStatusSubresource.Delete(k)
+		return true
+	})
+}
+
+func TestUpdateWithStatus(t *testing.T) {
+	defer flag.Set("use-status-subresource", "auto")
+	tc := []struct {
+		Name        string
+		Mode        string
+		Subresource bool
+		// Calls are the requests made by the second update
+		Calls      []string
+		WantStatus bool
+		WantErr    bool
+	}{
+		{Name: "auto with subresource", Mode: "auto", Subresource: true, Calls: []string{"update", "update status"}, WantStatus: true},
+		{Name: "auto without subresource", Mode: "auto", Subresource: false, Calls: []string{"update"}, WantStatus: true},
+		{Name: "true with subresource", Mode: "true", Subresource: true, Calls: []string{"update", "update status"}, WantStatus: true},
+		{Name: "true without subresource", Mode: "true", Subresource: false, WantErr: true},
+		{Name: "false without subresource", Mode: "false", Subresource: false, Calls: []string{"update"}, WantStatus: true},
+		{Name: "false with subresource", Mode: "false", Subresource: true, Calls: []string{"update"}, WantStatus: false},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			resetStatusSubresources()
+			flag.Set("use-status-subresource", tt.Mode)
+			obj := &unstructured.Unstructured{}
+			obj.SetGroupVersionKind(schema.GroupVersionKind{Group: "constraints.gatekeeper.sh", Version: "v1beta1", Kind: "K8sDenyAll"})
+			obj.SetName("thing")
+			obj.SetResourceVersion("0")
+			c := &subresourceClient{subresource: tt.Subresource, stored: obj.DeepCopy()}
+
+			for i := 0; i < 2; i++ {
+				c.calls = nil
+				obj.SetFinalizers([]string{"f" + strconv.Itoa(i)})
+				unstructured.SetNestedField(obj.Object, int64(i), "status", "totalViolations")
+				err := UpdateWithStatus(context.Background(), c, obj)
+				if tt.WantErr {
+					if err == nil {
+						t.Fatal("UpdateWithStatus() err = nil; want an error")
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("UpdateWithStatus() err = %s", err)
+				}
+				if got := c.stored.GetFinalizers(); len(got) != 1 || got[0] != "f"+strconv.Itoa(i) {
+					t.Errorf("stored finalizers = %v; want [f%d]", got, i)
+				}
+				got, found, _ := unstructured.NestedInt64(c.stored.Object, "status", "totalViolations")
+				if stored := found && got == int64(i); stored != tt.WantStatus {
+					t.Errorf("status stored = %t; want %t", stored, tt.WantStatus)
+				}
+				if obj.GetResourceVersion() != c.stored.GetResourceVersion() {
+					t.Errorf("object resourceVersion = %s; want the stored %s", obj.GetResourceVersion(), c.stored.GetResourceVersion())
+				}
+			}
+			if !reflect.DeepEqual(c.calls, tt.Calls) {
+				t.Errorf("requests of the second update = %v; want %v", c.calls, tt.Calls)
+			}
+		})
+	}
+}
+
+func TestUpdateStatus(t *testing.T) {
+	defer flag.Set("use-status-subresource", "auto")
+	for _, subresource := range []bool{true, false} {
+		resetStatusSubresources()
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(schema.GroupVersionKind{Group: "constraints.gatekeeper.sh", Version: "v1beta1", Kind: "K8sDenyAll"})
+		obj.SetName("thing")
+		obj.SetResourceVersion("0")
+		c := &subresourceClient{subresource: subresource, stored: obj.DeepCopy()}
+		for i := 0; i < 2; i++ {
+			c.calls = nil
+			unstructured.SetNestedField(obj.Object, int64(i), "status", "totalViolations")
+			if err := UpdateStatus(context.Background(), c, obj); err != nil {
+				t.Fatalf("subresource %t: UpdateStatus() err = %s", subresource, err)
+			}
+			if got, _, _ := unstructured.NestedInt64(c.stored.Object, "status", "totalViolations"); got != int64(i) {
+				t.Errorf("subresource %t: stored totalViolations = %d; want %d", subresource, got, i)
+			}
+		}
+		want := []string{"update status"}
+		if !subresource {
+			want = []string{"update"}
+		}
+		if !reflect.DeepEqual(c.calls, want) {
+			t.Errorf("subresource %t: requests of the second update = %v; want %v", subresource, c.calls, want)
+		}
+	}
+
+	flag.Set("use-status-subresource", "sometimes")
+	if err := UpdateStatus(context.Background(), &subresourceClient{}, &unstructured.Unstructured{}); err == nil {
+		t.Error("UpdateStatus() err = nil with an invalid --use-status-subresource; want an error")
+	}
+}
@@ -0,0 +1,68 @@
+package util
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+)
+
+var strictSpecValidation = flag.Bool("strict-spec-validation", false, "report the fields of constraints and constraint templates that Gatekeeper does not know, such as misspelled ones, in their status. unknown fields are ignored without notice if unspecified")
+
+// StrictSpecValidation returns true if the unknown fields of constraints and templates must be
+// reported
+func StrictSpecValidation() bool {
+	return *strictSpecValidation
+}
+
+// UnknownFields returns the sorted paths, under path, of the fields of obj that are not in
+// known, the same object as decoded into its type. Lists are compared element by element.
+// Fields set to an empty value are not reported, as types omit them whether they know them
+// or not.
+func UnknownFields(path string, obj, known interface{}) []string {
+	var unknown []string
+	switch value := obj.(type) {
+	case map[string]interface{}:
+		knownMap, _ := known.(map[string]interface{})
+		for k, child := range value {
+			knownChild, ok := knownMap[k]
+			if !ok {
+				if !isEmpty(child) {
+					unknown = append(unknown, path+"."+k)
+				}
+				continue
+			}
+			unknown = append(unknown, UnknownFields(path+"."+k, child, knownChild)...)
+		}
+	case []interface{}:
+		knownList, _ := known.([]interface{})
+		for i, child := range value {
+			if i < len(knownList) {
+				unknown = append(unknown, UnknownFields(fmt.Sprintf("%s[%d]", path, i), child, knownList[i])...)
+			}
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// isEmpty returns true if v, a value decoded from JSON, is null, false, 0, "" or an empty list
+// or object
+func isEmpty(v interface{}) bool {
+	switch value := v.(type) {
+	case nil:
+		return true
+	case bool:
+		return !value
+	case string:
+		return value == ""
+	case int64:
+		return value == 0
+	case float64:
+		return value == 0
+	case map[string]interface{}:
+		return len(value) == 0
+	case []interface{}:
+		return len(value) == 0
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnknownFields(t *testing.T) {
+	obj := map[string]interface{}{
+		"known":   "a",
+		"typo":    "b",
+		"empty":   []interface{}{},
+		"nested":  map[string]interface{}{"known": int64(1), "extra": true},
+		"list":    []interface{}{map[string]interface{}{"known": "c", "extra": "d"}},
+		"unset":   nil,
+		"cleared": false,
+	}
+	known := map[string]interface{}{
+		"known":  "a",
+		"nested": map[string]interface{}{"known": int64(1)},
+		"list":   []interface{}{map[string]interface{}{"known": "c"}},
+	}
+	expected := []string{"spec.list[0].extra", "spec.nested.extra", "spec.typo"}
+	if got := UnknownFields("spec", obj, known); !reflect.DeepEqual(got, expected) {
+		t.Errorf("UnknownFields() = %v; want %v", got, expected)
+	}
+	if got := UnknownFields("spec", known, known); len(got) != 0 {
+		t.Errorf("UnknownFields() = %v for a known object; want none", got)
+	}
+}
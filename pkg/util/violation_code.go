@@ -0,0 +1,17 @@
+package util
+
+import (
+	"github.com/open-policy-agent/frameworks/constraint/pkg/types"
+)
+
+// ViolationCode returns the machine-readable reason code of a violation, or "" if it has
+// none. Templates set the code as a string under "code" in the details of a violation, for
+// example violation[{"msg": msg, "details": {"code": "missing_label"}}].
+func ViolationCode(r *types.Result) string {
+	details, ok := r.Metadata["details"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	code, _ := details["code"].(string)
+	return code
+}
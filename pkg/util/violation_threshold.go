@@ -0,0 +1,190 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/types"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// violationThreshold is the spec.violationThreshold of a constraint. Its denials are reported
+// as dryrun while the violations counted in its scope do not exceed denyAbove.
+type violationThreshold struct {
+	denyAbove int64
+	// namespaced counts the violations in the namespace of the violating object, instead of
+	// in the whole cluster
+	namespaced bool
+}
+
+// violationThresholdOf returns the spec.violationThreshold of constraint. found is false if it
+// does not set one.
+func violationThresholdOf(constraint *unstructured.Unstructured) (threshold violationThreshold, found bool, err error) {
+	v, found, err := unstructured.NestedFieldNoCopy(constraint.Object, "spec", "violationThreshold")
+	if err != nil || !found || v == nil {
+		return violationThreshold{}, false, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return violationThreshold{}, true, fmt.Errorf("violationThreshold %v is not an object", v)
+	}
+	for field := range m {
+		if field != "denyAbove" && field != "scope" {
+			return violationThreshold{}, true, fmt.Errorf("violationThreshold has unknown field %q, only denyAbove and scope are supported", field)
+		}
+	}
+	switch n := m["denyAbove"].(type) {
+	case int64:
+		threshold.denyAbove = n
+	case float64:
+		if n != math.Trunc(n) {
+			return violationThreshold{}, true, fmt.Errorf("violationThreshold.denyAbove %v is not an integer", n)
+		}
+		threshold.denyAbove = int64(n)
+	case nil:
+		return violationThreshold{}, true, fmt.Errorf("violationThreshold.denyAbove must be set")
+	default:
+		return violationThreshold{}, true, fmt.Errorf("violationThreshold.denyAbove %v is not an integer", n)
+	}
+	if threshold.denyAbove < 0 {
+		return violationThreshold{}, true, fmt.Errorf("violationThreshold.denyAbove %d must not be negative", threshold.denyAbove)
+	}
+	switch scope := m["scope"]; scope {
+	case nil, "Cluster":
+	case "Namespace":
+		threshold.namespaced = true
+	default:
+		return violationThreshold{}, true, fmt.Errorf("violationThreshold.scope %v is neither Cluster nor Namespace", scope)
+	}
+	return threshold, true, nil
+}
+
+// ValidateViolationThreshold returns an error if constraint sets a violationThreshold without a
+// non-negative denyAbove, or with a scope other than Cluster or Namespace
+func ValidateViolationThreshold(constraint *unstructured.Unstructured) error {
+	_, _, err := violationThresholdOf(constraint)
+	return err
+}
+
+// violatingObject identifies the object of a violation
+type violatingObject struct {
+	group, kind, namespace, name string
+}
+
+// violatorOf returns the object whose review produced r
+func violatorOf(r *types.Result) violatingObject {
+	var obj violatingObject
+	review, ok := r.Review.(map[string]interface{})
+	if !ok {
+		return obj
+	}
+	obj.group, _, _ = unstructured.NestedString(review, "kind", "group")
+	obj.kind, _, _ = unstructured.NestedString(review, "kind", "kind")
+	obj.namespace, _, _ = unstructured.NestedString(review, "namespace")
+	obj.name, _, _ = unstructured.NestedString(review, "name")
+	return obj
+}
+
+func constraintKey(constraint *unstructured.Unstructured) string {
+	return fmt.Sprintf("%s/%s/%s", constraint.GetKind(), constraint.GetNamespace(), constraint.GetName())
+}
+
+// auditedViolations holds the violations found by the last audit of the constraints that set a
+// violationThreshold, so that admission counts the violations of objects already in the cluster
+type auditedViolations struct {
+	mux sync.RWMutex
+	// byConstraint[constraint][object] is the number of violations of constraint by object
+	byConstraint map[string]map[violatingObject]int64
+}
+
+var audited = &auditedViolations{byConstraint: make(map[string]map[violatingObject]int64)}
+
+// RecordAuditedViolations replaces the violations found by the last audit with results, which
+// must be the results of every constraint
+func RecordAuditedViolations(results []*types.Result) {
+	byConstraint := make(map[string]map[violatingObject]int64)
+	for _, r := range results {
+		if r.Constraint == nil {
+			continue
+		}
+		if _, found, err := violationThresholdOf(r.Constraint); err != nil || !found {
+			continue
+		}
+		key := constraintKey(r.Constraint)
+		if byConstraint[key] == nil {
+			byConstraint[key] = make(map[violatingObject]int64)
+		}
+		byConstraint[key][violatorOf(r)]++
+	}
+	audited.mux.Lock()
+	defer audited.mux.Unlock()
+	audited.byConstraint = byConstraint
+}
+
+// count returns the audited violations of constraint in namespace, or in the cluster if
+// namespaced is false, other than those by the objects in exclude
+func (a *auditedViolations) count(constraint string, namespaced bool, namespace string, exclude map[violatingObject]bool) int64 {
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+	var n int64
+	for obj, violations := range a.byConstraint[constraint] {
+		if exclude[obj] || (namespaced && obj.namespace != namespace) {
+			continue
+		}
+		n += violations
+	}
+	return n
+}
+
+// applyViolationThresholds reports the denials of constraints with a violationThreshold as
+// dryrun while the violations in their scope do not exceed it. The violations of the objects
+// under review in results replace those audited for them, so that an object is not counted
+// twice.
+func applyViolationThresholds(results []*types.Result) {
+	type scope struct{ constraint, namespace string }
+	thresholds := make(map[string]violationThreshold)
+	reviewed := make(map[string]map[violatingObject]bool)
+	counts := make(map[scope]int64)
+	for _, r := range results {
+		if r.Constraint == nil {
+			continue
+		}
+		threshold, found, err := violationThresholdOf(r.Constraint)
+		if err != nil || !found {
+			continue
+		}
+		key := constraintKey(r.Constraint)
+		thresholds[key] = threshold
+		obj := violatorOf(r)
+		if reviewed[key] == nil {
+			reviewed[key] = make(map[violatingObject]bool)
+		}
+		reviewed[key][obj] = true
+		s := scope{constraint: key}
+		if threshold.namespaced {
+			s.namespace = obj.namespace
+		}
+		counts[s]++
+	}
+	for s := range counts {
+		counts[s] += audited.count(s.constraint, thresholds[s.constraint].namespaced, s.namespace, reviewed[s.constraint])
+	}
+	for _, r := range results {
+		if r.Constraint == nil || r.EnforcementAction != "deny" {
+			continue
+		}
+		key := constraintKey(r.Constraint)
+		threshold, ok := thresholds[key]
+		if !ok {
+			continue
+		}
+		s := scope{constraint: key}
+		if threshold.namespaced {
+			s.namespace = violatorOf(r).namespace
+		}
+		if counts[s] <= threshold.denyAbove {
+			r.EnforcementAction = "dryrun"
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package util
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/types"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestValidateViolationThreshold(t *testing.T) {
+	tc := []struct {
+		Name      string
+		Threshold interface{}
+		WantErr   bool
+	}{
+		{Name: "unset", Threshold: nil},
+		{Name: "cluster", Threshold: map[string]interface{}{"denyAbove": int64(5)}},
+		{Name: "namespace", Threshold: map[string]interface{}{"denyAbove": float64(5), "scope": "Namespace"}},
+		{Name: "no denyAbove", Threshold: map[string]interface{}{"scope": "Cluster"}, WantErr: true},
+		{Name: "negative", Threshold: map[string]interface{}{"denyAbove": int64(-1)}, WantErr: true},
+		{Name: "fraction", Threshold: map[string]interface{}{"denyAbove": 2.5}, WantErr: true},
+		{Name: "unknown scope", Threshold: map[string]interface{}{"denyAbove": int64(5), "scope": "Team"}, WantErr: true},
+		{Name: "unknown field", Threshold: map[string]interface{}{"denyAbove": int64(5), "warnAbove": int64(1)}, WantErr: true},
+		{Name: "not an object", Threshold: int64(5), WantErr: true},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			cstr := &unstructured.Unstructured{Object: map[string]interface{}{"spec": map[string]interface{}{}}}
+			if tt.Threshold != nil {
+				cstr.Object["spec"].(map[string]interface{})["violationThreshold"] = tt.Threshold
+			}
+			if err := ValidateViolationThreshold(cstr); (err != nil) != tt.WantErr {
+				t.Errorf("ValidateViolationThreshold() err = %v; want error %t", err, tt.WantErr)
+			}
+		})
+	}
+}
+
+func thresholdConstraint(scope string) *unstructured.Unstructured {
+	threshold := map[string]interface{}{"denyAbove": int64(5)}
+	if scope != "" {
+		threshold["scope"] = scope
+	}
+	cstr := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"enforcementAction": "deny", "violationThreshold": threshold},
+	}}
+	cstr.SetKind("K8sRequiredLabels")
+	cstr.SetName("owner")
+	return cstr
+}
+
+// violations returns a deny result of constraint for each of n pods in namespace, named with
+// prefix
+func violations(constraint *unstructured.Unstructured, namespace, prefix string, n int) []*types.Result {
+	var results []*types.Result
+	for i := 0; i < n; i++ {
+		results = append(results, &types.Result{
+			Constraint:        constraint,
+			EnforcementAction: "deny",
+			Review: map[string]interface{}{
+				"kind":      map[string]interface{}{"group": "", "version": "v1", "kind": "Pod"},
+				"namespace": namespace,
+				"name":      fmt.Sprintf("%s-%d", prefix, i),
+			},
+		})
+	}
+	return results
+}
+
+func actions(results []*types.Result) map[string]int {
+	counts := make(map[string]int)
+	for _, r := range results {
+		counts[r.EnforcementAction]++
+	}
+	return counts
+}
+
+func TestAuditViolationThreshold(t *testing.T) {
+	defer RecordAuditedViolations(nil)
+	cluster := thresholdConstraint("")
+	namespaced := thresholdConstraint("Namespace")
+	tc := []struct {
+		Name       string
+		Results    []*types.Result
+		WantDeny   int
+		WantDryrun int
+	}{
+		{Name: "at the threshold", Results: violations(cluster, "a", "pod", 5), WantDryrun: 5},
+		{Name: "above the threshold", Results: violations(cluster, "a", "pod", 6), WantDeny: 6},
+		{Name: "across namespaces", Results: append(violations(cluster, "a", "pod", 3), violations(cluster, "b", "pod", 3)...), WantDeny: 6},
+		{Name: "within each namespace", Results: append(violations(namespaced, "a", "pod", 3), violations(namespaced, "b", "pod", 3)...), WantDryrun: 6},
+		{Name: "above in one namespace", Results: append(violations(namespaced, "a", "pod", 6), violations(namespaced, "b", "pod", 2)...), WantDeny: 6, WantDryrun: 2},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			RecordAuditedViolations(tt.Results)
+			ResolveEnforcementActions(tt.Results)
+			// recomputing the actions must not change them
+			ResolveEnforcementActions(tt.Results)
+			got := actions(tt.Results)
+			if got["deny"] != tt.WantDeny || got["dryrun"] != tt.WantDryrun {
+				t.Errorf("got %d deny and %d dryrun; want %d and %d", got["deny"], got["dryrun"], tt.WantDeny, tt.WantDryrun)
+			}
+		})
+	}
+}
+
+func TestAdmissionViolationThreshold(t *testing.T) {
+	defer RecordAuditedViolations(nil)
+	cluster := thresholdConstraint("")
+	namespaced := thresholdConstraint("Namespace")
+	tc := []struct {
+		Name    string
+		Audited []*types.Result
+		Request []*types.Result
+		Want    string
+	}{
+		{Name: "new violation within the threshold", Audited: violations(cluster, "a", "pod", 4), Request: violations(cluster, "a", "new", 1), Want: "dryrun"},
+		{Name: "new violation crossing the threshold", Audited: violations(cluster, "a", "pod", 5), Request: violations(cluster, "a", "new", 1), Want: "deny"},
+		{Name: "update of an audited object", Audited: violations(cluster, "a", "pod", 5), Request: violations(cluster, "a", "pod", 1), Want: "dryrun"},
+		{Name: "other namespaces not counted", Audited: violations(namespaced, "b", "pod", 5), Request: violations(namespaced, "a", "new", 1), Want: "dryrun"},
+		{Name: "namespace crossing the threshold", Audited: violations(namespaced, "a", "pod", 5), Request: violations(namespaced, "a", "new", 1), Want: "deny"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			RecordAuditedViolations(tt.Audited)
+			ResolveEnforcementActions(tt.Request)
+			if got := tt.Request[0].EnforcementAction; got != tt.Want {
+				t.Errorf("enforcementAction = %s; want %s", got, tt.Want)
+			}
+		})
+	}
+}
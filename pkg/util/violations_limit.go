@@ -0,0 +1,49 @@
+package util
+
+import (
+	"fmt"
+	"math"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// auditViolationsLimit returns the spec.auditViolationsLimit of constraint. found is false if
+// it does not set one.
+func auditViolationsLimit(constraint *unstructured.Unstructured) (limit int64, found bool, err error) {
+	v, found, err := unstructured.NestedFieldNoCopy(constraint.Object, "spec", "auditViolationsLimit")
+	if err != nil || !found || v == nil {
+		return 0, false, err
+	}
+	switch n := v.(type) {
+	case int64:
+		limit = n
+	case float64:
+		if n != math.Trunc(n) {
+			return 0, true, fmt.Errorf("auditViolationsLimit %v is not an integer", n)
+		}
+		limit = int64(n)
+	default:
+		return 0, true, fmt.Errorf("auditViolationsLimit %v is not an integer", v)
+	}
+	if limit < 0 {
+		return 0, true, fmt.Errorf("auditViolationsLimit %d must not be negative", limit)
+	}
+	return limit, true, nil
+}
+
+// AuditViolationsLimit returns the maximum number of violations of constraint that audit
+// reports, which is its spec.auditViolationsLimit, or defaultLimit if it does not set a valid one
+func AuditViolationsLimit(constraint *unstructured.Unstructured, defaultLimit int) int {
+	limit, found, err := auditViolationsLimit(constraint)
+	if err != nil || !found {
+		return defaultLimit
+	}
+	return int(limit)
+}
+
+// ValidateAuditViolationsLimit returns an error if constraint sets an auditViolationsLimit that
+// is not a non-negative integer
+func ValidateAuditViolationsLimit(constraint *unstructured.Unstructured) error {
+	_, _, err := auditViolationsLimit(constraint)
+	return err
+}
@@ -0,0 +1,38 @@
+package util
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestAuditViolationsLimit(t *testing.T) {
+	tc := []struct {
+		Name    string
+		Limit   interface{}
+		Want    int
+		WantErr bool
+	}{
+		{Name: "unset", Limit: nil, Want: 20},
+		{Name: "int", Limit: int64(50), Want: 50},
+		{Name: "float", Limit: float64(5), Want: 5},
+		{Name: "zero", Limit: int64(0), Want: 0},
+		{Name: "negative", Limit: int64(-1), Want: 20, WantErr: true},
+		{Name: "fraction", Limit: 2.5, Want: 20, WantErr: true},
+		{Name: "string", Limit: "10", Want: 20, WantErr: true},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			cstr := &unstructured.Unstructured{Object: map[string]interface{}{"spec": map[string]interface{}{}}}
+			if tt.Limit != nil {
+				cstr.Object["spec"].(map[string]interface{})["auditViolationsLimit"] = tt.Limit
+			}
+			if got := AuditViolationsLimit(cstr, 20); got != tt.Want {
+				t.Errorf("AuditViolationsLimit() = %d; want %d", got, tt.Want)
+			}
+			if err := ValidateAuditViolationsLimit(cstr); (err != nil) != tt.WantErr {
+				t.Errorf("ValidateAuditViolationsLimit() err = %v; want error %t", err, tt.WantErr)
+			}
+		})
+	}
+}
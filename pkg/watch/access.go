@@ -0,0 +1,71 @@
+package watch
+
+import (
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+var watchErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "gatekeeper_watch_errors_total",
+	Help: "Number of times a kind could not be listed and watched, e.g. because RBAC does not allow it yet. Watching the kind is retried until it succeeds",
+}, []string{"kind"})
+
+func init() {
+	util.AddMetrics("watch", watchErrorsTotal)
+}
+
+// checkListWatch returns an error if resource cannot be listed and watched with cfg
+func checkListWatch(cfg *rest.Config, resource schema.GroupVersionResource) error {
+	c, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	var limit int64 = 1
+	list, err := c.Resource(resource).List(metav1.ListOptions{Limit: limit})
+	if err != nil {
+		return err
+	}
+	var timeout int64 = 1
+	w, err := c.Resource(resource).Watch(metav1.ListOptions{ResourceVersion: list.GetResourceVersion(), TimeoutSeconds: &timeout})
+	if err != nil {
+		return err
+	}
+	w.Stop()
+	return nil
+}
+
+// filterAccessible returns the kinds that can be listed and watched. The others are left
+// out until a later update finds they can be, so granting the missing permissions starts
+// their watch without a restart. resources maps kinds to their resource, and kinds without
+// one are not checked.
+func (wm *WatchManager) filterAccessible(kinds map[schema.GroupVersionKind]watchVitals, resources map[schema.GroupVersionKind]schema.GroupVersionResource) map[schema.GroupVersionKind]watchVitals {
+	if wm.checkAccess == nil {
+		return kinds
+	}
+	if wm.failingKinds == nil {
+		wm.failingKinds = make(map[schema.GroupVersionKind]bool)
+	}
+	accessible := make(map[schema.GroupVersionKind]watchVitals)
+	for gvk, wv := range kinds {
+		if resource, ok := resources[gvk]; ok {
+			if err := wm.checkAccess(wm.cfg, resource); err != nil {
+				watchErrorsTotal.WithLabelValues(gvk.String()).Inc()
+				if !wm.failingKinds[gvk] {
+					log.Error(err, "unable to list and watch kind, retrying until it can be", "kind", gvk.String())
+					wm.failingKinds[gvk] = true
+				}
+				continue
+			}
+		}
+		if wm.failingKinds[gvk] {
+			log.Info("kind can now be listed and watched", "kind", gvk.String())
+			delete(wm.failingKinds, gvk)
+		}
+		accessible[gvk] = wv
+	}
+	return accessible
+}
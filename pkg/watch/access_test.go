@@ -0,0 +1,85 @@
+package watch
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	apiErr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+)
+
+// resourceDiscovery serves FooCRD as the resource foocrds
+type resourceDiscovery struct{}
+
+func (resourceDiscovery) ServerResourcesForGroupVersion(s string) (*metav1.APIResourceList, error) {
+	return &metav1.APIResourceList{GroupVersion: s, APIResources: []metav1.APIResource{
+		{Name: "foocrds", Kind: "FooCRD"},
+		{Name: "foocrds/status", Kind: "FooCRD"},
+	}}, nil
+}
+
+func TestForbiddenWatchRetried(t *testing.T) {
+	wm := newForTest(func(*rest.Config) (Discovery, error) { return resourceDiscovery{}, nil })
+	defer wm.close()
+	forbidden := true
+	var checked []schema.GroupVersionResource
+	wm.checkAccess = func(_ *rest.Config, resource schema.GroupVersionResource) error {
+		checked = append(checked, resource)
+		if forbidden {
+			return apiErr.NewForbidden(resource.GroupResource(), "", nil)
+		}
+		return nil
+	}
+	reg, err := wm.NewRegistrar("foo", nil)
+	if err != nil {
+		t.Fatalf("Error setting up registrar: %s", err)
+	}
+	gvk := makeGvk("FooCRD")
+	if err := reg.AddWatch(gvk); err != nil {
+		t.Fatalf("Error adding watch: %s", err)
+	}
+	errorCount := func() float64 {
+		m := &dto.Metric{}
+		if err := watchErrorsTotal.WithLabelValues(gvk.String()).Write(m); err != nil {
+			t.Fatalf("could not read watch errors metric: %s", err)
+		}
+		return m.GetCounter().GetValue()
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := wm.updateOrPause(); err != nil {
+			t.Fatalf("Could not update manager: %s", err)
+		}
+		if _, ok := wm.watchedKinds[gvk]; ok {
+			t.Fatalf("forbidden kind is watched")
+		}
+	}
+	if got := errorCount(); got != 2 {
+		t.Errorf("watch errors = %v; want 2", got)
+	}
+	expected := []schema.GroupVersionResource{{Resource: "foocrds"}, {Resource: "foocrds"}}
+	if len(checked) != len(expected) || checked[0] != expected[0] || checked[1] != expected[1] {
+		t.Errorf("checked resources = %v; want %v", checked, expected)
+	}
+
+	// access is granted
+	forbidden = false
+	restarted, err := wm.updateOrPause()
+	if err != nil {
+		t.Fatalf("Could not update manager: %s", err)
+	}
+	if !restarted {
+		t.Error("manager not restarted once the kind can be watched")
+	}
+	if _, ok := wm.watchedKinds[gvk]; !ok {
+		t.Error("kind not watched once it can be")
+	}
+	if wm.failingKinds[gvk] {
+		t.Error("kind still recorded as failing")
+	}
+	if got := errorCount(); got != 2 {
+		t.Errorf("watch errors = %v; want 2", got)
+	}
+}
@@ -36,6 +36,11 @@ type WatchManager struct {
 	watchedKinds map[schema.GroupVersionKind]watchVitals
 	cfg          *rest.Config
 	newDiscovery func(*rest.Config) (Discovery, error)
+	// checkAccess returns an error if a resource cannot be listed and watched. Kinds are not
+	// checked if it is nil.
+	checkAccess func(*rest.Config, schema.GroupVersionResource) error
+	// failingKinds are the kinds the last check could not list and watch
+	failingKinds map[schema.GroupVersionKind]bool
 }
 
 type Discovery interface {
@@ -56,6 +61,8 @@ func New(ctx context.Context, cfg *rest.Config) *WatchManager {
 		watchedKinds: make(map[schema.GroupVersionKind]watchVitals),
 		cfg:          cfg,
 		newDiscovery: newDiscovery,
+		checkAccess:  checkListWatch,
+		failingKinds: make(map[schema.GroupVersionKind]bool),
 	}
 	wm.managedKinds.mgr = wm
 	go wm.updateManagerLoop(ctx)
@@ -111,10 +118,14 @@ func (wm *WatchManager) updateManager() (bool, error) {
 	if err != nil {
 		return false, errp.Wrap(err, "error gathering watch changes, not restarting watch manager")
 	}
-	if wm.started == true && len(added) == 0 && len(removed) == 0 && len(changed) == 0 {
+	gone, err := wm.unservedKinds(removed)
+	if err != nil {
+		return false, errp.Wrap(err, "error checking watched kinds are served, not restarting watch manager")
+	}
+	if wm.started == true && len(added) == 0 && len(removed) == 0 && len(changed) == 0 && len(gone) == 0 {
 		return false, nil
 	}
-	var a, r, c []string
+	var a, r, c, g []string
 	for k, _ := range added {
 		a = append(a, k.String())
 	}
@@ -122,22 +133,28 @@ func (wm *WatchManager) updateManager() (bool, error) {
 		r = append(r, k.String())
 	}
 	for k, _ := range changed {
-		a = append(c, k.String())
+		c = append(c, k.String())
+	}
+	for k, _ := range gone {
+		g = append(g, k.String())
 	}
-	log.Info("Watcher registry found changes and/or needs restarting", "started", wm.started, "add", a, "remove", r, "change", c)
+	log.Info("Watcher registry found changes and/or needs restarting", "started", wm.started, "add", a, "remove", r, "change", c, "unserved", g)
 
 	readyToAdd, err := wm.filterPendingResources(added)
 	if err != nil {
 		return false, errp.Wrap(err, "could not filter pending resources, not restarting watch manager")
 	}
 
-	if wm.started == true && len(readyToAdd) == 0 && len(removed) == 0 && len(changed) == 0 {
+	if wm.started == true && len(readyToAdd) == 0 && len(removed) == 0 && len(changed) == 0 && len(gone) == 0 {
 		log.Info("Only changes are pending additions; not restarting watch manager")
 		return false, nil
 	}
 
 	newWatchedKinds := make(map[schema.GroupVersionKind]watchVitals)
 	for gvk, vitals := range wm.watchedKinds {
+		if _, ok := gone[gvk]; ok {
+			continue
+		}
 		if _, ok := removed[gvk]; !ok {
 			if newVitals, ok := changed[gvk]; ok {
 				newWatchedKinds[gvk] = newVitals
@@ -156,9 +173,41 @@ func (wm *WatchManager) updateManager() (bool, error) {
 	}
 
 	wm.watchedKinds = newWatchedKinds
+	for gvk, vitals := range gone {
+		log.Info("kind is no longer served, stopped watching it until it is", "kind", gvk.String())
+		for r := range vitals.registrars {
+			r.notifyKindRemoved(gvk)
+		}
+	}
 	return true, nil
 }
 
+// unservedKinds returns the watched kinds, other than those being removed, that the API server
+// no longer serves, e.g. because their CRD was deleted. Their informers would otherwise fail
+// forever. They stay in the intended watch set, so they are watched again once served.
+func (wm *WatchManager) unservedKinds(removed map[schema.GroupVersionKind]watchVitals) (map[schema.GroupVersionKind]watchVitals, error) {
+	gone := make(map[schema.GroupVersionKind]watchVitals)
+	watched := make(map[schema.GroupVersionKind]watchVitals)
+	for gvk, vitals := range wm.watchedKinds {
+		if _, ok := removed[gvk]; !ok {
+			watched[gvk] = vitals
+		}
+	}
+	if len(watched) == 0 {
+		return gone, nil
+	}
+	live, _, err := wm.liveResources(watched)
+	if err != nil {
+		return nil, err
+	}
+	for gvk, vitals := range watched {
+		if _, ok := live[gvk]; !ok {
+			gone[gvk] = vitals
+		}
+	}
+	return gone, nil
+}
+
 // updateManagerLoop looks for changes to the watch roster every 5 seconds. This method has a dual
 // benefit compared to restarting the manager every time a controller changes the watch
 // of placing an upper bound on how often the manager restarts.
@@ -203,6 +252,7 @@ func (wm *WatchManager) Pause() error {
 		case <-time.After(10 * time.Second):
 			return errors.New("timeout waiting for watch manager to pause")
 		}
+		wm.started = false
 	}
 	wm.paused = true
 	return nil
@@ -245,19 +295,27 @@ func (wm *WatchManager) restartManager(kinds map[schema.GroupVersionKind]watchVi
 		}
 	}
 
+	// Mark the manager as started before launching it so that a Pause() or restart that
+	// happens before the goroutine is scheduled still waits for it to stop
+	wm.started = true
 	go wm.startMgr(mgr, wm.stopper, wm.stopped, kindStr)
 	return nil
 }
 
-func (wm *WatchManager) startMgr(mgr manager.Manager, stopper chan struct{}, stopped chan<- struct{}, kinds []string) {
+func (wm *WatchManager) startMgr(mgr manager.Manager, stopper chan struct{}, stopped chan struct{}, kinds []string) {
 	log.Info("Calling Manager.Start()", "kinds", kinds)
-	wm.started = true
 	if err := mgr.Start(stopper); err != nil {
 		log.Error(err, "error starting watch manager")
 	}
 	// mgr.Start() only returns after the manager has completely stopped
-	wm.started = false
 	close(stopped)
+	// Pause() and restartManager() hold the lock while waiting on stopped, so
+	// only take it after closing the channel
+	wm.startedMux.Lock()
+	if wm.stopped == stopped {
+		wm.started = false
+	}
+	wm.startedMux.Unlock()
 	log.Info("sub-manager exiting", "kinds", kinds)
 }
 
@@ -302,6 +360,16 @@ func (wm *WatchManager) gatherChanges(managedKindsRaw map[string]map[schema.Grou
 }
 
 func (wm *WatchManager) filterPendingResources(kinds map[schema.GroupVersionKind]watchVitals) (map[schema.GroupVersionKind]watchVitals, error) {
+	liveResources, resources, err := wm.liveResources(kinds)
+	if err != nil {
+		return nil, err
+	}
+	return wm.filterAccessible(liveResources, resources), nil
+}
+
+// liveResources returns the kinds the API server serves, and the resources of those that have
+// one
+func (wm *WatchManager) liveResources(kinds map[schema.GroupVersionKind]watchVitals) (map[schema.GroupVersionKind]watchVitals, map[schema.GroupVersionKind]schema.GroupVersionResource, error) {
 	gvs := make(map[schema.GroupVersion]bool)
 	for gvk, _ := range kinds {
 		gvs[gvk.GroupVersion()] = true
@@ -309,9 +377,10 @@ func (wm *WatchManager) filterPendingResources(kinds map[schema.GroupVersionKind
 
 	discovery, err := wm.newDiscovery(wm.cfg)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	liveResources := make(map[schema.GroupVersionKind]watchVitals)
+	resources := make(map[schema.GroupVersionKind]schema.GroupVersionResource)
 	for gv, _ := range gvs {
 		rsrs, err := discovery.ServerResourcesForGroupVersion(gv.String())
 		if err != nil {
@@ -321,16 +390,19 @@ func (wm *WatchManager) filterPendingResources(kinds map[schema.GroupVersionKind
 					continue
 				}
 			}
-			return nil, err
+			return nil, nil, err
 		}
 		for _, r := range rsrs.APIResources {
 			gvk := gv.WithKind(r.Kind)
 			if wv, ok := kinds[gvk]; ok {
 				liveResources[gvk] = wv
+				if r.Name != "" && !strings.Contains(r.Name, "/") {
+					resources[gvk] = gv.WithResource(r.Name)
+				}
 			}
 		}
 	}
-	return liveResources, nil
+	return liveResources, resources, nil
 }
 
 func (wm *WatchManager) close() {
@@ -453,6 +525,25 @@ type Registrar struct {
 	parentName string
 	addFns     []func(manager.Manager, schema.GroupVersionKind) error
 	mgr        *WatchManager
+
+	removedMux sync.RWMutex
+	removedFns []func(schema.GroupVersionKind)
+}
+
+// OnKindRemoved registers fn to be called with each kind of the registrar whose watch was
+// stopped because the API server no longer serves it
+func (r *Registrar) OnKindRemoved(fn func(schema.GroupVersionKind)) {
+	r.removedMux.Lock()
+	defer r.removedMux.Unlock()
+	r.removedFns = append(r.removedFns, fn)
+}
+
+func (r *Registrar) notifyKindRemoved(gvk schema.GroupVersionKind) {
+	r.removedMux.RLock()
+	defer r.removedMux.RUnlock()
+	for _, fn := range r.removedFns {
+		fn(gvk)
+	}
 }
 
 // AddWatch registers a watch for the given kind
@@ -381,3 +381,111 @@ func TestRegistrar(t *testing.T) {
 		}
 	})
 }
+
+func TestPauseAndRemoveWatch(t *testing.T) {
+	wm := newForTest(newDiscoveryFactory(false, "FooCRD", "BarCRD"))
+	wm.stopped = make(chan struct{})
+	defer wm.close()
+	reg, err := wm.NewRegistrar("foo", nil)
+	if err != nil {
+		t.Fatalf("Error setting up registrar: %s", err)
+	}
+	if err := reg.ReplaceWatch([]schema.GroupVersionKind{makeGvk("FooCRD"), makeGvk("BarCRD")}); err != nil {
+		t.Fatalf("Error adding watches: %s", err)
+	}
+	if _, err := wm.updateOrPause(); err != nil {
+		t.Fatalf("Could not update manager: %s", err)
+	}
+
+	// Pausing immediately after a restart must still stop the new manager
+	running := wm.stopped
+	if err := reg.Pause(); err != nil {
+		t.Fatalf("Could not pause: %s", err)
+	}
+	select {
+	case <-running:
+	default:
+		t.Error("Manager still running after pause")
+	}
+
+	if err := reg.ReplaceWatch([]schema.GroupVersionKind{makeGvk("FooCRD")}); err != nil {
+		t.Fatalf("Error replacing watches: %s", err)
+	}
+	if b, err := wm.updateOrPause(); err != nil || b {
+		t.Errorf("updateOrPause() = %v, %v while paused; want false, nil", b, err)
+	}
+	if err := reg.Unpause(); err != nil {
+		t.Fatalf("Could not unpause: %s", err)
+	}
+	b, err := wm.updateOrPause()
+	if err != nil {
+		t.Fatalf("Could not update manager: %s", err)
+	}
+	if !b {
+		t.Error("Manager not restarted after removing a watch")
+	}
+	if _, ok := wm.watchedKinds[makeGvk("BarCRD")]; ok {
+		t.Error("BarCRD still watched after removal")
+	}
+	if _, ok := wm.watchedKinds[makeGvk("FooCRD")]; !ok {
+		t.Error("FooCRD no longer watched")
+	}
+}
+
+func TestUnservedKindRemoved(t *testing.T) {
+	wm := newForTest(newDiscoveryFactory(false, "FooCRD", "BarCRD"))
+	wm.stopped = make(chan struct{})
+	defer wm.close()
+	reg, err := wm.NewRegistrar("foo", nil)
+	if err != nil {
+		t.Fatalf("Error setting up registrar: %s", err)
+	}
+	var removed []schema.GroupVersionKind
+	reg.OnKindRemoved(func(gvk schema.GroupVersionKind) {
+		removed = append(removed, gvk)
+	})
+	if err := reg.ReplaceWatch([]schema.GroupVersionKind{makeGvk("FooCRD"), makeGvk("BarCRD")}); err != nil {
+		t.Fatalf("Error adding watches: %s", err)
+	}
+	if _, err := wm.updateManager(); err != nil {
+		t.Fatalf("Could not update manager: %s", err)
+	}
+
+	// deleting the CRD of BarCRD stops it from being served
+	wm.newDiscovery = newDiscoveryFactory(false, "FooCRD")
+	b, err := wm.updateManager()
+	if err != nil {
+		t.Fatalf("Could not update manager: %s", err)
+	}
+	if !b {
+		t.Error("Manager not restarted after a watched kind stopped being served")
+	}
+	if _, ok := wm.watchedKinds[makeGvk("BarCRD")]; ok {
+		t.Error("BarCRD still watched after it stopped being served")
+	}
+	if _, ok := wm.watchedKinds[makeGvk("FooCRD")]; !ok {
+		t.Error("FooCRD no longer watched")
+	}
+	if diff := cmp.Diff(removed, []schema.GroupVersionKind{makeGvk("BarCRD")}); diff != "" {
+		t.Errorf("removed kinds: %s", diff)
+	}
+
+	if b, err := wm.updateManager(); err != nil || b {
+		t.Errorf("updateManager() = %v, %v while BarCRD is not served; want false, nil", b, err)
+	}
+
+	wm.newDiscovery = newDiscoveryFactory(false, "FooCRD", "BarCRD")
+	b, err = wm.updateManager()
+	if err != nil {
+		t.Fatalf("Could not update manager: %s", err)
+	}
+	if !b {
+		t.Error("Manager not restarted once BarCRD is served again")
+	}
+	if _, ok := wm.watchedKinds[makeGvk("BarCRD")]; !ok {
+		t.Error("BarCRD not watched again once served")
+	}
+	if len(removed) != 1 {
+		t.Errorf("removed = %v, want only BarCRD", removed)
+	}
+}
@@ -0,0 +1,273 @@
+package webhook
+
+import (
+	"context"
+	"flag"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
+	"github.com/open-policy-agent/gatekeeper/pkg/controller/constrainttemplate"
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var templateErrorThreshold = flag.Int("template-error-threshold", 0, "number of consecutive evaluation errors after which a template is excluded from admission review until it is updated. templates are never excluded if unspecified or 0")
+
+// templateBreaker excludes templates from review after repeated evaluation errors, so one
+// broken template does not fail every request. Templates are identified by their kind. A
+// nil templateBreaker never excludes a template.
+type templateBreaker struct {
+	mux       sync.RWMutex
+	threshold int
+	failures  map[string]int
+	// tripped holds the error that excluded each excluded template
+	tripped map[string]error
+	// onTrip and onReset, if set, are called when a template is excluded and restored
+	onTrip  func(kind string, err error)
+	onReset func(kind string)
+}
+
+func newTemplateBreaker(threshold int) *templateBreaker {
+	return &templateBreaker{
+		threshold: threshold,
+		failures:  make(map[string]int),
+		tripped:   make(map[string]error),
+	}
+}
+
+// excluded returns true if the template of kind is excluded from review
+func (b *templateBreaker) excluded(kind string) bool {
+	if b == nil {
+		return false
+	}
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	_, ok := b.tripped[kind]
+	return ok
+}
+
+// cause returns the error that excluded the template of kind from review, or nil if it is not
+// excluded
+func (b *templateBreaker) cause(kind string) error {
+	if b == nil {
+		return nil
+	}
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.tripped[kind]
+}
+
+// excluding returns true if any template is excluded from review
+func (b *templateBreaker) excluding() bool {
+	if b == nil {
+		return false
+	}
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return len(b.tripped) != 0
+}
+
+// failing returns true if any template that is not yet excluded failed its last evaluation
+func (b *templateBreaker) failing() bool {
+	if b == nil {
+		return false
+	}
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return len(b.failures) != 0
+}
+
+// succeeded records that the template of kind evaluated without error
+func (b *templateBreaker) succeeded(kind string) {
+	if b == nil {
+		return
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	delete(b.failures, kind)
+}
+
+// failed records an evaluation error of the template of kind, excluding the template once
+// it has failed threshold times in a row
+func (b *templateBreaker) failed(kind string, err error) {
+	if b == nil {
+		return
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if _, ok := b.tripped[kind]; ok {
+		return
+	}
+	b.failures[kind]++
+	if b.failures[kind] < b.threshold {
+		return
+	}
+	delete(b.failures, kind)
+	b.tripped[kind] = err
+	templateEvaluationDisabledGauge.WithLabelValues(kind).Set(1)
+	log.Error(err, "excluding template from admission review after repeated evaluation errors, update the template to restore it", "kind", kind, "errors", b.threshold)
+	if b.onTrip != nil {
+		b.onTrip(kind, err)
+	}
+}
+
+// reset restores the template of kind and forgets its errors
+func (b *templateBreaker) reset(kind string) {
+	if b == nil {
+		return
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	delete(b.failures, kind)
+	if _, ok := b.tripped[kind]; !ok {
+		return
+	}
+	delete(b.tripped, kind)
+	templateEvaluationDisabledGauge.DeleteLabelValues(kind)
+	log.Info("restoring updated template to admission review", "kind", kind)
+	if b.onReset != nil {
+		b.onReset(kind)
+	}
+}
+
+// eventHandler resets the breaker for templates watched by an informer when their spec
+// changes or they are deleted
+func (b *templateBreaker) eventHandler() toolscache.ResourceEventHandler {
+	return toolscache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, obj interface{}) {
+			old, ok := oldObj.(*v1beta1.ConstraintTemplate)
+			if !ok {
+				return
+			}
+			templ, ok := obj.(*v1beta1.ConstraintTemplate)
+			if !ok {
+				return
+			}
+			if !reflect.DeepEqual(old.Spec, templ.Spec) {
+				b.reset(old.Spec.CRD.Spec.Names.Kind)
+				b.reset(templ.Spec.CRD.Spec.Names.Kind)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if templ, ok := obj.(*v1beta1.ConstraintTemplate); ok {
+				b.reset(templ.Spec.CRD.Spec.Names.Kind)
+			}
+		},
+	}
+}
+
+// recordEvaluated records that the templates of the constraints review was evaluated against
+// succeeded, so their errors are no longer consecutive
+func (h *validationHandler) recordEvaluated(ctx context.Context, review *admissionv1beta1.AdmissionRequest) {
+	constraints, err := h.reviewedConstraints(ctx, review)
+	if err != nil {
+		log.Error(err, "unable to list reviewed constraints")
+		return
+	}
+	for _, c := range constraints {
+		h.breaker.succeeded(c.GetKind())
+	}
+}
+
+// templateStatuses reports in the status of templates whether a breaker excludes them. The
+// statuses are written one at a time by Start, from the state of the breaker at the time of the
+// write, so a template restored while its exclusion is being written ends up reported as
+// restored.
+type templateStatuses struct {
+	breaker *templateBreaker
+	write   func(kind string, cause error)
+
+	mux sync.Mutex
+	// pending holds the kinds of the templates whose status is to be written
+	pending map[string]bool
+	// wake is signalled when a kind is queued
+	wake chan struct{}
+}
+
+// newTemplateStatuses returns the writer of the statuses of the templates b excludes, which
+// calls write with the kind of a template and the error that excluded it, or nil once it is
+// restored
+func newTemplateStatuses(b *templateBreaker, write func(kind string, cause error)) *templateStatuses {
+	return &templateStatuses{
+		breaker: b,
+		write:   write,
+		pending: make(map[string]bool),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// enqueue queues the write of the status of the template of kind. Kinds queued again before
+// their status is written are only written once.
+func (s *templateStatuses) enqueue(kind string) {
+	s.mux.Lock()
+	s.pending[kind] = true
+	s.mux.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next dequeues a kind whose status is to be written, if any
+func (s *templateStatuses) next() (string, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for kind := range s.pending {
+		delete(s.pending, kind)
+		return kind, true
+	}
+	return "", false
+}
+
+// Start writes the queued statuses until stop is closed. It implements manager.Runnable.
+func (s *templateStatuses) Start(stop <-chan struct{}) error {
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-s.wake:
+		}
+		for kind, ok := s.next(); ok; kind, ok = s.next() {
+			s.write(kind, s.breaker.cause(kind))
+		}
+	}
+}
+
+// setEvaluationDisabled reports in the status of the template of kind whether it is excluded
+// from review, which it is if cause is set. Templates are named after the lowercase of their kind.
+func setEvaluationDisabled(c client.Client, kind string, cause error) {
+	update := func() error {
+		templ := &v1beta1.ConstraintTemplate{}
+		if err := c.Get(context.Background(), types.NamespacedName{Name: strings.ToLower(kind)}, templ); err != nil {
+			return err
+		}
+		status := util.GetCTHAStatus(templ)
+		var errs []*v1beta1.CreateCRDError
+		for _, e := range status.Errors {
+			if e.Code != constrainttemplate.EvaluationDisabledCode {
+				errs = append(errs, e)
+			}
+		}
+		if cause != nil {
+			errs = append(errs, &v1beta1.CreateCRDError{
+				Code:    constrainttemplate.EvaluationDisabledCode,
+				Message: "excluded from admission review after repeated evaluation errors, update the template to restore it: " + cause.Error(),
+			})
+		}
+		status.Errors = errs
+		util.SetCTHAStatus(templ, status)
+		return c.Update(context.Background(), templ)
+	}
+	if err := retry.RetryOnConflict(retry.DefaultRetry, update); err != nil {
+		log.Error(err, "unable to update template status", "kind", kind)
+	}
+}
@@ -0,0 +1,195 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+	templv1beta1 "github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/core/templates"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	atypes "sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+func TestTemplateStatusesOrdering(t *testing.T) {
+	b := newTemplateBreaker(1)
+	type write struct {
+		kind     string
+		excluded bool
+	}
+	writes := make(chan write)
+	// release holds each write until the test lets it complete
+	release := make(chan struct{})
+	s := newTemplateStatuses(b, func(kind string, cause error) {
+		writes <- write{kind: kind, excluded: cause != nil}
+		<-release
+	})
+	b.onTrip = func(kind string, err error) { s.enqueue(kind) }
+	b.onReset = s.enqueue
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.Start(stop)
+	next := func() write {
+		select {
+		case w := <-writes:
+			return w
+		case <-time.After(5 * time.Second):
+			t.Fatal("status not written")
+		}
+		return write{}
+	}
+	noWrite := func() {
+		select {
+		case w := <-writes:
+			t.Fatalf("unexpected write %+v", w)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	b.failed("K8sBroken", errors.New("broken"))
+	if w := next(); w != (write{kind: "K8sBroken", excluded: true}) {
+		t.Fatalf("first write = %+v; want the exclusion", w)
+	}
+	// the template is restored while its exclusion is being written
+	b.reset("K8sBroken")
+	release <- struct{}{}
+	if w := next(); w != (write{kind: "K8sBroken", excluded: false}) {
+		t.Fatalf("second write = %+v; want the restoration", w)
+	}
+	release <- struct{}{}
+	noWrite()
+
+	// a trip and reset queued while another status is written are written once, as restored
+	b.failed("K8sBroken", errors.New("broken"))
+	if w := next(); w != (write{kind: "K8sBroken", excluded: true}) {
+		t.Fatalf("write = %+v; want the exclusion", w)
+	}
+	b.failed("K8sOther", errors.New("broken"))
+	b.reset("K8sOther")
+	release <- struct{}{}
+	if w := next(); w != (write{kind: "K8sOther", excluded: false}) {
+		t.Fatalf("write = %+v; want the restoration", w)
+	}
+	release <- struct{}{}
+	noWrite()
+}
+
+const (
+	conflicting_rego_template = `
+apiVersion: templates.gatekeeper.sh/v1beta1
+kind: ConstraintTemplate
+metadata:
+  name: k8sconflictingrego
+spec:
+  crd:
+    spec:
+      names:
+        kind: K8sConflictingRego
+        listKind: K8sConflictingRegoList
+        plural: k8sconflictingrego
+        singular: k8sconflictingrego
+  targets:
+    - target: admission.k8s.gatekeeper.sh
+      rego: |
+        package conflictingrego
+
+        value = 1 { true }
+        value = 2 { true }
+
+        violation[{"msg": msg}] {
+          value == 1
+          msg := "unreachable"
+        }
+`
+
+	conflicting_all_namespaces = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sConflictingRego
+metadata:
+  name: conflicting-all-namespaces
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Namespace"]
+`
+)
+
+func TestTemplateBreaker(t *testing.T) {
+	handler := makeDenyingHandler(t)
+	templ := &templv1beta1.ConstraintTemplate{}
+	if err := yaml.Unmarshal([]byte(conflicting_rego_template), templ); err != nil {
+		t.Fatalf("Could not instantiate template: %s", err)
+	}
+	unversioned := &templates.ConstraintTemplate{}
+	if err := runtimeScheme.Convert(templ, unversioned, nil); err != nil {
+		t.Fatalf("Could not convert to unversioned: %v", err)
+	}
+	if _, err := handler.opa.AddTemplate(context.Background(), unversioned); err != nil {
+		t.Fatalf("Could not add template: %s", err)
+	}
+	cnstr := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(conflicting_all_namespaces), &cnstr.Object); err != nil {
+		t.Fatalf("Could not instantiate constraint: %s", err)
+	}
+	if _, err := handler.opa.AddConstraint(context.Background(), cnstr); err != nil {
+		t.Fatalf("Could not add constraint: %s", err)
+	}
+	handler.breaker = newTemplateBreaker(2)
+	var trips, resets int
+	handler.breaker.onTrip = func(kind string, err error) { trips++ }
+	handler.breaker.onReset = func(kind string) { resets++ }
+	disabled := func() float64 {
+		m := &dto.Metric{}
+		if err := templateEvaluationDisabledGauge.WithLabelValues("K8sConflictingRego").Write(m); err != nil {
+			t.Fatalf("could not read metric: %s", err)
+		}
+		return m.GetGauge().GetValue()
+	}
+	review := func() atypes.Response {
+		return handler.Handle(context.Background(), namespaceRequest("foo"))
+	}
+
+	for i := 0; i < 2; i++ {
+		resp := review()
+		if resp.Response.Allowed || resp.Response.Result.Code != http.StatusInternalServerError {
+			t.Fatalf("request %d: got %+v; want an evaluation error", i, resp.Response.Result)
+		}
+		if !strings.Contains(string(resp.Response.Result.Reason), "K8sConflictingRego") {
+			t.Errorf("request %d: reason %q does not name the failing template", i, resp.Response.Result.Reason)
+		}
+	}
+	if !handler.breaker.excluded("K8sConflictingRego") || trips != 1 {
+		t.Fatalf("template not excluded after 2 errors; trips = %d", trips)
+	}
+	if handler.breaker.excluded("K8sGoodRego") {
+		t.Error("healthy template excluded")
+	}
+	if got := disabled(); got != 1 {
+		t.Errorf("gatekeeper_template_evaluation_disabled = %v; want 1", got)
+	}
+
+	resp := review()
+	if resp.Response.Result.Code != http.StatusForbidden || !strings.Contains(string(resp.Response.Result.Reason), "[denied by deny-all-namespaces]") {
+		t.Errorf("request with excluded template: got %+v; want a denial by the healthy template", resp.Response.Result)
+	}
+
+	// updating the template restores it
+	updated := templ.DeepCopy()
+	updated.Spec.Targets[0].Rego = updated.Spec.Targets[0].Rego + "\n"
+	handler.breaker.eventHandler().OnUpdate(templ, updated)
+	if handler.breaker.excluded("K8sConflictingRego") || resets != 1 {
+		t.Fatalf("template still excluded after update; resets = %d", resets)
+	}
+	if got := disabled(); got != 0 {
+		t.Errorf("gatekeeper_template_evaluation_disabled = %v after reset; want 0", got)
+	}
+	if resp := review(); resp.Response.Result.Code != http.StatusInternalServerError {
+		t.Errorf("request after reset: got %+v; want the template to be evaluated again", resp.Response.Result)
+	}
+}
@@ -0,0 +1,68 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	atypes "sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+// handleExempted decides a request that an exemption allows for reason. Only the matching
+// constraints that set spec.bypassable to false are reviewed, so that they hold even for
+// exempted namespaces, kinds or users. The request is allowed for reason unless they deny it.
+func (h *validationHandler) handleExempted(ctx context.Context, req atypes.Request, reason string) atypes.Response {
+	if h.driver == nil {
+		return admission.ValidationResponse(true, reason)
+	}
+	reviewCtx, cancel := reviewContext(ctx)
+	defer cancel()
+	review, err := h.trimRequest(req.AdmissionRequest)
+	if err != nil {
+		return exemptedErrorResponse(err)
+	}
+	constraints, err := h.reviewedConstraints(reviewCtx, review)
+	if err != nil {
+		return exemptedErrorResponse(err)
+	}
+	var nonBypassable []*unstructured.Unstructured
+	for _, c := range constraints {
+		if !util.Bypassable(c) {
+			nonBypassable = append(nonBypassable, c)
+		}
+	}
+	if len(nonBypassable) == 0 {
+		return admission.ValidationResponse(true, reason)
+	}
+	resp, err := h.reviewConstraints(reviewCtx, review, nonBypassable, false, *webhookShortCircuit)
+	if err != nil {
+		return exemptedErrorResponse(err)
+	}
+	vResp := validationResponse(resp)
+	admissionStatus := "allow"
+	if !vResp.Response.Allowed {
+		admissionStatus = "deny"
+	}
+	validationRequestsTotal.WithLabelValues(admissionStatus, strconv.FormatBool(isDryRun(req.AdmissionRequest))).Inc()
+	if vResp.Response.Allowed {
+		return admission.ValidationResponse(true, reason)
+	}
+	log.Info("denying exempted request by non-bypassable constraints", "kind", req.AdmissionRequest.Kind, "namespace", req.AdmissionRequest.Namespace, "name", req.AdmissionRequest.Name, "exemption", reason)
+	return vResp
+}
+
+// exemptedErrorResponse denies an exempted request whose non-bypassable constraints could not
+// be reviewed, as they must not be bypassed by failing
+func exemptedErrorResponse(err error) atypes.Response {
+	log.Error(err, "error reviewing non-bypassable constraints")
+	vResp := admission.ValidationResponse(false, err.Error())
+	if vResp.Response.Result == nil {
+		vResp.Response.Result = &metav1.Status{}
+	}
+	vResp.Response.Result.Code = http.StatusInternalServerError
+	return vResp
+}
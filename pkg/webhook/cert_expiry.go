@@ -0,0 +1,80 @@
+package webhook
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+var certDir = flag.String("webhook-cert-dir", "/certs", "directory holding the serving certificate and key of the webhook, cert.pem and key.pem. defaulted to /certs if unspecified ")
+
+// serverCertName is the name of the serving certificate in --webhook-cert-dir, as written by
+// the certificate provisioner of controller-runtime
+const serverCertName = "cert.pem"
+
+// certExpiryCheckInterval is how often the serving certificate is read, so that rotations are
+// reported
+var certExpiryCheckInterval = time.Minute
+
+var _ manager.Runnable = &certExpiryMonitor{}
+
+// certExpiryMonitor reports when the serving certificate of the webhook expires. Once it has,
+// the API server can no longer call the webhook and every admission request fails or is
+// allowed, depending on the webhook's failurePolicy.
+type certExpiryMonitor struct {
+	path string
+}
+
+// Start reads the certificate every certExpiryCheckInterval until stop is closed
+func (m *certExpiryMonitor) Start(stop <-chan struct{}) error {
+	ticker := time.NewTicker(certExpiryCheckInterval)
+	defer ticker.Stop()
+	for {
+		if err := m.check(); err != nil {
+			log.Error(err, "unable to read the webhook serving certificate", "path", m.path)
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// check sets gatekeeper_webhook_cert_expiry_seconds to the expiry of the certificate
+func (m *certExpiryMonitor) check() error {
+	notAfter, err := certExpiry(m.path)
+	if err != nil {
+		return err
+	}
+	webhookCertExpirySeconds.Set(float64(notAfter.Unix()))
+	return nil
+}
+
+// certExpiry returns the time the first certificate of the PEM file at path expires
+func certExpiry(path string) (time.Time, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return time.Time{}, fmt.Errorf("no certificate in %s", path)
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s: %s", path, err)
+		}
+		return cert.NotAfter, nil
+	}
+}
@@ -0,0 +1,151 @@
+package webhook
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	atypes "sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+var (
+	webhookMaxConcurrent             = flag.Int("webhook-max-concurrent", 0, "maximum number of admission requests reviewed at once. further requests wait for a review to finish until --webhook-timeout passes, and are then answered with a 429 error subject to the webhook's failurePolicy. requires a positive --webhook-timeout. unbounded if unspecified or 0")
+	perNamespaceAdmissionConcurrency = flag.Int("per-namespace-admission-concurrency", 0, "maximum number of admission requests for resources in the same namespace reviewed at once, so that a burst of requests in one namespace cannot hold every slot of --webhook-max-concurrent. further requests in the namespace wait as for --webhook-max-concurrent, without taking a slot of it. requests for cluster-scoped resources share a limit. requires a positive --webhook-timeout. unbounded if unspecified or 0")
+)
+
+// validateConcurrencyLimits returns an error if a limit of --webhook-max-concurrent or
+// --per-namespace-admission-concurrency is set without a timeout bounding the wait for a slot
+func validateConcurrencyLimits(maxConcurrent, perNamespace int, timeout time.Duration) error {
+	if timeout > 0 {
+		return nil
+	}
+	if maxConcurrent > 0 {
+		return fmt.Errorf("--webhook-max-concurrent %d requires a positive --webhook-timeout, or waiting requests are never rejected", maxConcurrent)
+	}
+	if perNamespace > 0 {
+		return fmt.Errorf("--per-namespace-admission-concurrency %d requires a positive --webhook-timeout, or waiting requests are never rejected", perNamespace)
+	}
+	return nil
+}
+
+// reviewLimiter bounds the number of reviews running at once
+type reviewLimiter struct {
+	slots chan struct{}
+}
+
+func newReviewLimiter(max int) *reviewLimiter {
+	return &reviewLimiter{slots: make(chan struct{}, max)}
+}
+
+// acquire waits until fewer than the maximum number of reviews run, and returns an error if
+// ctx is done first. Every successful acquire must be followed by a release.
+func (l *reviewLimiter) acquire(ctx context.Context) error {
+	// a free slot is taken even if ctx is done, as a select would pick either at random
+	select {
+	case l.slots <- struct{}{}:
+	default:
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return fmt.Errorf("%d admission requests are already under review: %s", cap(l.slots), ctx.Err())
+		}
+	}
+	inFlightRequests.Inc()
+	return nil
+}
+
+// release ends a review started with acquire
+func (l *reviewLimiter) release() {
+	inFlightRequests.Dec()
+	<-l.slots
+}
+
+// tooManyRequestsResponse rejects req, which waited since start for a review slot until err
+func tooManyRequestsResponse(req atypes.Request, err error, start time.Time) atypes.Response {
+	concurrencyRejectedTotal.Inc()
+	log.Error(err, "too many concurrent reviews, rejecting request", "kind", req.AdmissionRequest.Kind, "namespace", req.AdmissionRequest.Namespace, "name", req.AdmissionRequest.Name, "waited", time.Since(start).String())
+	vResp := admission.ValidationResponse(false, err.Error())
+	if vResp.Response.Result == nil {
+		vResp.Response.Result = &metav1.Status{}
+	}
+	vResp.Response.Result.Code = http.StatusTooManyRequests
+	return vResp
+}
+
+// namespaceLimiter bounds the number of reviews running at once for the resources of each
+// namespace. Namespaces are only tracked while they have reviews running or waiting.
+type namespaceLimiter struct {
+	max        int
+	mux        sync.Mutex
+	namespaces map[string]*namespaceSlots
+}
+
+type namespaceSlots struct {
+	slots chan struct{}
+	// users counts the reviews holding or waiting for a slot
+	users int
+}
+
+func newNamespaceLimiter(max int) *namespaceLimiter {
+	return &namespaceLimiter{max: max, namespaces: make(map[string]*namespaceSlots)}
+}
+
+// slots returns the slots of namespace, counting one more user of them
+func (l *namespaceLimiter) slots(namespace string) chan struct{} {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	ns, ok := l.namespaces[namespace]
+	if !ok {
+		ns = &namespaceSlots{slots: make(chan struct{}, l.max)}
+		l.namespaces[namespace] = ns
+	}
+	ns.users++
+	return ns.slots
+}
+
+// done counts one less user of the slots of namespace, forgetting them once unused
+func (l *namespaceLimiter) done(namespace string) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	ns := l.namespaces[namespace]
+	ns.users--
+	if ns.users == 0 {
+		delete(l.namespaces, namespace)
+	}
+}
+
+// acquire waits until fewer than the maximum number of reviews run for the resources of
+// namespace, and returns an error if ctx is done first. Every successful acquire must be
+// followed by a release of the same namespace.
+func (l *namespaceLimiter) acquire(ctx context.Context, namespace string) error {
+	slots := l.slots(namespace)
+	select {
+	case slots <- struct{}{}:
+		return nil
+	default:
+	}
+	select {
+	case slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		l.done(namespace)
+		if namespace == "" {
+			return fmt.Errorf("%d admission requests for cluster-scoped resources are already under review: %s", l.max, ctx.Err())
+		}
+		return fmt.Errorf("%d admission requests in namespace %s are already under review: %s", l.max, namespace, ctx.Err())
+	}
+}
+
+// release ends a review started with acquire
+func (l *namespaceLimiter) release(namespace string) {
+	l.mux.Lock()
+	slots := l.namespaces[namespace].slots
+	l.mux.Unlock()
+	<-slots
+	l.done(namespace)
+}
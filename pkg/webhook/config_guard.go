@@ -0,0 +1,90 @@
+package webhook
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+var manageWebhookConfig = flag.Bool("manage-webhook-config", false, "recreate the ValidatingWebhookConfiguration if it is deleted or loses the webhook, so that enforcement does not silently stop. without it, a missing configuration is only logged and reported by the gatekeeper_webhook_config_missing metric. not used with --enable-manual-deploy or --read-only, which leave the configuration to another installer")
+
+// webhookConfigCheckInterval is how often the webhook configuration is checked
+var webhookConfigCheckInterval = 10 * time.Second
+
+var _ manager.Runnable = &webhookConfigGuard{}
+
+// webhookConfigGuard checks that the ValidatingWebhookConfiguration still registers the
+// webhook, and reinstalls it if allowed to. Deleting the configuration stops enforcement while
+// Gatekeeper otherwise looks healthy.
+type webhookConfigGuard struct {
+	reader client.Reader
+	name   string
+	// install recreates the configuration, or is nil if the configuration must not be written
+	install func() error
+}
+
+// Start checks the webhook configuration every webhookConfigCheckInterval until stop is closed
+func (g *webhookConfigGuard) Start(stop <-chan struct{}) error {
+	ticker := time.NewTicker(webhookConfigCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			g.check(context.Background())
+		}
+	}
+}
+
+// check reports whether the webhook configuration is missing, and reinstalls it if allowed to
+func (g *webhookConfigGuard) check(ctx context.Context) {
+	missing, err := g.missing(ctx)
+	if err != nil {
+		log.Error(err, "unable to check the webhook configuration", "name", g.name)
+		return
+	}
+	if !missing {
+		webhookConfigMissingGauge.Set(0)
+		return
+	}
+	webhookConfigMissingGauge.Set(1)
+	missingErr := fmt.Errorf("ValidatingWebhookConfiguration %s is missing or does not register the webhook", g.name)
+	if g.install == nil {
+		log.Error(missingErr, "admission requests are not reviewed")
+		return
+	}
+	log.Error(missingErr, "recreating the webhook configuration")
+	if err := g.install(); err != nil {
+		log.Error(err, "unable to recreate the webhook configuration", "name", g.name)
+		return
+	}
+	webhookConfigRecreatedTotal.Inc()
+	webhookConfigMissingGauge.Set(0)
+	log.Info("webhook configuration recreated", "name", g.name)
+}
+
+// missing returns true if the webhook configuration does not exist or does not register the
+// webhook
+func (g *webhookConfigGuard) missing(ctx context.Context) (bool, error) {
+	cfg := &admissionregistrationv1beta1.ValidatingWebhookConfiguration{}
+	if err := g.reader.Get(ctx, types.NamespacedName{Name: g.name}, cfg); err != nil {
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	for _, wh := range cfg.Webhooks {
+		if wh.Name == g.name {
+			return false, nil
+		}
+	}
+	return true, nil
+}
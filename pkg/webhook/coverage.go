@@ -0,0 +1,178 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CoverageReport lists the mismatches between the resources the webhook intercepts and the
+// kinds constraints match
+type CoverageReport struct {
+	// UncoveredRules are the resources intercepted by the webhook that no constraint matches
+	UncoveredRules []string `json:"uncoveredRules"`
+	// UncoveredConstraints are the kinds matched by constraints that the webhook does not
+	// intercept, as <constraint kind>/<constraint name>: <kind>
+	UncoveredConstraints []string `json:"uncoveredConstraints"`
+}
+
+// kindSelector is a group and kind matched by a constraint, either of which may be "*"
+type kindSelector struct {
+	constraint string
+	gk         schema.GroupKind
+}
+
+// ruleResources returns the group and resource of every resource intercepted by rules, either
+// of which may be "*". Subresources are reviewed as their resource.
+func ruleResources(rules []admissionregistrationv1beta1.RuleWithOperations) []schema.GroupResource {
+	seen := make(map[schema.GroupResource]bool)
+	var resources []schema.GroupResource
+	for _, rule := range rules {
+		for _, group := range rule.APIGroups {
+			for _, resource := range rule.Resources {
+				gr := schema.GroupResource{Group: group, Resource: strings.SplitN(resource, "/", 2)[0]}
+				if !seen[gr] {
+					seen[gr] = true
+					resources = append(resources, gr)
+				}
+			}
+		}
+	}
+	return resources
+}
+
+// constraintKinds returns the kinds matched by constraint, which match every kind if they
+// are not specified
+func constraintKinds(constraint *unstructured.Unstructured) []kindSelector {
+	name := fmt.Sprintf("%s/%s", constraint.GetKind(), constraint.GetName())
+	selectors, found, err := unstructured.NestedSlice(constraint.Object, "spec", "match", "kinds")
+	if err != nil || !found {
+		return []kindSelector{{constraint: name, gk: schema.GroupKind{Group: "*", Kind: "*"}}}
+	}
+	var kinds []kindSelector
+	for _, s := range selectors {
+		selector, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		groups, _, _ := unstructured.NestedStringSlice(selector, "apiGroups")
+		ks, _, _ := unstructured.NestedStringSlice(selector, "kinds")
+		for _, group := range groups {
+			for _, kind := range ks {
+				kinds = append(kinds, kindSelector{constraint: name, gk: schema.GroupKind{Group: group, Kind: kind}})
+			}
+		}
+	}
+	return kinds
+}
+
+// overlaps returns true if requests for resource gr may be of kind gk
+func overlaps(mapper meta.RESTMapper, gr schema.GroupResource, gk schema.GroupKind) bool {
+	if gr.Group != "*" && gk.Group != "*" && gr.Group != gk.Group {
+		return false
+	}
+	if gr.Resource == "*" || gk.Kind == "*" {
+		return true
+	}
+	gvks, err := mapper.KindsFor(schema.GroupVersionResource{Resource: gr.Resource})
+	if err != nil {
+		return false
+	}
+	for _, gvk := range gvks {
+		if (gr.Group == "*" || gvk.Group == gr.Group) && (gk.Group == "*" || gvk.Group == gk.Group) && gvk.Kind == gk.Kind {
+			return true
+		}
+	}
+	return false
+}
+
+// analyzeCoverage reports the resources intercepted by rules that none of constraints
+// match, and the kinds matched by constraints that rules do not intercept. mapper maps
+// resources to their kinds.
+func analyzeCoverage(rules []admissionregistrationv1beta1.RuleWithOperations, constraints []*unstructured.Unstructured, mapper meta.RESTMapper) *CoverageReport {
+	resources := ruleResources(rules)
+	var kinds []kindSelector
+	for _, c := range constraints {
+		kinds = append(kinds, constraintKinds(c)...)
+	}
+
+	report := &CoverageReport{UncoveredRules: []string{}, UncoveredConstraints: []string{}}
+	for _, gr := range resources {
+		covered := false
+		for _, k := range kinds {
+			if overlaps(mapper, gr, k.gk) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			report.UncoveredRules = append(report.UncoveredRules, gr.String())
+		}
+	}
+	for _, k := range kinds {
+		covered := false
+		for _, gr := range resources {
+			if overlaps(mapper, gr, k.gk) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			report.UncoveredConstraints = append(report.UncoveredConstraints, fmt.Sprintf("%s: %s", k.constraint, k.gk))
+		}
+	}
+	sort.Strings(report.UncoveredRules)
+	sort.Strings(report.UncoveredConstraints)
+	return report
+}
+
+// CoverageHandler serves a CoverageReport of the rules of the webhook configuration and the
+// constraints loaded into driver as JSON
+func CoverageHandler(c client.Client, mapper meta.RESTMapper, driver drivers.Driver) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, err := coverage(r.Context(), c, mapper, driver)
+		if err != nil {
+			log.Error(err, "unable to analyze webhook coverage")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.Error(err, "unable to write webhook coverage")
+		}
+	})
+}
+
+func coverage(ctx context.Context, c client.Client, mapper meta.RESTMapper, driver drivers.Driver) (*CoverageReport, error) {
+	cfg := &admissionregistrationv1beta1.ValidatingWebhookConfiguration{}
+	if err := c.Get(ctx, types.NamespacedName{Name: *webhookName}, cfg); err != nil {
+		return nil, err
+	}
+	var rules []admissionregistrationv1beta1.RuleWithOperations
+	for _, wh := range cfg.Webhooks {
+		rules = append(rules, wh.Rules...)
+	}
+	resp, err := driver.Query(ctx, fmt.Sprintf(`hooks["%s"].library.loaded_constraints`, (&target.K8sValidationTarget{}).GetName()), nil)
+	if err != nil {
+		return nil, err
+	}
+	var constraints []*unstructured.Unstructured
+	for _, r := range resp.Results {
+		if r.Constraint != nil {
+			constraints = append(constraints, r.Constraint)
+		}
+	}
+	return analyzeCoverage(rules, constraints, mapper), nil
+}
@@ -0,0 +1,35 @@
+package webhook
+
+import (
+	"context"
+	"flag"
+	"time"
+)
+
+var webhookTimeout = flag.Duration("webhook-timeout", 0, "maximum time spent evaluating an admission request, e.g. 3s. reviews still running when it passes fail as errors and are subject to the webhook's failurePolicy. unbounded if unspecified or 0")
+
+// responseMargin is kept free before the deadline of ctx to send the response
+const responseMargin = 100 * time.Millisecond
+
+// reviewContext returns the context of the review of a request handled with ctx. The review
+// ends --webhook-timeout from now. The webhook server hands every request over without a
+// deadline, so a deadline of ctx only applies to callers that set one; the review then ends
+// at the latest responseMargin before it.
+func reviewContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	var cancels []context.CancelFunc
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline.Add(-responseMargin))
+		cancels = append(cancels, cancel)
+	}
+	if *webhookTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *webhookTimeout)
+		cancels = append(cancels, cancel)
+	}
+	return ctx, func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+}
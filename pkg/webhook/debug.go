@@ -0,0 +1,57 @@
+package webhook
+
+import (
+	"flag"
+	"time"
+
+	rtypes "github.com/open-policy-agent/frameworks/constraint/pkg/types"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var constraintDebugTraceSize = flag.Int("constraint-debug-trace-size", 0, "maximum size in bytes of the OPA trace logged with each evaluation of a constraint that sets spec.debug, past which it is truncated. traces are not collected for them if 0")
+
+// logDebugEvaluation logs the evaluation of review against constraint, which sets spec.debug:
+// the reviewed object, the violations or the error, and the trace if one was collected. Objects
+// with fields selected by --redact-paths are logged redacted, without the trace, whose values
+// cannot be redacted.
+func (h *validationHandler) logDebugEvaluation(review *admissionv1beta1.AdmissionRequest, constraint *unstructured.Unstructured, r *rtypes.Response, err error, duration time.Duration) {
+	req, redacted := h.redactor.request(review)
+	kv := []interface{}{
+		"constraint_kind", constraint.GetKind(),
+		"constraint_name", constraint.GetName(),
+		"request_uid", req.UID,
+		"operation", req.Operation,
+		"group", req.Kind.Group,
+		"version", req.Kind.Version,
+		"kind", req.Kind.Kind,
+		"namespace", req.Namespace,
+		"name", req.Name,
+		"user", req.UserInfo.Username,
+		"object", string(req.Object.Raw),
+		"duration", duration.String(),
+	}
+	if len(req.OldObject.Raw) != 0 {
+		kv = append(kv, "old_object", string(req.OldObject.Raw))
+	}
+	if err != nil {
+		kv = append(kv, "error", err.Error())
+	}
+	if r != nil {
+		var violations []string
+		for _, result := range r.Results {
+			violations = append(violations, result.Msg)
+		}
+		kv = append(kv, "violations", violations)
+		if r.Trace != nil && *constraintDebugTraceSize > 0 {
+			trace := *r.Trace
+			if redacted {
+				trace = "not logged for a request with fields selected by --redact-paths"
+			} else if len(trace) > *constraintDebugTraceSize {
+				trace = trace[:*constraintDebugTraceSize] + "..."
+			}
+			kv = append(kv, "trace", trace)
+		}
+	}
+	log.Info("debug constraint evaluation", kv...)
+}
@@ -0,0 +1,137 @@
+package webhook
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/gatekeeper/pkg/health"
+	atypes "sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+var (
+	enableDebugEndpoints = flag.Bool("enable-debug-endpoints", false, "keep the last --decision-buffer-size admission decisions in memory and serve them as JSON on /debug/decisions of --health-addr, stream the progress of audits on /debug/audit-progress, export the loaded policy on /debug/bundle and match resources against the constraints on /debug/match. the endpoints are served to clients connecting from localhost or authenticated with --debug-auth-token-file or --debug-client-ca-file")
+	decisionBufferSize   = flag.Int("decision-buffer-size", 100, "number of admission decisions kept for /debug/decisions with --enable-debug-endpoints. defaulted to 100 if unspecified ")
+)
+
+// debugDecisions is the buffer of the webhook's handler, served by DecisionsHandler. It is nil
+// unless --enable-debug-endpoints is set.
+var debugDecisions *decisionBuffer
+
+// DebugEndpointsEnabled returns true if the debug endpoints holding the contents of admission
+// requests must be served
+func DebugEndpointsEnabled() bool {
+	return *enableDebugEndpoints
+}
+
+func validateDecisionBufferSize(size int) error {
+	if size < 1 {
+		return fmt.Errorf("invalid --decision-buffer-size %d, must be at least 1", size)
+	}
+	return nil
+}
+
+// DecisionRecord summarizes an admission request and the decision made for it
+type DecisionRecord struct {
+	Time               time.Time `json:"time"`
+	UID                string    `json:"uid"`
+	Operation          string    `json:"operation"`
+	Group              string    `json:"group,omitempty"`
+	Version            string    `json:"version"`
+	Kind               string    `json:"kind"`
+	Namespace          string    `json:"namespace,omitempty"`
+	Name               string    `json:"name,omitempty"`
+	User               string    `json:"user"`
+	DryRun             bool      `json:"dryRun,omitempty"`
+	Allowed            bool      `json:"allowed"`
+	Reason             string    `json:"reason,omitempty"`
+	MatchedConstraints []string  `json:"matchedConstraints"`
+	Duration           string    `json:"duration"`
+}
+
+// decisionBuffer is a bounded, thread-safe ring buffer of the last admission decisions
+type decisionBuffer struct {
+	mux     sync.Mutex
+	records []DecisionRecord
+	// next is the index of the record overwritten next once the buffer is full
+	next int
+}
+
+func newDecisionBuffer(size int) *decisionBuffer {
+	return &decisionBuffer{records: make([]DecisionRecord, 0, size)}
+}
+
+// add records the decision resp made for req, replacing the oldest record if the buffer is full
+func (b *decisionBuffer) add(req atypes.Request, resp atypes.Response, matched []string, duration time.Duration) {
+	r := req.AdmissionRequest
+	rec := DecisionRecord{
+		Time:               time.Now().UTC(),
+		UID:                string(r.UID),
+		Operation:          string(r.Operation),
+		Group:              r.Kind.Group,
+		Version:            r.Kind.Version,
+		Kind:               r.Kind.Kind,
+		Namespace:          r.Namespace,
+		Name:               r.Name,
+		User:               r.UserInfo.Username,
+		DryRun:             isDryRun(r),
+		Allowed:            resp.Response.Allowed,
+		MatchedConstraints: append([]string{}, matched...),
+		Duration:           duration.String(),
+	}
+	if resp.Response.Result != nil {
+		rec.Reason = string(resp.Response.Result.Reason)
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if len(b.records) < cap(b.records) {
+		b.records = append(b.records, rec)
+		return
+	}
+	b.records[b.next] = rec
+	b.next = (b.next + 1) % len(b.records)
+}
+
+// list returns the recorded decisions, oldest first
+func (b *decisionBuffer) list() []DecisionRecord {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	out := make([]DecisionRecord, 0, len(b.records))
+	out = append(out, b.records[b.next:]...)
+	return append(out, b.records[:b.next]...)
+}
+
+// DecisionsHandler serves the decisions recorded with --enable-debug-endpoints as JSON, oldest
+// first. The limit query parameter restricts them to the most recent ones. Clients that do not
+// connect from localhost are denied unless they authenticated with --debug-auth-token-file or
+// --debug-client-ca-file, as the decisions name the requested resources and users.
+func DecisionsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !health.FromLocalhost(r) && !health.Authenticated(r) {
+			http.Error(w, "decisions are only served to localhost", http.StatusForbidden)
+			return
+		}
+		records := []DecisionRecord{}
+		if debugDecisions != nil {
+			records = debugDecisions.list()
+		}
+		if l := r.URL.Query().Get("limit"); l != "" {
+			limit, err := strconv.Atoi(l)
+			if err != nil || limit < 0 {
+				http.Error(w, fmt.Sprintf("invalid limit %q", l), http.StatusBadRequest)
+				return
+			}
+			if limit < len(records) {
+				records = records[len(records)-limit:]
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(records); err != nil {
+			log.Error(err, "unable to write admission decisions")
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package webhook
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"sync"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	atypes "sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+var (
+	fallbackToCachedDecision = flag.Bool("fallback-to-cached-decision", false, "when OPA fails to evaluate a request, return the last decision made for an identical request if one is cached. Cached decisions may be stale if policies changed since they were made")
+	decisionCacheSize        = flag.Int("decision-cache-size", 1000, "maximum number of decisions cached for --fallback-to-cached-decision. defaulted to 1000 if unspecified ")
+)
+
+// cachedDecision holds the parts of an admission response needed to replay it
+type cachedDecision struct {
+	allowed bool
+	reason  string
+	code    int32
+}
+
+func (d cachedDecision) response() atypes.Response {
+	resp := admission.ValidationResponse(d.allowed, d.reason)
+	if d.code != 0 {
+		if resp.Response.Result == nil {
+			resp.Response.Result = &metav1.Status{}
+		}
+		resp.Response.Result.Code = d.code
+	}
+	return resp
+}
+
+type decisionCacheEntry struct {
+	key      string
+	decision cachedDecision
+}
+
+// decisionCache is a bounded, thread-safe LRU cache of admission decisions keyed by
+// the content of the request that produced them
+type decisionCache struct {
+	mux   sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+func newDecisionCache(size int) *decisionCache {
+	return &decisionCache{
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// decisionKey identifies requests that should receive the same decision: the same
+// operation by the same user on the same object content
+func decisionKey(req *admissionv1beta1.AdmissionRequest) (string, error) {
+	b, err := json.Marshal(struct {
+		Kind        metav1.GroupVersionKind
+		SubResource string
+		Namespace   string
+		Name        string
+		Operation   admissionv1beta1.Operation
+		User        string
+		Groups      []string
+		Object      []byte
+		OldObject   []byte
+	}{
+		Kind:        req.Kind,
+		SubResource: req.SubResource,
+		Namespace:   req.Namespace,
+		Name:        req.Name,
+		Operation:   req.Operation,
+		User:        req.UserInfo.Username,
+		Groups:      req.UserInfo.Groups,
+		Object:      req.Object.Raw,
+		OldObject:   req.OldObject.Raw,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Add records the decision made for the request
+func (c *decisionCache) Add(req *admissionv1beta1.AdmissionRequest, resp atypes.Response) {
+	key, err := decisionKey(req)
+	if err != nil {
+		log.Error(err, "unable to compute decision cache key")
+		return
+	}
+	d := cachedDecision{allowed: resp.Response.Allowed}
+	if resp.Response.Result != nil {
+		d.reason = string(resp.Response.Result.Reason)
+		d.code = resp.Response.Result.Code
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if e, ok := c.items[key]; ok {
+		e.Value.(*decisionCacheEntry).decision = d
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.order.PushFront(&decisionCacheEntry{key: key, decision: d})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*decisionCacheEntry).key)
+	}
+}
+
+// Get returns a fresh copy of the decision cached for the request, if any
+func (c *decisionCache) Get(req *admissionv1beta1.AdmissionRequest) (atypes.Response, bool) {
+	key, err := decisionKey(req)
+	if err != nil {
+		log.Error(err, "unable to compute decision cache key")
+		return atypes.Response{}, false
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return atypes.Response{}, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*decisionCacheEntry).decision.response(), true
+}
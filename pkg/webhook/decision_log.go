@@ -0,0 +1,55 @@
+package webhook
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	atypes "sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+var (
+	logDenies       = flag.Bool("log-denies", false, "log a structured line describing every denied admission request")
+	logAllDecisions = flag.Bool("log-all-decisions", false, "log a structured line describing every reviewed admission request, allowed or denied. Implies --log-denies")
+)
+
+// shouldLogDecision returns true if a decision with the given outcome should be logged
+func shouldLogDecision(allowed bool) bool {
+	return *logAllDecisions || (*logDenies && !allowed)
+}
+
+// logDecision records enough about a reviewed request to reconstruct the decision from logs
+func logDecision(req atypes.Request, resp atypes.Response, matched []string, duration time.Duration) {
+	r := req.AdmissionRequest
+	decision := "allow"
+	if !resp.Response.Allowed {
+		decision = "deny"
+	}
+	log.Info("admission decision",
+		"request_uid", r.UID,
+		"dry_run", isDryRun(r),
+		"operation", r.Operation,
+		"group", r.Kind.Group,
+		"version", r.Kind.Version,
+		"kind", r.Kind.Kind,
+		"namespace", r.Namespace,
+		"name", r.Name,
+		"user", r.UserInfo.Username,
+		"decision", decision,
+		"matched_constraints", matched,
+		"duration", duration.String(),
+	)
+}
+
+// isDryRun returns true if the changes of r are not persisted, as for server-side dry-run
+// requests. Dry-run requests are reviewed like others, but are told apart in logs and metrics so
+// they are not taken for enforcement.
+func isDryRun(r *admissionv1beta1.AdmissionRequest) bool {
+	return r.DryRun != nil && *r.DryRun
+}
+
+func constraintName(c *unstructured.Unstructured) string {
+	return fmt.Sprintf("%s/%s", c.GetKind(), c.GetName())
+}
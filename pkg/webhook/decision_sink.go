@@ -0,0 +1,172 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/gatekeeper/version"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	atypes "sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+var decisionLogSink = flag.String("decision-log-sink", "", "where to send every reviewed admission decision as an OPA decision log entry: stdout, or the http or https URL of a decision log service, which receives them as JSON arrays in POST requests. decision logs are not sent if unspecified")
+
+const (
+	// decisionLogPath is the path of the decision in the entries, where OPA puts the policy
+	// queried
+	decisionLogPath = "gatekeeper/admission"
+	// decisionLogBufferSize is the number of entries waiting to be sent to an HTTP sink, past
+	// which new entries are dropped rather than slowing down admission
+	decisionLogBufferSize = 1000
+	// decisionLogBatchSize is the maximum number of entries sent in a single request
+	decisionLogBatchSize = 100
+)
+
+// decisionLogEntry is an admission decision in the format of OPA decision logs. As for OPA
+// deployed as an admission controller, the input is the AdmissionReview of the request and
+// the result that of the response.
+type decisionLogEntry struct {
+	Labels     map[string]string                 `json:"labels"`
+	DecisionID string                            `json:"decision_id"`
+	Path       string                            `json:"path"`
+	Input      *admissionv1beta1.AdmissionReview `json:"input"`
+	Result     *admissionv1beta1.AdmissionReview `json:"result"`
+	Timestamp  time.Time                         `json:"timestamp"`
+	Metrics    map[string]int64                  `json:"metrics"`
+}
+
+// newDecisionLogEntry returns the entry of the decision resp made for req in duration. Its
+// decision_id is the uid of the request, so it can be matched with the API server's audit log.
+func newDecisionLogEntry(req atypes.Request, resp atypes.Response, duration time.Duration) *decisionLogEntry {
+	id := string(req.AdmissionRequest.UID)
+	if id == "" {
+		id = string(uuid.NewUUID())
+	}
+	typeMeta := metav1.TypeMeta{APIVersion: admissionv1beta1.SchemeGroupVersion.String(), Kind: "AdmissionReview"}
+	host, _ := os.Hostname()
+	return &decisionLogEntry{
+		Labels:     map[string]string{"id": host, "version": version.Version},
+		DecisionID: id,
+		Path:       decisionLogPath,
+		Input:      &admissionv1beta1.AdmissionReview{TypeMeta: typeMeta, Request: req.AdmissionRequest},
+		Result:     &admissionv1beta1.AdmissionReview{TypeMeta: typeMeta, Response: resp.Response},
+		Timestamp:  time.Now().UTC(),
+		Metrics:    map[string]int64{"timer_rego_query_eval_ns": duration.Nanoseconds()},
+	}
+}
+
+// decisionSink receives the decision log entries of the webhook
+type decisionSink interface {
+	send(entry *decisionLogEntry)
+}
+
+// newDecisionSink returns the sink set by --decision-log-sink, or nil if decision logs are not
+// sent
+func newDecisionSink(sink string) (decisionSink, error) {
+	switch {
+	case sink == "":
+		return nil, nil
+	case sink == "stdout":
+		return &writerSink{w: os.Stdout}, nil
+	case strings.HasPrefix(sink, "http://"), strings.HasPrefix(sink, "https://"):
+		return newHTTPSink(sink), nil
+	}
+	return nil, fmt.Errorf("invalid --decision-log-sink %q, must be stdout or an http or https URL", sink)
+}
+
+// writerSink writes each entry to w as a line of JSON
+type writerSink struct {
+	mux sync.Mutex
+	w   io.Writer
+}
+
+func (s *writerSink) send(entry *decisionLogEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Error(err, "unable to encode decision log entry", "decision_id", entry.DecisionID)
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if _, err := s.w.Write(append(line, '\n')); err != nil {
+		log.Error(err, "unable to write decision log entry", "decision_id", entry.DecisionID)
+	}
+}
+
+var _ manager.Runnable = &httpSink{}
+
+// httpSink posts the entries to a decision log service in the background, in batches of the
+// entries waiting to be sent
+type httpSink struct {
+	url     string
+	client  *http.Client
+	entries chan *decisionLogEntry
+}
+
+func newHTTPSink(url string) *httpSink {
+	return &httpSink{
+		url:     url,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		entries: make(chan *decisionLogEntry, decisionLogBufferSize),
+	}
+}
+
+func (s *httpSink) send(entry *decisionLogEntry) {
+	select {
+	case s.entries <- entry:
+	default:
+		decisionLogDroppedTotal.Inc()
+	}
+}
+
+// Start posts the entries until stop is closed
+func (s *httpSink) Start(stop <-chan struct{}) error {
+	for {
+		select {
+		case <-stop:
+			return nil
+		case entry := <-s.entries:
+			batch := []*decisionLogEntry{entry}
+		drain:
+			for len(batch) < decisionLogBatchSize {
+				select {
+				case entry := <-s.entries:
+					batch = append(batch, entry)
+				default:
+					break drain
+				}
+			}
+			if err := s.post(batch); err != nil {
+				decisionLogDroppedTotal.Add(float64(len(batch)))
+				log.Error(err, "unable to send decision logs", "url", s.url, "entries", len(batch))
+			}
+		}
+	}
+}
+
+func (s *httpSink) post(batch []*decisionLogEntry) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("decision log service answered %s", resp.Status)
+	}
+	return nil
+}
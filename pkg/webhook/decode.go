@@ -0,0 +1,36 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// checkDecodable returns an error if the request or the objects it carries cannot be
+// decoded. The webhook server only checks that the request body is a valid
+// AdmissionReview; the objects in it may be any JSON value.
+func checkDecodable(req *admissionv1beta1.AdmissionRequest) error {
+	if req == nil {
+		return errors.New("admission review has no request")
+	}
+	for _, o := range []struct {
+		field string
+		raw   runtime.RawExtension
+	}{
+		{field: "object", raw: req.Object},
+		{field: "oldObject", raw: req.OldObject},
+	} {
+		// objects are null where the operation has none, e.g. object for DELETE
+		if o.raw.Raw == nil {
+			continue
+		}
+		obj := make(map[string]interface{})
+		if err := json.Unmarshal(o.raw.Raw, &obj); err != nil {
+			return fmt.Errorf("unable to decode request %s: %s", o.field, err)
+		}
+	}
+	return nil
+}
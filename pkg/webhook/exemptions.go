@@ -0,0 +1,158 @@
+package webhook
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+var exemptionsConfigMap = flag.String("exemptions-configmap", "", "name of a ConfigMap in the --gatekeeper-namespace namespace listing the namespaces, kinds and users whose admission requests are allowed without review, under its namespaces, kinds and users keys. changes to the ConfigMap apply without a restart. nothing is exempted if unspecified")
+
+const (
+	// exemptNamespacesKey lists exempted namespaces. Requests for resources in them, and for
+	// the namespaces themselves, are exempted.
+	exemptNamespacesKey = "namespaces"
+	// exemptKindsKey lists exempted kinds as Kind.group, or Kind for the core group, e.g.
+	// Deployment.apps or ConfigMap
+	exemptKindsKey = "kinds"
+	// exemptUsersKey lists the usernames whose requests are exempted
+	exemptUsersKey = "users"
+)
+
+// exemptionSet is a parsed exemption ConfigMap. It is not modified once parsed.
+type exemptionSet struct {
+	namespaces map[string]bool
+	kinds      map[schema.GroupKind]bool
+	users      map[string]bool
+}
+
+// parseExemptions reads the exemptions of cm. The values of its keys are lists separated by
+// commas or newlines.
+func parseExemptions(cm *corev1.ConfigMap) (*exemptionSet, error) {
+	set := &exemptionSet{
+		namespaces: make(map[string]bool),
+		kinds:      make(map[schema.GroupKind]bool),
+		users:      make(map[string]bool),
+	}
+	for key, value := range cm.Data {
+		switch key {
+		case exemptNamespacesKey, exemptKindsKey, exemptUsersKey:
+		default:
+			return nil, fmt.Errorf("unknown key %q, must be one of %s, %s or %s", key, exemptNamespacesKey, exemptKindsKey, exemptUsersKey)
+		}
+		items := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' })
+		for _, item := range items {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			switch key {
+			case exemptNamespacesKey:
+				if errs := validation.IsDNS1123Label(item); len(errs) != 0 {
+					return nil, fmt.Errorf("invalid namespace %q: %s", item, strings.Join(errs, ", "))
+				}
+				set.namespaces[item] = true
+			case exemptKindsKey:
+				set.kinds[schema.ParseGroupKind(item)] = true
+			case exemptUsersKey:
+				set.users[item] = true
+			}
+		}
+	}
+	return set, nil
+}
+
+// exempt returns the reason req is exempted from review, or an empty string if it is not
+func (s *exemptionSet) exempt(req *admissionv1beta1.AdmissionRequest) string {
+	if s == nil {
+		return ""
+	}
+	if s.users[req.UserInfo.Username] {
+		return fmt.Sprintf("user %s is exempted from review", req.UserInfo.Username)
+	}
+	if s.namespaces[req.Namespace] {
+		return fmt.Sprintf("namespace %s is exempted from review", req.Namespace)
+	}
+	if req.Kind.Group == "" && req.Kind.Kind == "Namespace" && s.namespaces[req.Name] {
+		return fmt.Sprintf("namespace %s is exempted from review", req.Name)
+	}
+	if gk := (schema.GroupKind{Group: req.Kind.Group, Kind: req.Kind.Kind}); s.kinds[gk] {
+		return fmt.Sprintf("kind %s is exempted from review", gk)
+	}
+	return ""
+}
+
+// exemptions holds the exemptions of the ConfigMap named by --exemptions-configmap. Requests
+// read the whole set at once, so a change never applies halfway through one.
+type exemptions struct {
+	name    string
+	mux     sync.RWMutex
+	current *exemptionSet
+}
+
+func newExemptions(name string) *exemptions {
+	return &exemptions{name: name}
+}
+
+// get returns the current exemptions. A nil exemptions exempts nothing.
+func (e *exemptions) get() *exemptionSet {
+	if e == nil {
+		return nil
+	}
+	e.mux.RLock()
+	defer e.mux.RUnlock()
+	return e.current
+}
+
+func (e *exemptions) update(obj interface{}, deleted bool) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok || cm.GetNamespace() != util.GetNamespace() || cm.GetName() != e.name {
+		return
+	}
+	if deleted {
+		e.mux.Lock()
+		e.current = nil
+		e.mux.Unlock()
+		log.Info("exemption ConfigMap deleted, no longer exempting requests", "name", e.name)
+		return
+	}
+	set, err := parseExemptions(cm)
+	if err != nil {
+		log.Error(err, "invalid exemption ConfigMap, keeping the previous exemptions", "name", e.name)
+		return
+	}
+	e.mux.Lock()
+	e.current = set
+	e.mux.Unlock()
+	log.Info("exemptions updated", "name", e.name, "namespaces", sortedKeys(set.namespaces), "kinds", len(set.kinds), "users", sortedKeys(set.users))
+}
+
+// eventHandler keeps the exemptions up to date with the ConfigMaps watched by an informer
+func (e *exemptions) eventHandler() toolscache.ResourceEventHandler {
+	return toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { e.update(obj, false) },
+		UpdateFunc: func(_, obj interface{}) { e.update(obj, false) },
+		DeleteFunc: func(obj interface{}) { e.update(obj, true) },
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,122 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers"
+	"github.com/open-policy-agent/gatekeeper/pkg/health"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// maxMatchBody bounds the size of the resources posted to MatchHandler
+const maxMatchBody = 1 << 20
+
+// MatchReport lists the constraints whose match criteria select a resource
+type MatchReport struct {
+	// Constraints are the matching constraints, as kind/name
+	Constraints []string `json:"constraints"`
+}
+
+// MatchHandler serves a MatchReport of the constraints loaded into driver whose match criteria
+// select the resource posted as YAML or JSON, as if it were reviewed for the operation of the
+// operation query parameter, CREATE by default. Only the match criteria are evaluated, not the
+// Rego of the templates. namespaceSelector is evaluated against the cached namespaces. Like
+// DecisionsHandler, it only serves clients connecting from localhost unless they authenticated.
+func MatchHandler(driver drivers.Driver, mapper meta.RESTMapper) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !health.FromLocalhost(r) && !health.Authenticated(r) {
+			http.Error(w, "matches are only served to localhost", http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "post the resource to match", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxMatchBody))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		operation := admissionv1beta1.Operation(strings.ToUpper(r.URL.Query().Get("operation")))
+		if operation == "" {
+			operation = admissionv1beta1.Create
+		}
+		req, err := matchRequest(body, operation, mapper)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		matched, err := matchingConstraints(r.Context(), driver, req)
+		if err != nil {
+			log.Error(err, "unable to match constraints")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if matched == nil {
+			matched = []string{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&MatchReport{Constraints: matched}); err != nil {
+			log.Error(err, "unable to write matching constraints")
+		}
+	})
+}
+
+// matchRequest builds the admission request for operation on the resource of body
+func matchRequest(body []byte, operation admissionv1beta1.Operation, mapper meta.RESTMapper) (*admissionv1beta1.AdmissionRequest, error) {
+	switch operation {
+	case admissionv1beta1.Create, admissionv1beta1.Update, admissionv1beta1.Delete, admissionv1beta1.Connect:
+	default:
+		return nil, fmt.Errorf("unknown operation %q", operation)
+	}
+	raw, err := yaml.ToJSON(body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode resource: %s", err)
+	}
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(raw); err != nil {
+		return nil, fmt.Errorf("unable to decode resource: %s", err)
+	}
+	gvk := obj.GroupVersionKind()
+	req := &admissionv1beta1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind},
+		Name:      obj.GetName(),
+		Operation: operation,
+	}
+	// deletions are reviewed with the existing object as the object
+	req.Object.Raw = raw
+	if operation == admissionv1beta1.Update || operation == admissionv1beta1.Delete {
+		req.OldObject.Raw = raw
+	}
+	req.Namespace = target.EffectiveNamespace(req, isNamespaced(mapper, req.Kind))
+	return req, nil
+}
+
+// matchingConstraints returns the constraints loaded into driver that match req, as kind/name,
+// in order
+func matchingConstraints(ctx context.Context, driver drivers.Driver, req *admissionv1beta1.AdmissionRequest) ([]string, error) {
+	input := map[string]interface{}{"review": req}
+	resp, err := driver.Query(ctx, fmt.Sprintf(`hooks["%s"].library.matched_constraints`, (&target.K8sValidationTarget{}).GetName()), input)
+	if err != nil {
+		return nil, err
+	}
+	var matched []string
+	for _, r := range resp.Results {
+		if r.Constraint != nil {
+			matched = append(matched, constraintName(r.Constraint))
+		}
+	}
+	sort.Strings(matched)
+	return matched, nil
+}
@@ -0,0 +1,106 @@
+package webhook
+
+import (
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	enforcementPausedGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gatekeeper_enforcement_paused",
+		Help: "Set to 1 while admission enforcement is paused, 0 otherwise",
+	})
+	validationRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gatekeeper_validation_request_total",
+		Help: "Number of admission requests reviewed against constraints, by admission_status and by whether the request was a dry_run request whose changes are not persisted",
+	}, []string{"admission_status", "dry_run"})
+	pausedRequestsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gatekeeper_validation_paused_total",
+		Help: "Number of admission requests allowed without review because enforcement was paused",
+	})
+	cachedFallbackTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gatekeeper_validation_cached_fallback_total",
+		Help: "Number of admission requests answered from the decision cache because OPA failed to evaluate them",
+	})
+	dryRunDeniedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gatekeeper_validation_dry_run_denied_total",
+		Help: "Number of admission requests allowed because of --dry-run that would have been denied",
+	})
+	unmatchedRequestsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gatekeeper_validation_unmatched_total",
+		Help: "Number of reviewed admission requests that matched no constraint",
+	})
+	exemptedRequestsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gatekeeper_validation_exempted_total",
+		Help: "Number of admission requests allowed without review because --exemptions-configmap exempts their namespace, kind or user",
+	})
+	decodeErrorsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gatekeeper_validation_decode_errors_total",
+		Help: "Number of admission requests rejected because they could not be decoded",
+	})
+	deadlineExceededTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gatekeeper_validation_deadline_exceeded_total",
+		Help: "Number of admission reviews that did not finish before --webhook-timeout",
+	})
+	webhookConfigMissingGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gatekeeper_webhook_config_missing",
+		Help: "Set to 1 while the ValidatingWebhookConfiguration is missing or does not register the webhook, so admission requests are not reviewed, 0 otherwise",
+	})
+	webhookConfigRecreatedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gatekeeper_webhook_config_recreated_total",
+		Help: "Number of times the missing ValidatingWebhookConfiguration was recreated because of --manage-webhook-config",
+	})
+	inFlightRequests = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gatekeeper_validation_in_flight_requests",
+		Help: "Number of admission requests under review, counted when --webhook-max-concurrent is set",
+	})
+	concurrencyRejectedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gatekeeper_validation_concurrency_rejected_total",
+		Help: "Number of admission requests rejected as --webhook-max-concurrent requests, or --per-namespace-admission-concurrency requests in their namespace, were under review until --webhook-timeout passed",
+	})
+	webhookCertExpirySeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gatekeeper_webhook_cert_expiry_seconds",
+		Help: "Time the serving certificate of the webhook expires, in seconds since the Unix epoch",
+	})
+	decisionLogDroppedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gatekeeper_validation_decision_log_dropped_total",
+		Help: "Number of decision log entries that could not be sent to the --decision-log-sink service",
+	})
+	templateEvalDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gatekeeper_template_eval_duration_seconds",
+		Help:    "Time taken to evaluate the constraints of a template matching an admission request, by template kind. Recorded when constraints are evaluated one at a time, as with --template-eval-metrics",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"kind"})
+	templateEvalTimeoutsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gatekeeper_template_eval_timeouts_total",
+		Help: "Number of admission requests for which the constraints of a template did not finish evaluating within its timeout, by template kind",
+	}, []string{"kind"})
+	templateEvaluationDisabledGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gatekeeper_template_evaluation_disabled",
+		Help: "Set to 1 for each template excluded from admission review after repeated evaluation errors",
+	}, []string{"kind"})
+)
+
+func init() {
+	util.AddMetrics(
+		"validation",
+		enforcementPausedGauge,
+		validationRequestsTotal,
+		pausedRequestsTotal,
+		cachedFallbackTotal,
+		dryRunDeniedTotal,
+		unmatchedRequestsTotal,
+		exemptedRequestsTotal,
+		decodeErrorsTotal,
+		deadlineExceededTotal,
+		templateEvaluationDisabledGauge,
+		templateEvalDurationSeconds,
+		templateEvalTimeoutsTotal,
+		webhookConfigMissingGauge,
+		webhookConfigRecreatedTotal,
+		webhookCertExpirySeconds,
+		decisionLogDroppedTotal,
+		inFlightRequests,
+		concurrencyRejectedTotal,
+	)
+}
@@ -0,0 +1,81 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+var webhookExcludeNamespaceLabels = flag.String("webhook-exclude-namespace-labels", "control-plane,kubernetes.io/metadata.name=kube-system", "comma-separated namespace labels whose namespaces the installed webhook configuration does not send requests for, either a key, excluding the namespaces with the label, or key=value. set to empty to send requests for every namespace. defaulted to control-plane,kubernetes.io/metadata.name=kube-system if unspecified ")
+
+// namespaceSelector returns the namespaceSelector of the installed webhook configuration, which
+// excludes the namespaces with the labels of --webhook-exclude-namespace-labels. The values
+// excluded for a key are combined into a single NotIn expression.
+func namespaceSelector() (*metav1.LabelSelector, error) {
+	// keys excluded whatever their value, and excluded values by key
+	var present []string
+	values := make(map[string][]string)
+	for _, entry := range strings.Split(*webhookExcludeNamespaceLabels, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if errs := validation.IsQualifiedName(key); len(errs) != 0 {
+			return nil, fmt.Errorf("invalid --webhook-exclude-namespace-labels key %q: %s", key, strings.Join(errs, "; "))
+		}
+		if len(parts) == 1 {
+			present = append(present, key)
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		if errs := validation.IsValidLabelValue(value); len(errs) != 0 {
+			return nil, fmt.Errorf("invalid --webhook-exclude-namespace-labels value %q: %s", value, strings.Join(errs, "; "))
+		}
+		values[key] = append(values[key], value)
+	}
+
+	// an empty selector keeps the webhook server from defaulting one
+	selector := &metav1.LabelSelector{}
+	sort.Strings(present)
+	for _, key := range present {
+		selector.MatchExpressions = append(selector.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key:      key,
+			Operator: metav1.LabelSelectorOpDoesNotExist,
+		})
+	}
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		sort.Strings(values[key])
+		selector.MatchExpressions = append(selector.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key:      key,
+			Operator: metav1.LabelSelectorOpNotIn,
+			Values:   values[key],
+		})
+	}
+	return selector, nil
+}
@@ -0,0 +1,50 @@
+package webhook
+
+import (
+	"flag"
+	"os"
+	"sync"
+)
+
+var enforcementPauseFile = flag.String("enforcement-pause-file", "", "path to a file whose presence pauses admission enforcement. All requests are allowed while the file exists; audit is unaffected. Disabled if unspecified")
+
+// pauser reports whether admission enforcement is currently paused
+type pauser interface {
+	Paused() bool
+}
+
+var _ pauser = &filePauser{}
+
+// filePauser pauses enforcement for as long as the file at path exists.
+// The file is checked on every call so removing it resumes enforcement
+// without a restart. Mounting an optional ConfigMap key at path allows
+// the switch to be flipped through the API.
+type filePauser struct {
+	path   string
+	mux    sync.Mutex
+	paused bool
+}
+
+func newFilePauser(path string) *filePauser {
+	return &filePauser{path: path}
+}
+
+// Paused returns true if the pause file exists
+func (p *filePauser) Paused() bool {
+	_, err := os.Stat(p.path)
+	paused := err == nil
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if paused != p.paused {
+		if paused {
+			log.Info("ENFORCEMENT PAUSED: all admission requests will be allowed until the pause file is removed", "file", p.path)
+			enforcementPausedGauge.Set(1)
+		} else {
+			log.Info("enforcement resumed", "file", p.path)
+			enforcementPausedGauge.Set(0)
+		}
+		p.paused = paused
+	}
+	return paused
+}
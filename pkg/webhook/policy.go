@@ -6,21 +6,32 @@ import (
 	"flag"
 	"fmt"
 	"net/http"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
 	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers"
 	"github.com/open-policy-agent/frameworks/constraint/pkg/core/templates"
 	rtypes "github.com/open-policy-agent/frameworks/constraint/pkg/types"
 	"github.com/open-policy-agent/gatekeeper/pkg/apis"
 	"github.com/open-policy-agent/gatekeeper/pkg/apis/config/v1alpha1"
 	"github.com/open-policy-agent/gatekeeper/pkg/controller/config"
+	"github.com/open-policy-agent/gatekeeper/pkg/controller/constraint"
+	"github.com/open-policy-agent/gatekeeper/pkg/controller/constrainttemplate"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
 	"github.com/open-policy-agent/gatekeeper/pkg/util"
 	admissionv1beta1 "k8s.io/api/admission/v1beta1"
 	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
 	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -46,32 +57,112 @@ var (
 	disableEnforcementActionValidation = flag.Bool("disable-enforcementaction-validation", false, "disable enforcementAction validation")
 	enableManualDeploy                 = flag.Bool("enable-manual-deploy", false, "allow users to manually create webhook related objects")
 	port                               = flag.Int("port", 443, "port for the server. defaulted to 443 if unspecified ")
+	validateConnects                   = flag.Bool("validate-connects", false, "review CONNECT requests, such as pods/exec and pods/portforward, instead of allowing them unreviewed. Also registers the webhook for CONNECT operations")
+	exemptGatekeeperNamespace          = flag.Bool("exempt-gatekeeper-namespace", true, "allow requests for resources in the --gatekeeper-namespace namespace, and for the namespace itself, without reviewing them against constraints, so a misconfigured constraint cannot block Gatekeeper's own rollout. set to false to enforce constraints in Gatekeeper's namespace")
+	validateDeletes                    = flag.Bool("validate-deletes", false, "register the webhook for DELETE operations so constraints can deny deletions. Constraints review the object being deleted as input.review.object. Requires Kubernetes v1.15.0+")
 	webhookName                        = flag.String("webhook-name", "validation.gatekeeper.sh", "domain name of the webhook, with at least three segments separated by dots. defaulted to validation.gatekeeper.sh if unspecified ")
 )
 
-var supportedEnforcementActions = []string{
-	"deny",
-	"dryrun",
-}
-
 // AddPolicyWebhook registers the policy webhook server with the manager
 // below: notations add permissions kube-mgmt needs. Access cannot yet be restricted on a namespace-level granularity
 // +kubebuilder:rbac:groups=*,resources=*,verbs=get;list;watch
 // +kubebuilder:rbac:groups=,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
-func AddPolicyWebhook(mgr manager.Manager, opa *opa.Client) error {
+func AddPolicyWebhook(mgr manager.Manager, opa *opa.Client, driver drivers.Driver, index *target.ConstraintIndex) error {
+	operations := []admissionregistrationv1beta1.OperationType{admissionregistrationv1beta1.Create, admissionregistrationv1beta1.Update}
+	if *validateDeletes {
+		operations = append(operations, admissionregistrationv1beta1.Delete)
+	}
+	if *validateConnects {
+		operations = append(operations, admissionregistrationv1beta1.Connect)
+	}
+	if *enableDebugEndpoints {
+		if err := validateDecisionBufferSize(*decisionBufferSize); err != nil {
+			return err
+		}
+	}
+	if err := validateUnsyncedAction(*referentialUnsyncedAction); err != nil {
+		return err
+	}
+	if err := validateConcurrencyLimits(*webhookMaxConcurrent, *perNamespaceAdmissionConcurrency, *webhookTimeout); err != nil {
+		return err
+	}
+	sink, err := newDecisionSink(*decisionLogSink)
+	if err != nil {
+		return err
+	}
+	redactor, err := newRedactor(*redactPaths)
+	if err != nil {
+		return err
+	}
+	handler := newValidationHandler(opa, driver, mgr.GetClient())
+	handler.mapper = mgr.GetRESTMapper()
+	handler.sink = sink
+	handler.redactor = redactor
+	if *webhookPrefilterConstraints {
+		handler.candidates = index
+	}
+	if runnable, ok := sink.(manager.Runnable); ok {
+		if err := mgr.Add(runnable); err != nil {
+			return err
+		}
+	}
+	if handler.statuses != nil {
+		if err := mgr.Add(handler.statuses); err != nil {
+			return err
+		}
+	}
+	debugDecisions = handler.decisions
+	// templates are always watched for their evaluation timeouts
+	templateInformer, err := mgr.GetCache().GetInformer(&v1beta1.ConstraintTemplate{})
+	if err != nil {
+		return err
+	}
+	handler.timeouts = newTemplateTimeouts()
+	templateInformer.AddEventHandler(handler.timeouts.eventHandler())
+	if trimEnabled() {
+		handler.trimOptOuts = newTrimOptOuts()
+		templateInformer.AddEventHandler(handler.trimOptOuts.eventHandler())
+	}
+	if handler.breaker != nil {
+		templateInformer.AddEventHandler(handler.breaker.eventHandler())
+	}
+	if timingTemplates() {
+		templateInformer.AddEventHandler(templateEvalMetricsEventHandler())
+	}
+	if *referentialUnsyncedAction != unsyncedEvaluate {
+		handler.referential = newReferentialTemplates(*referentialUnsyncedAction)
+		templateInformer.AddEventHandler(handler.referential.eventHandler())
+	}
+	if *exemptionsConfigMap != "" {
+		informer, err := mgr.GetCache().GetInformer(&corev1.ConfigMap{})
+		if err != nil {
+			return err
+		}
+		handler.exemptions = newExemptions(*exemptionsConfigMap)
+		informer.AddEventHandler(handler.exemptions.eventHandler())
+	}
+	selector, err := namespaceSelector()
+	if err != nil {
+		return err
+	}
+	resources, err := webhookResources()
+	if err != nil {
+		return err
+	}
 	validatingWh, err := builder.NewWebhookBuilder().
 		Validating().
 		Name(*webhookName).
 		Path("/v1/admit").
 		Rules(admissionregistrationv1beta1.RuleWithOperations{
-			Operations: []admissionregistrationv1beta1.OperationType{admissionregistrationv1beta1.Create, admissionregistrationv1beta1.Update},
+			Operations: operations,
 			Rule: admissionregistrationv1beta1.Rule{
 				APIGroups:   []string{"*"},
 				APIVersions: []string{"*"},
-				Resources:   []string{"*"},
+				Resources:   resources,
 			},
 		}).
-		Handlers(&validationHandler{opa: opa, client: mgr.GetClient()}).
+		NamespaceSelector(selector).
+		Handlers(handler).
 		WithManager(mgr).
 		Build()
 	if err != nil {
@@ -79,11 +170,12 @@ func AddPolicyWebhook(mgr manager.Manager, opa *opa.Client) error {
 	}
 
 	serverOptions := webhook.ServerOptions{
-		CertDir: "/certs",
+		CertDir: *certDir,
 		Port:    int32(*port),
 	}
 
-	if *enableManualDeploy == false {
+	// the webhook configuration must be installed by another instance in read-only mode
+	if *enableManualDeploy == false && !util.ReadOnly() {
 		serverOptions.BootstrapOptions = &webhook.BootstrapOptions{
 			ValidatingWebhookConfigName: *webhookName,
 			Secret: &types.NamespacedName{
@@ -113,26 +205,162 @@ func AddPolicyWebhook(mgr manager.Manager, opa *opa.Client) error {
 		return err
 	}
 
-	return nil
+	guard := &webhookConfigGuard{reader: mgr.GetClient(), name: *webhookName}
+	if *manageWebhookConfig && serverOptions.BootstrapOptions != nil {
+		guard.install = s.InstallWebhookManifests
+	}
+	if err := mgr.Add(guard); err != nil {
+		return err
+	}
+	return mgr.Add(&certExpiryMonitor{path: filepath.Join(*certDir, serverCertName)})
 }
 
 var _ admission.Handler = &validationHandler{}
 
 type validationHandler struct {
 	opa    *opa.Client
+	driver drivers.Driver
 	client client.Client
+	pauser pauser
+	cache  *decisionCache
+	// trimOptOuts is nil until the webhook watches templates
+	trimOptOuts *trimOptOuts
+	// breaker is nil unless --template-error-threshold is set
+	breaker *templateBreaker
+	// statuses writes the exclusions of breaker to the template statuses. It is nil unless
+	// breaker is set and the handler has a client.
+	statuses *templateStatuses
+	// decisions is nil unless --enable-debug-endpoints is set
+	decisions *decisionBuffer
+	// sink is nil unless --decision-log-sink is set
+	sink decisionSink
+	// limiter is nil unless --webhook-max-concurrent is set
+	limiter *reviewLimiter
+	// timeouts tracks the evaluation timeouts templates set with EvalTimeoutAnnotation
+	timeouts *templateTimeouts
+	// namespaceLimiter is nil unless --per-namespace-admission-concurrency is set
+	namespaceLimiter *namespaceLimiter
+	// referential is nil unless --referential-unsynced-action is deny or skip
+	referential *referentialTemplates
+	// exemptions is nil unless --exemptions-configmap is set
+	exemptions *exemptions
+	// redactor is nil if --redact-paths is empty
+	redactor *redactor
+	// candidates is nil unless --webhook-prefilter-constraints is set
+	candidates *target.ConstraintIndex
+	// mapper tells namespaced kinds from cluster-scoped ones. Requests without a namespace are
+	// not defaulted to the default namespace if it is nil.
+	mapper meta.RESTMapper
 
 	// for testing
 	injectedConfig *v1alpha1.Config
 }
 
+func newValidationHandler(opa *opa.Client, driver drivers.Driver, c client.Client) *validationHandler {
+	h := &validationHandler{opa: opa, driver: driver, client: c}
+	if *enforcementPauseFile != "" {
+		h.pauser = newFilePauser(*enforcementPauseFile)
+	}
+	if *fallbackToCachedDecision {
+		h.cache = newDecisionCache(*decisionCacheSize)
+	}
+	if *enableDebugEndpoints {
+		h.decisions = newDecisionBuffer(*decisionBufferSize)
+	}
+	if *webhookMaxConcurrent > 0 {
+		h.limiter = newReviewLimiter(*webhookMaxConcurrent)
+	}
+	if *perNamespaceAdmissionConcurrency > 0 {
+		h.namespaceLimiter = newNamespaceLimiter(*perNamespaceAdmissionConcurrency)
+	}
+	if *templateErrorThreshold > 0 && driver != nil {
+		h.breaker = newTemplateBreaker(*templateErrorThreshold)
+		if c != nil {
+			h.statuses = newTemplateStatuses(h.breaker, func(kind string, cause error) { setEvaluationDisabled(c, kind, cause) })
+			h.breaker.onTrip = func(kind string, err error) { h.statuses.enqueue(kind) }
+			h.breaker.onReset = h.statuses.enqueue
+		}
+	}
+	return h
+}
+
 // Handle the validation request
 func (h *validationHandler) Handle(ctx context.Context, req atypes.Request) atypes.Response {
+	resp := h.handle(ctx, req)
+	// the webhook server echoes the uid too, but webhook chains depend on it, so do not rely
+	// on every caller of the handler doing so
+	if req.AdmissionRequest != nil && resp.Response != nil {
+		resp.Response.UID = req.AdmissionRequest.UID
+	}
+	if util.DryRun() && resp.Response != nil && !resp.Response.Allowed {
+		resp = dryRunResponse(req, resp)
+	}
+	if *strictAdmissionResponse {
+		checkResponse(req.AdmissionRequest, resp.Response)
+	}
+	return resp
+}
+
+// namespaced returns true if the kind of req is known to be namespaced
+func (h *validationHandler) namespaced(req *admissionv1beta1.AdmissionRequest) bool {
+	return isNamespaced(h.mapper, req.Kind)
+}
+
+// isNamespaced returns true if mapper knows kind to be namespaced
+func isNamespaced(mapper meta.RESTMapper, kind metav1.GroupVersionKind) bool {
+	if mapper == nil {
+		return false
+	}
+	mapping, err := mapper.RESTMapping(schema.GroupKind{Group: kind.Group, Kind: kind.Kind}, kind.Version)
+	if err != nil {
+		log.V(1).Info("unable to tell whether kind is namespaced", "kind", kind, "error", err.Error())
+		return false
+	}
+	return mapping.Scope.Name() == meta.RESTScopeNameNamespace
+}
+
+// handle decides the validation request
+func (h *validationHandler) handle(ctx context.Context, req atypes.Request) atypes.Response {
 	log := log.WithValues("hookType", "validation")
+	if err := checkDecodable(req.AdmissionRequest); err != nil {
+		decodeErrorsTotal.Inc()
+		log.Error(err, "unable to decode admission request")
+		return admission.ErrorResponse(http.StatusBadRequest, err)
+	}
+	req.AdmissionRequest.Namespace = target.EffectiveNamespace(req.AdmissionRequest, h.namespaced(req.AdmissionRequest))
 	if isGkServiceAccount(req.AdmissionRequest.UserInfo) {
 		return admission.ValidationResponse(true, "Gatekeeper does not self-manage")
 	}
 
+	// CONNECT requests reach the handler when the webhook is registered for all operations.
+	// Their object is the options of the connection, e.g. PodExecOptions, not a resource.
+	if req.AdmissionRequest.Operation == admissionv1beta1.Connect && !*validateConnects {
+		return admission.ValidationResponse(true, "Gatekeeper does not review CONNECT requests")
+	}
+
+	// Gatekeeper's own resources are validated even while enforcement is paused, as an invalid
+	// template or constraint cannot be loaded. Deleting one must remain possible.
+	if req.AdmissionRequest.Operation != admissionv1beta1.Delete {
+		if userErr, err := h.validateGatekeeperResources(ctx, req); err != nil {
+			vResp := admission.ValidationResponse(false, err.Error())
+			if vResp.Response.Result == nil {
+				vResp.Response.Result = &metav1.Status{}
+			}
+			if userErr {
+				vResp.Response.Result.Code = http.StatusUnprocessableEntity
+			} else {
+				vResp.Response.Result.Code = http.StatusInternalServerError
+			}
+			return vResp
+		}
+	}
+
+	if h.pauser != nil && h.pauser.Paused() {
+		pausedRequestsTotal.Inc()
+		log.Info("enforcement is paused, allowing request", "kind", req.AdmissionRequest.Kind, "namespace", req.AdmissionRequest.Namespace, "name", req.AdmissionRequest.Name)
+		return admission.ValidationResponse(true, "Gatekeeper enforcement is paused")
+	}
+
 	if req.AdmissionRequest.Operation == admissionv1beta1.Delete {
 		// oldObject is the existing object.
 		// It is null for DELETE operations in API servers prior to v1.15.0.
@@ -151,22 +379,52 @@ func (h *validationHandler) Handle(ctx context.Context, req atypes.Request) atyp
 		}
 	}
 
-	if userErr, err := h.validateGatekeeperResources(ctx, req); err != nil {
-		vResp := admission.ValidationResponse(false, err.Error())
-		if vResp.Response.Result == nil {
-			vResp.Response.Result = &metav1.Status{}
+	if *exemptGatekeeperNamespace && inGatekeeperNamespace(req.AdmissionRequest) {
+		return h.handleExempted(ctx, req, "Gatekeeper does not review resources in its own namespace")
+	}
+
+	if reason := h.exemptions.get().exempt(req.AdmissionRequest); reason != "" {
+		exemptedRequestsTotal.Inc()
+		return h.handleExempted(ctx, req, reason)
+	}
+
+	start := time.Now()
+	reviewCtx, cancel := reviewContext(ctx)
+	defer cancel()
+	// the slot of the namespace is taken first, so that the requests waiting in a busy
+	// namespace do not take the slots shared with other namespaces
+	if h.namespaceLimiter != nil {
+		if err := h.namespaceLimiter.acquire(reviewCtx, req.AdmissionRequest.Namespace); err != nil {
+			return tooManyRequestsResponse(req, err, start)
 		}
-		if userErr {
-			vResp.Response.Result.Code = http.StatusUnprocessableEntity
-		} else {
-			vResp.Response.Result.Code = http.StatusInternalServerError
+		defer h.namespaceLimiter.release(req.AdmissionRequest.Namespace)
+	}
+	if h.limiter != nil {
+		if err := h.limiter.acquire(reviewCtx); err != nil {
+			return tooManyRequestsResponse(req, err, start)
 		}
-		return vResp
+		defer h.limiter.release()
+	}
+	resp, err := h.reviewRequest(reviewCtx, req)
+	if _, ok := err.(*evalTimeoutError); ok && denies(resp.Results()) {
+		// the templates that finished deny the request, whatever the slow ones would decide
+		log.Info("denying request without the results of templates that timed out", "kind", req.AdmissionRequest.Kind, "namespace", req.AdmissionRequest.Namespace, "name", req.AdmissionRequest.Name, "error", err.Error())
+		err = nil
 	}
-
-	resp, err := h.reviewRequest(ctx, req)
 	if err != nil {
-		log.Error(err, "error executing query")
+		if reviewCtx.Err() == context.DeadlineExceeded {
+			deadlineExceededTotal.Inc()
+			log.Error(err, "review did not finish before the deadline", "kind", req.AdmissionRequest.Kind, "namespace", req.AdmissionRequest.Namespace, "name", req.AdmissionRequest.Name, "duration", time.Since(start).String())
+		} else {
+			log.Error(err, "error executing query")
+		}
+		if h.cache != nil {
+			if cached, ok := h.cache.Get(req.AdmissionRequest); ok {
+				cachedFallbackTotal.Inc()
+				log.Info("falling back to cached decision", "kind", req.AdmissionRequest.Kind, "namespace", req.AdmissionRequest.Namespace, "name", req.AdmissionRequest.Name, "allowed", cached.Response.Allowed)
+				return cached
+			}
+		}
 		vResp := admission.ValidationResponse(false, err.Error())
 		if vResp.Response.Result == nil {
 			vResp.Response.Result = &metav1.Status{}
@@ -174,12 +432,79 @@ func (h *validationHandler) Handle(ctx context.Context, req atypes.Request) atyp
 		vResp.Response.Result.Code = http.StatusInternalServerError
 		return vResp
 	}
+	duration := time.Since(start)
+	res := resp.Results()
+	util.SortResults(res)
+	vResp := validationResponse(resp)
+	admissionStatus := "allow"
+	if !vResp.Response.Allowed {
+		admissionStatus = "deny"
+	}
+	validationRequestsTotal.WithLabelValues(admissionStatus, strconv.FormatBool(isDryRun(req.AdmissionRequest))).Inc()
+	logged := shouldLogDecision(vResp.Response.Allowed)
+	if len(res) == 0 || logged || h.decisions != nil {
+		matched, ok := h.matchedConstraints(ctx, req)
+		if ok && len(matched) == 0 {
+			unmatchedRequestsTotal.Inc()
+		}
+		if !ok {
+			for _, r := range res {
+				matched = append(matched, constraintName(r.Constraint))
+			}
+		}
+		if logged {
+			logDecision(req, vResp, matched, duration)
+		}
+		if h.decisions != nil {
+			h.decisions.add(req, vResp, matched, duration)
+		}
+	}
+	if h.sink != nil {
+		redacted, _ := h.redactor.request(req.AdmissionRequest)
+		h.sink.send(newDecisionLogEntry(atypes.Request{AdmissionRequest: redacted}, vResp, duration))
+	}
+	if h.cache != nil {
+		h.cache.Add(req.AdmissionRequest, vResp)
+	}
+	return vResp
+}
+
+// dryRunResponse allows the request denied by resp, for --dry-run
+func dryRunResponse(req atypes.Request, resp atypes.Response) atypes.Response {
+	dryRunDeniedTotal.Inc()
+	var reason string
+	if result := resp.Response.Result; result != nil {
+		// denials carry their messages as the reason, errors as the message
+		reason = string(result.Reason)
+		if reason == "" {
+			reason = result.Message
+		}
+	}
+	if req.AdmissionRequest != nil {
+		log.Info("dry-run, allowing request that would have been denied", "kind", req.AdmissionRequest.Kind, "namespace", req.AdmissionRequest.Namespace, "name", req.AdmissionRequest.Name, "reason", reason)
+	}
+	allowed := admission.ValidationResponse(true, "dry-run: "+reason)
+	allowed.Response.UID = resp.Response.UID
+	return allowed
+}
+
+// validationResponse builds the admission response for the results of a review
+func validationResponse(resp *rtypes.Responses) atypes.Response {
 	res := resp.Results()
+	util.ResolveEnforcementActions(res)
+	util.SortResults(res)
 	if len(res) != 0 {
 		var msgs []string
+		var causes []metav1.StatusCause
 		for _, r := range res {
 			if r.EnforcementAction == "deny" {
-				msgs = append(msgs, fmt.Sprintf("[denied by %s] %s", r.Constraint.GetName(), r.Msg))
+				msg := fmt.Sprintf("[denied by %s] %s", r.Constraint.GetName(), r.Msg)
+				msgs = append(msgs, msg)
+				// denials with a reason code are also listed as causes, so clients can
+				// classify them without parsing the message
+				if code := util.ViolationCode(r); code != "" {
+					causes = append(causes, metav1.StatusCause{Type: metav1.CauseType(code), Message: msg})
+				}
 			}
 		}
 		if len(msgs) > 0 {
@@ -188,6 +513,9 @@ func (h *validationHandler) Handle(ctx context.Context, req atypes.Request) atyp
 				vResp.Response.Result = &metav1.Status{}
 			}
 			vResp.Response.Result.Code = http.StatusForbidden
+			if len(causes) > 0 {
+				vResp.Response.Result.Details = &metav1.StatusDetails{Causes: causes}
+			}
 			return vResp
 		}
 	}
@@ -215,13 +543,23 @@ func isGkServiceAccount(user authenticationv1.UserInfo) bool {
 	return false
 }
 
+// inGatekeeperNamespace returns true if req is for a resource in Gatekeeper's namespace or for
+// the namespace itself
+func inGatekeeperNamespace(req *admissionv1beta1.AdmissionRequest) bool {
+	ns := util.GetNamespace()
+	if req.Namespace == ns {
+		return true
+	}
+	return req.Kind.Group == "" && req.Kind.Kind == "Namespace" && req.Name == ns
+}
+
 // validateGatekeeperResources returns whether an issue is user error (vs internal) and any errors
 // validating internal resources
 func (h *validationHandler) validateGatekeeperResources(ctx context.Context, req atypes.Request) (bool, error) {
 	if req.AdmissionRequest.Kind.Group == "templates.gatekeeper.sh" && req.AdmissionRequest.Kind.Kind == "ConstraintTemplate" {
 		return h.validateTemplate(ctx, req)
 	}
-	if req.AdmissionRequest.Kind.Group == "constraints.gatekeeper.sh" {
+	if req.AdmissionRequest.Kind.Group == constraint.Group || req.AdmissionRequest.Kind.Group == constraint.NamespacedGroup {
 		return h.validateConstraint(ctx, req)
 	}
 	return false, nil
@@ -236,6 +574,11 @@ func (h *validationHandler) validateTemplate(ctx context.Context, req atypes.Req
 	if err := runtimeScheme.Convert(templ, unversioned, nil); err != nil {
 		return false, err
 	}
+	// the template controller loads the admission target alone and warns of the others
+	constrainttemplate.RemoveUnsupportedTargets(unversioned)
+	if _, err := constrainttemplate.AddSharedLibs(ctx, h.client, unversioned); err != nil {
+		return true, err
+	}
 	if _, err := h.opa.CreateCRD(ctx, unversioned); err != nil {
 		return true, err
 	}
@@ -247,7 +590,32 @@ func (h *validationHandler) validateConstraint(ctx context.Context, req atypes.R
 	if _, _, err := deserializer.Decode(req.AdmissionRequest.Object.Raw, nil, obj); err != nil {
 		return false, err
 	}
-	if err := h.opa.ValidateConstraint(ctx, obj); err != nil {
+	if err := constraint.ValidateName(obj); err != nil {
+		return true, err
+	}
+	if err := constraint.ValidateNamespacedMatch(obj); err != nil {
+		return true, err
+	}
+	enforced, err := constraint.ToClusterConstraint(obj)
+	if err != nil {
+		return false, err
+	}
+	if err := h.opa.ValidateConstraint(ctx, enforced); err != nil {
+		return true, err
+	}
+	if err := util.ValidateAuditViolationsLimit(obj); err != nil {
+		return true, err
+	}
+	if err := util.ValidateBypassable(obj); err != nil {
+		return true, err
+	}
+	if err := util.ValidateDebug(obj); err != nil {
+		return true, err
+	}
+	if err := util.ValidateDenyAfter(obj); err != nil {
+		return true, err
+	}
+	if err := util.ValidateViolationThreshold(obj); err != nil {
 		return true, err
 	}
 
@@ -257,7 +625,7 @@ func (h *validationHandler) validateConstraint(ctx context.Context, req atypes.R
 	}
 	if found && enforcementActionString != "" {
 		if *disableEnforcementActionValidation == false {
-			err = validateEnforcementAction(enforcementActionString)
+			err = util.ValidateEnforcementAction(enforcementActionString)
 			if err != nil {
 				return false, err
 			}
@@ -268,17 +636,28 @@ func (h *validationHandler) validateConstraint(ctx context.Context, req atypes.R
 	return false, nil
 }
 
-func validateEnforcementAction(input string) error {
-	for _, n := range supportedEnforcementActions {
-		if input == n {
-			return nil
-		}
+// matchedConstraints returns the kind and name of every constraint matching the request.
+// ok is false if the matched constraints could not be determined.
+func (h *validationHandler) matchedConstraints(ctx context.Context, req atypes.Request) (matched []string, ok bool) {
+	if h.driver == nil {
+		return nil, false
+	}
+	matched, err := matchingConstraints(ctx, h.driver, req.AdmissionRequest)
+	if err != nil {
+		log.Error(err, "error listing matched constraints")
+		return nil, false
 	}
-	return fmt.Errorf("Could not find the provided enforcementAction value within the supported list %v", supportedEnforcementActions)
+	return matched, true
 }
 
 // traceSwitch returns true if a request should be traced
 func (h *validationHandler) reviewRequest(ctx context.Context, req atypes.Request) (*rtypes.Responses, error) {
+	// do not start a review there is no time left to finish
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// let low priority audits give way to the review
+	defer util.AdmissionStarted()()
 	cfg, _ := h.getConfig(ctx)
 	traceEnabled := false
 	dump := false
@@ -299,12 +678,35 @@ func (h *validationHandler) reviewRequest(ctx context.Context, req atypes.Reques
 		}
 	}
 
-	resp, err := h.opa.Review(ctx, req.AdmissionRequest, opa.Tracing(traceEnabled))
-	if traceEnabled {
-		log.Info(resp.TraceDump())
+	review, err := h.trimRequest(req.AdmissionRequest)
+	if err != nil {
+		return nil, err
+	}
+	var resp *rtypes.Responses
+	if h.reviewsEachConstraint() {
+		resp, err = h.reviewEach(ctx, review, traceEnabled, shortCircuiting())
+	} else {
+		resp, err = h.review(ctx, review, traceEnabled)
+		if err != nil && h.breaker != nil && ctx.Err() == nil {
+			// review the constraints one at a time to find the templates that fail
+			resp, err = h.reviewEach(ctx, review, traceEnabled, false)
+		} else if err == nil && h.breaker.failing() {
+			h.recordEvaluated(ctx, review)
+		}
+	}
+	if traceEnabled && resp != nil {
+		// traces hold the values of the fields the rego evaluated, which cannot be redacted
+		if _, redacted := h.redactor.request(req.AdmissionRequest); redacted {
+			log.Info("not logging the trace of a request with fields selected by --redact-paths", "kind", req.AdmissionRequest.Kind, "namespace", req.AdmissionRequest.Namespace, "name", req.AdmissionRequest.Name)
+		} else {
+			log.Info(resp.TraceDump())
+		}
 	}
 	if dump {
 		dump, err := h.opa.Dump(ctx)
+		if err == nil {
+			dump, err = h.redactor.dump(dump)
+		}
 		if err != nil {
 			log.Error(err, "dump error")
 		} else {
@@ -1,21 +1,55 @@
 package webhook
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/ghodss/yaml"
+	"github.com/go-logr/logr"
 	templv1beta1 "github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
 	"github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers"
 	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers/local"
 	"github.com/open-policy-agent/frameworks/constraint/pkg/core/templates"
+	rtypes "github.com/open-policy-agent/frameworks/constraint/pkg/types"
 	"github.com/open-policy-agent/gatekeeper/pkg/apis/config/v1alpha1"
+	"github.com/open-policy-agent/gatekeeper/pkg/controller/constrainttemplate"
 	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	"github.com/open-policy-agent/gatekeeper/version"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	admissionregistrationv1beta1 "k8s.io/api/admissionregistration/v1beta1"
 	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 	atypes "sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+	webhooktypes "sigs.k8s.io/controller-runtime/pkg/webhook/types"
 )
 
 const (
@@ -64,6 +98,53 @@ spec:
         }
 `
 
+	multi_target_template = `
+apiVersion: templates.gatekeeper.sh/v1beta1
+kind: ConstraintTemplate
+metadata:
+  name: k8smultitarget
+spec:
+  crd:
+    spec:
+      names:
+        kind: K8sMultiTarget
+  targets:
+    - target: admission.k8s.gatekeeper.sh
+      rego: |
+        package multitarget
+
+        violation[{"msg": msg}] {
+          msg := "denied"
+        }
+    - target: unknown.example.com
+      rego: |
+        package multitarget
+
+        deny[msg] {
+          msg := "denied"
+        }
+`
+
+	unknown_target_template = `
+apiVersion: templates.gatekeeper.sh/v1beta1
+kind: ConstraintTemplate
+metadata:
+  name: k8sunknowntarget
+spec:
+  crd:
+    spec:
+      names:
+        kind: K8sUnknownTarget
+  targets:
+    - target: unknown.example.com
+      rego: |
+        package unknowntarget
+
+        deny[msg] {
+          msg := "denied"
+        }
+`
+
 	bad_labelselector = `
 apiVersion: constraints.gatekeeper.sh/v1beta1
 kind: K8sGoodRego
@@ -155,20 +236,208 @@ spec:
       - apiGroups: [""]
         kinds: ["Pod"]
 `
+
+	good_auditviolationslimit = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sGoodRego
+metadata:
+  name: good-auditviolationslimit
+spec:
+  auditViolationsLimit: 100
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Pod"]
+`
+
+	bad_auditviolationslimit = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sGoodRego
+metadata:
+  name: bad-auditviolationslimit
+spec:
+  auditViolationsLimit: -1
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Pod"]
+`
+
+	// serving_cert expires at 2030-01-01T00:00:00Z
+	serving_cert = `-----BEGIN CERTIFICATE-----
+MIIBxTCCAWugAwIBAgIBATAKBggqhkjOPQQDAjBGMUQwQgYDVQQDEztnYXRla2Vl
+cGVyLWNvbnRyb2xsZXItbWFuYWdlci1zZXJ2aWNlLmdhdGVrZWVwZXItc3lzdGVt
+LnN2YzAeFw0yMDAxMDEwMDAwMDBaFw0zMDAxMDEwMDAwMDBaMEYxRDBCBgNVBAMT
+O2dhdGVrZWVwZXItY29udHJvbGxlci1tYW5hZ2VyLXNlcnZpY2UuZ2F0ZWtlZXBl
+ci1zeXN0ZW0uc3ZjMFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEU+/ossB+QJDL
+a28VzJz2Kbv+n5U85lFvPSLhah60xkgjlFh9v4XIeVWoh2o0WWcQBC+I+/GDcYcC
+KzugUzdmK6NKMEgwRgYDVR0RBD8wPYI7Z2F0ZWtlZXBlci1jb250cm9sbGVyLW1h
+bmFnZXItc2VydmljZS5nYXRla2VlcGVyLXN5c3RlbS5zdmMwCgYIKoZIzj0EAwID
+SAAwRQIhAJag8TyPv1y8IVoJXI1n8jVXQrJpanwK+LknND7UEYQ8AiAc+qkXbcC1
+efMPx7fyAVRsQ6l6WgoqsWpxUIJYxyhaZw==
+-----END CERTIFICATE-----
+`
+
+	deny_all_namespaces = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sGoodRego
+metadata:
+  name: deny-all-namespaces
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Namespace"]
+`
+
+	coded_rego_template = `
+apiVersion: templates.gatekeeper.sh/v1beta1
+kind: ConstraintTemplate
+metadata:
+  name: k8scodedrego
+spec:
+  crd:
+    spec:
+      names:
+        kind: K8sCodedRego
+        listKind: K8sCodedRegoList
+        plural: k8scodedrego
+        singular: k8scodedrego
+  targets:
+    - target: admission.k8s.gatekeeper.sh
+      rego: |
+        package codedrego
+
+        violation[{"msg": msg, "details": {"code": "missing_owner"}}] {
+          msg := "no owner"
+        }
+`
+
+	coded_deny_all_namespaces = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sCodedRego
+metadata:
+  name: coded-deny-all-namespaces
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Namespace"]
+`
+
+	deny_all_namespaces_explicitly = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sGoodRego
+metadata:
+  name: deny-all-namespaces-explicitly
+spec:
+  enforcementAction: deny
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Namespace"]
+`
+
+	conflict_rego_template = `
+apiVersion: templates.gatekeeper.sh/v1beta1
+kind: ConstraintTemplate
+metadata:
+  name: k8sconflictrego
+spec:
+  crd:
+    spec:
+      names:
+        kind: K8sConflictRego
+        listKind: K8sConflictRegoList
+        plural: k8sconflictrego
+        singular: k8sconflictrego
+  targets:
+    - target: admission.k8s.gatekeeper.sh
+      rego: |
+        package conflict
+
+        conflict = "a" { true }
+        conflict = "b" { true }
+
+        violation[{"msg": msg}] {
+          msg := conflict
+        }
+`
+
+	conflict_all_namespaces = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sConflictRego
+metadata:
+  name: conflict-all-namespaces
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Namespace"]
+`
 )
 
+// makeDenyingHandler returns a handler whose OPA client denies every Namespace
+func makeDenyingHandler(t testing.TB) *validationHandler {
+	opa, driver, err := makeOpaClientAndDriver()
+	if err != nil {
+		t.Fatalf("Could not initialize OPA: %s", err)
+	}
+	cstr := &templv1beta1.ConstraintTemplate{}
+	if err := yaml.Unmarshal([]byte(good_rego_template), cstr); err != nil {
+		t.Fatalf("Could not instantiate template: %s", err)
+	}
+	unversioned := &templates.ConstraintTemplate{}
+	if err := runtimeScheme.Convert(cstr, unversioned, nil); err != nil {
+		t.Fatalf("Could not convert to unversioned: %v", err)
+	}
+	if _, err := opa.AddTemplate(context.Background(), unversioned); err != nil {
+		t.Fatalf("Could not add template: %s", err)
+	}
+	cnstr := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(deny_all_namespaces), &cnstr.Object); err != nil {
+		t.Fatalf("Could not instantiate constraint: %s", err)
+	}
+	if _, err := opa.AddConstraint(context.Background(), cnstr); err != nil {
+		t.Fatalf("Could not add constraint: %s", err)
+	}
+	return &validationHandler{opa: opa, driver: driver, injectedConfig: &v1alpha1.Config{}}
+}
+
+func namespaceRequest(name string) atypes.Request {
+	return atypes.Request{
+		AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+			Kind: metav1.GroupVersionKind{
+				Group:   "",
+				Version: "v1",
+				Kind:    "Namespace",
+			},
+			Name:      name,
+			Operation: admissionv1beta1.Create,
+			Object: runtime.RawExtension{
+				Raw: []byte(fmt.Sprintf(`{"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "%s"}}`, name)),
+			},
+		},
+	}
+}
+
 func makeOpaClient() (*client.Client, error) {
+	c, _, err := makeOpaClientAndDriver()
+	return c, err
+}
+
+func makeOpaClientAndDriver() (*client.Client, drivers.Driver, error) {
 	target := &target.K8sValidationTarget{}
 	driver := local.New(local.Tracing(false))
 	backend, err := client.NewBackend(client.Driver(driver))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	c, err := backend.NewClient(client.Targets(target))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return c, nil
+	return c, driver, nil
 }
 
 func TestTemplateValidation(t *testing.T) {
@@ -187,6 +456,16 @@ func TestTemplateValidation(t *testing.T) {
 			Template:      bad_rego_template,
 			ErrorExpected: true,
 		},
+		{
+			Name:          "Template With An Unknown Target",
+			Template:      multi_target_template,
+			ErrorExpected: false,
+		},
+		{
+			Name:          "Template Without The Admission Target",
+			Template:      unknown_target_template,
+			ErrorExpected: true,
+		},
 	}
 	for _, tt := range tc {
 		t.Run(tt.Name, func(t *testing.T) {
@@ -265,6 +544,18 @@ func TestConstraintValidation(t *testing.T) {
 			Constraint:    bad_enforcementaction,
 			ErrorExpected: true,
 		},
+		{
+			Name:          "Valid Constraint auditViolationsLimit",
+			Template:      good_rego_template,
+			Constraint:    good_auditviolationslimit,
+			ErrorExpected: false,
+		},
+		{
+			Name:          "Invalid Constraint auditViolationsLimit",
+			Template:      good_rego_template,
+			Constraint:    bad_auditviolationslimit,
+			ErrorExpected: true,
+		},
 	}
 	for _, tt := range tc {
 		t.Run(tt.Name, func(t *testing.T) {
@@ -438,3 +729,2807 @@ func TestTracing(t *testing.T) {
 		})
 	}
 }
+
+func TestEnforcementPause(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gk-pause")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	pauseFile := filepath.Join(dir, "paused")
+
+	handler := makeDenyingHandler(t)
+	handler.pauser = newFilePauser(pauseFile)
+	req := namespaceRequest("paused")
+
+	if resp := handler.Handle(context.Background(), req); resp.Response.Allowed {
+		t.Fatal("request allowed before pausing; want denied")
+	}
+	if err := ioutil.WriteFile(pauseFile, []byte("true"), 0644); err != nil {
+		t.Fatalf("Could not write pause file: %s", err)
+	}
+	if resp := handler.Handle(context.Background(), req); !resp.Response.Allowed {
+		t.Error("request denied while paused; want allowed")
+	}
+	// Gatekeeper's own resources are still validated
+	b, err := yaml.YAMLToJSON([]byte(bad_rego_template))
+	if err != nil {
+		t.Fatalf("Error parsing yaml: %s", err)
+	}
+	templReq := atypes.Request{
+		AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+			Kind:      metav1.GroupVersionKind{Group: "templates.gatekeeper.sh", Version: "v1beta1", Kind: "ConstraintTemplate"},
+			Operation: admissionv1beta1.Create,
+			Object:    runtime.RawExtension{Raw: b},
+		},
+	}
+	if resp := handler.Handle(context.Background(), templReq); resp.Response.Allowed {
+		t.Error("invalid template allowed while paused; want denied")
+	}
+	if err := os.Remove(pauseFile); err != nil {
+		t.Fatalf("Could not remove pause file: %s", err)
+	}
+	if resp := handler.Handle(context.Background(), req); resp.Response.Allowed {
+		t.Error("request allowed after resuming; want denied")
+	}
+}
+
+func TestCachedDecisionFallback(t *testing.T) {
+	handler := makeDenyingHandler(t)
+	handler.cache = newDecisionCache(10)
+	req := namespaceRequest("cached")
+
+	if resp := handler.Handle(context.Background(), req); resp.Response.Allowed {
+		t.Fatal("request allowed; want denied")
+	}
+
+	// break evaluation for every Namespace
+	cstr := &templv1beta1.ConstraintTemplate{}
+	if err := yaml.Unmarshal([]byte(conflict_rego_template), cstr); err != nil {
+		t.Fatalf("Could not instantiate template: %s", err)
+	}
+	unversioned := &templates.ConstraintTemplate{}
+	if err := runtimeScheme.Convert(cstr, unversioned, nil); err != nil {
+		t.Fatalf("Could not convert to unversioned: %v", err)
+	}
+	if _, err := handler.opa.AddTemplate(context.Background(), unversioned); err != nil {
+		t.Fatalf("Could not add template: %s", err)
+	}
+	cnstr := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(conflict_all_namespaces), &cnstr.Object); err != nil {
+		t.Fatalf("Could not instantiate constraint: %s", err)
+	}
+	if _, err := handler.opa.AddConstraint(context.Background(), cnstr); err != nil {
+		t.Fatalf("Could not add constraint: %s", err)
+	}
+
+	resp := handler.Handle(context.Background(), req)
+	if resp.Response.Allowed {
+		t.Error("cached request allowed; want the cached denial")
+	}
+	if resp.Response.Result == nil || resp.Response.Result.Code != http.StatusForbidden {
+		t.Errorf("cached request result = %v; want code %d", resp.Response.Result, http.StatusForbidden)
+	}
+
+	resp = handler.Handle(context.Background(), namespaceRequest("uncached"))
+	if resp.Response.Allowed {
+		t.Error("uncached request allowed; want error")
+	}
+	if resp.Response.Result == nil || resp.Response.Result.Code != http.StatusInternalServerError {
+		t.Errorf("uncached request result = %v; want code %d", resp.Response.Result, http.StatusInternalServerError)
+	}
+}
+
+func TestDecisionCacheEviction(t *testing.T) {
+	c := newDecisionCache(2)
+	a, b, d := namespaceRequest("a"), namespaceRequest("b"), namespaceRequest("d")
+	c.Add(a.AdmissionRequest, admission.ValidationResponse(true, ""))
+	c.Add(b.AdmissionRequest, admission.ValidationResponse(true, ""))
+	// touch a so b is the least recently used
+	if _, ok := c.Get(a.AdmissionRequest); !ok {
+		t.Fatal("a not cached")
+	}
+	c.Add(d.AdmissionRequest, admission.ValidationResponse(false, "no"))
+	if _, ok := c.Get(b.AdmissionRequest); ok {
+		t.Error("b still cached; want evicted")
+	}
+	if _, ok := c.Get(a.AdmissionRequest); !ok {
+		t.Error("a evicted; want cached")
+	}
+	if resp, ok := c.Get(d.AdmissionRequest); !ok || resp.Response.Allowed {
+		t.Errorf("d = %v, %v; want cached denial", resp.Response, ok)
+	}
+}
+
+func TestDefaultEnforcementAction(t *testing.T) {
+	defer flag.Set("default-enforcement-action", "deny")
+
+	tc := []struct {
+		Name          string
+		Default       string
+		Explicit      bool
+		ExpectAllowed bool
+	}{
+		{
+			Name:          "Omitted action defaults to deny",
+			Default:       "deny",
+			ExpectAllowed: false,
+		},
+		{
+			Name:          "Omitted action defaults to dryrun",
+			Default:       "dryrun",
+			ExpectAllowed: true,
+		},
+		{
+			Name:          "Explicit action overrides dryrun default",
+			Default:       "dryrun",
+			Explicit:      true,
+			ExpectAllowed: false,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			if err := flag.Set("default-enforcement-action", tt.Default); err != nil {
+				t.Fatalf("Could not set default enforcement action: %s", err)
+			}
+			handler := makeDenyingHandler(t)
+			if tt.Explicit {
+				cnstr := &unstructured.Unstructured{}
+				if err := yaml.Unmarshal([]byte(deny_all_namespaces_explicitly), &cnstr.Object); err != nil {
+					t.Fatalf("Could not instantiate constraint: %s", err)
+				}
+				if _, err := handler.opa.AddConstraint(context.Background(), cnstr); err != nil {
+					t.Fatalf("Could not add constraint: %s", err)
+				}
+			}
+			resp := handler.Handle(context.Background(), namespaceRequest("default-action"))
+			if resp.Response.Allowed != tt.ExpectAllowed {
+				t.Errorf("allowed = %v; want %v", resp.Response.Allowed, tt.ExpectAllowed)
+			}
+		})
+	}
+}
+
+func TestDenialCauses(t *testing.T) {
+	handler := makeDenyingHandler(t)
+	templ := &templv1beta1.ConstraintTemplate{}
+	if err := yaml.Unmarshal([]byte(coded_rego_template), templ); err != nil {
+		t.Fatalf("Could not instantiate template: %s", err)
+	}
+	unversioned := &templates.ConstraintTemplate{}
+	if err := runtimeScheme.Convert(templ, unversioned, nil); err != nil {
+		t.Fatalf("Could not convert to unversioned: %v", err)
+	}
+	if _, err := handler.opa.AddTemplate(context.Background(), unversioned); err != nil {
+		t.Fatalf("Could not add template: %s", err)
+	}
+	cnstr := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(coded_deny_all_namespaces), &cnstr.Object); err != nil {
+		t.Fatalf("Could not instantiate constraint: %s", err)
+	}
+	if _, err := handler.opa.AddConstraint(context.Background(), cnstr); err != nil {
+		t.Fatalf("Could not add constraint: %s", err)
+	}
+
+	resp := handler.Handle(context.Background(), namespaceRequest("coded"))
+	if resp.Response.Allowed {
+		t.Fatal("allowed = true; want false")
+	}
+	details := resp.Response.Result.Details
+	if details == nil {
+		t.Fatal("denial has no details; want a cause for the coded violation")
+	}
+	want := []metav1.StatusCause{{Type: "missing_owner", Message: "[denied by coded-deny-all-namespaces] no owner"}}
+	if !reflect.DeepEqual(details.Causes, want) {
+		t.Errorf("causes = %v; want %v", details.Causes, want)
+	}
+}
+
+func TestAdmissionResponseUID(t *testing.T) {
+	handler := makeDenyingHandler(t)
+	gkRequest := namespaceRequest("gatekeeper-sa")
+	gkRequest.AdmissionRequest.UserInfo = authenticationv1.UserInfo{Groups: []string{fmt.Sprintf("system:serviceaccounts:%s", util.GetNamespace())}}
+	undecodable := namespaceRequest("undecodable")
+	undecodable.AdmissionRequest.Object.Raw = []byte("{")
+
+	tc := []struct {
+		Name    string
+		Request atypes.Request
+	}{
+		{Name: "Denied", Request: namespaceRequest("denied")},
+		{Name: "Gatekeeper service account", Request: gkRequest},
+		{Name: "Undecodable object", Request: undecodable},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			tt.Request.AdmissionRequest.UID = k8stypes.UID("uid-" + tt.Name)
+			resp := handler.Handle(context.Background(), tt.Request)
+			if resp.Response.UID != tt.Request.AdmissionRequest.UID {
+				t.Errorf("uid = %q; want %q", resp.Response.UID, tt.Request.AdmissionRequest.UID)
+			}
+			if problems := validateResponse(resp.Response); len(problems) != 0 {
+				t.Errorf("invalid response: %v", problems)
+			}
+		})
+	}
+}
+
+func TestValidateResponse(t *testing.T) {
+	jsonPatch := admissionv1beta1.PatchTypeJSONPatch
+	otherPatch := admissionv1beta1.PatchType("MergePatch")
+
+	tc := []struct {
+		Name          string
+		Response      *admissionv1beta1.AdmissionResponse
+		ExpectInvalid bool
+	}{
+		{
+			Name:     "Allowed",
+			Response: &admissionv1beta1.AdmissionResponse{UID: "uid", Allowed: true},
+		},
+		{
+			Name:     "Denied",
+			Response: &admissionv1beta1.AdmissionResponse{UID: "uid", Result: &metav1.Status{Code: http.StatusForbidden}},
+		},
+		{
+			Name:     "Patched",
+			Response: &admissionv1beta1.AdmissionResponse{UID: "uid", Allowed: true, Patch: []byte("[]"), PatchType: &jsonPatch},
+		},
+		{
+			Name:          "Empty",
+			ExpectInvalid: true,
+		},
+		{
+			Name:          "Patch without patchType",
+			Response:      &admissionv1beta1.AdmissionResponse{UID: "uid", Allowed: true, Patch: []byte("[]")},
+			ExpectInvalid: true,
+		},
+		{
+			Name:          "patchType without patch",
+			Response:      &admissionv1beta1.AdmissionResponse{UID: "uid", Allowed: true, PatchType: &jsonPatch},
+			ExpectInvalid: true,
+		},
+		{
+			Name:          "Unsupported patchType",
+			Response:      &admissionv1beta1.AdmissionResponse{UID: "uid", Allowed: true, Patch: []byte("{}"), PatchType: &otherPatch},
+			ExpectInvalid: true,
+		},
+		{
+			Name:          "Denied without status",
+			Response:      &admissionv1beta1.AdmissionResponse{UID: "uid"},
+			ExpectInvalid: true,
+		},
+		{
+			Name:          "Allowed with error code",
+			Response:      &admissionv1beta1.AdmissionResponse{UID: "uid", Allowed: true, Result: &metav1.Status{Code: http.StatusInternalServerError}},
+			ExpectInvalid: true,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			problems := validateResponse(tt.Response)
+			if (len(problems) != 0) != tt.ExpectInvalid {
+				t.Errorf("validateResponse() = %v; want invalid %t", problems, tt.ExpectInvalid)
+			}
+		})
+	}
+}
+
+func TestAnalyzeCoverage(t *testing.T) {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Pod"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Service"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "ReplicaSet"}, meta.RESTScopeNamespace)
+
+	rules := []admissionregistrationv1beta1.RuleWithOperations{
+		{Rule: admissionregistrationv1beta1.Rule{APIGroups: []string{""}, Resources: []string{"pods", "pods/status", "services"}}},
+		{Rule: admissionregistrationv1beta1.Rule{APIGroups: []string{"apps"}, Resources: []string{"deployments"}}},
+	}
+	constraint := func(name string, kinds string) *unstructured.Unstructured {
+		c := &unstructured.Unstructured{}
+		src := fmt.Sprintf("{\"kind\": \"K8sGoodRego\", \"metadata\": {\"name\": %q}, \"spec\": {\"match\": {\"kinds\": %s}}}", name, kinds)
+		if err := json.Unmarshal([]byte(src), &c.Object); err != nil {
+			t.Fatalf("Could not instantiate constraint: %s", err)
+		}
+		return c
+	}
+	pods := constraint("pods", `[{"apiGroups": [""], "kinds": ["Pod"]}]`)
+	replicaSets := constraint("replicasets", `[{"apiGroups": ["apps"], "kinds": ["ReplicaSet", "Deployment"]}]`)
+	anyGroupServices := constraint("services", `[{"apiGroups": ["*"], "kinds": ["Service"]}]`)
+	everything := &unstructured.Unstructured{}
+	everything.SetKind("K8sGoodRego")
+	everything.SetName("everything")
+
+	tc := []struct {
+		Name        string
+		Constraints []*unstructured.Unstructured
+		Expected    *CoverageReport
+	}{
+		{
+			Name:        "No constraints",
+			Constraints: nil,
+			Expected: &CoverageReport{
+				UncoveredRules:       []string{"deployments.apps", "pods", "services"},
+				UncoveredConstraints: []string{},
+			},
+		},
+		{
+			Name:        "Partial coverage",
+			Constraints: []*unstructured.Unstructured{pods, replicaSets},
+			Expected: &CoverageReport{
+				UncoveredRules:       []string{"services"},
+				UncoveredConstraints: []string{"K8sGoodRego/replicasets: ReplicaSet.apps"},
+			},
+		},
+		{
+			Name:        "Wildcard group",
+			Constraints: []*unstructured.Unstructured{pods, anyGroupServices},
+			Expected: &CoverageReport{
+				UncoveredRules:       []string{"deployments.apps"},
+				UncoveredConstraints: []string{},
+			},
+		},
+		{
+			Name:        "Constraint without kinds",
+			Constraints: []*unstructured.Unstructured{everything},
+			Expected: &CoverageReport{
+				UncoveredRules:       []string{},
+				UncoveredConstraints: []string{},
+			},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			got := analyzeCoverage(rules, tt.Constraints, mapper)
+			if !reflect.DeepEqual(got, tt.Expected) {
+				t.Errorf("analyzeCoverage() = %+v; want %+v", got, tt.Expected)
+			}
+		})
+	}
+}
+
+func TestLoadedConstraints(t *testing.T) {
+	handler := makeDenyingHandler(t)
+	resp, err := handler.driver.Query(context.Background(), fmt.Sprintf(`hooks["%s"].library.loaded_constraints`, (&target.K8sValidationTarget{}).GetName()), nil)
+	if err != nil {
+		t.Fatalf("Query() err = %s", err)
+	}
+	if len(resp.Results) != 1 || resp.Results[0].Constraint.GetName() != "deny-all-namespaces" {
+		t.Errorf("loaded constraints = %v; want deny-all-namespaces", resp.Results)
+	}
+}
+
+func TestUnmatchedRequestsMetric(t *testing.T) {
+	handler := makeDenyingHandler(t)
+	unmatched := func() float64 {
+		m := &dto.Metric{}
+		if err := unmatchedRequestsTotal.Write(m); err != nil {
+			t.Fatalf("Could not read metric: %s", err)
+		}
+		return m.GetCounter().GetValue()
+	}
+
+	before := unmatched()
+	handler.Handle(context.Background(), namespaceRequest("matched"))
+	if got := unmatched(); got != before {
+		t.Errorf("unmatched = %v after a matched request; want %v", got, before)
+	}
+
+	pod := atypes.Request{
+		AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+			Kind:      metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"},
+			Name:      "unmatched",
+			Namespace: "default",
+			Operation: admissionv1beta1.Create,
+			Object: runtime.RawExtension{
+				Raw: []byte(`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "unmatched", "namespace": "default"}}`),
+			},
+		},
+	}
+	if resp := handler.Handle(context.Background(), pod); !resp.Response.Allowed {
+		t.Fatalf("unmatched request denied: %v", resp.Response.Result)
+	}
+	if got := unmatched(); got != before+1 {
+		t.Errorf("unmatched = %v after an unmatched request; want %v", got, before+1)
+	}
+}
+
+func TestDeleteValidation(t *testing.T) {
+	handler := makeDenyingHandler(t)
+
+	del := namespaceRequest("protected")
+	del.AdmissionRequest.Operation = admissionv1beta1.Delete
+	del.AdmissionRequest.OldObject = del.AdmissionRequest.Object
+	del.AdmissionRequest.Object = runtime.RawExtension{}
+	resp := handler.Handle(context.Background(), del)
+	if resp.Response.Allowed {
+		t.Error("delete allowed; want denied")
+	}
+	if resp.Response.Result == nil || resp.Response.Result.Code != http.StatusForbidden {
+		t.Errorf("delete result = %v; want code %d", resp.Response.Result, http.StatusForbidden)
+	}
+
+	noOldObject := namespaceRequest("old-apiserver")
+	noOldObject.AdmissionRequest.Operation = admissionv1beta1.Delete
+	noOldObject.AdmissionRequest.Object = runtime.RawExtension{}
+	resp = handler.Handle(context.Background(), noOldObject)
+	if resp.Response.Allowed {
+		t.Error("delete without oldObject allowed; want error")
+	}
+	if resp.Response.Result == nil || resp.Response.Result.Code != http.StatusInternalServerError {
+		t.Errorf("delete without oldObject result = %v; want code %d", resp.Response.Result, http.StatusInternalServerError)
+	}
+
+	// deleting an invalid constraint must not be blocked by constraint validation
+	b, err := yaml.YAMLToJSON([]byte(bad_enforcementaction))
+	if err != nil {
+		t.Fatalf("Error parsing yaml: %s", err)
+	}
+	delConstraint := atypes.Request{
+		AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+			Kind: metav1.GroupVersionKind{
+				Group:   "constraints.gatekeeper.sh",
+				Version: "v1beta1",
+				Kind:    "K8sGoodRego",
+			},
+			Name:      "bad-namespaceselector",
+			Operation: admissionv1beta1.Delete,
+			OldObject: runtime.RawExtension{
+				Raw: b,
+			},
+		},
+	}
+	if resp := handler.Handle(context.Background(), delConstraint); !resp.Response.Allowed {
+		t.Errorf("delete of invalid constraint denied: %v", resp.Response.Result)
+	}
+}
+
+// recordingLogger records the key/value pairs of every Info call
+type recordingLogger struct {
+	entries *[]map[string]interface{}
+}
+
+func (l recordingLogger) Info(msg string, keysAndValues ...interface{}) {
+	entry := map[string]interface{}{"msg": msg}
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		entry[fmt.Sprint(keysAndValues[i])] = keysAndValues[i+1]
+	}
+	*l.entries = append(*l.entries, entry)
+}
+
+func (l recordingLogger) Enabled() bool                                             { return true }
+func (l recordingLogger) Error(err error, msg string, keysAndValues ...interface{}) {}
+func (l recordingLogger) V(level int) logr.InfoLogger                               { return l }
+func (l recordingLogger) WithValues(keysAndValues ...interface{}) logr.Logger       { return l }
+func (l recordingLogger) WithName(name string) logr.Logger                          { return l }
+
+func TestDecisionLogging(t *testing.T) {
+	defer flag.Set("log-denies", "false")
+	defer flag.Set("log-all-decisions", "false")
+	origLog := log
+	defer func() { log = origLog }()
+
+	tc := []struct {
+		Name        string
+		LogDenies   string
+		LogAll      string
+		Namespace   bool
+		ExpectEntry bool
+	}{
+		{
+			Name:        "Denied request logged with --log-denies",
+			LogDenies:   "true",
+			LogAll:      "false",
+			Namespace:   true,
+			ExpectEntry: true,
+		},
+		{
+			Name:        "Allowed request not logged with --log-denies",
+			LogDenies:   "true",
+			LogAll:      "false",
+			Namespace:   false,
+			ExpectEntry: false,
+		},
+		{
+			Name:        "Allowed request logged with --log-all-decisions",
+			LogDenies:   "false",
+			LogAll:      "true",
+			Namespace:   false,
+			ExpectEntry: true,
+		},
+		{
+			Name:        "Denied request not logged by default",
+			LogDenies:   "false",
+			LogAll:      "false",
+			Namespace:   true,
+			ExpectEntry: false,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			flag.Set("log-denies", tt.LogDenies)
+			flag.Set("log-all-decisions", tt.LogAll)
+			var entries []map[string]interface{}
+			log = recordingLogger{entries: &entries}
+
+			handler := makeDenyingHandler(t)
+			req := namespaceRequest("logged")
+			if !tt.Namespace {
+				req.AdmissionRequest.Kind.Kind = "ConfigMap"
+			}
+			req.AdmissionRequest.UID = "1234"
+			req.AdmissionRequest.UserInfo.Username = "alice"
+			handler.Handle(context.Background(), req)
+
+			var decision map[string]interface{}
+			for _, e := range entries {
+				if e["msg"] == "admission decision" {
+					decision = e
+				}
+			}
+			if !tt.ExpectEntry {
+				if decision != nil {
+					t.Errorf("decision logged: %v; want no entry", decision)
+				}
+				return
+			}
+			if decision == nil {
+				t.Fatal("decision not logged")
+			}
+			expectedDecision := "allow"
+			var expectedMatched []string
+			if tt.Namespace {
+				expectedDecision = "deny"
+				expectedMatched = []string{"K8sGoodRego/deny-all-namespaces"}
+			}
+			expected := map[string]interface{}{
+				"request_uid":         k8stypes.UID("1234"),
+				"operation":           admissionv1beta1.Create,
+				"version":             "v1",
+				"name":                "logged",
+				"user":                "alice",
+				"decision":            expectedDecision,
+				"matched_constraints": expectedMatched,
+				"dry_run":             false,
+			}
+			for k, v := range expected {
+				if !reflect.DeepEqual(decision[k], v) {
+					t.Errorf("%s = %#v; want %#v", k, decision[k], v)
+				}
+			}
+			for _, k := range []string{"group", "kind", "namespace", "duration"} {
+				if _, ok := decision[k]; !ok {
+					t.Errorf("%s missing from decision log", k)
+				}
+			}
+		})
+	}
+}
+
+const (
+	shared_lib_template = `
+apiVersion: templates.gatekeeper.sh/v1beta1
+kind: ConstraintTemplate
+metadata:
+  name: k8ssharedlib
+  annotations:
+    templates.gatekeeper.sh/libs: "naming"
+spec:
+  crd:
+    spec:
+      names:
+        kind: K8sSharedLib
+        listKind: K8sSharedLibList
+        plural: k8ssharedlib
+        singular: k8ssharedlib
+  targets:
+    - target: admission.k8s.gatekeeper.sh
+      rego: |
+        package sharedlib
+
+        import data.lib.naming
+
+        violation[{"msg": msg}] {
+          naming.forbidden(input.review.object.metadata.name)
+          msg := "forbidden name"
+        }
+`
+
+	shared_lib_all_namespaces = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sSharedLib
+metadata:
+  name: shared-lib-all-namespaces
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Namespace"]
+`
+)
+
+var _ ctrlclient.Client = &libClient{}
+
+// libClient serves ConfigMaps in the Gatekeeper namespace from libs
+type libClient struct {
+	libs map[string]map[string]string
+}
+
+func (c *libClient) Get(ctx context.Context, key ctrlclient.ObjectKey, obj runtime.Object) error {
+	data, ok := c.libs[key.Name]
+	if !ok || key.Namespace != util.GetNamespace() {
+		return apierrors.NewNotFound(corev1.Resource("configmaps"), key.Name)
+	}
+	cm := obj.(*corev1.ConfigMap)
+	cm.SetName(key.Name)
+	cm.SetNamespace(key.Namespace)
+	cm.Data = data
+	return nil
+}
+
+func (c *libClient) List(ctx context.Context, opts *ctrlclient.ListOptions, list runtime.Object) error {
+	return nil
+}
+
+func (c *libClient) Create(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+
+func (c *libClient) Delete(ctx context.Context, obj runtime.Object, opts ...ctrlclient.DeleteOptionFunc) error {
+	return nil
+}
+
+func (c *libClient) Update(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+
+func (c *libClient) Status() ctrlclient.StatusWriter {
+	return c
+}
+
+func TestSharedLibs(t *testing.T) {
+	b, err := yaml.YAMLToJSON([]byte(shared_lib_template))
+	if err != nil {
+		t.Fatalf("Error parsing yaml: %s", err)
+	}
+	review := atypes.Request{
+		AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+			Kind: metav1.GroupVersionKind{
+				Group:   "templates.gatekeeper.sh",
+				Version: "v1beta1",
+				Kind:    "ConstraintTemplate",
+			},
+			Object: runtime.RawExtension{
+				Raw: b,
+			},
+		},
+	}
+
+	tc := []struct {
+		Name          string
+		Libs          map[string]map[string]string
+		ErrorExpected string
+	}{
+		{
+			Name: "Valid shared lib",
+			Libs: map[string]map[string]string{
+				"naming": {"naming.rego": "package lib.naming\n\nforbidden(name) {\n  startswith(name, \"forbidden-\")\n}\n"},
+			},
+		},
+		{
+			Name:          "Missing shared lib",
+			Libs:          map[string]map[string]string{},
+			ErrorExpected: "shared lib naming",
+		},
+		{
+			Name: "Shared lib with a syntax error",
+			Libs: map[string]map[string]string{
+				"naming": {"naming.rego": "package lib.naming\n\nforbidden(name) {\n"},
+			},
+			ErrorExpected: "shared lib naming/naming.rego",
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			opa, err := makeOpaClient()
+			if err != nil {
+				t.Fatalf("Could not initialize OPA: %s", err)
+			}
+			handler := validationHandler{opa: opa, client: &libClient{libs: tt.Libs}}
+			userErr, err := handler.validateGatekeeperResources(context.Background(), review)
+			if tt.ErrorExpected == "" {
+				if err != nil {
+					t.Fatalf("err = %s; want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.ErrorExpected) {
+				t.Fatalf("err = %v; want error containing %q", err, tt.ErrorExpected)
+			}
+			if !userErr {
+				t.Error("shared lib error not reported as a user error")
+			}
+		})
+	}
+
+	t.Run("Template uses shared lib function", func(t *testing.T) {
+		opa, err := makeOpaClient()
+		if err != nil {
+			t.Fatalf("Could not initialize OPA: %s", err)
+		}
+		c := &libClient{libs: tc[0].Libs}
+		cstr := &templv1beta1.ConstraintTemplate{}
+		if err := yaml.Unmarshal([]byte(shared_lib_template), cstr); err != nil {
+			t.Fatalf("Could not instantiate template: %s", err)
+		}
+		unversioned := &templates.ConstraintTemplate{}
+		if err := runtimeScheme.Convert(cstr, unversioned, nil); err != nil {
+			t.Fatalf("Could not convert to unversioned: %v", err)
+		}
+		if _, err := constrainttemplate.AddSharedLibs(context.Background(), c, unversioned); err != nil {
+			t.Fatalf("Could not add shared libs: %s", err)
+		}
+		if _, err := opa.AddTemplate(context.Background(), unversioned); err != nil {
+			t.Fatalf("Could not add template: %s", err)
+		}
+		cnstr := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal([]byte(shared_lib_all_namespaces), &cnstr.Object); err != nil {
+			t.Fatalf("Could not instantiate constraint: %s", err)
+		}
+		if _, err := opa.AddConstraint(context.Background(), cnstr); err != nil {
+			t.Fatalf("Could not add constraint: %s", err)
+		}
+		handler := &validationHandler{opa: opa, client: c, injectedConfig: &v1alpha1.Config{}}
+		if resp := handler.Handle(context.Background(), namespaceRequest("forbidden-ns")); resp.Response.Allowed {
+			t.Error("forbidden-ns allowed; want denied by shared lib")
+		}
+		if resp := handler.Handle(context.Background(), namespaceRequest("allowed-ns")); !resp.Response.Allowed {
+			t.Errorf("allowed-ns denied: %v", resp.Response.Result)
+		}
+	})
+}
+
+func TestMalformedRequests(t *testing.T) {
+	handler := makeDenyingHandler(t)
+	wh := &admission.Webhook{
+		Name:     "validation.gatekeeper.sh",
+		Type:     webhooktypes.WebhookTypeValidating,
+		Handlers: []admission.Handler{handler},
+	}
+	decodeErrors := func() float64 {
+		m := &dto.Metric{}
+		if err := decodeErrorsTotal.Write(m); err != nil {
+			t.Fatalf("Could not read metric: %s", err)
+		}
+		return m.GetCounter().GetValue()
+	}
+
+	tc := []struct {
+		Name        string
+		Body        string
+		DecodeError bool
+	}{
+		{
+			Name: "Non-JSON body",
+			Body: `this is not json`,
+		},
+		{
+			Name: "Truncated body",
+			Body: `{"kind": "AdmissionReview", "apiVersion": "admission.k8s.io/v1beta1", "request": {"uid": "1", "kind": {"group": "", "version": "v1", "kind": "Namespace"}, "operation": "CREATE", "object": {"apiVersion": "v1", "kind": "Namesp`,
+		},
+		{
+			// rejected by the webhook server before it reaches the handler
+			Name: "Missing request",
+			Body: `{"kind": "AdmissionReview", "apiVersion": "admission.k8s.io/v1beta1"}`,
+		},
+		{
+			Name:        "Non-object object",
+			Body:        `{"kind": "AdmissionReview", "apiVersion": "admission.k8s.io/v1beta1", "request": {"uid": "1", "kind": {"group": "", "version": "v1", "kind": "Namespace"}, "operation": "CREATE", "object": "not an object"}}`,
+			DecodeError: true,
+		},
+		{
+			Name:        "Non-object oldObject",
+			Body:        `{"kind": "AdmissionReview", "apiVersion": "admission.k8s.io/v1beta1", "request": {"uid": "1", "kind": {"group": "", "version": "v1", "kind": "Namespace"}, "operation": "UPDATE", "object": {"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "a"}}, "oldObject": [1, 2]}}`,
+			DecodeError: true,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			before := decodeErrors()
+			req := httptest.NewRequest(http.MethodPost, "/v1/admit", strings.NewReader(tt.Body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			wh.ServeHTTP(w, req)
+
+			review := &admissionv1beta1.AdmissionReview{}
+			if err := json.Unmarshal(w.Body.Bytes(), review); err != nil {
+				t.Fatalf("Could not decode response %q: %s", w.Body.String(), err)
+			}
+			if review.Response == nil {
+				t.Fatalf("Response is missing: %s", w.Body.String())
+			}
+			if review.Response.Allowed {
+				t.Errorf("Malformed request allowed")
+			}
+			if review.Response.Result == nil || review.Response.Result.Code != http.StatusBadRequest {
+				t.Errorf("Result = %v; want code %d", review.Response.Result, http.StatusBadRequest)
+			}
+			want := before
+			if tt.DecodeError {
+				want++
+			}
+			if got := decodeErrors(); got != want {
+				t.Errorf("decode errors = %v; want %v", got, want)
+			}
+		})
+	}
+
+	t.Run("Missing request handled directly", func(t *testing.T) {
+		before := decodeErrors()
+		resp := handler.Handle(context.Background(), atypes.Request{})
+		if resp.Response.Allowed || resp.Response.Result.Code != http.StatusBadRequest {
+			t.Errorf("Response = %v; want a denial with code %d", resp.Response, http.StatusBadRequest)
+		}
+		if got := decodeErrors(); got != before+1 {
+			t.Errorf("decode errors = %v; want %v", got, before+1)
+		}
+	})
+}
+
+const (
+	trimmed_fields_template = `
+apiVersion: templates.gatekeeper.sh/v1beta1
+kind: ConstraintTemplate
+metadata:
+  name: k8strimmedfields
+spec:
+  crd:
+    spec:
+      names:
+        kind: K8sTrimmedFields
+        listKind: K8sTrimmedFieldsList
+        plural: k8strimmedfields
+        singular: k8strimmedfields
+  targets:
+    - target: admission.k8s.gatekeeper.sh
+      rego: |
+        package trimmedfields
+
+        violation[{"msg": "managedFields reviewed"}] {
+          input.review.object.metadata.managedFields
+        }
+
+        violation[{"msg": "status reviewed"}] {
+          input.review.object.status
+        }
+
+        violation[{"msg": "old managedFields reviewed"}] {
+          input.review.oldObject.metadata.managedFields
+        }
+`
+
+	trimmed_fields_all_namespaces = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sTrimmedFields
+metadata:
+  name: trimmed-fields-all-namespaces
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Namespace"]
+`
+)
+
+func TestTrimFields(t *testing.T) {
+	opa, driver, err := makeOpaClientAndDriver()
+	if err != nil {
+		t.Fatalf("Could not initialize OPA: %s", err)
+	}
+	templ := &templv1beta1.ConstraintTemplate{}
+	if err := yaml.Unmarshal([]byte(trimmed_fields_template), templ); err != nil {
+		t.Fatalf("Could not instantiate template: %s", err)
+	}
+	unversioned := &templates.ConstraintTemplate{}
+	if err := runtimeScheme.Convert(templ, unversioned, nil); err != nil {
+		t.Fatalf("Could not convert to unversioned: %v", err)
+	}
+	if _, err := opa.AddTemplate(context.Background(), unversioned); err != nil {
+		t.Fatalf("Could not add template: %s", err)
+	}
+	cnstr := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(trimmed_fields_all_namespaces), &cnstr.Object); err != nil {
+		t.Fatalf("Could not instantiate constraint: %s", err)
+	}
+	if _, err := opa.AddConstraint(context.Background(), cnstr); err != nil {
+		t.Fatalf("Could not add constraint: %s", err)
+	}
+
+	object := `{"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "trimmed", "managedFields": [{"manager": "kubectl"}]}, "status": {"phase": "Active"}}`
+	request := func() atypes.Request {
+		return atypes.Request{
+			AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+				Kind:      metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Namespace"},
+				Name:      "trimmed",
+				Operation: admissionv1beta1.Update,
+				Object:    runtime.RawExtension{Raw: []byte(object)},
+				OldObject: runtime.RawExtension{Raw: []byte(object)},
+			},
+		}
+	}
+
+	tc := []struct {
+		Name          string
+		ManagedFields bool
+		Status        bool
+		OptOut        bool
+		Denials       []string
+	}{
+		{
+			Name:    "Trimming disabled",
+			Denials: []string{"managedFields reviewed", "status reviewed", "old managedFields reviewed"},
+		},
+		{
+			Name:          "Trim managedFields",
+			ManagedFields: true,
+			Denials:       []string{"status reviewed"},
+		},
+		{
+			Name:          "Trim managedFields and status",
+			ManagedFields: true,
+			Status:        true,
+		},
+		{
+			Name:          "Template opted out",
+			ManagedFields: true,
+			Status:        true,
+			OptOut:        true,
+			Denials:       []string{"managedFields reviewed", "status reviewed", "old managedFields reviewed"},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			defer flag.Set("trim-managed-fields", "false")
+			defer flag.Set("trim-status", "false")
+			flag.Set("trim-managed-fields", fmt.Sprint(tt.ManagedFields))
+			flag.Set("trim-status", fmt.Sprint(tt.Status))
+
+			handler := &validationHandler{opa: opa, driver: driver, injectedConfig: &v1alpha1.Config{}, trimOptOuts: newTrimOptOuts()}
+			if tt.OptOut {
+				optOut := templ.DeepCopy()
+				optOut.SetAnnotations(map[string]string{UntrimmedReviewAnnotation: "true"})
+				handler.trimOptOuts.eventHandler().OnAdd(optOut)
+			}
+			req := request()
+			resp := handler.Handle(context.Background(), req)
+			if string(req.AdmissionRequest.Object.Raw) != object {
+				t.Errorf("Handle() modified the request object: %s", req.AdmissionRequest.Object.Raw)
+			}
+			if len(tt.Denials) == 0 {
+				if !resp.Response.Allowed {
+					t.Errorf("Request denied: %v", resp.Response.Result)
+				}
+				return
+			}
+			if resp.Response.Allowed {
+				t.Fatalf("Request allowed; want denials %v", tt.Denials)
+			}
+			reason := string(resp.Response.Result.Reason)
+			for _, d := range tt.Denials {
+				if !strings.Contains(reason, d) {
+					t.Errorf("Denial %q missing from %q", d, reason)
+				}
+			}
+			if got := strings.Count(reason, "[denied by"); got != len(tt.Denials) {
+				t.Errorf("Got %d denials in %q; want %d", got, reason, len(tt.Denials))
+			}
+		})
+	}
+
+	t.Run("Opt out removed", func(t *testing.T) {
+		optOuts := newTrimOptOuts()
+		optOut := templ.DeepCopy()
+		optOut.SetAnnotations(map[string]string{UntrimmedReviewAnnotation: "true"})
+		optOuts.eventHandler().OnAdd(optOut)
+		if !optOuts.Any() {
+			t.Fatalf("Opt out not recorded")
+		}
+		optOuts.eventHandler().OnUpdate(optOut, templ)
+		if optOuts.Any() {
+			t.Errorf("Opt out still recorded after the annotation was removed")
+		}
+		optOuts.eventHandler().OnAdd(optOut)
+		optOuts.eventHandler().OnDelete(toolscache.DeletedFinalStateUnknown{Key: optOut.GetName(), Obj: optOut})
+		if optOuts.Any() {
+			t.Errorf("Opt out still recorded after the template was deleted")
+		}
+	})
+}
+
+const (
+	good_namespaced_constraint = `
+apiVersion: namespaced.constraints.gatekeeper.sh/v1beta1
+kind: K8sGoodRego
+metadata:
+  name: own-namespace
+  namespace: tenant-a
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Pod"]
+    namespaces: ["tenant-a"]
+`
+
+	other_namespace_constraint = `
+apiVersion: namespaced.constraints.gatekeeper.sh/v1beta1
+kind: K8sGoodRego
+metadata:
+  name: other-namespace
+  namespace: tenant-a
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Pod"]
+    namespaces: ["tenant-b"]
+`
+
+	reserved_name_constraint = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sGoodRego
+metadata:
+  name: namespaced.tenant-a.own-namespace
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Pod"]
+`
+)
+
+func TestNamespacedConstraintValidation(t *testing.T) {
+	defer flag.Set("enable-namespaced-constraints", "false")
+	flag.Set("enable-namespaced-constraints", "true")
+
+	tc := []struct {
+		Name          string
+		Group         string
+		Constraint    string
+		ErrorExpected bool
+	}{
+		{
+			Name:       "Namespaced constraint matching its own namespace",
+			Group:      "namespaced.constraints.gatekeeper.sh",
+			Constraint: good_namespaced_constraint,
+		},
+		{
+			Name:          "Namespaced constraint matching another namespace",
+			Group:         "namespaced.constraints.gatekeeper.sh",
+			Constraint:    other_namespace_constraint,
+			ErrorExpected: true,
+		},
+		{
+			Name:          "Cluster constraint with a reserved name",
+			Group:         "constraints.gatekeeper.sh",
+			Constraint:    reserved_name_constraint,
+			ErrorExpected: true,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			opa, err := makeOpaClient()
+			if err != nil {
+				t.Fatalf("Could not initialize OPA: %s", err)
+			}
+			cstr := &templv1beta1.ConstraintTemplate{}
+			if err := yaml.Unmarshal([]byte(good_rego_template), cstr); err != nil {
+				t.Fatalf("Could not instantiate template: %s", err)
+			}
+			unversioned := &templates.ConstraintTemplate{}
+			if err := runtimeScheme.Convert(cstr, unversioned, nil); err != nil {
+				t.Fatalf("Could not convert to unversioned: %v", err)
+			}
+			if _, err := opa.AddTemplate(context.Background(), unversioned); err != nil {
+				t.Fatalf("Could not add template: %s", err)
+			}
+			handler := validationHandler{opa: opa}
+			b, err := yaml.YAMLToJSON([]byte(tt.Constraint))
+			if err != nil {
+				t.Fatalf("Error parsing yaml: %s", err)
+			}
+			review := atypes.Request{
+				AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+					Kind: metav1.GroupVersionKind{
+						Group:   tt.Group,
+						Version: "v1beta1",
+						Kind:    "K8sGoodRego",
+					},
+					Object: runtime.RawExtension{
+						Raw: b,
+					},
+				},
+			}
+			userErr, err := handler.validateGatekeeperResources(context.Background(), review)
+			if err != nil && !tt.ErrorExpected {
+				t.Errorf("err = %s; want nil", err)
+			}
+			if err == nil && tt.ErrorExpected {
+				t.Error("err = nil; want non-nil")
+			}
+			if err != nil && !userErr {
+				t.Errorf("err = %s is not reported as a user error", err)
+			}
+		})
+	}
+}
+
+const (
+	deny_pods_in_default = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sGoodRego
+metadata:
+  name: pods-in-default
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Pod"]
+    namespaces: ["default"]
+`
+
+	deny_pods_in_prod = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sGoodRego
+metadata:
+  name: pods-in-prod
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Pod"]
+    namespaceSelector:
+      matchLabels:
+        env: prod
+`
+)
+
+func TestEmptyNamespace(t *testing.T) {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Pod"}, meta.RESTScopeNamespace)
+
+	tc := []struct {
+		Name       string
+		Constraint string
+		Object     string
+		NoMapper   bool
+		Allowed    bool
+	}{
+		{
+			Name:       "Defaulted to the default namespace",
+			Constraint: deny_pods_in_default,
+			Object:     `{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "pod"}}`,
+		},
+		{
+			Name:       "Namespace from the object metadata",
+			Constraint: deny_pods_in_default,
+			Object:     `{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "pod", "namespace": "other"}}`,
+			Allowed:    true,
+		},
+		{
+			Name:       "Unknown scope",
+			Constraint: deny_pods_in_default,
+			Object:     `{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "pod"}}`,
+			NoMapper:   true,
+			Allowed:    true,
+		},
+		{
+			Name:       "Selector on the default namespace",
+			Constraint: deny_pods_in_prod,
+			Object:     `{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "pod"}}`,
+			Allowed:    true,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			opa, driver, err := makeOpaClientAndDriver()
+			if err != nil {
+				t.Fatalf("Could not initialize OPA: %s", err)
+			}
+			cstr := &templv1beta1.ConstraintTemplate{}
+			if err := yaml.Unmarshal([]byte(good_rego_template), cstr); err != nil {
+				t.Fatalf("Could not instantiate template: %s", err)
+			}
+			unversioned := &templates.ConstraintTemplate{}
+			if err := runtimeScheme.Convert(cstr, unversioned, nil); err != nil {
+				t.Fatalf("Could not convert to unversioned: %v", err)
+			}
+			if _, err := opa.AddTemplate(context.Background(), unversioned); err != nil {
+				t.Fatalf("Could not add template: %s", err)
+			}
+			cnstr := &unstructured.Unstructured{}
+			if err := yaml.Unmarshal([]byte(tt.Constraint), &cnstr.Object); err != nil {
+				t.Fatalf("Could not instantiate constraint: %s", err)
+			}
+			if _, err := opa.AddConstraint(context.Background(), cnstr); err != nil {
+				t.Fatalf("Could not add constraint: %s", err)
+			}
+			ns := &unstructured.Unstructured{}
+			ns.SetGroupVersionKind(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"})
+			ns.SetName("default")
+			ns.SetLabels(map[string]string{"env": "dev"})
+			if _, err := opa.AddData(context.Background(), ns); err != nil {
+				t.Fatalf("Could not add namespace: %s", err)
+			}
+
+			handler := &validationHandler{opa: opa, driver: driver, injectedConfig: &v1alpha1.Config{}, mapper: mapper}
+			if tt.NoMapper {
+				handler.mapper = nil
+			}
+			req := atypes.Request{
+				AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+					Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+					Name:      "pod",
+					Operation: admissionv1beta1.Create,
+					Object:    runtime.RawExtension{Raw: []byte(tt.Object)},
+				},
+			}
+			resp := handler.Handle(context.Background(), req)
+			if resp.Response.Allowed != tt.Allowed {
+				t.Errorf("allowed = %t; want %t: %+v", resp.Response.Allowed, tt.Allowed, resp.Response.Result)
+			}
+		})
+	}
+}
+
+const deny_pod_exec = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sGoodRego
+metadata:
+  name: deny-pod-exec
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["PodExecOptions"]
+`
+
+func TestConnectRequests(t *testing.T) {
+	handler := makeDenyingHandler(t)
+	cnstr := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(deny_pod_exec), &cnstr.Object); err != nil {
+		t.Fatalf("Could not instantiate constraint: %s", err)
+	}
+	if _, err := handler.opa.AddConstraint(context.Background(), cnstr); err != nil {
+		t.Fatalf("Could not add constraint: %s", err)
+	}
+	exec := atypes.Request{
+		AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+			Kind:        metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "PodExecOptions"},
+			Resource:    metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"},
+			SubResource: "exec",
+			Name:        "shell",
+			Namespace:   "default",
+			Operation:   admissionv1beta1.Connect,
+			Object: runtime.RawExtension{
+				Raw: []byte(`{"apiVersion": "v1", "kind": "PodExecOptions", "command": ["sh"], "stdin": true, "tty": true}`),
+			},
+		},
+	}
+
+	if resp := handler.Handle(context.Background(), exec); !resp.Response.Allowed {
+		t.Errorf("CONNECT request denied by default: %v", resp.Response.Result)
+	}
+
+	defer flag.Set("validate-connects", "false")
+	flag.Set("validate-connects", "true")
+	if resp := handler.Handle(context.Background(), exec); resp.Response.Allowed {
+		t.Errorf("CONNECT request allowed with --validate-connects")
+	}
+}
+
+func TestGatekeeperNamespaceExemption(t *testing.T) {
+	handler := makeDenyingHandler(t)
+	if resp := handler.Handle(context.Background(), namespaceRequest(util.GetNamespace())); !resp.Response.Allowed {
+		t.Errorf("Gatekeeper namespace denied by default: %+v", resp.Response.Result)
+	}
+	if resp := handler.Handle(context.Background(), namespaceRequest("other")); resp.Response.Allowed {
+		t.Error("other namespace allowed; want it denied")
+	}
+
+	pod := &admissionv1beta1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Name:      "gatekeeper-controller-manager-0",
+		Namespace: util.GetNamespace(),
+	}
+	if !inGatekeeperNamespace(pod) {
+		t.Error("pod in the Gatekeeper namespace is not exempt")
+	}
+	pod.Namespace = "other"
+	if inGatekeeperNamespace(pod) {
+		t.Error("pod in another namespace is exempt")
+	}
+
+	defer flag.Set("exempt-gatekeeper-namespace", "true")
+	flag.Set("exempt-gatekeeper-namespace", "false")
+	if resp := handler.Handle(context.Background(), namespaceRequest(util.GetNamespace())); resp.Response.Allowed {
+		t.Error("Gatekeeper namespace allowed with --exempt-gatekeeper-namespace=false; want it denied")
+	}
+}
+
+func TestReviewContext(t *testing.T) {
+	defer flag.Set("webhook-timeout", "0")
+	deadline := time.Now().Add(time.Second)
+	withDeadline, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	tc := []struct {
+		Name     string
+		Ctx      context.Context
+		Timeout  string
+		Deadline time.Time
+	}{
+		{
+			Name: "Unbounded",
+			Ctx:  context.Background(),
+		},
+		{
+			Name:     "Request deadline",
+			Ctx:      withDeadline,
+			Deadline: deadline.Add(-responseMargin),
+		},
+		{
+			Name:     "Timeout before the request deadline",
+			Ctx:      withDeadline,
+			Timeout:  "100ms",
+			Deadline: time.Now().Add(100 * time.Millisecond),
+		},
+		{
+			Name:     "Timeout after the request deadline",
+			Ctx:      withDeadline,
+			Timeout:  "1h",
+			Deadline: deadline.Add(-responseMargin),
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			timeout := tt.Timeout
+			if timeout == "" {
+				timeout = "0"
+			}
+			flag.Set("webhook-timeout", timeout)
+			ctx, cancel := reviewContext(tt.Ctx)
+			defer cancel()
+			got, ok := ctx.Deadline()
+			if ok != !tt.Deadline.IsZero() {
+				t.Fatalf("review has a deadline = %t; want %t", ok, !tt.Deadline.IsZero())
+			}
+			if diff := got.Sub(tt.Deadline); diff < -50*time.Millisecond || diff > 50*time.Millisecond {
+				t.Errorf("review deadline = %s; want %s", got, tt.Deadline)
+			}
+		})
+	}
+}
+
+func TestShortRequestDeadline(t *testing.T) {
+	count := func() float64 {
+		m := &dto.Metric{}
+		if err := deadlineExceededTotal.Write(m); err != nil {
+			t.Fatalf("could not read deadline exceeded metric: %s", err)
+		}
+		return m.GetCounter().GetValue()
+	}
+	handler := makeDenyingHandler(t)
+
+	// enough time left to review the request
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp := handler.Handle(ctx, namespaceRequest("plenty-of-time"))
+	if resp.Response.Allowed || resp.Response.Result.Code == http.StatusInternalServerError {
+		t.Errorf("response = %+v; want a denial by the constraint", resp.Response.Result)
+	}
+
+	// less time left than is needed to respond
+	before := count()
+	ctx, cancel = context.WithTimeout(context.Background(), responseMargin/2)
+	defer cancel()
+	resp = handler.Handle(ctx, namespaceRequest("out-of-time"))
+	if resp.Response.Allowed {
+		t.Error("request allowed; want an error response")
+	}
+	if resp.Response.Result == nil || resp.Response.Result.Code != http.StatusInternalServerError {
+		t.Errorf("response = %+v; want code %d", resp.Response.Result, http.StatusInternalServerError)
+	}
+	if got := count() - before; got != 1 {
+		t.Errorf("deadline exceeded metric increased by %v; want 1", got)
+	}
+}
+
+func TestWebhookResources(t *testing.T) {
+	defer flag.Set("webhook-subresources", "")
+	tc := []struct {
+		Name         string
+		Subresources string
+		Expected     []string
+		WantErr      bool
+	}{
+		{Name: "default", Subresources: "", Expected: []string{"*"}},
+		{Name: "subresources", Subresources: "deployments/scale, */ephemeralcontainers", Expected: []string{"*", "deployments/scale", "*/ephemeralcontainers"}},
+		{Name: "no subresource", Subresources: "deployments", WantErr: true},
+		{Name: "no resource", Subresources: "/scale", WantErr: true},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			flag.Set("webhook-subresources", tt.Subresources)
+			resources, err := webhookResources()
+			if (err != nil) != tt.WantErr {
+				t.Fatalf("webhookResources() err = %v; want error %t", err, tt.WantErr)
+			}
+			if !tt.WantErr && !reflect.DeepEqual(resources, tt.Expected) {
+				t.Errorf("webhookResources() = %v; want %v", resources, tt.Expected)
+			}
+		})
+	}
+}
+
+func TestNamespaceSelector(t *testing.T) {
+	defer flag.Set("webhook-exclude-namespace-labels", "control-plane,kubernetes.io/metadata.name=kube-system")
+	tc := []struct {
+		Name     string
+		Labels   string
+		Expected []metav1.LabelSelectorRequirement
+		WantErr  bool
+	}{
+		{
+			Name:   "default",
+			Labels: "control-plane,kubernetes.io/metadata.name=kube-system",
+			Expected: []metav1.LabelSelectorRequirement{
+				{Key: "control-plane", Operator: metav1.LabelSelectorOpDoesNotExist},
+				{Key: "kubernetes.io/metadata.name", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"kube-system"}},
+			},
+		},
+		{
+			Name:   "values of a key combined",
+			Labels: "kubernetes.io/metadata.name=kube-system, kubernetes.io/metadata.name=kube-public,admission=off",
+			Expected: []metav1.LabelSelectorRequirement{
+				{Key: "admission", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"off"}},
+				{Key: "kubernetes.io/metadata.name", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"kube-public", "kube-system"}},
+			},
+		},
+		{
+			Name:   "empty",
+			Labels: "",
+		},
+		{
+			Name:    "invalid key",
+			Labels:  "not a key",
+			WantErr: true,
+		},
+		{
+			Name:    "invalid value",
+			Labels:  "team=not a value",
+			WantErr: true,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			flag.Set("webhook-exclude-namespace-labels", tt.Labels)
+			selector, err := namespaceSelector()
+			if tt.WantErr {
+				if err == nil {
+					t.Errorf("namespaceSelector() err = nil; want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("namespaceSelector() err = %s", err)
+			}
+			// a nil selector would be defaulted by the webhook server
+			if selector == nil {
+				t.Fatal("namespaceSelector() = nil; want a selector")
+			}
+			if !reflect.DeepEqual(selector.MatchExpressions, tt.Expected) {
+				t.Errorf("namespaceSelector() expressions = %v; want %v", selector.MatchExpressions, tt.Expected)
+			}
+		})
+	}
+}
+
+func TestDeterministicValidationResponse(t *testing.T) {
+	defer flag.Set("deterministic-eval", "false")
+	flag.Set("deterministic-eval", "true")
+
+	var results []*rtypes.Result
+	for _, name := range []string{"team", "owner", "repos"} {
+		cstr := &unstructured.Unstructured{}
+		cstr.SetKind("K8sRequiredLabels")
+		cstr.SetName(name)
+		results = append(results, &rtypes.Result{Constraint: cstr, Msg: name + " missing", EnforcementAction: "deny"})
+	}
+	want := "[denied by owner] owner missing\n[denied by repos] repos missing\n[denied by team] team missing"
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		r.Shuffle(len(results), func(j, k int) { results[j], results[k] = results[k], results[j] })
+		resp := &rtypes.Responses{ByTarget: map[string]*rtypes.Response{
+			"admission.k8s.gatekeeper.sh": {Target: "admission.k8s.gatekeeper.sh", Results: append([]*rtypes.Result(nil), results...)},
+		}}
+		if got := string(validationResponse(resp).Response.Result.Reason); got != want {
+			t.Fatalf("run %d: reason = %q; want %q", i, got, want)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	defer flag.Set("dry-run", "false")
+	handler := makeDenyingHandler(t)
+	req := namespaceRequest("dry-run")
+	req.AdmissionRequest.UID = "dry-run-uid"
+	denied := func() float64 {
+		m := &dto.Metric{}
+		if err := dryRunDeniedTotal.Write(m); err != nil {
+			t.Fatalf("could not read metric: %s", err)
+		}
+		return m.GetCounter().GetValue()
+	}
+
+	if resp := handler.Handle(context.Background(), req); resp.Response.Allowed {
+		t.Fatal("request allowed without --dry-run; want denied")
+	}
+
+	flag.Set("dry-run", "true")
+	before := denied()
+	resp := handler.Handle(context.Background(), req)
+	if !resp.Response.Allowed {
+		t.Fatal("request denied with --dry-run; want allowed")
+	}
+	if resp.Response.UID != "dry-run-uid" {
+		t.Errorf("UID = %q; want dry-run-uid", resp.Response.UID)
+	}
+	if msg := string(resp.Response.Result.Reason); !strings.HasPrefix(msg, "dry-run: [denied by deny-all-namespaces]") {
+		t.Errorf("message = %q; want the denial that was skipped", msg)
+	}
+	if got := denied() - before; got != 1 {
+		t.Errorf("gatekeeper_validation_dry_run_denied_total increased by %v; want 1", got)
+	}
+
+	if resp := handler.Handle(context.Background(), namespaceRequest("allowed-anyway")); !resp.Response.Allowed {
+		t.Error("request denied with --dry-run; want allowed")
+	}
+}
+
+func TestConstraintGracePeriod(t *testing.T) {
+	defer flag.Set("constraint-grace-period", "0")
+	flag.Set("constraint-grace-period", "1h")
+	handler := makeDenyingHandler(t)
+
+	tc := []struct {
+		Name    string
+		Age     time.Duration
+		Allowed bool
+	}{
+		{Name: "within grace period", Age: 10 * time.Minute, Allowed: true},
+		{Name: "past grace period", Age: 2 * time.Hour, Allowed: false},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			cnstr := &unstructured.Unstructured{}
+			if err := yaml.Unmarshal([]byte(deny_all_namespaces), &cnstr.Object); err != nil {
+				t.Fatalf("Could not instantiate constraint: %s", err)
+			}
+			cnstr.SetCreationTimestamp(metav1.NewTime(time.Now().Add(-tt.Age)))
+			if _, err := handler.opa.AddConstraint(context.Background(), cnstr); err != nil {
+				t.Fatalf("Could not add constraint: %s", err)
+			}
+			if resp := handler.Handle(context.Background(), namespaceRequest("new-team")); resp.Response.Allowed != tt.Allowed {
+				t.Errorf("allowed = %v; want %v", resp.Response.Allowed, tt.Allowed)
+			}
+		})
+	}
+}
+
+func TestDecisionLogSink(t *testing.T) {
+	defer func(v string) { version.Version = v }(version.Version)
+	version.Version = "v0.0.0-test"
+	handler := makeDenyingHandler(t)
+	buf := &bytes.Buffer{}
+	handler.sink = &writerSink{w: buf}
+
+	allowed := atypes.Request{AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+		UID:       "allowed-uid",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Namespace: "default",
+		Name:      "web",
+		Operation: admissionv1beta1.Create,
+		Object:    runtime.RawExtension{Raw: []byte(`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "web", "namespace": "default"}}`)},
+	}}
+	denied := namespaceRequest("denied")
+	denied.AdmissionRequest.UID = "denied-uid"
+
+	tc := []struct {
+		Name    string
+		Request atypes.Request
+		Allowed bool
+	}{
+		{Name: "allow", Request: allowed, Allowed: true},
+		{Name: "deny", Request: denied, Allowed: false},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			buf.Reset()
+			handler.Handle(context.Background(), tt.Request)
+			entry := map[string]interface{}{}
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("decision log entry %q is not JSON: %s", buf.String(), err)
+			}
+			keys := func(m map[string]interface{}) []string {
+				var out []string
+				for k := range m {
+					out = append(out, k)
+				}
+				sort.Strings(out)
+				return out
+			}
+			if got, want := keys(entry), []string{"decision_id", "input", "labels", "metrics", "path", "result", "timestamp"}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("entry fields = %v; want %v", got, want)
+			}
+			uid := string(tt.Request.AdmissionRequest.UID)
+			if entry["decision_id"] != uid {
+				t.Errorf("decision_id = %v; want %s", entry["decision_id"], uid)
+			}
+			if entry["path"] != "gatekeeper/admission" {
+				t.Errorf("path = %v; want gatekeeper/admission", entry["path"])
+			}
+			if labels := entry["labels"].(map[string]interface{}); labels["version"] != "v0.0.0-test" {
+				t.Errorf("labels = %v; want the version of Gatekeeper", labels)
+			}
+			if _, err := time.Parse(time.RFC3339Nano, entry["timestamp"].(string)); err != nil {
+				t.Errorf("timestamp = %v; want an RFC 3339 time", entry["timestamp"])
+			}
+			if _, ok := entry["metrics"].(map[string]interface{})["timer_rego_query_eval_ns"].(float64); !ok {
+				t.Errorf("metrics = %v; want timer_rego_query_eval_ns", entry["metrics"])
+			}
+			input := entry["input"].(map[string]interface{})
+			if input["kind"] != "AdmissionReview" || input["request"].(map[string]interface{})["uid"] != uid {
+				t.Errorf("input = %v; want the AdmissionReview of the request", input)
+			}
+			result := entry["result"].(map[string]interface{})
+			response := result["response"].(map[string]interface{})
+			if result["kind"] != "AdmissionReview" || response["allowed"] != tt.Allowed {
+				t.Errorf("result = %v; want the AdmissionReview of a response with allowed %v", result, tt.Allowed)
+			}
+			if !tt.Allowed {
+				if reason := response["status"].(map[string]interface{})["reason"]; !strings.Contains(reason.(string), "[denied by deny-all-namespaces]") {
+					t.Errorf("reason = %v; want the denial", reason)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPDecisionSink(t *testing.T) {
+	received := make(chan []map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("decision logs are not a JSON array: %s", err)
+		}
+		received <- batch
+	}))
+	defer server.Close()
+
+	sink, err := newDecisionSink(server.URL)
+	if err != nil {
+		t.Fatalf("newDecisionSink() err = %s", err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go sink.(*httpSink).Start(stop)
+	req := namespaceRequest("posted")
+	req.AdmissionRequest.UID = "posted-uid"
+	sink.send(newDecisionLogEntry(req, admission.ValidationResponse(true, ""), time.Millisecond))
+
+	select {
+	case batch := <-received:
+		if len(batch) != 1 || batch[0]["decision_id"] != "posted-uid" {
+			t.Errorf("posted decision logs = %v; want the entry of the request", batch)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no decision logs posted")
+	}
+
+	if _, err := newDecisionSink("syslog"); err == nil {
+		t.Error("newDecisionSink(syslog) err = nil; want an error")
+	}
+}
+
+func TestDecisionBuffer(t *testing.T) {
+	defer func() { debugDecisions = nil }()
+	handler := makeDenyingHandler(t)
+	handler.decisions = newDecisionBuffer(3)
+	debugDecisions = handler.decisions
+	for i := 0; i < 5; i++ {
+		handler.Handle(context.Background(), namespaceRequest(fmt.Sprintf("ns-%d", i)))
+	}
+
+	query := func(remoteAddr, rawQuery string) (*httptest.ResponseRecorder, []DecisionRecord) {
+		req := httptest.NewRequest("GET", "/debug/decisions?"+rawQuery, nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		DecisionsHandler().ServeHTTP(rec, req)
+		var records []DecisionRecord
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &records); err != nil {
+				t.Fatalf("could not decode decisions: %s", err)
+			}
+		}
+		return rec, records
+	}
+	names := func(records []DecisionRecord) []string {
+		var out []string
+		for _, r := range records {
+			out = append(out, r.Name)
+		}
+		return out
+	}
+
+	rec, records := query("127.0.0.1:40000", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d; want 200", rec.Code)
+	}
+	if got, want := names(records), []string{"ns-2", "ns-3", "ns-4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("decisions = %v; want %v", got, want)
+	}
+	r := records[2]
+	if r.Allowed || r.Kind != "Namespace" || !reflect.DeepEqual(r.MatchedConstraints, []string{"K8sGoodRego/deny-all-namespaces"}) || !strings.Contains(r.Reason, "denied by deny-all-namespaces") {
+		t.Errorf("decision = %+v; want a denial of the namespace by deny-all-namespaces", r)
+	}
+
+	if _, records := query("[::1]:40000", "limit=2"); !reflect.DeepEqual(names(records), []string{"ns-3", "ns-4"}) {
+		t.Errorf("decisions with limit=2 = %v; want [ns-3 ns-4]", names(records))
+	}
+	if rec, _ := query("127.0.0.1:40000", "limit=-1"); rec.Code != http.StatusBadRequest {
+		t.Errorf("status with limit=-1 = %d; want 400", rec.Code)
+	}
+	if rec, _ := query("10.0.0.1:40000", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("status from another host = %d; want 403", rec.Code)
+	}
+
+	// concurrent decisions never grow the buffer past its size
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			handler.Handle(context.Background(), namespaceRequest(fmt.Sprintf("concurrent-%d", i)))
+		}(i)
+	}
+	wg.Wait()
+	if got := len(handler.decisions.list()); got != 3 {
+		t.Errorf("buffer holds %d decisions; want 3", got)
+	}
+
+	if err := validateDecisionBufferSize(0); err == nil {
+		t.Error("validateDecisionBufferSize(0) err = nil; want an error")
+	}
+}
+
+func TestDryRunRequest(t *testing.T) {
+	defer flag.Set("log-denies", "false")
+	origLog := log
+	defer func() { log = origLog }()
+	flag.Set("log-denies", "true")
+	var entries []map[string]interface{}
+	log = recordingLogger{entries: &entries}
+	requests := func(status, dryRun string) float64 {
+		m := &dto.Metric{}
+		if err := validationRequestsTotal.WithLabelValues(status, dryRun).Write(m); err != nil {
+			t.Fatalf("could not read metric: %s", err)
+		}
+		return m.GetCounter().GetValue()
+	}
+
+	handler := makeDenyingHandler(t)
+	handler.decisions = newDecisionBuffer(1)
+	req := namespaceRequest("dry-run-request")
+	dryRun := true
+	req.AdmissionRequest.DryRun = &dryRun
+	before, beforeEnforced := requests("deny", "true"), requests("deny", "false")
+	resp := handler.Handle(context.Background(), req)
+	if resp.Response.Allowed {
+		t.Fatal("dry-run request allowed; want the same denial as a persisted request")
+	}
+	if !strings.Contains(string(resp.Response.Result.Reason), "[denied by deny-all-namespaces]") {
+		t.Errorf("reason = %q; want the denial of deny-all-namespaces", resp.Response.Result.Reason)
+	}
+	if got := requests("deny", "true") - before; got != 1 {
+		t.Errorf("dry-run denials counted %v times; want 1", got)
+	}
+	if got := requests("deny", "false") - beforeEnforced; got != 0 {
+		t.Errorf("dry-run denial counted %v times as enforced; want 0", got)
+	}
+	var decision map[string]interface{}
+	for _, e := range entries {
+		if e["msg"] == "admission decision" {
+			decision = e
+		}
+	}
+	if decision == nil || decision["dry_run"] != true {
+		t.Errorf("decision log = %v; want it tagged dry_run", decision)
+	}
+	if records := handler.decisions.list(); len(records) != 1 || !records[0].DryRun {
+		t.Errorf("recorded decisions = %+v; want the dry-run denial", records)
+	}
+}
+
+func TestMatchHandler(t *testing.T) {
+	opa, driver, err := makeOpaClientAndDriver()
+	if err != nil {
+		t.Fatalf("Could not initialize OPA: %s", err)
+	}
+	templ := &templv1beta1.ConstraintTemplate{}
+	if err := yaml.Unmarshal([]byte(good_rego_template), templ); err != nil {
+		t.Fatalf("Could not instantiate template: %s", err)
+	}
+	unversioned := &templates.ConstraintTemplate{}
+	if err := runtimeScheme.Convert(templ, unversioned, nil); err != nil {
+		t.Fatalf("Could not convert to unversioned: %v", err)
+	}
+	if _, err := opa.AddTemplate(context.Background(), unversioned); err != nil {
+		t.Fatalf("Could not add template: %s", err)
+	}
+	for name, match := range map[string]string{
+		"everything":          `{}`,
+		"deployments-in-prod": `{"kinds": [{"apiGroups": ["apps"], "kinds": ["Deployment"]}], "namespaces": ["prod"]}`,
+		"web-apps":            `{"labelSelector": {"matchLabels": {"app": "web"}}}`,
+		"pods":                `{"kinds": [{"apiGroups": [""], "kinds": ["Pod"]}]}`,
+		"deployments-in-dev":  `{"kinds": [{"apiGroups": ["apps"], "kinds": ["Deployment"]}], "namespaces": ["dev"]}`,
+	} {
+		cstr := &unstructured.Unstructured{}
+		if err := json.Unmarshal([]byte(fmt.Sprintf(`{"apiVersion": "constraints.gatekeeper.sh/v1beta1", "kind": "K8sGoodRego", "metadata": {"name": %q}, "spec": {"match": %s}}`, name, match)), cstr); err != nil {
+			t.Fatalf("Could not instantiate constraint: %s", err)
+		}
+		if _, err := opa.AddConstraint(context.Background(), cstr); err != nil {
+			t.Fatalf("Could not add constraint: %s", err)
+		}
+	}
+
+	deployment := `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: frontend
+  namespace: prod
+  labels:
+    app: web
+`
+	tc := []struct {
+		Name        string
+		Method      string
+		Query       string
+		Body        string
+		RemoteAddr  string
+		Code        int
+		Constraints []string
+	}{
+		{
+			Name:        "deployment",
+			Method:      "POST",
+			Body:        deployment,
+			Code:        http.StatusOK,
+			Constraints: []string{"K8sGoodRego/deployments-in-prod", "K8sGoodRego/everything", "K8sGoodRego/web-apps"},
+		},
+		{
+			Name:        "update",
+			Method:      "POST",
+			Query:       "operation=update",
+			Body:        deployment,
+			Code:        http.StatusOK,
+			Constraints: []string{"K8sGoodRego/deployments-in-prod", "K8sGoodRego/everything", "K8sGoodRego/web-apps"},
+		},
+		{
+			Name:        "unlabeled namespace",
+			Method:      "POST",
+			Body:        `{"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "prod"}}`,
+			Code:        http.StatusOK,
+			Constraints: []string{"K8sGoodRego/everything"},
+		},
+		{Name: "get", Method: "GET", Code: http.StatusMethodNotAllowed},
+		{Name: "invalid resource", Method: "POST", Body: "kind: [", Code: http.StatusBadRequest},
+		{Name: "unknown operation", Method: "POST", Query: "operation=patch", Body: deployment, Code: http.StatusBadRequest},
+		{Name: "remote client", Method: "POST", Body: deployment, RemoteAddr: "10.0.0.7:34567", Code: http.StatusForbidden},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.Method, "/debug/match?"+tt.Query, strings.NewReader(tt.Body))
+			req.RemoteAddr = "127.0.0.1:34567"
+			if tt.RemoteAddr != "" {
+				req.RemoteAddr = tt.RemoteAddr
+			}
+			rec := httptest.NewRecorder()
+			MatchHandler(driver, nil).ServeHTTP(rec, req)
+			if rec.Code != tt.Code {
+				t.Fatalf("status = %d; want %d: %s", rec.Code, tt.Code, rec.Body.String())
+			}
+			if tt.Code != http.StatusOK {
+				return
+			}
+			report := &MatchReport{}
+			if err := json.Unmarshal(rec.Body.Bytes(), report); err != nil {
+				t.Fatalf("could not decode report: %s", err)
+			}
+			if !reflect.DeepEqual(report.Constraints, tt.Constraints) {
+				t.Errorf("constraints = %v; want %v", report.Constraints, tt.Constraints)
+			}
+		})
+	}
+}
+
+var _ ctrlclient.Reader = &webhookConfigReader{}
+
+// webhookConfigReader serves a single ValidatingWebhookConfiguration, or none if cfg is nil
+type webhookConfigReader struct {
+	cfg *admissionregistrationv1beta1.ValidatingWebhookConfiguration
+}
+
+func (r *webhookConfigReader) Get(ctx context.Context, key ctrlclient.ObjectKey, obj runtime.Object) error {
+	if r.cfg == nil || r.cfg.GetName() != key.Name {
+		return apierrors.NewNotFound(admissionregistrationv1beta1.Resource("validatingwebhookconfigurations"), key.Name)
+	}
+	r.cfg.DeepCopyInto(obj.(*admissionregistrationv1beta1.ValidatingWebhookConfiguration))
+	return nil
+}
+
+func (r *webhookConfigReader) List(ctx context.Context, opts *ctrlclient.ListOptions, list runtime.Object) error {
+	return nil
+}
+
+func TestWebhookConfigGuard(t *testing.T) {
+	name := "validation.gatekeeper.sh"
+	installed := func() *admissionregistrationv1beta1.ValidatingWebhookConfiguration {
+		cfg := &admissionregistrationv1beta1.ValidatingWebhookConfiguration{
+			Webhooks: []admissionregistrationv1beta1.Webhook{{Name: name}},
+		}
+		cfg.SetName(name)
+		return cfg
+	}
+	missing := func() float64 {
+		m := &dto.Metric{}
+		if err := webhookConfigMissingGauge.Write(m); err != nil {
+			t.Fatalf("could not read metric: %s", err)
+		}
+		return m.GetGauge().GetValue()
+	}
+	recreated := func() float64 {
+		m := &dto.Metric{}
+		if err := webhookConfigRecreatedTotal.Write(m); err != nil {
+			t.Fatalf("could not read metric: %s", err)
+		}
+		return m.GetCounter().GetValue()
+	}
+
+	reader := &webhookConfigReader{cfg: installed()}
+	guard := &webhookConfigGuard{reader: reader, name: name}
+	guard.check(context.Background())
+	if got := missing(); got != 0 {
+		t.Errorf("gatekeeper_webhook_config_missing = %v with the configuration installed; want 0", got)
+	}
+
+	t.Run("deleted without --manage-webhook-config", func(t *testing.T) {
+		reader.cfg = nil
+		guard.check(context.Background())
+		if got := missing(); got != 1 {
+			t.Errorf("gatekeeper_webhook_config_missing = %v; want 1", got)
+		}
+	})
+
+	t.Run("webhook removed without --manage-webhook-config", func(t *testing.T) {
+		reader.cfg = installed()
+		reader.cfg.Webhooks = nil
+		guard.check(context.Background())
+		if got := missing(); got != 1 {
+			t.Errorf("gatekeeper_webhook_config_missing = %v; want 1", got)
+		}
+	})
+
+	t.Run("deleted with --manage-webhook-config", func(t *testing.T) {
+		reader.cfg = nil
+		installs := 0
+		guard.install = func() error {
+			installs++
+			reader.cfg = installed()
+			return nil
+		}
+		before := recreated()
+		guard.check(context.Background())
+		if installs != 1 {
+			t.Fatalf("configuration installed %d times; want 1", installs)
+		}
+		if got := missing(); got != 0 {
+			t.Errorf("gatekeeper_webhook_config_missing = %v once recreated; want 0", got)
+		}
+		if got := recreated() - before; got != 1 {
+			t.Errorf("gatekeeper_webhook_config_recreated_total increased by %v; want 1", got)
+		}
+		// the recreated configuration is left alone
+		guard.check(context.Background())
+		if installs != 1 {
+			t.Errorf("configuration installed %d times; want 1", installs)
+		}
+	})
+
+	t.Run("recreation fails", func(t *testing.T) {
+		reader.cfg = nil
+		guard.install = func() error { return fmt.Errorf("forbidden") }
+		guard.check(context.Background())
+		if got := missing(); got != 1 {
+			t.Errorf("gatekeeper_webhook_config_missing = %v; want 1", got)
+		}
+	})
+}
+
+func TestCertExpiryMonitor(t *testing.T) {
+	defer func(d time.Duration) { certExpiryCheckInterval = d }(certExpiryCheckInterval)
+	certExpiryCheckInterval = 10 * time.Millisecond
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatalf("could not create directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, serverCertName)
+	expiry := func() float64 {
+		m := &dto.Metric{}
+		if err := webhookCertExpirySeconds.Write(m); err != nil {
+			t.Fatalf("could not read metric: %s", err)
+		}
+		return m.GetGauge().GetValue()
+	}
+	webhookCertExpirySeconds.Set(0)
+
+	m := &certExpiryMonitor{path: path}
+	if err := m.check(); err == nil {
+		t.Error("check() err = nil without a certificate; want an error")
+	}
+	if err := ioutil.WriteFile(path, []byte("not a certificate"), 0644); err != nil {
+		t.Fatalf("could not write certificate: %s", err)
+	}
+	if err := m.check(); err == nil {
+		t.Error("check() err = nil with an invalid certificate; want an error")
+	}
+
+	// the certificate is read again once it is written
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- m.Start(stop) }()
+	defer func() {
+		close(stop)
+		if err := <-done; err != nil {
+			t.Errorf("Start() err = %s", err)
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := ioutil.WriteFile(path, []byte(serving_cert), 0644); err != nil {
+		t.Fatalf("could not write certificate: %s", err)
+	}
+	want := float64(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC).Unix())
+	for i := 0; i < 100 && expiry() != want; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := expiry(); got != want {
+		t.Errorf("gatekeeper_webhook_cert_expiry_seconds = %v; want %v", got, want)
+	}
+}
+
+func TestWebhookMaxConcurrent(t *testing.T) {
+	defer flag.Set("webhook-max-concurrent", "0")
+	flag.Set("webhook-max-concurrent", "1")
+	handler := makeDenyingHandler(t)
+	handler = newValidationHandler(handler.opa, handler.driver, nil)
+	handler.injectedConfig = &v1alpha1.Config{}
+	if handler.limiter == nil {
+		t.Fatal("no limiter with --webhook-max-concurrent")
+	}
+	rejected := func() float64 {
+		m := &dto.Metric{}
+		if err := concurrencyRejectedTotal.Write(m); err != nil {
+			t.Fatalf("could not read metric: %s", err)
+		}
+		return m.GetCounter().GetValue()
+	}
+	inFlight := func() float64 {
+		m := &dto.Metric{}
+		if err := inFlightRequests.Write(m); err != nil {
+			t.Fatalf("could not read metric: %s", err)
+		}
+		return m.GetGauge().GetValue()
+	}
+
+	// a review holds the only slot
+	if err := handler.limiter.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire() err = %s", err)
+	}
+	if got := inFlight(); got != 1 {
+		t.Errorf("gatekeeper_validation_in_flight_requests = %v; want 1", got)
+	}
+
+	// requests past the bound are rejected once their deadline passes
+	before := rejected()
+	ctx, cancel := context.WithTimeout(context.Background(), responseMargin+50*time.Millisecond)
+	defer cancel()
+	resp := handler.Handle(ctx, namespaceRequest("rejected"))
+	if resp.Response.Allowed || resp.Response.Result == nil || resp.Response.Result.Code != http.StatusTooManyRequests {
+		t.Errorf("response = %+v; want code %d", resp.Response.Result, http.StatusTooManyRequests)
+	}
+	if got := rejected() - before; got != 1 {
+		t.Errorf("gatekeeper_validation_concurrency_rejected_total increased by %v; want 1", got)
+	}
+
+	// and reviewed once a slot is free before it
+	done := make(chan atypes.Response)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		done <- handler.Handle(ctx, namespaceRequest("waiting"))
+	}()
+	select {
+	case <-done:
+		t.Fatal("request reviewed while the only slot was held")
+	case <-time.After(100 * time.Millisecond):
+	}
+	handler.limiter.release()
+	select {
+	case resp := <-done:
+		if resp.Response.Allowed || resp.Response.Result.Code != http.StatusForbidden {
+			t.Errorf("response = %+v; want a denial by the constraint", resp.Response.Result)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("waiting request not reviewed once the slot was released")
+	}
+	if got := inFlight(); got != 0 {
+		t.Errorf("gatekeeper_validation_in_flight_requests = %v; want 0", got)
+	}
+	if got := rejected() - before; got != 1 {
+		t.Errorf("gatekeeper_validation_concurrency_rejected_total increased by %v; want only the first rejection", got)
+	}
+}
+
+func TestValidateConcurrencyLimits(t *testing.T) {
+	tc := []struct {
+		Name          string
+		MaxConcurrent int
+		PerNamespace  int
+		Timeout       time.Duration
+		ErrorExpected bool
+	}{
+		{Name: "unbounded"},
+		{Name: "max concurrent with timeout", MaxConcurrent: 50, Timeout: 3 * time.Second},
+		{Name: "per namespace with timeout", PerNamespace: 10, Timeout: 3 * time.Second},
+		{Name: "max concurrent without timeout", MaxConcurrent: 50, ErrorExpected: true},
+		{Name: "per namespace without timeout", MaxConcurrent: 50, PerNamespace: 10, ErrorExpected: true},
+		{Name: "per namespace alone without timeout", PerNamespace: 10, ErrorExpected: true},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := validateConcurrencyLimits(tt.MaxConcurrent, tt.PerNamespace, tt.Timeout)
+			if (err != nil) != tt.ErrorExpected {
+				t.Errorf("validateConcurrencyLimits() err = %v; want an error: %t", err, tt.ErrorExpected)
+			}
+		})
+	}
+}
+
+func TestPerNamespaceAdmissionConcurrency(t *testing.T) {
+	defer flag.Set("webhook-max-concurrent", "0")
+	defer flag.Set("per-namespace-admission-concurrency", "0")
+	flag.Set("webhook-max-concurrent", "4")
+	flag.Set("per-namespace-admission-concurrency", "2")
+	handler := makeDenyingHandler(t)
+	handler = newValidationHandler(handler.opa, handler.driver, nil)
+	handler.injectedConfig = &v1alpha1.Config{}
+	if handler.namespaceLimiter == nil {
+		t.Fatal("no namespace limiter with --per-namespace-admission-concurrency")
+	}
+	inNamespace := func(namespace, name string) atypes.Request {
+		req := namespaceRequest(name)
+		req.AdmissionRequest.Namespace = namespace
+		return req
+	}
+
+	// long reviews hold both slots of the busy namespace
+	for i := 0; i < 2; i++ {
+		if err := handler.namespaceLimiter.acquire(context.Background(), "busy"); err != nil {
+			t.Fatalf("acquire() err = %s", err)
+		}
+	}
+	// while a burst of requests in it waits
+	const burst = 20
+	done := make(chan atypes.Response, burst)
+	for i := 0; i < burst; i++ {
+		go func(i int) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			done <- handler.Handle(ctx, inNamespace("busy", fmt.Sprintf("burst-%d", i)))
+		}(i)
+	}
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("request reviewed while the slots of its namespace were held")
+	default:
+	}
+
+	// requests in other namespaces still get a slot, and are reviewed without waiting
+	for i := 0; i < 4; i++ {
+		start := time.Now()
+		resp := handler.Handle(context.Background(), inNamespace("quiet", fmt.Sprintf("quiet-%d", i)))
+		if resp.Response.Allowed || resp.Response.Result.Code != http.StatusForbidden {
+			t.Errorf("response = %+v; want a denial by the constraint", resp.Response.Result)
+		}
+		if latency := time.Since(start); latency > time.Second {
+			t.Errorf("request in a quiet namespace took %s during a burst in another namespace", latency)
+		}
+	}
+
+	// the burst is reviewed once the slots of its namespace are released
+	handler.namespaceLimiter.release("busy")
+	handler.namespaceLimiter.release("busy")
+	for i := 0; i < burst; i++ {
+		select {
+		case resp := <-done:
+			if resp.Response.Allowed || resp.Response.Result.Code != http.StatusForbidden {
+				t.Errorf("response = %+v; want a denial by the constraint", resp.Response.Result)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%d requests of the burst not reviewed once the slots were released", burst-i)
+		}
+	}
+	handler.namespaceLimiter.mux.Lock()
+	defer handler.namespaceLimiter.mux.Unlock()
+	if n := len(handler.namespaceLimiter.namespaces); n != 0 {
+		t.Errorf("%d namespaces still tracked once idle", n)
+	}
+}
+
+func TestTemplateEvalMetrics(t *testing.T) {
+	defer flag.Set("template-eval-metrics", "false")
+	handler := makeDenyingHandler(t)
+	templ := &templv1beta1.ConstraintTemplate{}
+	if err := yaml.Unmarshal([]byte(good_rego_template), templ); err != nil {
+		t.Fatalf("Could not instantiate template: %s", err)
+	}
+	// two constraints of the same template are timed together
+	cnstr := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(deny_all_namespaces_again), &cnstr.Object); err != nil {
+		t.Fatalf("Could not instantiate constraint: %s", err)
+	}
+	if _, err := handler.opa.AddConstraint(context.Background(), cnstr); err != nil {
+		t.Fatalf("Could not add constraint: %s", err)
+	}
+	templateEvalDurationSeconds.DeleteLabelValues("K8sGoodRego")
+	samples := func() uint64 {
+		m := &dto.Metric{}
+		if err := templateEvalDurationSeconds.WithLabelValues("K8sGoodRego").(prometheus.Metric).Write(m); err != nil {
+			t.Fatalf("could not read metric: %s", err)
+		}
+		return m.GetHistogram().GetSampleCount()
+	}
+
+	if resp := handler.Handle(context.Background(), namespaceRequest("foo")); resp.Response.Allowed {
+		t.Fatal("request allowed; want a denial")
+	}
+	if got := samples(); got != 0 {
+		t.Errorf("%d evaluation times recorded without --template-eval-metrics; want 0", got)
+	}
+
+	flag.Set("template-eval-metrics", "true")
+	resp := handler.Handle(context.Background(), namespaceRequest("foo"))
+	if resp.Response.Allowed {
+		t.Fatal("request allowed; want a denial")
+	}
+	for _, msg := range []string{"[denied by deny-all-namespaces]", "[denied by deny-all-namespaces-again]"} {
+		if !strings.Contains(string(resp.Response.Result.Reason), msg) {
+			t.Errorf("reason %q does not contain %q", resp.Response.Result.Reason, msg)
+		}
+	}
+	if got := samples(); got != 1 {
+		t.Errorf("%d evaluation times recorded for the template; want 1", got)
+	}
+
+	// deleting the template forgets its evaluation times
+	templateEvalMetricsEventHandler().OnDelete(templ)
+	if templateEvalDurationSeconds.DeleteLabelValues("K8sGoodRego") {
+		t.Error("evaluation times of a deleted template still reported")
+	}
+}
+
+func TestExemptionsConfigMap(t *testing.T) {
+	handler := makeDenyingHandler(t)
+	handler.exemptions = newExemptions("gatekeeper-exemptions")
+	events := handler.exemptions.eventHandler()
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "gatekeeper-exemptions", Namespace: util.GetNamespace()},
+	}
+	allowed := func(req atypes.Request) bool {
+		return handler.Handle(context.Background(), req).Response.Allowed
+	}
+	userRequest := func(name, user string) atypes.Request {
+		req := namespaceRequest(name)
+		req.AdmissionRequest.UserInfo.Username = user
+		return req
+	}
+
+	if allowed(namespaceRequest("foo")) {
+		t.Fatal("request allowed before any exemption")
+	}
+
+	cm.Data = map[string]string{"namespaces": "foo,\nbar"}
+	events.OnAdd(cm)
+	if !allowed(namespaceRequest("foo")) || !allowed(namespaceRequest("bar")) {
+		t.Error("requests for exempted namespaces denied")
+	}
+	if allowed(namespaceRequest("baz")) {
+		t.Error("request for a namespace that is not exempted allowed")
+	}
+
+	// an incident needs another exemption
+	updated := cm.DeepCopy()
+	updated.Data = map[string]string{"users": "oncall", "kinds": "Deployment.apps"}
+	events.OnUpdate(cm, updated)
+	if allowed(namespaceRequest("foo")) {
+		t.Error("request for a namespace no longer exempted allowed")
+	}
+	if !allowed(userRequest("foo", "oncall")) {
+		t.Error("request by an exempted user denied")
+	}
+	if set := handler.exemptions.get(); !set.kinds[schema.GroupKind{Group: "apps", Kind: "Deployment"}] {
+		t.Errorf("kinds = %v; want Deployment.apps exempted", set.kinds)
+	}
+
+	// a typo keeps the previous exemptions in place
+	invalid := updated.DeepCopy()
+	invalid.Data = map[string]string{"user": "someone-else"}
+	events.OnUpdate(updated, invalid)
+	if !allowed(userRequest("foo", "oncall")) {
+		t.Error("exemptions dropped by an invalid ConfigMap")
+	}
+
+	other := updated.DeepCopy()
+	other.Name = "unrelated"
+	other.Data = map[string]string{"namespaces": "foo"}
+	events.OnAdd(other)
+	if allowed(namespaceRequest("foo")) {
+		t.Error("exemptions read from another ConfigMap")
+	}
+
+	events.OnDelete(updated)
+	if allowed(userRequest("foo", "oncall")) {
+		t.Error("request exempted after the ConfigMap was deleted")
+	}
+}
+
+func TestNonBypassableConstraint(t *testing.T) {
+	handler := makeDenyingHandler(t)
+	handler.exemptions = newExemptions("gatekeeper-exemptions")
+	handler.exemptions.eventHandler().OnAdd(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "gatekeeper-exemptions", Namespace: util.GetNamespace()},
+		Data:       map[string]string{"namespaces": "foo"},
+	})
+	if resp := handler.Handle(context.Background(), namespaceRequest("foo")); !resp.Response.Allowed {
+		t.Fatalf("exempted request denied by bypassable constraints: %v", resp.Response.Result)
+	}
+
+	cnstr := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(deny_all_namespaces), &cnstr.Object); err != nil {
+		t.Fatalf("Could not instantiate constraint: %s", err)
+	}
+	cnstr.SetName("no-privileged-namespaces")
+	if err := unstructured.SetNestedField(cnstr.Object, false, "spec", "bypassable"); err != nil {
+		t.Fatalf("Could not set bypassable: %s", err)
+	}
+	if _, err := handler.opa.AddConstraint(context.Background(), cnstr); err != nil {
+		t.Fatalf("Could not add constraint: %s", err)
+	}
+
+	for _, name := range []string{"foo", util.GetNamespace()} {
+		resp := handler.Handle(context.Background(), namespaceRequest(name))
+		if resp.Response.Allowed {
+			t.Errorf("exempted namespace %s allowed despite a non-bypassable constraint", name)
+			continue
+		}
+		reason := string(resp.Response.Result.Reason)
+		if !strings.Contains(reason, "no-privileged-namespaces") {
+			t.Errorf("reason = %q; want a denial by the non-bypassable constraint", reason)
+		}
+		if strings.Contains(reason, "deny-all-namespaces") {
+			t.Errorf("reason = %q; want bypassable constraints skipped", reason)
+		}
+	}
+	resp := handler.Handle(context.Background(), namespaceRequest("bar"))
+	if resp.Response.Allowed {
+		t.Fatal("request that is not exempted allowed")
+	}
+	if reason := string(resp.Response.Result.Reason); !strings.Contains(reason, "deny-all-namespaces") || !strings.Contains(reason, "no-privileged-namespaces") {
+		t.Errorf("reason = %q; want denials by both constraints", reason)
+	}
+}
+
+func TestRedactPaths(t *testing.T) {
+	origLog := log
+	defer func() { log = origLog }()
+	var entries []map[string]interface{}
+	log = recordingLogger{entries: &entries}
+
+	redactor, err := newRedactor(defaultRedactPaths)
+	if err != nil {
+		t.Fatalf("newRedactor() err = %s", err)
+	}
+	handler := makeDenyingHandler(t)
+	buf := &bytes.Buffer{}
+	handler.sink = &writerSink{w: buf}
+	handler.redactor = redactor
+	handler.injectedConfig = &v1alpha1.Config{Spec: v1alpha1.ConfigSpec{Validation: v1alpha1.Validation{
+		Traces: []v1alpha1.Trace{{User: "alice", Kind: v1alpha1.GVK{Version: "v1", Kind: "Secret"}, Dump: "All"}},
+	}}}
+	secret := `{"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "db", "namespace": "default"}, "data": {"password": "czNjcjN0LXBhc3N3MHJk"}, "stringData": {"token": "hunter2-token"}}`
+	synced := &unstructured.Unstructured{}
+	if err := json.Unmarshal([]byte(strings.Replace(secret, `"db"`, `"synced"`, 1)), &synced.Object); err != nil {
+		t.Fatalf("could not parse secret: %s", err)
+	}
+	if _, err := handler.opa.AddData(context.Background(), synced); err != nil {
+		t.Fatalf("could not add data: %s", err)
+	}
+
+	req := atypes.Request{AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+		UID:       "secret-uid",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Secret"},
+		Namespace: "default",
+		Name:      "db",
+		Operation: admissionv1beta1.Create,
+		UserInfo:  authenticationv1.UserInfo{Username: "alice"},
+		Object:    runtime.RawExtension{Raw: []byte(secret)},
+	}}
+	if resp := handler.Handle(context.Background(), req); !resp.Response.Allowed {
+		t.Fatalf("secret denied: %v", resp.Response.Result)
+	}
+	if string(req.AdmissionRequest.Object.Raw) != secret {
+		t.Error("redaction modified the reviewed request")
+	}
+
+	var lines []string
+	lines = append(lines, strings.Split(strings.TrimSpace(buf.String()), "\n")...)
+	withheld := false
+	dumped := false
+	for _, e := range entries {
+		if strings.HasPrefix(fmt.Sprint(e["msg"]), "not logging the trace") {
+			withheld = true
+		}
+		if strings.Contains(fmt.Sprint(e["msg"]), `"modules"`) {
+			dumped = true
+		}
+		lines = append(lines, fmt.Sprint(e))
+	}
+	if len(lines) < 2 || !withheld || !dumped {
+		t.Fatalf("decision log %q, trace withheld %t, dump logged %t; want all of them", buf.String(), withheld, dumped)
+	}
+	for _, line := range lines {
+		for _, value := range []string{"czNjcjN0LXBhc3N3MHJk", "hunter2-token"} {
+			if strings.Contains(line, value) {
+				t.Errorf("line %q leaks secret value %s", line, value)
+			}
+		}
+	}
+	if !strings.Contains(buf.String(), `"password":"[REDACTED]"`) {
+		t.Errorf("decision log %q; want the keys of the secret's data kept", buf.String())
+	}
+}
+
+func TestRedactorPaths(t *testing.T) {
+	redactor, err := newRedactor(defaultRedactPaths)
+	if err != nil {
+		t.Fatalf("newRedactor() err = %s", err)
+	}
+	pod := `{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "web"}, "spec": {"containers": [{"name": "a", "env": [{"name": "TOKEN", "value": "abc"}, {"name": "REF", "valueFrom": {"secretKeyRef": {"name": "s", "key": "k"}}}]}]}}`
+	raw, redacted, err := redactor.redactRaw(runtime.RawExtension{Raw: []byte(pod)})
+	if err != nil || !redacted {
+		t.Fatalf("redactRaw() = %t, %v; want redacted", redacted, err)
+	}
+	expected := `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"web"},"spec":{"containers":[{"env":[{"name":"TOKEN","value":"[REDACTED]"},{"name":"REF","valueFrom":{"secretKeyRef":{"key":"k","name":"s"}}}],"name":"a"}]}}`
+	if string(raw.Raw) != expected {
+		t.Errorf("redactRaw() = %s; want %s", raw.Raw, expected)
+	}
+	configMap := `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "cfg"}, "data": {"key": "value"}}`
+	if raw, redacted, _ := redactor.redactRaw(runtime.RawExtension{Raw: []byte(configMap)}); redacted || string(raw.Raw) != configMap {
+		t.Errorf("redactRaw() = %s; want ConfigMap data, which is not selected, kept", raw.Raw)
+	}
+
+	for _, paths := range []string{"spec..env", "Secret:", ":data"} {
+		if _, err := newRedactor(paths); err == nil {
+			t.Errorf("newRedactor(%q) succeeded; want error", paths)
+		}
+	}
+	if r, err := newRedactor(""); r != nil || err != nil {
+		t.Errorf("newRedactor(\"\") = %v, %v; want nil", r, err)
+	}
+}
+
+func TestDenyAfter(t *testing.T) {
+	handler := makeDenyingHandler(t)
+	tc := []struct {
+		Name      string
+		DenyAfter string
+		Allowed   bool
+	}{
+		{Name: "before escalation", DenyAfter: time.Now().Add(time.Hour).UTC().Format(time.RFC3339), Allowed: true},
+		{Name: "after escalation", DenyAfter: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339), Allowed: false},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			cnstr := &unstructured.Unstructured{}
+			if err := yaml.Unmarshal([]byte(deny_all_namespaces), &cnstr.Object); err != nil {
+				t.Fatalf("Could not instantiate constraint: %s", err)
+			}
+			if err := unstructured.SetNestedField(cnstr.Object, tt.DenyAfter, "spec", "denyAfter"); err != nil {
+				t.Fatalf("Could not set denyAfter: %s", err)
+			}
+			if _, err := handler.opa.AddConstraint(context.Background(), cnstr); err != nil {
+				t.Fatalf("Could not add constraint: %s", err)
+			}
+			resp := handler.Handle(context.Background(), namespaceRequest("escalated"))
+			if resp.Response.Allowed != tt.Allowed {
+				t.Errorf("allowed = %t; want %t", resp.Response.Allowed, tt.Allowed)
+			}
+		})
+	}
+}
+
+const (
+	immutable_label_template = `
+apiVersion: templates.gatekeeper.sh/v1beta1
+kind: ConstraintTemplate
+metadata:
+  name: k8simmutablelabel
+spec:
+  crd:
+    spec:
+      names:
+        kind: K8sImmutableLabel
+        listKind: K8sImmutableLabelList
+        plural: k8simmutablelabel
+        singular: k8simmutablelabel
+  targets:
+    - target: admission.k8s.gatekeeper.sh
+      rego: |
+        package immutablelabel
+
+        violation[{"msg": msg}] {
+          input.review.operation == "UPDATE"
+          previous := team(input.review.oldObject)
+          current := team(input.review.object)
+          previous != current
+          msg := sprintf("label team is immutable, was %q, got %q", [previous, current])
+        }
+
+        team(obj) = value {
+          value := obj.metadata.labels.team
+        }
+
+        team(obj) = "" {
+          not obj.metadata.labels.team
+        }
+`
+
+	immutable_label_namespaces = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sImmutableLabel
+metadata:
+  name: immutable-team-label
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Namespace"]
+`
+)
+
+func TestImmutableField(t *testing.T) {
+	opa, driver, err := makeOpaClientAndDriver()
+	if err != nil {
+		t.Fatalf("Could not initialize OPA: %s", err)
+	}
+	templ := &templv1beta1.ConstraintTemplate{}
+	if err := yaml.Unmarshal([]byte(immutable_label_template), templ); err != nil {
+		t.Fatalf("Could not instantiate template: %s", err)
+	}
+	unversioned := &templates.ConstraintTemplate{}
+	if err := runtimeScheme.Convert(templ, unversioned, nil); err != nil {
+		t.Fatalf("Could not convert to unversioned: %v", err)
+	}
+	if _, err := opa.AddTemplate(context.Background(), unversioned); err != nil {
+		t.Fatalf("Could not add template: %s", err)
+	}
+	cnstr := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(immutable_label_namespaces), &cnstr.Object); err != nil {
+		t.Fatalf("Could not instantiate constraint: %s", err)
+	}
+	if _, err := opa.AddConstraint(context.Background(), cnstr); err != nil {
+		t.Fatalf("Could not add constraint: %s", err)
+	}
+	handler := &validationHandler{opa: opa, driver: driver, injectedConfig: &v1alpha1.Config{}}
+
+	namespace := func(team string) runtime.RawExtension {
+		return runtime.RawExtension{Raw: []byte(fmt.Sprintf(`{"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "payments", "labels": {"team": %q}}}`, team))}
+	}
+	tc := []struct {
+		Name      string
+		Operation admissionv1beta1.Operation
+		Object    runtime.RawExtension
+		OldObject runtime.RawExtension
+		Denial    string
+	}{
+		{
+			Name:      "Create",
+			Operation: admissionv1beta1.Create,
+			Object:    namespace("billing"),
+		},
+		{
+			Name:      "Update keeping the field",
+			Operation: admissionv1beta1.Update,
+			Object:    namespace("billing"),
+			OldObject: namespace("billing"),
+		},
+		{
+			Name:      "Update changing the field",
+			Operation: admissionv1beta1.Update,
+			Object:    namespace("checkout"),
+			OldObject: namespace("billing"),
+			Denial:    `label team is immutable, was "billing", got "checkout"`,
+		},
+		{
+			Name:      "Update removing the field",
+			Operation: admissionv1beta1.Update,
+			Object:    runtime.RawExtension{Raw: []byte(`{"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "payments"}}`)},
+			OldObject: namespace("billing"),
+			Denial:    `label team is immutable, was "billing", got ""`,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			req := atypes.Request{
+				AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+					Kind:      metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Namespace"},
+					Name:      "payments",
+					Operation: tt.Operation,
+					Object:    tt.Object,
+					OldObject: tt.OldObject,
+				},
+			}
+			resp := handler.Handle(context.Background(), req)
+			if tt.Denial == "" {
+				if !resp.Response.Allowed {
+					t.Errorf("Request denied: %v", resp.Response.Result)
+				}
+				return
+			}
+			if resp.Response.Allowed {
+				t.Fatalf("Request allowed; want denial %q", tt.Denial)
+			}
+			if reason := string(resp.Response.Result.Reason); !strings.Contains(reason, tt.Denial) {
+				t.Errorf("Reason = %q; want it to contain %q", reason, tt.Denial)
+			}
+		})
+	}
+}
+
+func TestViolationThreshold(t *testing.T) {
+	defer util.RecordAuditedViolations(nil)
+	handler := makeDenyingHandler(t)
+	cnstr := kindConstraint("deny-namespaces-above-one", "", "Namespace")
+	if err := unstructured.SetNestedField(cnstr.Object, map[string]interface{}{"denyAbove": int64(1)}, "spec", "violationThreshold"); err != nil {
+		t.Fatalf("Could not set violationThreshold: %s", err)
+	}
+	if _, err := handler.opa.AddConstraint(context.Background(), cnstr); err != nil {
+		t.Fatalf("Could not add constraint: %s", err)
+	}
+	audited := []*rtypes.Result{{
+		Constraint:        cnstr,
+		EnforcementAction: "deny",
+		Review: map[string]interface{}{
+			"kind": map[string]interface{}{"group": "", "version": "v1", "kind": "Namespace"},
+			"name": "existing",
+		},
+	}}
+
+	tc := []struct {
+		Name    string
+		Audited []*rtypes.Result
+		Request atypes.Request
+		Denied  bool
+	}{
+		{Name: "First violation", Request: namespaceRequest("foo")},
+		{Name: "Update of the audited violation", Audited: audited, Request: namespaceRequest("existing")},
+		{Name: "Violation above the threshold", Audited: audited, Request: namespaceRequest("foo"), Denied: true},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			util.RecordAuditedViolations(tt.Audited)
+			resp, err := handler.reviewRequest(context.Background(), tt.Request)
+			if err != nil {
+				t.Fatalf("reviewRequest() err = %s", err)
+			}
+			reason := ""
+			if vResp := validationResponse(resp); vResp.Response.Result != nil {
+				reason = string(vResp.Response.Result.Reason)
+			}
+			if denied := strings.Contains(reason, "[denied by deny-namespaces-above-one]"); denied != tt.Denied {
+				t.Errorf("denied by deny-namespaces-above-one = %t; want %t, reason %q", denied, tt.Denied, reason)
+			}
+		})
+	}
+}
+
+func TestConstraintDebugLogging(t *testing.T) {
+	defer flag.Set("constraint-debug-trace-size", "0")
+	origLog := log
+	defer func() { log = origLog }()
+	var entries []map[string]interface{}
+	log = recordingLogger{entries: &entries}
+
+	handler := makeDenyingHandler(t)
+	debugged := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(deny_all_namespaces), &debugged.Object); err != nil {
+		t.Fatalf("Could not instantiate constraint: %s", err)
+	}
+	debugged.SetName("debugged-namespaces")
+	if err := unstructured.SetNestedField(debugged.Object, true, "spec", "debug"); err != nil {
+		t.Fatalf("Could not set debug: %s", err)
+	}
+	if _, err := handler.opa.AddConstraint(context.Background(), debugged); err != nil {
+		t.Fatalf("Could not add constraint: %s", err)
+	}
+	debugEntries := func() []map[string]interface{} {
+		var debug []map[string]interface{}
+		for _, e := range entries {
+			if e["msg"] == "debug constraint evaluation" {
+				debug = append(debug, e)
+			}
+		}
+		return debug
+	}
+
+	// nothing is logged until the constraint is recorded as loaded by the controller
+	handler.Handle(context.Background(), namespaceRequest("foo"))
+	if debug := debugEntries(); len(debug) != 0 {
+		t.Errorf("debug entries = %v before the debug constraint is recorded", debug)
+	}
+
+	util.RecordDebugConstraint(debugged)
+	defer util.ForgetDebugConstraint(debugged)
+	entries = nil
+	resp := handler.Handle(context.Background(), namespaceRequest("foo"))
+	if resp.Response.Allowed || !strings.Contains(string(resp.Response.Result.Reason), "[denied by deny-all-namespaces]") {
+		t.Errorf("got %+v; want a denial", resp.Response.Result)
+	}
+	debug := debugEntries()
+	if len(debug) != 1 || debug[0]["constraint_name"] != "debugged-namespaces" {
+		t.Fatalf("debug entries = %v; want one for debugged-namespaces only", debug)
+	}
+	if !strings.Contains(fmt.Sprint(debug[0]["object"]), `"name": "foo"`) || len(debug[0]["violations"].([]string)) != 1 {
+		t.Errorf("debug entry = %v; want the object and the violation", debug[0])
+	}
+	if _, ok := debug[0]["trace"]; ok {
+		t.Errorf("debug entry has a trace without --constraint-debug-trace-size")
+	}
+
+	// traces are truncated to --constraint-debug-trace-size
+	flag.Set("constraint-debug-trace-size", "100")
+	entries = nil
+	handler.Handle(context.Background(), namespaceRequest("foo"))
+	debug = debugEntries()
+	if len(debug) != 1 {
+		t.Fatalf("debug entries = %v; want one", debug)
+	}
+	trace, _ := debug[0]["trace"].(string)
+	if trace == "" || len(trace) > 100+len("...") {
+		t.Errorf("trace = %q; want one truncated to 100 bytes", trace)
+	}
+}
@@ -0,0 +1,57 @@
+package webhook
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	opa "github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers"
+	rtypes "github.com/open-policy-agent/frameworks/constraint/pkg/types"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+)
+
+var webhookPrefilterConstraints = flag.Bool("webhook-prefilter-constraints", false, "only evaluate an admission request against the constraints whose kind selectors select the group and kind of its object, or that have a namespace selector, instead of checking every constraint for a match. speeds up reviews when there are many constraints for other kinds, with identical decisions")
+
+// prefiltering reports whether reviews are only evaluated against the candidate constraints
+// listed by h.candidates
+func (h *validationHandler) prefiltering() bool {
+	return h.candidates != nil
+}
+
+// reviewInput returns the input of a query of the target library for review, listing the
+// candidate constraints for the kind of its object if h is prefiltering
+func (h *validationHandler) reviewInput(review *admissionv1beta1.AdmissionRequest) map[string]interface{} {
+	input := map[string]interface{}{"review": review}
+	if h.prefiltering() {
+		input["candidates"] = h.candidates.Candidates(review.Kind.Group, review.Kind.Kind)
+	}
+	return input
+}
+
+// review reviews review against every constraint, or only against its candidate constraints if
+// h is prefiltering
+func (h *validationHandler) review(ctx context.Context, review *admissionv1beta1.AdmissionRequest, tracing bool) (*rtypes.Responses, error) {
+	if h.driver == nil || !h.prefiltering() {
+		return h.opa.Review(ctx, review, opa.Tracing(tracing))
+	}
+	t := &target.K8sValidationTarget{}
+	_, handledReview, err := t.HandleReview(review)
+	if err != nil {
+		return nil, err
+	}
+	r, err := h.driver.Query(ctx, fmt.Sprintf(`hooks["%s"].library.candidate_violation`, t.GetName()), h.reviewInput(handledReview.(*admissionv1beta1.AdmissionRequest)), drivers.Tracing(tracing))
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range r.Results {
+		if err := t.HandleViolation(result); err != nil {
+			return nil, err
+		}
+	}
+	r.Target = t.GetName()
+	responses := rtypes.NewResponses()
+	responses.ByTarget[t.GetName()] = r
+	return responses, nil
+}
@@ -0,0 +1,184 @@
+package webhook
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	atypes "sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+// kindConstraint returns a K8sGoodRego constraint selecting kinds of group, or every kind if
+// kinds is empty
+func kindConstraint(name, group string, kinds ...string) *unstructured.Unstructured {
+	match := map[string]interface{}{}
+	if len(kinds) > 0 {
+		var list []interface{}
+		for _, k := range kinds {
+			list = append(list, k)
+		}
+		match["kinds"] = []interface{}{map[string]interface{}{"apiGroups": []interface{}{group}, "kinds": list}}
+	}
+	u := &unstructured.Unstructured{Object: map[string]interface{}{"spec": map[string]interface{}{"match": match}}}
+	u.SetAPIVersion("constraints.gatekeeper.sh/v1beta1")
+	u.SetKind("K8sGoodRego")
+	u.SetName(name)
+	return u
+}
+
+// loadConstraint adds cnstr to the OPA client of handler, and lists it in index as the
+// constraint controller does
+func loadConstraint(t testing.TB, handler *validationHandler, index *target.ConstraintIndex, cnstr *unstructured.Unstructured) {
+	index.Add(cnstr)
+	if _, err := handler.opa.AddConstraint(context.Background(), cnstr); err != nil {
+		t.Fatalf("Could not add constraint: %s", err)
+	}
+	index.Trim(cnstr)
+}
+
+// makePrefilteringHandler returns the handler of makeDenyingHandler with an index listing its
+// constraint. Reviews are only prefiltered once the index is set as its candidates.
+func makePrefilteringHandler(t testing.TB) (*validationHandler, *target.ConstraintIndex) {
+	handler := makeDenyingHandler(t)
+	cnstr := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(deny_all_namespaces), &cnstr.Object); err != nil {
+		t.Fatalf("Could not instantiate constraint: %s", err)
+	}
+	index := target.NewConstraintIndex()
+	index.Add(cnstr)
+	return handler, index
+}
+
+// addUnrelatedConstraints adds n constraints selecting kinds no request in the tests is for
+func addUnrelatedConstraints(t testing.TB, handler *validationHandler, index *target.ConstraintIndex, n int) {
+	for i := 0; i < n; i++ {
+		loadConstraint(t, handler, index, kindConstraint(fmt.Sprintf("unrelated-%d", i), "example.com", fmt.Sprintf("Kind%d", i)))
+	}
+}
+
+func TestPrefilterConstraints(t *testing.T) {
+	handler, index := makePrefilteringHandler(t)
+	for _, src := range []string{dryrun_all_namespaces, deny_all_namespaces_again, deny_selected_pods} {
+		cnstr := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal([]byte(src), &cnstr.Object); err != nil {
+			t.Fatalf("Could not instantiate constraint: %s", err)
+		}
+		loadConstraint(t, handler, index, cnstr)
+	}
+	for _, cnstr := range []*unstructured.Unstructured{
+		kindConstraint("deny-all-deployments", "*", "Deployment"),
+		kindConstraint("deny-all-apps", "apps", "*"),
+		kindConstraint("deny-everything", ""),
+	} {
+		loadConstraint(t, handler, index, cnstr)
+	}
+	addUnrelatedConstraints(t, handler, index, 50)
+	// changing the kinds of a constraint must not drop it from its old kinds until it is stored
+	loadConstraint(t, handler, index, kindConstraint("moved", "", "ConfigMap"))
+	index.Add(kindConstraint("moved", "", "Service"))
+	// deleted constraints must no longer be candidates
+	loadConstraint(t, handler, index, kindConstraint("deleted", "", "ConfigMap"))
+	deleted := kindConstraint("deleted", "", "ConfigMap")
+	if _, err := handler.opa.RemoveConstraint(context.Background(), deleted); err != nil {
+		t.Fatalf("Could not remove constraint: %s", err)
+	}
+	index.Remove(deleted)
+	for _, key := range index.Candidates("", "ConfigMap") {
+		if key.Name == "deleted" {
+			t.Error("deleted constraint still listed as a candidate")
+		}
+	}
+
+	request := func(group, kind, namespace string) atypes.Request {
+		return atypes.Request{
+			AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+				Kind:      metav1.GroupVersionKind{Group: group, Version: "v1", Kind: kind},
+				Name:      "obj",
+				Namespace: namespace,
+				Operation: admissionv1beta1.Create,
+				Object: runtime.RawExtension{
+					Raw: []byte(fmt.Sprintf(`{"kind": "%s", "metadata": {"name": "obj", "namespace": "%s"}}`, kind, namespace)),
+				},
+			},
+		}
+	}
+	requests := map[string]atypes.Request{
+		"Namespace":              namespaceRequest("foo"),
+		"Pod in uncached ns":     request("", "Pod", "foo"),
+		"Pod":                    request("", "Pod", ""),
+		"Deployment":             request("apps", "Deployment", "foo"),
+		"Deployment of a group":  request("example.com", "Deployment", "foo"),
+		"Unrelated kind":         request("example.com", "Kind7", "foo"),
+		"Kind of another group":  request("example.org", "Kind7", "foo"),
+		"Old kind of constraint": request("", "ConfigMap", "foo"),
+	}
+
+	decision := func(req atypes.Request) (bool, string, []string) {
+		resp, err := handler.reviewRequest(context.Background(), req)
+		if err != nil {
+			t.Fatalf("reviewRequest() err = %s", err)
+		}
+		var names []string
+		for _, r := range resp.Results() {
+			names = append(names, r.Constraint.GetName())
+		}
+		sort.Strings(names)
+		vResp := validationResponse(resp)
+		reason := ""
+		if vResp.Response.Result != nil {
+			reason = string(vResp.Response.Result.Reason)
+		}
+		return vResp.Response.Allowed, reason, names
+	}
+	// the order of the denials of a review depends on the order OPA evaluates constraints in
+	defer flag.Set("deterministic-eval", "false")
+	flag.Set("deterministic-eval", "true")
+	defer flag.Set("webhook-short-circuit", "false")
+	for name, req := range requests {
+		for _, shortCircuit := range []string{"false", "true"} {
+			t.Run(fmt.Sprintf("%s short-circuit=%s", name, shortCircuit), func(t *testing.T) {
+				flag.Set("webhook-short-circuit", shortCircuit)
+				handler.candidates = nil
+				allowed, reason, names := decision(req)
+				handler.candidates = index
+				pAllowed, pReason, pNames := decision(req)
+				if pAllowed != allowed || pReason != reason {
+					t.Errorf("prefiltered decision = %t %q; want %t %q", pAllowed, pReason, allowed, reason)
+				}
+				if !reflect.DeepEqual(pNames, names) {
+					t.Errorf("prefiltered results from %v; want %v", pNames, names)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkPrefilterConstraints(b *testing.B) {
+	for _, n := range []int{100, 1000} {
+		handler, index := makePrefilteringHandler(b)
+		addUnrelatedConstraints(b, handler, index, n)
+		req := namespaceRequest("foo")
+		for _, prefilter := range []bool{false, true} {
+			b.Run(fmt.Sprintf("constraints=%d prefilter=%t", n+1, prefilter), func(b *testing.B) {
+				handler.candidates = nil
+				if prefilter {
+					handler.candidates = index
+				}
+				for i := 0; i < b.N; i++ {
+					if _, err := handler.reviewRequest(context.Background(), req); err != nil {
+						b.Fatalf("reviewRequest() err = %s", err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package webhook
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// defaultRedactPaths are the fields known to hold credentials: the data of Secrets and the
+// environment variables of containers, in pods and in the pod templates of workloads
+const defaultRedactPaths = "Secret:data,Secret:stringData," +
+	"spec.containers.env.value,spec.initContainers.env.value," +
+	"spec.template.spec.containers.env.value,spec.template.spec.initContainers.env.value," +
+	"spec.jobTemplate.spec.template.spec.containers.env.value,spec.jobTemplate.spec.template.spec.initContainers.env.value"
+
+var redactPaths = flag.String("redact-paths", defaultRedactPaths, "comma-separated list of the dot-separated paths of the object fields whose values are replaced with "+redactedValue+" before objects are written to decision logs, OPA dumps or the bundle of /debug/bundle. a path prefixed with Kind.group:, or Kind: for the core group, only applies to objects of that kind. traces of requests with redacted fields are not logged. set to empty to redact nothing")
+
+// redactedValue replaces the redacted values
+const redactedValue = "[REDACTED]"
+
+// redactor removes the values of sensitive fields from objects before they are written out. A
+// nil redactor redacts nothing.
+type redactor struct {
+	// all lists the paths redacted in objects of every kind
+	all [][]string
+	// kinds lists the paths redacted in objects of the given kinds only
+	kinds map[schema.GroupKind][][]string
+}
+
+// newRedactor parses the --redact-paths paths, returning nil if there are none
+func newRedactor(paths string) (*redactor, error) {
+	r := &redactor{kinds: make(map[schema.GroupKind][][]string)}
+	empty := true
+	for _, p := range strings.Split(paths, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		var kind string
+		if i := strings.Index(p, ":"); i >= 0 {
+			kind, p = p[:i], p[i+1:]
+			if kind == "" {
+				return nil, fmt.Errorf("invalid --redact-paths path %q, the kind before : must not be empty", p)
+			}
+		}
+		fields := strings.Split(p, ".")
+		for _, f := range fields {
+			if f == "" {
+				return nil, fmt.Errorf("invalid --redact-paths path %q, fields must not be empty", p)
+			}
+		}
+		if kind == "" {
+			r.all = append(r.all, fields)
+		} else {
+			gk := schema.ParseGroupKind(kind)
+			r.kinds[gk] = append(r.kinds[gk], fields)
+		}
+		empty = false
+	}
+	if empty {
+		return nil, nil
+	}
+	return r, nil
+}
+
+// redact redacts, in place, the objects found in v, a value decoded from JSON. Maps with
+// apiVersion and kind fields are objects, which also finds the objects of lists and of dumps.
+// It returns true if any value was redacted.
+func (r *redactor) redact(v interface{}) bool {
+	redacted := false
+	switch value := v.(type) {
+	case map[string]interface{}:
+		apiVersion, _ := value["apiVersion"].(string)
+		kind, _ := value["kind"].(string)
+		if apiVersion != "" && kind != "" {
+			gk := schema.FromAPIVersionAndKind(apiVersion, kind).GroupKind()
+			for _, path := range append(r.kinds[gk], r.all...) {
+				if redactPath(value, path) {
+					redacted = true
+				}
+			}
+		}
+		for _, child := range value {
+			if r.redact(child) {
+				redacted = true
+			}
+		}
+	case []interface{}:
+		for _, child := range value {
+			if r.redact(child) {
+				redacted = true
+			}
+		}
+	}
+	return redacted
+}
+
+// redactPath replaces the values at path under obj. As for requiredPaths, lists are looked
+// into element by element.
+func redactPath(obj interface{}, path []string) bool {
+	switch value := obj.(type) {
+	case []interface{}:
+		redacted := false
+		for _, elem := range value {
+			if redactPath(elem, path) {
+				redacted = true
+			}
+		}
+		return redacted
+	case map[string]interface{}:
+		child, ok := value[path[0]]
+		if !ok || child == nil {
+			return false
+		}
+		if len(path) > 1 {
+			return redactPath(child, path[1:])
+		}
+		// keep the keys of maps, such as those of a Secret's data, which are not sensitive
+		if m, ok := child.(map[string]interface{}); ok {
+			for k := range m {
+				m[k] = redactedValue
+			}
+			return len(m) != 0
+		}
+		value[path[0]] = redactedValue
+		return true
+	}
+	return false
+}
+
+// redactRaw returns raw with its fields redacted. raw is returned as is if nothing is redacted.
+func (r *redactor) redactRaw(raw runtime.RawExtension) (runtime.RawExtension, bool, error) {
+	if r == nil || raw.Raw == nil {
+		return raw, false, nil
+	}
+	var obj interface{}
+	if err := json.Unmarshal(raw.Raw, &obj); err != nil {
+		return raw, false, err
+	}
+	if !r.redact(obj) {
+		return raw, false, nil
+	}
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return raw, false, err
+	}
+	return runtime.RawExtension{Raw: b}, true, nil
+}
+
+// request returns a copy of req whose objects are redacted, and whether anything was. req is
+// returned as is if nothing is redacted. Objects that cannot be decoded are dropped, as they
+// cannot be told apart from sensitive ones.
+func (r *redactor) request(req *admissionv1beta1.AdmissionRequest) (*admissionv1beta1.AdmissionRequest, bool) {
+	if r == nil {
+		return req, false
+	}
+	object, objectRedacted, err := r.redactRaw(req.Object)
+	if err != nil {
+		object, objectRedacted = runtime.RawExtension{}, true
+	}
+	oldObject, oldObjectRedacted, err := r.redactRaw(req.OldObject)
+	if err != nil {
+		oldObject, oldObjectRedacted = runtime.RawExtension{}, true
+	}
+	if !objectRedacted && !oldObjectRedacted {
+		return req, false
+	}
+	redacted := *req
+	redacted.Object = object
+	redacted.OldObject = oldObject
+	return &redacted, true
+}
+
+// RedactDump redacts the fields selected by --redact-paths in the objects of dump, an OPA dump
+func RedactDump(dump string) (string, error) {
+	r, err := newRedactor(*redactPaths)
+	if err != nil {
+		return "", err
+	}
+	return r.dump(dump)
+}
+
+// dump redacts the objects of an OPA dump, which holds the synced objects
+func (r *redactor) dump(dump string) (string, error) {
+	if r == nil {
+		return dump, nil
+	}
+	var data interface{}
+	if err := json.Unmarshal([]byte(dump), &data); err != nil {
+		return "", err
+	}
+	if !r.redact(data) {
+		return dump, nil
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,53 @@
+package webhook
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+)
+
+var strictAdmissionResponse = flag.Bool("strict-admission-response", false, "check every admission response against the admission API before returning it and log any inconsistency, such as a patch without a patchType. the response is returned unchanged")
+
+// validateResponse returns the ways resp is inconsistent with the admission API, which other
+// webhooks and the API server may reject. The uid is not checked, as Handle always sets it to
+// the uid of the request.
+func validateResponse(resp *admissionv1beta1.AdmissionResponse) []string {
+	if resp == nil {
+		return []string{"response is empty"}
+	}
+	var problems []string
+	if len(resp.Patch) != 0 && resp.PatchType == nil {
+		problems = append(problems, "patch is set without a patchType")
+	}
+	if resp.PatchType != nil {
+		if len(resp.Patch) == 0 {
+			problems = append(problems, "patchType is set without a patch")
+		}
+		if *resp.PatchType != admissionv1beta1.PatchTypeJSONPatch {
+			problems = append(problems, fmt.Sprintf("patchType %q is not %q", *resp.PatchType, admissionv1beta1.PatchTypeJSONPatch))
+		}
+	}
+	if !resp.Allowed && resp.Result == nil {
+		problems = append(problems, "request is denied without a status")
+	}
+	if resp.Allowed && resp.Result != nil && resp.Result.Code >= 400 {
+		problems = append(problems, fmt.Sprintf("request is allowed with error code %d", resp.Result.Code))
+	}
+	return problems
+}
+
+// checkResponse logs the inconsistencies of resp, the response to req
+func checkResponse(req *admissionv1beta1.AdmissionRequest, resp *admissionv1beta1.AdmissionResponse) {
+	problems := validateResponse(resp)
+	if len(problems) == 0 {
+		return
+	}
+	var uid string
+	if req != nil {
+		uid = string(req.UID)
+	}
+	log.Error(errors.New(strings.Join(problems, "; ")), "invalid admission response", "uid", uid)
+}
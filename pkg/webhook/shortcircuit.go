@@ -0,0 +1,264 @@
+package webhook
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers"
+	rtypes "github.com/open-policy-agent/frameworks/constraint/pkg/types"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
+	"github.com/open-policy-agent/gatekeeper/pkg/util"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var webhookShortCircuit = flag.Bool("webhook-short-circuit", false, "evaluate the constraints matching an admission request one at a time and deny it as soon as one returns a deny violation, without evaluating the rest. the denial only references the first denying constraint")
+
+// shortCircuiting returns true if --webhook-short-circuit is set, so reviews stop at the first
+// denial
+func shortCircuiting() bool {
+	return *webhookShortCircuit
+}
+
+// reviewsEachConstraint returns true if a feature in use needs the constraints matching a review
+// to be evaluated one at a time, which takes the driver
+func (h *validationHandler) reviewsEachConstraint() bool {
+	if h.driver == nil {
+		return false
+	}
+	for _, inUse := range []func() bool{
+		// stop at the first denial
+		shortCircuiting,
+		// time the constraints of each template
+		timingTemplates,
+		// skip the constraints of excluded templates
+		h.breaker.excluding,
+		// bound the evaluation time of the constraints of a template
+		h.timeouts.enabled,
+		// log the evaluations of debug constraints
+		util.DebuggingConstraints,
+		// deny or skip the constraints whose referential data is not synced yet
+		h.referential.pending,
+	} {
+		if inUse() {
+			return true
+		}
+	}
+	return false
+}
+
+// reviewedConstraints returns the constraints review is evaluated against, in order of kind
+// and name
+func (h *validationHandler) reviewedConstraints(ctx context.Context, review *admissionv1beta1.AdmissionRequest) ([]*unstructured.Unstructured, error) {
+	rule := "reviewed_constraints"
+	if h.prefiltering() {
+		rule = "reviewed_candidates"
+	}
+	reviewed, err := h.driver.Query(ctx, fmt.Sprintf(`hooks["%s"].library.%s`, (&target.K8sValidationTarget{}).GetName(), rule), h.reviewInput(review))
+	if err != nil {
+		return nil, err
+	}
+	var constraints []*unstructured.Unstructured
+	seen := make(map[string]bool)
+	for _, r := range reviewed.Results {
+		if r.Constraint == nil || seen[constraintName(r.Constraint)] {
+			continue
+		}
+		seen[constraintName(r.Constraint)] = true
+		constraints = append(constraints, r.Constraint)
+	}
+	sort.Slice(constraints, func(i, j int) bool {
+		return constraintName(constraints[i]) < constraintName(constraints[j])
+	})
+	return constraints, nil
+}
+
+// reviewEach reviews the constraints matching review one at a time, in order of kind and
+// name, skipping the constraints of templates excluded by the breaker. If stopAtDeny is set,
+// it stops at the first constraint that denies review. Evaluation errors are recorded with
+// the breaker and returned together once every constraint has been reviewed.
+func (h *validationHandler) reviewEach(ctx context.Context, review *admissionv1beta1.AdmissionRequest, tracing bool, stopAtDeny bool) (*rtypes.Responses, error) {
+	constraints, err := h.reviewedConstraints(ctx, review)
+	if err != nil {
+		return nil, err
+	}
+	return h.reviewConstraints(ctx, review, constraints, tracing, stopAtDeny)
+}
+
+// reviewConstraints reviews constraints, which must match review, one at a time as described
+// for reviewEach
+func (h *validationHandler) reviewConstraints(ctx context.Context, review *admissionv1beta1.AdmissionRequest, constraints []*unstructured.Unstructured, tracing bool, stopAtDeny bool) (*rtypes.Responses, error) {
+	constraints, unsynced := h.referential.withoutUnsynced(review, constraints)
+	cr := newConstraintReview(h, review, tracing, unsynced)
+	defer cr.timer.observe()
+	if stopAtDeny && denies(unsynced) {
+		constraints = nil
+	}
+	for _, c := range constraints {
+		if cr.skipped(c.GetKind()) {
+			continue
+		}
+		results, err := cr.evaluate(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		if stopAtDeny && denies(results) {
+			break
+		}
+	}
+	return cr.responses()
+}
+
+// constraintReview holds the state of a review evaluating its constraints one at a time.
+// Templates are identified by their kind.
+type constraintReview struct {
+	h       *validationHandler
+	target  *target.K8sValidationTarget
+	review  *admissionv1beta1.AdmissionRequest
+	tracing bool
+
+	resp   *rtypes.Response
+	traces []string
+	// evaluated holds the templates whose constraints evaluated without error at least once
+	evaluated map[string]bool
+	// failed holds the first evaluation error of each failing template
+	failed map[string]error
+	// deadlines holds when the constraints of each template with an evaluation timeout must have
+	// finished, from the evaluation of the first one
+	deadlines map[string]time.Time
+	timedOut  map[string]bool
+	timer     templateEvalTimer
+}
+
+// newConstraintReview returns a review of review which starts with the results of the
+// constraints skipped for their unsynced referential data
+func newConstraintReview(h *validationHandler, review *admissionv1beta1.AdmissionRequest, tracing bool, unsynced []*rtypes.Result) *constraintReview {
+	t := &target.K8sValidationTarget{}
+	return &constraintReview{
+		h:         h,
+		target:    t,
+		review:    review,
+		tracing:   tracing,
+		resp:      &rtypes.Response{Target: t.GetName(), Results: unsynced},
+		evaluated: make(map[string]bool),
+		failed:    make(map[string]error),
+		deadlines: make(map[string]time.Time),
+		timedOut:  make(map[string]bool),
+		timer:     templateEvalTimer{},
+	}
+}
+
+// skipped returns true if the constraints of the template of kind are not evaluated, because
+// the breaker excludes it, or because one of its constraints failed or timed out in this review
+func (cr *constraintReview) skipped(kind string) bool {
+	return cr.h.breaker.excluded(kind) || cr.failed[kind] != nil || cr.timedOut[kind]
+}
+
+// evalContext returns the context the constraints of the template of kind are evaluated in,
+// which ends at the deadline of the template if it has an evaluation timeout
+func (cr *constraintReview) evalContext(ctx context.Context, kind string) (context.Context, context.CancelFunc) {
+	timeout := cr.h.timeouts.timeout(kind)
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	if _, ok := cr.deadlines[kind]; !ok {
+		cr.deadlines[kind] = time.Now().Add(timeout)
+	}
+	return context.WithDeadline(ctx, cr.deadlines[kind])
+}
+
+// evaluate evaluates c and returns its results. Evaluation errors and timeouts are recorded
+// against the template of c rather than returned; the error returned ends the review.
+func (cr *constraintReview) evaluate(ctx context.Context, c *unstructured.Unstructured) ([]*rtypes.Result, error) {
+	kind := c.GetKind()
+	input := map[string]interface{}{
+		"review":     cr.review,
+		"constraint": map[string]interface{}{"kind": kind, "name": c.GetName()},
+	}
+	evalCtx, cancel := cr.evalContext(ctx, kind)
+	// the evaluations of debug constraints are logged, traced within --constraint-debug-trace-size
+	debugging := util.Debug(c)
+	traced := cr.tracing || (debugging && *constraintDebugTraceSize > 0)
+	var r *rtypes.Response
+	var err error
+	start := time.Now()
+	cr.timer.time(kind, func() {
+		r, err = cr.h.driver.Query(evalCtx, fmt.Sprintf(`hooks["%s"].library.constraint_violation`, cr.target.GetName()), input, drivers.Tracing(traced))
+	})
+	cancel()
+	if debugging {
+		cr.h.logDebugEvaluation(cr.review, c, r, err, time.Since(start))
+	}
+	if err != nil && ctx.Err() != nil {
+		// running out of time is not the template's fault
+		return nil, err
+	}
+	if err != nil && evalCtx.Err() == context.DeadlineExceeded {
+		templateEvalTimeoutsTotal.WithLabelValues(kind).Inc()
+		cr.timedOut[kind] = true
+		return nil, nil
+	}
+	if err != nil {
+		cr.failed[kind] = err
+		return nil, nil
+	}
+	cr.evaluated[kind] = true
+	if cr.tracing && r.Trace != nil {
+		cr.traces = append(cr.traces, *r.Trace)
+	}
+	cr.resp.Input = r.Input
+	for _, result := range r.Results {
+		if err := cr.target.HandleViolation(result); err != nil {
+			return nil, err
+		}
+	}
+	cr.resp.Results = append(cr.resp.Results, r.Results...)
+	return r.Results, nil
+}
+
+// responses returns the responses of the review, and records the templates that failed or
+// succeeded with the breaker. The error lists the templates that failed, or those that timed
+// out if none failed.
+func (cr *constraintReview) responses() (*rtypes.Responses, error) {
+	if cr.tracing {
+		trace := strings.Join(cr.traces, "\n")
+		cr.resp.Trace = &trace
+	}
+	responses := rtypes.NewResponses()
+	responses.ByTarget[cr.target.GetName()] = cr.resp
+
+	for kind := range cr.evaluated {
+		if cr.failed[kind] == nil && !cr.timedOut[kind] {
+			cr.h.breaker.succeeded(kind)
+		}
+	}
+	if len(cr.failed) == 0 && len(cr.timedOut) == 0 {
+		return responses, nil
+	}
+	if len(cr.failed) == 0 {
+		return responses, newEvalTimeoutError(cr.timedOut)
+	}
+	var msgs []string
+	for kind, err := range cr.failed {
+		cr.h.breaker.failed(kind, err)
+		msgs = append(msgs, fmt.Sprintf("template %s: %s", kind, err))
+	}
+	sort.Strings(msgs)
+	return responses, fmt.Errorf("%s", strings.Join(msgs, "\n"))
+}
+
+// denies resolves the enforcement actions of results, then reports whether any of them denies
+// the request
+func denies(results []*rtypes.Result) bool {
+	util.ResolveEnforcementActions(results)
+	for _, r := range results {
+		if r.EnforcementAction == "deny" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	atypes "sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+func TestReviewsEachConstraint(t *testing.T) {
+	handler := makeDenyingHandler(t)
+	if handler.reviewsEachConstraint() {
+		t.Error("reviewsEachConstraint() = true without a feature in use; want false")
+	}
+	for _, name := range []string{"webhook-short-circuit", "template-eval-metrics"} {
+		t.Run(name, func(t *testing.T) {
+			defer flag.Set(name, "false")
+			flag.Set(name, "true")
+			if !handler.reviewsEachConstraint() {
+				t.Errorf("reviewsEachConstraint() = false with --%s; want true", name)
+			}
+			driver := handler.driver
+			handler.driver = nil
+			defer func() { handler.driver = driver }()
+			if handler.reviewsEachConstraint() {
+				t.Error("reviewsEachConstraint() = true without a driver; want false")
+			}
+		})
+	}
+	t.Run("excluded template", func(t *testing.T) {
+		handler.breaker = newTemplateBreaker(1)
+		defer func() { handler.breaker = nil }()
+		handler.breaker.failed("K8sGoodRego", errors.New("broken"))
+		if !handler.reviewsEachConstraint() {
+			t.Error("reviewsEachConstraint() = false with an excluded template; want true")
+		}
+	})
+}
+
+const (
+	dryrun_all_namespaces = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sGoodRego
+metadata:
+  name: a-dryrun-all-namespaces
+spec:
+  enforcementAction: dryrun
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Namespace"]
+`
+
+	deny_all_namespaces_again = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sGoodRego
+metadata:
+  name: deny-all-namespaces-again
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Namespace"]
+`
+
+	deny_selected_pods = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sGoodRego
+metadata:
+  name: deny-selected-pods
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Pod"]
+    namespaceSelector:
+      matchLabels:
+        selected: "true"
+`
+)
+
+func TestShortCircuit(t *testing.T) {
+	handler := makeDenyingHandler(t)
+	for _, src := range []string{dryrun_all_namespaces, deny_all_namespaces_again, deny_selected_pods} {
+		cnstr := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal([]byte(src), &cnstr.Object); err != nil {
+			t.Fatalf("Could not instantiate constraint: %s", err)
+		}
+		if _, err := handler.opa.AddConstraint(context.Background(), cnstr); err != nil {
+			t.Fatalf("Could not add constraint: %s", err)
+		}
+	}
+	podRequest := func(namespace string) atypes.Request {
+		return atypes.Request{
+			AdmissionRequest: &admissionv1beta1.AdmissionRequest{
+				Kind:      metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"},
+				Name:      "pod",
+				Namespace: namespace,
+				Operation: admissionv1beta1.Create,
+				Object: runtime.RawExtension{
+					Raw: []byte(fmt.Sprintf(`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "pod", "namespace": "%s"}}`, namespace)),
+				},
+			},
+		}
+	}
+
+	tc := []struct {
+		Name            string
+		Request         atypes.Request
+		Allowed         bool
+		FullReason      []string
+		ShortReason     []string
+		NumFull         int
+		NumShort        int
+		ExcludedByShort []string
+	}{
+		{
+			Name:    "Several denying constraints",
+			Request: namespaceRequest("foo"),
+			FullReason: []string{
+				"[denied by deny-all-namespaces] Maybe this will work?",
+				"[denied by deny-all-namespaces-again] Maybe this will work?",
+			},
+			ShortReason:     []string{"[denied by deny-all-namespaces] Maybe this will work?"},
+			ExcludedByShort: []string{"deny-all-namespaces-again", "a-dryrun-all-namespaces"},
+			NumFull:         3,
+			NumShort:        2,
+		},
+		{
+			Name:        "Rejected for an uncached namespace",
+			Request:     podRequest("foo"),
+			FullReason:  []string{"[denied by deny-selected-pods] Namespace is not cached in OPA."},
+			ShortReason: []string{"[denied by deny-selected-pods] Namespace is not cached in OPA."},
+			NumFull:     1,
+			NumShort:    1,
+		},
+		{
+			Name:    "Request matching no constraint",
+			Request: podRequest(""),
+			Allowed: true,
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			defer flag.Set("webhook-short-circuit", "false")
+			for _, shortCircuit := range []bool{false, true} {
+				flag.Set("webhook-short-circuit", fmt.Sprintf("%t", shortCircuit))
+				want, num := tt.FullReason, tt.NumFull
+				if shortCircuit {
+					want, num = tt.ShortReason, tt.NumShort
+				}
+				resp, err := handler.reviewRequest(context.Background(), tt.Request)
+				if err != nil {
+					t.Fatalf("short-circuit=%t: reviewRequest() err = %s", shortCircuit, err)
+				}
+				if got := len(resp.Results()); got != num {
+					t.Errorf("short-circuit=%t: got %d results; want %d", shortCircuit, got, num)
+				}
+				vResp := validationResponse(resp)
+				if vResp.Response.Allowed != tt.Allowed {
+					t.Errorf("short-circuit=%t: allowed = %t; want %t", shortCircuit, vResp.Response.Allowed, tt.Allowed)
+				}
+				if tt.Allowed {
+					continue
+				}
+				reason := string(vResp.Response.Result.Reason)
+				for _, msg := range want {
+					if !strings.Contains(reason, msg) {
+						t.Errorf("short-circuit=%t: reason %q does not contain %q", shortCircuit, reason, msg)
+					}
+				}
+				if shortCircuit {
+					for _, msg := range tt.ExcludedByShort {
+						if strings.Contains(reason, msg) {
+							t.Errorf("short-circuit=%t: reason %q contains %q", shortCircuit, reason, msg)
+						}
+					}
+				}
+			}
+		})
+	}
+}
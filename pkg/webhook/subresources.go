@@ -0,0 +1,27 @@
+package webhook
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+var webhookSubresources = flag.String("webhook-subresources", "", "comma-separated subresources, as resource/subresource, that the webhook is also registered for, such as deployments/scale or */scale. their requests are only reviewed against the constraints that list the subresource in spec.match.subresources. subresources are not sent to the webhook if unspecified")
+
+// webhookResources returns the resources the webhook is registered for: every resource, and the
+// subresources of --webhook-subresources
+func webhookResources() ([]string, error) {
+	resources := []string{"*"}
+	for _, entry := range strings.Split(*webhookSubresources, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid --webhook-subresources entry %q, must be resource/subresource, such as deployments/scale", entry)
+		}
+		resources = append(resources, entry)
+	}
+	return resources, nil
+}
@@ -0,0 +1,50 @@
+package webhook
+
+import (
+	"flag"
+	"time"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+var templateEvalMetrics = flag.Bool("template-eval-metrics", false, "evaluate the constraints matching an admission request one at a time, so that gatekeeper_template_eval_duration_seconds records how long the constraints of each template take. reviews take longer than when all constraints are evaluated in a single query")
+
+// timingTemplates returns true if --template-eval-metrics is set, so the evaluation time of
+// the constraints of each template is recorded
+func timingTemplates() bool {
+	return *templateEvalMetrics
+}
+
+// templateEvalTimer sums how long the constraints of each template take to evaluate during a
+// review. Templates are identified by their kind.
+type templateEvalTimer map[string]time.Duration
+
+// time evaluates fn as part of the template of kind
+func (t templateEvalTimer) time(kind string, fn func()) {
+	start := time.Now()
+	fn()
+	t[kind] += time.Since(start)
+}
+
+// observe records the evaluation time of every template of the review
+func (t templateEvalTimer) observe() {
+	for kind, d := range t {
+		templateEvalDurationSeconds.WithLabelValues(kind).Observe(d.Seconds())
+	}
+}
+
+// templateEvalMetricsEventHandler forgets the evaluation times of deleted templates, so that
+// the kinds of the histogram are bounded by the templates that exist
+func templateEvalMetricsEventHandler() toolscache.ResourceEventHandler {
+	return toolscache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if templ, ok := obj.(*v1beta1.ConstraintTemplate); ok {
+				templateEvalDurationSeconds.DeleteLabelValues(templ.Spec.CRD.Spec.Names.Kind)
+			}
+		},
+	}
+}
@@ -0,0 +1,118 @@
+package webhook
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+// EvalTimeoutAnnotation sets the time the constraints of a template may take to evaluate for an
+// admission request, such as 500ms, overriding --template-eval-timeout
+const EvalTimeoutAnnotation = "templates.gatekeeper.sh/eval-timeout"
+
+var templateEvalTimeout = flag.Duration("template-eval-timeout", 0, "maximum time the constraints of a template may take to evaluate for an admission request, e.g. 500ms, so that a slow template cannot use up --webhook-timeout. the constraints matching a request are then evaluated one at a time. a request is denied if the templates that finished deny it, and fails as if the review errored otherwise. templates can set their own with the "+EvalTimeoutAnnotation+" annotation. unbounded if unspecified or 0")
+
+// templateTimeouts tracks the evaluation timeouts that templates set with EvalTimeoutAnnotation,
+// by the kind of their constraints
+type templateTimeouts struct {
+	mux    sync.RWMutex
+	byKind map[string]time.Duration
+}
+
+func newTemplateTimeouts() *templateTimeouts {
+	return &templateTimeouts{byKind: make(map[string]time.Duration)}
+}
+
+// enabled returns true if the constraints of any template have an evaluation timeout
+func (t *templateTimeouts) enabled() bool {
+	if *templateEvalTimeout > 0 {
+		return true
+	}
+	if t == nil {
+		return false
+	}
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	return len(t.byKind) != 0
+}
+
+// timeout returns the evaluation timeout of the constraints of kind, or 0 if they have none
+func (t *templateTimeouts) timeout(kind string) time.Duration {
+	if t != nil {
+		t.mux.RLock()
+		defer t.mux.RUnlock()
+		if d, ok := t.byKind[kind]; ok {
+			return d
+		}
+	}
+	return *templateEvalTimeout
+}
+
+func (t *templateTimeouts) set(obj interface{}, deleted bool) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	templ, ok := obj.(*v1beta1.ConstraintTemplate)
+	if !ok {
+		return
+	}
+	kind := templ.Spec.CRD.Spec.Names.Kind
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	delete(t.byKind, kind)
+	if deleted {
+		templateEvalTimeoutsTotal.DeleteLabelValues(kind)
+		return
+	}
+	value, ok := templ.GetAnnotations()[EvalTimeoutAnnotation]
+	if !ok {
+		return
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Error(fmt.Errorf("invalid %s annotation %q, must be a positive duration", EvalTimeoutAnnotation, value), "ignoring the evaluation timeout of template", "name", templ.GetName())
+		return
+	}
+	t.byKind[kind] = d
+}
+
+// eventHandler keeps the timeouts up to date with the templates watched by an informer
+func (t *templateTimeouts) eventHandler() toolscache.ResourceEventHandler {
+	return toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { t.set(obj, false) },
+		UpdateFunc: func(_, obj interface{}) { t.set(obj, false) },
+		DeleteFunc: func(obj interface{}) { t.set(obj, true) },
+	}
+}
+
+// evalTimeoutError is returned by reviews in which only the constraints of the templates of
+// kinds ran out of time. The results of the other templates are returned with it.
+type evalTimeoutError struct {
+	// kinds is sorted, as the error may be read concurrently
+	kinds []string
+}
+
+// newEvalTimeoutError returns the error of a review in which the constraints of the templates
+// of timedOut ran out of time
+func newEvalTimeoutError(timedOut map[string]bool) *evalTimeoutError {
+	e := &evalTimeoutError{}
+	for kind := range timedOut {
+		e.kinds = append(e.kinds, kind)
+	}
+	sort.Strings(e.kinds)
+	return e
+}
+
+func (e *evalTimeoutError) Error() string {
+	var msgs []string
+	for _, kind := range e.kinds {
+		msgs = append(msgs, fmt.Sprintf("template %s: evaluation did not finish within its timeout", kind))
+	}
+	return strings.Join(msgs, "\n")
+}
@@ -0,0 +1,172 @@
+package webhook
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+	templv1beta1 "github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/core/templates"
+	"github.com/open-policy-agent/gatekeeper/pkg/apis/config/v1alpha1"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	atypes "sigs.k8s.io/controller-runtime/pkg/webhook/admission/types"
+)
+
+const (
+	slow_rego_template = `
+apiVersion: templates.gatekeeper.sh/v1beta1
+kind: ConstraintTemplate
+metadata:
+  name: k8sslowrego
+spec:
+  crd:
+    spec:
+      names:
+        kind: K8sSlowRego
+        listKind: K8sSlowRegoList
+        plural: k8sslowrego
+        singular: k8sslowrego
+  targets:
+    - target: admission.k8s.gatekeeper.sh
+      rego: |
+        package slowrego
+
+        digits = [0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19]
+
+        violation[{"msg": msg}] {
+          count([1 | digits[_]; digits[_]; digits[_]; digits[_]; digits[_]; digits[_]]) < 0
+          msg := "unreachable"
+        }
+`
+
+	slow_all_namespaces = `
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sSlowRego
+metadata:
+  name: slow-all-namespaces
+spec:
+  match:
+    kinds:
+      - apiGroups: [""]
+        kinds: ["Namespace"]
+`
+)
+
+// addSlowTemplate adds a template whose constraint takes minutes to evaluate for namespaces
+func addSlowTemplate(t *testing.T, handler *validationHandler) *templv1beta1.ConstraintTemplate {
+	templ := &templv1beta1.ConstraintTemplate{}
+	if err := yaml.Unmarshal([]byte(slow_rego_template), templ); err != nil {
+		t.Fatalf("Could not instantiate template: %s", err)
+	}
+	unversioned := &templates.ConstraintTemplate{}
+	if err := runtimeScheme.Convert(templ, unversioned, nil); err != nil {
+		t.Fatalf("Could not convert to unversioned: %v", err)
+	}
+	if _, err := handler.opa.AddTemplate(context.Background(), unversioned); err != nil {
+		t.Fatalf("Could not add template: %s", err)
+	}
+	cnstr := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(slow_all_namespaces), &cnstr.Object); err != nil {
+		t.Fatalf("Could not instantiate constraint: %s", err)
+	}
+	if _, err := handler.opa.AddConstraint(context.Background(), cnstr); err != nil {
+		t.Fatalf("Could not add constraint: %s", err)
+	}
+	return templ
+}
+
+func TestEvalTimeoutError(t *testing.T) {
+	err := newEvalTimeoutError(map[string]bool{"K8sSlow": true, "K8sAlsoSlow": true})
+	want := "template K8sAlsoSlow: evaluation did not finish within its timeout\ntemplate K8sSlow: evaluation did not finish within its timeout"
+	// the error is read by the response and the logs at once
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := err.Error(); got != want {
+				t.Errorf("Error() = %q; want %q", got, want)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestTemplateEvalTimeout(t *testing.T) {
+	defer flag.Set("template-eval-timeout", "0")
+	timeouts := func() float64 {
+		m := &dto.Metric{}
+		if err := templateEvalTimeoutsTotal.WithLabelValues("K8sSlowRego").Write(m); err != nil {
+			t.Fatalf("could not read metric: %s", err)
+		}
+		return m.GetCounter().GetValue()
+	}
+	review := func(handler *validationHandler) (atypes.Response, time.Duration) {
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		resp := handler.Handle(ctx, namespaceRequest("foo"))
+		return resp, time.Since(start)
+	}
+
+	// the fast templates still deny the request
+	flag.Set("template-eval-timeout", "200ms")
+	handler := makeDenyingHandler(t)
+	addSlowTemplate(t, handler)
+	before := timeouts()
+	resp, took := review(handler)
+	if resp.Response.Allowed || resp.Response.Result.Code != http.StatusForbidden || !strings.Contains(string(resp.Response.Result.Reason), "[denied by deny-all-namespaces]") {
+		t.Errorf("got %+v; want a denial by the fast template", resp.Response.Result)
+	}
+	if took > 5*time.Second {
+		t.Errorf("review took %s with a 200ms template timeout", took)
+	}
+	if got := timeouts() - before; got != 1 {
+		t.Errorf("gatekeeper_template_eval_timeouts_total increased by %v; want 1", got)
+	}
+
+	// without a denial, the request fails as the slow template could not decide
+	handler = &validationHandler{injectedConfig: &v1alpha1.Config{}}
+	var err error
+	handler.opa, handler.driver, err = makeOpaClientAndDriver()
+	if err != nil {
+		t.Fatalf("Could not initialize OPA: %s", err)
+	}
+	templ := addSlowTemplate(t, handler)
+	resp, took = review(handler)
+	if resp.Response.Allowed || resp.Response.Result.Code != http.StatusInternalServerError || !strings.Contains(string(resp.Response.Result.Reason), "K8sSlowRego") {
+		t.Errorf("got %+v; want an error naming the slow template", resp.Response.Result)
+	}
+	if took > 5*time.Second {
+		t.Errorf("review took %s with a 200ms template timeout", took)
+	}
+
+	// templates set their own timeout with the annotation
+	flag.Set("template-eval-timeout", "0")
+	handler.timeouts = newTemplateTimeouts()
+	if handler.timeouts.enabled() {
+		t.Fatal("timeouts enabled without the flag or an annotated template")
+	}
+	templ.SetAnnotations(map[string]string{EvalTimeoutAnnotation: "200ms"})
+	handler.timeouts.set(templ, false)
+	if got := handler.timeouts.timeout("K8sSlowRego"); got != 200*time.Millisecond {
+		t.Errorf("timeout = %s; want the 200ms of the annotation", got)
+	}
+	resp, took = review(handler)
+	if resp.Response.Allowed || resp.Response.Result.Code != http.StatusInternalServerError {
+		t.Errorf("got %+v; want an error", resp.Response.Result)
+	}
+	if took > 5*time.Second {
+		t.Errorf("review took %s with a 200ms template timeout", took)
+	}
+	handler.timeouts.set(templ, true)
+	if handler.timeouts.enabled() {
+		t.Error("timeouts still enabled once the annotated template is deleted")
+	}
+}
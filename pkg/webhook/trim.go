@@ -0,0 +1,110 @@
+package webhook
+
+import (
+	"encoding/json"
+	"flag"
+	"sync"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+// UntrimmedReviewAnnotation opts a constraint template out of field trimming. While any
+// template with the annotation set to "true" exists, requests are reviewed untrimmed.
+const UntrimmedReviewAnnotation = "templates.gatekeeper.sh/untrimmed-review"
+
+var (
+	trimManagedFields = flag.Bool("trim-managed-fields", false, "remove metadata.managedFields from objects before they are reviewed, unless a template is annotated with "+UntrimmedReviewAnnotation+"=true")
+	trimStatus        = flag.Bool("trim-status", false, "remove status from objects before they are reviewed, unless a template is annotated with "+UntrimmedReviewAnnotation+"=true")
+)
+
+// trimEnabled returns true if any field is trimmed before review
+func trimEnabled() bool {
+	return *trimManagedFields || *trimStatus
+}
+
+// trimOptOuts tracks the templates that opted out of field trimming
+type trimOptOuts struct {
+	mux       sync.RWMutex
+	templates map[string]bool
+}
+
+func newTrimOptOuts() *trimOptOuts {
+	return &trimOptOuts{templates: make(map[string]bool)}
+}
+
+// Any returns true if any template opted out of field trimming
+func (o *trimOptOuts) Any() bool {
+	o.mux.RLock()
+	defer o.mux.RUnlock()
+	return len(o.templates) != 0
+}
+
+func (o *trimOptOuts) set(obj interface{}, deleted bool) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		log.Error(err, "unable to read template for field trimming")
+		return
+	}
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	if !deleted && accessor.GetAnnotations()[UntrimmedReviewAnnotation] == "true" {
+		o.templates[accessor.GetName()] = true
+	} else {
+		delete(o.templates, accessor.GetName())
+	}
+}
+
+// eventHandler keeps the opt outs up to date with the templates watched by an informer
+func (o *trimOptOuts) eventHandler() toolscache.ResourceEventHandler {
+	return toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { o.set(obj, false) },
+		UpdateFunc: func(_, obj interface{}) { o.set(obj, false) },
+		DeleteFunc: func(obj interface{}) { o.set(obj, true) },
+	}
+}
+
+// trimRequest returns a copy of req whose objects no longer carry the fields selected for
+// trimming. req is returned as is if nothing is trimmed.
+func (h *validationHandler) trimRequest(req *admissionv1beta1.AdmissionRequest) (*admissionv1beta1.AdmissionRequest, error) {
+	if !trimEnabled() || (h.trimOptOuts != nil && h.trimOptOuts.Any()) {
+		return req, nil
+	}
+	trimmed := *req
+	var err error
+	if trimmed.Object, err = trimObject(req.Object); err != nil {
+		return nil, err
+	}
+	if trimmed.OldObject, err = trimObject(req.OldObject); err != nil {
+		return nil, err
+	}
+	return &trimmed, nil
+}
+
+func trimObject(raw runtime.RawExtension) (runtime.RawExtension, error) {
+	if raw.Raw == nil {
+		return raw, nil
+	}
+	obj := make(map[string]interface{})
+	if err := json.Unmarshal(raw.Raw, &obj); err != nil {
+		return raw, err
+	}
+	if *trimManagedFields {
+		if metadata, ok := obj["metadata"].(map[string]interface{}); ok {
+			delete(metadata, "managedFields")
+		}
+	}
+	if *trimStatus {
+		delete(obj, "status")
+	}
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return raw, err
+	}
+	return runtime.RawExtension{Raw: b}, nil
+}
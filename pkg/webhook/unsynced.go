@@ -0,0 +1,159 @@
+package webhook
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
+	rtypes "github.com/open-policy-agent/frameworks/constraint/pkg/types"
+	"github.com/open-policy-agent/gatekeeper/pkg/controller/config"
+	syncc "github.com/open-policy-agent/gatekeeper/pkg/controller/sync"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+const (
+	// unsyncedEvaluate evaluates referential constraints against the data synced so far
+	unsyncedEvaluate = "evaluate"
+	// unsyncedDeny denies the requests matched by referential constraints whose data is not synced
+	unsyncedDeny = "deny"
+	// unsyncedSkip leaves referential constraints whose data is not synced out of reviews
+	unsyncedSkip = "skip"
+)
+
+var referentialUnsyncedAction = flag.String("referential-unsynced-action", unsyncedEvaluate, "what admission does with the constraints of templates whose "+config.SyncDependenciesAnnotation+" kinds are not synced into OPA yet, which would evaluate against a partial data.inventory: evaluate them regardless, deny the requests they match, or skip them with a warning. defaulted to evaluate if unspecified")
+
+// validateUnsyncedAction returns an error if action is not a known --referential-unsynced-action
+func validateUnsyncedAction(action string) error {
+	switch action {
+	case unsyncedEvaluate, unsyncedDeny, unsyncedSkip:
+		return nil
+	}
+	return fmt.Errorf("invalid --referential-unsynced-action %q, must be %s, %s or %s", action, unsyncedEvaluate, unsyncedDeny, unsyncedSkip)
+}
+
+// referentialTemplates tracks the kinds that templates declare with
+// config.SyncDependenciesAnnotation, by the kind of their constraints, so that their constraints
+// are not evaluated before those kinds are synced
+type referentialTemplates struct {
+	action string
+	// synced returns whether every object of a kind has been synced into OPA
+	synced func(schema.GroupVersionKind) bool
+	mux    sync.RWMutex
+	byKind map[string][]schema.GroupVersionKind
+}
+
+func newReferentialTemplates(action string) *referentialTemplates {
+	return &referentialTemplates{action: action, synced: syncc.Synced, byKind: make(map[string][]schema.GroupVersionKind)}
+}
+
+func (r *referentialTemplates) set(obj interface{}, deleted bool) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	templ, ok := obj.(*v1beta1.ConstraintTemplate)
+	if !ok {
+		return
+	}
+	kind := templ.Spec.CRD.Spec.Names.Kind
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	delete(r.byKind, kind)
+	if deleted {
+		return
+	}
+	// the config controller logs invalid annotations, which it does not sync either
+	gvks, err := config.SyncDependencies(templ)
+	if err != nil || len(gvks) == 0 {
+		return
+	}
+	r.byKind[kind] = gvks
+}
+
+// eventHandler keeps the dependencies up to date with the templates watched by an informer
+func (r *referentialTemplates) eventHandler() toolscache.ResourceEventHandler {
+	return toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { r.set(obj, false) },
+		UpdateFunc: func(_, obj interface{}) { r.set(obj, false) },
+		DeleteFunc: func(obj interface{}) { r.set(obj, true) },
+	}
+}
+
+// unsynced returns the kinds the constraints of kind depend on that are not synced yet
+func (r *referentialTemplates) unsynced(kind string) []string {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	var missing []string
+	for _, gvk := range r.byKind[kind] {
+		if !r.synced(gvk) {
+			missing = append(missing, gvk.GroupVersion().String()+" "+gvk.Kind)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// pending returns true if the constraints of a template depend on kinds that are not synced
+// yet, in which case the constraints matching a request are reviewed one at a time
+func (r *referentialTemplates) pending() bool {
+	if r == nil {
+		return false
+	}
+	r.mux.RLock()
+	kinds := make([]string, 0, len(r.byKind))
+	for kind := range r.byKind {
+		kinds = append(kinds, kind)
+	}
+	r.mux.RUnlock()
+	for _, kind := range kinds {
+		if len(r.unsynced(kind)) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutUnsynced returns the constraints that can be evaluated for review, leaving out those
+// whose templates depend on kinds that are not synced yet. With --referential-unsynced-action
+// deny, a violation of each constraint left out is returned in their place.
+func (r *referentialTemplates) withoutUnsynced(review *admissionv1beta1.AdmissionRequest, constraints []*unstructured.Unstructured) ([]*unstructured.Unstructured, []*rtypes.Result) {
+	if r == nil {
+		return constraints, nil
+	}
+	var kept []*unstructured.Unstructured
+	var results []*rtypes.Result
+	for _, c := range constraints {
+		missing := r.unsynced(c.GetKind())
+		if len(missing) == 0 {
+			kept = append(kept, c)
+			continue
+		}
+		msg := fmt.Sprintf("the data of %s the constraint references is not synced yet", strings.Join(missing, ", "))
+		if r.action == unsyncedSkip {
+			log.Error(errors.New(msg), "skipping constraint whose data is not synced yet", "constraint_kind", c.GetKind(), "constraint_name", c.GetName(), "kind", review.Kind, "namespace", review.Namespace, "name", review.Name)
+			continue
+		}
+		action, found, err := unstructured.NestedString(c.Object, "spec", "enforcementAction")
+		if err != nil || !found || action == "" {
+			action = "deny"
+		}
+		results = append(results, &rtypes.Result{
+			Msg:               msg,
+			Constraint:        c,
+			EnforcementAction: action,
+			// the violated object, as violationThreshold counts it
+			Review: map[string]interface{}{
+				"kind":      map[string]interface{}{"group": review.Kind.Group, "version": review.Kind.Version, "kind": review.Kind.Kind},
+				"namespace": review.Namespace,
+				"name":      review.Name,
+			},
+		})
+	}
+	return kept, results
+}
@@ -0,0 +1,70 @@
+package webhook
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	templv1beta1 "github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
+	"github.com/open-policy-agent/gatekeeper/pkg/controller/config"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestReferentialUnsyncedAction(t *testing.T) {
+	templ := &templv1beta1.ConstraintTemplate{}
+	if err := yaml.Unmarshal([]byte(good_rego_template), templ); err != nil {
+		t.Fatalf("Could not instantiate template: %s", err)
+	}
+	templ.SetAnnotations(map[string]string{config.SyncDependenciesAnnotation: `[{"group": "", "version": "v1", "kind": "Namespace"}]`})
+
+	tc := []struct {
+		Name    string
+		Action  string
+		Synced  bool
+		Allowed bool
+		Reason  string
+	}{
+		{Name: "evaluated against partial data", Action: unsyncedEvaluate, Synced: false, Reason: "[denied by deny-all-namespaces] Maybe this will work?"},
+		{Name: "denied until synced", Action: unsyncedDeny, Synced: false, Reason: "[denied by deny-all-namespaces] the data of v1 Namespace the constraint references is not synced yet"},
+		{Name: "evaluated once synced", Action: unsyncedDeny, Synced: true, Reason: "[denied by deny-all-namespaces] Maybe this will work?"},
+		{Name: "skipped until synced", Action: unsyncedSkip, Synced: false, Allowed: true},
+		{Name: "not skipped once synced", Action: unsyncedSkip, Synced: true, Reason: "[denied by deny-all-namespaces] Maybe this will work?"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.Name, func(t *testing.T) {
+			handler := makeDenyingHandler(t)
+			if tt.Action != unsyncedEvaluate {
+				handler.referential = newReferentialTemplates(tt.Action)
+				handler.referential.synced = func(gvk schema.GroupVersionKind) bool {
+					if gvk != (schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}) {
+						t.Errorf("synced() called for %s; want the declared dependency", gvk)
+					}
+					return tt.Synced
+				}
+				handler.referential.set(templ, false)
+				if pending := handler.referential.pending(); pending == tt.Synced {
+					t.Errorf("pending() = %t with the dependency synced %t", pending, tt.Synced)
+				}
+			}
+			resp := handler.Handle(context.Background(), namespaceRequest("foo"))
+			if resp.Response.Allowed != tt.Allowed {
+				t.Fatalf("allowed = %t; want %t, result %+v", resp.Response.Allowed, tt.Allowed, resp.Response.Result)
+			}
+			if !tt.Allowed && string(resp.Response.Result.Reason) != tt.Reason {
+				t.Errorf("reason = %q; want %q", resp.Response.Result.Reason, tt.Reason)
+			}
+		})
+	}
+
+	// templates without dependencies, or deleted, are not referential
+	referential := newReferentialTemplates(unsyncedDeny)
+	referential.synced = func(schema.GroupVersionKind) bool { return false }
+	referential.set(templ, false)
+	referential.set(templ, true)
+	if referential.pending() {
+		t.Error("pending() = true once the referential template is deleted")
+	}
+	if err := validateUnsyncedAction("fail"); err == nil {
+		t.Error("validateUnsyncedAction() accepted an unknown action")
+	}
+}
@@ -17,19 +17,21 @@ package webhook
 
 import (
 	"github.com/open-policy-agent/frameworks/constraint/pkg/client"
+	"github.com/open-policy-agent/frameworks/constraint/pkg/client/drivers"
+	"github.com/open-policy-agent/gatekeeper/pkg/target"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
 // AddToManagerFuncs is a list of functions to add all Controllers to the Manager
-var AddToManagerFuncs []func(manager.Manager, *client.Client) error
+var AddToManagerFuncs []func(manager.Manager, *client.Client, drivers.Driver, *target.ConstraintIndex) error
 
 // AddToManager adds all Controllers to the Manager
 // +kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=mutatingwebhookconfigurations;validatingwebhookconfigurations,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
-func AddToManager(m manager.Manager, opa *client.Client) error {
+func AddToManager(m manager.Manager, opa *client.Client, driver drivers.Driver, index *target.ConstraintIndex) error {
 	for _, f := range AddToManagerFuncs {
-		if err := f(m, opa); err != nil {
+		if err := f(m, opa, driver, index); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,20 @@
+package version
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "gatekeeper_build_info",
+	Help: "Always 1, labeled by the version of the running Gatekeeper build",
+}, []string{"version", "vcs", "timestamp", "frameworks_version"})
+
+func init() {
+	metrics.Registry.MustRegister(buildInfo)
+}
+
+// RecordBuildInfo sets the gatekeeper_build_info metric for the running build
+func RecordBuildInfo() {
+	buildInfo.WithLabelValues(Version, Vcs, Timestamp, FrameworksVersion).Set(1)
+}
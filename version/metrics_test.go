@@ -0,0 +1,48 @@
+package version
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+func TestBuildInfoMetric(t *testing.T) {
+	defer func(v, vcs string) { Version, Vcs = v, vcs }(Version, Vcs)
+	Version, Vcs = "v0.0.0-test", "abc123"
+	RecordBuildInfo()
+
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("Could not gather metrics: %s", err)
+	}
+	for _, f := range families {
+		if f.GetName() != "gatekeeper_build_info" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			labels := make(map[string]string)
+			var keys []string
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+				keys = append(keys, l.GetName())
+			}
+			if labels["version"] != Version {
+				continue
+			}
+			sort.Strings(keys)
+			if want := []string{"frameworks_version", "timestamp", "vcs", "version"}; !reflect.DeepEqual(keys, want) {
+				t.Errorf("label keys = %v; want %v", keys, want)
+			}
+			if labels["vcs"] != Vcs {
+				t.Errorf("vcs = %s; want %s", labels["vcs"], Vcs)
+			}
+			if got := m.GetGauge().GetValue(); got != 1 {
+				t.Errorf("value = %v; want 1", got)
+			}
+			return
+		}
+	}
+	t.Errorf("gatekeeper_build_info is not registered for version %s", Version)
+}
@@ -0,0 +1,15 @@
+// Package version contains version information that is set at build time.
+package version
+
+// Version is the canonical version of Gatekeeper.
+var Version = ""
+
+// Additional version information that identifies the build of running instances of
+// Gatekeeper.
+var (
+	Vcs       = ""
+	Timestamp = ""
+	Hostname  = ""
+	// FrameworksVersion is the revision of the constraint framework Gatekeeper was built with
+	FrameworksVersion = ""
+)